@@ -12,9 +12,12 @@ require (
 	github.com/gookit/color v1.4.2
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
 	github.com/notedit/rtmp v0.0.2
+	github.com/pion/rtcp v1.2.4
 	github.com/pion/rtp v1.6.2
+	github.com/pion/sdp/v3 v3.0.2
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	golang.org/x/net v0.0.0-20210610132358-84b48f89b13b
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/yaml.v2 v2.2.8
 )
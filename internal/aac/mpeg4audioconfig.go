@@ -0,0 +1,55 @@
+package aac
+
+import (
+	"fmt"
+)
+
+// EncodeMPEG4AudioConfig encodes the MPEG-4 audio configuration of an AAC-LC
+// stream, that is needed to describe the stream in a track.
+func EncodeMPEG4AudioConfig(sampleRate int, channelCount int) ([]byte, error) {
+	var sampleRateIndex uint16
+	switch sampleRate {
+	case 96000:
+		sampleRateIndex = 0
+	case 88200:
+		sampleRateIndex = 1
+	case 64000:
+		sampleRateIndex = 2
+	case 48000:
+		sampleRateIndex = 3
+	case 44100:
+		sampleRateIndex = 4
+	case 32000:
+		sampleRateIndex = 5
+	case 24000:
+		sampleRateIndex = 6
+	case 22050:
+		sampleRateIndex = 7
+	case 16000:
+		sampleRateIndex = 8
+	case 12000:
+		sampleRateIndex = 9
+	case 11025:
+		sampleRateIndex = 10
+	case 8000:
+		sampleRateIndex = 11
+	case 7350:
+		sampleRateIndex = 12
+	default:
+		return nil, fmt.Errorf("invalid sample rate: %v", sampleRate)
+	}
+
+	var channelConf uint16
+	switch channelCount {
+	case 1, 2, 3, 4, 5, 6:
+		channelConf = uint16(channelCount)
+	case 8:
+		channelConf = 7
+	default:
+		return nil, fmt.Errorf("invalid channel count: %v", channelCount)
+	}
+
+	// object type (5 bits), sample rate index (4 bits), channel configuration (4 bits)
+	v := uint16(2)<<11 | sampleRateIndex<<7 | channelConf<<3
+	return []byte{byte(v >> 8), byte(v)}, nil
+}
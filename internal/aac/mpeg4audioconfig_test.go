@@ -0,0 +1,33 @@
+package aac
+
+import (
+	"testing"
+
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeMPEG4AudioConfig(t *testing.T) {
+	for _, ca := range []struct {
+		name         string
+		sampleRate   int
+		channelCount int
+		byts         []byte
+	}{
+		{"44100 stereo", 44100, 2, []byte{0x12, 0x10}},
+		{"48000 mono", 48000, 1, []byte{0x11, 0x88}},
+		{"48000 7.1", 48000, 8, []byte{0x11, 0xb8}},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			byts, err := EncodeMPEG4AudioConfig(ca.sampleRate, ca.channelCount)
+			require.NoError(t, err)
+			require.Equal(t, ca.byts, byts)
+
+			var conf rtpaac.MPEG4AudioConfig
+			err = conf.Decode(byts)
+			require.NoError(t, err)
+			require.Equal(t, ca.sampleRate, conf.SampleRate)
+			require.Equal(t, ca.channelCount, conf.ChannelCount)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Package av1 contains utilities to work with the AV1 codec.
+package av1
+
+import (
+	"fmt"
+)
+
+// OBUType is the type of an OBU.
+type OBUType uint8
+
+// standard OBU types.
+const (
+	OBUTypeSequenceHeader       OBUType = 1
+	OBUTypeTemporalDelimiter    OBUType = 2
+	OBUTypeFrameHeader          OBUType = 3
+	OBUTypeTileGroup            OBUType = 4
+	OBUTypeMetadata             OBUType = 5
+	OBUTypeFrame                OBUType = 6
+	OBUTypeRedundantFrameHeader OBUType = 7
+	OBUTypeTileList             OBUType = 8
+	OBUTypePadding              OBUType = 15
+)
+
+// OBUTypeOf returns the type of an OBU.
+func OBUTypeOf(obu []byte) OBUType {
+	return OBUType((obu[0] >> 3) & 0x0F)
+}
+
+func readLEB128(byts []byte) (uint64, int, error) {
+	var v uint64
+
+	for i := 0; i < 8; i++ {
+		if i >= len(byts) {
+			return 0, 0, fmt.Errorf("not enough bytes")
+		}
+
+		v |= uint64(byts[i]&0x7F) << (7 * i)
+
+		if (byts[i] & 0x80) == 0 {
+			return v, i + 1, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("LEB128 value is too long")
+}
+
+func leb128Size(v int) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func appendLEB128(buf []byte, v int) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v&0x7F)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+// DecodeOBUs decodes OBUs in the low overhead bitstream format, that is used
+// by RTMP and MP4. Returned OBUs don't have the size field.
+func DecodeOBUs(byts []byte) ([][]byte, error) {
+	var ret [][]byte
+
+	for len(byts) > 0 {
+		hasExtension := (byts[0] & 0x04) != 0
+		hasSize := (byts[0] & 0x02) != 0
+
+		headerLen := 1
+		if hasExtension {
+			headerLen = 2
+		}
+
+		if len(byts) < headerLen {
+			return nil, fmt.Errorf("invalid OBU header")
+		}
+
+		header := byts[:headerLen]
+		byts = byts[headerLen:]
+
+		size := len(byts)
+		if hasSize {
+			v, n, err := readLEB128(byts)
+			if err != nil {
+				return nil, fmt.Errorf("invalid OBU size: %v", err)
+			}
+			byts = byts[n:]
+
+			if v > uint64(len(byts)) {
+				return nil, fmt.Errorf("invalid OBU size")
+			}
+			size = int(v)
+		}
+
+		obu := make([]byte, headerLen+size)
+		copy(obu, header)
+		obu[0] &^= 0x02
+		copy(obu[headerLen:], byts[:size])
+		byts = byts[size:]
+
+		ret = append(ret, obu)
+	}
+
+	if ret == nil {
+		return nil, fmt.Errorf("no OBUs found")
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,55 @@
+package av1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeOBUs(t *testing.T) {
+	obus, err := DecodeOBUs([]byte{
+		0x12, 0x00, // temporal delimiter
+		0x0a, 0x03, 0x01, 0x02, 0x03, // sequence header
+		0x36, 0x00, 0x02, 0x04, 0x05, // frame with extension
+		0x30, 0x06, 0x07, // frame without size
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{
+		{0x10},
+		{0x08, 0x01, 0x02, 0x03},
+		{0x34, 0x00, 0x04, 0x05},
+		{0x30, 0x06, 0x07},
+	}, obus)
+	require.Equal(t, OBUTypeTemporalDelimiter, OBUTypeOf(obus[0]))
+	require.Equal(t, OBUTypeSequenceHeader, OBUTypeOf(obus[1]))
+	require.Equal(t, OBUTypeFrame, OBUTypeOf(obus[2]))
+}
+
+func TestDecodeOBUsError(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		byts []byte
+	}{
+		{
+			"empty",
+			[]byte{},
+		},
+		{
+			"missing extension",
+			[]byte{0x36},
+		},
+		{
+			"invalid size",
+			[]byte{0x0a, 0x05, 0x01},
+		},
+		{
+			"truncated size",
+			[]byte{0x0a, 0x80},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := DecodeOBUs(ca.byts)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package av1
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	rtpVersion        = 0x02
+	rtpPayloadMaxSize = 1460  // 1500 (mtu) - 20 (ip header) - 8 (udp header) - 12 (rtp header)
+	rtpClockRate      = 90000 // AV1 always uses 90khz
+)
+
+// Encoder is a RTP/AV1 encoder, that implements the
+// "RTP Payload Format For AV1" specification.
+type Encoder struct {
+	payloadType    uint8
+	sequenceNumber uint16
+	ssrc           uint32
+	initialTs      uint32
+}
+
+// NewEncoder allocates an Encoder.
+func NewEncoder(payloadType uint8,
+	sequenceNumber *uint16,
+	ssrc *uint32,
+	initialTs *uint32) *Encoder {
+	return &Encoder{
+		payloadType: payloadType,
+		sequenceNumber: func() uint16 {
+			if sequenceNumber != nil {
+				return *sequenceNumber
+			}
+			return uint16(rand.Uint32())
+		}(),
+		ssrc: func() uint32 {
+			if ssrc != nil {
+				return *ssrc
+			}
+			return rand.Uint32()
+		}(),
+		initialTs: func() uint32 {
+			if initialTs != nil {
+				return *initialTs
+			}
+			return rand.Uint32()
+		}(),
+	}
+}
+
+func (e *Encoder) encodeTimestamp(ts time.Duration) uint32 {
+	return e.initialTs + uint32(ts.Seconds()*rtpClockRate)
+}
+
+// Encode encodes the OBUs of a temporal unit into RTP/AV1 packets.
+// OBUs must not have the size field.
+// It returns the encoded packets.
+func (e *Encoder) Encode(obus [][]byte, pts time.Duration) ([][]byte, error) {
+	var elems [][]byte
+	newSequence := false
+
+	for _, obu := range obus {
+		switch OBUTypeOf(obu) {
+		// temporal delimiters and tile lists must not be sent
+		case OBUTypeTemporalDelimiter, OBUTypeTileList:
+			continue
+
+		case OBUTypeSequenceHeader:
+			newSequence = true
+		}
+
+		elems = append(elems, obu)
+	}
+
+	if elems == nil {
+		return nil, nil
+	}
+
+	var rets [][]byte
+	continued := false
+	payload := []byte{0}
+
+	flush := func(fragmented bool, marker bool) error {
+		// aggregation header
+		if continued {
+			payload[0] |= 0x80
+		}
+		if fragmented {
+			payload[0] |= 0x40
+		}
+		if newSequence && rets == nil {
+			payload[0] |= 0x08
+		}
+
+		frame, err := (&rtp.Packet{
+			Header: rtp.Header{
+				Version:        rtpVersion,
+				PayloadType:    e.payloadType,
+				SequenceNumber: e.sequenceNumber,
+				Timestamp:      e.encodeTimestamp(pts),
+				SSRC:           e.ssrc,
+				Marker:         marker,
+			},
+			Payload: payload,
+		}).Marshal()
+		if err != nil {
+			return err
+		}
+
+		e.sequenceNumber++
+		rets = append(rets, frame)
+
+		continued = fragmented
+		payload = []byte{0}
+		return nil
+	}
+
+	for len(elems) > 0 {
+		elem := elems[0]
+
+		// each element is preceded by its size
+		avail := rtpPayloadMaxSize - len(payload)
+		n := avail - leb128Size(avail)
+		if n > len(elem) {
+			n = len(elem)
+		}
+
+		if n <= 0 {
+			err := flush(false, false)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		payload = appendLEB128(payload, n)
+		payload = append(payload, elem[:n]...)
+
+		if n < len(elem) {
+			elems[0] = elem[n:]
+			err := flush(true, false)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		elems = elems[1:]
+	}
+
+	// marker is used to indicate the last packet of the temporal unit
+	err := flush(false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return rets, nil
+}
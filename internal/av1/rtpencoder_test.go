@@ -0,0 +1,85 @@
+package av1
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// depacketize rebuilds OBUs from RTP/AV1 payloads whose elements
+// are all preceded by their size.
+func depacketize(t *testing.T, payloads [][]byte) [][]byte {
+	var obus [][]byte
+	var fragment []byte
+
+	for _, payload := range payloads {
+		z := (payload[0] & 0x80) != 0
+		y := (payload[0] & 0x40) != 0
+		payload = payload[1:]
+
+		first := true
+		for len(payload) > 0 {
+			size, n, err := readLEB128(payload)
+			require.NoError(t, err)
+			payload = payload[n:]
+			elem := payload[:size]
+			payload = payload[size:]
+
+			if first && z {
+				fragment = append(fragment, elem...)
+			} else {
+				fragment = append([]byte(nil), elem...)
+			}
+			first = false
+
+			if len(payload) > 0 || !y {
+				obus = append(obus, fragment)
+				fragment = nil
+			}
+		}
+	}
+
+	return obus
+}
+
+func TestEncoder(t *testing.T) {
+	sequenceNumber := uint16(0x44ed)
+	ssrc := uint32(0x9dbb7812)
+	initialTs := uint32(0x88776655)
+	e := NewEncoder(96, &sequenceNumber, &ssrc, &initialTs)
+
+	obus := [][]byte{
+		{0x10},
+		{0x08, 0x01, 0x02, 0x03},
+		append([]byte{0x30}, bytes.Repeat([]byte{0x01, 0x02, 0x03, 0x04}, 1000)...),
+		{0x28, 0x05},
+	}
+
+	frames, err := e.Encode(obus, 2*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(frames))
+
+	var payloads [][]byte
+
+	for i, frame := range frames {
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(frame)
+		require.NoError(t, err)
+		require.Equal(t, sequenceNumber+uint16(i), pkt.SequenceNumber)
+		require.Equal(t, initialTs+2*90000, pkt.Timestamp)
+		require.Equal(t, i == len(frames)-1, pkt.Marker)
+		require.LessOrEqual(t, len(pkt.Payload), rtpPayloadMaxSize)
+		payloads = append(payloads, pkt.Payload)
+	}
+
+	// N is set in the first packet only
+	require.Equal(t, byte(0x08|0x40), payloads[0][0])
+	require.Equal(t, byte(0x80|0x40), payloads[1][0])
+	require.Equal(t, byte(0x80), payloads[2][0])
+
+	// the temporal delimiter is removed
+	require.Equal(t, obus[1:], depacketize(t, payloads))
+}
@@ -0,0 +1,51 @@
+package av1
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aler9/gortsplib"
+	psdp "github.com/pion/sdp/v3"
+)
+
+// IsTrack checks whether a track is an AV1 track.
+func IsTrack(t *gortsplib.Track) bool {
+	if t.Media.MediaName.Media != "video" {
+		return false
+	}
+
+	v, ok := t.Media.Attribute("rtpmap")
+	if !ok {
+		return false
+	}
+
+	vals := strings.Split(v, " ")
+	if len(vals) != 2 {
+		return false
+	}
+
+	return strings.ToUpper(vals[1]) == "AV1/90000"
+}
+
+// NewTrack initializes an AV1 track.
+// Sequence headers are sent in band, therefore the SDP doesn't contain
+// codec parameters.
+func NewTrack(payloadType uint8) *gortsplib.Track {
+	typ := strconv.FormatInt(int64(payloadType), 10)
+
+	return &gortsplib.Track{
+		Media: &psdp.MediaDescription{
+			MediaName: psdp.MediaName{
+				Media:   "video",
+				Protos:  []string{"RTP", "AVP"},
+				Formats: []string{typ},
+			},
+			Attributes: []psdp.Attribute{
+				{
+					Key:   "rtpmap",
+					Value: typ + " AV1/90000",
+				},
+			},
+		},
+	}
+}
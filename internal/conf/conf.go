@@ -2,17 +2,28 @@ package conf
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/headers"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/nacl/secretbox"
 	"gopkg.in/yaml.v2"
 
 	"github.com/aler9/rtsp-simple-server/internal/confenv"
+	"github.com/aler9/rtsp-simple-server/internal/hls"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/proxydialer"
 )
 
 // Encryption is an encryption policy.
@@ -35,6 +46,76 @@ const (
 	ProtocolTCP
 )
 
+// RTMPListener is an additional listener of the RTMP server.
+type RTMPListener struct {
+	Address string
+
+	// whether credentials and stream keys of clients are not checked.
+	NoAuth bool
+}
+
+func parseRTMPListener(v string) (RTMPListener, error) {
+	l := RTMPListener{Address: v}
+
+	if i := strings.LastIndex(v, "/"); i >= 0 {
+		l.Address = v[:i]
+
+		switch v[i+1:] {
+		case "auth":
+
+		case "noauth":
+			l.NoAuth = true
+
+		default:
+			return RTMPListener{}, fmt.Errorf("unsupported RTMP listener policy: '%s'", v[i+1:])
+		}
+	}
+
+	_, _, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return RTMPListener{}, fmt.Errorf("invalid RTMP listener address: '%s'", l.Address)
+	}
+
+	return l, nil
+}
+
+// PortRange is a range of UDP ports.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+func parsePortRange(v string) (*PortRange, error) {
+	parts := strings.Split(v, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("range must be in format min-max")
+	}
+
+	min, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", parts[0])
+	}
+
+	max, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", parts[1])
+	}
+
+	r := &PortRange{Min: int(min), Max: int(max)}
+
+	// RTP ports must be even and followed by the RTCP port
+	firstRTP := r.Min + (r.Min % 2)
+	if r.Min == 0 || (firstRTP+1) > r.Max {
+		return nil, fmt.Errorf("range must contain at least an even port followed by an odd port")
+	}
+
+	return r, nil
+}
+
+func (r PortRange) overlaps(other PortRange) bool {
+	return r.Min <= other.Max && other.Min <= r.Max
+}
+
 func decrypt(key string, byts []byte) ([]byte, error) {
 	enc, err := base64.StdEncoding.DecodeString(string(byts))
 	if err != nil {
@@ -57,56 +138,153 @@ func decrypt(key string, byts []byte) ([]byte, error) {
 // Conf is the main program configuration.
 type Conf struct {
 	// general
-	LogLevel              string                          `yaml:"logLevel" json:"logLevel"`
-	LogLevelParsed        logger.Level                    `yaml:"-" json:"-"`
-	LogDestinations       []string                        `yaml:"logDestinations" json:"logDestinations"`
-	LogDestinationsParsed map[logger.Destination]struct{} `yaml:"-" json:"-"`
-	LogFile               string                          `yaml:"logFile" json:"logFile"`
-	ReadTimeout           time.Duration                   `yaml:"readTimeout" json:"readTimeout"`
-	WriteTimeout          time.Duration                   `yaml:"writeTimeout" json:"writeTimeout"`
-	ReadBufferCount       int                             `yaml:"readBufferCount" json:"readBufferCount"`
-	API                   bool                            `yaml:"api" json:"api"`
-	APIAddress            string                          `yaml:"apiAddress" json:"apiAddress"`
-	Metrics               bool                            `yaml:"metrics" json:"metrics"`
-	MetricsAddress        string                          `yaml:"metricsAddress" json:"metricsAddress"`
-	PPROF                 bool                            `yaml:"pprof" json:"pprof"`
-	PPROFAddress          string                          `yaml:"pprofAddress" json:"pprofAddress"`
-	RunOnConnect          string                          `yaml:"runOnConnect" json:"runOnConnect"`
-	RunOnConnectRestart   bool                            `yaml:"runOnConnectRestart" json:"runOnConnectRestart"`
+	LogLevel                     string                          `yaml:"logLevel" json:"logLevel"`
+	LogLevelParsed               logger.Level                    `yaml:"-" json:"-"`
+	LogDestinations              []string                        `yaml:"logDestinations" json:"logDestinations"`
+	LogDestinationsParsed        map[logger.Destination]struct{} `yaml:"-" json:"-"`
+	LogFile                      string                          `yaml:"logFile" json:"logFile"`
+	ReadTimeout                  time.Duration                   `yaml:"readTimeout" json:"readTimeout"`
+	WriteTimeout                 time.Duration                   `yaml:"writeTimeout" json:"writeTimeout"`
+	ReadBufferCount              int                             `yaml:"readBufferCount" json:"readBufferCount"`
+	API                          bool                            `yaml:"api" json:"api"`
+	APIAddress                   string                          `yaml:"apiAddress" json:"apiAddress"`
+	APIAuditLog                  bool                            `yaml:"apiAuditLog" json:"apiAuditLog"`
+	APIAuditLogFile              string                          `yaml:"apiAuditLogFile" json:"apiAuditLogFile"`
+	APIOIDC                      bool                            `yaml:"apiOIDC" json:"apiOIDC"`
+	BandwidthAccounting          bool                            `yaml:"bandwidthAccounting" json:"bandwidthAccounting"`
+	BandwidthAccountingFile      string                          `yaml:"bandwidthAccountingFile" json:"bandwidthAccountingFile"`
+	BandwidthAccountingBucket    time.Duration                   `yaml:"bandwidthAccountingBucket" json:"bandwidthAccountingBucket"`
+	BandwidthAccountingRetention time.Duration                   `yaml:"bandwidthAccountingRetention" json:"bandwidthAccountingRetention"`
+	Metrics                      bool                            `yaml:"metrics" json:"metrics"`
+	MetricsAddress               string                          `yaml:"metricsAddress" json:"metricsAddress"`
+	PPROF                        bool                            `yaml:"pprof" json:"pprof"`
+	PPROFAddress                 string                          `yaml:"pprofAddress" json:"pprofAddress"`
+	RunOnConnect                 string                          `yaml:"runOnConnect" json:"runOnConnect"`
+	RunOnConnectRestart          bool                            `yaml:"runOnConnectRestart" json:"runOnConnectRestart"`
+	OIDCIssuer                   string                          `yaml:"oidcIssuer" json:"oidcIssuer"`
+	OIDCClientID                 string                          `yaml:"oidcClientID" json:"oidcClientID"`
+	OIDCClientSecret             string                          `yaml:"oidcClientSecret" json:"oidcClientSecret"`
+	ACME                         bool                            `yaml:"acme" json:"acme"`
+	ACMEAddress                  string                          `yaml:"acmeAddress" json:"acmeAddress"`
+	ACMEDomains                  []string                        `yaml:"acmeDomains" json:"acmeDomains"`
+	ACMEEmail                    string                          `yaml:"acmeEmail" json:"acmeEmail"`
+	ACMEDirectoryURL             string                          `yaml:"acmeDirectoryURL" json:"acmeDirectoryURL"`
+	ACMECacheDir                 string                          `yaml:"acmeCacheDir" json:"acmeCacheDir"`
+	OutboundProxy                string                          `yaml:"outboundProxy" json:"outboundProxy"`
+	OutboundInterface            string                          `yaml:"outboundInterface" json:"outboundInterface"`
 
 	// rtsp
-	RTSPDisable       bool                  `yaml:"rtspDisable" json:"rtspDisable"`
-	Protocols         []string              `yaml:"protocols" json:"protocols"`
-	ProtocolsParsed   map[Protocol]struct{} `yaml:"-" json:"-"`
-	Encryption        string                `yaml:"encryption" json:"encryption"`
-	EncryptionParsed  Encryption            `yaml:"-" json:"-"`
-	RTSPAddress       string                `yaml:"rtspAddress" json:"rtspAddress"`
-	RTSPSAddress      string                `yaml:"rtspsAddress" json:"rtspsAddress"`
-	RTPAddress        string                `yaml:"rtpAddress" json:"rtpAddress"`
-	RTCPAddress       string                `yaml:"rtcpAddress" json:"rtcpAddress"`
-	MulticastIPRange  string                `yaml:"multicastIPRange" json:"multicastIPRange"`
-	MulticastRTPPort  int                   `yaml:"multicastRTPPort" json:"multicastRTPPort"`
-	MulticastRTCPPort int                   `yaml:"multicastRTCPPort" json:"multicastRTCPPort"`
-	ServerKey         string                `yaml:"serverKey" json:"serverKey"`
-	ServerCert        string                `yaml:"serverCert" json:"serverCert"`
-	AuthMethods       []string              `yaml:"authMethods" json:"authMethods"`
-	AuthMethodsParsed []headers.AuthMethod  `yaml:"-" json:"-"`
-	ReadBufferSize    int                   `yaml:"readBufferSize" json:"readBufferSize"`
+	RTSPDisable              bool                  `yaml:"rtspDisable" json:"rtspDisable"`
+	Protocols                []string              `yaml:"protocols" json:"protocols"`
+	ProtocolsParsed          map[Protocol]struct{} `yaml:"-" json:"-"`
+	ForceTCPIPs              []string              `yaml:"forceTCPIPs" json:"forceTCPIPs"`
+	ForceTCPIPsParsed        []interface{}         `yaml:"-" json:"-"`
+	ForceUDPIPs              []string              `yaml:"forceUDPIPs" json:"forceUDPIPs"`
+	ForceUDPIPsParsed        []interface{}         `yaml:"-" json:"-"`
+	Encryption               string                `yaml:"encryption" json:"encryption"`
+	EncryptionParsed         Encryption            `yaml:"-" json:"-"`
+	RTSPAddress              string                `yaml:"rtspAddress" json:"rtspAddress"`
+	RTSPSAddress             string                `yaml:"rtspsAddress" json:"rtspsAddress"`
+	RTSPWebSocket            bool                  `yaml:"rtspWebSocket" json:"rtspWebSocket"`
+	RTSPWebSocketAddress     string                `yaml:"rtspWebSocketAddress" json:"rtspWebSocketAddress"`
+	RTPAddress               string                `yaml:"rtpAddress" json:"rtpAddress"`
+	RTCPAddress              string                `yaml:"rtcpAddress" json:"rtcpAddress"`
+	MulticastIPRange         string                `yaml:"multicastIPRange" json:"multicastIPRange"`
+	MulticastRTPPort         int                   `yaml:"multicastRTPPort" json:"multicastRTPPort"`
+	MulticastRTCPPort        int                   `yaml:"multicastRTCPPort" json:"multicastRTCPPort"`
+	MulticastTTL             int                   `yaml:"multicastTTL" json:"multicastTTL"`
+	SourceRTPPortRange       string                `yaml:"sourceRTPPortRange" json:"sourceRTPPortRange"`
+	SourceRTPPortRangeParsed *PortRange            `yaml:"-" json:"-"`
+	ServerKey                string                `yaml:"serverKey" json:"serverKey"`
+	ServerCert               string                `yaml:"serverCert" json:"serverCert"`
+	ClientCA                 string                `yaml:"clientCA" json:"clientCA"`
+	ClientCertRequired       bool                  `yaml:"clientCertRequired" json:"clientCertRequired"`
+	AuthMethods              []string              `yaml:"authMethods" json:"authMethods"`
+	AuthMethodsParsed        []headers.AuthMethod  `yaml:"-" json:"-"`
+	ReadBufferSize           int                   `yaml:"readBufferSize" json:"readBufferSize"`
 
 	// rtmp
-	RTMPDisable bool   `yaml:"rtmpDisable" json:"rtmpDisable"`
-	RTMPAddress string `yaml:"rtmpAddress" json:"rtmpAddress"`
+	RTMPDisable                   bool           `yaml:"rtmpDisable" json:"rtmpDisable"`
+	RTMPEncryption                string         `yaml:"rtmpEncryption" json:"rtmpEncryption"`
+	RTMPEncryptionParsed          Encryption     `yaml:"-" json:"-"`
+	RTMPAddress                   string         `yaml:"rtmpAddress" json:"rtmpAddress"`
+	RTMPSAddress                  string         `yaml:"rtmpsAddress" json:"rtmpsAddress"`
+	RTMPServerKey                 string         `yaml:"rtmpServerKey" json:"rtmpServerKey"`
+	RTMPServerCert                string         `yaml:"rtmpServerCert" json:"rtmpServerCert"`
+	RTMPClientCA                  string         `yaml:"rtmpClientCA" json:"rtmpClientCA"`
+	RTMPClientCertRequired        bool           `yaml:"rtmpClientCertRequired" json:"rtmpClientCertRequired"`
+	RTMPStreamKeyURL              string         `yaml:"rtmpStreamKeyURL" json:"rtmpStreamKeyURL"`
+	RTMPAdditionalAddresses       []string       `yaml:"rtmpAdditionalAddresses" json:"rtmpAdditionalAddresses"`
+	RTMPAdditionalAddressesParsed []RTMPListener `yaml:"-" json:"-"`
 
 	// hls
-	HLSDisable         bool          `yaml:"hlsDisable" json:"hlsDisable"`
-	HLSAddress         string        `yaml:"hlsAddress" json:"hlsAddress"`
-	HLSAlwaysRemux     bool          `yaml:"hlsAlwaysRemux" json:"hlsAlwaysRemux"`
-	HLSSegmentCount    int           `yaml:"hlsSegmentCount" json:"hlsSegmentCount"`
-	HLSSegmentDuration time.Duration `yaml:"hlsSegmentDuration" json:"hlsSegmentDuration"`
-	HLSAllowOrigin     string        `yaml:"hlsAllowOrigin" json:"hlsAllowOrigin"`
+	HLSDisable              bool              `yaml:"hlsDisable" json:"hlsDisable"`
+	HLSAddress              string            `yaml:"hlsAddress" json:"hlsAddress"`
+	HLSSocketMode           string            `yaml:"hlsSocketMode" json:"hlsSocketMode"`
+	HLSSocketModeParsed     os.FileMode       `yaml:"-" json:"-"`
+	HLSTLS                  bool              `yaml:"hlsTLS" json:"hlsTLS"`
+	HLSServerKey            string            `yaml:"hlsServerKey" json:"hlsServerKey"`
+	HLSServerCert           string            `yaml:"hlsServerCert" json:"hlsServerCert"`
+	HLSAlwaysRemux          bool              `yaml:"hlsAlwaysRemux" json:"hlsAlwaysRemux"`
+	HLSRemuxerCloseAfter    time.Duration     `yaml:"hlsRemuxerCloseAfter" json:"hlsRemuxerCloseAfter"`
+	HLSWriteTimeout         time.Duration     `yaml:"hlsWriteTimeout" json:"hlsWriteTimeout"`
+	HLSMaxConnections       int               `yaml:"hlsMaxConnections" json:"hlsMaxConnections"`
+	HLSRequestRateLimit     int               `yaml:"hlsRequestRateLimit" json:"hlsRequestRateLimit"`
+	HLSSessions             bool              `yaml:"hlsSessions" json:"hlsSessions"`
+	HLSSessionTimeout       time.Duration     `yaml:"hlsSessionTimeout" json:"hlsSessionTimeout"`
+	HLSEndList              bool              `yaml:"hlsEndList" json:"hlsEndList"`
+	HLSSegmentCount         int               `yaml:"hlsSegmentCount" json:"hlsSegmentCount"`
+	HLSSegmentDuration      time.Duration     `yaml:"hlsSegmentDuration" json:"hlsSegmentDuration"`
+	HLSLowLatency           bool              `yaml:"hlsLowLatency" json:"hlsLowLatency"`
+	HLSPartDuration         time.Duration     `yaml:"hlsPartDuration" json:"hlsPartDuration"`
+	HLSSegmentFormat        string            `yaml:"hlsSegmentFormat" json:"hlsSegmentFormat"`
+	HLSSegmentFormatParsed  hls.SegmentFormat `yaml:"-" json:"-"`
+	HLSPlaylistName         string            `yaml:"hlsPlaylistName" json:"hlsPlaylistName"`
+	HLSSegmentName          string            `yaml:"hlsSegmentName" json:"hlsSegmentName"`
+	HLSEncryption           string            `yaml:"hlsEncryption" json:"hlsEncryption"`
+	HLSEncryptionKey        string            `yaml:"hlsEncryptionKey" json:"hlsEncryptionKey"`
+	HLSEncryptionKeyParsed  []byte            `yaml:"-" json:"-"`
+	HLSEncryptionKeyURI     string            `yaml:"hlsEncryptionKeyURI" json:"hlsEncryptionKeyURI"`
+	HLSAllowOrigin          string            `yaml:"hlsAllowOrigin" json:"hlsAllowOrigin"`
+	HLSAllowOrigins         []string          `yaml:"hlsAllowOrigins" json:"hlsAllowOrigins"`
+	HLSAllowOriginsParsed   []*regexp.Regexp  `yaml:"-" json:"-"`
+	HLSBasePath             string            `yaml:"hlsBasePath" json:"hlsBasePath"`
+	HLSTrustedProxies       []string          `yaml:"hlsTrustedProxies" json:"hlsTrustedProxies"`
+	HLSTrustedProxiesParsed []interface{}     `yaml:"-" json:"-"`
+	HLSPlaylistCacheControl string            `yaml:"hlsPlaylistCacheControl" json:"hlsPlaylistCacheControl"`
+	HLSSegmentCacheControl  string            `yaml:"hlsSegmentCacheControl" json:"hlsSegmentCacheControl"`
+	HLSPlayerDisable        bool              `yaml:"hlsPlayerDisable" json:"hlsPlayerDisable"`
+	HLSPlayerScriptURL      string            `yaml:"hlsPlayerScriptURL" json:"hlsPlayerScriptURL"`
+
+	// dash
+	DASH                bool          `yaml:"dash" json:"dash"`
+	DASHAddress         string        `yaml:"dashAddress" json:"dashAddress"`
+	DASHMuxerCloseAfter time.Duration `yaml:"dashMuxerCloseAfter" json:"dashMuxerCloseAfter"`
+	DASHSegmentCount    int           `yaml:"dashSegmentCount" json:"dashSegmentCount"`
+	DASHSegmentDuration time.Duration `yaml:"dashSegmentDuration" json:"dashSegmentDuration"`
+	DASHAllowOrigin     string        `yaml:"dashAllowOrigin" json:"dashAllowOrigin"`
+
+	// onvif
+	ONVIF        bool   `yaml:"onvif" json:"onvif"`
+	ONVIFAddress string `yaml:"onvifAddress" json:"onvifAddress"`
 
 	// paths
 	Paths map[string]*PathConf `yaml:"paths" json:"paths"`
+
+	// credentials, with secret references resolved.
+	// They are not exposed in order not to disclose secrets through the API.
+	oidcClientSecret string
+	outboundProxy    string
+}
+
+// ResolvedOIDCClientSecret returns oidcClientSecret, with secret references resolved.
+func (conf *Conf) ResolvedOIDCClientSecret() string {
+	return conf.oidcClientSecret
+}
+
+// ResolvedOutboundProxy returns outboundProxy, with secret references resolved.
+func (conf *Conf) ResolvedOutboundProxy() string {
+	return conf.outboundProxy
 }
 
 // Load loads a Conf.
@@ -214,6 +392,67 @@ func (conf *Conf) CheckAndFillMissing() error {
 	if conf.APIAddress == "" {
 		conf.APIAddress = "127.0.0.1:9997"
 	}
+	if conf.APIAuditLogFile == "" {
+		conf.APIAuditLogFile = "rtsp-simple-server-audit.log"
+	}
+
+	if conf.BandwidthAccountingFile == "" {
+		conf.BandwidthAccountingFile = "rtsp-simple-server-bandwidth.json"
+	}
+	if conf.BandwidthAccountingBucket == 0 {
+		conf.BandwidthAccountingBucket = 1 * time.Hour
+	}
+
+	var hlsEncryptionKey string
+	err := resolveSecretFields([]secretField{
+		{"oidcClientSecret", conf.OIDCClientSecret, &conf.oidcClientSecret},
+		{"outboundProxy", conf.OutboundProxy, &conf.outboundProxy},
+		{"hlsEncryptionKey", conf.HLSEncryptionKey, &hlsEncryptionKey},
+	})
+	if err != nil {
+		return err
+	}
+
+	if conf.OIDCIssuer != "" {
+		if !strings.HasPrefix(conf.OIDCIssuer, "https://") && !strings.HasPrefix(conf.OIDCIssuer, "http://") {
+			return fmt.Errorf("'oidcIssuer' must be an HTTP or HTTPS URL")
+		}
+		if conf.OIDCClientID == "" {
+			return fmt.Errorf("'oidcClientID' is required when 'oidcIssuer' is set")
+		}
+	}
+	if conf.APIOIDC && conf.OIDCIssuer == "" {
+		return fmt.Errorf("'apiOIDC' requires 'oidcIssuer'")
+	}
+
+	if conf.ACME {
+		if len(conf.ACMEDomains) == 0 {
+			return fmt.Errorf("'acme' requires at least one domain in 'acmeDomains'")
+		}
+	}
+	if conf.ACMEAddress == "" {
+		conf.ACMEAddress = ":80"
+	}
+	if conf.ACMEDirectoryURL == "" {
+		conf.ACMEDirectoryURL = acme.LetsEncryptURL
+	}
+	if conf.ACMECacheDir == "" {
+		conf.ACMECacheDir = "acme"
+	}
+
+	if conf.outboundProxy != "" {
+		_, err := proxydialer.New(conf.outboundProxy, nil)
+		if err != nil {
+			return fmt.Errorf("invalid 'outboundProxy': %s", err)
+		}
+	}
+
+	if conf.OutboundInterface != "" {
+		_, err := proxydialer.ResolveLocalIP(conf.OutboundInterface)
+		if err != nil {
+			return fmt.Errorf("invalid 'outboundInterface': %s", err)
+		}
+	}
 
 	if conf.MetricsAddress == "" {
 		conf.MetricsAddress = "127.0.0.1:9998"
@@ -246,6 +485,28 @@ func (conf *Conf) CheckAndFillMissing() error {
 		return fmt.Errorf("no protocols provided")
 	}
 
+	conf.ForceTCPIPsParsed, err = parseIPCidrList(conf.ForceTCPIPs)
+	if err != nil {
+		return err
+	}
+	if conf.ForceTCPIPsParsed != nil {
+		if _, ok := conf.ProtocolsParsed[ProtocolTCP]; !ok {
+			return fmt.Errorf("'forceTCPIPs' requires 'tcp' in protocols")
+		}
+	}
+
+	conf.ForceUDPIPsParsed, err = parseIPCidrList(conf.ForceUDPIPs)
+	if err != nil {
+		return err
+	}
+	if conf.ForceUDPIPsParsed != nil {
+		_, udp := conf.ProtocolsParsed[ProtocolUDP]
+		_, multicast := conf.ProtocolsParsed[ProtocolMulticast]
+		if !udp && !multicast {
+			return fmt.Errorf("'forceUDPIPs' requires 'udp' or 'multicast' in protocols")
+		}
+	}
+
 	if conf.Encryption == "" {
 		conf.Encryption = "no"
 	}
@@ -273,6 +534,9 @@ func (conf *Conf) CheckAndFillMissing() error {
 	if conf.RTSPSAddress == "" {
 		conf.RTSPSAddress = ":8555"
 	}
+	if conf.RTSPWebSocketAddress == "" {
+		conf.RTSPWebSocketAddress = ":8556"
+	}
 	if conf.RTPAddress == "" {
 		conf.RTPAddress = ":8000"
 	}
@@ -288,6 +552,17 @@ func (conf *Conf) CheckAndFillMissing() error {
 	if conf.MulticastRTCPPort == 0 {
 		conf.MulticastRTCPPort = 8003
 	}
+	if conf.MulticastTTL < 0 || conf.MulticastTTL > 255 {
+		return fmt.Errorf("'multicastTTL' must be between 0 and 255")
+	}
+
+	if conf.SourceRTPPortRange != "" {
+		r, err := parsePortRange(conf.SourceRTPPortRange)
+		if err != nil {
+			return fmt.Errorf("invalid 'sourceRTPPortRange': %s", err)
+		}
+		conf.SourceRTPPortRangeParsed = r
+	}
 
 	if conf.ServerKey == "" {
 		conf.ServerKey = "server.key"
@@ -312,22 +587,224 @@ func (conf *Conf) CheckAndFillMissing() error {
 		}
 	}
 
+	if conf.RTMPEncryption == "" {
+		conf.RTMPEncryption = "no"
+	}
+	switch conf.RTMPEncryption {
+	case "no", "false":
+		conf.RTMPEncryptionParsed = EncryptionNo
+
+	case "optional":
+		conf.RTMPEncryptionParsed = EncryptionOptional
+
+	case "strict", "yes", "true":
+		conf.RTMPEncryptionParsed = EncryptionStrict
+
+	default:
+		return fmt.Errorf("unsupported RTMP encryption value: '%s'", conf.RTMPEncryption)
+	}
+
 	if conf.RTMPAddress == "" {
 		conf.RTMPAddress = ":1935"
 	}
+	if conf.RTMPSAddress == "" {
+		conf.RTMPSAddress = ":1936"
+	}
+	if conf.RTMPServerKey == "" {
+		conf.RTMPServerKey = "server.key"
+	}
+	if conf.RTMPServerCert == "" {
+		conf.RTMPServerCert = "server.crt"
+	}
+
+	if conf.RTMPStreamKeyURL != "" {
+		u, err := url.Parse(conf.RTMPStreamKeyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("'%s' is not a valid HTTP URL", conf.RTMPStreamKeyURL)
+		}
+	}
+
+	conf.RTMPAdditionalAddressesParsed = nil
+	for _, v := range conf.RTMPAdditionalAddresses {
+		l, err := parseRTMPListener(v)
+		if err != nil {
+			return err
+		}
+		conf.RTMPAdditionalAddressesParsed = append(conf.RTMPAdditionalAddressesParsed, l)
+	}
 
 	if conf.HLSAddress == "" {
 		conf.HLSAddress = ":8888"
 	}
+	if conf.HLSSocketMode == "" {
+		conf.HLSSocketMode = "0660"
+	}
+	mode, err := strconv.ParseUint(conf.HLSSocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return fmt.Errorf("invalid 'hlsSocketMode': %s", conf.HLSSocketMode)
+	}
+	conf.HLSSocketModeParsed = os.FileMode(mode)
+	if conf.HLSRemuxerCloseAfter == 0 {
+		conf.HLSRemuxerCloseAfter = 60 * time.Second
+	}
+	if conf.HLSRemuxerCloseAfter < 0 {
+		return fmt.Errorf("'hlsRemuxerCloseAfter' can't be negative")
+	}
+	if conf.HLSWriteTimeout == 0 {
+		conf.HLSWriteTimeout = 10 * time.Second
+	}
+	if conf.HLSWriteTimeout < 0 {
+		return fmt.Errorf("'hlsWriteTimeout' can't be negative")
+	}
+	if conf.HLSMaxConnections < 0 {
+		return fmt.Errorf("'hlsMaxConnections' can't be negative")
+	}
+	if conf.HLSRequestRateLimit < 0 {
+		return fmt.Errorf("'hlsRequestRateLimit' can't be negative")
+	}
+	if conf.HLSSessionTimeout == 0 {
+		conf.HLSSessionTimeout = 30 * time.Second
+	}
+	if conf.HLSSessionTimeout < 0 {
+		return fmt.Errorf("'hlsSessionTimeout' can't be negative")
+	}
+	if conf.HLSServerKey == "" {
+		conf.HLSServerKey = "server.key"
+	}
+	if conf.HLSServerCert == "" {
+		conf.HLSServerCert = "server.crt"
+	}
 	if conf.HLSSegmentCount == 0 {
 		conf.HLSSegmentCount = 5
 	}
 	if conf.HLSSegmentDuration == 0 {
 		conf.HLSSegmentDuration = 1 * time.Second
 	}
+	if conf.HLSPartDuration == 0 {
+		conf.HLSPartDuration = 200 * time.Millisecond
+	}
+	if conf.HLSPartDuration < 0 || conf.HLSPartDuration >= conf.HLSSegmentDuration {
+		return fmt.Errorf("'hlsPartDuration' must be positive and less than 'hlsSegmentDuration'")
+	}
+	if conf.HLSSegmentFormat == "" {
+		conf.HLSSegmentFormat = "mpegts"
+	}
+	switch conf.HLSSegmentFormat {
+	case "mpegts":
+		conf.HLSSegmentFormatParsed = hls.SegmentFormatMPEGTS
+
+	case "fmp4":
+		conf.HLSSegmentFormatParsed = hls.SegmentFormatFMP4
+
+	default:
+		return fmt.Errorf("unsupported HLS segment format: '%s'", conf.HLSSegmentFormat)
+	}
+	if conf.HLSPlaylistName == "" {
+		conf.HLSPlaylistName = "stream.m3u8"
+	}
+	if !strings.HasSuffix(conf.HLSPlaylistName, ".m3u8") || strings.Contains(conf.HLSPlaylistName, "/") {
+		return fmt.Errorf("'hlsPlaylistName' must end with .m3u8 and can't contain slashes")
+	}
+	if conf.HLSSegmentName == "" {
+		conf.HLSSegmentName = "$TIME.ts"
+	}
+	if !strings.HasSuffix(conf.HLSSegmentName, ".ts") || strings.Contains(conf.HLSSegmentName, "/") {
+		return fmt.Errorf("'hlsSegmentName' must end with .ts and can't contain slashes")
+	}
+	if !strings.Contains(conf.HLSSegmentName, "$TIME") && !strings.Contains(conf.HLSSegmentName, "$SEQ") {
+		return fmt.Errorf("'hlsSegmentName' must contain $TIME or $SEQ")
+	}
+	if conf.HLSEncryption == "" {
+		conf.HLSEncryption = "no"
+	}
+	switch conf.HLSEncryption {
+	case "no":
+
+	case "aes-128":
+		if conf.HLSLowLatency {
+			return fmt.Errorf("HLS encryption can't be used with low-latency HLS")
+		}
+		if conf.HLSSegmentFormatParsed != hls.SegmentFormatMPEGTS {
+			return fmt.Errorf("HLS encryption can be used with the mpegts segment format only")
+		}
+
+	default:
+		return fmt.Errorf("unsupported HLS encryption value: '%s'", conf.HLSEncryption)
+	}
+	if hlsEncryptionKey != "" {
+		key, err := hex.DecodeString(hlsEncryptionKey)
+		if err != nil || len(key) != 16 {
+			return fmt.Errorf("'hlsEncryptionKey' must be a 16-byte key in hexadecimal format")
+		}
+		conf.HLSEncryptionKeyParsed = key
+	}
+	if conf.HLSEncryptionKeyURI != "" && conf.HLSEncryptionKey == "" {
+		return fmt.Errorf("'hlsEncryptionKey' is required when 'hlsEncryptionKeyURI' is set")
+	}
 	if conf.HLSAllowOrigin == "" {
 		conf.HLSAllowOrigin = "*"
 	}
+	if len(conf.HLSAllowOrigins) == 0 {
+		conf.HLSAllowOrigins = nil
+	}
+	conf.HLSAllowOriginsParsed = nil
+	for _, origin := range conf.HLSAllowOrigins {
+		re, err := parseOriginPattern(origin)
+		if err != nil {
+			return err
+		}
+		conf.HLSAllowOriginsParsed = append(conf.HLSAllowOriginsParsed, re)
+	}
+	if conf.HLSBasePath != "" {
+		if !strings.HasPrefix(conf.HLSBasePath, "/") {
+			return fmt.Errorf("'hlsBasePath' must start with a slash")
+		}
+		if !strings.HasSuffix(conf.HLSBasePath, "/") {
+			conf.HLSBasePath += "/"
+		}
+	}
+	if len(conf.HLSTrustedProxies) == 0 {
+		conf.HLSTrustedProxies = nil
+	}
+	conf.HLSTrustedProxiesParsed, err = parseIPCidrList(conf.HLSTrustedProxies)
+	if err != nil {
+		return err
+	}
+	if conf.HLSPlaylistCacheControl == "" {
+		conf.HLSPlaylistCacheControl = "no-cache"
+	}
+	if conf.HLSPlayerScriptURL == "" {
+		conf.HLSPlayerScriptURL = "https://cdn.jsdelivr.net/npm/hls.js@1.0.0"
+	}
+
+	if conf.DASHAddress == "" {
+		conf.DASHAddress = ":8889"
+	}
+	if conf.DASHMuxerCloseAfter == 0 {
+		conf.DASHMuxerCloseAfter = 60 * time.Second
+	}
+	if conf.DASHMuxerCloseAfter < 0 {
+		return fmt.Errorf("'dashMuxerCloseAfter' can't be negative")
+	}
+	if conf.DASHSegmentCount == 0 {
+		conf.DASHSegmentCount = 7
+	}
+	if conf.DASHSegmentCount < 0 {
+		return fmt.Errorf("'dashSegmentCount' can't be negative")
+	}
+	if conf.DASHSegmentDuration == 0 {
+		conf.DASHSegmentDuration = 2 * time.Second
+	}
+	if conf.DASHSegmentDuration < 0 {
+		return fmt.Errorf("'dashSegmentDuration' can't be negative")
+	}
+	if conf.DASHAllowOrigin == "" {
+		conf.DASHAllowOrigin = "*"
+	}
+
+	if conf.ONVIFAddress == "" {
+		conf.ONVIFAddress = ":8000"
+	}
 
 	if len(conf.Paths) == 0 {
 		conf.Paths = map[string]*PathConf{
@@ -351,7 +828,72 @@ func (conf *Conf) CheckAndFillMissing() error {
 		if err != nil {
 			return err
 		}
+
+		if pconf.ReadOIDC && conf.OIDCIssuer == "" {
+			return fmt.Errorf("'readOIDC' requires 'oidcIssuer'")
+		}
+
+		// proxies can only forward TCP connections
+		if (pconf.outboundProxy != "" || conf.outboundProxy != "") &&
+			pconf.SourceProtocolParsed != nil &&
+			*pconf.SourceProtocolParsed != gortsplib.ClientProtocolTCP {
+			return fmt.Errorf("'outboundProxy' can be used only with the TCP source protocol")
+		}
+
+		// init sections of the two playlists would have the same names
+		if pconf.HLSAudioOnlyVariant && conf.HLSSegmentFormatParsed != hls.SegmentFormatMPEGTS {
+			return fmt.Errorf("'hlsAudioOnlyVariant' can be used with the mpegts segment format only")
+		}
+	}
+
+	return conf.checkPathPortRanges()
+}
+
+// checkPathPortRanges checks that the port ranges of paths don't overlap,
+// since they are meant to isolate paths from each other. Paths with the same
+// range form a group that shares it.
+func (conf *Conf) checkPathPortRanges() error {
+	var names []string
+	for name, pconf := range conf.Paths {
+		if pconf.SourceRTPPortRangeParsed != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		r := *conf.Paths[name].SourceRTPPortRangeParsed
+
+		if conf.SourceRTPPortRangeParsed != nil && r.overlaps(*conf.SourceRTPPortRangeParsed) {
+			return fmt.Errorf("'sourceRTPPortRange' of path '%s' overlaps with the global one", name)
+		}
+
+		for _, other := range names[i+1:] {
+			otherRange := *conf.Paths[other].SourceRTPPortRangeParsed
+			if otherRange != r && r.overlaps(otherRange) {
+				return fmt.Errorf("'sourceRTPPortRange' of path '%s' overlaps with the one of path '%s'", name, other)
+			}
+		}
 	}
 
 	return nil
 }
+
+// parseOriginPattern converts an entry of hlsAllowOrigins into a regular
+// expression. Entries that start with a tilde are regular expressions,
+// the others can contain wildcards (*).
+func parseOriginPattern(origin string) (*regexp.Regexp, error) {
+	if origin == "" {
+		return nil, fmt.Errorf("'hlsAllowOrigins' can't contain empty entries")
+	}
+
+	if origin[0] == '~' {
+		re, err := regexp.Compile(origin[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression in 'hlsAllowOrigins': %s", origin[1:])
+		}
+		return re, nil
+	}
+
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(origin), `\*`, ".*") + "$"), nil
+}
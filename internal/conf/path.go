@@ -6,11 +6,15 @@ import (
 	"net"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
+
+	"github.com/aler9/rtsp-simple-server/internal/credential"
+	"github.com/aler9/rtsp-simple-server/internal/proxydialer"
 )
 
 const userPassSupportedChars = "A-Z,0-9,!,$,(,),*,+,.,;,<,=,>,[,],^,_,-,{,}"
@@ -19,6 +23,87 @@ var reUserPass = regexp.MustCompile(`^[a-zA-Z0-9!\$\(\)\*\+\.;<=>\[\]\^_\-\{\}]+
 
 var rePathName = regexp.MustCompile(`^[0-9a-zA-Z_\-/\.~]+$`)
 
+// RTPValidation is a policy for malformed RTP/RTCP packets.
+type RTPValidation int
+
+// RTP validation policies.
+const (
+	RTPValidationNo RTPValidation = iota
+	RTPValidationFlag
+	RTPValidationDrop
+)
+
+// PublisherPolicy is the behavior when a client tries to publish to a path
+// that already has a publisher.
+type PublisherPolicy int
+
+// publisher policies.
+const (
+	PublisherPolicyTakeover PublisherPolicy = iota
+	PublisherPolicyReject
+	PublisherPolicyStandby
+)
+
+// ReaderStart is the point of a stream from which readers start reading.
+type ReaderStart int
+
+// reader start points.
+const (
+	ReaderStartLive ReaderStart = iota
+	ReaderStartKeyframe
+)
+
+// HLSRendition is a rendition of a path, generated by transcoding.
+type HLSRendition struct {
+	Height  int
+	Bitrate string
+}
+
+var reBitrate = regexp.MustCompile(`^[0-9]+[kM]?$`)
+
+// DefaultHLSRenditionCommand is the command used to generate renditions
+// when hlsRenditionCommand is not set.
+const DefaultHLSRenditionCommand = "ffmpeg -hide_banner -loglevel error " +
+	"-i rtsp://localhost:$RTSP_PORT/$RTSP_PATH " +
+	"-c:v libx264 -preset veryfast -tune zerolatency -force_key_frames source " +
+	"-vf scale=-2:$RTSP_RENDITION_HEIGHT -b:v $RTSP_RENDITION_BITRATE " +
+	"-maxrate $RTSP_RENDITION_BITRATE -bufsize $RTSP_RENDITION_BITRATE " +
+	"-c:a copy -f rtsp rtsp://localhost:$RTSP_PORT/$RTSP_RENDITION_PATH"
+
+// DefaultHLSAudioTranscodeCommand is the command used to transcode audio
+// when hlsAudioTranscodeCommand is not set.
+const DefaultHLSAudioTranscodeCommand = "ffmpeg -hide_banner -loglevel error " +
+	"-i rtsp://localhost:$RTSP_PORT/$RTSP_PATH " +
+	"-map 0:v? -map 0:a:0 -c:v copy -c:a aac -b:a 128k " +
+	"-f rtsp rtsp://localhost:$RTSP_PORT/$RTSP_RENDITION_PATH"
+
+// PauseBehavior is the behavior of readers when a stream is paused.
+type PauseBehavior int
+
+// pause behaviors.
+const (
+	PauseBehaviorFreeze PauseBehavior = iota
+	PauseBehaviorClose
+)
+
+var supportedCodecs = map[string]struct{}{
+	"h264":       {},
+	"h265":       {},
+	"vp8":        {},
+	"vp9":        {},
+	"av1":        {},
+	"mpeg4video": {},
+	"mpegvideo":  {},
+	"jpeg":       {},
+	"aac":        {},
+	"opus":       {},
+	"mpegaudio":  {},
+	"pcma":       {},
+	"pcmu":       {},
+	"g722":       {},
+	"lpcm":       {},
+}
+
 func parseIPCidrList(in []string) ([]interface{}, error) {
 	if len(in) == 0 {
 		return nil, nil
@@ -43,6 +128,27 @@ func parseIPCidrList(in []string) ([]interface{}, error) {
 	return ret, nil
 }
 
+func checkRTMPURL(ur string) error {
+	u, err := url.Parse(ur)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid RTMP URL", ur)
+	}
+	if u.Scheme != "rtmp" && u.Scheme != "rtmps" {
+		return fmt.Errorf("'%s' is not a valid RTMP URL", ur)
+	}
+
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		user := u.User.Username()
+		if user != "" && pass == "" ||
+			user == "" && pass != "" {
+			return fmt.Errorf("username and password must be both provided")
+		}
+	}
+
+	return nil
+}
+
 // CheckPathName checks if a path name is valid.
 func CheckPathName(name string) error {
 	if name == "" {
@@ -73,23 +179,95 @@ type PathConf struct {
 	SourceProtocol             string                    `yaml:"sourceProtocol" json:"sourceProtocol"`
 	SourceProtocolParsed       *gortsplib.ClientProtocol `yaml:"-" json:"-"`
 	SourceAnyPortEnable        bool                      `yaml:"sourceAnyPortEnable" json:"sourceAnyPortEnable"`
+	SourceRTPPortRange         string                    `yaml:"sourceRTPPortRange" json:"sourceRTPPortRange"`
+	SourceRTPPortRangeParsed   *PortRange                `yaml:"-" json:"-"`
 	SourceFingerprint          string                    `yaml:"sourceFingerprint" json:"sourceFingerprint"`
 	SourceOnDemand             bool                      `yaml:"sourceOnDemand" json:"sourceOnDemand"`
 	SourceOnDemandStartTimeout time.Duration             `yaml:"sourceOnDemandStartTimeout" json:"sourceOnDemandStartTimeout"`
 	SourceOnDemandCloseAfter   time.Duration             `yaml:"sourceOnDemandCloseAfter" json:"sourceOnDemandCloseAfter"`
+	OnDemandRetryAfter         time.Duration             `yaml:"onDemandRetryAfter" json:"onDemandRetryAfter"`
+	OnDemandForwardQuery       bool                      `yaml:"onDemandForwardQuery" json:"onDemandForwardQuery"`
 	SourceRedirect             string                    `yaml:"sourceRedirect" json:"sourceRedirect"`
-	DisablePublisherOverride   bool                      `yaml:"disablePublisherOverride" json:"disablePublisherOverride"`
+	SourceRedirectPool         []string                  `yaml:"sourceRedirectPool" json:"sourceRedirectPool"`
+	SourceRedirectHook         string                    `yaml:"sourceRedirectHook" json:"sourceRedirectHook"`
+	SourceFile                 string                    `yaml:"sourceFile" json:"sourceFile"`
+	SourcePool                 []string                  `yaml:"sourcePool" json:"sourcePool"`
+	SourceBackups              []string                  `yaml:"sourceBackups" json:"sourceBackups"`
+	SourceFailbackInterval     time.Duration             `yaml:"sourceFailbackInterval" json:"sourceFailbackInterval"`
+	SourceHealthCheckInterval  time.Duration             `yaml:"sourceHealthCheckInterval" json:"sourceHealthCheckInterval"`
+	SourceRetryPause           time.Duration             `yaml:"sourceRetryPause" json:"sourceRetryPause"`
+	SourceRetryMaxPause        time.Duration             `yaml:"sourceRetryMaxPause" json:"sourceRetryMaxPause"`
+	OutboundProxy              string                    `yaml:"outboundProxy" json:"outboundProxy"`
+	OutboundInterface          string                    `yaml:"outboundInterface" json:"outboundInterface"`
+	TestPatternResolution      string                    `yaml:"testPatternResolution" json:"testPatternResolution"`
+	TestPatternWidth           int                       `yaml:"-" json:"-"`
+	TestPatternHeight          int                       `yaml:"-" json:"-"`
+	TestPatternFPS             int                       `yaml:"testPatternFPS" json:"testPatternFPS"`
+	TestPatternBitrate         int                       `yaml:"testPatternBitrate" json:"testPatternBitrate"`
+	DisablePublisherOverride   bool                      `yaml:"disablePublisherOverride" json:"disablePublisherOverride"` // deprecated
+	PublisherPolicy            string                    `yaml:"publisherPolicy" json:"publisherPolicy"`
+	PublisherPolicyParsed      PublisherPolicy           `yaml:"-" json:"-"`
+	PublishCodecs              []string                  `yaml:"publishCodecs" json:"publishCodecs"`
+	RTPValidation              string                    `yaml:"rtpValidation" json:"rtpValidation"`
+	RTPValidationParsed        RTPValidation             `yaml:"-" json:"-"`
+	RTPHeaderExtensions        []string                  `yaml:"rtpHeaderExtensions" json:"rtpHeaderExtensions"`
+	RTPHeaderExtensionsParsed  []int                     `yaml:"-" json:"-"`
 	Fallback                   string                    `yaml:"fallback" json:"fallback"`
+	HLSDisable                 bool                      `yaml:"hlsDisable" json:"hlsDisable"`
+	HLSVariants                []string                  `yaml:"hlsVariants" json:"hlsVariants"`
+	HLSSegmentCount            int                       `yaml:"hlsSegmentCount" json:"hlsSegmentCount"`
+	HLSSegmentDuration         time.Duration             `yaml:"hlsSegmentDuration" json:"hlsSegmentDuration"`
+	HLSDVRWindow               time.Duration             `yaml:"hlsDVRWindow" json:"hlsDVRWindow"`
+	HLSDirectory               string                    `yaml:"hlsDirectory" json:"hlsDirectory"`
+	HLSAudioOnlyVariant        bool                      `yaml:"hlsAudioOnlyVariant" json:"hlsAudioOnlyVariant"`
+	HLSRenditions              []string                  `yaml:"hlsRenditions" json:"hlsRenditions"`
+	HLSRenditionsParsed        []HLSRendition            `yaml:"-" json:"-"`
+	HLSRenditionCommand        string                    `yaml:"hlsRenditionCommand" json:"hlsRenditionCommand"`
+	HLSAudioTranscode          bool                      `yaml:"hlsAudioTranscode" json:"hlsAudioTranscode"`
+	HLSAudioTranscodeCommand   string                    `yaml:"hlsAudioTranscodeCommand" json:"hlsAudioTranscodeCommand"`
+	LatencyProbe               bool                      `yaml:"latencyProbe" json:"latencyProbe"`
+	ReaderStart                string                    `yaml:"readerStart" json:"readerStart"`
+	ReaderStartParsed          ReaderStart               `yaml:"-" json:"-"`
+	PauseBehavior              string                    `yaml:"pauseBehavior" json:"pauseBehavior"`
+	PauseBehaviorParsed        PauseBehavior             `yaml:"-" json:"-"`
+	KeyframeRequest            bool                      `yaml:"keyframeRequest" json:"keyframeRequest"`
+	MPEGTSMulticastAddress     string                    `yaml:"mpegtsMulticastAddress" json:"mpegtsMulticastAddress"`
+	MPEGTSMulticastTTL         int                       `yaml:"mpegtsMulticastTTL" json:"mpegtsMulticastTTL"`
+	MPEGTSMulticastInterface   string                    `yaml:"mpegtsMulticastInterface" json:"mpegtsMulticastInterface"`
+	MPEGTSMulticastSAP         bool                      `yaml:"mpegtsMulticastSAP" json:"mpegtsMulticastSAP"`
+	PushTargets                []string                  `yaml:"pushTargets" json:"pushTargets"`
+	Title                      string                    `yaml:"title" json:"title"`
+	Description                string                    `yaml:"description" json:"description"`
+	SDPPassthrough             bool                      `yaml:"sdpPassthrough" json:"sdpPassthrough"`
+	SRTP                       bool                      `yaml:"srtp" json:"srtp"`
+	MaxPublishDuration         time.Duration             `yaml:"maxPublishDuration" json:"maxPublishDuration"`
+	IdleCloseAfter             time.Duration             `yaml:"idleCloseAfter" json:"idleCloseAfter"`
+	PublisherGracePeriod       time.Duration             `yaml:"publisherGracePeriod" json:"publisherGracePeriod"`
+	ReadTimeout                time.Duration             `yaml:"readTimeout" json:"readTimeout"`
+	WriteTimeout               time.Duration             `yaml:"writeTimeout" json:"writeTimeout"`
+	SessionTimeout             time.Duration             `yaml:"sessionTimeout" json:"sessionTimeout"`
 
 	// authentication
 	PublishUser      string        `yaml:"publishUser" json:"publishUser"`
 	PublishPass      string        `yaml:"publishPass" json:"publishPass"`
 	PublishIPs       []string      `yaml:"publishIPs" json:"publishIPs"`
 	PublishIPsParsed []interface{} `yaml:"-" json:"-"`
+	PublishClientCNs []string      `yaml:"publishClientCNs" json:"publishClientCNs"`
 	ReadUser         string        `yaml:"readUser" json:"readUser"`
 	ReadPass         string        `yaml:"readPass" json:"readPass"`
 	ReadIPs          []string      `yaml:"readIPs" json:"readIPs"`
 	ReadIPsParsed    []interface{} `yaml:"-" json:"-"`
+	ReadClientCNs    []string      `yaml:"readClientCNs" json:"readClientCNs"`
+	ReadOIDC         bool          `yaml:"readOIDC" json:"readOIDC"`
+	ReadOIDCUsers    []string      `yaml:"readOIDCUsers" json:"readOIDCUsers"`
+
+	// credentials, with secret references resolved.
+	// They are not exposed in order not to disclose secrets through the API.
+	publishUser   string
+	publishPass   string
+	readUser      string
+	readPass      string
+	outboundProxy string
 
 	// custom commands
 	RunOnInit               string        `yaml:"runOnInit" json:"runOnInit"`
@@ -102,6 +280,8 @@ type PathConf struct {
 	RunOnPublishRestart     bool          `yaml:"runOnPublishRestart" json:"runOnPublishRestart"`
 	RunOnRead               string        `yaml:"runOnRead" json:"runOnRead"`
 	RunOnReadRestart        bool          `yaml:"runOnReadRestart" json:"runOnReadRestart"`
+	RunOnLimit              string        `yaml:"runOnLimit" json:"runOnLimit"`
+	RunOnSourceSwitch       string        `yaml:"runOnSourceSwitch" json:"runOnSourceSwitch"`
 }
 
 func (pconf *PathConf) checkAndFillMissing(name string) error {
@@ -170,48 +350,198 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 			return fmt.Errorf("sourceFingerprint is required with a RTSPS URL")
 		}
 
-	case strings.HasPrefix(pconf.Source, "rtmp://"):
+		if pconf.SourceRTPPortRange != "" {
+			r, err := parsePortRange(pconf.SourceRTPPortRange)
+			if err != nil {
+				return fmt.Errorf("invalid 'sourceRTPPortRange': %s", err)
+			}
+			pconf.SourceRTPPortRangeParsed = r
+		}
+
+		for _, ur := range append(append([]string(nil), pconf.SourcePool...), pconf.SourceBackups...) {
+			if !strings.HasPrefix(ur, "rtsp://") && !strings.HasPrefix(ur, "rtsps://") {
+				return fmt.Errorf("'%s' is not a valid RTSP URL", ur)
+			}
+
+			_, err := base.ParseURL(ur)
+			if err != nil {
+				return fmt.Errorf("'%s' is not a valid RTSP URL", ur)
+			}
+
+			if strings.HasPrefix(ur, "rtsps://") && pconf.SourceFingerprint == "" {
+				return fmt.Errorf("sourceFingerprint is required with a RTSPS URL")
+			}
+		}
+
+	case strings.HasPrefix(pconf.Source, "rtmp://") ||
+		strings.HasPrefix(pconf.Source, "rtmps://"):
 		if pconf.Regexp != nil {
 			return fmt.Errorf("a path with a regular expression (or path 'all') cannot have a RTMP source; use another path")
 		}
 
+		urs := append([]string{pconf.Source}, pconf.SourcePool...)
+		for _, ur := range append(urs, pconf.SourceBackups...) {
+			err := checkRTMPURL(ur)
+			if err != nil {
+				return err
+			}
+		}
+
+	case strings.HasPrefix(pconf.Source, "http://") ||
+		strings.HasPrefix(pconf.Source, "https://"):
+		if pconf.Regexp != nil {
+			return fmt.Errorf("a path with a regular expression (or path 'all') cannot have a HLS source; use another path")
+		}
+
 		u, err := url.Parse(pconf.Source)
-		if err != nil {
-			return fmt.Errorf("'%s' is not a valid RTMP URL", pconf.Source)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("'%s' is not a valid HLS URL", pconf.Source)
 		}
-		if u.Scheme != "rtmp" {
-			return fmt.Errorf("'%s' is not a valid RTMP URL", pconf.Source)
+
+	case pconf.Source == "testpattern":
+		if pconf.TestPatternResolution == "" {
+			pconf.TestPatternResolution = "640x480"
 		}
 
-		if u.User != nil {
-			pass, _ := u.User.Password()
-			user := u.User.Username()
-			if user != "" && pass == "" ||
-				user == "" && pass != "" {
-				return fmt.Errorf("username and password must be both provided")
-			}
+		var width, height int
+		_, err := fmt.Sscanf(pconf.TestPatternResolution, "%dx%d", &width, &height)
+		if err != nil || width <= 0 || height <= 0 {
+			return fmt.Errorf("invalid test pattern resolution: '%s'", pconf.TestPatternResolution)
+		}
+		if (width%16) != 0 || (height%16) != 0 {
+			return fmt.Errorf("test pattern width and height must be multiples of 16")
+		}
+		if width > 4096 || height > 2304 {
+			return fmt.Errorf("test pattern resolution can't be greater than 4096x2304")
+		}
+		pconf.TestPatternWidth = width
+		pconf.TestPatternHeight = height
+
+		if pconf.TestPatternFPS == 0 {
+			pconf.TestPatternFPS = 25
+		}
+		if pconf.TestPatternFPS < 0 || pconf.TestPatternFPS > 120 {
+			return fmt.Errorf("test pattern FPS must be between 1 and 120")
+		}
+
+		if pconf.TestPatternBitrate < 0 {
+			return fmt.Errorf("test pattern bitrate can't be negative")
 		}
 
 	case pconf.Source == "redirect":
-		if pconf.SourceRedirect == "" {
+		if pconf.SourceRedirect == "" && pconf.SourceRedirectHook == "" {
 			return fmt.Errorf("source redirect must be filled")
 		}
 
-		_, err := base.ParseURL(pconf.SourceRedirect)
-		if err != nil {
-			return fmt.Errorf("'%s' is not a valid RTSP URL", pconf.SourceRedirect)
+		if pconf.SourceRedirect == "" && len(pconf.SourceRedirectPool) != 0 {
+			return fmt.Errorf("'sourceRedirectPool' can be used only with 'sourceRedirect'")
+		}
+
+		if pconf.SourceRedirect != "" {
+			for _, ur := range append([]string{pconf.SourceRedirect}, pconf.SourceRedirectPool...) {
+				_, err := base.ParseURL(ur)
+				if err != nil {
+					return fmt.Errorf("'%s' is not a valid RTSP URL", ur)
+				}
+			}
+		}
+
+		if pconf.SourceRedirectHook != "" {
+			u, err := url.Parse(pconf.SourceRedirectHook)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("'%s' is not a valid HTTP URL", pconf.SourceRedirectHook)
+			}
+		}
+
+	case pconf.Source == "vod":
+		if pconf.SourceFile == "" {
+			return fmt.Errorf("source file must be filled")
 		}
 
 	default:
 		return fmt.Errorf("invalid source: '%s'", pconf.Source)
 	}
 
+	if pconf.SRTP && pconf.Source == "vod" {
+		return fmt.Errorf("'srtp' can't be used with the 'vod' source")
+	}
+
+	if (len(pconf.SourceRedirectPool) != 0 || pconf.SourceRedirectHook != "") &&
+		pconf.Source != "redirect" {
+		return fmt.Errorf("'sourceRedirectPool' and 'sourceRedirectHook' can be used only with the 'redirect' source")
+	}
+
+	if len(pconf.SourcePool) != 0 &&
+		!strings.HasPrefix(pconf.Source, "rtsp://") &&
+		!strings.HasPrefix(pconf.Source, "rtsps://") &&
+		!strings.HasPrefix(pconf.Source, "rtmp://") &&
+		!strings.HasPrefix(pconf.Source, "rtmps://") {
+		return fmt.Errorf("'sourcePool' can be used only with a RTSP or RTMP source")
+	}
+
+	if pconf.SourceRTPPortRange != "" && pconf.SourceRTPPortRangeParsed == nil {
+		return fmt.Errorf("'sourceRTPPortRange' can be used only with a RTSP source")
+	}
+
+	if len(pconf.SourceBackups) != 0 {
+		if !strings.HasPrefix(pconf.Source, "rtsp://") &&
+			!strings.HasPrefix(pconf.Source, "rtsps://") &&
+			!strings.HasPrefix(pconf.Source, "rtmp://") &&
+			!strings.HasPrefix(pconf.Source, "rtmps://") {
+			return fmt.Errorf("'sourceBackups' can be used only with a RTSP or RTMP source")
+		}
+
+		if len(pconf.SourcePool) != 0 {
+			return fmt.Errorf("'sourceBackups' and 'sourcePool' can't be used together")
+		}
+	}
+
+	if pconf.SourceFailbackInterval < 0 {
+		return fmt.Errorf("'sourceFailbackInterval' can't be negative")
+	}
+
+	if pconf.SourceFailbackInterval == 0 {
+		pconf.SourceFailbackInterval = 30 * time.Second
+	}
+
+	if pconf.SourceHealthCheckInterval < 0 {
+		return fmt.Errorf("'sourceHealthCheckInterval' can't be negative")
+	}
+
+	if pconf.SourceHealthCheckInterval != 0 &&
+		!strings.HasPrefix(pconf.Source, "rtsp://") &&
+		!strings.HasPrefix(pconf.Source, "rtsps://") &&
+		!strings.HasPrefix(pconf.Source, "rtmp://") &&
+		!strings.HasPrefix(pconf.Source, "rtmps://") {
+		return fmt.Errorf("'sourceHealthCheckInterval' can be used only with a RTSP or RTMP source")
+	}
+
+	if pconf.RunOnSourceSwitch != "" && len(pconf.SourceBackups) == 0 {
+		return fmt.Errorf("'runOnSourceSwitch' can be used only when 'sourceBackups' is set")
+	}
+
 	if pconf.SourceOnDemand {
 		if pconf.Source == "publisher" {
 			return fmt.Errorf("'sourceOnDemand' is useless when source is 'publisher'")
 		}
 	}
 
+	if pconf.SourceRetryPause < 0 || pconf.SourceRetryMaxPause < 0 {
+		return fmt.Errorf("'sourceRetryPause' and 'sourceRetryMaxPause' can't be negative")
+	}
+
+	if pconf.SourceRetryPause == 0 {
+		pconf.SourceRetryPause = 1 * time.Second
+	}
+
+	if pconf.SourceRetryMaxPause == 0 {
+		pconf.SourceRetryMaxPause = 30 * time.Second
+	}
+
+	if pconf.SourceRetryMaxPause < pconf.SourceRetryPause {
+		return fmt.Errorf("'sourceRetryMaxPause' can't be lower than 'sourceRetryPause'")
+	}
+
 	if pconf.SourceOnDemandStartTimeout == 0 {
 		pconf.SourceOnDemandStartTimeout = 10 * time.Second
 	}
@@ -220,6 +550,31 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 		pconf.SourceOnDemandCloseAfter = 10 * time.Second
 	}
 
+	err := resolveSecretFields([]secretField{
+		{"publishUser", pconf.PublishUser, &pconf.publishUser},
+		{"publishPass", pconf.PublishPass, &pconf.publishPass},
+		{"readUser", pconf.ReadUser, &pconf.readUser},
+		{"readPass", pconf.ReadPass, &pconf.readPass},
+		{"outboundProxy", pconf.OutboundProxy, &pconf.outboundProxy},
+	})
+	if err != nil {
+		return err
+	}
+
+	if pconf.outboundProxy != "" {
+		_, err := proxydialer.New(pconf.outboundProxy, nil)
+		if err != nil {
+			return fmt.Errorf("invalid 'outboundProxy': %s", err)
+		}
+	}
+
+	if pconf.OutboundInterface != "" {
+		_, err := proxydialer.ResolveLocalIP(pconf.OutboundInterface)
+		if err != nil {
+			return fmt.Errorf("invalid 'outboundInterface': %s", err)
+		}
+	}
+
 	if pconf.Fallback != "" {
 		if strings.HasPrefix(pconf.Fallback, "/") {
 			err := CheckPathName(pconf.Fallback[1:])
@@ -235,31 +590,225 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 		}
 	}
 
-	if (pconf.PublishUser != "" && pconf.PublishPass == "") || (pconf.PublishUser == "" && pconf.PublishPass != "") {
+	if len(pconf.HLSVariants) == 0 {
+		pconf.HLSVariants = nil
+	}
+	for _, v := range pconf.HLSVariants {
+		err := CheckPathName(v)
+		if err != nil {
+			return fmt.Errorf("invalid HLS variant '%s': %s", v, err)
+		}
+
+		if v == name {
+			return fmt.Errorf("a path can't be a HLS variant of itself")
+		}
+	}
+
+	if pconf.HLSSegmentCount < 0 {
+		return fmt.Errorf("'hlsSegmentCount' can't be negative")
+	}
+	if pconf.HLSSegmentDuration < 0 {
+		return fmt.Errorf("'hlsSegmentDuration' can't be negative")
+	}
+	if pconf.HLSDVRWindow < 0 {
+		return fmt.Errorf("'hlsDVRWindow' can't be negative")
+	}
+
+	if len(pconf.HLSRenditions) == 0 {
+		pconf.HLSRenditions = nil
+	}
+	pconf.HLSRenditionsParsed = nil
+	for _, v := range pconf.HLSRenditions {
+		parts := strings.Split(v, ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid HLS rendition '%s': must be in format HEIGHT:BITRATE", v)
+		}
+
+		height, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil || height == 0 || (height%2) != 0 {
+			return fmt.Errorf("invalid HLS rendition '%s': height must be a positive even number", v)
+		}
+
+		if !reBitrate.MatchString(parts[1]) {
+			return fmt.Errorf("invalid HLS rendition '%s': invalid bitrate", v)
+		}
+
+		pconf.HLSRenditionsParsed = append(pconf.HLSRenditionsParsed, HLSRendition{
+			Height:  int(height),
+			Bitrate: parts[1],
+		})
+	}
+	if pconf.HLSRenditions != nil {
+		if pconf.Regexp != nil {
+			return fmt.Errorf("a path with a regular expression does not support option 'hlsRenditions'; use another path")
+		}
+		if pconf.HLSRenditionCommand == "" {
+			pconf.HLSRenditionCommand = DefaultHLSRenditionCommand
+		}
+	}
+
+	if pconf.HLSAudioTranscode && pconf.HLSAudioTranscodeCommand == "" {
+		pconf.HLSAudioTranscodeCommand = DefaultHLSAudioTranscodeCommand
+	}
+
+	if pconf.PublisherPolicy == "" {
+		if pconf.DisablePublisherOverride {
+			pconf.PublisherPolicy = "reject"
+		} else {
+			pconf.PublisherPolicy = "takeover"
+		}
+	} else if pconf.DisablePublisherOverride && pconf.PublisherPolicy != "reject" {
+		return fmt.Errorf("'disablePublisherOverride' and 'publisherPolicy' can't be used together; use only 'publisherPolicy'")
+	}
+	switch pconf.PublisherPolicy {
+	case "takeover":
+		pconf.PublisherPolicyParsed = PublisherPolicyTakeover
+
+	case "reject":
+		pconf.PublisherPolicyParsed = PublisherPolicyReject
+
+	case "standby":
+		pconf.PublisherPolicyParsed = PublisherPolicyStandby
+
+	default:
+		return fmt.Errorf("unsupported publisherPolicy value: '%s'", pconf.PublisherPolicy)
+	}
+
+	if len(pconf.PublishCodecs) == 0 {
+		pconf.PublishCodecs = nil
+	}
+	if pconf.PublishCodecs != nil {
+		if pconf.Source != "publisher" {
+			return fmt.Errorf("'publishCodecs' is useless when source is not 'publisher'")
+		}
+
+		for i, codec := range pconf.PublishCodecs {
+			codec = strings.ToLower(codec)
+			if _, ok := supportedCodecs[codec]; !ok {
+				return fmt.Errorf("unsupported codec: '%s'", codec)
+			}
+			pconf.PublishCodecs[i] = codec
+		}
+	}
+
+	if pconf.RTPValidation == "" {
+		pconf.RTPValidation = "no"
+	}
+	switch pconf.RTPValidation {
+	case "no", "false":
+		pconf.RTPValidationParsed = RTPValidationNo
+
+	case "flag":
+		pconf.RTPValidationParsed = RTPValidationFlag
+
+	case "drop":
+		pconf.RTPValidationParsed = RTPValidationDrop
+
+	default:
+		return fmt.Errorf("unsupported rtpValidation value: '%s'", pconf.RTPValidation)
+	}
+
+	if len(pconf.RTPHeaderExtensions) == 0 {
+		pconf.RTPHeaderExtensions = nil
+	}
+	pconf.RTPHeaderExtensionsParsed = nil
+	for _, v := range pconf.RTPHeaderExtensions {
+		id, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || id == 0 {
+			return fmt.Errorf("invalid RTP header extension ID: '%s'", v)
+		}
+		pconf.RTPHeaderExtensionsParsed = append(pconf.RTPHeaderExtensionsParsed, int(id))
+	}
+
+	if pconf.ReaderStart == "" {
+		pconf.ReaderStart = "live"
+	}
+	switch pconf.ReaderStart {
+	case "live":
+		pconf.ReaderStartParsed = ReaderStartLive
+
+	case "keyframe":
+		pconf.ReaderStartParsed = ReaderStartKeyframe
+
+	default:
+		return fmt.Errorf("unsupported readerStart value: '%s'", pconf.ReaderStart)
+	}
+
+	if pconf.PauseBehavior == "" {
+		pconf.PauseBehavior = "freeze"
+	}
+	switch pconf.PauseBehavior {
+	case "freeze":
+		pconf.PauseBehaviorParsed = PauseBehaviorFreeze
+
+	case "close":
+		pconf.PauseBehaviorParsed = PauseBehaviorClose
+
+	default:
+		return fmt.Errorf("unsupported pauseBehavior value: '%s'", pconf.PauseBehavior)
+	}
+
+	if pconf.MPEGTSMulticastAddress != "" {
+		host, _, err := net.SplitHostPort(pconf.MPEGTSMulticastAddress)
+		if err != nil {
+			return fmt.Errorf("invalid mpegtsMulticastAddress: %s", err)
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+			return fmt.Errorf("'%s' is not a IPv4 multicast address", host)
+		}
+	}
+
+	if pconf.MPEGTSMulticastTTL < 0 || pconf.MPEGTSMulticastTTL > 255 {
+		return fmt.Errorf("mpegtsMulticastTTL must be between 0 and 255")
+	}
+
+	for _, ur := range pconf.PushTargets {
+		u, err := url.Parse(ur)
+		if err != nil || (u.Scheme != "rtmp" && u.Scheme != "rtmps") || u.Host == "" {
+			return fmt.Errorf("'%s' is not a valid RTMP URL", ur)
+		}
+	}
+
+	// metadata is inserted into SDP and playlists, that are line-based.
+	if strings.ContainsAny(pconf.Title, "\r\n") {
+		return fmt.Errorf("title can't contain line breaks")
+	}
+	if strings.ContainsAny(pconf.Description, "\r\n") {
+		return fmt.Errorf("description can't contain line breaks")
+	}
+
+	if (pconf.publishUser != "" && pconf.publishPass == "") || (pconf.publishUser == "" && pconf.publishPass != "") {
 		return fmt.Errorf("read username and password must be both filled")
 	}
-	if pconf.PublishUser != "" {
+	if pconf.publishUser != "" {
 		if pconf.Source != "publisher" {
 			return fmt.Errorf("'publishUser' is useless when source is not 'publisher'")
 		}
 
-		if !strings.HasPrefix(pconf.PublishUser, "sha256:") && !reUserPass.MatchString(pconf.PublishUser) {
+		if !credential.IsHashed(pconf.publishUser) && !reUserPass.MatchString(pconf.publishUser) {
 			return fmt.Errorf("publish username contains unsupported characters (supported are %s)", userPassSupportedChars)
 		}
+		if err := credential.Validate(pconf.publishUser); err != nil {
+			return fmt.Errorf("publish username: %s", err)
+		}
 	}
-	if pconf.PublishPass != "" {
+	if pconf.publishPass != "" {
 		if pconf.Source != "publisher" {
 			return fmt.Errorf("'publishPass' is useless when source is not 'publisher', since the stream is not provided by a publisher, but by a fixed source")
 		}
 
-		if !strings.HasPrefix(pconf.PublishPass, "sha256:") && !reUserPass.MatchString(pconf.PublishPass) {
+		if !credential.IsHashed(pconf.publishPass) && !reUserPass.MatchString(pconf.publishPass) {
 			return fmt.Errorf("publish password contains unsupported characters (supported are %s)", userPassSupportedChars)
 		}
+		if err := credential.Validate(pconf.publishPass); err != nil {
+			return fmt.Errorf("publish password: %s", err)
+		}
 	}
 	if len(pconf.PublishIPs) == 0 {
 		pconf.PublishIPs = nil
 	}
-	var err error
 	pconf.PublishIPsParsed, err = func() ([]interface{}, error) {
 		if len(pconf.PublishIPs) == 0 {
 			return nil, nil
@@ -275,18 +824,24 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 		return err
 	}
 
-	if (pconf.ReadUser != "" && pconf.ReadPass == "") || (pconf.ReadUser == "" && pconf.ReadPass != "") {
+	if (pconf.readUser != "" && pconf.readPass == "") || (pconf.readUser == "" && pconf.readPass != "") {
 		return fmt.Errorf("read username and password must be both filled")
 	}
-	if pconf.ReadUser != "" {
-		if !strings.HasPrefix(pconf.ReadUser, "sha256:") && !reUserPass.MatchString(pconf.ReadUser) {
+	if pconf.readUser != "" {
+		if !credential.IsHashed(pconf.readUser) && !reUserPass.MatchString(pconf.readUser) {
 			return fmt.Errorf("read username contains unsupported characters (supported are %s)", userPassSupportedChars)
 		}
+		if err := credential.Validate(pconf.readUser); err != nil {
+			return fmt.Errorf("read username: %s", err)
+		}
 	}
-	if pconf.ReadPass != "" {
-		if !strings.HasPrefix(pconf.ReadPass, "sha256:") && !reUserPass.MatchString(pconf.ReadPass) {
+	if pconf.readPass != "" {
+		if !credential.IsHashed(pconf.readPass) && !reUserPass.MatchString(pconf.readPass) {
 			return fmt.Errorf("read password contains unsupported characters (supported are %s)", userPassSupportedChars)
 		}
+		if err := credential.Validate(pconf.readPass); err != nil {
+			return fmt.Errorf("read password: %s", err)
+		}
 	}
 	if len(pconf.ReadIPs) == 0 {
 		pconf.ReadIPs = nil
@@ -298,6 +853,13 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 		return err
 	}
 
+	if len(pconf.PublishClientCNs) == 0 {
+		pconf.PublishClientCNs = nil
+	}
+	if len(pconf.ReadClientCNs) == 0 {
+		pconf.ReadClientCNs = nil
+	}
+
 	if pconf.RunOnInit != "" && pconf.Regexp != nil {
 		return fmt.Errorf("a path with a regular expression does not support option 'runOnInit'; use another path")
 	}
@@ -310,6 +872,48 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 		return fmt.Errorf("'runOnDemand' can be used only when source is 'publisher'")
 	}
 
+	if pconf.OnDemandForwardQuery && !pconf.SourceOnDemand && pconf.RunOnDemand == "" {
+		return fmt.Errorf("'onDemandForwardQuery' can be used only when 'sourceOnDemand' or 'runOnDemand' are set")
+	}
+
+	if pconf.MaxPublishDuration != 0 && pconf.Source != "publisher" {
+		return fmt.Errorf("'maxPublishDuration' can be used only when source is 'publisher'")
+	}
+
+	if pconf.IdleCloseAfter != 0 && pconf.Source != "publisher" {
+		return fmt.Errorf("'idleCloseAfter' can be used only when source is 'publisher'")
+	}
+
+	if pconf.PublisherGracePeriod < 0 {
+		return fmt.Errorf("'publisherGracePeriod' can't be negative")
+	}
+
+	if pconf.PublisherGracePeriod != 0 {
+		if pconf.Source != "publisher" {
+			return fmt.Errorf("'publisherGracePeriod' can be used only when source is 'publisher'")
+		}
+
+		// on-demand publishers are stopped by the server, not by a network failure.
+		if pconf.RunOnDemand != "" {
+			return fmt.Errorf("'publisherGracePeriod' and 'runOnDemand' can't be used together")
+		}
+	}
+
+	if pconf.ReadTimeout < 0 {
+		return fmt.Errorf("'readTimeout' can't be negative")
+	}
+
+	if pconf.WriteTimeout < 0 {
+		return fmt.Errorf("'writeTimeout' can't be negative")
+	}
+
+	// sessions of RTSP readers are closed by the server after 60 seconds
+	// without requests, therefore clients can't be asked to wait more.
+	if pconf.SessionTimeout != 0 &&
+		(pconf.SessionTimeout < time.Second || pconf.SessionTimeout > 60*time.Second) {
+		return fmt.Errorf("'sessionTimeout' must be between 1s and 60s")
+	}
+
 	if pconf.RunOnDemandStartTimeout == 0 {
 		pconf.RunOnDemandStartTimeout = 10 * time.Second
 	}
@@ -321,8 +925,43 @@ func (pconf *PathConf) checkAndFillMissing(name string) error {
 	return nil
 }
 
+// ResolvedPublishUser returns publishUser, with secret references resolved.
+func (pconf *PathConf) ResolvedPublishUser() string {
+	return pconf.publishUser
+}
+
+// ResolvedPublishPass returns publishPass, with secret references resolved.
+func (pconf *PathConf) ResolvedPublishPass() string {
+	return pconf.publishPass
+}
+
+// ResolvedReadUser returns readUser, with secret references resolved.
+func (pconf *PathConf) ResolvedReadUser() string {
+	return pconf.readUser
+}
+
+// ResolvedReadPass returns readPass, with secret references resolved.
+func (pconf *PathConf) ResolvedReadPass() string {
+	return pconf.readPass
+}
+
+// ResolvedOutboundProxy returns outboundProxy, with secret references resolved.
+func (pconf *PathConf) ResolvedOutboundProxy() string {
+	return pconf.outboundProxy
+}
+
 // Equal checks whether two PathConfs are equal.
+// Resolved secrets are compared too, since they can change while
+// their references stay the same.
 func (pconf *PathConf) Equal(other *PathConf) bool {
+	if pconf.publishUser != other.publishUser ||
+		pconf.publishPass != other.publishPass ||
+		pconf.readUser != other.readUser ||
+		pconf.readPass != other.readPass ||
+		pconf.outboundProxy != other.outboundProxy {
+		return false
+	}
+
 	a, _ := json.Marshal(pconf)
 	b, _ := json.Marshal(other)
 	return string(a) == string(b)
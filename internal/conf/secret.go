@@ -0,0 +1,191 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	secretPrefixFile  = "file:"
+	secretPrefixEnv   = "env:"
+	secretPrefixVault = "vault:"
+
+	vaultRequestTimeout = 10 * time.Second
+
+	// secrets read from Vault are refreshed in background after this period.
+	vaultCacheTTL = 5 * time.Minute
+)
+
+type vaultCacheEntry struct {
+	value      string
+	fetched    time.Time
+	refreshing bool
+}
+
+// secrets are read from Vault every time the configuration is checked,
+// that happens on every reload and on every configuration change performed
+// through the API. In order not to block these operations, only the first
+// read of a secret is synchronous, while the following ones are served
+// from a cache that is refreshed in background.
+var (
+	vaultCacheMutex sync.Mutex
+	vaultCache      = make(map[string]*vaultCacheEntry)
+)
+
+func readVaultSecret(ref string) (string, error) {
+	i := strings.LastIndexByte(ref, '#')
+	if i < 0 {
+		return "", fmt.Errorf("vault reference must be in the format 'path#key'")
+	}
+	secretPath, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+secretPath, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+
+	hc := &http.Client{Timeout: vaultRequestTimeout}
+	res, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+
+	data := body.Data
+
+	// KV version 2 nests values inside another data object
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	v, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("key '%s' not found", key)
+	}
+
+	return v, nil
+}
+
+func readVaultSecretCached(ref string) (string, error) {
+	key := os.Getenv("VAULT_ADDR") + "\x00" + ref
+
+	vaultCacheMutex.Lock()
+	e, ok := vaultCache[key]
+	if ok {
+		if !e.refreshing && time.Since(e.fetched) >= vaultCacheTTL {
+			e.refreshing = true
+			go refreshVaultSecret(ref, e)
+		}
+		v := e.value
+		vaultCacheMutex.Unlock()
+		return v, nil
+	}
+	vaultCacheMutex.Unlock()
+
+	v, err := readVaultSecret(ref)
+	if err != nil {
+		return "", err
+	}
+
+	vaultCacheMutex.Lock()
+	vaultCache[key] = &vaultCacheEntry{
+		value:   v,
+		fetched: time.Now(),
+	}
+	vaultCacheMutex.Unlock()
+
+	return v, nil
+}
+
+func refreshVaultSecret(ref string, e *vaultCacheEntry) {
+	// in case of errors, the previous value is kept
+	v, err := readVaultSecret(ref)
+
+	vaultCacheMutex.Lock()
+	defer vaultCacheMutex.Unlock()
+
+	if err == nil {
+		e.value = v
+		e.fetched = time.Now()
+	}
+	e.refreshing = false
+}
+
+// resolveSecret resolves a credential that references a file ("file:"),
+// an environment variable ("env:") or a HashiCorp Vault secret ("vault:").
+// Other values are returned as they are.
+func resolveSecret(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, secretPrefixFile):
+		byts, err := ioutil.ReadFile(strings.TrimPrefix(v, secretPrefixFile))
+		if err != nil {
+			return "", err
+		}
+
+		// files created by editors and Kubernetes often end with a newline
+		return strings.TrimRight(string(byts), "\r\n"), nil
+
+	case strings.HasPrefix(v, secretPrefixEnv):
+		name := strings.TrimPrefix(v, secretPrefixEnv)
+		ret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable '%s' is not set", name)
+		}
+		return ret, nil
+
+	case strings.HasPrefix(v, secretPrefixVault):
+		ret, err := readVaultSecretCached(strings.TrimPrefix(v, secretPrefixVault))
+		if err != nil {
+			return "", fmt.Errorf("unable to read secret from Vault: %s", err)
+		}
+		return ret, nil
+	}
+
+	return v, nil
+}
+
+// secretField is a configuration field that can contain a secret reference.
+// The reference is kept in the field, while the resolved value is stored
+// into dest.
+type secretField struct {
+	name string
+	v    string
+	dest *string
+}
+
+func resolveSecretFields(fields []secretField) error {
+	for _, f := range fields {
+		v, err := resolveSecret(f.v)
+		if err != nil {
+			return fmt.Errorf("unable to resolve '%s': %s", f.name, err)
+		}
+		*f.dest = v
+	}
+	return nil
+}
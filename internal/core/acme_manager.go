@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type acmeManagerParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// acmeManager obtains and renews certificates with the ACME protocol,
+// using the HTTP-01 challenge, that is served by a dedicated listener,
+// since providers send challenges to port 80 only.
+type acmeManager struct {
+	defaultDomain string
+	parent        acmeManagerParent
+
+	m        *autocert.Manager
+	listener net.Listener
+	server   *http.Server
+}
+
+func newACMEManager(
+	address string,
+	domains []string,
+	email string,
+	directoryURL string,
+	cacheDir string,
+	parent acmeManagerParent,
+) (*acmeManager, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+		Client:     &acme.Client{DirectoryURL: directoryURL},
+	}
+
+	am := &acmeManager{
+		defaultDomain: domains[0],
+		parent:        parent,
+		m:             m,
+		listener:      listener,
+	}
+
+	// requests that are not challenges are not redirected to HTTPS,
+	// since HTTPS is not served on port 443.
+	am.server = &http.Server{
+		Handler: m.HTTPHandler(http.NotFoundHandler()),
+	}
+
+	am.Log(logger.Info, "challenges are served on %s, certificates of %s are managed with %s",
+		address, strings.Join(domains, ", "), directoryURL)
+
+	go am.run()
+
+	return am, nil
+}
+
+func (am *acmeManager) close() {
+	am.server.Shutdown(context.Background())
+}
+
+func (am *acmeManager) run() {
+	err := am.server.Serve(am.listener)
+	if err != http.ErrServerClosed {
+		panic(err)
+	}
+}
+
+// Log is the main logging function.
+func (am *acmeManager) Log(level logger.Level, format string, args ...interface{}) {
+	am.parent.Log(level, "[ACME] "+format, args...)
+}
+
+func (am *acmeManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// RTSP clients often connect with an IP and don't send a server name
+	if hello.ServerName == "" {
+		h := *hello
+		h.ServerName = am.defaultDomain
+		hello = &h
+	}
+
+	cert, err := am.m.GetCertificate(hello)
+	if err != nil {
+		am.Log(logger.Warn, "unable to get certificate for '%s': %s", hello.ServerName, err)
+		return nil, err
+	}
+
+	return cert, nil
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestACMEManagerChallenge(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "rtsp-acme")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	p, ok := newInstance("acme: yes\n" +
+		"acmeAddress: :9080\n" +
+		"acmeDomains: [stream.example.com]\n" +
+		"acmeDirectoryURL: http://127.0.0.1:9/directory\n" +
+		"acmeCacheDir: " + cacheDir + "\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	for _, ca := range []struct {
+		name string
+		host string
+		path string
+		code int
+	}{
+		{"challenge", "stream.example.com", "/.well-known/acme-challenge/testtoken", http.StatusNotFound},
+		{"challenge other host", "other.example.com", "/.well-known/acme-challenge/testtoken", http.StatusForbidden},
+		{"other path", "stream.example.com", "/test", http.StatusNotFound},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://localhost:9080"+ca.path, nil)
+			require.NoError(t, err)
+			req.Host = ca.host
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			require.Equal(t, ca.code, res.StatusCode)
+		})
+	}
+}
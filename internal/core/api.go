@@ -9,13 +9,16 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/hls"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpstats"
 )
 
 func interfaceIsEmpty(i interface{}) bool {
@@ -44,53 +47,129 @@ func cloneStruct(dest interface{}, source interface{}) {
 	_ = json.Unmarshal(enc, source)
 }
 
-func loadConfData(ctx *gin.Context) (interface{}, error) {
+func loadConfData(ctx *gin.Context, strict bool) (interface{}, error) {
 	var in struct {
 		// general
-		LogLevel            *string        `json:"logLevel"`
-		LogDestinations     *[]string      `json:"logDestinations"`
-		LogFile             *string        `json:"logFile"`
-		ReadTimeout         *time.Duration `json:"readTimeout"`
-		WriteTimeout        *time.Duration `json:"writeTimeout"`
-		ReadBufferCount     *int           `json:"readBufferCount"`
-		API                 *bool          `json:"api"`
-		APIAddress          *string        `json:"apiAddress"`
-		Metrics             *bool          `json:"metrics"`
-		MetricsAddress      *string        `json:"metricsAddress"`
-		PPROF               *bool          `json:"pprof"`
-		PPROFAddress        *string        `json:"pprofAddress"`
-		RunOnConnect        *string        `json:"runOnConnect"`
-		RunOnConnectRestart *bool          `json:"runOnConnectRestart"`
+		LogLevel                     *string        `json:"logLevel"`
+		LogDestinations              *[]string      `json:"logDestinations"`
+		LogFile                      *string        `json:"logFile"`
+		ReadTimeout                  *time.Duration `json:"readTimeout"`
+		WriteTimeout                 *time.Duration `json:"writeTimeout"`
+		ReadBufferCount              *int           `json:"readBufferCount"`
+		API                          *bool          `json:"api"`
+		APIAddress                   *string        `json:"apiAddress"`
+		APIAuditLog                  *bool          `json:"apiAuditLog"`
+		APIAuditLogFile              *string        `json:"apiAuditLogFile"`
+		APIOIDC                      *bool          `json:"apiOIDC"`
+		BandwidthAccounting          *bool          `json:"bandwidthAccounting"`
+		BandwidthAccountingFile      *string        `json:"bandwidthAccountingFile"`
+		BandwidthAccountingBucket    *time.Duration `json:"bandwidthAccountingBucket"`
+		BandwidthAccountingRetention *time.Duration `json:"bandwidthAccountingRetention"`
+		Metrics                      *bool          `json:"metrics"`
+		MetricsAddress               *string        `json:"metricsAddress"`
+		PPROF                        *bool          `json:"pprof"`
+		PPROFAddress                 *string        `json:"pprofAddress"`
+		RunOnConnect                 *string        `json:"runOnConnect"`
+		RunOnConnectRestart          *bool          `json:"runOnConnectRestart"`
+		OIDCIssuer                   *string        `json:"oidcIssuer"`
+		OIDCClientID                 *string        `json:"oidcClientID"`
+		OIDCClientSecret             *string        `json:"oidcClientSecret"`
+		ACME                         *bool          `json:"acme"`
+		ACMEAddress                  *string        `json:"acmeAddress"`
+		ACMEDomains                  *[]string      `json:"acmeDomains"`
+		ACMEEmail                    *string        `json:"acmeEmail"`
+		ACMEDirectoryURL             *string        `json:"acmeDirectoryURL"`
+		ACMECacheDir                 *string        `json:"acmeCacheDir"`
+		OutboundProxy                *string        `json:"outboundProxy"`
+		OutboundInterface            *string        `json:"outboundInterface"`
 
 		// rtsp
-		RTSPDisable       *bool     `json:"rtspDisable"`
-		Protocols         *[]string `json:"protocols"`
-		Encryption        *string   `json:"encryption"`
-		RTSPAddress       *string   `json:"rtspAddress"`
-		RTSPSAddress      *string   `json:"rtspsAddress"`
-		RTPAddress        *string   `json:"rtpAddress"`
-		RTCPAddress       *string   `json:"rtcpAddress"`
-		MulticastIPRange  *string   `json:"multicastIPRange"`
-		MulticastRTPPort  *int      `json:"multicastRTPPort"`
-		MulticastRTCPPort *int      `json:"multicastRTCPPort"`
-		ServerKey         *string   `json:"serverKey"`
-		ServerCert        *string   `json:"serverCert"`
-		AuthMethods       *[]string `json:"authMethods"`
-		ReadBufferSize    *int      `json:"readBufferSize"`
+		RTSPDisable          *bool     `json:"rtspDisable"`
+		Protocols            *[]string `json:"protocols"`
+		ForceTCPIPs          *[]string `json:"forceTCPIPs"`
+		ForceUDPIPs          *[]string `json:"forceUDPIPs"`
+		Encryption           *string   `json:"encryption"`
+		RTSPAddress          *string   `json:"rtspAddress"`
+		RTSPSAddress         *string   `json:"rtspsAddress"`
+		RTSPWebSocket        *bool     `json:"rtspWebSocket"`
+		RTSPWebSocketAddress *string   `json:"rtspWebSocketAddress"`
+		RTPAddress           *string   `json:"rtpAddress"`
+		RTCPAddress          *string   `json:"rtcpAddress"`
+		MulticastIPRange     *string   `json:"multicastIPRange"`
+		MulticastRTPPort     *int      `json:"multicastRTPPort"`
+		MulticastRTCPPort    *int      `json:"multicastRTCPPort"`
+		MulticastTTL         *int      `json:"multicastTTL"`
+		SourceRTPPortRange   *string   `json:"sourceRTPPortRange"`
+		ServerKey            *string   `json:"serverKey"`
+		ServerCert           *string   `json:"serverCert"`
+		ClientCA             *string   `json:"clientCA"`
+		ClientCertRequired   *bool     `json:"clientCertRequired"`
+		AuthMethods          *[]string `json:"authMethods"`
+		ReadBufferSize       *int      `json:"readBufferSize"`
 
 		// rtmp
-		RTMPDisable *bool   `json:"rtmpDisable"`
-		RTMPAddress *string `json:"rtmpAddress"`
+		RTMPDisable             *bool     `json:"rtmpDisable"`
+		RTMPEncryption          *string   `json:"rtmpEncryption"`
+		RTMPAddress             *string   `json:"rtmpAddress"`
+		RTMPSAddress            *string   `json:"rtmpsAddress"`
+		RTMPServerKey           *string   `json:"rtmpServerKey"`
+		RTMPServerCert          *string   `json:"rtmpServerCert"`
+		RTMPClientCA            *string   `json:"rtmpClientCA"`
+		RTMPClientCertRequired  *bool     `json:"rtmpClientCertRequired"`
+		RTMPStreamKeyURL        *string   `json:"rtmpStreamKeyURL"`
+		RTMPAdditionalAddresses *[]string `json:"rtmpAdditionalAddresses"`
 
 		// hls
-		HLSDisable         *bool          `json:"hlsDisable"`
-		HLSAddress         *string        `json:"hlsAddress"`
-		HLSAlwaysRemux     *bool          `json:"hlsAlwaysRemux"`
-		HLSSegmentCount    *int           `json:"hlsSegmentCount"`
-		HLSSegmentDuration *time.Duration `json:"hlsSegmentDuration"`
-		HLSAllowOrigin     *string        `json:"hlsAllowOrigin"`
+		HLSDisable              *bool          `json:"hlsDisable"`
+		HLSAddress              *string        `json:"hlsAddress"`
+		HLSSocketMode           *string        `json:"hlsSocketMode"`
+		HLSTLS                  *bool          `json:"hlsTLS"`
+		HLSServerKey            *string        `json:"hlsServerKey"`
+		HLSServerCert           *string        `json:"hlsServerCert"`
+		HLSAlwaysRemux          *bool          `json:"hlsAlwaysRemux"`
+		HLSRemuxerCloseAfter    *time.Duration `json:"hlsRemuxerCloseAfter"`
+		HLSWriteTimeout         *time.Duration `json:"hlsWriteTimeout"`
+		HLSMaxConnections       *int           `json:"hlsMaxConnections"`
+		HLSRequestRateLimit     *int           `json:"hlsRequestRateLimit"`
+		HLSSessions             *bool          `json:"hlsSessions"`
+		HLSSessionTimeout       *time.Duration `json:"hlsSessionTimeout"`
+		HLSEndList              *bool          `json:"hlsEndList"`
+		HLSSegmentCount         *int           `json:"hlsSegmentCount"`
+		HLSSegmentDuration      *time.Duration `json:"hlsSegmentDuration"`
+		HLSLowLatency           *bool          `json:"hlsLowLatency"`
+		HLSPartDuration         *time.Duration `json:"hlsPartDuration"`
+		HLSSegmentFormat        *string        `json:"hlsSegmentFormat"`
+		HLSPlaylistName         *string        `json:"hlsPlaylistName"`
+		HLSSegmentName          *string        `json:"hlsSegmentName"`
+		HLSEncryption           *string        `json:"hlsEncryption"`
+		HLSEncryptionKey        *string        `json:"hlsEncryptionKey"`
+		HLSEncryptionKeyURI     *string        `json:"hlsEncryptionKeyURI"`
+		HLSAllowOrigin          *string        `json:"hlsAllowOrigin"`
+		HLSAllowOrigins         *[]string      `json:"hlsAllowOrigins"`
+		HLSBasePath             *string        `json:"hlsBasePath"`
+		HLSTrustedProxies       *[]string      `json:"hlsTrustedProxies"`
+		HLSPlaylistCacheControl *string        `json:"hlsPlaylistCacheControl"`
+		HLSSegmentCacheControl  *string        `json:"hlsSegmentCacheControl"`
+		HLSPlayerDisable        *bool          `json:"hlsPlayerDisable"`
+		HLSPlayerScriptURL      *string        `json:"hlsPlayerScriptURL"`
+
+		// dash
+		DASH                *bool          `json:"dash"`
+		DASHAddress         *string        `json:"dashAddress"`
+		DASHMuxerCloseAfter *time.Duration `json:"dashMuxerCloseAfter"`
+		DASHSegmentCount    *int           `json:"dashSegmentCount"`
+		DASHSegmentDuration *time.Duration `json:"dashSegmentDuration"`
+		DASHAllowOrigin     *string        `json:"dashAllowOrigin"`
+
+		// onvif
+		ONVIF        *bool   `json:"onvif"`
+		ONVIFAddress *string `json:"onvifAddress"`
 	}
-	err := json.NewDecoder(ctx.Request.Body).Decode(&in)
+	dec := json.NewDecoder(ctx.Request.Body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(&in)
 	if err != nil {
 		return nil, err
 	}
@@ -104,21 +183,76 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 		Source                     *string        `json:"source"`
 		SourceProtocol             *string        `json:"sourceProtocol"`
 		SourceAnyPortEnable        *bool          `json:"sourceAnyPortEnable"`
+		SourceRTPPortRange         *string        `json:"sourceRTPPortRange"`
 		SourceFingerprint          *string        `json:"sourceFingerprint"`
 		SourceOnDemand             *bool          `json:"sourceOnDemand"`
 		SourceOnDemandStartTimeout *time.Duration `json:"sourceOnDemandStartTimeout"`
 		SourceOnDemandCloseAfter   *time.Duration `json:"sourceOnDemandCloseAfter"`
+		OnDemandRetryAfter         *time.Duration `json:"onDemandRetryAfter"`
+		OnDemandForwardQuery       *bool          `json:"onDemandForwardQuery"`
 		SourceRedirect             *string        `json:"sourceRedirect"`
+		SourceRedirectPool         *[]string      `json:"sourceRedirectPool"`
+		SourceRedirectHook         *string        `json:"sourceRedirectHook"`
+		SourceFile                 *string        `json:"sourceFile"`
+		SourcePool                 *[]string      `json:"sourcePool"`
+		SourceBackups              *[]string      `json:"sourceBackups"`
+		SourceFailbackInterval     *time.Duration `json:"sourceFailbackInterval"`
+		SourceHealthCheckInterval  *time.Duration `json:"sourceHealthCheckInterval"`
+		SourceRetryPause           *time.Duration `json:"sourceRetryPause"`
+		SourceRetryMaxPause        *time.Duration `json:"sourceRetryMaxPause"`
+		OutboundProxy              *string        `json:"outboundProxy"`
+		OutboundInterface          *string        `json:"outboundInterface"`
+		TestPatternResolution      *string        `json:"testPatternResolution"`
+		TestPatternFPS             *int           `json:"testPatternFPS"`
+		TestPatternBitrate         *int           `json:"testPatternBitrate"`
 		DisablePublisherOverride   *bool          `json:"disablePublisherOverride"`
+		PublisherPolicy            *string        `json:"publisherPolicy"`
+		PublishCodecs              *[]string      `json:"publishCodecs"`
+		RTPValidation              *string        `json:"rtpValidation"`
+		RTPHeaderExtensions        *[]string      `json:"rtpHeaderExtensions"`
 		Fallback                   *string        `json:"fallback"`
+		HLSDisable                 *bool          `json:"hlsDisable"`
+		HLSVariants                *[]string      `json:"hlsVariants"`
+		HLSSegmentCount            *int           `json:"hlsSegmentCount"`
+		HLSSegmentDuration         *time.Duration `json:"hlsSegmentDuration"`
+		HLSDVRWindow               *time.Duration `json:"hlsDVRWindow"`
+		HLSDirectory               *string        `json:"hlsDirectory"`
+		HLSAudioOnlyVariant        *bool          `json:"hlsAudioOnlyVariant"`
+		HLSRenditions              *[]string      `json:"hlsRenditions"`
+		HLSRenditionCommand        *string        `json:"hlsRenditionCommand"`
+		HLSAudioTranscode          *bool          `json:"hlsAudioTranscode"`
+		HLSAudioTranscodeCommand   *string        `json:"hlsAudioTranscodeCommand"`
+		LatencyProbe               *bool          `json:"latencyProbe"`
+		ReaderStart                *string        `json:"readerStart"`
+		PauseBehavior              *string        `json:"pauseBehavior"`
+		KeyframeRequest            *bool          `json:"keyframeRequest"`
+		MPEGTSMulticastAddress     *string        `json:"mpegtsMulticastAddress"`
+		MPEGTSMulticastTTL         *int           `json:"mpegtsMulticastTTL"`
+		MPEGTSMulticastInterface   *string        `json:"mpegtsMulticastInterface"`
+		MPEGTSMulticastSAP         *bool          `json:"mpegtsMulticastSAP"`
+		PushTargets                *[]string      `json:"pushTargets"`
+		Title                      *string        `json:"title"`
+		Description                *string        `json:"description"`
+		SDPPassthrough             *bool          `json:"sdpPassthrough"`
+		SRTP                       *bool          `json:"srtp"`
+		MaxPublishDuration         *time.Duration `json:"maxPublishDuration"`
+		IdleCloseAfter             *time.Duration `json:"idleCloseAfter"`
+		PublisherGracePeriod       *time.Duration `json:"publisherGracePeriod"`
+		ReadTimeout                *time.Duration `json:"readTimeout"`
+		WriteTimeout               *time.Duration `json:"writeTimeout"`
+		SessionTimeout             *time.Duration `json:"sessionTimeout"`
 
 		// authentication
-		PublishUser *string   `json:"publishUser"`
-		PublishPass *string   `json:"publishPass"`
-		PublishIPs  *[]string `json:"publishIPs"`
-		ReadUser    *string   `json:"readUser"`
-		ReadPass    *string   `json:"readPass"`
-		ReadIPs     *[]string `json:"readIPs"`
+		PublishUser      *string   `json:"publishUser"`
+		PublishPass      *string   `json:"publishPass"`
+		PublishIPs       *[]string `json:"publishIPs"`
+		PublishClientCNs *[]string `json:"publishClientCNs"`
+		ReadUser         *string   `json:"readUser"`
+		ReadPass         *string   `json:"readPass"`
+		ReadIPs          *[]string `json:"readIPs"`
+		ReadClientCNs    *[]string `json:"readClientCNs"`
+		ReadOIDC         *bool     `json:"readOIDC"`
+		ReadOIDCUsers    *[]string `json:"readOIDCUsers"`
 
 		// custom commands
 		RunOnInit               *string        `json:"runOnInit"`
@@ -131,6 +265,8 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 		RunOnPublishRestart     *bool          `json:"runOnPublishRestart"`
 		RunOnRead               *string        `json:"runOnRead"`
 		RunOnReadRestart        *bool          `json:"runOnReadRestart"`
+		RunOnLimit              *string        `json:"runOnLimit"`
+		RunOnSourceSwitch       *string        `json:"runOnSourceSwitch"`
 	}
 	err := json.NewDecoder(ctx.Request.Body).Decode(&in)
 	if err != nil {
@@ -140,12 +276,30 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 	return in, err
 }
 
+// apiPathsLatency contains latency percentiles, in milliseconds.
+type apiPathsLatency struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+func durationMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 type apiPathsItem struct {
-	ConfName    string         `json:"confName"`
-	Conf        *conf.PathConf `json:"conf"`
-	Source      interface{}    `json:"source"`
-	SourceReady bool           `json:"sourceReady"`
-	Readers     []interface{}  `json:"readers"`
+	ConfName             string                     `json:"confName"`
+	Conf                 *conf.PathConf             `json:"conf"`
+	Source               interface{}                `json:"source"`
+	SourceReady          bool                       `json:"sourceReady"`
+	SourceHealth         []sourceHealthStatus       `json:"sourceHealth,omitempty"`
+	PushTargets          []rtmpPusherStatus         `json:"pushTargets,omitempty"`
+	Metadata             map[string]string          `json:"metadata,omitempty"`
+	Paused               bool                       `json:"paused"`
+	Readers              []interface{}              `json:"readers"`
+	MalformedRTPPackets  int64                      `json:"malformedRTPPackets"`
+	MalformedRTCPPackets int64                      `json:"malformedRTCPPackets"`
+	Latency              map[string]apiPathsLatency `json:"latency,omitempty"`
 }
 
 type apiPathsListData struct {
@@ -167,9 +321,26 @@ type apiPathsListReq2 struct {
 	Res  chan struct{}
 }
 
+type apiPathsPauseRes struct {
+	Path *path
+	Err  error
+}
+
+type apiPathsPauseReq struct {
+	Name   string
+	Paused bool
+	Res    chan apiPathsPauseRes
+}
+
+type apiRTSPSessionsListTrack struct {
+	ID int `json:"id"`
+	rtcpstats.Stats
+}
+
 type apiRTSPSessionsListItem struct {
-	RemoteAddr string `json:"remoteAddr"`
-	State      string `json:"state"`
+	RemoteAddr string                     `json:"remoteAddr"`
+	State      string                     `json:"state"`
+	Tracks     []apiRTSPSessionsListTrack `json:"tracks"`
 }
 
 type apiRTSPSessionsListData struct {
@@ -218,8 +389,59 @@ type apiRTMPConnsKickReq struct {
 	Res chan apiRTMPConnsKickRes
 }
 
+type apiIPsListItem struct {
+	Conns         int64 `json:"conns"`
+	BytesReceived int64 `json:"bytesReceived"`
+	BytesSent     int64 `json:"bytesSent"`
+	AuthFailures  int64 `json:"authFailures"`
+}
+
+type apiIPsListData struct {
+	Items map[string]apiIPsListItem `json:"items"`
+}
+
+type apiHLSViewersListClient struct {
+	IP          string    `json:"ip"`
+	BytesSent   int64     `json:"bytesSent"`
+	LastRequest time.Time `json:"lastRequest"`
+}
+
+type apiHLSViewersListItem struct {
+	Viewers     int                                `json:"viewers"`
+	BytesSent   int64                              `json:"bytesSent"`
+	LastRequest time.Time                          `json:"lastRequest"`
+	Clients     map[string]apiHLSViewersListClient `json:"clients"`
+}
+
+type apiHLSViewersListData struct {
+	Items map[string]apiHLSViewersListItem `json:"items"`
+}
+
+type apiHLSRemuxersMetadataRes struct {
+	Err error
+}
+
+type apiHLSRemuxersMetadataReq struct {
+	Name string
+	Tag  []byte
+	Res  chan apiHLSRemuxersMetadataRes
+}
+
+type apiBandwidthListItem struct {
+	Time          time.Time `json:"time"`
+	Path          string    `json:"path"`
+	User          string    `json:"user"`
+	BytesReceived int64     `json:"bytesReceived"`
+	BytesSent     int64     `json:"bytesSent"`
+}
+
+type apiBandwidthListData struct {
+	Items []apiBandwidthListItem `json:"items"`
+}
+
 type apiPathManager interface {
 	OnAPIPathsList(req apiPathsListReq1) apiPathsListRes1
+	OnAPIPathsPause(req apiPathsPauseReq) apiPathsPauseRes
 }
 
 type apiRTSPServer interface {
@@ -232,30 +454,48 @@ type apiRTMPServer interface {
 	OnAPIRTMPConnsKick(req apiRTMPConnsKickReq) apiRTMPConnsKickRes
 }
 
+type apiHLSServer interface {
+	OnAPIHLSRemuxersMetadata(req apiHLSRemuxersMetadataReq) apiHLSRemuxersMetadataRes
+}
+
 type apiParent interface {
 	Log(logger.Level, string, ...interface{})
 	OnAPIConfigSet(conf *conf.Conf)
 }
 
 type api struct {
-	conf        *conf.Conf
-	pathManager apiPathManager
-	rtspServer  apiRTSPServer
-	rtspsServer apiRTSPServer
-	rtmpServer  apiRTMPServer
-	parent      apiParent
-
-	mutex sync.Mutex
-	s     *http.Server
+	conf                *conf.Conf
+	stats               *stats
+	bandwidthAccounting *bandwidthAccounting
+	pathManager         apiPathManager
+	rtspServer          apiRTSPServer
+	rtspsServer         apiRTSPServer
+	rtmpServer          apiRTMPServer
+	hlsServer           apiHLSServer
+	parent              apiParent
+
+	mutex    sync.Mutex
+	s        *http.Server
+	auditLog *apiAuditLog
+	oidc     *oidcAuth
 }
 
 func newAPI(
 	address string,
+	auditLog bool,
+	auditLogFile string,
+	useOIDC bool,
+	oidcIssuer string,
+	oidcClientID string,
+	oidcClientSecret string,
 	conf *conf.Conf,
+	stats *stats,
+	bandwidthAccounting *bandwidthAccounting,
 	pathManager apiPathManager,
 	rtspServer apiRTSPServer,
 	rtspsServer apiRTSPServer,
 	rtmpServer apiRTMPServer,
+	hlsServer apiHLSServer,
 	parent apiParent,
 ) (*api, error) {
 	ln, err := net.Listen("tcp", address)
@@ -264,29 +504,57 @@ func newAPI(
 	}
 
 	a := &api{
-		conf:        conf,
-		pathManager: pathManager,
-		rtspServer:  rtspServer,
-		rtspsServer: rtspsServer,
-		rtmpServer:  rtmpServer,
-		parent:      parent,
+		conf:                conf,
+		stats:               stats,
+		bandwidthAccounting: bandwidthAccounting,
+		pathManager:         pathManager,
+		rtspServer:          rtspServer,
+		rtspsServer:         rtspsServer,
+		rtmpServer:          rtmpServer,
+		hlsServer:           hlsServer,
+		parent:              parent,
+	}
+
+	if auditLog {
+		a.auditLog, err = newAPIAuditLog(auditLogFile)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	if useOIDC {
+		a.oidc = newOIDCAuth(oidcIssuer, oidcClientID, oidcClientSecret)
 	}
 
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	group := router.Group("/", a.mwLog)
+
+	if a.oidc != nil {
+		router.GET(oidcCallbackPath, a.mwLog, a.onOIDCCallback)
+	}
+
+	group := router.Group("/", a.mwLog, a.mwOIDC, a.mwAudit)
 	group.GET("/v1/config/get", a.onConfigGet)
 	group.POST("/v1/config/set", a.onConfigSet)
+	group.PATCH("/v1/config/global", a.onConfigGlobalPatch)
 	group.POST("/v1/config/paths/add/:name", a.onConfigPathsAdd)
 	group.POST("/v1/config/paths/edit/:name", a.onConfigPathsEdit)
 	group.POST("/v1/config/paths/remove/:name", a.onConfigPathsDelete)
 	group.GET("/v1/paths/list", a.onPathsList)
+	group.POST("/v1/paths/pause/:name", a.onPathsPause)
+	group.POST("/v1/paths/resume/:name", a.onPathsResume)
 	group.GET("/v1/rtspsessions/list", a.onRTSPSessionsList)
 	group.POST("/v1/rtspsessions/kick/:id", a.onRTSPSessionsKick)
 	group.GET("/v1/rtspssessions/list", a.onRTSPSSessionsList)
 	group.POST("/v1/rtspssessions/kick/:id", a.onRTSPSSessionsKick)
 	group.GET("/v1/rtmpconns/list", a.onRTMPConnsList)
 	group.POST("/v1/rtmpconns/kick/:id", a.onRTMPConnsKick)
+	group.GET("/v1/ips/list", a.onIPsList)
+	group.GET("/v1/hlsviewers/list", a.onHLSViewersList)
+	group.POST("/v1/hlssessions/kick/:id", a.onHLSSessionsKick)
+	group.POST("/v1/hlsremuxers/metadata/:name", a.onHLSRemuxersMetadata)
+	group.GET("/v1/bandwidth/list", a.onBandwidthList)
 
 	a.s = &http.Server{
 		Handler: router,
@@ -301,6 +569,9 @@ func newAPI(
 
 func (a *api) close() {
 	a.s.Shutdown(context.Background())
+	if a.auditLog != nil {
+		a.auditLog.close()
+	}
 	a.log(logger.Info, "closed")
 }
 
@@ -344,6 +615,71 @@ func (a *api) mwLog(ctx *gin.Context) {
 	a.log(logger.Debug, "[s->c] %s", buf.String())
 }
 
+func (a *api) mwOIDC(ctx *gin.Context) {
+	if a.oidc == nil {
+		return
+	}
+
+	claims, err := a.oidc.authenticate(ctx.Request)
+	if err != nil {
+		if err != errOIDCNoToken {
+			a.log(logger.Info, "ERR: invalid OIDC token: %s", err)
+		}
+
+		// redirect browsers to the login page
+		if ctx.Request.Method == http.MethodGet &&
+			strings.Contains(ctx.Request.Header.Get("Accept"), "text/html") {
+			a.oidc.redirectToLogin(ctx.Writer, ctx.Request)
+			ctx.Abort()
+			return
+		}
+
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	ctx.Set(apiAuditLogUserKey, claims.Subject)
+}
+
+func (a *api) onOIDCCallback(ctx *gin.Context) {
+	err := a.oidc.handleCallback(ctx.Writer, ctx.Request)
+	if err != nil {
+		a.log(logger.Info, "ERR: OIDC login failed: %s", err)
+	}
+}
+
+func (a *api) mwAudit(ctx *gin.Context) {
+	if a.auditLog == nil || ctx.Request.Method == http.MethodGet {
+		ctx.Next()
+		return
+	}
+
+	ctx.Next()
+
+	user, _, _ := ctx.Request.BasicAuth()
+	if v, ok := ctx.Get(apiAuditLogUserKey); ok {
+		user = v.(string)
+	}
+
+	entry := apiAuditLogEntry{
+		Time:       time.Now(),
+		RemoteAddr: ctx.Request.RemoteAddr,
+		User:       user,
+		Method:     ctx.Request.Method,
+		Path:       ctx.Request.URL.Path,
+		Status:     ctx.Writer.Status(),
+	}
+
+	if v, ok := ctx.Get(apiAuditLogChangesKey); ok {
+		entry.Changes = v.([]apiAuditLogChange)
+	}
+
+	err := a.auditLog.write(entry)
+	if err != nil {
+		a.log(logger.Warn, "unable to write audit log: %s", err)
+	}
+}
+
 func (a *api) onConfigGet(ctx *gin.Context) {
 	a.mutex.Lock()
 	c := a.conf
@@ -353,7 +689,7 @@ func (a *api) onConfigGet(ctx *gin.Context) {
 }
 
 func (a *api) onConfigSet(ctx *gin.Context) {
-	in, err := loadConfData(ctx)
+	in, err := loadConfData(ctx, false)
 	if err != nil {
 		ctx.AbortWithStatus(http.StatusBadRequest)
 		return
@@ -373,9 +709,48 @@ func (a *api) onConfigSet(ctx *gin.Context) {
 	}
 
 	a.mutex.Lock()
+	oldConf := a.conf
+	a.conf = &newConf
+	a.mutex.Unlock()
+
+	setAuditChanges(ctx, oldConf, &newConf)
+
+	// since reloading the configuration can cause the shutdown of the API,
+	// call it in a goroutine
+	go a.parent.OnAPIConfigSet(&newConf)
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *api) onConfigGlobalPatch(ctx *gin.Context) {
+	// unlike onConfigSet, reject unknown fields and report validation errors,
+	// since this endpoint is meant to be used interactively.
+	in, err := loadConfData(ctx, true)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.mutex.Lock()
+	var newConf conf.Conf
+	cloneStruct(a.conf, &newConf)
+	a.mutex.Unlock()
+
+	fillStruct(&newConf, in)
+
+	err = newConf.CheckAndFillMissing()
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.mutex.Lock()
+	oldConf := a.conf
 	a.conf = &newConf
 	a.mutex.Unlock()
 
+	setAuditChanges(ctx, oldConf, &newConf)
+
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
 	go a.parent.OnAPIConfigSet(&newConf)
@@ -413,9 +788,12 @@ func (a *api) onConfigPathsAdd(ctx *gin.Context) {
 	}
 
 	a.mutex.Lock()
+	oldConf := a.conf
 	a.conf = &newConf
 	a.mutex.Unlock()
 
+	setAuditChanges(ctx, oldConf, &newConf)
+
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
 	go a.parent.OnAPIConfigSet(&newConf)
@@ -452,9 +830,12 @@ func (a *api) onConfigPathsEdit(ctx *gin.Context) {
 	}
 
 	a.mutex.Lock()
+	oldConf := a.conf
 	a.conf = &newConf
 	a.mutex.Unlock()
 
+	setAuditChanges(ctx, oldConf, &newConf)
+
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
 	go a.parent.OnAPIConfigSet(&newConf)
@@ -484,9 +865,12 @@ func (a *api) onConfigPathsDelete(ctx *gin.Context) {
 	}
 
 	a.mutex.Lock()
+	oldConf := a.conf
 	a.conf = &newConf
 	a.mutex.Unlock()
 
+	setAuditChanges(ctx, oldConf, &newConf)
+
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
 	go a.parent.OnAPIConfigSet(&newConf)
@@ -504,6 +888,31 @@ func (a *api) onPathsList(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, res.Data)
 }
 
+func (a *api) onPathsPause(ctx *gin.Context) {
+	a.setPathPaused(ctx, true)
+}
+
+func (a *api) onPathsResume(ctx *gin.Context) {
+	a.setPathPaused(ctx, false)
+}
+
+func (a *api) setPathPaused(ctx *gin.Context, paused bool) {
+	res := a.pathManager.OnAPIPathsPause(apiPathsPauseReq{
+		Name:   ctx.Param("name"),
+		Paused: paused,
+	})
+	if res.Path == nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if res.Err != nil {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
 func (a *api) onRTSPSessionsList(ctx *gin.Context) {
 	if interfaceIsEmpty(a.rtspServer) {
 		ctx.AbortWithStatus(http.StatusNotFound)
@@ -606,3 +1015,85 @@ func (a *api) onRTMPConnsKick(ctx *gin.Context) {
 
 	ctx.Status(http.StatusOK)
 }
+
+func (a *api) onIPsList(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, a.stats.IPs.apiList())
+}
+
+func (a *api) onHLSViewersList(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, a.stats.HLSViewers.apiList())
+}
+
+func (a *api) onHLSSessionsKick(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	err := a.stats.HLSViewers.kickSession(id)
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *api) onHLSRemuxersMetadata(ctx *gin.Context) {
+	if interfaceIsEmpty(a.hlsServer) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var in struct {
+		Description string `json:"description"`
+		Value       string `json:"value"`
+	}
+	err := json.NewDecoder(ctx.Request.Body).Decode(&in)
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	res := a.hlsServer.OnAPIHLSRemuxersMetadata(apiHLSRemuxersMetadataReq{
+		Name: ctx.Param("name"),
+		Tag:  hls.ID3TextTag(in.Description, in.Value),
+	})
+	if res.Err != nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *api) onBandwidthList(ctx *gin.Context) {
+	if a.bandwidthAccounting == nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	parseTime := func(key string) (time.Time, error) {
+		v := ctx.Query(key)
+		if v == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, v)
+	}
+
+	from, err := parseTime("from")
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseTime("to")
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var user *string
+	if v, ok := ctx.GetQuery("user"); ok {
+		user = &v
+	}
+
+	ctx.JSON(http.StatusOK, a.bandwidthAccounting.apiList(ctx.Query("path"), user, from, to))
+}
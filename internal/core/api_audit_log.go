@@ -0,0 +1,186 @@
+package core
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+const (
+	apiAuditLogChangesKey = "auditChanges"
+	apiAuditLogUserKey    = "auditUser"
+	apiAuditLogRedacted   = "<redacted>"
+)
+
+type apiAuditLogChange struct {
+	Field    string      `json:"field"`
+	Previous interface{} `json:"previous"`
+	Current  interface{} `json:"current"`
+}
+
+type apiAuditLogEntry struct {
+	Time       time.Time           `json:"time"`
+	RemoteAddr string              `json:"remoteAddr"`
+	User       string              `json:"user"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Status     int                 `json:"status"`
+	Changes    []apiAuditLogChange `json:"changes,omitempty"`
+}
+
+// flattenConf converts a configuration into a map of dotted field names,
+// in order to compare configurations field by field.
+func flattenConf(prefix string, in interface{}, out map[string]interface{}) {
+	if m, ok := in.(map[string]interface{}); ok && (prefix == "" || len(m) != 0) {
+		for k, v := range m {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenConf(key, v, out)
+		}
+		return
+	}
+
+	out[prefix] = in
+}
+
+func confToMap(c *conf.Conf) map[string]interface{} {
+	byts, _ := json.Marshal(c)
+	var tmp map[string]interface{}
+	json.Unmarshal(byts, &tmp)
+
+	ret := make(map[string]interface{})
+	flattenConf("", tmp, ret)
+	return ret
+}
+
+func isCredentialField(field string) bool {
+	return strings.HasSuffix(field, "User") ||
+		strings.HasSuffix(field, "Pass") ||
+		strings.HasSuffix(field, "Secret") ||
+		strings.HasSuffix(field, "EncryptionKey")
+}
+
+// stripURLCredentials removes the credentials from values that are URLs,
+// like sources and proxies, or lists of URLs, like source pools.
+func stripURLCredentials(v interface{}) interface{} {
+	if l, ok := v.([]interface{}); ok {
+		ret := make([]interface{}, len(l))
+		for i, e := range l {
+			ret[i] = stripURLCredentials(e)
+		}
+		return ret
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.User == nil {
+		return v
+	}
+
+	u.User = nil
+	return u.String()
+}
+
+// confDiff returns the fields that differ between two configurations.
+// Credentials are redacted, and removed from URLs.
+func confDiff(prev *conf.Conf, cur *conf.Conf) []apiAuditLogChange {
+	prevMap := confToMap(prev)
+	curMap := confToMap(cur)
+
+	keys := make(map[string]struct{})
+	for k := range prevMap {
+		keys[k] = struct{}{}
+	}
+	for k := range curMap {
+		keys[k] = struct{}{}
+	}
+
+	var ret []apiAuditLogChange
+	for k := range keys {
+		pv := prevMap[k]
+		cv := curMap[k]
+		if reflect.DeepEqual(pv, cv) {
+			continue
+		}
+
+		if isCredentialField(k) {
+			if pv != nil && pv != "" {
+				pv = apiAuditLogRedacted
+			}
+			if cv != nil && cv != "" {
+				cv = apiAuditLogRedacted
+			}
+		} else {
+			pv = stripURLCredentials(pv)
+			cv = stripURLCredentials(cv)
+		}
+
+		ret = append(ret, apiAuditLogChange{
+			Field:    k,
+			Previous: pv,
+			Current:  cv,
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Field < ret[j].Field
+	})
+
+	return ret
+}
+
+// apiAuditLog writes a record of every API request that mutates the server state.
+type apiAuditLog struct {
+	mutex sync.Mutex
+	f     *os.File
+}
+
+func newAPIAuditLog(fpath string) (*apiAuditLog, error) {
+	f, err := os.OpenFile(fpath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiAuditLog{
+		f: f,
+	}, nil
+}
+
+func (al *apiAuditLog) close() {
+	al.f.Close()
+}
+
+func (al *apiAuditLog) write(entry apiAuditLogEntry) error {
+	byts, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	byts = append(byts, '\n')
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	_, err = al.f.Write(byts)
+	return err
+}
+
+// setAuditChanges attaches configuration changes to the current request,
+// in order to be recorded by the audit log.
+func setAuditChanges(ctx *gin.Context, prev *conf.Conf, cur *conf.Conf) {
+	ctx.Set(apiAuditLogChangesKey, confDiff(prev, cur))
+}
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	bandwidthAccountingSavePeriod = 1 * time.Minute
+
+	// counters that are not in use are removed after this period without activity.
+	bandwidthCountersIdleTimeout = 1 * time.Hour
+)
+
+type bandwidthAccountingKey struct {
+	Path string
+	User string
+}
+
+type bandwidthCounter struct {
+	// use pointers to avoid a crash on 32bit platforms
+	// https://github.com/golang/go/issues/9959
+	BytesReceived *int64
+	BytesSent     *int64
+
+	// protected by the mutex of bandwidthCounters
+	refs     int
+	lastUsed time.Time
+}
+
+// bandwidthCounters contains live counters aggregated by path and user.
+// Counters are always incremented, and are consumed by bandwidthAccounting
+// when accounting is enabled. They are removed when they are not in use
+// and have been idle for bandwidthCountersIdleTimeout.
+type bandwidthCounters struct {
+	mutex   sync.Mutex
+	entries map[bandwidthAccountingKey]*bandwidthCounter
+}
+
+func newBandwidthCounters() *bandwidthCounters {
+	return &bandwidthCounters{
+		entries: make(map[bandwidthAccountingKey]*bandwidthCounter),
+	}
+}
+
+// removeIdle removes the counters that are not in use anymore.
+// It must be called with the mutex locked.
+func (c *bandwidthCounters) removeIdle(now time.Time) {
+	minTime := now.Add(-bandwidthCountersIdleTimeout)
+	for key, e := range c.entries {
+		if e.refs == 0 && e.lastUsed.Before(minTime) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// acquire returns the counters of a path and user, creating them if they
+// don't exist. Counters are kept until release() is called.
+func (c *bandwidthCounters) acquire(pathName string, user string) *bandwidthCounter {
+	key := bandwidthAccountingKey{Path: pathName, User: user}
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.removeIdle(now)
+
+		e = &bandwidthCounter{
+			BytesReceived: ptrInt64(),
+			BytesSent:     ptrInt64(),
+		}
+		c.entries[key] = e
+	}
+
+	e.refs++
+	e.lastUsed = now
+
+	return e
+}
+
+// release allows the counters returned by acquire() to be removed.
+func (c *bandwidthCounters) release(e *bandwidthCounter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e.refs--
+	e.lastUsed = time.Now()
+}
+
+// consume returns the counters accumulated since the last call and resets them.
+func (c *bandwidthCounters) consume(cb func(key bandwidthAccountingKey, received int64, sent int64)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, e := range c.entries {
+		received := atomic.SwapInt64(e.BytesReceived, 0)
+		sent := atomic.SwapInt64(e.BytesSent, 0)
+		if received != 0 || sent != 0 {
+			cb(key, received, sent)
+		}
+	}
+
+	c.removeIdle(time.Now())
+}
+
+type bandwidthAccountingBucketKey struct {
+	Time int64
+	bandwidthAccountingKey
+}
+
+type bandwidthAccountingParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// bandwidthAccounting accumulates counters into time buckets,
+// and saves them to disk periodically.
+type bandwidthAccounting struct {
+	filePath       string
+	bucketDuration time.Duration
+	retention      time.Duration
+	counters       *bandwidthCounters
+	parent         bandwidthAccountingParent
+
+	ctx       context.Context
+	ctxCancel func()
+	mutex     sync.Mutex
+	buckets   map[bandwidthAccountingBucketKey]*apiBandwidthListItem
+	done      chan struct{}
+}
+
+func newBandwidthAccounting(
+	parentCtx context.Context,
+	filePath string,
+	bucketDuration time.Duration,
+	retention time.Duration,
+	counters *bandwidthCounters,
+	parent bandwidthAccountingParent,
+) (*bandwidthAccounting, error) {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	a := &bandwidthAccounting{
+		filePath:       filePath,
+		bucketDuration: bucketDuration,
+		retention:      retention,
+		counters:       counters,
+		parent:         parent,
+		ctx:            ctx,
+		ctxCancel:      ctxCancel,
+		buckets:        make(map[bandwidthAccountingBucketKey]*apiBandwidthListItem),
+		done:           make(chan struct{}),
+	}
+
+	err := a.load()
+	if err != nil {
+		ctxCancel()
+		return nil, err
+	}
+
+	// discard bytes exchanged while accounting was disabled
+	counters.consume(func(bandwidthAccountingKey, int64, int64) {})
+
+	a.log(logger.Info, "saving to %s", filePath)
+
+	go a.run()
+
+	return a, nil
+}
+
+func (a *bandwidthAccounting) close() {
+	a.ctxCancel()
+	<-a.done
+}
+
+func (a *bandwidthAccounting) log(level logger.Level, format string, args ...interface{}) {
+	a.parent.Log(level, "[bandwidth accounting] "+format, args...)
+}
+
+func (a *bandwidthAccounting) run() {
+	defer close(a.done)
+
+	t := time.NewTicker(bandwidthAccountingSavePeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			a.collect()
+			err := a.save()
+			if err != nil {
+				a.log(logger.Warn, "unable to save: %s", err)
+			}
+
+		case <-a.ctx.Done():
+			a.collect()
+			err := a.save()
+			if err != nil {
+				a.log(logger.Warn, "unable to save: %s", err)
+			}
+			return
+		}
+	}
+}
+
+func (a *bandwidthAccounting) load() error {
+	byts, err := ioutil.ReadFile(a.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var items []apiBandwidthListItem
+	err = json.Unmarshal(byts, &items)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		item := item
+		a.buckets[bandwidthAccountingBucketKey{
+			Time: item.Time.Unix(),
+			bandwidthAccountingKey: bandwidthAccountingKey{
+				Path: item.Path,
+				User: item.User,
+			},
+		}] = &item
+	}
+
+	return nil
+}
+
+// collect moves the live counters into the current bucket,
+// and removes buckets that are older than the retention period.
+func (a *bandwidthAccounting) collect() {
+	now := time.Now()
+	bucketTime := now.Truncate(a.bucketDuration)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.counters.consume(func(key bandwidthAccountingKey, received int64, sent int64) {
+		bkey := bandwidthAccountingBucketKey{
+			Time:                   bucketTime.Unix(),
+			bandwidthAccountingKey: key,
+		}
+
+		b, ok := a.buckets[bkey]
+		if !ok {
+			b = &apiBandwidthListItem{
+				Time: bucketTime.UTC(),
+				Path: key.Path,
+				User: key.User,
+			}
+			a.buckets[bkey] = b
+		}
+
+		b.BytesReceived += received
+		b.BytesSent += sent
+	})
+
+	if a.retention != 0 {
+		minTime := now.Add(-a.retention).Unix()
+		for bkey := range a.buckets {
+			if bkey.Time < minTime {
+				delete(a.buckets, bkey)
+			}
+		}
+	}
+}
+
+// sortedItems returns buckets sorted by time, path and user.
+func (a *bandwidthAccounting) sortedItems(filter func(*apiBandwidthListItem) bool) []apiBandwidthListItem {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	items := []apiBandwidthListItem{}
+	for _, b := range a.buckets {
+		if filter(b) {
+			items = append(items, *b)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].Time.Equal(items[j].Time) {
+			return items[i].Time.Before(items[j].Time)
+		}
+		if items[i].Path != items[j].Path {
+			return items[i].Path < items[j].Path
+		}
+		return items[i].User < items[j].User
+	})
+
+	return items
+}
+
+func (a *bandwidthAccounting) save() error {
+	byts, err := json.Marshal(a.sortedItems(func(*apiBandwidthListItem) bool { return true }))
+	if err != nil {
+		return err
+	}
+
+	// write into a temporary file and rename it, in order not to
+	// leave a truncated file in case of crash.
+	tmpPath := a.filePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, byts, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, a.filePath)
+}
+
+// apiList returns the buckets that match the given path, user and time range.
+// Empty values are not used as filters.
+func (a *bandwidthAccounting) apiList(pathName string, user *string, from time.Time, to time.Time) *apiBandwidthListData {
+	a.collect()
+
+	return &apiBandwidthListData{
+		Items: a.sortedItems(func(b *apiBandwidthListItem) bool {
+			return (pathName == "" || b.Path == pathName) &&
+				(user == nil || b.User == *user) &&
+				(from.IsZero() || !b.Time.Before(from)) &&
+				(to.IsZero() || b.Time.Before(to))
+		}),
+	}
+}
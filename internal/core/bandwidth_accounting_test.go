@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type testBandwidthAccountingParent struct{}
+
+func (testBandwidthAccountingParent) Log(logger.Level, string, ...interface{}) {}
+
+func TestBandwidthAccounting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-bandwidth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "bandwidth.json")
+
+	counters := newBandwidthCounters()
+
+	// bytes exchanged before accounting is enabled are discarded
+	atomic.AddInt64(counters.acquire("mypath", "").BytesReceived, 1000)
+
+	a, err := newBandwidthAccounting(context.Background(), fpath, time.Hour, 0, counters, testBandwidthAccountingParent{})
+	require.NoError(t, err)
+
+	atomic.AddInt64(counters.acquire("mypath", "").BytesReceived, 100)
+	atomic.AddInt64(counters.acquire("mypath", "user1").BytesSent, 200)
+	atomic.AddInt64(counters.acquire("otherpath", "user1").BytesSent, 300)
+
+	data := a.apiList("", nil, time.Time{}, time.Time{})
+	require.Equal(t, 3, len(data.Items))
+
+	user := "user1"
+	data = a.apiList("mypath", &user, time.Time{}, time.Time{})
+	require.Equal(t, 1, len(data.Items))
+	require.Equal(t, int64(0), data.Items[0].BytesReceived)
+	require.Equal(t, int64(200), data.Items[0].BytesSent)
+
+	// counters are added to the current bucket
+	atomic.AddInt64(counters.acquire("mypath", "user1").BytesSent, 50)
+	data = a.apiList("mypath", &user, time.Time{}, time.Time{})
+	require.Equal(t, int64(250), data.Items[0].BytesSent)
+
+	data = a.apiList("", nil, time.Now().Add(time.Hour), time.Time{})
+	require.Equal(t, 0, len(data.Items))
+
+	// buckets are saved when closing and loaded when opening
+	a.close()
+
+	a, err = newBandwidthAccounting(context.Background(), fpath, time.Hour, 0, counters, testBandwidthAccountingParent{})
+	require.NoError(t, err)
+	defer a.close()
+
+	data = a.apiList("", nil, time.Time{}, time.Time{})
+	require.Equal(t, 3, len(data.Items))
+	require.Equal(t, "mypath", data.Items[0].Path)
+	require.Equal(t, "", data.Items[0].User)
+	require.Equal(t, int64(100), data.Items[0].BytesReceived)
+}
+
+func TestBandwidthAccountingRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-bandwidth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "bandwidth.json"), []byte(`[`+
+		`{"time":"2000-01-01T00:00:00Z","path":"mypath","user":"","bytesReceived":1,"bytesSent":2}`+
+		`]`), 0o600)
+	require.NoError(t, err)
+
+	a, err := newBandwidthAccounting(context.Background(), filepath.Join(dir, "bandwidth.json"),
+		time.Hour, 24*time.Hour, newBandwidthCounters(), testBandwidthAccountingParent{})
+	require.NoError(t, err)
+	defer a.close()
+
+	data := a.apiList("", nil, time.Time{}, time.Time{})
+	require.Equal(t, 0, len(data.Items))
+}
+
+func TestBandwidthCountersRemoveIdle(t *testing.T) {
+	counters := newBandwidthCounters()
+
+	inUse := counters.acquire("mypath", "user1")
+	closed := counters.acquire("mypath", "user2")
+	atomic.AddInt64(closed.BytesSent, 100)
+	counters.release(closed)
+
+	// simulate the passing of time
+	counters.mutex.Lock()
+	for _, e := range counters.entries {
+		e.lastUsed = e.lastUsed.Add(-2 * bandwidthCountersIdleTimeout)
+	}
+	counters.mutex.Unlock()
+
+	// bytes are consumed before idle counters are removed
+	var sent int64
+	counters.consume(func(_ bandwidthAccountingKey, _ int64, s int64) {
+		sent += s
+	})
+	require.Equal(t, int64(100), sent)
+
+	// counters in use are kept
+	require.Equal(t, 1, len(counters.entries))
+
+	counters.release(inUse)
+	require.Equal(t, 1, len(counters.entries))
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/confwatcher"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	certLoaderRetryPeriod = 2 * time.Second
+)
+
+type certLoaderParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// certLoader loads a TLS certificate and reloads it when the certificate
+// or the key change on disk, without affecting existing connections.
+type certLoader struct {
+	certPath string
+	keyPath  string
+	parent   certLoaderParent
+
+	certWatcher *confwatcher.ConfWatcher
+	keyWatcher  *confwatcher.ConfWatcher
+	mutex       sync.RWMutex
+	cert        *tls.Certificate
+	done        chan struct{}
+}
+
+func newCertLoader(certPath string, keyPath string, parent certLoaderParent) (*certLoader, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	certWatcher, err := confwatcher.New(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keyWatcher, err := confwatcher.New(keyPath)
+	if err != nil {
+		certWatcher.Close()
+		return nil, err
+	}
+
+	cl := &certLoader{
+		certPath:    certPath,
+		keyPath:     keyPath,
+		parent:      parent,
+		certWatcher: certWatcher,
+		keyWatcher:  keyWatcher,
+		cert:        &cert,
+		done:        make(chan struct{}),
+	}
+
+	go cl.run()
+
+	return cl, nil
+}
+
+func (cl *certLoader) close() {
+	cl.certWatcher.Close()
+	cl.keyWatcher.Close()
+	<-cl.done
+}
+
+func (cl *certLoader) run() {
+	defer close(cl.done)
+
+	retryTimer := time.NewTimer(0)
+	if !retryTimer.Stop() {
+		<-retryTimer.C
+	}
+	defer retryTimer.Stop()
+
+	for {
+		isRetry := false
+
+		select {
+		case _, ok := <-cl.certWatcher.Watch():
+			if !ok {
+				return
+			}
+
+		case _, ok := <-cl.keyWatcher.Watch():
+			if !ok {
+				return
+			}
+
+		case <-retryTimer.C:
+			isRetry = true
+		}
+
+		cert, err := tls.LoadX509KeyPair(cl.certPath, cl.keyPath)
+		if err != nil {
+			// certificate and key are usually written separately and
+			// can be temporarily mismatched; keep the current certificate
+			// and try again later.
+			if !isRetry {
+				if !retryTimer.Stop() {
+					select {
+					case <-retryTimer.C:
+					default:
+					}
+				}
+				retryTimer.Reset(certLoaderRetryPeriod)
+			} else {
+				cl.parent.Log(logger.Warn, "unable to reload certificate: %s", err)
+			}
+			continue
+		}
+
+		cl.mutex.Lock()
+		cl.cert = &cert
+		cl.mutex.Unlock()
+
+		cl.parent.Log(logger.Info, "certificate reloaded")
+	}
+}
+
+func (cl *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cl.mutex.RLock()
+	defer cl.mutex.RUnlock()
+	return cl.cert, nil
+}
+
+// loadClientCAs loads the CA certificates used to verify client certificates.
+func loadClientCAs(fpath string) (*x509.CertPool, error) {
+	byts, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(byts) {
+		return nil, fmt.Errorf("unable to parse client CA '%s'", fpath)
+	}
+
+	return pool, nil
+}
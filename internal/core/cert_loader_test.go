@@ -0,0 +1,89 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type testCertLoaderParent struct{}
+
+func (testCertLoaderParent) Log(logger.Level, string, ...interface{}) {}
+
+func generateTestCert(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestCertLoaderReload(t *testing.T) {
+	serverCertFpath, err := writeTempFile(serverCert)
+	require.NoError(t, err)
+	defer os.Remove(serverCertFpath)
+
+	serverKeyFpath, err := writeTempFile(serverKey)
+	require.NoError(t, err)
+	defer os.Remove(serverKeyFpath)
+
+	cl, err := newCertLoader(serverCertFpath, serverKeyFpath, testCertLoaderParent{})
+	require.NoError(t, err)
+	defer cl.close()
+
+	initial, err := cl.getCertificate(nil)
+	require.NoError(t, err)
+
+	// wait for the watchers to become active
+	time.Sleep(1 * time.Second)
+
+	newCert, newKey := generateTestCert(t, "reloaded")
+
+	// write certificate and key separately, like most tools do
+	err = ioutil.WriteFile(serverCertFpath, newCert, 0o644)
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	// the mismatched pair must not replace the current certificate
+	cur, _ := cl.getCertificate(nil)
+	require.Equal(t, initial, cur)
+
+	err = ioutil.WriteFile(serverKeyFpath, newKey, 0o644)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		cur, _ := cl.getCertificate(nil)
+		if cur == initial {
+			return false
+		}
+
+		parsed, err := x509.ParseCertificate(cur.Certificate[0])
+		return err == nil && parsed.Subject.CommonName == "reloaded"
+	}, 5*time.Second, 100*time.Millisecond)
+}
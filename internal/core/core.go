@@ -26,13 +26,17 @@ type Core struct {
 	confFound   bool
 	stats       *stats
 	logger      *logger.Logger
+	bandwidth   *bandwidthAccounting
 	metrics     *metrics
 	pprof       *pprof
+	acmeManager *acmeManager
 	pathManager *pathManager
 	rtspServer  *rtspServer
 	rtspsServer *rtspServer
 	rtmpServer  *rtmpServer
 	hlsServer   *hlsServer
+	dashServer  *dashServer
+	onvifServer *onvifServer
 	api         *api
 	confWatcher *confwatcher.ConfWatcher
 
@@ -196,6 +200,21 @@ func (p *Core) createResources(initial bool) error {
 		}
 	}
 
+	if p.conf.BandwidthAccounting {
+		if p.bandwidth == nil {
+			p.bandwidth, err = newBandwidthAccounting(
+				p.ctx,
+				p.conf.BandwidthAccountingFile,
+				p.conf.BandwidthAccountingBucket,
+				p.conf.BandwidthAccountingRetention,
+				p.stats.Bandwidth,
+				p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	if p.conf.Metrics {
 		if p.metrics == nil {
 			p.metrics, err = newMetrics(
@@ -218,6 +237,21 @@ func (p *Core) createResources(initial bool) error {
 		}
 	}
 
+	if p.conf.ACME {
+		if p.acmeManager == nil {
+			p.acmeManager, err = newACMEManager(
+				p.conf.ACMEAddress,
+				p.conf.ACMEDomains,
+				p.conf.ACMEEmail,
+				p.conf.ACMEDirectoryURL,
+				p.conf.ACMECacheDir,
+				p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	if p.pathManager == nil {
 		p.pathManager = newPathManager(
 			p.ctx,
@@ -226,6 +260,9 @@ func (p *Core) createResources(initial bool) error {
 			p.conf.WriteTimeout,
 			p.conf.ReadBufferCount,
 			p.conf.ReadBufferSize,
+			p.conf.ResolvedOutboundProxy(),
+			p.conf.OutboundInterface,
+			p.conf.SourceRTPPortRangeParsed,
 			p.conf.Paths,
 			p.stats,
 			p.metrics,
@@ -253,13 +290,22 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.MulticastIPRange,
 				p.conf.MulticastRTPPort,
 				p.conf.MulticastRTCPPort,
+				p.conf.MulticastTTL,
+				p.conf.RTSPWebSocket,
+				p.conf.RTSPWebSocketAddress,
 				false,
 				"",
 				"",
+				"",
+				false,
+				nil,
 				p.conf.RTSPAddress,
 				p.conf.ProtocolsParsed,
+				p.conf.ForceTCPIPsParsed,
+				p.conf.ForceUDPIPsParsed,
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
+				p.stats,
 				p.metrics,
 				p.pathManager,
 				p)
@@ -288,13 +334,22 @@ func (p *Core) createResources(initial bool) error {
 				"",
 				0,
 				0,
+				0,
+				false,
+				"",
 				true,
 				p.conf.ServerCert,
 				p.conf.ServerKey,
+				p.conf.ClientCA,
+				p.conf.ClientCertRequired,
+				p.acmeManager,
 				p.conf.RTSPAddress,
 				p.conf.ProtocolsParsed,
+				nil,
+				nil,
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
+				p.stats,
 				p.metrics,
 				p.pathManager,
 				p)
@@ -309,12 +364,22 @@ func (p *Core) createResources(initial bool) error {
 			p.rtmpServer, err = newRTMPServer(
 				p.ctx,
 				p.conf.RTMPAddress,
+				p.conf.RTMPEncryptionParsed,
+				p.conf.RTMPSAddress,
+				p.conf.RTMPServerCert,
+				p.conf.RTMPServerKey,
+				p.conf.RTMPClientCA,
+				p.conf.RTMPClientCertRequired,
+				p.acmeManager,
+				p.conf.RTMPAdditionalAddressesParsed,
 				p.conf.ReadTimeout,
 				p.conf.WriteTimeout,
 				p.conf.ReadBufferCount,
 				p.conf.RTSPAddress,
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
+				p.conf.RTMPStreamKeyURL,
+				p.stats,
 				p.metrics,
 				p.pathManager,
 				p)
@@ -329,11 +394,81 @@ func (p *Core) createResources(initial bool) error {
 			p.hlsServer, err = newHLSServer(
 				p.ctx,
 				p.conf.HLSAddress,
+				p.conf.HLSSocketModeParsed,
+				p.conf.HLSTLS,
+				p.conf.HLSServerCert,
+				p.conf.HLSServerKey,
 				p.conf.HLSAlwaysRemux,
+				p.conf.HLSRemuxerCloseAfter,
+				p.conf.HLSWriteTimeout,
+				p.conf.HLSMaxConnections,
+				p.conf.HLSRequestRateLimit,
+				p.conf.HLSSessions,
+				p.conf.HLSSessionTimeout,
+				p.conf.HLSEndList,
 				p.conf.HLSSegmentCount,
 				p.conf.HLSSegmentDuration,
+				p.conf.HLSLowLatency,
+				p.conf.HLSPartDuration,
+				p.conf.HLSSegmentFormatParsed,
+				p.conf.HLSPlaylistName,
+				p.conf.HLSSegmentName,
+				p.conf.HLSEncryption == "aes-128",
+				p.conf.HLSEncryptionKeyParsed,
+				p.conf.HLSEncryptionKeyURI,
 				p.conf.HLSAllowOrigin,
+				p.conf.HLSAllowOriginsParsed,
+				p.conf.HLSBasePath,
+				p.conf.HLSTrustedProxiesParsed,
+				p.conf.HLSPlaylistCacheControl,
+				p.conf.HLSSegmentCacheControl,
+				p.conf.HLSPlayerDisable,
+				p.conf.HLSPlayerScriptURL,
 				p.conf.ReadBufferCount,
+				p.conf.OIDCIssuer,
+				p.conf.OIDCClientID,
+				p.conf.ResolvedOIDCClientSecret(),
+				p.stats,
+				p.pathManager,
+				p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.conf.DASH {
+		if p.dashServer == nil {
+			p.dashServer, err = newDASHServer(
+				p.ctx,
+				p.conf.DASHAddress,
+				p.conf.DASHMuxerCloseAfter,
+				p.conf.DASHSegmentCount,
+				p.conf.DASHSegmentDuration,
+				p.conf.DASHAllowOrigin,
+				p.conf.ReadBufferCount,
+				p.stats,
+				p.pathManager,
+				p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.conf.ONVIF && !p.conf.RTSPDisable {
+		if p.onvifServer == nil {
+			rtspScheme, rtspAddress := "rtsp", p.conf.RTSPAddress
+			if p.conf.EncryptionParsed == conf.EncryptionStrict {
+				rtspScheme, rtspAddress = "rtsps", p.conf.RTSPSAddress
+			}
+
+			p.onvifServer, err = newONVIFServer(
+				p.ctx,
+				p.conf.ONVIFAddress,
+				rtspScheme,
+				rtspAddress,
+				p.conf.Paths,
 				p.pathManager,
 				p)
 			if err != nil {
@@ -346,11 +481,20 @@ func (p *Core) createResources(initial bool) error {
 		if p.api == nil {
 			p.api, err = newAPI(
 				p.conf.APIAddress,
+				p.conf.APIAuditLog,
+				p.conf.APIAuditLogFile,
+				p.conf.APIOIDC,
+				p.conf.OIDCIssuer,
+				p.conf.OIDCClientID,
+				p.conf.ResolvedOIDCClientSecret(),
 				p.conf,
+				p.stats,
+				p.bandwidth,
 				p.pathManager,
 				p.rtspServer,
 				p.rtspsServer,
 				p.rtmpServer,
+				p.hlsServer,
 				p)
 			if err != nil {
 				return err
@@ -372,6 +516,9 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		!reflect.DeepEqual(newConf.LogDestinationsParsed, p.conf.LogDestinationsParsed) ||
 		newConf.LogFile != p.conf.LogFile {
 		closeLogger = true
+	} else if newConf.LogLevelParsed != p.conf.LogLevelParsed {
+		// the log level can be changed without recreating the logger
+		p.logger.SetLevel(newConf.LogLevelParsed)
 	}
 
 	closeMetrics := false
@@ -381,6 +528,15 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		closeMetrics = true
 	}
 
+	closeBandwidth := false
+	if newConf == nil ||
+		newConf.BandwidthAccounting != p.conf.BandwidthAccounting ||
+		newConf.BandwidthAccountingFile != p.conf.BandwidthAccountingFile ||
+		newConf.BandwidthAccountingBucket != p.conf.BandwidthAccountingBucket ||
+		newConf.BandwidthAccountingRetention != p.conf.BandwidthAccountingRetention {
+		closeBandwidth = true
+	}
+
 	closePPROF := false
 	if newConf == nil ||
 		newConf.PPROF != p.conf.PPROF ||
@@ -388,6 +544,17 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		closePPROF = true
 	}
 
+	closeACMEManager := false
+	if newConf == nil ||
+		newConf.ACME != p.conf.ACME ||
+		newConf.ACMEAddress != p.conf.ACMEAddress ||
+		!reflect.DeepEqual(newConf.ACMEDomains, p.conf.ACMEDomains) ||
+		newConf.ACMEEmail != p.conf.ACMEEmail ||
+		newConf.ACMEDirectoryURL != p.conf.ACMEDirectoryURL ||
+		newConf.ACMECacheDir != p.conf.ACMECacheDir {
+		closeACMEManager = true
+	}
+
 	closePathManager := false
 	if newConf == nil ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
@@ -395,6 +562,9 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
 		newConf.ReadBufferSize != p.conf.ReadBufferSize ||
+		newConf.ResolvedOutboundProxy() != p.conf.ResolvedOutboundProxy() ||
+		newConf.OutboundInterface != p.conf.OutboundInterface ||
+		newConf.SourceRTPPortRange != p.conf.SourceRTPPortRange ||
 		closeStats ||
 		closeMetrics {
 		closePathManager = true
@@ -407,6 +577,10 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		newConf.RTSPDisable != p.conf.RTSPDisable ||
 		newConf.EncryptionParsed != p.conf.EncryptionParsed ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
+		newConf.RTSPWebSocket != p.conf.RTSPWebSocket ||
+		!reflect.DeepEqual(newConf.ForceTCPIPsParsed, p.conf.ForceTCPIPsParsed) ||
+		!reflect.DeepEqual(newConf.ForceUDPIPsParsed, p.conf.ForceUDPIPsParsed) ||
+		newConf.RTSPWebSocketAddress != p.conf.RTSPWebSocketAddress ||
 		!reflect.DeepEqual(newConf.AuthMethodsParsed, p.conf.AuthMethodsParsed) ||
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
@@ -417,6 +591,7 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		newConf.MulticastIPRange != p.conf.MulticastIPRange ||
 		newConf.MulticastRTPPort != p.conf.MulticastRTPPort ||
 		newConf.MulticastRTCPPort != p.conf.MulticastRTCPPort ||
+		newConf.MulticastTTL != p.conf.MulticastTTL ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
 		!reflect.DeepEqual(newConf.ProtocolsParsed, p.conf.ProtocolsParsed) ||
 		newConf.RunOnConnect != p.conf.RunOnConnect ||
@@ -437,6 +612,9 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
 		newConf.ServerCert != p.conf.ServerCert ||
 		newConf.ServerKey != p.conf.ServerKey ||
+		newConf.ClientCA != p.conf.ClientCA ||
+		newConf.ClientCertRequired != p.conf.ClientCertRequired ||
+		closeACMEManager ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
 		!reflect.DeepEqual(newConf.ProtocolsParsed, p.conf.ProtocolsParsed) ||
 		newConf.RunOnConnect != p.conf.RunOnConnect ||
@@ -449,7 +627,16 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 	closeRTMPServer := false
 	if newConf == nil ||
 		newConf.RTMPDisable != p.conf.RTMPDisable ||
+		newConf.RTMPEncryption != p.conf.RTMPEncryption ||
 		newConf.RTMPAddress != p.conf.RTMPAddress ||
+		newConf.RTMPSAddress != p.conf.RTMPSAddress ||
+		newConf.RTMPServerKey != p.conf.RTMPServerKey ||
+		newConf.RTMPServerCert != p.conf.RTMPServerCert ||
+		newConf.RTMPClientCA != p.conf.RTMPClientCA ||
+		newConf.RTMPClientCertRequired != p.conf.RTMPClientCertRequired ||
+		newConf.RTMPStreamKeyURL != p.conf.RTMPStreamKeyURL ||
+		!reflect.DeepEqual(newConf.RTMPAdditionalAddressesParsed, p.conf.RTMPAdditionalAddressesParsed) ||
+		closeACMEManager ||
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
@@ -465,23 +652,87 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 	if newConf == nil ||
 		newConf.HLSDisable != p.conf.HLSDisable ||
 		newConf.HLSAddress != p.conf.HLSAddress ||
+		newConf.HLSSocketModeParsed != p.conf.HLSSocketModeParsed ||
 		newConf.HLSAlwaysRemux != p.conf.HLSAlwaysRemux ||
+		newConf.HLSRemuxerCloseAfter != p.conf.HLSRemuxerCloseAfter ||
+		newConf.HLSWriteTimeout != p.conf.HLSWriteTimeout ||
+		newConf.HLSMaxConnections != p.conf.HLSMaxConnections ||
+		newConf.HLSRequestRateLimit != p.conf.HLSRequestRateLimit ||
+		newConf.HLSSessions != p.conf.HLSSessions ||
+		newConf.HLSSessionTimeout != p.conf.HLSSessionTimeout ||
+		newConf.HLSEndList != p.conf.HLSEndList ||
 		newConf.HLSSegmentCount != p.conf.HLSSegmentCount ||
 		newConf.HLSSegmentDuration != p.conf.HLSSegmentDuration ||
+		newConf.HLSTLS != p.conf.HLSTLS ||
+		newConf.HLSServerCert != p.conf.HLSServerCert ||
+		newConf.HLSServerKey != p.conf.HLSServerKey ||
+		newConf.HLSLowLatency != p.conf.HLSLowLatency ||
+		newConf.HLSPartDuration != p.conf.HLSPartDuration ||
+		newConf.HLSSegmentFormat != p.conf.HLSSegmentFormat ||
+		newConf.HLSPlaylistName != p.conf.HLSPlaylistName ||
+		newConf.HLSSegmentName != p.conf.HLSSegmentName ||
+		newConf.HLSEncryption != p.conf.HLSEncryption ||
+		!reflect.DeepEqual(newConf.HLSEncryptionKeyParsed, p.conf.HLSEncryptionKeyParsed) ||
+		newConf.HLSEncryptionKeyURI != p.conf.HLSEncryptionKeyURI ||
 		newConf.HLSAllowOrigin != p.conf.HLSAllowOrigin ||
+		!reflect.DeepEqual(newConf.HLSAllowOrigins, p.conf.HLSAllowOrigins) ||
+		newConf.HLSBasePath != p.conf.HLSBasePath ||
+		!reflect.DeepEqual(newConf.HLSTrustedProxies, p.conf.HLSTrustedProxies) ||
+		newConf.HLSPlaylistCacheControl != p.conf.HLSPlaylistCacheControl ||
+		newConf.HLSSegmentCacheControl != p.conf.HLSSegmentCacheControl ||
+		newConf.HLSPlayerDisable != p.conf.HLSPlayerDisable ||
+		newConf.HLSPlayerScriptURL != p.conf.HLSPlayerScriptURL ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.OIDCIssuer != p.conf.OIDCIssuer ||
+		newConf.OIDCClientID != p.conf.OIDCClientID ||
+		newConf.ResolvedOIDCClientSecret() != p.conf.ResolvedOIDCClientSecret() ||
 		closePathManager {
 		closeHLSServer = true
 	}
 
+	closeDASHServer := false
+	if newConf == nil ||
+		newConf.DASH != p.conf.DASH ||
+		newConf.DASHAddress != p.conf.DASHAddress ||
+		newConf.DASHMuxerCloseAfter != p.conf.DASHMuxerCloseAfter ||
+		newConf.DASHSegmentCount != p.conf.DASHSegmentCount ||
+		newConf.DASHSegmentDuration != p.conf.DASHSegmentDuration ||
+		newConf.DASHAllowOrigin != p.conf.DASHAllowOrigin ||
+		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		closePathManager {
+		closeDASHServer = true
+	}
+
+	closeONVIFServer := false
+	if newConf == nil ||
+		newConf.ONVIF != p.conf.ONVIF ||
+		newConf.ONVIFAddress != p.conf.ONVIFAddress ||
+		newConf.RTSPDisable != p.conf.RTSPDisable ||
+		newConf.EncryptionParsed != p.conf.EncryptionParsed ||
+		newConf.RTSPAddress != p.conf.RTSPAddress ||
+		newConf.RTSPSAddress != p.conf.RTSPSAddress ||
+		closePathManager {
+		closeONVIFServer = true
+	} else if p.onvifServer != nil && !reflect.DeepEqual(newConf.Paths, p.conf.Paths) {
+		p.onvifServer.OnConfReload(newConf.Paths)
+	}
+
 	closeAPI := false
 	if newConf == nil ||
 		newConf.API != p.conf.API ||
 		newConf.APIAddress != p.conf.APIAddress ||
+		newConf.APIAuditLog != p.conf.APIAuditLog ||
+		newConf.APIAuditLogFile != p.conf.APIAuditLogFile ||
+		newConf.APIOIDC != p.conf.APIOIDC ||
+		newConf.OIDCIssuer != p.conf.OIDCIssuer ||
+		newConf.OIDCClientID != p.conf.OIDCClientID ||
+		newConf.ResolvedOIDCClientSecret() != p.conf.ResolvedOIDCClientSecret() ||
+		closeBandwidth ||
 		closePathManager ||
 		closeRTSPServer ||
 		closeRTSPSServer ||
-		closeRTMPServer {
+		closeRTMPServer ||
+		closeHLSServer {
 		closeAPI = true
 	}
 
@@ -494,6 +745,11 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		}
 	}
 
+	if closeONVIFServer && p.onvifServer != nil {
+		p.onvifServer.close()
+		p.onvifServer = nil
+	}
+
 	if closeRTSPSServer && p.rtspsServer != nil {
 		p.rtspsServer.close()
 		p.rtspsServer = nil
@@ -514,11 +770,26 @@ func (p *Core) closeResources(newConf *conf.Conf) {
 		p.hlsServer = nil
 	}
 
+	if closeDASHServer && p.dashServer != nil {
+		p.dashServer.close()
+		p.dashServer = nil
+	}
+
 	if closeRTMPServer && p.rtmpServer != nil {
 		p.rtmpServer.close()
 		p.rtmpServer = nil
 	}
 
+	if closeACMEManager && p.acmeManager != nil {
+		p.acmeManager.close()
+		p.acmeManager = nil
+	}
+
+	if closeBandwidth && p.bandwidth != nil {
+		p.bandwidth.close()
+		p.bandwidth = nil
+	}
+
 	if closePPROF && p.pprof != nil {
 		p.pprof.close()
 		p.pprof = nil
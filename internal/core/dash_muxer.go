@@ -0,0 +1,467 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/ringbuffer"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/credential"
+	"github.com/aler9/rtsp-simple-server/internal/dash"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+// file name of the manifest.
+const dashManifestName = "manifest.mpd"
+
+type dashMuxerRequest struct {
+	File string
+	IP   net.IP
+	Req  *http.Request
+	W    http.ResponseWriter
+	Res  chan io.Reader
+}
+
+type dashMuxerTrackIDPayloadPair struct {
+	trackID int
+	buf     []byte
+}
+
+type dashMuxerPathManager interface {
+	OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
+}
+
+type dashMuxerParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnMuxerClose(*dashMuxer)
+}
+
+type dashMuxer struct {
+	dashMuxerCloseAfter time.Duration
+	dashSegmentCount    int
+	dashSegmentDuration time.Duration
+	readBufferCount     int
+	wg                  *sync.WaitGroup
+	stats               *stats
+	pathName            string
+	pathManager         dashMuxerPathManager
+	parent              dashMuxerParent
+
+	ctx             context.Context
+	ctxCancel       func()
+	path            *path
+	ringBuffer      *ringbuffer.RingBuffer
+	lastRequestTime *int64
+	muxer           *dash.Muxer
+	requests        []dashMuxerRequest
+
+	// in
+	request chan dashMuxerRequest
+}
+
+func newDASHMuxer(
+	parentCtx context.Context,
+	dashMuxerCloseAfter time.Duration,
+	dashSegmentCount int,
+	dashSegmentDuration time.Duration,
+	readBufferCount int,
+	wg *sync.WaitGroup,
+	stats *stats,
+	pathName string,
+	pathManager dashMuxerPathManager,
+	parent dashMuxerParent) *dashMuxer {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	m := &dashMuxer{
+		dashMuxerCloseAfter: dashMuxerCloseAfter,
+		dashSegmentCount:    dashSegmentCount,
+		dashSegmentDuration: dashSegmentDuration,
+		readBufferCount:     readBufferCount,
+		wg:                  wg,
+		stats:               stats,
+		pathName:            pathName,
+		pathManager:         pathManager,
+		parent:              parent,
+		ctx:                 ctx,
+		ctxCancel:           ctxCancel,
+		lastRequestTime: func() *int64 {
+			v := time.Now().Unix()
+			return &v
+		}(),
+		request: make(chan dashMuxerRequest),
+	}
+
+	m.log(logger.Info, "created")
+
+	m.wg.Add(1)
+	go m.run()
+
+	return m
+}
+
+// Close implements reader.
+func (m *dashMuxer) Close() {
+	m.ctxCancel()
+}
+
+func (m *dashMuxer) log(level logger.Level, format string, args ...interface{}) {
+	m.parent.Log(level, "[muxer %s] "+format, append([]interface{}{m.pathName}, args...)...)
+}
+
+// PathName returns the path name.
+func (m *dashMuxer) PathName() string {
+	return m.pathName
+}
+
+func (m *dashMuxer) run() {
+	defer m.wg.Done()
+	defer m.log(logger.Info, "destroyed")
+
+	innerCtx, innerCtxCancel := context.WithCancel(context.Background())
+	innerReady := make(chan struct{})
+	innerErr := make(chan error)
+	go func() {
+		innerErr <- m.runInner(innerCtx, innerReady)
+	}()
+
+	isReady := false
+
+outer:
+	for {
+		select {
+		case <-m.ctx.Done():
+			innerCtxCancel()
+			<-innerErr
+			break outer
+
+		case req := <-m.request:
+			if isReady {
+				m.handleRequest(req)
+			} else {
+				m.requests = append(m.requests, req)
+			}
+
+		case <-innerReady:
+			isReady = true
+			for _, req := range m.requests {
+				m.handleRequest(req)
+			}
+			m.requests = nil
+
+		case err := <-innerErr:
+			innerCtxCancel()
+			if err != nil {
+				m.log(logger.Info, "ERR: %s", err)
+			}
+			break outer
+		}
+	}
+
+	m.ctxCancel()
+
+	for _, req := range m.requests {
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+	}
+
+	m.parent.OnMuxerClose(m)
+}
+
+func (m *dashMuxer) runInner(innerCtx context.Context, innerReady chan struct{}) error {
+	res := m.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
+		Author:              m,
+		PathName:            m.pathName,
+		IP:                  nil,
+		ValidateCredentials: nil,
+	})
+	if res.Err != nil {
+		return res.Err
+	}
+
+	m.path = res.Path
+
+	defer func() {
+		m.path.OnReaderRemove(pathReaderRemoveReq{Author: m})
+	}()
+
+	var videoTrack *gortsplib.Track
+	videoTrackID := -1
+	var h264Decoder *rtph264.Decoder
+	var h265Decoder *h265.Decoder
+	var audioTrack *gortsplib.Track
+	audioTrackID := -1
+	var aacDecoder *rtpaac.Decoder
+
+	for i, t := range res.Stream.tracks() {
+		switch {
+		case t.IsH264():
+			if videoTrack != nil {
+				return fmt.Errorf("can't read track %d with DASH: too many tracks", i+1)
+			}
+
+			videoTrack = t
+			videoTrackID = i
+			h264Decoder = rtph264.NewDecoder()
+
+		case h265.IsTrack(t):
+			if videoTrack != nil {
+				return fmt.Errorf("can't read track %d with DASH: too many tracks", i+1)
+			}
+
+			videoTrack = t
+			videoTrackID = i
+			h265Decoder = h265.NewDecoder()
+
+		case t.IsAAC():
+			if audioTrack != nil {
+				return fmt.Errorf("can't read track %d with DASH: too many tracks", i+1)
+			}
+
+			byts, err := t.ExtractDataAAC()
+			if err != nil {
+				return err
+			}
+
+			var aacConfig rtpaac.MPEG4AudioConfig
+			err = aacConfig.Decode(byts)
+			if err != nil {
+				return err
+			}
+
+			audioTrack = t
+			audioTrackID = i
+			aacDecoder = rtpaac.NewDecoder(aacConfig.SampleRate)
+		}
+	}
+
+	if videoTrack == nil && audioTrack == nil {
+		return fmt.Errorf("the stream doesn't contain an H264, H265 or AAC track")
+	}
+
+	var err error
+	m.muxer, err = dash.NewMuxer(
+		m.dashSegmentCount,
+		m.dashSegmentDuration,
+		videoTrack,
+		audioTrack,
+	)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case innerReady <- struct{}{}:
+	case <-innerCtx.Done():
+		return nil
+	}
+
+	m.ringBuffer = ringbuffer.New(uint64(m.readBufferCount))
+
+	m.path.OnReaderPlay(pathReaderPlayReq{Author: m})
+
+	writerDone := make(chan error)
+	go func() {
+		writerDone <- func() error {
+			var videoBuf [][]byte
+
+			for {
+				data, ok := m.ringBuffer.Pull()
+				if !ok {
+					return fmt.Errorf("terminated")
+				}
+				pair := data.(dashMuxerTrackIDPayloadPair)
+
+				var pkt rtp.Packet
+				err := pkt.Unmarshal(pair.buf)
+				if err != nil {
+					m.log(logger.Warn, "unable to decode RTP packet: %v", err)
+					continue
+				}
+
+				switch {
+				case h265Decoder != nil && pair.trackID == videoTrackID:
+					nalus, pts, err := h265Decoder.DecodeRTP(&pkt)
+					if err != nil {
+						if err != h265.ErrMorePacketsNeeded && err != h265.ErrNonStartingPacketAndNoPrevious {
+							m.log(logger.Warn, "unable to decode video track: %v", err)
+						}
+						continue
+					}
+
+					videoBuf = append(videoBuf, nalus...)
+
+					// RTP marker means that all the NALUs with the same PTS have been received.
+					// send them together.
+					if pkt.Marker {
+						err := m.muxer.WriteH265(pts, videoBuf)
+						if err != nil {
+							return err
+						}
+						videoBuf = nil
+					}
+
+				case h264Decoder != nil && pair.trackID == videoTrackID:
+					nalus, pts, err := h264Decoder.DecodeRTP(&pkt)
+					if err != nil {
+						if err != rtph264.ErrMorePacketsNeeded && err != rtph264.ErrNonStartingPacketAndNoPrevious {
+							m.log(logger.Warn, "unable to decode video track: %v", err)
+						}
+						continue
+					}
+
+					videoBuf = append(videoBuf, nalus...)
+
+					if pkt.Marker {
+						err := m.muxer.WriteH264(pts, videoBuf)
+						if err != nil {
+							return err
+						}
+						videoBuf = nil
+					}
+
+				case audioTrack != nil && pair.trackID == audioTrackID:
+					aus, pts, err := aacDecoder.DecodeRTP(&pkt)
+					if err != nil {
+						if err != rtpaac.ErrMorePacketsNeeded {
+							m.log(logger.Warn, "unable to decode audio track: %v", err)
+						}
+						continue
+					}
+
+					err = m.muxer.WriteAAC(pts, aus)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}()
+	}()
+
+	closeCheckTicker := time.NewTicker(closeCheckPeriod)
+	defer closeCheckTicker.Stop()
+
+	for {
+		select {
+		case <-closeCheckTicker.C:
+			t := time.Unix(atomic.LoadInt64(m.lastRequestTime), 0)
+			if time.Since(t) >= m.dashMuxerCloseAfter {
+				m.ringBuffer.Close()
+				<-writerDone
+				return nil
+			}
+
+		case err := <-writerDone:
+			return err
+
+		case <-innerCtx.Done():
+			m.ringBuffer.Close()
+			<-writerDone
+			return nil
+		}
+	}
+}
+
+func (m *dashMuxer) handleRequest(req dashMuxerRequest) {
+	atomic.StoreInt64(m.lastRequestTime, time.Now().Unix())
+
+	conf := m.path.Conf()
+
+	if conf.ReadIPsParsed != nil {
+		if !ipEqualOrInRange(req.IP, conf.ReadIPsParsed) {
+			m.stats.IPs.onAuthFailure(req.IP)
+			m.log(logger.Info, "ERR: ip '%s' not allowed", req.IP)
+			req.W.WriteHeader(http.StatusUnauthorized)
+			req.Res <- nil
+			return
+		}
+	}
+
+	if conf.ResolvedReadUser() != "" {
+		user, pass, ok := req.Req.BasicAuth()
+		if !ok || !credential.Check(conf.ResolvedReadUser(), user) || !credential.Check(conf.ResolvedReadPass(), pass) {
+			// requests without credentials are part of the normal handshake
+			if ok {
+				m.stats.IPs.onAuthFailure(req.IP)
+			}
+
+			req.W.Header().Set("WWW-Authenticate", `Basic realm="rtsp-simple-server"`)
+			req.W.WriteHeader(http.StatusUnauthorized)
+			req.Res <- nil
+			return
+		}
+	}
+
+	switch {
+	case req.File == dashManifestName:
+		mpd := m.muxer.MPD()
+		if mpd == nil {
+			req.W.WriteHeader(http.StatusNotFound)
+			req.Res <- nil
+			return
+		}
+
+		req.W.Header().Set("Content-Type", "application/dash+xml")
+		req.W.Header().Set("Cache-Control", "no-cache")
+		req.Res <- mpd
+
+	case strings.HasSuffix(req.File, ".mp4"):
+		f := m.muxer.File(req.File)
+		if f == nil {
+			req.W.WriteHeader(http.StatusNotFound)
+			req.Res <- nil
+			return
+		}
+
+		if strings.HasPrefix(req.File, "audio_") {
+			req.W.Header().Set("Content-Type", "audio/mp4")
+		} else {
+			req.W.Header().Set("Content-Type", "video/mp4")
+		}
+		req.Res <- f
+
+	default:
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+	}
+}
+
+// OnRequest is called by dashServer.
+func (m *dashMuxer) OnRequest(req dashMuxerRequest) {
+	select {
+	case m.request <- req:
+	case <-m.ctx.Done():
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+	}
+}
+
+// OnReaderAccepted implements reader.
+func (m *dashMuxer) OnReaderAccepted() {
+	m.log(logger.Info, "is muxing into DASH")
+}
+
+// OnReaderFrame implements reader.
+func (m *dashMuxer) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType == gortsplib.StreamTypeRTP {
+		m.ringBuffer.Push(dashMuxerTrackIDPayloadPair{trackID, payload})
+	}
+}
+
+// OnReaderAPIDescribe implements reader.
+func (m *dashMuxer) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"dashMuxer"}
+}
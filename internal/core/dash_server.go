@@ -0,0 +1,227 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	gopath "path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type dashServerParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+type dashServer struct {
+	dashMuxerCloseAfter time.Duration
+	dashSegmentCount    int
+	dashSegmentDuration time.Duration
+	dashAllowOrigin     string
+	readBufferCount     int
+	stats               *stats
+	pathManager         *pathManager
+	parent              dashServerParent
+
+	ctx       context.Context
+	ctxCancel func()
+	wg        sync.WaitGroup
+	ln        net.Listener
+	muxers    map[string]*dashMuxer
+
+	// in
+	request    chan dashServerRequest
+	muxerClose chan *dashMuxer
+}
+
+type dashServerRequest struct {
+	dir string
+	req dashMuxerRequest
+}
+
+func newDASHServer(
+	parentCtx context.Context,
+	address string,
+	dashMuxerCloseAfter time.Duration,
+	dashSegmentCount int,
+	dashSegmentDuration time.Duration,
+	dashAllowOrigin string,
+	readBufferCount int,
+	stats *stats,
+	pathManager *pathManager,
+	parent dashServerParent,
+) (*dashServer, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &dashServer{
+		dashMuxerCloseAfter: dashMuxerCloseAfter,
+		dashSegmentCount:    dashSegmentCount,
+		dashSegmentDuration: dashSegmentDuration,
+		dashAllowOrigin:     dashAllowOrigin,
+		readBufferCount:     readBufferCount,
+		stats:               stats,
+		pathManager:         pathManager,
+		parent:              parent,
+		ctx:                 ctx,
+		ctxCancel:           ctxCancel,
+		ln:                  ln,
+		muxers:              make(map[string]*dashMuxer),
+		request:             make(chan dashServerRequest),
+		muxerClose:          make(chan *dashMuxer),
+	}
+
+	s.Log(logger.Info, "listener opened on "+address)
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// Log is the main logging function.
+func (s *dashServer) Log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[DASH] "+format, append([]interface{}{}, args...)...)
+}
+
+func (s *dashServer) close() {
+	s.ctxCancel()
+	s.wg.Wait()
+	s.Log(logger.Info, "closed")
+}
+
+func (s *dashServer) run() {
+	defer s.wg.Done()
+
+	hs := &http.Server{Handler: s}
+	go hs.Serve(s.ln)
+
+outer:
+	for {
+		select {
+		case req := <-s.request:
+			m, ok := s.muxers[req.dir]
+			if !ok {
+				m = newDASHMuxer(
+					s.ctx,
+					s.dashMuxerCloseAfter,
+					s.dashSegmentCount,
+					s.dashSegmentDuration,
+					s.readBufferCount,
+					&s.wg,
+					s.stats,
+					req.dir,
+					s.pathManager,
+					s)
+				s.muxers[req.dir] = m
+			}
+			m.OnRequest(req.req)
+
+		case m := <-s.muxerClose:
+			if m2, ok := s.muxers[m.PathName()]; !ok || m2 != m {
+				continue
+			}
+			delete(s.muxers, m.PathName())
+
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+
+	hs.Shutdown(context.Background())
+}
+
+// ServeHTTP implements http.Handler.
+func (s *dashServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Log(logger.Info, "[conn %v] %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+
+	w.Header().Add("Access-Control-Allow-Origin", s.dashAllowOrigin)
+	w.Header().Add("Access-Control-Allow-Credentials", "true")
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodOptions:
+		w.Header().Add("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Add("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.WriteHeader(http.StatusOK)
+		return
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// remove leading prefix
+	pa := r.URL.Path[1:]
+
+	if !strings.HasSuffix(pa, ".mpd") && !strings.HasSuffix(pa, ".mp4") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	dir, fname := gopath.Dir(pa), gopath.Base(pa)
+	if dir == "." {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	ip := httpClientIP(r, nil)
+
+	cres := make(chan io.Reader)
+	req := dashServerRequest{
+		dir: dir,
+		req: dashMuxerRequest{
+			File: fname,
+			IP:   ip,
+			Req:  r,
+			W:    w,
+			Res:  cres,
+		},
+	}
+
+	select {
+	case s.request <- req:
+		res := <-cres
+
+		if res != nil {
+			ipStats := s.stats.IPs.acquire(ip)
+			defer s.stats.IPs.release(ipStats)
+
+			buf := make([]byte, 4096)
+			for {
+				n, err := res.Read(buf)
+				if err != nil {
+					return
+				}
+
+				n, err = w.Write(buf[:n])
+				atomic.AddInt64(ipStats.BytesSent, int64(n))
+				if err != nil {
+					return
+				}
+			}
+		}
+
+	case <-s.ctx.Done():
+	}
+}
+
+// OnMuxerClose is called by dashMuxer.
+func (s *dashServer) OnMuxerClose(m *dashMuxer) {
+	select {
+	case s.muxerClose <- m:
+	case <-s.ctx.Done():
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDASHServer(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"dash: yes\n" +
+		"dashSegmentDuration: 1s\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	videoTrack, err := gortsplib.NewTrackH264(96,
+		[]byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x40},
+		[]byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{0x12, 0x10})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{videoTrack, audioTrack})
+	require.NoError(t, err)
+	defer source.Close()
+
+	get := func(u string) (int, string) {
+		res, err := http.Get(u)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		byts, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(byts)
+	}
+
+	// the first request starts the muxer, that doesn't have any segment yet
+	code, _ := get("http://localhost:8889/teststream/manifest.mpd")
+	require.Equal(t, http.StatusNotFound, code)
+
+	time.Sleep(500 * time.Millisecond)
+
+	videoEnc := rtph264.NewEncoder(96, nil, nil, nil)
+	audioEnc := rtpaac.NewEncoder(97, 44100, nil, nil, nil)
+
+	for i := 0; i < 4; i++ {
+		pkts, err := videoEnc.Encode([][]byte{{0x05, 0x01}}, time.Duration(i)*time.Second)
+		require.NoError(t, err)
+		for _, pkt := range pkts {
+			err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+			require.NoError(t, err)
+		}
+
+		pkts, err = audioEnc.Encode([][]byte{{0x01, 0x02, 0x03, 0x04}}, time.Duration(i)*time.Second)
+		require.NoError(t, err)
+		for _, pkt := range pkts {
+			err := source.WriteFrame(1, gortsplib.StreamTypeRTP, pkt)
+			require.NoError(t, err)
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	code, mpd := get("http://localhost:8889/teststream/manifest.mpd")
+	require.Equal(t, http.StatusOK, code)
+	require.Regexp(t, `type="dynamic"`, mpd)
+	require.Regexp(t, `codecs="avc1.640028"`, mpd)
+	require.Regexp(t, `codecs="mp4a.40.2"`, mpd)
+
+	ma := regexp.MustCompile(`<S t="([0-9]+)" d="90000"/>`).FindStringSubmatch(mpd)
+	require.NotNil(t, ma)
+
+	code, _ = get("http://localhost:8889/teststream/video_init.mp4")
+	require.Equal(t, http.StatusOK, code)
+
+	code, _ = get("http://localhost:8889/teststream/audio_init.mp4")
+	require.Equal(t, http.StatusOK, code)
+
+	code, seg := get("http://localhost:8889/teststream/video_" + ma[1] + ".mp4")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "moof", seg[4:8])
+
+	code, _ = get("http://localhost:8889/teststream/video_123.mp4")
+	require.Equal(t, http.StatusNotFound, code)
+}
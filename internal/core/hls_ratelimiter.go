@@ -0,0 +1,76 @@
+package core
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	hlsRateLimiterCleanupPeriod = 10 * time.Second
+)
+
+type hlsRateLimiterBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// hlsRateLimiter limits the requests per second of each IP with a token bucket,
+// that allows bursts of up to one second of requests.
+type hlsRateLimiter struct {
+	rate float64
+
+	mutex       sync.Mutex
+	buckets     map[string]*hlsRateLimiterBucket
+	lastCleanup time.Time
+}
+
+func newHLSRateLimiter(rate int) *hlsRateLimiter {
+	return &hlsRateLimiter{
+		rate:        float64(rate),
+		buckets:     make(map[string]*hlsRateLimiterBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// allow returns whether a request of the given IP can be performed.
+func (l *hlsRateLimiter) allow(ip net.IP) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+
+	// buckets that have not been used for a second are full, and can be removed
+	if now.Sub(l.lastCleanup) >= hlsRateLimiterCleanupPeriod {
+		l.lastCleanup = now
+		for key, b := range l.buckets {
+			if now.Sub(b.last) >= time.Second {
+				delete(l.buckets, key)
+			}
+		}
+	}
+
+	key := ip.String()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &hlsRateLimiterBucket{
+			tokens: l.rate,
+			last:   now,
+		}
+		l.buckets[key] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		if b.tokens > l.rate {
+			b.tokens = l.rate
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
@@ -3,10 +3,17 @@ package core
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	gopath "path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,22 +23,43 @@ import (
 	"github.com/aler9/gortsplib/pkg/ringbuffer"
 	"github.com/aler9/gortsplib/pkg/rtpaac"
 	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/credential"
 	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
 	"github.com/aler9/rtsp-simple-server/internal/hls"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
 const (
-	closeCheckPeriod     = 1 * time.Second
-	closeAfterInactivity = 60 * time.Second
+	closeCheckPeriod = 1 * time.Second
+
+	// when the source of the path is lost, the remuxer tries
+	// to read the path again with this period.
+	hlsRemuxerRetryPause = 1 * time.Second
+
+	// file name of the encryption key, when it is served by the server.
+	hlsEncryptionKeyFile = "encryption.key"
+
+	// file name of the playlist of the audio-only variant.
+	hlsAudioPlaylistName = "audio.m3u8"
+
+	// prefix of segments of the audio-only variant, that allows to tell them
+	// apart from the ones of the main playlist.
+	hlsAudioSegmentPrefix = "audio_"
+
+	// group ID of the alternate audio renditions.
+	hlsAudioGroupID = "audio"
 )
 
 const index = `<!DOCTYPE html>
 <html>
 <head>
 <meta charset="utf-8">
+<title>%s</title>
 <style>
 #video {
 	width: 600px;
@@ -42,7 +70,7 @@ const index = `<!DOCTYPE html>
 </head>
 <body>
 
-<script src="https://cdn.jsdelivr.net/npm/hls.js@1.0.0"></script>
+<script src="%s"></script>
 <video id="video" muted controls></video>
 <script>
 
@@ -63,7 +91,7 @@ const create = () => {
 		}
 	});
 
-	hls.loadSource('stream.m3u8');
+	hls.loadSource('%s' + window.location.search);
 	hls.attachMedia(video);
 
 	video.play();
@@ -76,78 +104,175 @@ create();
 </html>
 `
 
+var errHLSRemuxerNoSource = errors.New("source is not available")
+
 type hlsRemuxerRequest struct {
-	Dir  string
-	File string
-	Req  *http.Request
-	W    http.ResponseWriter
-	Res  chan io.Reader
+	Dir           string
+	File          string
+	Direct        bool
+	BasePath      string
+	SegmentPrefix string
+	SegmentQuery  string
+	Session       string
+	IP            net.IP
+	Req           *http.Request
+	W             http.ResponseWriter
+	User          *string // filled with the authenticated user, before Res is written
+	Res           chan io.Reader
+}
+
+type hlsRemuxerVariantReq struct {
+	PathName string
+	Res      chan *hls.Variant
 }
 
 type hlsRemuxerTrackIDPayloadPair struct {
-	trackID int
-	buf     []byte
+	trackID    int
+	streamType gortsplib.StreamType
+	buf        []byte
+	ts         time.Time
+}
+
+// ntpTimeToTime converts a NTP timestamp, in the format used by RTCP sender
+// reports, into a time.Time.
+func ntpTimeToTime(v uint64) time.Time {
+	// seconds between 1900-01-01 and 1970-01-01
+	const ntpEpochOffset = 2208988800
+
+	secs := int64(v>>32) - ntpEpochOffset
+	nsecs := int64(((v & 0xFFFFFFFF) * 1000000000) >> 32)
+	return time.Unix(secs, nsecs)
 }
 
 type hlsRemuxerPathManager interface {
 	OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
+	OnConfGet(req pathConfGetReq) pathConfGetRes
 }
 
 type hlsRemuxerParent interface {
 	Log(logger.Level, string, ...interface{})
 	OnRemuxerClose(*hlsRemuxer)
+	OnRemuxerVariantRequest(pathName string) *hls.Variant
 }
 
 type hlsRemuxer struct {
-	hlsAlwaysRemux     bool
-	hlsSegmentCount    int
-	hlsSegmentDuration time.Duration
-	readBufferCount    int
-	wg                 *sync.WaitGroup
-	pathName           string
-	pathManager        hlsRemuxerPathManager
-	parent             hlsRemuxerParent
+	hlsAlwaysRemux       bool
+	hlsRemuxerCloseAfter time.Duration
+	hlsSessions          bool
+	hlsSessionTimeout    time.Duration
+	hlsEndList           bool
+	hlsSegmentCount      int
+	hlsSegmentDuration   time.Duration
+	hlsLowLatency        bool
+	hlsPartDuration      time.Duration
+	hlsSegmentFormat     hls.SegmentFormat
+	hlsPlaylistName      string
+	hlsSegmentName       string
+	hlsEncryption        bool
+	hlsEncryptionKey     []byte
+	hlsEncryptionKeyURI  string
+	hlsPlayerDisable     bool
+	hlsPlayerScriptURL   string
+	readBufferCount      int
+	wg                   *sync.WaitGroup
+	oidc                 *oidcAuth
+	stats                *stats
+	pathName             string
+	pathManager          hlsRemuxerPathManager
+	parent               hlsRemuxerParent
 
 	ctx             context.Context
 	ctxCancel       func()
 	path            *path
 	ringBuffer      *ringbuffer.RingBuffer
+	latency         *latencyProbe
+	metadata        []streamMetadataEntry
 	lastRequestTime *int64
 	muxer           *hls.Muxer
+	audioMuxer      *hls.Muxer
+	altAudioMuxers  []*hls.Muxer
+	masterConf      *conf.PathConf
 	requests        []hlsRemuxerRequest
+	variantRequests []hlsRemuxerVariantReq
 
 	// in
-	request chan hlsRemuxerRequest
+	request        chan hlsRemuxerRequest
+	variantRequest chan hlsRemuxerVariantReq
+	apiMetadata    chan apiHLSRemuxersMetadataReq
+	sourceLost     chan struct{}
+	metadataUpdate chan []streamMetadataEntry
 }
 
 func newHLSRemuxer(
 	parentCtx context.Context,
 	hlsAlwaysRemux bool,
+	hlsRemuxerCloseAfter time.Duration,
+	hlsSessions bool,
+	hlsSessionTimeout time.Duration,
+	hlsEndList bool,
 	hlsSegmentCount int,
 	hlsSegmentDuration time.Duration,
+	hlsLowLatency bool,
+	hlsPartDuration time.Duration,
+	hlsSegmentFormat hls.SegmentFormat,
+	hlsPlaylistName string,
+	hlsSegmentName string,
+	hlsEncryption bool,
+	hlsEncryptionKey []byte,
+	hlsEncryptionKeyURI string,
+	hlsPlayerDisable bool,
+	hlsPlayerScriptURL string,
 	readBufferCount int,
 	wg *sync.WaitGroup,
+	oidc *oidcAuth,
+	stats *stats,
 	pathName string,
 	pathManager hlsRemuxerPathManager,
 	parent hlsRemuxerParent) *hlsRemuxer {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	r := &hlsRemuxer{
-		hlsAlwaysRemux:     hlsAlwaysRemux,
-		hlsSegmentCount:    hlsSegmentCount,
-		hlsSegmentDuration: hlsSegmentDuration,
-		readBufferCount:    readBufferCount,
-		wg:                 wg,
-		pathName:           pathName,
-		pathManager:        pathManager,
-		parent:             parent,
-		ctx:                ctx,
-		ctxCancel:          ctxCancel,
+		hlsAlwaysRemux:       hlsAlwaysRemux,
+		hlsRemuxerCloseAfter: hlsRemuxerCloseAfter,
+		hlsSessions:          hlsSessions,
+		hlsSessionTimeout:    hlsSessionTimeout,
+		hlsEndList:           hlsEndList,
+		hlsSegmentCount:      hlsSegmentCount,
+		hlsSegmentDuration:   hlsSegmentDuration,
+		hlsLowLatency:        hlsLowLatency,
+		hlsPartDuration:      hlsPartDuration,
+		hlsSegmentFormat:     hlsSegmentFormat,
+		hlsPlaylistName:      hlsPlaylistName,
+		hlsSegmentName:       hlsSegmentName,
+		hlsEncryption:        hlsEncryption,
+		hlsEncryptionKey:     hlsEncryptionKey,
+		hlsEncryptionKeyURI:  hlsEncryptionKeyURI,
+		hlsPlayerDisable:     hlsPlayerDisable,
+		hlsPlayerScriptURL:   hlsPlayerScriptURL,
+		readBufferCount:      readBufferCount,
+		wg:                   wg,
+		oidc:                 oidc,
+		stats:                stats,
+		pathName:             pathName,
+		pathManager:          pathManager,
+		parent:               parent,
+		ctx:                  ctx,
+		ctxCancel:            ctxCancel,
 		lastRequestTime: func() *int64 {
 			v := time.Now().Unix()
 			return &v
 		}(),
-		request: make(chan hlsRemuxerRequest),
+		request:        make(chan hlsRemuxerRequest),
+		variantRequest: make(chan hlsRemuxerVariantReq),
+		apiMetadata:    make(chan apiHLSRemuxersMetadataReq),
+		sourceLost:     make(chan struct{}, 1),
+		metadataUpdate: make(chan []streamMetadataEntry),
+	}
+
+	// when a key is not provided, a random key is generated for each stream
+	if r.hlsEncryption && r.hlsEncryptionKey == nil {
+		r.hlsEncryptionKey = make([]byte, 16)
+		rand.Read(r.hlsEncryptionKey)
 	}
 
 	r.log(logger.Info, "created")
@@ -158,8 +283,14 @@ func newHLSRemuxer(
 	return r
 }
 
+// Close implements reader.
+// It is called by the path when its source is lost; the remuxer keeps
+// serving existing segments and waits for the source to come back.
 func (r *hlsRemuxer) Close() {
-	r.ctxCancel()
+	select {
+	case r.sourceLost <- struct{}{}:
+	default:
+	}
 }
 
 func (r *hlsRemuxer) log(level logger.Level, format string, args ...interface{}) {
@@ -175,23 +306,88 @@ func (r *hlsRemuxer) run() {
 	defer r.wg.Done()
 	defer r.log(logger.Info, "destroyed")
 
-	remuxerCtx, remuxerCtxCancel := context.WithCancel(context.Background())
 	remuxerReady := make(chan struct{})
 	remuxerErr := make(chan error)
-	go func() {
-		remuxerErr <- r.runRemuxer(remuxerCtx, remuxerReady)
-	}()
+	var remuxerCtxCancel func()
 
+	startRemuxer := func() {
+		var remuxerCtx context.Context
+		remuxerCtx, remuxerCtxCancel = context.WithCancel(context.Background())
+		go func() {
+			remuxerErr <- r.runRemuxer(remuxerCtx, remuxerReady)
+		}()
+	}
+
+	isRunning := false
 	isReady := false
 
+	res := r.pathManager.OnConfGet(pathConfGetReq{PathName: r.pathName})
+	switch {
+	case res.Err == nil && res.Conf.HLSDisable:
+		// the remuxer is closed as soon as the loop starts, and pending
+		// requests are answered with 404
+		r.log(logger.Info, "ERR: HLS is disabled on this path")
+		r.ctxCancel()
+
+	// paths with variants are not read, a master playlist is served instead
+	case res.Err == nil && res.Conf.HLSVariants != nil:
+		r.masterConf = res.Conf
+		isReady = true
+
+	default:
+		startRemuxer()
+		isRunning = true
+	}
+
+	retryTimer := newEmptyTimer()
+	defer retryTimer.Stop()
+
+	closeCheckTicker := time.NewTicker(closeCheckPeriod)
+	defer closeCheckTicker.Stop()
+
 outer:
 	for {
 		select {
 		case <-r.ctx.Done():
-			remuxerCtxCancel()
-			<-remuxerErr
+			if isRunning {
+				remuxerCtxCancel()
+				<-remuxerErr
+			}
 			break outer
 
+		case <-r.sourceLost:
+			if isRunning {
+				remuxerCtxCancel()
+				<-remuxerErr
+				isRunning = false
+			}
+
+			if r.muxer == nil {
+				break outer
+			}
+
+			if r.hlsEndList {
+				err := r.endPlaylists()
+				if err != nil {
+					r.log(logger.Info, "ERR: %s", err)
+					break outer
+				}
+			}
+
+			r.log(logger.Info, "source lost, waiting for it to come back")
+			retryTimer = time.NewTimer(hlsRemuxerRetryPause)
+
+		case <-retryTimer.C:
+			startRemuxer()
+			isRunning = true
+
+		case <-closeCheckTicker.C:
+			// when the source is lost, close after a period without requests
+			t := time.Unix(atomic.LoadInt64(r.lastRequestTime), 0)
+			if !isRunning && time.Since(t) >= r.hlsRemuxerCloseAfter {
+				break outer
+			}
+
 		case req := <-r.request:
 			if isReady {
 				r.handleRequest(req)
@@ -199,15 +395,56 @@ outer:
 				r.requests = append(r.requests, req)
 			}
 
+		case req := <-r.variantRequest:
+			if isReady {
+				r.handleVariantRequest(req)
+			} else {
+				r.variantRequests = append(r.variantRequests, req)
+			}
+
+		case req := <-r.apiMetadata:
+			if !isReady {
+				req.Res <- apiHLSRemuxersMetadataRes{Err: fmt.Errorf("remuxer is not ready yet")}
+				continue
+			}
+			req.Res <- apiHLSRemuxersMetadataRes{Err: r.writeMetadata(req.Tag)}
+
+		case metadata := <-r.metadataUpdate:
+			r.metadata = metadata
+
 		case <-remuxerReady:
 			isReady = true
 			for _, req := range r.requests {
 				r.handleRequest(req)
 			}
 			r.requests = nil
+			for _, req := range r.variantRequests {
+				r.handleVariantRequest(req)
+			}
+			r.variantRequests = nil
 
 		case err := <-remuxerErr:
 			remuxerCtxCancel()
+			isRunning = false
+
+			// if the source is not available yet, try again
+			if err == errHLSRemuxerNoSource && r.muxer != nil {
+				retryTimer = time.NewTimer(hlsRemuxerRetryPause)
+				continue
+			}
+
+			// if the source is starting, tell clients when to come back
+			if terr, ok := err.(pathErrNotReady); ok {
+				r.log(logger.Info, "source is starting, asking clients to retry")
+				for _, req := range r.requests {
+					req.W.Header().Set("Retry-After", terr.RetryAfterSeconds())
+					req.W.WriteHeader(http.StatusServiceUnavailable)
+					req.Res <- nil
+				}
+				r.requests = nil
+				break outer
+			}
+
 			if err != nil {
 				r.log(logger.Info, "ERR: %s", err)
 			}
@@ -217,6 +454,27 @@ outer:
 
 	r.ctxCancel()
 
+	for _, req := range r.requests {
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+	}
+
+	for _, req := range r.variantRequests {
+		req.Res <- nil
+	}
+
+	if r.muxer != nil {
+		r.muxer.Close()
+	}
+
+	if r.audioMuxer != nil {
+		r.audioMuxer.Close()
+	}
+
+	for _, m := range r.altAudioMuxers {
+		m.Close()
+	}
+
 	r.parent.OnRemuxerClose(r)
 }
 
@@ -228,25 +486,72 @@ func (r *hlsRemuxer) runRemuxer(remuxerCtx context.Context, remuxerReady chan st
 		ValidateCredentials: nil,
 	})
 	if res.Err != nil {
+		if r.muxer != nil {
+			return errHLSRemuxerNoSource
+		}
 		return res.Err
 	}
 
 	r.path = res.Path
 
-	defer func() {
+	// HLS can be disabled by a configuration reload
+	if r.path.Conf().HLSDisable {
+		r.path.OnReaderRemove(pathReaderRemoveReq{Author: r})
+		return fmt.Errorf("HLS is disabled on this path")
+	}
+
+	// when the audio track can't be muxed, the stream is read from the path
+	// where it is transcoded to AAC. r.path is kept, since it provides the
+	// configuration of the stream.
+	if r.path.Conf().HLSAudioTranscode && hlsAudioNeedsTranscode(res.Stream.tracks()) {
 		r.path.OnReaderRemove(pathReaderRemoveReq{Author: r})
+
+		res = r.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
+			Author:              r,
+			PathName:            hlsAudioTranscodePath(r.pathName),
+			IP:                  nil,
+			ValidateCredentials: nil,
+		})
+		if res.Err != nil {
+			if r.muxer != nil {
+				return errHLSRemuxerNoSource
+			}
+
+			// the transcoder needs some time to start
+			return pathErrNotReady{PathName: r.pathName, RetryAfter: time.Second}
+		}
+	}
+
+	readPath := res.Path
+
+	defer func() {
+		readPath.OnReaderRemove(pathReaderRemoveReq{Author: r})
 	}()
 
+	// metadata is used by the master playlist, that is served by run(),
+	// and is sent before the remuxer becomes ready.
+	select {
+	case r.metadataUpdate <- streamMetadataEntries(res.Stream.metadata):
+	case <-remuxerCtx.Done():
+		return fmt.Errorf("terminated")
+	}
+
 	var videoTrack *gortsplib.Track
 	videoTrackID := -1
 	var h264SPS []byte
 	var h264PPS []byte
 	var h264Decoder *rtph264.Decoder
+	var h265Params [][]byte
+	var h265Decoder *h265.Decoder
 	var audioTrack *gortsplib.Track
 	audioTrackID := -1
-	var aacConfig rtpaac.MPEG4AudioConfig
 	var aacDecoder *rtpaac.Decoder
 
+	// additional audio tracks are exposed as alternate renditions
+	var altAudioTracks []*gortsplib.Track
+	altAudioTrackIDs := make(map[int]int)
+	var altAACDecoders []*rtpaac.Decoder
+
 	for i, t := range res.Stream.tracks() {
 		if t.IsH264() {
 			if videoTrack != nil {
@@ -264,55 +569,198 @@ func (r *hlsRemuxer) runRemuxer(remuxerCtx context.Context, remuxerReady chan st
 
 			h264Decoder = rtph264.NewDecoder()
 
-		} else if t.IsAAC() {
-			if audioTrack != nil {
+		} else if h265.IsTrack(t) {
+			if videoTrack != nil {
 				return fmt.Errorf("can't read track %d with HLS: too many tracks", i+1)
 			}
 
-			audioTrack = t
-			audioTrackID = i
+			videoTrack = t
+			videoTrackID = i
+
+			// when parameters are not in the SDP, they are sent in-band and left untouched
+			vps, sps, pps, err := h265.ExtractParams(t)
+			if err == nil {
+				h265Params = [][]byte{vps, sps, pps}
+			}
+
+			h265Decoder = h265.NewDecoder()
 
+		} else if t.IsAAC() {
 			byts, err := t.ExtractDataAAC()
 			if err != nil {
 				return err
 			}
 
+			var aacConfig rtpaac.MPEG4AudioConfig
 			err = aacConfig.Decode(byts)
 			if err != nil {
 				return err
 			}
 
-			aacDecoder = rtpaac.NewDecoder(aacConfig.SampleRate)
+			if audioTrack == nil {
+				audioTrack = t
+				audioTrackID = i
+				aacDecoder = rtpaac.NewDecoder(aacConfig.SampleRate)
+			} else {
+				altAudioTrackIDs[i] = len(altAudioTracks)
+				altAudioTracks = append(altAudioTracks, t)
+				altAACDecoders = append(altAACDecoders, rtpaac.NewDecoder(aacConfig.SampleRate))
+			}
 		}
 	}
 
 	if videoTrack == nil && audioTrack == nil {
-		return fmt.Errorf("the stream doesn't contain an H264 track or an AAC track")
+		return fmt.Errorf("the stream doesn't contain an H264, H265 or AAC track")
 	}
 
-	var err error
-	r.muxer, err = hls.NewMuxer(
-		r.hlsSegmentCount,
-		r.hlsSegmentDuration,
-		videoTrack,
-		audioTrack,
-	)
-	if err != nil {
-		return err
-	}
-	defer r.muxer.Close()
+	if r.muxer == nil {
+		hlsSegmentCount := r.hlsSegmentCount
+		if v := r.path.Conf().HLSSegmentCount; v != 0 {
+			hlsSegmentCount = v
+		}
 
-	remuxerReady <- struct{}{}
+		hlsSegmentDuration := r.hlsSegmentDuration
+		if v := r.path.Conf().HLSSegmentDuration; v != 0 {
+			hlsSegmentDuration = v
+		}
+
+		var hlsPartDuration time.Duration
+		if r.hlsLowLatency {
+			hlsPartDuration = r.hlsPartDuration
+		}
+
+		var encryptionKey []byte
+		encryptionKeyURI := r.hlsEncryptionKeyURI
+		if r.hlsEncryption {
+			encryptionKey = r.hlsEncryptionKey
+			if encryptionKeyURI == "" {
+				encryptionKeyURI = hlsEncryptionKeyFile
+			}
+		}
+
+		diskPlaylistPath := ""
+		if r.path.Conf().HLSDirectory != "" {
+			diskPlaylistPath = filepath.Join(r.path.Conf().HLSDirectory, r.pathName, r.hlsPlaylistName)
+		}
+
+		var err error
+		r.muxer, err = hls.NewMuxer(
+			hlsSegmentCount,
+			hlsSegmentDuration,
+			r.path.Conf().HLSDVRWindow,
+			hlsPartDuration,
+			r.hlsSegmentFormat,
+			hlsSegmentName(r.hlsSegmentName, r.pathName),
+			encryptionKey,
+			encryptionKeyURI,
+			diskPlaylistPath,
+			videoTrack,
+			audioTrack,
+		)
+		if err != nil {
+			return err
+		}
+
+		// the audio-only variant is useful only when the stream contains video too
+		if r.path.Conf().HLSAudioOnlyVariant && videoTrack != nil && audioTrack != nil {
+			r.audioMuxer, err = hls.NewMuxer(
+				hlsSegmentCount,
+				hlsSegmentDuration,
+				r.path.Conf().HLSDVRWindow,
+				hlsPartDuration,
+				r.hlsSegmentFormat,
+				hlsAudioSegmentPrefix+hlsSegmentName(r.hlsSegmentName, r.pathName),
+				encryptionKey,
+				encryptionKeyURI,
+				"",
+				nil,
+				audioTrack,
+			)
+			if err != nil {
+				r.muxer.Close()
+				r.muxer = nil
+				return err
+			}
+		}
+
+		for i, t := range altAudioTracks {
+			m, err := hls.NewMuxer(
+				hlsSegmentCount,
+				hlsSegmentDuration,
+				r.path.Conf().HLSDVRWindow,
+				hlsPartDuration,
+				r.hlsSegmentFormat,
+				hlsAltAudioSegmentPrefix(i)+hlsSegmentName(r.hlsSegmentName, r.pathName),
+				encryptionKey,
+				encryptionKeyURI,
+				"",
+				nil,
+				t,
+			)
+			if err != nil {
+				for _, m := range r.altAudioMuxers {
+					m.Close()
+				}
+				r.altAudioMuxers = nil
+				if r.audioMuxer != nil {
+					r.audioMuxer.Close()
+					r.audioMuxer = nil
+				}
+				r.muxer.Close()
+				r.muxer = nil
+				return err
+			}
+
+			r.altAudioMuxers = append(r.altAudioMuxers, m)
+		}
+
+		remuxerReady <- struct{}{}
+	} else {
+		// the muxer survives source restarts, in order to allow players to resume
+		err := r.muxer.Restart(videoTrack, audioTrack)
+		if err != nil {
+			return err
+		}
+
+		if r.audioMuxer != nil && audioTrack != nil {
+			err := r.audioMuxer.Restart(nil, audioTrack)
+			if err != nil {
+				return err
+			}
+		}
+
+		// renditions are listed in the master playlist, therefore their
+		// number can't change; tracks in excess are ignored.
+		for i, m := range r.altAudioMuxers {
+			if i >= len(altAudioTracks) {
+				break
+			}
+
+			err := m.Restart(nil, altAudioTracks[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		r.log(logger.Info, "source is back, inserting a discontinuity")
+	}
 
 	r.ringBuffer = ringbuffer.New(uint64(r.readBufferCount))
+	r.latency = res.Stream.latency
+
+	readPath.OnReaderPlay(pathReaderPlayReq{Author: r})
 
-	r.path.OnReaderPlay(pathReaderPlayReq{Author: r})
+	tracks := res.Stream.tracks()
 
 	writerDone := make(chan error)
 	go func() {
 		writerDone <- func() error {
 			var videoBuf [][]byte
 
+			// RTP timestamps of the first packets, that are the origin of
+			// the timestamps computed by decoders.
+			initialTs := make(map[int]uint32)
+
 			for {
 				data, ok := r.ringBuffer.Pull()
 				if !ok {
@@ -320,7 +768,119 @@ func (r *hlsRemuxer) runRemuxer(remuxerCtx context.Context, remuxerReady chan st
 				}
 				pair := data.(hlsRemuxerTrackIDPayloadPair)
 
-				if videoTrack != nil && pair.trackID == videoTrackID {
+				if pair.streamType == gortsplib.StreamTypeRTCP {
+					// sender reports map timestamps to absolute time,
+					// that is used to fill EXT-X-PROGRAM-DATE-TIME tags
+					var muxers []*hls.Muxer
+					switch {
+					case pair.trackID == videoTrackID:
+						muxers = []*hls.Muxer{r.muxer}
+
+					case pair.trackID == audioTrackID:
+						if videoTrack == nil {
+							muxers = append(muxers, r.muxer)
+						}
+						if r.audioMuxer != nil {
+							muxers = append(muxers, r.audioMuxer)
+						}
+
+					default:
+						if j, ok := altAudioTrackIDs[pair.trackID]; ok && j < len(r.altAudioMuxers) {
+							muxers = []*hls.Muxer{r.altAudioMuxers[j]}
+						}
+					}
+
+					its, ok := initialTs[pair.trackID]
+					if len(muxers) == 0 || !ok {
+						continue
+					}
+
+					clockRate, err := tracks[pair.trackID].ClockRate()
+					if err != nil {
+						continue
+					}
+
+					pkts, err := rtcp.Unmarshal(pair.buf)
+					if err != nil {
+						r.log(logger.Warn, "unable to decode RTCP packet: %v", err)
+						continue
+					}
+
+					for _, pkt := range pkts {
+						if sr, ok := pkt.(*rtcp.SenderReport); ok {
+							pts := (time.Duration(sr.RTPTime) - time.Duration(its)) * time.Second /
+								time.Duration(clockRate)
+							for _, m := range muxers {
+								m.SetReferenceTime(pts, ntpTimeToTime(sr.NTPTime))
+							}
+						}
+					}
+					continue
+				}
+
+				if _, ok := initialTs[pair.trackID]; !ok {
+					var pkt rtp.Packet
+					if pkt.Unmarshal(pair.buf) == nil {
+						initialTs[pair.trackID] = pkt.Timestamp
+					}
+				}
+
+				if h265Decoder != nil && pair.trackID == videoTrackID {
+					var pkt rtp.Packet
+					err := pkt.Unmarshal(pair.buf)
+					if err != nil {
+						r.log(logger.Warn, "unable to decode RTP packet: %v", err)
+						continue
+					}
+
+					nalus, pts, err := h265Decoder.DecodeRTP(&pkt)
+					if err != nil {
+						if err != h265.ErrMorePacketsNeeded && err != h265.ErrNonStartingPacketAndNoPrevious {
+							r.log(logger.Warn, "unable to decode video track: %v", err)
+						}
+						continue
+					}
+
+					for _, nalu := range nalus {
+						typ := h265.NALUTypeOf(nalu)
+
+						if h265Params != nil {
+							// remove VPS, SPS, PPS
+							switch typ {
+							case h265.NALUTypeVPS, h265.NALUTypeSPS, h265.NALUTypePPS:
+								continue
+							}
+
+							// add VPS, SPS and PPS before random access points
+							if typ.IsRandomAccess() {
+								videoBuf = append(videoBuf, h265Params...)
+							}
+						}
+
+						// remove AUD
+						if typ == h265.NALUTypeAccessUnitDelimiter {
+							continue
+						}
+
+						videoBuf = append(videoBuf, nalu)
+					}
+
+					// RTP marker means that all the NALUs with the same PTS have been received.
+					// send them together.
+					if pkt.Marker {
+						err := r.muxer.WriteH265(pts, videoBuf)
+						if err != nil {
+							return err
+						}
+
+						if r.latency != nil {
+							r.latency.add("hls", time.Since(pair.ts))
+						}
+
+						videoBuf = nil
+					}
+
+				} else if videoTrack != nil && pair.trackID == videoTrackID {
 					var pkt rtp.Packet
 					err := pkt.Unmarshal(pair.buf)
 					if err != nil {
@@ -361,6 +921,10 @@ func (r *hlsRemuxer) runRemuxer(remuxerCtx context.Context, remuxerReady chan st
 							return err
 						}
 
+						if r.latency != nil {
+							r.latency.add("hls", time.Since(pair.ts))
+						}
+
 						videoBuf = nil
 					}
 
@@ -384,6 +948,38 @@ func (r *hlsRemuxer) runRemuxer(remuxerCtx context.Context, remuxerReady chan st
 					if err != nil {
 						return err
 					}
+
+					if r.audioMuxer != nil {
+						err = r.audioMuxer.WriteAAC(pts, aus)
+						if err != nil {
+							return err
+						}
+					}
+
+					if r.latency != nil {
+						r.latency.add("hls", time.Since(pair.ts))
+					}
+
+				} else if j, ok := altAudioTrackIDs[pair.trackID]; ok && j < len(r.altAudioMuxers) {
+					var pkt rtp.Packet
+					err := pkt.Unmarshal(pair.buf)
+					if err != nil {
+						r.log(logger.Warn, "unable to decode RTP packet: %v", err)
+						continue
+					}
+
+					aus, pts, err := altAACDecoders[j].DecodeRTP(&pkt)
+					if err != nil {
+						if err != rtpaac.ErrMorePacketsNeeded {
+							r.log(logger.Warn, "unable to decode audio track: %v", err)
+						}
+						continue
+					}
+
+					err = r.altAudioMuxers[j].WriteAAC(pts, aus)
+					if err != nil {
+						return err
+					}
 				}
 			}
 		}()
@@ -396,7 +992,9 @@ func (r *hlsRemuxer) runRemuxer(remuxerCtx context.Context, remuxerReady chan st
 		select {
 		case <-closeCheckTicker.C:
 			t := time.Unix(atomic.LoadInt64(r.lastRequestTime), 0)
-			if !r.hlsAlwaysRemux && time.Since(t) >= closeAfterInactivity {
+			// remuxers that write to disk are kept alive
+			if !r.hlsAlwaysRemux && r.path.Conf().HLSDirectory == "" &&
+				time.Since(t) >= r.hlsRemuxerCloseAfter {
 				r.ringBuffer.Close()
 				<-writerDone
 				return nil
@@ -416,12 +1014,16 @@ func (r *hlsRemuxer) runRemuxer(remuxerCtx context.Context, remuxerReady chan st
 func (r *hlsRemuxer) handleRequest(req hlsRemuxerRequest) {
 	atomic.StoreInt64(r.lastRequestTime, time.Now().Unix())
 
-	conf := r.path.Conf()
+	conf := r.masterConf
+	if conf == nil {
+		conf = r.path.Conf()
+	}
+
+	ip := req.IP
 
 	if conf.ReadIPsParsed != nil {
-		tmp, _, _ := net.SplitHostPort(req.Req.RemoteAddr)
-		ip := net.ParseIP(tmp)
 		if !ipEqualOrInRange(ip, conf.ReadIPsParsed) {
+			r.stats.IPs.onAuthFailure(ip)
 			r.log(logger.Info, "ERR: ip '%s' not allowed", ip)
 			req.W.WriteHeader(http.StatusUnauthorized)
 			req.Res <- nil
@@ -429,41 +1031,211 @@ func (r *hlsRemuxer) handleRequest(req hlsRemuxerRequest) {
 		}
 	}
 
-	if conf.ReadUser != "" {
+	if conf.ReadOIDC {
+		if r.oidc == nil {
+			req.W.WriteHeader(http.StatusUnauthorized)
+			req.Res <- nil
+			return
+		}
+
+		claims, err := r.oidc.authenticate(req.Req)
+		if err != nil {
+			if err != errOIDCNoToken {
+				r.stats.IPs.onAuthFailure(ip)
+				r.log(logger.Debug, "ERR: invalid OIDC token: %s", err)
+			}
+
+			// redirect browsers to the login page
+			if req.File == "" {
+				r.oidc.redirectToLogin(req.W, req.Req)
+				req.Res <- nil
+				return
+			}
+
+			req.W.WriteHeader(http.StatusUnauthorized)
+			req.Res <- nil
+			return
+		}
+
+		if !isOIDCUserAllowed(claims, conf.ReadOIDCUsers) {
+			r.stats.IPs.onAuthFailure(ip)
+			r.log(logger.Info, "ERR: OIDC user '%s' not allowed", claims.Subject)
+			req.W.WriteHeader(http.StatusForbidden)
+			req.Res <- nil
+			return
+		}
+
+		*req.User = claims.Subject
+	}
+
+	if conf.ResolvedReadUser() != "" {
 		user, pass, ok := req.Req.BasicAuth()
-		if !ok || user != conf.ReadUser || pass != conf.ReadPass {
+		if !ok {
+			// players that can't send headers can provide credentials
+			// through the query, that is then appended to the URLs of playlists.
+			query := req.Req.URL.Query()
+			if query.Get("user") != "" || query.Get("pass") != "" {
+				user, pass, ok = query.Get("user"), query.Get("pass"), true
+				vals := url.Values{"user": {user}, "pass": {pass}}
+				if req.Session != "" {
+					vals.Set("session", req.Session)
+				}
+				req.SegmentQuery = vals.Encode()
+			}
+		}
+
+		if !ok || !credential.Check(conf.ResolvedReadUser(), user) || !credential.Check(conf.ResolvedReadPass(), pass) {
+			// requests without credentials are part of the normal handshake
+			if ok {
+				r.stats.IPs.onAuthFailure(ip)
+			}
+
 			req.W.Header().Set("WWW-Authenticate", `Basic realm="rtsp-simple-server"`)
 			req.W.WriteHeader(http.StatusUnauthorized)
 			req.Res <- nil
 			return
 		}
+
+		*req.User = user
 	}
 
-	switch {
-	case req.File == "stream.m3u8":
-		r := r.muxer.Playlist()
-		if r == nil {
-			req.W.WriteHeader(http.StatusNotFound)
+	// the first playlist request establishes a session, whose token is
+	// then appended to the URLs of playlists and segments.
+	if r.hlsSessions && req.Session == "" && strings.HasSuffix(req.File, ".m3u8") {
+		id, err := r.stats.HLSViewers.newSession(r.pathName, ip, r.hlsSessionTimeout)
+		if err != nil {
+			req.W.WriteHeader(http.StatusInternalServerError)
 			req.Res <- nil
 			return
 		}
 
-		req.W.Header().Set("Content-Type", `application/x-mpegURL`)
-		req.Res <- r
+		query := req.Req.URL.Query()
+		query.Set("session", id)
+		req.W.Header().Set("Location", gopath.Base(req.Req.URL.Path)+"?"+query.Encode())
+		req.W.Header().Set("Cache-Control", "no-store")
+		req.W.WriteHeader(http.StatusFound)
+		req.Res <- nil
+		return
+	}
 
-	case strings.HasSuffix(req.File, ".ts"):
-		r := r.muxer.TSFile(req.File)
-		if r == nil {
+	switch {
+	case r.masterConf != nil && req.File == r.hlsPlaylistName:
+		// variants are queried in a separate routine, since they may not be ready yet
+		go r.serveMasterPlaylist(req, r.masterConf.HLSVariants, nil, nil, nil, r.masterConf)
+
+	case r.masterConf != nil && req.File != "":
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+
+	case req.File == r.hlsPlaylistName:
+		if r.hlsLowLatency && req.Req.URL.Query().Get("_HLS_msn") != "" {
+			// the playlist is sent when it contains the requested segment or part
+			go r.serveBlockingPlaylist(req, r.muxer)
+			return
+		}
+
+		r.servePlaylist(req, r.muxer)
+
+	case req.File == hlsAudioPlaylistName && r.audioMuxer != nil:
+		if r.hlsLowLatency && req.Req.URL.Query().Get("_HLS_msn") != "" {
+			go r.serveBlockingPlaylist(req, r.audioMuxer)
+			return
+		}
+
+		r.servePlaylist(req, r.audioMuxer)
+
+	case r.altAudioMuxer(req.File) != nil:
+		muxer := r.altAudioMuxer(req.File)
+
+		if r.hlsLowLatency && req.Req.URL.Query().Get("_HLS_msn") != "" {
+			go r.serveBlockingPlaylist(req, muxer)
+			return
+		}
+
+		r.servePlaylist(req, muxer)
+
+	case req.File == hlsMasterPlaylistName && r.hasMasterPlaylist(conf):
+		variants := []string{r.pathName}
+		for _, rendition := range conf.HLSRenditionsParsed {
+			variants = append(variants, hlsRenditionPath(r.pathName, rendition))
+		}
+
+		var audioVariant *hls.Variant
+		if r.audioMuxer != nil {
+			audioVariant = &hls.Variant{
+				URL:       hlsVariantURL(req.BasePath, req.Dir, false, r.pathName, hlsAudioPlaylistName),
+				Bandwidth: r.audioMuxer.Bandwidth(),
+				Codecs:    r.audioMuxer.Codecs(),
+			}
+		}
+
+		var audioRenditions []hls.AudioRendition
+		if r.altAudioMuxers != nil {
+			audioRenditions = append(audioRenditions, hls.AudioRendition{
+				GroupID: hlsAudioGroupID,
+				Name:    "audio1",
+				Default: true,
+			})
+
+			for i := range r.altAudioMuxers {
+				u := hlsVariantURL(req.BasePath, req.Dir, false, r.pathName, hlsAltAudioPlaylistName(i))
+				if req.SegmentQuery != "" {
+					u += "?" + req.SegmentQuery
+				}
+
+				audioRenditions = append(audioRenditions, hls.AudioRendition{
+					GroupID: hlsAudioGroupID,
+					Name:    "audio" + strconv.FormatInt(int64(i+2), 10),
+					URL:     u,
+				})
+			}
+		}
+
+		go r.serveMasterPlaylist(req, variants, audioVariant, audioRenditions, r.metadata, conf)
+
+	case r.hlsEncryption && r.hlsEncryptionKeyURI == "" && req.File == hlsEncryptionKeyFile:
+		req.W.Header().Set("Content-Type", "application/octet-stream")
+		req.W.Header().Set("Cache-Control", "no-store")
+		req.Res <- bytes.NewReader(r.hlsEncryptionKey)
+
+	case strings.HasSuffix(req.File, ".ts") || strings.HasSuffix(req.File, ".mp4"):
+		f := r.muxer.File(req.File)
+		if f == nil && r.audioMuxer != nil {
+			f = r.audioMuxer.File(req.File)
+		}
+		for _, m := range r.altAudioMuxers {
+			if f != nil {
+				break
+			}
+			f = m.File(req.File)
+		}
+		if f == nil {
 			req.W.WriteHeader(http.StatusNotFound)
 			req.Res <- nil
 			return
 		}
 
-		req.W.Header().Set("Content-Type", `video/MP2T`)
-		req.Res <- r
+		// the segment may still be in progress, in this case it's sent with
+		// chunked transfer encoding while it grows; prevent reverse proxies
+		// from buffering it.
+		if strings.HasSuffix(req.File, ".mp4") {
+			req.W.Header().Set("Content-Type", `video/mp4`)
+		} else {
+			req.W.Header().Set("Content-Type", `video/MP2T`)
+		}
+		req.W.Header().Set("X-Accel-Buffering", "no")
+		req.Res <- f
 
-	case req.File == "":
-		req.Res <- bytes.NewReader([]byte(index))
+	case req.File == "" && !r.hlsPlayerDisable:
+		title := conf.Title
+		if title == "" {
+			title = r.pathName
+		}
+		playlistName := r.hlsPlaylistName
+		if r.hasMasterPlaylist(conf) {
+			playlistName = hlsMasterPlaylistName
+		}
+		req.Res <- bytes.NewReader([]byte(fmt.Sprintf(index, html.EscapeString(title), html.EscapeString(r.hlsPlayerScriptURL), playlistName)))
 
 	default:
 		req.W.WriteHeader(http.StatusNotFound)
@@ -471,6 +1243,285 @@ func (r *hlsRemuxer) handleRequest(req hlsRemuxerRequest) {
 	}
 }
 
+func (r *hlsRemuxer) servePlaylist(req hlsRemuxerRequest, muxer *hls.Muxer) {
+	pl := muxer.Playlist(req.SegmentPrefix, req.SegmentQuery)
+	if pl == nil {
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+		return
+	}
+
+	req.W.Header().Set("Content-Type", `application/x-mpegURL`)
+	req.Res <- pl
+}
+
+func (r *hlsRemuxer) serveBlockingPlaylist(req hlsRemuxerRequest, muxer *hls.Muxer) {
+	query := req.Req.URL.Query()
+
+	msn, err := strconv.ParseUint(query.Get("_HLS_msn"), 10, 32)
+	if err != nil {
+		req.W.WriteHeader(http.StatusBadRequest)
+		req.Res <- nil
+		return
+	}
+
+	part := int64(-1)
+	if v := query.Get("_HLS_part"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			req.W.WriteHeader(http.StatusBadRequest)
+			req.Res <- nil
+			return
+		}
+		part = int64(tmp)
+	}
+
+	// wait at most three target durations
+	ctx, ctxCancel := context.WithTimeout(r.ctx, 3*muxer.TargetDuration())
+	defer ctxCancel()
+
+	go func() {
+		select {
+		case <-req.Req.Context().Done():
+			ctxCancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err = muxer.WaitPlaylist(ctx, int(msn), int(part))
+	if err != nil {
+		if err == hls.ErrPlaylistRequestInvalid {
+			req.W.WriteHeader(http.StatusBadRequest)
+		} else {
+			req.W.WriteHeader(http.StatusServiceUnavailable)
+		}
+		req.Res <- nil
+		return
+	}
+
+	r.servePlaylist(req, muxer)
+}
+
+func (r *hlsRemuxer) serveMasterPlaylist(
+	req hlsRemuxerRequest,
+	pathNames []string,
+	audioVariant *hls.Variant,
+	audioRenditions []hls.AudioRendition,
+	metadata []streamMetadataEntry,
+	conf *conf.PathConf,
+) {
+	var variants []hls.Variant
+
+	for _, pathName := range pathNames {
+		v := r.parent.OnRemuxerVariantRequest(pathName)
+		if v == nil {
+			r.log(logger.Debug, "variant '%s' is not available", pathName)
+			continue
+		}
+
+		v.URL = hlsVariantURL(req.BasePath, req.Dir, req.Direct, pathName, r.hlsPlaylistName)
+		if req.SegmentQuery != "" {
+			v.URL += "?" + req.SegmentQuery
+		}
+
+		// alternate audio renditions belong to the stream of the master playlist
+		if pathName == r.pathName && audioRenditions != nil {
+			v.Audio = hlsAudioGroupID
+		}
+		variants = append(variants, *v)
+	}
+
+	if audioVariant != nil {
+		if req.SegmentQuery != "" {
+			audioVariant.URL += "?" + req.SegmentQuery
+		}
+		variants = append(variants, *audioVariant)
+	}
+
+	if variants == nil {
+		req.W.WriteHeader(http.StatusNotFound)
+		req.Res <- nil
+		return
+	}
+
+	var sessionData []hls.SessionData
+	if conf.Title != "" {
+		sessionData = append(sessionData, hls.SessionData{ID: "com.apple.hls.title", Value: conf.Title})
+	}
+	if conf.Description != "" {
+		sessionData = append(sessionData, hls.SessionData{ID: "com.apple.hls.description", Value: conf.Description})
+	}
+	for _, e := range metadata {
+		sessionData = append(sessionData, hls.SessionData{ID: "com.rtsp-simple-server.metadata." + e.Key, Value: e.Value})
+	}
+
+	req.W.Header().Set("Content-Type", `application/x-mpegURL`)
+	req.Res <- hls.MasterPlaylist(variants, audioRenditions, sessionData)
+}
+
+func (r *hlsRemuxer) handleVariantRequest(req hlsRemuxerVariantReq) {
+	atomic.StoreInt64(r.lastRequestTime, time.Now().Unix())
+
+	if r.muxer == nil {
+		req.Res <- nil
+		return
+	}
+
+	req.Res <- &hls.Variant{
+		Bandwidth: r.muxer.Bandwidth(),
+		Codecs:    r.muxer.Codecs(),
+	}
+}
+
+// writeMetadata writes an ID3 tag into all the playlists of the stream.
+func (r *hlsRemuxer) writeMetadata(tag []byte) error {
+	// paths with variants don't have a muxer
+	if r.muxer == nil {
+		return fmt.Errorf("path has no stream")
+	}
+
+	err := r.muxer.WriteID3(tag)
+	if err != nil {
+		return err
+	}
+
+	if r.audioMuxer != nil {
+		r.audioMuxer.WriteID3(tag)
+	}
+
+	for _, m := range r.altAudioMuxers {
+		m.WriteID3(tag)
+	}
+
+	return nil
+}
+
+// endPlaylists appends EXT-X-ENDLIST to all the playlists of the stream.
+func (r *hlsRemuxer) endPlaylists() error {
+	err := r.muxer.End()
+	if err != nil {
+		return err
+	}
+
+	if r.audioMuxer != nil {
+		r.audioMuxer.End()
+	}
+
+	for _, m := range r.altAudioMuxers {
+		m.End()
+	}
+
+	return nil
+}
+
+// hasMasterPlaylist returns whether the stream is served with a master playlist.
+func (r *hlsRemuxer) hasMasterPlaylist(conf *conf.PathConf) bool {
+	return conf.HLSRenditionsParsed != nil || r.audioMuxer != nil || r.altAudioMuxers != nil
+}
+
+// altAudioMuxer returns the muxer of the alternate audio rendition with the
+// given playlist name, if any.
+func (r *hlsRemuxer) altAudioMuxer(playlistName string) *hls.Muxer {
+	for i, m := range r.altAudioMuxers {
+		if playlistName == hlsAltAudioPlaylistName(i) {
+			return m
+		}
+	}
+	return nil
+}
+
+// hlsAltAudioPlaylistName returns the file name of the playlist of the i-th
+// alternate audio rendition. Numbering starts from 2, since the first
+// audio track is contained in the main playlist.
+func hlsAltAudioPlaylistName(i int) string {
+	return "audio" + strconv.FormatInt(int64(i+2), 10) + ".m3u8"
+}
+
+// hlsAltAudioSegmentPrefix returns the prefix of segments of the i-th
+// alternate audio rendition.
+func hlsAltAudioSegmentPrefix(i int) string {
+	return "audio" + strconv.FormatInt(int64(i+2), 10) + "_"
+}
+
+// isHLSAltAudioPlaylistName returns whether a file name can be the name
+// of the playlist of an alternate audio rendition.
+func isHLSAltAudioPlaylistName(name string) bool {
+	if !strings.HasPrefix(name, "audio") || !strings.HasSuffix(name, ".m3u8") {
+		return false
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "audio"), ".m3u8"), 10, 32)
+	return err == nil && n >= 2
+}
+
+// hlsVariantURL returns the URL of the playlist of a variant, relative to
+// the master playlist of masterDir, that is either requested directly
+// (/masterDir.m3u8) or inside the master directory (/masterDir/playlist).
+// When basePath is set, the URL is absolute.
+func hlsVariantURL(basePath string, masterDir string, direct bool, variant string, playlistName string) string {
+	if basePath != "" {
+		return basePath + variant + "/" + playlistName
+	}
+
+	depth := strings.Count(masterDir, "/")
+
+	if direct {
+		return strings.Repeat("../", depth) + variant + ".m3u8"
+	}
+
+	return strings.Repeat("../", depth+1) + variant + "/" + playlistName
+}
+
+// hlsSegmentName fills the path name into the template of segment names.
+// Slashes are replaced with underscores, since segment names can't contain them.
+func hlsSegmentName(template string, pathName string) string {
+	return strings.ReplaceAll(template, "$PATH", strings.ReplaceAll(pathName, "/", "_"))
+}
+
+// hlsRenditionPath returns the name of the path to which a rendition is published.
+func hlsRenditionPath(pathName string, rendition conf.HLSRendition) string {
+	return pathName + "_" + strconv.FormatInt(int64(rendition.Height), 10) + "p"
+}
+
+// hlsAudioTranscodePath returns the name of the path to which a stream
+// is published after its audio track is transcoded to AAC.
+func hlsAudioTranscodePath(pathName string) string {
+	return pathName + "_aac"
+}
+
+// hlsAudioNeedsTranscode returns whether a stream contains audio tracks
+// but none of them can be muxed into HLS.
+func hlsAudioNeedsTranscode(tracks gortsplib.Tracks) bool {
+	hasAudio := false
+	for _, t := range tracks {
+		if t.IsAAC() {
+			return false
+		}
+		if t.Media.MediaName.Media == "audio" {
+			hasAudio = true
+		}
+	}
+	return hasAudio
+}
+
+// OnVariantRequest is called by hlsServer.
+func (r *hlsRemuxer) OnVariantRequest(req hlsRemuxerVariantReq) {
+	select {
+	case r.variantRequest <- req:
+	case <-r.ctx.Done():
+		req.Res <- nil
+	}
+}
+
+// OnAPIMetadata is called by hlsServer (forwarded from api).
+func (r *hlsRemuxer) OnAPIMetadata(req apiHLSRemuxersMetadataReq) {
+	select {
+	case r.apiMetadata <- req:
+	case <-r.ctx.Done():
+		req.Res <- apiHLSRemuxersMetadataRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
 // OnRequest is called by hlsserver.Server (forwarded from ServeHTTP).
 func (r *hlsRemuxer) OnRequest(req hlsRemuxerRequest) {
 	select {
@@ -488,9 +1539,12 @@ func (r *hlsRemuxer) OnReaderAccepted() {
 
 // OnReaderFrame implements reader.
 func (r *hlsRemuxer) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
-	if streamType == gortsplib.StreamTypeRTP {
-		r.ringBuffer.Push(hlsRemuxerTrackIDPayloadPair{trackID, payload})
+	var ts time.Time
+	if streamType == gortsplib.StreamTypeRTP && r.latency != nil {
+		ts = time.Now()
 	}
+
+	r.ringBuffer.Push(hlsRemuxerTrackIDPayloadPair{trackID, streamType, payload, ts})
 }
 
 // OnReaderAPIDescribe implements reader.
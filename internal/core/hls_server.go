@@ -2,54 +2,129 @@ package core
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	gopath "path"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aler9/rtsp-simple-server/internal/hls"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
+// name of the playlist that is always accepted, in addition to the configured one,
+// since it is hardcoded by some players.
+const hlsPlaylistAlias = "index.m3u8"
+
+// name of the master playlist of paths with renditions.
+const hlsMasterPlaylistName = "master.m3u8"
+
+// key of the request context that contains the connection.
+type hlsServerConnKey struct{}
+
 type hlsServerParent interface {
 	Log(logger.Level, string, ...interface{})
 }
 
 type hlsServer struct {
-	hlsAlwaysRemux     bool
-	hlsSegmentCount    int
-	hlsSegmentDuration time.Duration
-	hlsAllowOrigin     string
-	readBufferCount    int
-	pathManager        *pathManager
-	parent             hlsServerParent
-
-	ctx       context.Context
-	ctxCancel func()
-	wg        sync.WaitGroup
-	ln        net.Listener
-	remuxers  map[string]*hlsRemuxer
+	hlsAlwaysRemux          bool
+	hlsRemuxerCloseAfter    time.Duration
+	hlsWriteTimeout         time.Duration
+	hlsMaxConnections       int
+	hlsSessions             bool
+	hlsSessionTimeout       time.Duration
+	hlsEndList              bool
+	hlsSegmentCount         int
+	hlsSegmentDuration      time.Duration
+	hlsLowLatency           bool
+	hlsPartDuration         time.Duration
+	hlsSegmentFormat        hls.SegmentFormat
+	hlsPlaylistName         string
+	hlsSegmentName          string
+	hlsEncryption           bool
+	hlsEncryptionKey        []byte
+	hlsEncryptionKeyURI     string
+	hlsAllowOrigin          string
+	hlsAllowOrigins         []*regexp.Regexp
+	hlsBasePath             string
+	hlsTrustedProxies       []interface{}
+	hlsPlaylistCacheControl string
+	hlsSegmentCacheControl  string
+	hlsPlayerDisable        bool
+	hlsPlayerScriptURL      string
+	readBufferCount         int
+	oidc                    *oidcAuth
+	certLoader              *certLoader
+	stats                   *stats
+	pathManager             *pathManager
+	parent                  hlsServerParent
+
+	ctx         context.Context
+	ctxCancel   func()
+	wg          sync.WaitGroup
+	ln          net.Listener
+	remuxers    map[string]*hlsRemuxer
+	connCount   int64
+	rateLimiter *hlsRateLimiter
 
 	// in
 	pathSourceReady chan *path
 	request         chan hlsRemuxerRequest
+	variantRequest  chan hlsRemuxerVariantReq
 	remuxerClose    chan *hlsRemuxer
+	apiMetadata     chan apiHLSRemuxersMetadataReq
 }
 
 func newHLSServer(
 	parentCtx context.Context,
 	address string,
+	socketMode os.FileMode,
+	hlsTLS bool,
+	hlsServerCert string,
+	hlsServerKey string,
 	hlsAlwaysRemux bool,
+	hlsRemuxerCloseAfter time.Duration,
+	hlsWriteTimeout time.Duration,
+	hlsMaxConnections int,
+	hlsRequestRateLimit int,
+	hlsSessions bool,
+	hlsSessionTimeout time.Duration,
+	hlsEndList bool,
 	hlsSegmentCount int,
 	hlsSegmentDuration time.Duration,
+	hlsLowLatency bool,
+	hlsPartDuration time.Duration,
+	hlsSegmentFormat hls.SegmentFormat,
+	hlsPlaylistName string,
+	hlsSegmentName string,
+	hlsEncryption bool,
+	hlsEncryptionKey []byte,
+	hlsEncryptionKeyURI string,
 	hlsAllowOrigin string,
+	hlsAllowOrigins []*regexp.Regexp,
+	hlsBasePath string,
+	hlsTrustedProxies []interface{},
+	hlsPlaylistCacheControl string,
+	hlsSegmentCacheControl string,
+	hlsPlayerDisable bool,
+	hlsPlayerScriptURL string,
 	readBufferCount int,
+	oidcIssuer string,
+	oidcClientID string,
+	oidcClientSecret string,
+	stats *stats,
 	pathManager *pathManager,
 	parent hlsServerParent,
 ) (*hlsServer, error) {
-	ln, err := net.Listen("tcp", address)
+	ln, err := hlsListen(address, socketMode)
 	if err != nil {
 		return nil, err
 	}
@@ -57,20 +132,60 @@ func newHLSServer(
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	s := &hlsServer{
-		hlsAlwaysRemux:     hlsAlwaysRemux,
-		hlsSegmentCount:    hlsSegmentCount,
-		hlsSegmentDuration: hlsSegmentDuration,
-		hlsAllowOrigin:     hlsAllowOrigin,
-		readBufferCount:    readBufferCount,
-		pathManager:        pathManager,
-		parent:             parent,
-		ctx:                ctx,
-		ctxCancel:          ctxCancel,
-		ln:                 ln,
-		remuxers:           make(map[string]*hlsRemuxer),
-		pathSourceReady:    make(chan *path),
-		request:            make(chan hlsRemuxerRequest),
-		remuxerClose:       make(chan *hlsRemuxer),
+		hlsAlwaysRemux:          hlsAlwaysRemux,
+		hlsRemuxerCloseAfter:    hlsRemuxerCloseAfter,
+		hlsWriteTimeout:         hlsWriteTimeout,
+		hlsMaxConnections:       hlsMaxConnections,
+		hlsSessions:             hlsSessions,
+		hlsSessionTimeout:       hlsSessionTimeout,
+		hlsEndList:              hlsEndList,
+		hlsSegmentCount:         hlsSegmentCount,
+		hlsSegmentDuration:      hlsSegmentDuration,
+		hlsLowLatency:           hlsLowLatency,
+		hlsPartDuration:         hlsPartDuration,
+		hlsSegmentFormat:        hlsSegmentFormat,
+		hlsPlaylistName:         hlsPlaylistName,
+		hlsSegmentName:          hlsSegmentName,
+		hlsEncryption:           hlsEncryption,
+		hlsEncryptionKey:        hlsEncryptionKey,
+		hlsEncryptionKeyURI:     hlsEncryptionKeyURI,
+		hlsAllowOrigin:          hlsAllowOrigin,
+		hlsAllowOrigins:         hlsAllowOrigins,
+		hlsBasePath:             hlsBasePath,
+		hlsTrustedProxies:       hlsTrustedProxies,
+		hlsPlaylistCacheControl: hlsPlaylistCacheControl,
+		hlsSegmentCacheControl:  hlsSegmentCacheControl,
+		hlsPlayerDisable:        hlsPlayerDisable,
+		hlsPlayerScriptURL:      hlsPlayerScriptURL,
+		readBufferCount:         readBufferCount,
+		oidc:                    newOIDCAuth(oidcIssuer, oidcClientID, oidcClientSecret),
+		stats:                   stats,
+		pathManager:             pathManager,
+		parent:                  parent,
+		ctx:                     ctx,
+		ctxCancel:               ctxCancel,
+		ln:                      ln,
+		remuxers:                make(map[string]*hlsRemuxer),
+		pathSourceReady:         make(chan *path),
+		request:                 make(chan hlsRemuxerRequest),
+		variantRequest:          make(chan hlsRemuxerVariantReq),
+		remuxerClose:            make(chan *hlsRemuxer),
+		apiMetadata:             make(chan apiHLSRemuxersMetadataReq),
+	}
+
+	if hlsRequestRateLimit != 0 {
+		s.rateLimiter = newHLSRateLimiter(hlsRequestRateLimit)
+	}
+
+	if hlsTLS {
+		s.certLoader, err = newCertLoader(hlsServerCert, hlsServerKey, s)
+		if err != nil {
+			ln.Close()
+			ctxCancel()
+			return nil, err
+		}
+
+		s.ln = tls.NewListener(ln, &tls.Config{GetCertificate: s.certLoader.getCertificate})
 	}
 
 	s.Log(logger.Info, "listener opened on "+address)
@@ -83,6 +198,36 @@ func newHLSServer(
 	return s, nil
 }
 
+// hlsListen opens a TCP listener or, if the address is in the format
+// unix:/path/to/socket, a Unix socket with the given permissions.
+func hlsListen(address string, socketMode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(address, "unix:") {
+		return net.Listen("tcp", address)
+	}
+
+	pa := strings.TrimPrefix(address, "unix:")
+
+	// remove the socket of a previous instance that was not closed properly
+	if fi, err := os.Lstat(pa); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(pa)
+	}
+
+	ln, err := net.Listen("unix", pa)
+	if err != nil {
+		return nil, err
+	}
+
+	// allow local reverse proxies, that usually run with another user
+	// belonging to the same group, to connect
+	err = os.Chmod(pa, socketMode)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
 // Log is the main logging function.
 func (s *hlsServer) Log(level logger.Level, format string, args ...interface{}) {
 	s.parent.Log(level, "[HLS] "+format, append([]interface{}{}, args...)...)
@@ -97,14 +242,27 @@ func (s *hlsServer) close() {
 func (s *hlsServer) run() {
 	defer s.wg.Done()
 
-	hs := &http.Server{Handler: s}
+	hs := &http.Server{
+		Handler: s,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, hlsServerConnKey{}, c)
+		},
+		ConnState: func(c net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				atomic.AddInt64(&s.connCount, 1)
+			case http.StateHijacked, http.StateClosed:
+				atomic.AddInt64(&s.connCount, -1)
+			}
+		},
+	}
 	go hs.Serve(s.ln)
 
 outer:
 	for {
 		select {
 		case pa := <-s.pathSourceReady:
-			if s.hlsAlwaysRemux {
+			if !pa.Conf().HLSDisable && (s.hlsAlwaysRemux || pa.Conf().HLSDirectory != "") {
 				s.findOrCreateRemuxer(pa.Name())
 			}
 
@@ -112,6 +270,18 @@ outer:
 			r := s.findOrCreateRemuxer(req.Dir)
 			r.OnRequest(req)
 
+		case req := <-s.variantRequest:
+			r := s.findOrCreateRemuxer(req.PathName)
+			r.OnVariantRequest(req)
+
+		case req := <-s.apiMetadata:
+			r, ok := s.remuxers[req.Name]
+			if !ok {
+				req.Res <- apiHLSRemuxersMetadataRes{Err: fmt.Errorf("not found")}
+				continue
+			}
+			r.OnAPIMetadata(req)
+
 		case c := <-s.remuxerClose:
 			if c2, ok := s.remuxers[c.PathName()]; !ok || c2 != c {
 				continue
@@ -127,17 +297,55 @@ outer:
 
 	hs.Shutdown(context.Background())
 
+	if s.certLoader != nil {
+		s.certLoader.close()
+	}
+
 	s.pathManager.OnHLSServerSet(nil)
 }
 
 // ServeHTTP implements http.Handler.
 func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.Log(logger.Info, "[conn %v] %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+	ip := httpClientIP(r, s.hlsTrustedProxies)
+
+	// peers of Unix sockets don't have an address
+	remoteAddr := r.RemoteAddr
+	if remoteAddr == "" || remoteAddr == "@" {
+		remoteAddr = "unix"
+	}
+
+	// when the request is forwarded by a trusted proxy, log the real client IP too
+	connName := remoteAddr
+	if tmp, _, _ := net.SplitHostPort(r.RemoteAddr); ip != nil && tmp != ip.String() {
+		connName = ip.String() + " via " + remoteAddr
+	}
+
+	s.Log(logger.Info, "[conn %v] %s %s", connName, r.Method, r.URL.Path)
+
+	if s.hlsMaxConnections != 0 && atomic.LoadInt64(&s.connCount) > int64(s.hlsMaxConnections) {
+		s.Log(logger.Info, "[conn %v] ERR: too many connections", connName)
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 
-	// remove leading prefix
+	if s.rateLimiter != nil && !s.rateLimiter.allow(ip) {
+		s.Log(logger.Info, "[conn %v] ERR: too many requests", connName)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	// remove leading prefix, that includes the base path when the
+	// reverse proxy doesn't strip it
 	pa := r.URL.Path[1:]
+	if s.hlsBasePath != "" && strings.HasPrefix(r.URL.Path, s.hlsBasePath) {
+		pa = strings.TrimPrefix(r.URL.Path, s.hlsBasePath)
+	}
 
-	w.Header().Add("Access-Control-Allow-Origin", s.hlsAllowOrigin)
+	if origin := s.allowOrigin(w, r); origin != "" {
+		w.Header().Add("Access-Control-Allow-Origin", origin)
+	}
 	w.Header().Add("Access-Control-Allow-Credentials", "true")
 
 	switch r.Method {
@@ -154,34 +362,106 @@ func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.oidc != nil && r.URL.Path == oidcCallbackPath {
+		err := s.oidc.handleCallback(w, r)
+		if err != nil {
+			s.Log(logger.Info, "[conn %v] ERR: OIDC login failed: %s", connName, err)
+		}
+		return
+	}
+
 	switch pa {
 	case "", "favicon.ico":
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	direct := false
+
 	dir, fname := func() (string, string) {
-		if strings.HasSuffix(pa, ".ts") || strings.HasSuffix(pa, ".m3u8") {
+		if strings.HasSuffix(pa, ".m3u8") {
+			base := gopath.Base(pa)
+
+			// playlist requested directly, i.e. /mystream.m3u8
+			if base != s.hlsPlaylistName && base != hlsPlaylistAlias && base != hlsMasterPlaylistName &&
+				base != hlsAudioPlaylistName && !isHLSAltAudioPlaylistName(base) {
+				direct = true
+				return strings.TrimSuffix(pa, ".m3u8"), s.hlsPlaylistName
+			}
+
+			if base == hlsPlaylistAlias {
+				return gopath.Dir(pa), s.hlsPlaylistName
+			}
+
+			return gopath.Dir(pa), base
+		}
+
+		if strings.HasSuffix(pa, ".ts") || strings.HasSuffix(pa, ".mp4") || strings.HasSuffix(pa, ".key") {
 			return gopath.Dir(pa), gopath.Base(pa)
 		}
 		return pa, ""
 	}()
 
 	if fname == "" && !strings.HasSuffix(dir, "/") {
-		w.Header().Add("Location", "/"+dir+"/")
+		loc := s.basePath() + dir + "/"
+		if r.URL.RawQuery != "" {
+			loc += "?" + r.URL.RawQuery
+		}
+		w.Header().Add("Location", loc)
 		w.WriteHeader(http.StatusMovedPermanently)
 		return
 	}
 
 	dir = strings.TrimSuffix(dir, "/")
 
+	// when sessions are enabled, segments can be downloaded only with the
+	// token provided by the playlist.
+	var session *hlsViewer
+	sessionID := ""
+	if s.hlsSessions {
+		sessionID = r.URL.Query().Get("session")
+		if sessionID != "" {
+			session = s.stats.HLSViewers.onSessionRequest(sessionID)
+			if session == nil {
+				s.Log(logger.Info, "[conn %v] ERR: invalid or expired session", connName)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		} else if fname != "" && !strings.HasSuffix(fname, ".m3u8") {
+			s.Log(logger.Info, "[conn %v] ERR: session is missing", connName)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	// URLs in playlists are relative to the playlist, unless a base path is set
+	segmentPrefix := ""
+	if s.hlsBasePath != "" {
+		segmentPrefix = s.hlsBasePath + dir + "/"
+	} else if direct {
+		segmentPrefix = gopath.Base(dir) + "/"
+	}
+
+	segmentQuery := ""
+	if sessionID != "" {
+		segmentQuery = "session=" + sessionID
+	}
+
+	user := ""
 	cres := make(chan io.Reader)
 	hreq := hlsRemuxerRequest{
-		Dir:  dir,
-		File: fname,
-		Req:  r,
-		W:    w,
-		Res:  cres,
+		Dir:           dir,
+		File:          fname,
+		Direct:        direct,
+		BasePath:      s.hlsBasePath,
+		SegmentPrefix: segmentPrefix,
+		SegmentQuery:  segmentQuery,
+		Session:       sessionID,
+		IP:            ip,
+		Req:           r,
+		W:             w,
+		User:          &user,
+		Res:           cres,
 	}
 
 	select {
@@ -189,6 +469,31 @@ func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		res := <-cres
 
 		if res != nil {
+			// the remuxer can set its own policy (i.e. for keys)
+			if w.Header().Get("Cache-Control") == "" {
+				s.setCacheControl(w, fname)
+			}
+
+			ipStats := s.stats.IPs.acquire(ip)
+			defer s.stats.IPs.release(ipStats)
+
+			// requests that precede the creation of a session are not counted
+			viewer := session
+			if !s.hlsSessions {
+				viewer = s.stats.HLSViewers.onRequest(dir, ip)
+			}
+
+			// the user has been filled by the remuxer after authenticating the request
+			bandwidth := s.stats.Bandwidth.acquire(dir, user)
+			defer s.stats.Bandwidth.release(bandwidth)
+
+			// every chunk must be delivered within hlsWriteTimeout, otherwise
+			// the client is too slow and the connection is closed.
+			conn, _ := r.Context().Value(hlsServerConnKey{}).(net.Conn)
+			if conn != nil {
+				defer conn.SetWriteDeadline(time.Time{})
+			}
+
 			buf := make([]byte, 4096)
 			for {
 				n, err := res.Read(buf)
@@ -196,8 +501,21 @@ func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 
-				_, err = w.Write(buf[:n])
+				if conn != nil {
+					conn.SetWriteDeadline(time.Now().Add(s.hlsWriteTimeout))
+				}
+
+				n, err = w.Write(buf[:n])
+				atomic.AddInt64(ipStats.BytesSent, int64(n))
+				atomic.AddInt64(bandwidth.BytesSent, int64(n))
+				if viewer != nil {
+					atomic.AddInt64(viewer.BytesSent, int64(n))
+				}
 				if err != nil {
+					if errors.Is(err, os.ErrDeadlineExceeded) {
+						s.Log(logger.Info, "[conn %v] ERR: write timed out, closing slow client", connName)
+						conn.Close()
+					}
 					return
 				}
 
@@ -209,16 +527,76 @@ func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// allowOrigin returns the value of the Access-Control-Allow-Origin header.
+// When a list of origins is set, the origin of the request is echoed back
+// if it matches one of them, otherwise the header is omitted.
+func (s *hlsServer) allowOrigin(w http.ResponseWriter, r *http.Request) string {
+	if s.hlsAllowOrigins == nil {
+		return s.hlsAllowOrigin
+	}
+
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return ""
+	}
+
+	for _, re := range s.hlsAllowOrigins {
+		if re.MatchString(origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// basePath returns the path prefix under which the server is exposed.
+func (s *hlsServer) basePath() string {
+	if s.hlsBasePath != "" {
+		return s.hlsBasePath
+	}
+	return "/"
+}
+
+func (s *hlsServer) setCacheControl(w http.ResponseWriter, fname string) {
+	switch {
+	case strings.HasSuffix(fname, ".m3u8"):
+		w.Header().Set("Cache-Control", s.hlsPlaylistCacheControl)
+
+	case strings.HasSuffix(fname, ".ts") || strings.HasSuffix(fname, ".mp4"):
+		if s.hlsSegmentCacheControl != "" {
+			w.Header().Set("Cache-Control", s.hlsSegmentCacheControl)
+		}
+	}
+}
+
 func (s *hlsServer) findOrCreateRemuxer(pathName string) *hlsRemuxer {
 	r, ok := s.remuxers[pathName]
 	if !ok {
 		r = newHLSRemuxer(
 			s.ctx,
 			s.hlsAlwaysRemux,
+			s.hlsRemuxerCloseAfter,
+			s.hlsSessions,
+			s.hlsSessionTimeout,
+			s.hlsEndList,
 			s.hlsSegmentCount,
 			s.hlsSegmentDuration,
+			s.hlsLowLatency,
+			s.hlsPartDuration,
+			s.hlsSegmentFormat,
+			s.hlsPlaylistName,
+			s.hlsSegmentName,
+			s.hlsEncryption,
+			s.hlsEncryptionKey,
+			s.hlsEncryptionKeyURI,
+			s.hlsPlayerDisable,
+			s.hlsPlayerScriptURL,
 			s.readBufferCount,
 			&s.wg,
+			s.oidc,
+			s.stats,
 			pathName,
 			s.pathManager,
 			s)
@@ -235,6 +613,32 @@ func (s *hlsServer) OnRemuxerClose(c *hlsRemuxer) {
 	}
 }
 
+// OnRemuxerVariantRequest is called by hlsRemuxer.
+func (s *hlsServer) OnRemuxerVariantRequest(pathName string) *hls.Variant {
+	req := hlsRemuxerVariantReq{
+		PathName: pathName,
+		Res:      make(chan *hls.Variant),
+	}
+
+	select {
+	case s.variantRequest <- req:
+		return <-req.Res
+	case <-s.ctx.Done():
+		return nil
+	}
+}
+
+// OnAPIHLSRemuxersMetadata is called by api.
+func (s *hlsServer) OnAPIHLSRemuxersMetadata(req apiHLSRemuxersMetadataReq) apiHLSRemuxersMetadataRes {
+	req.Res = make(chan apiHLSRemuxersMetadataRes)
+	select {
+	case s.apiMetadata <- req:
+		return <-req.Res
+	case <-s.ctx.Done():
+		return apiHLSRemuxersMetadataRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
 // OnPathSourceReady is called by core.
 func (s *hlsServer) OnPathSourceReady(pa *path) {
 	select {
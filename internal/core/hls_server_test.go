@@ -1,10 +1,28 @@
 package core
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
 	"time"
 
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/h265"
 )
 
 func TestHLSServerRead(t *testing.T) {
@@ -69,3 +87,1397 @@ func TestHLSServerReadAuth(t *testing.T) {
 	defer cnt2.close()
 	require.Equal(t, 0, cnt2.wait())
 }
+
+func TestHLSServerSourceRestart(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAlwaysRemux: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08})
+	require.NoError(t, err)
+
+	publish := func() {
+		source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+			gortsplib.Tracks{track})
+		require.NoError(t, err)
+		defer source.Close()
+
+		enc := rtph264.NewEncoder(96, nil, nil, nil)
+		for i := 0; i < 3; i++ {
+			pkts, err := enc.Encode([][]byte{{0x05, 0x01}}, time.Duration(i)*time.Second)
+			require.NoError(t, err)
+
+			for _, pkt := range pkts {
+				err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+				require.NoError(t, err)
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	getPlaylist := func() string {
+		res, err := http.Get("http://localhost:8888/teststream/stream.m3u8")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		byts, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(byts)
+	}
+
+	publish()
+	require.NotContains(t, getPlaylist(), "#EXT-X-DISCONTINUITY\n")
+
+	// the remuxer survives the restart of the source
+	publish()
+	require.Contains(t, getPlaylist(), "#EXT-X-DISCONTINUITY\n")
+}
+
+func TestHLSServerFileNames(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsPlaylistName: index.m3u8\n" +
+		"hlsSegmentName: seg$SEQ.ts\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	res, err := http.Get("http://localhost:8888/test/index.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(byts), "\nseg0.ts\n")
+
+	res2, err := http.Get("http://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	defer res2.Body.Close()
+	require.Equal(t, http.StatusNotFound, res2.StatusCode)
+}
+
+func TestHLSServerDirectPlaylist(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	for _, ca := range []struct {
+		url    string
+		prefix string
+	}{
+		{"http://localhost:8888/test.m3u8", "test/"},
+		{"http://localhost:8888/test/index.m3u8", ""},
+		{"http://localhost:8888/test/stream.m3u8", ""},
+	} {
+		t.Run(ca.url, func(t *testing.T) {
+			cl := &http.Client{
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
+
+			res, err := cl.Get(ca.url)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			byts, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Regexp(t, "\n"+ca.prefix+"[0-9]+\\.ts\n", string(byts))
+		})
+	}
+}
+
+func TestHLSServerInProgressSegment(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsSegmentDuration: 3s\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile("\n([0-9]+\\.ts)\n$").FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	res2, err := http.Get("http://localhost:8888/test/" + ma[1])
+	require.NoError(t, err)
+	defer res2.Body.Close()
+	require.Equal(t, http.StatusOK, res2.StatusCode)
+	require.Equal(t, []string{"chunked"}, res2.TransferEncoding)
+
+	// the first bytes are received before the segment is complete
+	buf := make([]byte, 188)
+	_, err = res2.Body.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x47), buf[0])
+}
+
+func TestHLSServerVariants(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"paths:\n" +
+		"  cam1:\n" +
+		"    hlsVariants: [cam1_high, cam1_low, cam1_missing]\n" +
+		"  cam1_high:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 128x128\n" +
+		"  cam1_low:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	for _, ca := range []struct {
+		url  string
+		high string
+		low  string
+	}{
+		{"http://localhost:8888/cam1/stream.m3u8", "../cam1_high/stream.m3u8", "../cam1_low/stream.m3u8"},
+		{"http://localhost:8888/cam1.m3u8", "cam1_high.m3u8", "cam1_low.m3u8"},
+	} {
+		t.Run(ca.url, func(t *testing.T) {
+			res, err := http.Get(ca.url)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			byts, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			require.Regexp(t, "^#EXTM3U\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,CODECS=\"avc1\\.[0-9a-f]{6}\"\n"+
+				regexp.QuoteMeta(ca.high)+"\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,CODECS=\"avc1\\.[0-9a-f]{6}\"\n"+
+				regexp.QuoteMeta(ca.low)+"\n$", string(byts))
+		})
+	}
+
+	res, err := http.Get("http://localhost:8888/cam1_low/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestHLSServerLatencyProbe(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"metrics: yes\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    latencyProbe: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	time.Sleep(500 * time.Millisecond)
+
+	res, err = http.Get("http://localhost:9998/metrics")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Regexp(t, "\npaths_latency_microseconds\\{name=\"test\",protocol=\"hls\",quantile=\"0.99\"\\} [0-9]+ ", string(byts))
+}
+
+func TestHLSServerPathSegmentOverrides(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsSegmentDuration: 1s\n" +
+		"paths:\n" +
+		"  short:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"  long:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    hlsSegmentDuration: 7s\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	for _, ca := range []struct {
+		name     string
+		duration string
+	}{
+		{"short", "1"},
+		{"long", "7"},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			res, err := http.Get("http://localhost:8888/" + ca.name + "/stream.m3u8")
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			byts, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(byts), "\n#EXT-X-TARGETDURATION:"+ca.duration+"\n")
+		})
+	}
+}
+
+func TestHLSServerPathDisable(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAlwaysRemux: yes\n" +
+		"paths:\n" +
+		"  enabled:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"  disabled:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    hlsDisable: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	for _, ca := range []struct {
+		name   string
+		status int
+	}{
+		{"enabled", http.StatusOK},
+		{"disabled", http.StatusNotFound},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			res, err := http.Get("http://localhost:8888/" + ca.name + "/stream.m3u8")
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, ca.status, res.StatusCode)
+		})
+	}
+}
+
+func TestHLSServerAudioTranscode(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"paths:\n" +
+		"  cam1:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    hlsAudioTranscode: yes\n" +
+		"    hlsAudioTranscodeCommand: sleep 10\n" +
+		"  cam1_aac:\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	// the transcoded stream is not available yet
+	res, err := http.Get("http://localhost:8888/cam1/stream.m3u8")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	require.Equal(t, "1", res.Header.Get("Retry-After"))
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{0x12, 0x10})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/cam1_aac",
+		gortsplib.Tracks{videoTrack, audioTrack})
+	require.NoError(t, err)
+	defer source.Close()
+
+	videoEnc := rtph264.NewEncoder(96, nil, nil, nil)
+	pkts, err := videoEnc.Encode([][]byte{{0x05, 0x01}}, 0)
+	require.NoError(t, err)
+	for _, pkt := range pkts {
+		err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+		require.NoError(t, err)
+	}
+
+	res, err = http.Get("http://localhost:8888/cam1/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestHLSServerLowLatency(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsLowLatency: yes\n" +
+		"hlsPartDuration: 200ms\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	// blocking playlist reload
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8?_HLS_msn=0&_HLS_part=1")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Regexp(t, "\n#EXT-X-PART:DURATION=[0-9.]+,URI=\"[0-9]+_part1\\.ts\"\n", string(byts))
+
+	ma := regexp.MustCompile("\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"([0-9]+_part[0-9]+\\.ts)\"\n$").
+		FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	// the hinted part is sent when it's complete
+	res2, err := http.Get("http://localhost:8888/test/" + ma[1])
+	require.NoError(t, err)
+	defer res2.Body.Close()
+	require.Equal(t, http.StatusOK, res2.StatusCode)
+
+	byts, err = ioutil.ReadAll(res2.Body)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x47), byts[0])
+
+	res3, err := http.Get("http://localhost:8888/test/stream.m3u8?_HLS_msn=100")
+	require.NoError(t, err)
+	defer res3.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res3.StatusCode)
+}
+
+func TestHLSServerFMP4(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsSegmentFormat: fmp4\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile("\n#EXT-X-MAP:URI=\"(init_[0-9]+\\.mp4)\"\n").FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+	require.Regexp(t, "\n[0-9]+\\.mp4\n$", string(byts))
+
+	res2, err := http.Get("http://localhost:8888/test/" + ma[1])
+	require.NoError(t, err)
+	defer res2.Body.Close()
+	require.Equal(t, http.StatusOK, res2.StatusCode)
+	require.Equal(t, "video/mp4", res2.Header.Get("Content-Type"))
+
+	byts, err = ioutil.ReadAll(res2.Body)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ftyp"), byts[4:8])
+}
+
+func TestHLSServerEncryption(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsEncryption: aes-128\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(byts), "\n#EXT-X-KEY:METHOD=AES-128,URI=\"encryption.key\"\n")
+
+	res2, err := http.Get("http://localhost:8888/test/encryption.key")
+	require.NoError(t, err)
+	defer res2.Body.Close()
+	require.Equal(t, http.StatusOK, res2.StatusCode)
+
+	byts, err = ioutil.ReadAll(res2.Body)
+	require.NoError(t, err)
+	require.Equal(t, 16, len(byts))
+}
+
+func TestHLSServerRenditions(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"paths:\n" +
+		"  cam1:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 128x128\n" +
+		"    hlsRenditions: [64:200k]\n" +
+		"    hlsRenditionCommand: sleep 10\n" +
+		"  cam1_64p:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	res, err := http.Get("http://localhost:8888/cam1/master.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	require.Regexp(t, "^#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,CODECS=\"avc1\\.[0-9a-f]{6}\"\n"+
+		regexp.QuoteMeta("../cam1/stream.m3u8")+"\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,CODECS=\"avc1\\.[0-9a-f]{6}\"\n"+
+		regexp.QuoteMeta("../cam1_64p/stream.m3u8")+"\n$", string(byts))
+}
+
+func TestHLSServerDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-hls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"paths:\n" +
+		"  teststream:\n" +
+		"    hlsDirectory: " + dir + "\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	// segments are written without requests
+	enc := rtph264.NewEncoder(96, nil, nil, nil)
+	for i := 0; i < 5; i++ {
+		pkts, err := enc.Encode([][]byte{{0x05, 0x01}}, time.Duration(i)*time.Second)
+		require.NoError(t, err)
+
+		for _, pkt := range pkts {
+			err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+			require.NoError(t, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	byts, err := ioutil.ReadFile(filepath.Join(dir, "teststream", "stream.m3u8"))
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile("\n([0-9]+\\.ts)\n$").FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	_, err = os.Stat(filepath.Join(dir, "teststream", ma[1]))
+	require.NoError(t, err)
+}
+
+func TestHLSServerPlayer(t *testing.T) {
+	for _, ca := range []string{"enabled", "disabled"} {
+		t.Run(ca, func(t *testing.T) {
+			conf := "rtmpDisable: yes\n" +
+				"hlsPlayerScriptURL: http://myserver/hls.min.js\n"
+			if ca == "disabled" {
+				conf += "hlsPlayerDisable: yes\n"
+			}
+			conf += "paths:\n" +
+				"  test:\n" +
+				"    source: testpattern\n" +
+				"    testPatternResolution: 64x64\n"
+
+			p, ok := newInstance(conf)
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			time.Sleep(500 * time.Millisecond)
+
+			res, err := http.Get("http://localhost:8888/test/")
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			if ca == "disabled" {
+				require.Equal(t, http.StatusNotFound, res.StatusCode)
+				return
+			}
+
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			byts, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(byts), `<script src="http://myserver/hls.min.js"></script>`)
+			require.Contains(t, string(byts), `hls.loadSource('stream.m3u8' + window.location.search)`)
+		})
+	}
+}
+
+func TestHLSServerTLS(t *testing.T) {
+	serverCertFpath, err := writeTempFile(serverCert)
+	require.NoError(t, err)
+	defer os.Remove(serverCertFpath)
+
+	serverKeyFpath, err := writeTempFile(serverKey)
+	require.NoError(t, err)
+	defer os.Remove(serverKeyFpath)
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsTLS: yes\n" +
+		"hlsServerCert: " + serverCertFpath + "\n" +
+		"hlsServerKey: " + serverKeyFpath + "\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	hc := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	res, err := hc.Get("https://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Regexp(t, "\n[0-9]+\\.ts\n$", string(byts))
+}
+
+func TestHLSServerReadAuthQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-bandwidth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"api: yes\n" +
+		"bandwidthAccounting: yes\n" +
+		"bandwidthAccountingFile: " + filepath.Join(dir, "bandwidth.json") + "\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    readUser: testuser\n" +
+		"    readPass: testpass\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8?user=testuser&pass=wrong")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res, err = http.Get("http://localhost:8888/test/stream.m3u8?user=testuser&pass=testpass")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	// credentials are appended to the URLs of segments
+	ma := regexp.MustCompile("\n([0-9]+\\.ts\\?pass=testpass&user=testuser)\n$").FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	res2, err := http.Get("http://localhost:8888/test/" + ma[1])
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res2.StatusCode)
+	_, err = ioutil.ReadAll(res2.Body)
+	require.NoError(t, err)
+	res2.Body.Close()
+
+	// bytes are billed to the user that has been authenticated through
+	// the query, while bytes of the static source are not bound to users
+	var out apiBandwidthListData
+	err = httpRequest(http.MethodGet, "http://localhost:9997/v1/bandwidth/list?path=test", nil, &out)
+	require.NoError(t, err)
+
+	items := make(map[string]apiBandwidthListItem)
+	for _, item := range out.Items {
+		items[item.User] = item
+	}
+	require.NotZero(t, items["testuser"].BytesSent)
+	require.NotZero(t, items[""].BytesReceived)
+}
+
+func TestHLSServerSegmentNamePath(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsSegmentName: $PATH_$TIME_$SEQ.ts\n" +
+		"paths:\n" +
+		"  cams/test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	res, err := http.Get("http://localhost:8888/cams/test/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile("\n(cams_test_[0-9]+_0\\.ts)\n").FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	res2, err := http.Get("http://localhost:8888/cams/test/" + ma[1])
+	require.NoError(t, err)
+	defer res2.Body.Close()
+	require.Equal(t, http.StatusOK, res2.StatusCode)
+}
+
+func TestHLSServerAudioOnlyVariant(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAlwaysRemux: yes\n" +
+		"paths:\n" +
+		"  teststream:\n" +
+		"    hlsAudioOnlyVariant: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{0x12, 0x10})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{videoTrack, audioTrack})
+	require.NoError(t, err)
+	defer source.Close()
+
+	videoEnc := rtph264.NewEncoder(96, nil, nil, nil)
+	audioEnc := rtpaac.NewEncoder(97, 44100, nil, nil, nil)
+
+	pkts, err := videoEnc.Encode([][]byte{{0x05, 0x01}}, 0)
+	require.NoError(t, err)
+	for _, pkt := range pkts {
+		err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+		require.NoError(t, err)
+	}
+
+	// write enough AUs to complete the first segment of the audio-only variant
+	for i := 0; i < 150; i++ {
+		pkts, err := audioEnc.Encode([][]byte{{0x01, 0x02, 0x03, 0x04}},
+			time.Duration(i)*1024*time.Second/44100)
+		require.NoError(t, err)
+		for _, pkt := range pkts {
+			err := source.WriteFrame(1, gortsplib.StreamTypeRTP, pkt)
+			require.NoError(t, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	get := func(u string) string {
+		res, err := http.Get(u)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		byts, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(byts)
+	}
+
+	master := get("http://localhost:8888/teststream/master.m3u8")
+	require.Regexp(t, "\n#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,CODECS=\"avc1\\.[0-9a-f]+,mp4a\\.40\\.2\"\n"+
+		"\\.\\./teststream/stream\\.m3u8\n", master)
+	require.Regexp(t, "\n#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,CODECS=\"mp4a\\.40\\.2\"\n"+
+		"\\.\\./teststream/audio\\.m3u8\n$", master)
+
+	audio := get("http://localhost:8888/teststream/audio.m3u8")
+	ma := regexp.MustCompile("\n#EXTINF:[0-9.]+,\n(audio_[0-9]+\\.ts)\n#EXT-X-PROGRAM-DATE-TIME:").FindStringSubmatch(audio)
+	require.NotNil(t, ma)
+
+	get("http://localhost:8888/teststream/" + ma[1])
+}
+
+func TestHLSServerCacheControl(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsSegmentCacheControl: public, max-age=3600\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "no-cache", res.Header.Get("Cache-Control"))
+
+	byts, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile("\n([0-9]+\\.ts)\n").FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	res2, err := http.Get("http://localhost:8888/test/" + ma[1])
+	require.NoError(t, err)
+	defer res2.Body.Close()
+	require.Equal(t, http.StatusOK, res2.StatusCode)
+	require.Equal(t, "public, max-age=3600", res2.Header.Get("Cache-Control"))
+
+	res3, err := http.Get("http://localhost:8888/test/missing.ts")
+	require.NoError(t, err)
+	defer res3.Body.Close()
+	require.Equal(t, http.StatusNotFound, res3.StatusCode)
+	require.Equal(t, "", res3.Header.Get("Cache-Control"))
+}
+
+func TestHLSServerRemuxerCloseAfter(t *testing.T) {
+	p, ok := newInstance("api: yes\n" +
+		"rtmpDisable: yes\n" +
+		"hlsRemuxerCloseAfter: 1s\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	countReaders := func() int {
+		var out struct {
+			Items map[string]struct {
+				Readers []interface{} `json:"readers"`
+			} `json:"items"`
+		}
+		err := httpRequest(http.MethodGet, "http://localhost:9997/v1/paths/list", nil, &out)
+		require.NoError(t, err)
+		return len(out.Items["test"].Readers)
+	}
+
+	require.Equal(t, 1, countReaders())
+
+	time.Sleep(3 * time.Second)
+
+	require.Equal(t, 0, countReaders())
+}
+
+func TestHLSServerAlternateAudio(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAlwaysRemux: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08})
+	require.NoError(t, err)
+
+	audioTrack1, err := gortsplib.NewTrackAAC(97, []byte{0x12, 0x10})
+	require.NoError(t, err)
+
+	audioTrack2, err := gortsplib.NewTrackAAC(98, []byte{0x12, 0x10})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{videoTrack, audioTrack1, audioTrack2})
+	require.NoError(t, err)
+	defer source.Close()
+
+	videoEnc := rtph264.NewEncoder(96, nil, nil, nil)
+	audioEnc := rtpaac.NewEncoder(98, 44100, nil, nil, nil)
+
+	pkts, err := videoEnc.Encode([][]byte{{0x05, 0x01}}, 0)
+	require.NoError(t, err)
+	for _, pkt := range pkts {
+		err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+		require.NoError(t, err)
+	}
+
+	// write enough AUs to complete the first segment of the alternate rendition
+	for i := 0; i < 150; i++ {
+		pkts, err := audioEnc.Encode([][]byte{{0x01, 0x02, 0x03, 0x04}},
+			time.Duration(i)*1024*time.Second/44100)
+		require.NoError(t, err)
+		for _, pkt := range pkts {
+			err := source.WriteFrame(2, gortsplib.StreamTypeRTP, pkt)
+			require.NoError(t, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	get := func(u string) string {
+		res, err := http.Get(u)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		byts, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(byts)
+	}
+
+	master := get("http://localhost:8888/teststream/master.m3u8")
+	require.Regexp(t, "\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio1\",DEFAULT=YES,AUTOSELECT=YES\n"+
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio2\",DEFAULT=NO,AUTOSELECT=YES,"+
+		"URI=\"\\.\\./teststream/audio2\\.m3u8\"\n", master)
+	require.Regexp(t, "\n#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,CODECS=\"avc1\\.[0-9a-f]+,mp4a\\.40\\.2\",AUDIO=\"audio\"\n"+
+		"\\.\\./teststream/stream\\.m3u8\n$", master)
+
+	audio := get("http://localhost:8888/teststream/audio2.m3u8")
+	ma := regexp.MustCompile("\n#EXTINF:[0-9.]+,\n(audio2_[0-9]+\\.ts)\n#EXT-X-PROGRAM-DATE-TIME:").FindStringSubmatch(audio)
+	require.NotNil(t, ma)
+
+	get("http://localhost:8888/teststream/" + ma[1])
+}
+
+func TestHLSServerEndList(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAlwaysRemux: yes\n" +
+		"hlsEndList: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{videoTrack})
+	require.NoError(t, err)
+
+	enc := rtph264.NewEncoder(96, nil, nil, nil)
+	pkts, err := enc.Encode([][]byte{{0x05, 0x01}}, 0)
+	require.NoError(t, err)
+	for _, pkt := range pkts {
+		err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+		require.NoError(t, err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	get := func() string {
+		res, err := http.Get("http://localhost:8888/teststream/stream.m3u8")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		byts, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(byts)
+	}
+
+	require.NotContains(t, get(), "#EXT-X-ENDLIST")
+
+	source.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	require.Regexp(t, "\n#EXTINF:[0-9.]+,\n[0-9]+\\.ts\n#EXT-X-ENDLIST\n$", get())
+}
+
+func TestHLSServerProgramDateTime(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsSegmentDuration: 1s\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{videoTrack})
+	require.NoError(t, err)
+	defer source.Close()
+
+	initialTs := uint32(0)
+	enc := rtph264.NewEncoder(96, nil, nil, &initialTs)
+
+	writeIDR := func(pts time.Duration) {
+		pkts, err := enc.Encode([][]byte{{0x05, 0x01}}, pts)
+		require.NoError(t, err)
+		for _, pkt := range pkts {
+			err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+			require.NoError(t, err)
+		}
+	}
+
+	// the first request starts the remuxer
+	res, err := http.Get("http://localhost:8888/teststream/stream.m3u8")
+	require.NoError(t, err)
+	res.Body.Close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	writeIDR(0)
+
+	time.Sleep(200 * time.Millisecond)
+
+	// RTP timestamp 0 corresponds to 2020-01-01T00:00:00Z
+	sr := &rtcp.SenderReport{
+		NTPTime: uint64(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix()+2208988800) << 32,
+		RTPTime: 0,
+	}
+	byts, err := sr.Marshal()
+	require.NoError(t, err)
+	err = source.WriteFrame(0, gortsplib.StreamTypeRTCP, byts)
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	writeIDR(1 * time.Second)
+	writeIDR(2 * time.Second)
+
+	time.Sleep(500 * time.Millisecond)
+
+	res, err = http.Get("http://localhost:8888/teststream/stream.m3u8")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	byts, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Regexp(t, "\n#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:02.000Z\n#EXTINF:0,\n", string(byts))
+}
+
+func TestHLSServerBasePath(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsBasePath: /streams\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	cl := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// the base path is accepted both when the proxy strips it and when it doesn't
+	for _, u := range []string{
+		"http://localhost:8888/test",
+		"http://localhost:8888/streams/test",
+	} {
+		res, err := cl.Get(u)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusMovedPermanently, res.StatusCode)
+		require.Equal(t, "/streams/test/", res.Header.Get("Location"))
+	}
+
+	for _, u := range []string{
+		"http://localhost:8888/test.m3u8",
+		"http://localhost:8888/streams/test/stream.m3u8",
+	} {
+		res, err := cl.Get(u)
+		require.NoError(t, err)
+		byts, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		ma := regexp.MustCompile("\n(/streams/test/[0-9]+\\.ts)\n").FindStringSubmatch(string(byts))
+		require.NotNil(t, ma)
+
+		res, err = cl.Get("http://localhost:8888" + ma[1])
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestHLSServerTrustedProxies(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsTrustedProxies: [127.0.0.1]\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    readIPs: [10.0.0.0/24]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	for _, ca := range []struct {
+		name   string
+		header string
+		value  string
+		code   int
+	}{
+		{"no header", "", "", http.StatusUnauthorized},
+		{"forwarded allowed", "X-Forwarded-For", "10.0.0.5", http.StatusOK},
+		{"forwarded chain", "X-Forwarded-For", "10.0.0.5, 127.0.0.1", http.StatusOK},
+		{"forwarded denied", "X-Forwarded-For", "10.0.1.5", http.StatusUnauthorized},
+		{"forwarded spoofed", "X-Forwarded-For", "10.0.0.5, 10.0.1.5", http.StatusUnauthorized},
+		{"real ip", "X-Real-IP", "10.0.0.5", http.StatusOK},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://localhost:8888/test/index.m3u8", nil)
+			require.NoError(t, err)
+			if ca.header != "" {
+				req.Header.Set(ca.header, ca.value)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, ca.code, res.StatusCode)
+		})
+	}
+}
+
+func TestHLSServerUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-hls-unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "hls.sock")
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAddress: unix:" + sock + "\n" +
+		"hlsTrustedProxies: [127.0.0.1]\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    readIPs: [10.0.0.0/24]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	fi, err := os.Stat(sock)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o660), fi.Mode().Perm())
+
+	time.Sleep(1 * time.Second)
+
+	hc := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+
+	for _, ca := range []struct {
+		name  string
+		value string
+		code  int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"forwarded allowed", "10.0.0.5", http.StatusOK},
+		{"forwarded denied", "10.0.1.5", http.StatusUnauthorized},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://localhost/test/index.m3u8", nil)
+			require.NoError(t, err)
+			if ca.value != "" {
+				req.Header.Set("X-Forwarded-For", ca.value)
+			}
+
+			res, err := hc.Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, ca.code, res.StatusCode)
+		})
+	}
+}
+
+func TestHLSServerUnixSocketUntrusted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-hls-unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "hls.sock")
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAddress: unix:" + sock + "\n" +
+		"hlsSocketMode: \"0600\"\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    readIPs: [10.0.0.0/24]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	fi, err := os.Stat(sock)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	time.Sleep(1 * time.Second)
+
+	hc := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+
+	// headers are ignored since the socket is not a trusted proxy
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/test/index.m3u8", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "10.0.0.5")
+
+	res, err := hc.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestHLSServerWriteTimeout(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"readBufferCount: 4096\n" +
+		"hlsWriteTimeout: 1s\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"    testPatternBitrate: 80000000\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	time.Sleep(1 * time.Second)
+
+	res, err := http.Get("http://localhost:8888/test/stream.m3u8")
+	require.NoError(t, err)
+	byts, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	time.Sleep(2 * time.Second)
+
+	ma := regexp.MustCompile("\n([0-9]+\\.ts)\n").FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	// request a segment, that is bigger than socket buffers, without reading it
+	conn, err := net.Dial("tcp", "localhost:8888")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /test/" + ma[1] + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	time.Sleep(3 * time.Second)
+
+	// the connection has been closed by the server
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.Copy(ioutil.Discard, conn)
+	if err != nil {
+		var ne net.Error
+		require.False(t, errors.As(err, &ne) && ne.Timeout(), "connection has not been closed")
+	}
+}
+
+func TestHLSServerAllowOrigins(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAllowOrigins: ['https://*.example.com', '~^http://localhost:[0-9]+$']\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	for _, ca := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://www.example.com", true},
+		{"https://example.com", false},
+		{"http://localhost:3000", true},
+		{"https://www.example.com.evil.com", false},
+	} {
+		t.Run(ca.origin, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodOptions, "http://localhost:8888/teststream/stream.m3u8", nil)
+			require.NoError(t, err)
+			req.Header.Set("Origin", ca.origin)
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, "Origin", res.Header.Get("Vary"))
+
+			if ca.allowed {
+				require.Equal(t, ca.origin, res.Header.Get("Access-Control-Allow-Origin"))
+			} else {
+				require.Equal(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+}
+
+func TestHLSServerH265(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsAlwaysRemux: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	vps := []byte{
+		0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60,
+		0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x03, 0x00, 0x78, 0x99, 0x98, 0x09,
+	}
+	sps := []byte{
+		0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+		0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+		0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+		0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+		0xe0, 0x80,
+	}
+	pps := []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{h265.NewTrack(96, vps, sps, pps)})
+	require.NoError(t, err)
+	defer source.Close()
+
+	for i := 0; i < 3; i++ {
+		pkt := rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         true,
+				PayloadType:    96,
+				SequenceNumber: uint16(i),
+				Timestamp:      uint32(i * 90000),
+				SSRC:           1234,
+			},
+			Payload: []byte{0x26, 0x01, 0xaa}, // IDR
+		}
+		byts, err := pkt.Marshal()
+		require.NoError(t, err)
+
+		err = source.WriteFrame(0, gortsplib.StreamTypeRTP, byts)
+		require.NoError(t, err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	get := func(u string) []byte {
+		res, err := http.Get(u)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		byts, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return byts
+	}
+
+	pl := get("http://localhost:8888/teststream/stream.m3u8")
+	ma := regexp.MustCompile("\n#EXTINF:[0-9.]+,\n([0-9]+\\.ts)\n").FindStringSubmatch(string(pl))
+	require.NotNil(t, ma)
+
+	// parameter sets are inserted before random access points
+	seg := get("http://localhost:8888/teststream/" + ma[1])
+	require.Equal(t, true, bytes.Contains(seg, append([]byte{0x00, 0x00, 0x00, 0x01}, vps[:8]...)))
+}
+
+func TestHLSServerLimits(t *testing.T) {
+	t.Run("rate", func(t *testing.T) {
+		p, ok := newInstance("rtmpDisable: yes\n" +
+			"hlsRequestRateLimit: 2\n")
+		require.Equal(t, true, ok)
+		defer p.close()
+
+		get := func() int {
+			res, err := http.Get("http://localhost:8888/teststream/stream.m3u8")
+			require.NoError(t, err)
+			defer res.Body.Close()
+			return res.StatusCode
+		}
+
+		require.Equal(t, http.StatusNotFound, get())
+		require.Equal(t, http.StatusNotFound, get())
+		require.Equal(t, http.StatusTooManyRequests, get())
+
+		time.Sleep(1 * time.Second)
+		require.Equal(t, http.StatusNotFound, get())
+	})
+
+	t.Run("connections", func(t *testing.T) {
+		p, ok := newInstance("rtmpDisable: yes\n" +
+			"hlsMaxConnections: 1\n")
+		require.Equal(t, true, ok)
+		defer p.close()
+
+		get := func(conn net.Conn) string {
+			_, err := conn.Write([]byte("GET /teststream/stream.m3u8 HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			require.NoError(t, err)
+
+			buf := make([]byte, 1024)
+			n, err := conn.Read(buf)
+			require.NoError(t, err)
+			return string(buf[:n])
+		}
+
+		conn1, err := net.Dial("tcp", "localhost:8888")
+		require.NoError(t, err)
+		defer conn1.Close()
+		require.Regexp(t, "^HTTP/1.1 404 ", get(conn1))
+
+		conn2, err := net.Dial("tcp", "localhost:8888")
+		require.NoError(t, err)
+		defer conn2.Close()
+		require.Regexp(t, "^HTTP/1.1 503 ", get(conn2))
+
+		// the connection above the limit is closed
+		_, err = conn2.Read(make([]byte, 1024))
+		require.Equal(t, io.EOF, err)
+
+		// the other connection can still be used
+		require.Regexp(t, "^HTTP/1.1 404 ", get(conn1))
+	})
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+
+	"github.com/aler9/rtsp-simple-server/internal/hls"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/proxydialer"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpsenderset"
+)
+
+type hlsSourceParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+}
+
+type hlsSource struct {
+	ur           string
+	readTimeout  time.Duration
+	retryBackoff *sourceRetryBackoff
+	dialContext  proxydialer.DialContextFunc
+	wg           *sync.WaitGroup
+	stats        *stats
+	parent       hlsSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newHLSSource(
+	parentCtx context.Context,
+	ur string,
+	readTimeout time.Duration,
+	retryPause time.Duration,
+	retryMaxPause time.Duration,
+	dialContext proxydialer.DialContextFunc,
+	wg *sync.WaitGroup,
+	stats *stats,
+	parent hlsSourceParent) *hlsSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &hlsSource{
+		ur:           ur,
+		readTimeout:  readTimeout,
+		retryBackoff: newSourceRetryBackoff(retryPause, retryMaxPause),
+		dialContext:  dialContext,
+		wg:           wg,
+		stats:        stats,
+		parent:       parent,
+		ctx:          ctx,
+		ctxCancel:    ctxCancel,
+	}
+
+	s.log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Close closes a Source.
+func (s *hlsSource) Close() {
+	s.log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *hlsSource) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[hls source] "+format, args...)
+}
+
+func (s *hlsSource) run() {
+	defer s.wg.Done()
+
+	for {
+		ok := func() bool {
+			ok := s.runInner()
+			if !ok {
+				return false
+			}
+
+			select {
+			case <-time.After(s.retryBackoff.next()):
+				return true
+			case <-s.ctx.Done():
+				return false
+			}
+		}()
+		if !ok {
+			break
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *hlsSource) runInner() bool {
+	s.log(logger.Debug, "connecting")
+
+	var stream *stream
+	var rtcpSenders *rtcpsenderset.RTCPSenderSet
+	var videoTrackID int
+	var audioTrackID int
+	var h264Encoder *rtph264.Encoder
+	var aacEncoder *rtpaac.Encoder
+
+	defer func() {
+		if stream != nil {
+			s.parent.OnSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{Source: s})
+			rtcpSenders.Close()
+		}
+	}()
+
+	onFrame := func(trackID int, payload []byte) {
+		rtcpSenders.OnFrame(trackID, gortsplib.StreamTypeRTP, payload)
+		stream.onFrame(trackID, gortsplib.StreamTypeRTP, payload)
+	}
+
+	onTracks := func(videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) error {
+		var tracks gortsplib.Tracks
+
+		if videoTrack != nil {
+			h264Encoder = rtph264.NewEncoder(96, nil, nil, nil)
+			videoTrackID = len(tracks)
+			tracks = append(tracks, videoTrack)
+		}
+
+		if audioTrack != nil {
+			clockRate, _ := audioTrack.ClockRate()
+			aacEncoder = rtpaac.NewEncoder(96, clockRate, nil, nil, nil)
+			audioTrackID = len(tracks)
+			tracks = append(tracks, audioTrack)
+		}
+
+		res := s.parent.OnSourceStaticSetReady(pathSourceStaticSetReadyReq{
+			Tracks: tracks,
+		})
+		if res.Err != nil {
+			return res.Err
+		}
+
+		s.log(logger.Info, "ready")
+		s.retryBackoff.reset()
+
+		stream = res.Stream
+		rtcpSenders = rtcpsenderset.New(tracks, stream.onFrame)
+		return nil
+	}
+
+	onVideoData := func(pts time.Duration, nalus [][]byte) {
+		pkts, err := h264Encoder.Encode(nalus, pts)
+		if err != nil {
+			s.log(logger.Warn, "unable to encode video frame: %v", err)
+			return
+		}
+
+		for _, pkt := range pkts {
+			onFrame(videoTrackID, pkt)
+		}
+	}
+
+	onAudioData := func(pts time.Duration, aus [][]byte) {
+		pkts, err := aacEncoder.Encode(aus, pts)
+		if err != nil {
+			s.log(logger.Warn, "unable to encode audio frame: %v", err)
+			return
+		}
+
+		for _, pkt := range pkts {
+			onFrame(audioTrackID, pkt)
+		}
+	}
+
+	c, err := hls.NewClient(
+		s.ur,
+		&http.Client{
+			Timeout: s.readTimeout,
+			Transport: &http.Transport{
+				DialContext: s.dialContext,
+			},
+		},
+		onTracks,
+		onVideoData,
+		onAudioData)
+	if err != nil {
+		s.log(logger.Info, "ERR: %s", err)
+		return true
+	}
+
+	err = c.Run(s.ctx)
+	if s.ctx.Err() != nil {
+		return false
+	}
+
+	s.log(logger.Info, "ERR: %s", err)
+	return true
+}
+
+// OnSourceAPIDescribe implements source.
+func (*hlsSource) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"hlsSource"}
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHLSSource(t *testing.T) {
+	// the stream is read from the HLS server of the same instance
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x64\n" +
+		"  proxied:\n" +
+		"    source: http://localhost:8888/test/stream.m3u8\n" +
+		"    sourceOnDemand: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := gortsplib.DialRead("rtsp://localhost:8554/proxied")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	tracks := conn.Tracks()
+	require.Equal(t, 1, len(tracks))
+	require.Equal(t, true, tracks[0].IsH264())
+
+	readDone := make(chan struct{})
+	videoRecv := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		videoDone := false
+		conn.ReadFrames(func(trackID int, streamType base.StreamType, payload []byte) {
+			if streamType == gortsplib.StreamTypeRTP && trackID == 0 && !videoDone {
+				videoDone = true
+				close(videoRecv)
+			}
+		})
+	}()
+
+	select {
+	case <-videoRecv:
+	case <-time.After(5 * time.Second):
+		t.Errorf("no frames received")
+	}
+
+	conn.Close()
+	<-readDone
+}
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// HLS doesn't have sessions; a client is considered a viewer until
+	// it stops performing requests for this period.
+	hlsViewerTimeout = 30 * time.Second
+)
+
+type hlsViewerKey struct {
+	Path string
+	IP   string
+}
+
+type hlsViewer struct {
+	// use pointers to avoid a crash on 32bit platforms
+	// https://github.com/golang/go/issues/9959
+	BytesSent   *int64
+	LastRequest *int64
+
+	// sessions only
+	Path    string
+	IP      string
+	Timeout time.Duration
+}
+
+// hlsViewers contains the clients that are reading paths with HLS,
+// identified by path and remote IP, or by a session token when
+// sessions are enabled.
+type hlsViewers struct {
+	mutex    sync.Mutex
+	entries  map[hlsViewerKey]*hlsViewer
+	sessions map[string]*hlsViewer
+}
+
+func newHLSViewers() *hlsViewers {
+	return &hlsViewers{
+		entries:  make(map[hlsViewerKey]*hlsViewer),
+		sessions: make(map[string]*hlsViewer),
+	}
+}
+
+// removeExpired removes viewers that stopped performing requests.
+// It must be called with the mutex locked.
+func (v *hlsViewers) removeExpired(now time.Time) {
+	minTime := now.Add(-hlsViewerTimeout).UnixNano()
+	for key, e := range v.entries {
+		if atomic.LoadInt64(e.LastRequest) < minTime {
+			delete(v.entries, key)
+		}
+	}
+
+	for id, e := range v.sessions {
+		if atomic.LoadInt64(e.LastRequest) < now.Add(-e.Timeout).UnixNano() {
+			delete(v.sessions, id)
+		}
+	}
+}
+
+// onRequest returns the viewer that performed a request, creating it if it doesn't exist.
+func (v *hlsViewers) onRequest(pathName string, ip net.IP) *hlsViewer {
+	key := hlsViewerKey{Path: pathName, IP: ip.String()}
+	now := time.Now()
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	e, ok := v.entries[key]
+	if !ok {
+		v.removeExpired(now)
+
+		e = &hlsViewer{
+			BytesSent:   ptrInt64(),
+			LastRequest: ptrInt64(),
+		}
+		v.entries[key] = e
+	}
+
+	atomic.StoreInt64(e.LastRequest, now.UnixNano())
+
+	return e
+}
+
+// newSession creates a session, that expires after timeout without requests,
+// and returns its token.
+func (v *hlsViewers) newSession(pathName string, ip net.IP, timeout time.Duration) (string, error) {
+	var tmp [16]byte
+	_, err := rand.Read(tmp[:])
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(tmp[:])
+
+	now := time.Now()
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.removeExpired(now)
+
+	e := &hlsViewer{
+		BytesSent:   ptrInt64(),
+		LastRequest: ptrInt64(),
+		Path:        pathName,
+		IP:          ip.String(),
+		Timeout:     timeout,
+	}
+	atomic.StoreInt64(e.LastRequest, now.UnixNano())
+	v.sessions[id] = e
+
+	return id, nil
+}
+
+// onSessionRequest returns the session with the given token, or nil if
+// the session doesn't exist, has expired or has been kicked.
+func (v *hlsViewers) onSessionRequest(id string) *hlsViewer {
+	now := time.Now()
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	e, ok := v.sessions[id]
+	if !ok || atomic.LoadInt64(e.LastRequest) < now.Add(-e.Timeout).UnixNano() {
+		return nil
+	}
+
+	atomic.StoreInt64(e.LastRequest, now.UnixNano())
+
+	return e
+}
+
+// kickSession removes a session, preventing its token from being used again.
+func (v *hlsViewers) kickSession(id string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if _, ok := v.sessions[id]; !ok {
+		return fmt.Errorf("session not found")
+	}
+
+	delete(v.sessions, id)
+	return nil
+}
+
+func (v *hlsViewers) apiList() *apiHLSViewersListData {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.removeExpired(time.Now())
+
+	data := &apiHLSViewersListData{
+		Items: make(map[string]apiHLSViewersListItem),
+	}
+
+	add := func(pathName string, clientKey string, ip string, e *hlsViewer) {
+		bytesSent := atomic.LoadInt64(e.BytesSent)
+		lastRequest := time.Unix(0, atomic.LoadInt64(e.LastRequest)).UTC()
+
+		item, ok := data.Items[pathName]
+		if !ok {
+			item.Clients = make(map[string]apiHLSViewersListClient)
+		}
+
+		item.Viewers++
+		item.BytesSent += bytesSent
+		if lastRequest.After(item.LastRequest) {
+			item.LastRequest = lastRequest
+		}
+		item.Clients[clientKey] = apiHLSViewersListClient{
+			IP:          ip,
+			BytesSent:   bytesSent,
+			LastRequest: lastRequest,
+		}
+
+		data.Items[pathName] = item
+	}
+
+	for key, e := range v.entries {
+		add(key.Path, key.IP, key.IP, e)
+	}
+
+	// sessions are listed by token
+	for id, e := range v.sessions {
+		add(e.Path, id, e.IP, e)
+	}
+
+	return data
+}
@@ -2,6 +2,8 @@ package core
 
 import (
 	"net"
+	"net/http"
+	"strings"
 )
 
 func ipEqualOrInRange(ip net.IP, ips []interface{}) bool {
@@ -20,3 +22,69 @@ func ipEqualOrInRange(ip net.IP, ips []interface{}) bool {
 	}
 	return false
 }
+
+// ipMatchLength returns the length of the prefix of the most specific
+// IP or network that contains the given IP, or -1 if there's none.
+func ipMatchLength(ip net.IP, ips []interface{}) int {
+	ret := -1
+	for _, item := range ips {
+		switch titem := item.(type) {
+		case net.IP:
+			if titem.Equal(ip) {
+				return 128
+			}
+
+		case *net.IPNet:
+			if titem.Contains(ip) {
+				ones, _ := titem.Mask.Size()
+				if ones > ret {
+					ret = ones
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// httpClientIP returns the IP of the client that performed a HTTP request.
+// When the request comes from a trusted proxy, the IP is read from the
+// X-Forwarded-For header, that is walked from right to left skipping trusted
+// proxies, or from the X-Real-IP header.
+// Requests received through a Unix socket are performed by local processes,
+// therefore they are considered as coming from the loopback address, that
+// must be listed among the trusted proxies in order to read the headers.
+func httpClientIP(r *http.Request, trustedProxies []interface{}) net.IP {
+	tmp, _, _ := net.SplitHostPort(r.RemoteAddr)
+	ip := net.ParseIP(tmp)
+
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok &&
+		addr.Network() == "unix" {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+
+	if trustedProxies == nil || ip == nil || !ipEqualOrInRange(ip, trustedProxies) {
+		return ip
+	}
+
+	if v := r.Header.Values("X-Forwarded-For"); len(v) != 0 {
+		hops := strings.Split(strings.Join(v, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+
+			ip = hop
+			if !ipEqualOrInRange(hop, trustedProxies) {
+				break
+			}
+		}
+		return ip
+	}
+
+	if hop := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); hop != nil {
+		return hop
+	}
+
+	return ip
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// counters of IPs that have no open connections are removed
+	// after this period without activity.
+	ipStatsIdleTimeout = 1 * time.Hour
+)
+
+type ipStatsEntry struct {
+	// use pointers to avoid a crash on 32bit platforms
+	// https://github.com/golang/go/issues/9959
+	Conns         *int64
+	BytesReceived *int64
+	BytesSent     *int64
+	AuthFailures  *int64
+
+	// protected by the mutex of ipStats
+	refs     int
+	lastUsed time.Time
+}
+
+// ipStats contains counters aggregated by remote IP.
+// Counters are kept after connections are closed, in order to identify
+// clients that open many short connections, and are removed when the IP
+// has no open connections and has been idle for ipStatsIdleTimeout.
+type ipStats struct {
+	mutex   sync.Mutex
+	entries map[string]*ipStatsEntry
+}
+
+func newIPStats() *ipStats {
+	return &ipStats{
+		entries: make(map[string]*ipStatsEntry),
+	}
+}
+
+// removeIdle removes the counters of IPs that are not in use anymore.
+// It must be called with the mutex locked.
+func (s *ipStats) removeIdle(now time.Time) {
+	minTime := now.Add(-ipStatsIdleTimeout)
+	for key, e := range s.entries {
+		if e.refs == 0 && e.lastUsed.Before(minTime) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// get returns the counters of an IP, creating them if they don't exist.
+// It must be called with the mutex locked.
+func (s *ipStats) get(ip net.IP) *ipStatsEntry {
+	key := ip.String()
+	now := time.Now()
+
+	e, ok := s.entries[key]
+	if !ok {
+		s.removeIdle(now)
+
+		e = &ipStatsEntry{
+			Conns:         ptrInt64(),
+			BytesReceived: ptrInt64(),
+			BytesSent:     ptrInt64(),
+			AuthFailures:  ptrInt64(),
+		}
+		s.entries[key] = e
+	}
+
+	e.lastUsed = now
+
+	return e
+}
+
+// acquire returns the counters of an IP, that are kept until release() is called.
+func (s *ipStats) acquire(ip net.IP) *ipStatsEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e := s.get(ip)
+	e.refs++
+	return e
+}
+
+// release allows the counters returned by acquire() to be removed.
+func (s *ipStats) release(e *ipStatsEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e.refs--
+	e.lastUsed = time.Now()
+}
+
+// onConn acquires the counters of an IP and counts a new connection.
+func (s *ipStats) onConn(ip net.IP) *ipStatsEntry {
+	e := s.acquire(ip)
+	atomic.AddInt64(e.Conns, 1)
+	return e
+}
+
+func (s *ipStats) onAuthFailure(ip net.IP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	atomic.AddInt64(s.get(ip).AuthFailures, 1)
+}
+
+func (s *ipStats) apiList() *apiIPsListData {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.removeIdle(time.Now())
+
+	data := &apiIPsListData{
+		Items: make(map[string]apiIPsListItem),
+	}
+
+	for key, e := range s.entries {
+		data.Items[key] = apiIPsListItem{
+			Conns:         atomic.LoadInt64(e.Conns),
+			BytesReceived: atomic.LoadInt64(e.BytesReceived),
+			BytesSent:     atomic.LoadInt64(e.BytesSent),
+			AuthFailures:  atomic.LoadInt64(e.AuthFailures),
+		}
+	}
+
+	return data
+}
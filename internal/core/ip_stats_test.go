@@ -0,0 +1,34 @@
+package core
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPStatsRemoveIdle(t *testing.T) {
+	s := newIPStats()
+
+	conn := s.onConn(net.ParseIP("10.0.0.1"))
+	s.onAuthFailure(net.ParseIP("10.0.0.2"))
+	closed := s.onConn(net.ParseIP("10.0.0.3"))
+	s.release(closed)
+
+	require.Equal(t, 3, len(s.apiList().Items))
+
+	// simulate the passing of time
+	s.mutex.Lock()
+	for _, e := range s.entries {
+		e.lastUsed = e.lastUsed.Add(-2 * ipStatsIdleTimeout)
+	}
+	s.mutex.Unlock()
+
+	// IPs with open connections are kept
+	items := s.apiList().Items
+	require.Equal(t, 1, len(items))
+	require.Equal(t, int64(1), items["10.0.0.1"].Conns)
+
+	s.release(conn)
+	require.Equal(t, 1, len(s.apiList().Items))
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// percentiles are computed on the most recent samples.
+	latencyProbeSampleCount = 1000
+)
+
+type latencyProbePercentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// latencyProbeSamples is a circular buffer containing the most recent samples.
+type latencyProbeSamples struct {
+	buf  []time.Duration
+	next int
+}
+
+func (s *latencyProbeSamples) add(d time.Duration) {
+	if len(s.buf) < latencyProbeSampleCount {
+		s.buf = append(s.buf, d)
+		return
+	}
+
+	s.buf[s.next] = d
+	s.next = (s.next + 1) % latencyProbeSampleCount
+}
+
+func (s *latencyProbeSamples) percentiles() latencyProbePercentiles {
+	sorted := make([]time.Duration, len(s.buf))
+	copy(sorted, s.buf)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+
+	return latencyProbePercentiles{
+		P50: at(0.50),
+		P90: at(0.90),
+		P99: at(0.99),
+	}
+}
+
+// latencyProbe measures, for each protocol, the time elapsed between the
+// arrival of a frame and the moment in which it is handed to readers.
+type latencyProbe struct {
+	mutex   sync.Mutex
+	samples map[string]*latencyProbeSamples
+}
+
+func newLatencyProbe() *latencyProbe {
+	return &latencyProbe{
+		samples: make(map[string]*latencyProbeSamples),
+	}
+}
+
+func (p *latencyProbe) add(protocol string, d time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.samples[protocol]
+	if !ok {
+		s = &latencyProbeSamples{}
+		p.samples[protocol] = s
+	}
+
+	s.add(d)
+}
+
+func (p *latencyProbe) percentiles() map[string]latencyProbePercentiles {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ret := make(map[string]latencyProbePercentiles)
+	for protocol, s := range p.samples {
+		ret[protocol] = s.percentiles()
+	}
+	return ret
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyProbe(t *testing.T) {
+	p := newLatencyProbe()
+
+	for i := 1; i <= 100; i++ {
+		p.add("rtmp", time.Duration(i)*time.Millisecond)
+	}
+
+	require.Equal(t, map[string]latencyProbePercentiles{
+		"rtmp": {
+			P50: 50 * time.Millisecond,
+			P90: 90 * time.Millisecond,
+			P99: 99 * time.Millisecond,
+		},
+	}, p.percentiles())
+
+	// old samples are replaced by new ones
+	for i := 0; i < latencyProbeSampleCount; i++ {
+		p.add("rtmp", time.Second)
+	}
+
+	require.Equal(t, time.Second, p.percentiles()["rtmp"].P50)
+}
@@ -5,10 +5,12 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
@@ -105,6 +107,49 @@ func (m *metrics) onMetrics(w http.ResponseWriter, req *http.Request) {
 			readyCount, nowUnix)
 		out += formatMetric("paths{state=\"notReady\"}",
 			notReadyCount, nowUnix)
+
+		names := make([]string, 0, len(res.Data.Items))
+		for name := range res.Data.Items {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			p := res.Data.Items[name]
+			if p.Conf.RTPValidationParsed == conf.RTPValidationNo {
+				continue
+			}
+			out += formatMetric("paths_malformed_packets{name=\""+name+"\",type=\"rtp\"}",
+				p.MalformedRTPPackets, nowUnix)
+			out += formatMetric("paths_malformed_packets{name=\""+name+"\",type=\"rtcp\"}",
+				p.MalformedRTCPPackets, nowUnix)
+		}
+
+		for _, name := range names {
+			p := res.Data.Items[name]
+
+			protocols := make([]string, 0, len(p.Latency))
+			for protocol := range p.Latency {
+				protocols = append(protocols, protocol)
+			}
+			sort.Strings(protocols)
+
+			for _, protocol := range protocols {
+				l := p.Latency[protocol]
+				for _, q := range []struct {
+					quantile string
+					v        float64
+				}{
+					{"0.5", l.P50},
+					{"0.9", l.P90},
+					{"0.99", l.P99},
+				} {
+					out += formatMetric("paths_latency_microseconds{name=\""+name+
+						"\",protocol=\""+protocol+"\",quantile=\""+q.quantile+"\"}",
+						int64(q.v*1000), nowUnix)
+				}
+			}
+		}
 	}
 
 	if !interfaceIsEmpty(m.rtspServer) {
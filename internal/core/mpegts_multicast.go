@@ -0,0 +1,389 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/ringbuffer"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtp"
+	"golang.org/x/net/ipv4"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/mpegts"
+	"github.com/aler9/rtsp-simple-server/internal/sap"
+)
+
+const (
+	mpegtsMulticastSAPPeriod = 5 * time.Second
+)
+
+type mpegtsMulticastTrackIDPayloadPair struct {
+	trackID int
+	buf     []byte
+}
+
+type mpegtsMulticastParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// mpegtsMulticast is a reader that sends a stream to a multicast group,
+// in MPEG-TS format over RTP, and optionally announces it with SAP.
+type mpegtsMulticast struct {
+	pathName string
+	parent   mpegtsMulticastParent
+
+	ctx          context.Context
+	ctxCancel    func()
+	ringBuffer   *ringbuffer.RingBuffer
+	conn         *net.UDPConn
+	sapConn      *net.UDPConn
+	sapPacket    []byte
+	sapDeletion  []byte
+	videoTrack   *gortsplib.Track
+	videoTrackID int
+	audioTrack   *gortsplib.Track
+	audioTrackID int
+	done         chan struct{}
+}
+
+func mpegtsMulticastDial(address string, ttl int, iface *net.Interface) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := ipv4.NewPacketConn(conn)
+
+	if ttl != 0 {
+		err = p.SetMulticastTTL(ttl)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if iface != nil {
+		err = p.SetMulticastInterface(iface)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func newMPEGTSMulticast(
+	parentCtx context.Context,
+	pathName string,
+	pathConf *conf.PathConf,
+	readBufferCount int,
+	tracks gortsplib.Tracks,
+	parent mpegtsMulticastParent) (*mpegtsMulticast, error) {
+	m := &mpegtsMulticast{
+		pathName:     pathName,
+		parent:       parent,
+		videoTrackID: -1,
+		audioTrackID: -1,
+		done:         make(chan struct{}),
+	}
+
+	for i, t := range tracks {
+		if t.IsH264() {
+			if m.videoTrack != nil {
+				return nil, fmt.Errorf("can't send track %d with MPEG-TS: too many tracks", i+1)
+			}
+			m.videoTrack = t
+			m.videoTrackID = i
+
+		} else if t.IsAAC() {
+			if m.audioTrack != nil {
+				return nil, fmt.Errorf("can't send track %d with MPEG-TS: too many tracks", i+1)
+			}
+			m.audioTrack = t
+			m.audioTrackID = i
+		}
+	}
+
+	if m.videoTrack == nil && m.audioTrack == nil {
+		return nil, fmt.Errorf("the stream doesn't contain an H264 track or an AAC track")
+	}
+
+	var iface *net.Interface
+	if pathConf.MPEGTSMulticastInterface != "" {
+		var err error
+		iface, err = net.InterfaceByName(pathConf.MPEGTSMulticastInterface)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	m.conn, err = mpegtsMulticastDial(pathConf.MPEGTSMulticastAddress, pathConf.MPEGTSMulticastTTL, iface)
+	if err != nil {
+		return nil, err
+	}
+
+	if pathConf.MPEGTSMulticastSAP {
+		m.sapConn, err = mpegtsMulticastDial(sap.DefaultAddress, pathConf.MPEGTSMulticastTTL, iface)
+		if err != nil {
+			m.conn.Close()
+			return nil, err
+		}
+
+		m.sapPacket, m.sapDeletion, err = m.generateSAP(pathConf)
+		if err != nil {
+			m.conn.Close()
+			m.sapConn.Close()
+			return nil, err
+		}
+	}
+
+	m.ctx, m.ctxCancel = context.WithCancel(parentCtx)
+	m.ringBuffer = ringbuffer.New(uint64(readBufferCount))
+
+	m.log(logger.Info, "sending to %s", pathConf.MPEGTSMulticastAddress)
+
+	go m.run()
+
+	return m, nil
+}
+
+func (m *mpegtsMulticast) log(level logger.Level, format string, args ...interface{}) {
+	m.parent.Log(level, "[mpegts multicast] "+format, args...)
+}
+
+func (m *mpegtsMulticast) generateSAP(pathConf *conf.PathConf) ([]byte, []byte, error) {
+	group := m.conn.RemoteAddr().(*net.UDPAddr)
+	origin := m.sapConn.LocalAddr().(*net.UDPAddr).IP
+
+	h := fnv.New32a()
+	h.Write([]byte(pathConf.MPEGTSMulticastAddress))
+	msgIDHash := uint16(h.Sum32())
+
+	ttl := pathConf.MPEGTSMulticastTTL
+	if ttl == 0 {
+		ttl = 1
+	}
+
+	sdp := []byte("v=0\r\n" +
+		"o=- " + strconv.FormatUint(uint64(msgIDHash), 10) + " 1 IN IP4 " + origin.String() + "\r\n" +
+		"s=" + m.pathName + "\r\n" +
+		"c=IN IP4 " + group.IP.String() + "/" + strconv.FormatInt(int64(ttl), 10) + "\r\n" +
+		"t=0 0\r\n" +
+		"m=video " + strconv.FormatInt(int64(group.Port), 10) + " RTP/AVP 33\r\n")
+
+	announce, err := sap.Packet{
+		MessageIDHash: msgIDHash,
+		Origin:        origin,
+		SDP:           sdp,
+	}.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deletion, err := sap.Packet{
+		Deletion:      true,
+		MessageIDHash: msgIDHash,
+		Origin:        origin,
+		SDP:           sdp,
+	}.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return announce, deletion, nil
+}
+
+func (m *mpegtsMulticast) close() {
+	m.ctxCancel()
+	<-m.done
+}
+
+func (m *mpegtsMulticast) run() {
+	defer close(m.done)
+
+	writerDone := make(chan error)
+	go func() {
+		writerDone <- m.runWriter()
+	}()
+
+	var sapC <-chan time.Time
+	if m.sapConn != nil {
+		m.sapConn.Write(m.sapPacket)
+
+		sapTicker := time.NewTicker(mpegtsMulticastSAPPeriod)
+		defer sapTicker.Stop()
+		sapC = sapTicker.C
+	}
+
+outer:
+	for {
+		select {
+		case <-sapC:
+			m.sapConn.Write(m.sapPacket)
+
+		case err := <-writerDone:
+			m.log(logger.Info, "ERR: %s", err)
+			<-m.ctx.Done()
+			break outer
+
+		case <-m.ctx.Done():
+			m.ringBuffer.Close()
+			<-writerDone
+			break outer
+		}
+	}
+
+	m.ctxCancel()
+
+	if m.sapConn != nil {
+		m.sapConn.Write(m.sapDeletion)
+		m.sapConn.Close()
+	}
+
+	m.conn.Close()
+}
+
+func (m *mpegtsMulticast) runWriter() error {
+	var h264SPS []byte
+	var h264PPS []byte
+	var h264Decoder *rtph264.Decoder
+	if m.videoTrack != nil {
+		var err error
+		h264SPS, h264PPS, err = m.videoTrack.ExtractDataH264()
+		if err != nil {
+			return err
+		}
+		h264Decoder = rtph264.NewDecoder()
+	}
+
+	var aacDecoder *rtpaac.Decoder
+	if m.audioTrack != nil {
+		clockRate, _ := m.audioTrack.ClockRate()
+		aacDecoder = rtpaac.NewDecoder(clockRate)
+	}
+
+	rtpWriter := mpegts.NewRTPWriter(m.conn)
+
+	w, err := mpegts.NewWriter(rtpWriter, m.videoTrack, m.audioTrack)
+	if err != nil {
+		return err
+	}
+
+	var videoBuf [][]byte
+
+	for {
+		data, ok := m.ringBuffer.Pull()
+		if !ok {
+			return fmt.Errorf("terminated")
+		}
+		pair := data.(mpegtsMulticastTrackIDPayloadPair)
+
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(pair.buf)
+		if err != nil {
+			m.log(logger.Warn, "unable to decode RTP packet: %v", err)
+			continue
+		}
+
+		if pair.trackID == m.videoTrackID {
+			nalus, pts, err := h264Decoder.DecodeRTP(&pkt)
+			if err != nil {
+				if err != rtph264.ErrMorePacketsNeeded && err != rtph264.ErrNonStartingPacketAndNoPrevious {
+					m.log(logger.Warn, "unable to decode video track: %v", err)
+				}
+				continue
+			}
+
+			for _, nalu := range nalus {
+				// remove SPS, PPS, AUD
+				typ := h264.NALUType(nalu[0] & 0x1F)
+				switch typ {
+				case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+					continue
+				}
+
+				// add SPS and PPS before IDR
+				if typ == h264.NALUTypeIDR {
+					videoBuf = append(videoBuf, h264SPS)
+					videoBuf = append(videoBuf, h264PPS)
+				}
+
+				videoBuf = append(videoBuf, nalu)
+			}
+
+			// RTP marker means that all the NALUs with the same PTS have been received.
+			// send them together.
+			if !pkt.Marker {
+				continue
+			}
+
+			err = w.WriteH264(pts, videoBuf)
+			videoBuf = nil
+			if err != nil {
+				return err
+			}
+
+		} else if pair.trackID == m.audioTrackID {
+			aus, pts, err := aacDecoder.DecodeRTP(&pkt)
+			if err != nil {
+				if err != rtpaac.ErrMorePacketsNeeded {
+					m.log(logger.Warn, "unable to decode audio track: %v", err)
+				}
+				continue
+			}
+
+			err = w.WriteAAC(pts, aus)
+			if err != nil {
+				return err
+			}
+
+		} else {
+			continue
+		}
+
+		// send data as soon as possible
+		err = rtpWriter.Flush()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close implements reader.
+func (m *mpegtsMulticast) Close() {
+	m.ctxCancel()
+}
+
+// OnReaderAccepted implements reader.
+func (m *mpegtsMulticast) OnReaderAccepted() {
+}
+
+// OnReaderFrame implements reader.
+func (m *mpegtsMulticast) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType == gortsplib.StreamTypeRTP {
+		m.ringBuffer.Push(mpegtsMulticastTrackIDPayloadPair{trackID, payload})
+	}
+}
+
+// OnReaderAPIDescribe implements reader.
+func (m *mpegtsMulticast) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"mpegtsMulticast"}
+}
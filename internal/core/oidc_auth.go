@@ -0,0 +1,172 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/oidc"
+)
+
+const (
+	oidcCallbackPath    = "/oidc/callback"
+	oidcTokenCookie     = "rtsp-simple-server-oidc"
+	oidcStateCookie     = "rtsp-simple-server-oidc-state"
+	oidcStateCookieTime = 600
+)
+
+var (
+	errOIDCNoToken       = errors.New("token not provided")
+	errOIDCStateMismatch = errors.New("state mismatch")
+)
+
+// oidcAuth implements OpenID Connect authentication for the HTTP servers.
+// Browsers are redirected to the provider and receive a session cookie
+// containing the ID token; other clients can provide a bearer token.
+type oidcAuth struct {
+	provider *oidc.Provider
+}
+
+func newOIDCAuth(issuer string, clientID string, clientSecret string) *oidcAuth {
+	if issuer == "" {
+		return nil
+	}
+
+	return &oidcAuth{
+		provider: oidc.New(issuer, clientID, clientSecret),
+	}
+}
+
+func (a *oidcAuth) redirectURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + oidcCallbackPath
+}
+
+// authenticate returns the claims of the token provided by the client.
+func (a *oidcAuth) authenticate(r *http.Request) (*oidc.Claims, error) {
+	var token string
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	} else if c, err := r.Cookie(oidcTokenCookie); err == nil {
+		token = c.Value
+	}
+
+	if token == "" {
+		return nil, errOIDCNoToken
+	}
+
+	return a.provider.Verify(token)
+}
+
+// redirectToLogin redirects a browser to the login page of the provider.
+func (a *oidcAuth) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	var tmp [16]byte
+	_, err := rand.Read(tmp[:])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(tmp[:])
+
+	u, err := a.provider.AuthCodeURL(a.redirectURL(r), state)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	// the state cookie contains the state and the page to return to
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state + ":" + url.QueryEscape(r.URL.RequestURI()),
+		Path:     oidcCallbackPath,
+		MaxAge:   oidcStateCookieTime,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// oidcRedirectDest returns the path where users are redirected after the login.
+// Only local paths are allowed, in order to prevent open redirects; browsers
+// treat backslashes like slashes, therefore "/\host" is not a local path.
+func oidcRedirectDest(v string) string {
+	dest, err := url.QueryUnescape(v)
+	if err != nil || !strings.HasPrefix(dest, "/") ||
+		strings.HasPrefix(dest, "//") || strings.HasPrefix(dest, "/\\") {
+		return "/"
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+
+	return dest
+}
+
+// handleCallback handles the redirect from the provider.
+func (a *oidcAuth) handleCallback(w http.ResponseWriter, r *http.Request) error {
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return errOIDCStateMismatch
+	}
+
+	parts := strings.SplitN(c.Value, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[0] != r.URL.Query().Get("state") {
+		w.WriteHeader(http.StatusBadRequest)
+		return errOIDCStateMismatch
+	}
+
+	dest := oidcRedirectDest(parts[1])
+
+	token, claims, err := a.provider.Exchange(a.redirectURL(r), r.URL.Query().Get("code"))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     oidcCallbackPath,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcTokenCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(claims.Expiry - time.Now().Unix()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, dest, http.StatusFound)
+	return nil
+}
+
+// isOIDCUserAllowed checks whether the user is in the allowed list.
+// An empty list allows all users authenticated by the provider.
+func isOIDCUserAllowed(claims *oidc.Claims, users []string) bool {
+	if len(users) == 0 {
+		return true
+	}
+
+	for _, u := range users {
+		if u == claims.Subject || (claims.Email != "" && u == claims.Email) {
+			return true
+		}
+	}
+	return false
+}
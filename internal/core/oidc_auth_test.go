@@ -0,0 +1,28 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCRedirectDest(t *testing.T) {
+	for _, ca := range []struct {
+		dest string
+		res  string
+	}{
+		{"/mypath/index.m3u8", "/mypath/index.m3u8"},
+		{"/mypath/?a=b", "/mypath/?a=b"},
+		{"", "/"},
+		{"mypath", "/"},
+		{"//evil.com", "/"},
+		{"/\\evil.com", "/"},
+		{"/\t/evil.com", "/"},
+		{"http://evil.com", "/"},
+	} {
+		t.Run(ca.dest, func(t *testing.T) {
+			require.Equal(t, ca.res, oidcRedirectDest(url.QueryEscape(ca.dest)))
+		})
+	}
+}
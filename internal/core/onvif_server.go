@@ -0,0 +1,308 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/onvif"
+)
+
+const (
+	onvifMaxRequestSize = 64 * 1024
+)
+
+type onvifServerParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// onvifServer exposes each path as an ONVIF device, that can be found with
+// WS-Discovery and whose stream URI can be obtained with SOAP requests.
+type onvifServer struct {
+	address     string
+	rtspScheme  string
+	rtspAddress string
+	pathManager *pathManager
+	parent      onvifServerParent
+
+	ctx        context.Context
+	ctxCancel  func()
+	wg         sync.WaitGroup
+	ln         net.Listener
+	pc         net.PacketConn
+	mutex      sync.Mutex
+	pathConfs  map[string]*conf.PathConf
+	httpServer *http.Server
+}
+
+func newONVIFServer(
+	parentCtx context.Context,
+	address string,
+	rtspScheme string,
+	rtspAddress string,
+	pathConfs map[string]*conf.PathConf,
+	pathManager *pathManager,
+	parent onvifServerParent,
+) (*onvifServer, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	_, port, _ := net.SplitHostPort(onvif.DiscoveryAddress)
+	pc, err := net.ListenPacket("udp4", ":"+port)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &onvifServer{
+		address:     address,
+		rtspScheme:  rtspScheme,
+		rtspAddress: rtspAddress,
+		pathManager: pathManager,
+		parent:      parent,
+		ctx:         ctx,
+		ctxCancel:   ctxCancel,
+		ln:          ln,
+		pc:          pc,
+		pathConfs:   pathConfs,
+	}
+
+	s.joinDiscoveryGroup()
+
+	s.httpServer = &http.Server{Handler: s}
+
+	s.Log(logger.Info, "listener opened on "+address+" (HTTP), :"+port+" (WS-Discovery)")
+
+	s.wg.Add(2)
+	go s.runHTTP()
+	go s.runDiscovery()
+
+	return s, nil
+}
+
+// Log is the main logging function.
+func (s *onvifServer) Log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[ONVIF] "+format, append([]interface{}{}, args...)...)
+}
+
+func (s *onvifServer) close() {
+	s.ctxCancel()
+	s.httpServer.Shutdown(context.Background())
+	s.pc.Close()
+	s.wg.Wait()
+	s.Log(logger.Info, "closed")
+}
+
+// OnConfReload is called by core.
+func (s *onvifServer) OnConfReload(pathConfs map[string]*conf.PathConf) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pathConfs = pathConfs
+}
+
+// joinDiscoveryGroup joins the WS-Discovery multicast group on every
+// interface that supports multicast. Failures are not fatal, since probes
+// can also be sent in unicast.
+func (s *onvifServer) joinDiscoveryGroup() {
+	host, _, _ := net.SplitHostPort(onvif.DiscoveryAddress)
+	group := &net.UDPAddr{IP: net.ParseIP(host)}
+	p := ipv4.NewPacketConn(s.pc)
+
+	intfs, err := net.Interfaces()
+	if err != nil {
+		s.Log(logger.Warn, "unable to list interfaces: %s", err)
+		return
+	}
+
+	joined := 0
+	for _, intf := range intfs {
+		if (intf.Flags&net.FlagUp) == 0 || (intf.Flags&net.FlagMulticast) == 0 {
+			continue
+		}
+
+		intf := intf
+		err := p.JoinGroup(&intf, group)
+		if err == nil {
+			joined++
+		}
+	}
+
+	if joined == 0 {
+		s.Log(logger.Warn, "unable to join the WS-Discovery multicast group")
+	}
+}
+
+// devices returns the names of the paths that are exposed as devices, that are
+// paths with a fixed name and paths that are currently in use.
+func (s *onvifServer) devices() []string {
+	names := make(map[string]struct{})
+
+	s.mutex.Lock()
+	for name, pathConf := range s.pathConfs {
+		if pathConf.Regexp == nil {
+			names[name] = struct{}{}
+		}
+	}
+	s.mutex.Unlock()
+
+	res := s.pathManager.OnAPIPathsList(apiPathsListReq1{})
+	if res.Err == nil {
+		for name := range res.Paths {
+			names[name] = struct{}{}
+		}
+	}
+
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *onvifServer) deviceExists(name string) bool {
+	for _, n := range s.devices() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *onvifServer) xAddr(host string, pathName string) string {
+	_, port, _ := net.SplitHostPort(s.address)
+	return "http://" + net.JoinHostPort(host, port) + "/onvif/" + url.PathEscape(pathName) + "/device_service"
+}
+
+func (s *onvifServer) runHTTP() {
+	defer s.wg.Done()
+
+	err := s.httpServer.Serve(s.ln)
+	if err != http.ErrServerClosed {
+		s.Log(logger.Warn, "ERR: %s", err)
+	}
+}
+
+func (s *onvifServer) runDiscovery() {
+	defer s.wg.Done()
+
+	buf := make([]byte, onvifMaxRequestSize)
+
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+			default:
+				s.Log(logger.Warn, "ERR: %s", err)
+			}
+			return
+		}
+
+		var probe onvif.Probe
+		err = probe.Unmarshal(buf[:n])
+		if err != nil || !probe.MatchesDevice() {
+			continue
+		}
+
+		s.onProbe(probe, addr.(*net.UDPAddr))
+	}
+}
+
+func (s *onvifServer) onProbe(probe onvif.Probe, addr *net.UDPAddr) {
+	// find the local IP that is reachable by the sender
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return
+	}
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP.String()
+	conn.Close()
+
+	// reply with a message for each device, since most clients don't support
+	// multiple devices in a single message.
+	for _, name := range s.devices() {
+		s.pc.WriteTo(onvif.ProbeMatch{
+			MessageID:  onvif.DeviceUUID(probe.MessageID + "/" + name),
+			RelatesTo:  probe.MessageID,
+			DeviceUUID: onvif.DeviceUUID(name),
+			Name:       name,
+			XAddr:      s.xAddr(localIP, name),
+		}.Marshal(), addr)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *onvifServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Log(logger.Debug, "[conn %v] %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/onvif/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	pa := strings.TrimPrefix(r.URL.Path, "/onvif/")
+	i := strings.LastIndexByte(pa, '/')
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	pathName, service := pa[:i], pa[i+1:]
+
+	if (service != "device_service" && service != "media_service") || !s.deviceExists(pathName) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	byts, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, onvifMaxRequestSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	action, err := onvif.Action(byts)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(onvif.Fault(err.Error()))
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	_, rtspPort, _ := net.SplitHostPort(s.rtspAddress)
+
+	device := onvif.Device{
+		Name:            pathName,
+		UUID:            onvif.DeviceUUID(pathName),
+		FirmwareVersion: version,
+		XAddr:           s.xAddr(host, pathName),
+		StreamURI:       s.rtspScheme + "://" + net.JoinHostPort(host, rtspPort) + "/" + pathName,
+	}
+
+	res := device.Response(action, time.Now())
+	if res == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(onvif.Fault("action '" + action + "' is not supported"))
+		return
+	}
+
+	w.Write(res)
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestONVIFServer(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"onvif: yes\n" +
+		"paths:\n" +
+		"  mypath:\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	t.Run("discovery", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.WriteTo([]byte(`<?xml version="1.0" encoding="UTF-8"?>`+
+			`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope"`+
+			` xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing"`+
+			` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"`+
+			` xmlns:dn="http://www.onvif.org/ver10/network/wsdl">`+
+			`<e:Header><w:MessageID>uuid:1234</w:MessageID></e:Header>`+
+			`<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body>`+
+			`</e:Envelope>`),
+			&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3702})
+		require.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 4096)
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Contains(t, string(buf[:n]), "<a:RelatesTo>uuid:1234</a:RelatesTo>")
+		require.Contains(t, string(buf[:n]), "<d:XAddrs>http://127.0.0.1:8000/onvif/mypath/device_service</d:XAddrs>")
+	})
+
+	soap := func(u string, action string) (int, string) {
+		res, err := http.Post(u, "application/soap+xml", bytes.NewReader([]byte(
+			`<?xml version="1.0" encoding="UTF-8"?>`+
+				`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">`+
+				`<s:Body><`+action+` xmlns="http://www.onvif.org/ver10/media/wsdl"/></s:Body>`+
+				`</s:Envelope>`)))
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		byts, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(byts)
+	}
+
+	t.Run("stream uri", func(t *testing.T) {
+		code, body := soap("http://localhost:8000/onvif/mypath/media_service", "GetStreamUri")
+		require.Equal(t, http.StatusOK, code)
+		require.Contains(t, body, "<tt:Uri>rtsp://localhost:8554/mypath</tt:Uri>")
+	})
+
+	t.Run("unsupported action", func(t *testing.T) {
+		code, body := soap("http://localhost:8000/onvif/mypath/device_service", "SystemReboot")
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Contains(t, body, "<s:Fault>")
+	})
+
+	t.Run("unknown path", func(t *testing.T) {
+		code, _ := soap("http://localhost:8000/onvif/otherpath/device_service", "GetProfiles")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+}
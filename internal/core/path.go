@@ -3,7 +3,11 @@ package core
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -11,10 +15,12 @@ import (
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
+	psdp "github.com/pion/sdp/v3"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/proxydialer"
 )
 
 func newEmptyTimer() *time.Timer {
@@ -32,6 +38,25 @@ func (e pathErrNoOnePublishing) Error() string {
 	return fmt.Sprintf("no one is publishing to path '%s'", e.PathName)
 }
 
+type pathErrNotReady struct {
+	PathName   string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e pathErrNotReady) Error() string {
+	return fmt.Sprintf("source of path '%s' is starting", e.PathName)
+}
+
+// RetryAfterSeconds returns the value of the Retry-After header.
+func (e pathErrNotReady) RetryAfterSeconds() string {
+	secs := int64(math.Ceil(e.RetryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
 type pathErrAuthNotCritical struct {
 	*base.Response
 }
@@ -51,6 +76,19 @@ func (pathErrAuthCritical) Error() string {
 	return "critical authentication error"
 }
 
+type pathErrCodecNotAllowed struct {
+	PathName string
+	Codec    string
+}
+
+// Error implements the error interface.
+func (e pathErrCodecNotAllowed) Error() string {
+	if e.Codec == "" {
+		return fmt.Sprintf("a track with an unknown codec is not allowed in path '%s'", e.PathName)
+	}
+	return fmt.Sprintf("codec '%s' is not allowed in path '%s'", e.Codec, e.PathName)
+}
+
 type pathParent interface {
 	Log(logger.Level, string, ...interface{})
 	OnPathSourceReady(*path)
@@ -70,6 +108,15 @@ func (*sourceRedirect) OnSourceAPIDescribe() interface{} {
 	}{"redirect"}
 }
 
+type sourceVOD struct{}
+
+// OnSourceAPIDescribe implements source.
+func (*sourceVOD) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"vod"}
+}
+
 type pathReaderState int
 
 const (
@@ -77,6 +124,12 @@ const (
 	pathReaderStatePlay
 )
 
+const (
+	// keyframes are requested to the source at most once in this period,
+	// in order not to flood it when many readers join at the same time.
+	pathKeyframeRequestMinPeriod = 1 * time.Second
+)
+
 type pathOnDemandState int
 
 const (
@@ -92,8 +145,9 @@ type pathSourceStaticSetReadyRes struct {
 }
 
 type pathSourceStaticSetReadyReq struct {
-	Tracks gortsplib.Tracks
-	Res    chan pathSourceStaticSetReadyRes
+	Tracks   gortsplib.Tracks
+	Metadata map[string]string
+	Res      chan pathSourceStaticSetReadyRes
 }
 
 type pathSourceStaticSetNotReadyReq struct {
@@ -101,6 +155,22 @@ type pathSourceStaticSetNotReadyReq struct {
 	Res    chan struct{}
 }
 
+type pathSourceStaticSwitchReq struct {
+	URL    string
+	Reason string
+}
+
+type pathSourceTracksUpdateRes struct {
+	Stream *stream
+	Err    error
+}
+
+type pathSourceTracksUpdateReq struct {
+	Source source
+	Tracks gortsplib.Tracks
+	Res    chan pathSourceTracksUpdateRes
+}
+
 type pathReaderRemoveReq struct {
 	Author reader
 	Res    chan struct{}
@@ -112,30 +182,37 @@ type pathPublisherRemoveReq struct {
 }
 
 type pathDescribeRes struct {
-	Path     *path
-	Stream   *stream
-	Redirect string
-	Err      error
+	Path         *path
+	Stream       *stream
+	Redirect     string
+	RedirectHook string
+	VODFile      string
+	Err          error
 }
 
 type pathDescribeReq struct {
 	PathName            string
 	URL                 *base.URL
+	Query               string
 	IP                  net.IP
+	ClientCN            string
 	ValidateCredentials func(pathUser string, pathPass string) error
 	Res                 chan pathDescribeRes
 }
 
 type pathReaderSetupPlayRes struct {
-	Path   *path
-	Stream *stream
-	Err    error
+	Path    *path
+	Stream  *stream
+	VODFile string
+	Err     error
 }
 
 type pathReaderSetupPlayReq struct {
 	Author              reader
 	PathName            string
+	Query               string
 	IP                  net.IP
+	ClientCN            string
 	ValidateCredentials func(pathUser string, pathPass string) error
 	Res                 chan pathReaderSetupPlayRes
 }
@@ -148,11 +225,18 @@ type pathPublisherAnnounceRes struct {
 type pathPublisherAnnounceReq struct {
 	Author              publisher
 	PathName            string
+	Tracks              gortsplib.Tracks
 	IP                  net.IP
+	ClientCN            string
 	ValidateCredentials func(pathUser string, pathPass string) error
 	Res                 chan pathPublisherAnnounceRes
 }
 
+type pathStandbyPublisher struct {
+	req      pathPublisherAnnounceReq
+	deadline time.Time
+}
+
 type pathReaderPlayReq struct {
 	Author reader
 	Res    chan struct{}
@@ -164,9 +248,11 @@ type pathPublisherRecordRes struct {
 }
 
 type pathPublisherRecordReq struct {
-	Author publisher
-	Tracks gortsplib.Tracks
-	Res    chan pathPublisherRecordRes
+	Author        publisher
+	Tracks        gortsplib.Tracks
+	SDPAttributes []psdp.Attribute
+	Metadata      map[string]string
+	Res           chan pathPublisherRecordRes
 }
 
 type pathReaderPauseReq struct {
@@ -179,18 +265,31 @@ type pathPublisherPauseReq struct {
 	Res    chan struct{}
 }
 
+type pathSourceSuspendRes struct {
+	Err error
+}
+
+type pathSourceSuspendReq struct {
+	Author    source // nil when the request comes from the API
+	Suspended bool
+	Res       chan pathSourceSuspendRes
+}
+
 type path struct {
-	rtspAddress     string
-	readTimeout     time.Duration
-	writeTimeout    time.Duration
-	readBufferCount int
-	readBufferSize  int
-	confName        string
-	conf            *conf.PathConf
-	name            string
-	wg              *sync.WaitGroup
-	stats           *stats
-	parent          pathParent
+	rtspAddress       string
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	readBufferCount   int
+	readBufferSize    int
+	outboundProxy     string
+	outboundInterface string
+	sourcePortRange   *conf.PortRange
+	confName          string
+	conf              *conf.PathConf
+	name              string
+	wg                *sync.WaitGroup
+	stats             *stats
+	parent            pathParent
 
 	ctx                context.Context
 	ctxCancel          func()
@@ -206,10 +305,29 @@ type path struct {
 	onDemandReadyTimer *time.Timer
 	onDemandCloseTimer *time.Timer
 	onDemandState      pathOnDemandState
+	onDemandQuery      string
+	publishLimitTimer  *time.Timer
+	idleTimer          *time.Timer
+	idleTimerRunning   bool
+	suspended          bool
+	lastKeyframeReq    time.Time
+	mpegtsMulticast    *mpegtsMulticast
+	pushers            []*rtmpPusher
+	renditionCmds      []*externalcmd.Cmd
+	audioTranscodeCmd  *externalcmd.Cmd
+	redirectIdx        int
+	standbyPublishers  []pathStandbyPublisher
+	standbyTimer       *time.Timer
+	graceTimer         *time.Timer
+	graceTimerRunning  bool
+	healthCheck        *sourceHealthCheck
+	sourceBandwidth    *bandwidthCounter // static sources
 
 	// in
 	sourceStaticSetReady    chan pathSourceStaticSetReadyReq
 	sourceStaticSetNotReady chan pathSourceStaticSetNotReadyReq
+	sourceTracksUpdate      chan pathSourceTracksUpdateReq
+	sourceSuspend           chan pathSourceSuspendReq
 	describe                chan pathDescribeReq
 	publisherRemove         chan pathPublisherRemoveReq
 	publisherAnnounce       chan pathPublisherAnnounceReq
@@ -229,6 +347,9 @@ func newPath(
 	writeTimeout time.Duration,
 	readBufferCount int,
 	readBufferSize int,
+	outboundProxy string,
+	outboundInterface string,
+	sourcePortRange *conf.PortRange,
 	confName string,
 	conf *conf.PathConf,
 	name string,
@@ -243,6 +364,9 @@ func newPath(
 		writeTimeout:            writeTimeout,
 		readBufferCount:         readBufferCount,
 		readBufferSize:          readBufferSize,
+		outboundProxy:           outboundProxy,
+		outboundInterface:       outboundInterface,
+		sourcePortRange:         sourcePortRange,
 		confName:                confName,
 		conf:                    conf,
 		name:                    name,
@@ -254,8 +378,14 @@ func newPath(
 		readers:                 make(map[reader]pathReaderState),
 		onDemandReadyTimer:      newEmptyTimer(),
 		onDemandCloseTimer:      newEmptyTimer(),
+		publishLimitTimer:       newEmptyTimer(),
+		idleTimer:               newEmptyTimer(),
+		standbyTimer:            newEmptyTimer(),
+		graceTimer:              newEmptyTimer(),
 		sourceStaticSetReady:    make(chan pathSourceStaticSetReadyReq),
 		sourceStaticSetNotReady: make(chan pathSourceStaticSetNotReadyReq),
+		sourceTracksUpdate:      make(chan pathSourceTracksUpdateReq),
+		sourceSuspend:           make(chan pathSourceSuspendReq),
 		describe:                make(chan pathDescribeReq),
 		publisherRemove:         make(chan pathPublisherRemoveReq),
 		publisherAnnounce:       make(chan pathPublisherAnnounceReq),
@@ -268,6 +398,21 @@ func newPath(
 		apiPathsList:            make(chan apiPathsListReq2),
 	}
 
+	if conf.ReadTimeout != 0 {
+		pa.readTimeout = conf.ReadTimeout
+	}
+	if conf.WriteTimeout != 0 {
+		pa.writeTimeout = conf.WriteTimeout
+	}
+	if conf.SourceRTPPortRangeParsed != nil {
+		pa.sourcePortRange = conf.SourceRTPPortRangeParsed
+	}
+
+	// bytes received from static sources are not associated with any user.
+	if pa.hasStaticSource() {
+		pa.sourceBandwidth = stats.Bandwidth.acquire(name, "")
+	}
+
 	pa.Log(logger.Info, "created")
 
 	pa.wg.Add(1)
@@ -306,10 +451,23 @@ func (pa *path) run() {
 
 	if pa.conf.Source == "redirect" {
 		pa.source = &sourceRedirect{}
+
+		// start the rotation from a URL that depends on the path name, in order
+		// to spread readers of paths created by regular expressions.
+		h := fnv.New32a()
+		h.Write([]byte(pa.name))
+		pa.redirectIdx = int(h.Sum32() % uint32(1+len(pa.conf.SourceRedirectPool)))
+	} else if pa.conf.Source == "vod" {
+		pa.source = &sourceVOD{}
 	} else if !pa.conf.SourceOnDemand && pa.hasStaticSource() {
 		pa.staticSourceCreate()
 	}
 
+	if pa.conf.SourceHealthCheckInterval != 0 {
+		pa.healthCheck = newSourceHealthCheck(pa.ctx, pa.sourceURLs(),
+			pa.conf.SourceHealthCheckInterval, pa.sourceProbe, pa)
+	}
+
 	var onInitCmd *externalcmd.Cmd
 	if pa.conf.RunOnInit != "" {
 		pa.Log(logger.Info, "on init command started")
@@ -347,8 +505,35 @@ outer:
 				break outer
 			}
 
+		case <-pa.publishLimitTimer.C:
+			pa.closePublisherOnLimit("maxPublishDuration")
+
+			if pa.source == nil && pa.conf.Regexp != nil {
+				break outer
+			}
+
+		case <-pa.idleTimer.C:
+			pa.idleTimerRunning = false
+			pa.closePublisherOnLimit("idleCloseAfter")
+
+			if pa.source == nil && pa.conf.Regexp != nil {
+				break outer
+			}
+
+		case <-pa.standbyTimer.C:
+			pa.expireStandbyPublishers()
+
+		case <-pa.graceTimer.C:
+			pa.graceTimerRunning = false
+			pa.Log(logger.Info, "publisher didn't come back")
+			pa.sourceSetNotReady()
+
+			if pa.source == nil && pa.conf.Regexp != nil {
+				break outer
+			}
+
 		case req := <-pa.sourceStaticSetReady:
-			pa.sourceSetReady(req.Tracks)
+			pa.sourceSetReady(req.Tracks, nil, req.Metadata)
 			req.Res <- pathSourceStaticSetReadyRes{Stream: pa.stream}
 
 		case req := <-pa.sourceStaticSetNotReady:
@@ -365,13 +550,19 @@ outer:
 				break outer
 			}
 
+		case req := <-pa.sourceTracksUpdate:
+			pa.handleSourceTracksUpdate(req)
+
+		case req := <-pa.sourceSuspend:
+			pa.handleSourceSuspend(req)
+
 		case req := <-pa.describe:
 			pa.handleDescribe(req)
 
 		case req := <-pa.publisherRemove:
 			pa.handlePublisherRemove(req)
 
-			if pa.source == nil && pa.conf.Regexp != nil {
+			if pa.source == nil && !pa.graceTimerRunning && pa.conf.Regexp != nil {
 				break outer
 			}
 
@@ -412,6 +603,14 @@ outer:
 
 	pa.onDemandReadyTimer.Stop()
 	pa.onDemandCloseTimer.Stop()
+	pa.publishLimitTimer.Stop()
+	pa.idleTimer.Stop()
+	pa.standbyTimer.Stop()
+	pa.graceTimer.Stop()
+
+	if pa.healthCheck != nil {
+		pa.healthCheck.close()
+	}
 
 	if onInitCmd != nil {
 		pa.Log(logger.Info, "on init command stopped")
@@ -426,6 +625,10 @@ outer:
 		req.Res <- pathReaderSetupPlayRes{Err: fmt.Errorf("terminated")}
 	}
 
+	for _, sp := range pa.standbyPublishers {
+		sp.req.Res <- pathPublisherAnnounceRes{Err: fmt.Errorf("terminated")}
+	}
+
 	for rp, state := range pa.readers {
 		if state == pathReaderStatePlay {
 			atomic.AddInt64(pa.stats.CountReaders, -1)
@@ -439,6 +642,10 @@ outer:
 	}
 
 	if pa.stream != nil {
+		pa.stopAudioTranscode()
+		pa.stopRenditions()
+		pa.stopPushers()
+		pa.stopMPEGTSMulticast()
 		pa.stream.close()
 	}
 
@@ -447,27 +654,57 @@ outer:
 			source.Close()
 			pa.sourceStaticWg.Wait()
 		} else if source, ok := pa.source.(publisher); ok {
-			if pa.sourceReady {
+			if pa.sourceReady && !pa.graceTimerRunning {
 				atomic.AddInt64(pa.stats.CountPublishers, -1)
 			}
 			source.Close()
 		}
 	}
 
+	if pa.sourceBandwidth != nil {
+		pa.stats.Bandwidth.release(pa.sourceBandwidth)
+	}
+
 	pa.parent.OnPathClose(pa)
 }
 
 func (pa *path) hasStaticSource() bool {
 	return strings.HasPrefix(pa.conf.Source, "rtsp://") ||
 		strings.HasPrefix(pa.conf.Source, "rtsps://") ||
-		strings.HasPrefix(pa.conf.Source, "rtmp://")
+		strings.HasPrefix(pa.conf.Source, "rtmp://") ||
+		strings.HasPrefix(pa.conf.Source, "rtmps://") ||
+		strings.HasPrefix(pa.conf.Source, "http://") ||
+		strings.HasPrefix(pa.conf.Source, "https://") ||
+		pa.conf.Source == "testpattern"
 }
 
 func (pa *path) isOnDemand() bool {
 	return (pa.hasStaticSource() && pa.conf.SourceOnDemand) || pa.conf.RunOnDemand != ""
 }
 
-func (pa *path) onDemandStartSource() {
+// pathOnDemandQuery returns the query of a reader that is forwarded to
+// on-demand sources, without the credentials used to authenticate with the server.
+func pathOnDemandQuery(rawQuery string) string {
+	rawQuery = strings.TrimPrefix(rawQuery, "?")
+	if rawQuery == "" {
+		return ""
+	}
+
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+
+	q.Del("user")
+	q.Del("pass")
+	return q.Encode()
+}
+
+func (pa *path) onDemandStartSource(query string) {
+	if pa.conf.OnDemandForwardQuery {
+		pa.onDemandQuery = pathOnDemandQuery(query)
+	}
+
 	pa.onDemandReadyTimer.Stop()
 	if pa.hasStaticSource() {
 		pa.staticSourceCreate()
@@ -477,8 +714,9 @@ func (pa *path) onDemandStartSource() {
 		pa.Log(logger.Info, "on demand command started")
 		_, port, _ := net.SplitHostPort(pa.rtspAddress)
 		pa.onDemandCmd = externalcmd.New(pa.conf.RunOnDemand, pa.conf.RunOnDemandRestart, externalcmd.Environment{
-			Path: pa.name,
-			Port: port,
+			Path:  pa.name,
+			Port:  port,
+			Query: pa.onDemandQuery,
 		})
 		pa.onDemandReadyTimer = time.NewTimer(pa.conf.RunOnDemandStartTimeout)
 	}
@@ -505,6 +743,7 @@ func (pa *path) onDemandCloseSource() {
 
 	// set state before doPublisherRemove()
 	pa.onDemandState = pathOnDemandStateInitial
+	pa.onDemandQuery = ""
 
 	if pa.hasStaticSource() {
 		if pa.sourceReady {
@@ -524,9 +763,132 @@ func (pa *path) onDemandCloseSource() {
 	}
 }
 
-func (pa *path) sourceSetReady(tracks gortsplib.Tracks) {
+func (pa *path) newStream(tracks gortsplib.Tracks, sdpAttributes []psdp.Attribute, metadata map[string]string) *stream {
+	// the GOP cache can't be longer than the buffers of readers.
+	gopCacheSize := 0
+	if pa.conf.ReaderStartParsed == conf.ReaderStartKeyframe {
+		gopCacheSize = pa.readBufferCount
+	}
+
+	return newStream(tracks, sdpAttributes, metadata, pa.conf.RTPValidationParsed, pa.conf.RTPHeaderExtensionsParsed,
+		pa.conf.LatencyProbe, pa.conf.PublisherGracePeriod != 0, gopCacheSize, pa.sourceBandwidth, pa)
+}
+
+func (pa *path) startMPEGTSMulticast(tracks gortsplib.Tracks) {
+	if pa.conf.MPEGTSMulticastAddress == "" {
+		return
+	}
+
+	m, err := newMPEGTSMulticast(pa.ctx, pa.name, pa.conf, pa.readBufferCount, tracks, pa)
+	if err != nil {
+		pa.Log(logger.Warn, "unable to start MPEG-TS multicast: %s", err)
+		return
+	}
+
+	pa.mpegtsMulticast = m
+	pa.stream.readerAdd(m)
+}
+
+func (pa *path) stopMPEGTSMulticast() {
+	if pa.mpegtsMulticast == nil {
+		return
+	}
+
+	pa.stream.readerRemove(pa.mpegtsMulticast)
+	pa.mpegtsMulticast.close()
+	pa.mpegtsMulticast = nil
+}
+
+// startPushers starts forwarding the stream to the push targets.
+func (pa *path) startPushers(tracks gortsplib.Tracks) {
+	if len(pa.conf.PushTargets) == 0 {
+		return
+	}
+
+	dialContext := pa.sourceDialContext(pa.sourceLocalIP())
+
+	for i, ur := range pa.conf.PushTargets {
+		p, err := newRTMPPusher(pa.ctx, i, ur, pa.conf.Title, pa.conf.Description,
+			pa.readTimeout, pa.writeTimeout, pa.readBufferCount, dialContext, tracks, pa)
+		if err != nil {
+			pa.Log(logger.Warn, "unable to start push target %d: %s", i+1, err)
+			continue
+		}
+
+		pa.pushers = append(pa.pushers, p)
+		pa.stream.readerAdd(p)
+	}
+}
+
+func (pa *path) stopPushers() {
+	for _, p := range pa.pushers {
+		pa.stream.readerRemove(p)
+		p.close()
+	}
+	pa.pushers = nil
+}
+
+// startRenditions starts the commands that transcode the stream into
+// HLS renditions, that are published to other paths.
+func (pa *path) startRenditions() {
+	if pa.conf.HLSRenditionsParsed == nil {
+		return
+	}
+
+	_, port, _ := net.SplitHostPort(pa.rtspAddress)
+
+	for _, r := range pa.conf.HLSRenditionsParsed {
+		pa.renditionCmds = append(pa.renditionCmds, externalcmd.New(pa.conf.HLSRenditionCommand, true, externalcmd.Environment{
+			Path:             pa.name,
+			Port:             port,
+			RenditionPath:    hlsRenditionPath(pa.name, r),
+			RenditionHeight:  strconv.FormatInt(int64(r.Height), 10),
+			RenditionBitrate: r.Bitrate,
+		}))
+	}
+
+	pa.Log(logger.Info, "transcoding into %d HLS renditions", len(pa.renditionCmds))
+}
+
+func (pa *path) stopRenditions() {
+	for _, c := range pa.renditionCmds {
+		c.Close()
+	}
+	pa.renditionCmds = nil
+}
+
+// startAudioTranscode starts the command that transcodes audio tracks
+// that can't be muxed into HLS into AAC, and publishes the result to another path.
+func (pa *path) startAudioTranscode(tracks gortsplib.Tracks) {
+	if !pa.conf.HLSAudioTranscode || !hlsAudioNeedsTranscode(tracks) {
+		return
+	}
+
+	_, port, _ := net.SplitHostPort(pa.rtspAddress)
+
+	pa.audioTranscodeCmd = externalcmd.New(pa.conf.HLSAudioTranscodeCommand, true, externalcmd.Environment{
+		Path:          pa.name,
+		Port:          port,
+		RenditionPath: hlsAudioTranscodePath(pa.name),
+	})
+
+	pa.Log(logger.Info, "transcoding audio into AAC for HLS")
+}
+
+func (pa *path) stopAudioTranscode() {
+	if pa.audioTranscodeCmd != nil {
+		pa.audioTranscodeCmd.Close()
+		pa.audioTranscodeCmd = nil
+	}
+}
+
+func (pa *path) sourceSetReady(tracks gortsplib.Tracks, sdpAttributes []psdp.Attribute, metadata map[string]string) {
 	pa.sourceReady = true
-	pa.stream = newStream(tracks)
+	pa.stream = pa.newStream(tracks, sdpAttributes, metadata)
+	pa.startMPEGTSMulticast(tracks)
+	pa.startPushers(tracks)
+	pa.startRenditions()
+	pa.startAudioTranscode(tracks)
 
 	if pa.isOnDemand() {
 		pa.onDemandReadyTimer.Stop()
@@ -534,6 +896,7 @@ func (pa *path) sourceSetReady(tracks gortsplib.Tracks) {
 
 		for _, req := range pa.describeRequests {
 			req.Res <- pathDescribeRes{
+				Path:   pa,
 				Stream: pa.stream,
 			}
 		}
@@ -566,35 +929,164 @@ func (pa *path) sourceSetNotReady() {
 	}
 
 	pa.sourceReady = false
+	pa.suspended = false
+
+	pa.publishLimitTimer.Stop()
+	pa.publishLimitTimer = newEmptyTimer()
+	pa.updateIdleTimer()
+
+	pa.stopAudioTranscode()
+	pa.stopRenditions()
+	pa.stopPushers()
+	pa.stopMPEGTSMulticast()
 	pa.stream.close()
 	pa.stream = nil
 }
 
+// sourceURLs returns the URLs of a static source, that are used in rotation
+// or, when backups are set, in order of preference.
+func (pa *path) sourceURLs() []string {
+	ret := append([]string{pa.conf.Source}, pa.conf.SourcePool...)
+	ret = append(ret, pa.conf.SourceBackups...)
+
+	for i, ur := range ret {
+		ret[i] = pa.sourceURLWithQuery(ur)
+	}
+	return ret
+}
+
+// sourceURLWithQuery adds the query of the reader that started an on-demand
+// source to an URL of the source.
+func (pa *path) sourceURLWithQuery(ur string) string {
+	if pa.onDemandQuery == "" {
+		return ur
+	}
+
+	if strings.Contains(ur, "?") {
+		return ur + "&" + pa.onDemandQuery
+	}
+	return ur + "?" + pa.onDemandQuery
+}
+
+// sourceFailbackInterval returns the interval between probes of the primary URL
+// of a static source, or zero if the source has no backup URLs.
+func (pa *path) sourceFailbackInterval() time.Duration {
+	if len(pa.conf.SourceBackups) == 0 {
+		return 0
+	}
+	return pa.conf.SourceFailbackInterval
+}
+
+// sourceLocalIP returns the local IP used by static sources, or nil if unset.
+func (pa *path) sourceLocalIP() net.IP {
+	v := pa.conf.OutboundInterface
+	if v == "" {
+		v = pa.outboundInterface
+	}
+	if v == "" {
+		return nil
+	}
+
+	// interfaces can change after the configuration has been validated
+	ip, err := proxydialer.ResolveLocalIP(v)
+	if err != nil {
+		pa.Log(logger.Warn, "unable to use outbound interface: %s", err)
+		return nil
+	}
+	return ip
+}
+
+// sourceDialContext returns the function used by static sources to connect
+// to servers, that takes into account the outbound proxy and interface.
+func (pa *path) sourceDialContext(localIP net.IP) proxydialer.DialContextFunc {
+	proxyURL := pa.conf.ResolvedOutboundProxy()
+	if proxyURL == "" {
+		proxyURL = pa.outboundProxy
+	}
+
+	// the URL has already been validated by the configuration
+	dialContext, _ := proxydialer.New(proxyURL, localIP)
+	return dialContext
+}
+
+// sourceProbe checks whether a source URL is available.
+func (pa *path) sourceProbe(ctx context.Context, ur string) error {
+	dialContext := pa.sourceDialContext(pa.sourceLocalIP())
+
+	if strings.HasPrefix(ur, "rtmp://") || strings.HasPrefix(ur, "rtmps://") {
+		return rtmpSourceProbe(ctx, ur, pa.conf.SourceFingerprint, pa.readTimeout, pa.writeTimeout, dialContext)
+	}
+	return rtspSourceProbe(ctx, ur, pa.conf.SourceFingerprint, pa.readTimeout, pa.writeTimeout, dialContext)
+}
+
 func (pa *path) staticSourceCreate() {
 	if strings.HasPrefix(pa.conf.Source, "rtsp://") ||
 		strings.HasPrefix(pa.conf.Source, "rtsps://") {
+		proto := pa.conf.SourceProtocolParsed
+
+		// proxies can only forward TCP connections
+		if pa.conf.ResolvedOutboundProxy() != "" || pa.outboundProxy != "" {
+			v := gortsplib.ClientProtocolTCP
+			proto = &v
+		}
+
+		localIP := pa.sourceLocalIP()
+
 		pa.source = newRTSPSource(
 			pa.ctx,
-			pa.conf.Source,
-			pa.conf.SourceProtocolParsed,
+			pa.sourceURLs(),
+			pa.sourceFailbackInterval(),
+			proto,
 			pa.conf.SourceAnyPortEnable,
 			pa.conf.SourceFingerprint,
 			pa.readTimeout,
 			pa.writeTimeout,
+			pa.conf.SourceRetryPause,
+			pa.conf.SourceRetryMaxPause,
 			pa.readBufferCount,
 			pa.readBufferSize,
+			localIP,
+			pa.sourcePortRange,
+			pa.sourceDialContext(localIP),
 			&pa.sourceStaticWg,
 			pa.stats,
 			pa)
-	} else if strings.HasPrefix(pa.conf.Source, "rtmp://") {
+	} else if strings.HasPrefix(pa.conf.Source, "rtmp://") ||
+		strings.HasPrefix(pa.conf.Source, "rtmps://") {
 		pa.source = newRTMPSource(
 			pa.ctx,
-			pa.conf.Source,
+			pa.sourceURLs(),
+			pa.sourceFailbackInterval(),
+			pa.conf.SourceFingerprint,
 			pa.readTimeout,
 			pa.writeTimeout,
+			pa.conf.SourceRetryPause,
+			pa.conf.SourceRetryMaxPause,
+			pa.sourceDialContext(pa.sourceLocalIP()),
+			&pa.sourceStaticWg,
+			pa.stats,
+			pa)
+	} else if strings.HasPrefix(pa.conf.Source, "http://") ||
+		strings.HasPrefix(pa.conf.Source, "https://") {
+		pa.source = newHLSSource(
+			pa.ctx,
+			pa.sourceURLWithQuery(pa.conf.Source),
+			pa.readTimeout,
+			pa.conf.SourceRetryPause,
+			pa.conf.SourceRetryMaxPause,
+			pa.sourceDialContext(pa.sourceLocalIP()),
 			&pa.sourceStaticWg,
 			pa.stats,
 			pa)
+	} else if pa.conf.Source == "testpattern" {
+		pa.source = newTestPatternSource(
+			pa.ctx,
+			pa.conf.TestPatternWidth,
+			pa.conf.TestPatternHeight,
+			pa.conf.TestPatternFPS,
+			pa.conf.TestPatternBitrate,
+			&pa.sourceStaticWg,
+			pa)
 	}
 }
 
@@ -610,6 +1102,14 @@ func (pa *path) doReaderRemove(r reader) {
 }
 
 func (pa *path) doPublisherRemove() {
+	// the publisher has been assigned to the path during the grace period,
+	// but has never started publishing.
+	if pa.graceTimerRunning {
+		pa.source = nil
+		pa.promoteStandbyPublisher()
+		return
+	}
+
 	if pa.sourceReady {
 		atomic.AddInt64(pa.stats.CountPublishers, -1)
 
@@ -626,18 +1126,150 @@ func (pa *path) doPublisherRemove() {
 		pa.doReaderRemove(r)
 		r.Close()
 	}
+
+	pa.promoteStandbyPublisher()
+}
+
+// promoteStandbyPublisher assigns the path to the first publisher that is
+// waiting in standby, if any.
+func (pa *path) promoteStandbyPublisher() {
+	if len(pa.standbyPublishers) == 0 {
+		return
+	}
+
+	sp := pa.standbyPublishers[0]
+	pa.standbyPublishers = pa.standbyPublishers[1:]
+	pa.updateStandbyTimer()
+
+	pa.Log(logger.Info, "standby publisher is taking over")
+	pa.source = sp.req.Author
+	sp.req.Res <- pathPublisherAnnounceRes{Path: pa}
+}
+
+// expireStandbyPublishers rejects publishers that have been waiting in standby
+// for too long.
+func (pa *path) expireStandbyPublishers() {
+	now := time.Now()
+	n := 0
+	for _, sp := range pa.standbyPublishers {
+		if !now.Before(sp.deadline) {
+			sp.req.Res <- pathPublisherAnnounceRes{
+				Err: fmt.Errorf("timed out while waiting for the publisher of path '%s' to disconnect", pa.name),
+			}
+		} else {
+			pa.standbyPublishers[n] = sp
+			n++
+		}
+	}
+	pa.standbyPublishers = pa.standbyPublishers[:n]
+	pa.updateStandbyTimer()
+}
+
+// updateStandbyTimer sets the standby timer to the deadline of the publisher
+// that has been waiting for the longest time.
+func (pa *path) updateStandbyTimer() {
+	pa.standbyTimer.Stop()
+	if len(pa.standbyPublishers) == 0 {
+		pa.standbyTimer = newEmptyTimer()
+	} else {
+		pa.standbyTimer = time.NewTimer(time.Until(pa.standbyPublishers[0].deadline))
+	}
+}
+
+func (pa *path) handleSourceTracksUpdate(req pathSourceTracksUpdateReq) {
+	if pa.source != req.Source || !pa.sourceReady {
+		req.Res <- pathSourceTracksUpdateRes{Err: fmt.Errorf("source is not ready")}
+		return
+	}
+
+	// late tracks must satisfy the same restrictions of the initial ones.
+	if pa.conf.PublishCodecs != nil {
+		err := pa.checkCodecs(req.Tracks)
+		if err != nil {
+			req.Res <- pathSourceTracksUpdateRes{Err: err}
+			return
+		}
+	}
+
+	// readers are closed, in order to make them read the new tracks.
+	// the state of on-demand sources and commands is preserved.
+	for r := range pa.readers {
+		pa.doReaderRemove(r)
+		r.Close()
+	}
+
+	pa.stopPushers()
+	pa.stopMPEGTSMulticast()
+	pa.stream.close()
+	pa.stream = pa.newStream(req.Tracks, pa.stream.sdpAttributes, pa.stream.metadata)
+	pa.stream.setSuspended(pa.suspended)
+	pa.startMPEGTSMulticast(req.Tracks)
+	pa.startPushers(req.Tracks)
+	pa.updateIdleTimer()
+
+	pa.parent.OnPathSourceReady(pa)
+
+	req.Res <- pathSourceTracksUpdateRes{Stream: pa.stream}
+}
+
+func (pa *path) handleSourceSuspend(req pathSourceSuspendReq) {
+	if req.Author != nil && req.Author != pa.source {
+		req.Res <- pathSourceSuspendRes{Err: fmt.Errorf("not the source of the path")}
+		return
+	}
+
+	if !pa.sourceReady {
+		req.Res <- pathSourceSuspendRes{Err: pathErrNoOnePublishing{PathName: pa.name}}
+		return
+	}
+
+	if req.Suspended != pa.suspended {
+		pa.suspended = req.Suspended
+		pa.stream.setSuspended(req.Suspended)
+
+		if req.Suspended {
+			pa.Log(logger.Info, "stream paused")
+
+			if pa.conf.PauseBehaviorParsed == conf.PauseBehaviorClose {
+				for r := range pa.readers {
+					pa.doReaderRemove(r)
+					r.Close()
+				}
+			}
+		} else {
+			pa.Log(logger.Info, "stream resumed")
+		}
+	}
+
+	req.Res <- pathSourceSuspendRes{}
 }
 
 func (pa *path) handleDescribe(req pathDescribeReq) {
 	if _, ok := pa.source.(*sourceRedirect); ok {
 		req.Res <- pathDescribeRes{
-			Redirect: pa.conf.SourceRedirect,
+			Redirect:     pa.nextRedirectURL(),
+			RedirectHook: pa.conf.SourceRedirectHook,
+		}
+		return
+	}
+
+	if _, ok := pa.source.(*sourceVOD); ok {
+		fpath, err := pa.vodFile()
+		if err != nil {
+			req.Res <- pathDescribeRes{Err: err}
+			return
+		}
+
+		req.Res <- pathDescribeRes{
+			Path:    pa,
+			VODFile: fpath,
 		}
 		return
 	}
 
 	if pa.sourceReady {
 		req.Res <- pathDescribeRes{
+			Path:   pa,
 			Stream: pa.stream,
 		}
 		return
@@ -645,8 +1277,14 @@ func (pa *path) handleDescribe(req pathDescribeReq) {
 
 	if pa.isOnDemand() {
 		if pa.onDemandState == pathOnDemandStateInitial {
-			pa.onDemandStartSource()
+			pa.onDemandStartSource(req.Query)
+		}
+
+		if pa.conf.OnDemandRetryAfter != 0 {
+			req.Res <- pathDescribeRes{Err: pathErrNotReady{PathName: pa.name, RetryAfter: pa.conf.OnDemandRetryAfter}}
+			return
 		}
+
 		pa.describeRequests = append(pa.describeRequests, req)
 		return
 	}
@@ -671,23 +1309,98 @@ func (pa *path) handleDescribe(req pathDescribeReq) {
 	req.Res <- pathDescribeRes{Err: pathErrNoOnePublishing{PathName: pa.name}}
 }
 
+// nextRedirectURL picks the URL which the next reader is redirected to,
+// rotating between the available ones.
+func (pa *path) nextRedirectURL() string {
+	if pa.conf.SourceRedirect == "" {
+		return ""
+	}
+
+	urs := append([]string{pa.conf.SourceRedirect}, pa.conf.SourceRedirectPool...)
+	ur := urs[pa.redirectIdx%len(urs)]
+	pa.redirectIdx = (pa.redirectIdx + 1) % len(urs)
+
+	return strings.ReplaceAll(ur, "$RTSP_PATH", pa.name)
+}
+
+// vodFile returns the file served by a path with the "vod" source.
+func (pa *path) vodFile() (string, error) {
+	// the path name can be inserted into the file path, therefore
+	// it must not allow to exit from the intended directory.
+	if strings.Contains("/"+pa.name+"/", "/../") {
+		return "", fmt.Errorf("invalid path name '%s'", pa.name)
+	}
+
+	return strings.ReplaceAll(pa.conf.SourceFile, "$RTSP_PATH", pa.name), nil
+}
+
 func (pa *path) handlePublisherRemove(req pathPublisherRemoveReq) {
 	if pa.source == req.Author {
-		pa.doPublisherRemove()
+		if pa.conf.PublisherGracePeriod != 0 && pa.sourceReady && !pa.graceTimerRunning {
+			pa.startGracePeriod()
+		} else {
+			pa.doPublisherRemove()
+		}
 	}
 	close(req.Res)
 }
 
+// startGracePeriod detaches the publisher from the path, while keeping
+// the stream and its readers, in order to wait for the publisher to come back.
+func (pa *path) startGracePeriod() {
+	pa.Log(logger.Info, "publisher disconnected, waiting %v for it to come back", pa.conf.PublisherGracePeriod)
+
+	atomic.AddInt64(pa.stats.CountPublishers, -1)
+	pa.source = nil
+
+	pa.publishLimitTimer.Stop()
+	pa.publishLimitTimer = newEmptyTimer()
+	pa.updateIdleTimer()
+
+	pa.graceTimer.Stop()
+	pa.graceTimer = time.NewTimer(pa.conf.PublisherGracePeriod)
+	pa.graceTimerRunning = true
+
+	pa.promoteStandbyPublisher()
+}
+
+// stopGracePeriod stops waiting for the publisher to come back.
+func (pa *path) stopGracePeriod() {
+	pa.graceTimer.Stop()
+	pa.graceTimer = newEmptyTimer()
+	pa.graceTimerRunning = false
+}
+
 func (pa *path) handlePublisherAnnounce(req pathPublisherAnnounceReq) {
+	if pa.conf.PublishCodecs != nil {
+		err := pa.checkCodecs(req.Tracks)
+		if err != nil {
+			req.Res <- pathPublisherAnnounceRes{Err: err}
+			return
+		}
+	}
+
 	if pa.source != nil {
-		if pa.hasStaticSource() {
+		if _, ok := pa.source.(publisher); !ok {
 			req.Res <- pathPublisherAnnounceRes{Err: fmt.Errorf("path '%s' is assigned to a static source", pa.name)}
 			return
 		}
 
-		if pa.conf.DisablePublisherOverride {
+		switch pa.conf.PublisherPolicyParsed {
+		case conf.PublisherPolicyReject:
 			req.Res <- pathPublisherAnnounceRes{Err: fmt.Errorf("another publisher is already publishing to path '%s'", pa.name)}
 			return
+
+		case conf.PublisherPolicyStandby:
+			pa.Log(logger.Info, "another publisher is waiting in standby")
+			pa.standbyPublishers = append(pa.standbyPublishers, pathStandbyPublisher{
+				req:      req,
+				deadline: time.Now().Add(pa.readTimeout),
+			})
+			if len(pa.standbyPublishers) == 1 {
+				pa.updateStandbyTimer()
+			}
+			return
 		}
 
 		pa.Log(logger.Info, "closing existing publisher")
@@ -700,17 +1413,43 @@ func (pa *path) handlePublisherAnnounce(req pathPublisherAnnounceReq) {
 	req.Res <- pathPublisherAnnounceRes{Path: pa}
 }
 
+func (pa *path) checkCodecs(tracks gortsplib.Tracks) error {
+outer:
+	for _, t := range tracks {
+		codec := trackCodec(t)
+		for _, allowed := range pa.conf.PublishCodecs {
+			if codec == allowed {
+				continue outer
+			}
+		}
+		return pathErrCodecNotAllowed{PathName: pa.name, Codec: codec}
+	}
+	return nil
+}
+
 func (pa *path) handlePublisherRecord(req pathPublisherRecordReq) {
 	if pa.source != req.Author {
 		req.Res <- pathPublisherRecordRes{Err: fmt.Errorf("publisher is not assigned to this path anymore")}
 		return
 	}
 
+	if pa.graceTimerRunning {
+		pa.stopGracePeriod()
+
+		if pa.stream.canSplice(req.Tracks) {
+			pa.resumePublisher(req)
+			return
+		}
+
+		pa.Log(logger.Info, "publisher is back with different tracks")
+		pa.sourceSetNotReady()
+	}
+
 	atomic.AddInt64(pa.stats.CountPublishers, 1)
 
 	req.Author.OnPublisherAccepted(len(req.Tracks))
 
-	pa.sourceSetReady(req.Tracks)
+	pa.sourceSetReady(req.Tracks, req.SDPAttributes, req.Metadata)
 
 	if pa.conf.RunOnPublish != "" {
 		_, port, _ := net.SplitHostPort(pa.rtspAddress)
@@ -720,11 +1459,86 @@ func (pa *path) handlePublisherRecord(req pathPublisherRecordReq) {
 		})
 	}
 
+	if pa.conf.MaxPublishDuration != 0 {
+		pa.publishLimitTimer.Stop()
+		pa.publishLimitTimer = time.NewTimer(pa.conf.MaxPublishDuration)
+	}
+
+	pa.updateIdleTimer()
+
+	req.Res <- pathPublisherRecordRes{Stream: pa.stream}
+}
+
+// resumePublisher appends the stream of a publisher that came back during
+// the grace period to the existing one.
+func (pa *path) resumePublisher(req pathPublisherRecordReq) {
+	pa.Log(logger.Info, "publisher is back")
+
+	atomic.AddInt64(pa.stats.CountPublishers, 1)
+
+	req.Author.OnPublisherAccepted(len(req.Tracks))
+
+	pa.stream.splice()
+
+	if pa.suspended {
+		pa.suspended = false
+		pa.stream.setSuspended(false)
+	}
+
+	if pa.conf.MaxPublishDuration != 0 {
+		pa.publishLimitTimer.Stop()
+		pa.publishLimitTimer = time.NewTimer(pa.conf.MaxPublishDuration)
+	}
+
+	pa.updateIdleTimer()
+
 	req.Res <- pathPublisherRecordRes{Stream: pa.stream}
 }
 
+// updateIdleTimer starts the idle timer when a publisher is publishing
+// and there are no readers, and stops it otherwise.
+func (pa *path) updateIdleTimer() {
+	_, isPublisher := pa.source.(publisher)
+	idle := pa.conf.IdleCloseAfter != 0 && isPublisher && pa.sourceReady && len(pa.readers) == 0
+
+	if idle == pa.idleTimerRunning {
+		return
+	}
+
+	pa.idleTimer.Stop()
+	if idle {
+		pa.idleTimer = time.NewTimer(pa.conf.IdleCloseAfter)
+	} else {
+		pa.idleTimer = newEmptyTimer()
+	}
+	pa.idleTimerRunning = idle
+}
+
+// closePublisherOnLimit closes the publisher when it reaches a limit,
+// and runs the runOnLimit command.
+func (pa *path) closePublisherOnLimit(limit string) {
+	pub, ok := pa.source.(publisher)
+	if !ok {
+		return
+	}
+
+	pa.Log(logger.Info, "closing publisher: %s reached", limit)
+
+	if pa.conf.RunOnLimit != "" {
+		_, port, _ := net.SplitHostPort(pa.rtspAddress)
+		externalcmd.RunOnce(pa.conf.RunOnLimit, externalcmd.Environment{
+			Path:   pa.name,
+			Port:   port,
+			Reason: limit,
+		})
+	}
+
+	pub.Close()
+	pa.doPublisherRemove()
+}
+
 func (pa *path) handlePublisherPause(req pathPublisherPauseReq) {
-	if req.Author == pa.source && pa.sourceReady {
+	if req.Author == pa.source && pa.sourceReady && !pa.graceTimerRunning {
 		atomic.AddInt64(pa.stats.CountPublishers, -1)
 
 		if pa.isOnDemand() && pa.onDemandState != pathOnDemandStateInitial {
@@ -742,6 +1556,8 @@ func (pa *path) handleReaderRemove(req pathReaderRemoveReq) {
 	}
 	close(req.Res)
 
+	pa.updateIdleTimer()
+
 	if pa.isOnDemand() &&
 		len(pa.readers) == 0 &&
 		pa.onDemandState == pathOnDemandStateReady {
@@ -750,6 +1566,27 @@ func (pa *path) handleReaderRemove(req pathReaderRemoveReq) {
 }
 
 func (pa *path) handleReaderSetupPlay(req pathReaderSetupPlayReq) {
+	if _, ok := pa.source.(*sourceVOD); ok {
+		// files are read by each session independently, therefore
+		// sessions are not added to readers.
+		if _, ok := req.Author.(pathRTSPSession); !ok {
+			req.Res <- pathReaderSetupPlayRes{Err: fmt.Errorf("path '%s' can be read only with RTSP", pa.name)}
+			return
+		}
+
+		fpath, err := pa.vodFile()
+		if err != nil {
+			req.Res <- pathReaderSetupPlayRes{Err: err}
+			return
+		}
+
+		req.Res <- pathReaderSetupPlayRes{
+			Path:    pa,
+			VODFile: fpath,
+		}
+		return
+	}
+
 	if pa.sourceReady {
 		pa.handleReaderSetupPlayPost(req)
 		return
@@ -757,8 +1594,14 @@ func (pa *path) handleReaderSetupPlay(req pathReaderSetupPlayReq) {
 
 	if pa.isOnDemand() {
 		if pa.onDemandState == pathOnDemandStateInitial {
-			pa.onDemandStartSource()
+			pa.onDemandStartSource(req.Query)
 		}
+
+		if pa.conf.OnDemandRetryAfter != 0 {
+			req.Res <- pathReaderSetupPlayRes{Err: pathErrNotReady{PathName: pa.name, RetryAfter: pa.conf.OnDemandRetryAfter}}
+			return
+		}
+
 		pa.setupPlayRequests = append(pa.setupPlayRequests, req)
 		return
 	}
@@ -768,6 +1611,7 @@ func (pa *path) handleReaderSetupPlay(req pathReaderSetupPlayReq) {
 
 func (pa *path) handleReaderSetupPlayPost(req pathReaderSetupPlayReq) {
 	pa.readers[req.Author] = pathReaderStatePrePlay
+	pa.updateIdleTimer()
 
 	if pa.isOnDemand() && pa.onDemandState == pathOnDemandStateClosing {
 		pa.onDemandState = pathOnDemandStateReady
@@ -789,9 +1633,34 @@ func (pa *path) handleReaderPlay(req pathReaderPlayReq) {
 
 	req.Author.OnReaderAccepted()
 
+	if pa.conf.KeyframeRequest {
+		pa.requestKeyframe()
+	}
+
 	close(req.Res)
 }
 
+// requestKeyframe asks the source to send a keyframe on every video track,
+// in order to shorten the time needed by a new reader to decode the stream.
+func (pa *path) requestKeyframe() {
+	kr, ok := pa.source.(sourceKeyframeRequester)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(pa.lastKeyframeReq) < pathKeyframeRequestMinPeriod {
+		return
+	}
+	pa.lastKeyframeReq = now
+
+	for i, t := range pa.stream.tracks() {
+		if t.Media.MediaName.Media == "video" {
+			kr.OnSourceKeyframeRequest(i, pa.stream.ssrc(i))
+		}
+	}
+}
+
 func (pa *path) handleReaderPause(req pathReaderPauseReq) {
 	if state, ok := pa.readers[req.Author]; ok && state == pathReaderStatePlay {
 		atomic.AddInt64(pa.stats.CountReaders, -1)
@@ -802,6 +1671,24 @@ func (pa *path) handleReaderPause(req pathReaderPauseReq) {
 }
 
 func (pa *path) handleAPIPathsList(req apiPathsListReq2) {
+	var malformedRTP, malformedRTCP int64
+	var latency map[string]apiPathsLatency
+	if pa.stream != nil {
+		malformedRTP = atomic.LoadInt64(pa.stream.malformedRTP)
+		malformedRTCP = atomic.LoadInt64(pa.stream.malformedRTCP)
+
+		if pa.stream.latency != nil {
+			latency = make(map[string]apiPathsLatency)
+			for protocol, p := range pa.stream.latency.percentiles() {
+				latency[protocol] = apiPathsLatency{
+					P50: durationMilliseconds(p.P50),
+					P90: durationMilliseconds(p.P90),
+					P99: durationMilliseconds(p.P99),
+				}
+			}
+		}
+	}
+
 	req.Data.Items[pa.name] = apiPathsItem{
 		ConfName: pa.confName,
 		Conf:     pa.conf,
@@ -812,6 +1699,26 @@ func (pa *path) handleAPIPathsList(req apiPathsListReq2) {
 			return pa.source.OnSourceAPIDescribe()
 		}(),
 		SourceReady: pa.sourceReady,
+		SourceHealth: func() []sourceHealthStatus {
+			if pa.healthCheck == nil {
+				return nil
+			}
+			return pa.healthCheck.status()
+		}(),
+		PushTargets: func() []rtmpPusherStatus {
+			var ret []rtmpPusherStatus
+			for _, p := range pa.pushers {
+				ret = append(ret, p.apiStatus())
+			}
+			return ret
+		}(),
+		Metadata: func() map[string]string {
+			if pa.stream == nil {
+				return nil
+			}
+			return pa.stream.metadata
+		}(),
+		Paused: pa.suspended,
 		Readers: func() []interface{} {
 			ret := []interface{}{}
 			for r := range pa.readers {
@@ -819,6 +1726,9 @@ func (pa *path) handleAPIPathsList(req apiPathsListReq2) {
 			}
 			return ret
 		}(),
+		MalformedRTPPackets:  malformedRTP,
+		MalformedRTCPPackets: malformedRTCP,
+		Latency:              latency,
 	}
 	close(req.Res)
 }
@@ -844,6 +1754,34 @@ func (pa *path) OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq) {
 	}
 }
 
+// OnSourceStaticSwitch is called by a sourceStatic when it switches between
+// the primary URL and the backup URLs.
+func (pa *path) OnSourceStaticSwitch(req pathSourceStaticSwitchReq) {
+	// the command depends on the configuration only,
+	// therefore it can be started outside of the path goroutine.
+	if pa.conf.RunOnSourceSwitch != "" {
+		_, port, _ := net.SplitHostPort(pa.rtspAddress)
+		externalcmd.RunOnce(pa.conf.RunOnSourceSwitch, externalcmd.Environment{
+			Path:      pa.name,
+			Port:      port,
+			Reason:    req.Reason,
+			SourceURL: req.URL,
+		})
+	}
+}
+
+// OnSourceTracksUpdate is called by a source when tracks are added after the
+// source is ready.
+func (pa *path) OnSourceTracksUpdate(req pathSourceTracksUpdateReq) pathSourceTracksUpdateRes {
+	req.Res = make(chan pathSourceTracksUpdateRes)
+	select {
+	case pa.sourceTracksUpdate <- req:
+		return <-req.Res
+	case <-pa.ctx.Done():
+		return pathSourceTracksUpdateRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
 // OnDescribe is called by a reader or publisher through pathManager.
 func (pa *path) OnDescribe(req pathDescribeReq) pathDescribeRes {
 	select {
@@ -885,6 +1823,17 @@ func (pa *path) OnPublisherRecord(req pathPublisherRecordReq) pathPublisherRecor
 	}
 }
 
+// OnSourceSuspend is called by a source or by the API.
+func (pa *path) OnSourceSuspend(req pathSourceSuspendReq) pathSourceSuspendRes {
+	req.Res = make(chan pathSourceSuspendRes)
+	select {
+	case pa.sourceSuspend <- req:
+		return <-req.Res
+	case <-pa.ctx.Done():
+		return pathSourceSuspendRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
 // OnPublisherPause is called by a publisher.
 func (pa *path) OnPublisherPause(req pathPublisherPauseReq) {
 	req.Res = make(chan struct{})
@@ -17,20 +17,33 @@ type pathManagerHLSServer interface {
 	OnPathSourceReady(pa *path)
 }
 
+type pathConfGetRes struct {
+	Conf *conf.PathConf
+	Err  error
+}
+
+type pathConfGetReq struct {
+	PathName string
+	Res      chan pathConfGetRes
+}
+
 type pathManagerParent interface {
 	Log(logger.Level, string, ...interface{})
 }
 
 type pathManager struct {
-	rtspAddress     string
-	readTimeout     time.Duration
-	writeTimeout    time.Duration
-	readBufferCount int
-	readBufferSize  int
-	pathConfs       map[string]*conf.PathConf
-	stats           *stats
-	metrics         *metrics
-	parent          pathManagerParent
+	rtspAddress       string
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	readBufferCount   int
+	readBufferSize    int
+	outboundProxy     string
+	outboundInterface string
+	sourcePortRange   *conf.PortRange
+	pathConfs         map[string]*conf.PathConf
+	stats             *stats
+	metrics           *metrics
+	parent            pathManagerParent
 
 	ctx       context.Context
 	ctxCancel func()
@@ -45,8 +58,10 @@ type pathManager struct {
 	describe          chan pathDescribeReq
 	readerSetupPlay   chan pathReaderSetupPlayReq
 	publisherAnnounce chan pathPublisherAnnounceReq
+	confGet           chan pathConfGetReq
 	hlsServerSet      chan pathManagerHLSServer
 	apiPathsList      chan apiPathsListReq1
+	apiPathsPause     chan apiPathsPauseReq
 }
 
 func newPathManager(
@@ -56,6 +71,9 @@ func newPathManager(
 	writeTimeout time.Duration,
 	readBufferCount int,
 	readBufferSize int,
+	outboundProxy string,
+	outboundInterface string,
+	sourcePortRange *conf.PortRange,
 	pathConfs map[string]*conf.PathConf,
 	stats *stats,
 	metrics *metrics,
@@ -68,6 +86,9 @@ func newPathManager(
 		writeTimeout:      writeTimeout,
 		readBufferCount:   readBufferCount,
 		readBufferSize:    readBufferSize,
+		outboundProxy:     outboundProxy,
+		outboundInterface: outboundInterface,
+		sourcePortRange:   sourcePortRange,
 		pathConfs:         pathConfs,
 		stats:             stats,
 		metrics:           metrics,
@@ -81,8 +102,10 @@ func newPathManager(
 		describe:          make(chan pathDescribeReq),
 		readerSetupPlay:   make(chan pathReaderSetupPlayReq),
 		publisherAnnounce: make(chan pathPublisherAnnounceReq),
+		confGet:           make(chan pathConfGetReq),
 		hlsServerSet:      make(chan pathManagerHLSServer),
 		apiPathsList:      make(chan apiPathsListReq1),
+		apiPathsPause:     make(chan apiPathsPauseReq),
 	}
 
 	for pathName, pathConf := range pm.pathConfs {
@@ -176,11 +199,13 @@ outer:
 
 			err = pm.authenticate(
 				req.IP,
+				req.ClientCN,
 				req.ValidateCredentials,
 				req.PathName,
 				pathConf.ReadIPsParsed,
-				pathConf.ReadUser,
-				pathConf.ReadPass,
+				pathConf.ReadClientCNs,
+				pathConf.ResolvedReadUser(),
+				pathConf.ResolvedReadPass(),
 			)
 			if err != nil {
 				req.Res <- pathDescribeRes{Err: err}
@@ -203,11 +228,13 @@ outer:
 
 			err = pm.authenticate(
 				req.IP,
+				req.ClientCN,
 				req.ValidateCredentials,
 				req.PathName,
 				pathConf.ReadIPsParsed,
-				pathConf.ReadUser,
-				pathConf.ReadPass,
+				pathConf.ReadClientCNs,
+				pathConf.ResolvedReadUser(),
+				pathConf.ResolvedReadPass(),
 			)
 			if err != nil {
 				req.Res <- pathReaderSetupPlayRes{Err: err}
@@ -230,11 +257,13 @@ outer:
 
 			err = pm.authenticate(
 				req.IP,
+				req.ClientCN,
 				req.ValidateCredentials,
 				req.PathName,
 				pathConf.PublishIPsParsed,
-				pathConf.PublishUser,
-				pathConf.PublishPass,
+				pathConf.PublishClientCNs,
+				pathConf.ResolvedPublishUser(),
+				pathConf.ResolvedPublishPass(),
 			)
 			if err != nil {
 				req.Res <- pathPublisherAnnounceRes{Err: err}
@@ -248,6 +277,10 @@ outer:
 
 			req.Res <- pathPublisherAnnounceRes{Path: pm.paths[req.PathName]}
 
+		case req := <-pm.confGet:
+			_, pathConf, err := pm.findPathConf(req.PathName)
+			req.Res <- pathConfGetRes{Conf: pathConf, Err: err}
+
 		case s := <-pm.hlsServerSet:
 			pm.hlsServer = s
 
@@ -262,6 +295,15 @@ outer:
 				Paths: paths,
 			}
 
+		case req := <-pm.apiPathsPause:
+			pa, ok := pm.paths[req.Name]
+			if !ok {
+				req.Res <- apiPathsPauseRes{Err: fmt.Errorf("path not found")}
+				continue
+			}
+
+			req.Res <- apiPathsPauseRes{Path: pa}
+
 		case <-pm.ctx.Done():
 			break outer
 		}
@@ -282,6 +324,9 @@ func (pm *pathManager) createPath(confName string, conf *conf.PathConf, name str
 		pm.writeTimeout,
 		pm.readBufferCount,
 		pm.readBufferSize,
+		pm.outboundProxy,
+		pm.outboundInterface,
+		pm.sourcePortRange,
 		confName,
 		conf,
 		name,
@@ -313,15 +358,18 @@ func (pm *pathManager) findPathConf(name string) (string, *conf.PathConf, error)
 
 func (pm *pathManager) authenticate(
 	ip net.IP,
+	clientCN string,
 	validateCredentials func(pathUser string, pathPass string) error,
 	pathName string,
 	pathIPs []interface{},
+	pathCNs []string,
 	pathUser string,
 	pathPass string,
 ) error {
 	// validate ip
 	if pathIPs != nil && ip != nil {
 		if !ipEqualOrInRange(ip, pathIPs) {
+			pm.stats.IPs.onAuthFailure(ip)
 			return pathErrAuthCritical{
 				Message: fmt.Sprintf("IP '%s' not allowed", ip),
 				Response: &base.Response{
@@ -331,10 +379,38 @@ func (pm *pathManager) authenticate(
 		}
 	}
 
+	// validate client certificate
+	if pathCNs != nil && validateCredentials != nil {
+		allowed := false
+		if clientCN != "" {
+			for _, cn := range pathCNs {
+				if cn == clientCN {
+					allowed = true
+					break
+				}
+			}
+		}
+
+		if !allowed {
+			if ip != nil {
+				pm.stats.IPs.onAuthFailure(ip)
+			}
+			return pathErrAuthCritical{
+				Message: fmt.Sprintf("certificate CN '%s' not allowed", clientCN),
+				Response: &base.Response{
+					StatusCode: base.StatusUnauthorized,
+				},
+			}
+		}
+	}
+
 	// validate user
 	if pathUser != "" && validateCredentials != nil {
 		err := validateCredentials(pathUser, pathPass)
 		if err != nil {
+			if _, ok := err.(pathErrAuthCritical); ok && ip != nil {
+				pm.stats.IPs.onAuthFailure(ip)
+			}
 			return err
 		}
 	}
@@ -417,6 +493,18 @@ func (pm *pathManager) OnReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderS
 	}
 }
 
+// OnConfGet is called by hlsRemuxer.
+func (pm *pathManager) OnConfGet(req pathConfGetReq) pathConfGetRes {
+	req.Res = make(chan pathConfGetRes)
+	select {
+	case pm.confGet <- req:
+		return <-req.Res
+
+	case <-pm.ctx.Done():
+		return pathConfGetRes{Err: fmt.Errorf("terminated")}
+	}
+}
+
 // OnHLSServerSet is called by hlsServer.
 func (pm *pathManager) OnHLSServerSet(s pathManagerHLSServer) {
 	select {
@@ -446,3 +534,23 @@ func (pm *pathManager) OnAPIPathsList(req apiPathsListReq1) apiPathsListRes1 {
 		return apiPathsListRes1{Err: fmt.Errorf("terminated")}
 	}
 }
+
+// OnAPIPathsPause is called by api.
+func (pm *pathManager) OnAPIPathsPause(req apiPathsPauseReq) apiPathsPauseRes {
+	req.Res = make(chan apiPathsPauseRes)
+	select {
+	case pm.apiPathsPause <- req:
+		res := <-req.Res
+		if res.Err != nil {
+			return res
+		}
+
+		// the path is called outside of the run loop, like in OnAPIPathsList.
+		sres := res.Path.OnSourceSuspend(pathSourceSuspendReq{Suspended: req.Paused})
+		res.Err = sres.Err
+		return res
+
+	case <-pm.ctx.Done():
+		return apiPathsPauseRes{Err: fmt.Errorf("terminated")}
+	}
+}
@@ -1,14 +1,20 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aler9/gortsplib"
@@ -18,6 +24,7 @@ import (
 	"github.com/notedit/rtmp/av"
 	"github.com/pion/rtp"
 
+	"github.com/aler9/rtsp-simple-server/internal/credential"
 	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
 	"github.com/aler9/rtsp-simple-server/internal/h264"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
@@ -42,9 +49,31 @@ func pathNameAndQuery(inURL *url.URL) (string, url.Values) {
 	return pathName, ur.Query()
 }
 
+// rtmpConnTrackSelection parses the 'tracks' query parameter, that contains
+// the IDs of the tracks that a reader wants to receive, separated by commas.
+// It returns nil when all tracks are selected.
+func rtmpConnTrackSelection(query url.Values, tracksLen int) (map[int]struct{}, error) {
+	v := query.Get("tracks")
+	if v == "" {
+		return nil, nil
+	}
+
+	ret := make(map[int]struct{})
+	for _, tmp := range strings.Split(v, ",") {
+		trackID, err := strconv.ParseInt(tmp, 10, 64)
+		if err != nil || trackID < 0 || int(trackID) >= tracksLen {
+			return nil, fmt.Errorf("invalid track ID: '%s'", tmp)
+		}
+		ret[int(trackID)] = struct{}{}
+	}
+
+	return ret, nil
+}
+
 type rtmpConnTrackIDPayloadPair struct {
 	trackID int
 	buf     []byte
+	ts      time.Time
 }
 
 type rtmpConnPathManager interface {
@@ -65,8 +94,12 @@ type rtmpConn struct {
 	readBufferCount     int
 	runOnConnect        string
 	runOnConnectRestart bool
+	streamKeyURL        string
+	noAuth              bool
 	wg                  *sync.WaitGroup
 	conn                *rtmp.Conn
+	stats               *stats
+	ipStats             *ipStatsEntry
 	pathManager         rtmpConnPathManager
 	parent              rtmpConnParent
 
@@ -74,6 +107,8 @@ type rtmpConn struct {
 	ctxCancel  func()
 	path       *path
 	ringBuffer *ringbuffer.RingBuffer // read
+	tracks     map[int]struct{}       // read
+	latency    *latencyProbe          // read
 	state      gortsplib.ServerSessionState
 	stateMutex sync.Mutex
 }
@@ -87,7 +122,10 @@ func newRTMPConn(
 	readBufferCount int,
 	runOnConnect string,
 	runOnConnectRestart bool,
+	streamKeyURL string,
+	noAuth bool,
 	wg *sync.WaitGroup,
+	stats *stats,
 	nconn net.Conn,
 	pathManager rtmpConnPathManager,
 	parent rtmpConnParent) *rtmpConn {
@@ -101,8 +139,12 @@ func newRTMPConn(
 		readBufferCount:     readBufferCount,
 		runOnConnect:        runOnConnect,
 		runOnConnectRestart: runOnConnectRestart,
+		streamKeyURL:        streamKeyURL,
+		noAuth:              noAuth,
 		wg:                  wg,
 		conn:                rtmp.NewServerConn(nconn),
+		stats:               stats,
+		ipStats:             stats.IPs.onConn(nconn.RemoteAddr().(*net.TCPAddr).IP),
 		pathManager:         pathManager,
 		parent:              parent,
 		ctx:                 ctx,
@@ -140,6 +182,22 @@ func (c *rtmpConn) ip() net.IP {
 	return c.conn.NetConn().RemoteAddr().(*net.TCPAddr).IP
 }
 
+// clientCN returns the common name of the certificate provided by a RTMPS
+// client, if it has been verified.
+func (c *rtmpConn) clientCN() string {
+	tc, ok := c.conn.NetConn().(*tls.Conn)
+	if !ok {
+		return ""
+	}
+
+	state := tc.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
 func (c *rtmpConn) safeState() gortsplib.ServerSessionState {
 	c.stateMutex.Lock()
 	defer c.stateMutex.Unlock()
@@ -180,6 +238,8 @@ func (c *rtmpConn) run() {
 
 	c.ctxCancel()
 
+	c.stats.IPs.release(c.ipStats)
+
 	c.parent.OnConnClose(c)
 }
 
@@ -205,13 +265,14 @@ func (c *rtmpConn) runInner(ctx context.Context) error {
 func (c *rtmpConn) runRead(ctx context.Context) error {
 	pathName, query := pathNameAndQuery(c.conn.URL())
 
+	user := ""
 	res := c.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
-		Author:   c,
-		PathName: pathName,
-		IP:       c.ip(),
-		ValidateCredentials: func(pathUser string, pathPass string) error {
-			return c.validateCredentials(pathUser, pathPass, query)
-		},
+		Author:              c,
+		PathName:            pathName,
+		Query:               query.Encode(),
+		IP:                  c.ip(),
+		ClientCN:            c.clientCN(),
+		ValidateCredentials: c.validateCredentialsFunc(query, &user),
 	})
 
 	if res.Err != nil {
@@ -224,6 +285,8 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 	}
 
 	c.path = res.Path
+	bandwidth := c.stats.Bandwidth.acquire(res.Path.Name(), user)
+	defer c.stats.Bandwidth.release(bandwidth)
 
 	defer func() {
 		c.path.OnReaderRemove(pathReaderRemoveReq{Author: c})
@@ -241,7 +304,19 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 	var audioClockRate int
 	var aacDecoder *rtpaac.Decoder
 
+	var err error
+	c.tracks, err = rtmpConnTrackSelection(query, len(res.Stream.tracks()))
+	if err != nil {
+		return err
+	}
+
 	for i, t := range res.Stream.tracks() {
+		if c.tracks != nil {
+			if _, ok := c.tracks[i]; !ok {
+				continue
+			}
+		}
+
 		if t.IsH264() {
 			if videoTrack != nil {
 				return fmt.Errorf("can't read track %d with RTMP: too many tracks", i+1)
@@ -268,9 +343,11 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 	}
 
 	c.conn.NetConn().SetWriteDeadline(time.Now().Add(c.writeTimeout))
-	c.conn.WriteMetadata(videoTrack, audioTrack)
+	pathConf := c.path.Conf()
+	c.conn.WriteMetadata(videoTrack, audioTrack, pathConf.Title, pathConf.Description)
 
 	c.ringBuffer = ringbuffer.New(uint64(c.readBufferCount))
+	c.latency = res.Stream.latency
 
 	go func() {
 		<-ctx.Done()
@@ -340,6 +417,12 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 				if err != nil {
 					return err
 				}
+				atomic.AddInt64(c.ipStats.BytesSent, int64(len(data)))
+				atomic.AddInt64(bandwidth.BytesSent, int64(len(data)))
+
+				if c.latency != nil {
+					c.latency.add("rtmp", time.Since(pair.ts))
+				}
 
 				videoBuf = nil
 			}
@@ -372,6 +455,12 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 				if err != nil {
 					return err
 				}
+				atomic.AddInt64(c.ipStats.BytesSent, int64(len(au)))
+				atomic.AddInt64(bandwidth.BytesSent, int64(len(au)))
+			}
+
+			if c.latency != nil {
+				c.latency.add("rtmp", time.Since(pair.ts))
 			}
 		}
 	}
@@ -384,34 +473,33 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 		return err
 	}
 
-	var tracks gortsplib.Tracks
-	videoTrackID := -1
-	audioTrackID := -1
+	rtracks := newRTMPTracks(videoTrack, audioTrack)
+	tracks := rtracks.tracks
 
-	var h264Encoder *rtph264.Encoder
-	if videoTrack != nil {
-		h264Encoder = rtph264.NewEncoder(96, nil, nil, nil)
-		videoTrackID = len(tracks)
-		tracks = append(tracks, videoTrack)
-	}
+	pathName, query := pathNameAndQuery(c.conn.URL())
 
-	var aacEncoder *rtpaac.Encoder
-	if audioTrack != nil {
-		clockRate, _ := audioTrack.ClockRate()
-		aacEncoder = rtpaac.NewEncoder(96, clockRate, nil, nil, nil)
-		audioTrackID = len(tracks)
-		tracks = append(tracks, audioTrack)
-	}
+	if c.streamKeyURL != "" && !c.noAuth {
+		err := c.validateStreamKey(pathName, query)
+		if err != nil {
+			if terr, ok := err.(pathErrAuthCritical); ok {
+				c.stats.IPs.onAuthFailure(c.ip())
 
-	pathName, query := pathNameAndQuery(c.conn.URL())
+				// wait some seconds to stop brute force attacks
+				<-time.After(rtmpConnPauseAfterAuthError)
+				return errors.New(terr.Message)
+			}
+			return err
+		}
+	}
 
+	user := ""
 	res := c.pathManager.OnPublisherAnnounce(pathPublisherAnnounceReq{
-		Author:   c,
-		PathName: pathName,
-		IP:       c.ip(),
-		ValidateCredentials: func(pathUser string, pathPass string) error {
-			return c.validateCredentials(pathUser, pathPass, query)
-		},
+		Author:              c,
+		PathName:            pathName,
+		Tracks:              tracks,
+		IP:                  c.ip(),
+		ClientCN:            c.clientCN(),
+		ValidateCredentials: c.validateCredentialsFunc(query, &user),
 	})
 
 	if res.Err != nil {
@@ -424,6 +512,8 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 	}
 
 	c.path = res.Path
+	bandwidth := c.stats.Bandwidth.acquire(res.Path.Name(), user)
+	defer c.stats.Bandwidth.release(bandwidth)
 
 	defer func() {
 		c.path.OnPublisherRemove(pathPublisherRemoveReq{Author: c})
@@ -437,19 +527,23 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 	c.conn.NetConn().SetWriteDeadline(time.Time{})
 
 	rres := c.path.OnPublisherRecord(pathPublisherRecordReq{
-		Author: c,
-		Tracks: tracks,
+		Author:   c,
+		Tracks:   tracks,
+		Metadata: c.conn.Metadata(),
 	})
 	if rres.Err != nil {
 		return rres.Err
 	}
 
-	rtcpSenders := rtcpsenderset.New(tracks, rres.Stream.onFrame)
-	defer rtcpSenders.Close()
+	stream := rres.Stream
+	rtcpSenders := rtcpsenderset.New(tracks, stream.onFrame)
+	defer func() {
+		rtcpSenders.Close()
+	}()
 
 	onFrame := func(trackID int, payload []byte) {
 		rtcpSenders.OnFrame(trackID, gortsplib.StreamTypeRTP, payload)
-		rres.Stream.onFrame(trackID, gortsplib.StreamTypeRTP, payload)
+		stream.onFrame(trackID, gortsplib.StreamTypeRTP, payload)
 	}
 
 	for {
@@ -458,61 +552,61 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+		atomic.AddInt64(c.ipStats.BytesReceived, int64(len(pkt.Data)))
+		atomic.AddInt64(bandwidth.BytesReceived, int64(len(pkt.Data)))
 
-		switch pkt.Type {
-		case av.H264:
-			if videoTrack == nil {
-				return fmt.Errorf("ERR: received an H264 frame, but track is not set up")
-			}
-
-			nalus, err := h264.DecodeAVCC(pkt.Data)
+		if rtmp.IsVideoDecoderConfig(pkt.Type) || pkt.Type == av.AACDecoderConfig {
+			// a track that was not available when the stream started
+			added, err := rtracks.addLateTrack(pkt)
 			if err != nil {
 				return err
 			}
-
-			var outNALUs [][]byte
-
-			for _, nalu := range nalus {
-				// remove SPS, PPS and AUD, not needed by RTSP
-				typ := h264.NALUType(nalu[0] & 0x1F)
-				switch typ {
-				case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
-					continue
-				}
-
-				outNALUs = append(outNALUs, nalu)
-			}
-
-			if len(outNALUs) == 0 {
+			if !added {
 				continue
 			}
 
-			frames, err := h264Encoder.Encode(outNALUs, pkt.Time+pkt.CTime)
-			if err != nil {
-				return fmt.Errorf("ERR while encoding H264: %v", err)
-			}
+			tracks = rtracks.tracks
+			c.log(logger.Info, "a late track has been added, the stream now has %d tracks", len(tracks))
 
-			for _, frame := range frames {
-				onFrame(videoTrackID, frame)
+			ures := c.path.OnSourceTracksUpdate(pathSourceTracksUpdateReq{
+				Source: c,
+				Tracks: tracks,
+			})
+			if ures.Err != nil {
+				return ures.Err
 			}
 
-		case av.AAC:
-			if audioTrack == nil {
-				return fmt.Errorf("ERR: received an AAC frame, but track is not set up")
-			}
+			rtcpSenders.Close()
+			stream = ures.Stream
+			rtcpSenders = rtcpsenderset.New(tracks, stream.onFrame)
+			continue
+		}
 
-			frames, err := aacEncoder.Encode([][]byte{pkt.Data}, pkt.Time+pkt.CTime)
-			if err != nil {
-				return fmt.Errorf("ERR while encoding AAC: %v", err)
-			}
+		trackID, frames, err := rtracks.encode(pkt)
+		if err != nil {
+			return err
+		}
 
-			for _, frame := range frames {
-				onFrame(audioTrackID, frame)
-			}
+		for _, frame := range frames {
+			onFrame(trackID, frame)
+		}
+	}
+}
+
+// validateCredentialsFunc returns the function that checks the credentials
+// of the connection and fills user, or nil when the connection has been
+// accepted by a listener without authentication.
+func (c *rtmpConn) validateCredentialsFunc(query url.Values, user *string) func(string, string) error {
+	if c.noAuth {
+		return nil
+	}
 
-		default:
-			return fmt.Errorf("ERR: unexpected packet: %v", pkt.Type)
+	return func(pathUser string, pathPass string) error {
+		err := c.validateCredentials(pathUser, pathPass, query)
+		if err == nil {
+			*user = query.Get("user")
 		}
+		return err
 	}
 }
 
@@ -521,8 +615,8 @@ func (c *rtmpConn) validateCredentials(
 	pathPass string,
 	query url.Values,
 ) error {
-	if query.Get("user") != pathUser ||
-		query.Get("pass") != pathPass {
+	if !credential.Check(pathUser, query.Get("user")) ||
+		!credential.Check(pathPass, query.Get("pass")) {
 		return pathErrAuthCritical{
 			Message: "wrong username or password",
 		}
@@ -531,6 +625,46 @@ func (c *rtmpConn) validateCredentials(
 	return nil
 }
 
+// validateStreamKey asks the stream key validation endpoint whether
+// the connection is allowed to publish.
+// The stream key is the "key" query parameter or, if it is not set,
+// the last segment of the path.
+func (c *rtmpConn) validateStreamKey(pathName string, query url.Values) error {
+	key := query.Get("key")
+	if key == "" {
+		key = pathName[strings.LastIndex(pathName, "/")+1:]
+	}
+
+	byts, _ := json.Marshal(struct {
+		Key  string `json:"key"`
+		IP   string `json:"ip"`
+		Path string `json:"path"`
+	}{key, c.ip().String(), pathName})
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.readTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.streamKeyURL, bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to validate stream key: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return pathErrAuthCritical{
+			Message: fmt.Sprintf("stream key rejected (status code %d)", res.StatusCode),
+		}
+	}
+
+	return nil
+}
+
 // OnReaderAccepted implements reader.
 func (c *rtmpConn) OnReaderAccepted() {
 	c.log(logger.Info, "is reading from path '%s'", c.path.Name())
@@ -538,8 +672,21 @@ func (c *rtmpConn) OnReaderAccepted() {
 
 // OnReaderFrame implements reader.
 func (c *rtmpConn) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	// frames of tracks that were not selected are discarded here, in order
+	// not to fill the buffer
+	if c.tracks != nil {
+		if _, ok := c.tracks[trackID]; !ok {
+			return
+		}
+	}
+
 	if streamType == gortsplib.StreamTypeRTP {
-		c.ringBuffer.Push(rtmpConnTrackIDPayloadPair{trackID, payload})
+		var ts time.Time
+		if c.latency != nil {
+			ts = time.Now()
+		}
+
+		c.ringBuffer.Push(rtmpConnTrackIDPayloadPair{trackID, payload, ts})
 	}
 }
 
@@ -0,0 +1,24 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTMPConnTrackSelection(t *testing.T) {
+	tracks, err := rtmpConnTrackSelection(url.Values{}, 3)
+	require.NoError(t, err)
+	require.Nil(t, tracks)
+
+	tracks, err = rtmpConnTrackSelection(url.Values{"tracks": []string{"0,2"}}, 3)
+	require.NoError(t, err)
+	require.Equal(t, map[int]struct{}{0: {}, 2: {}}, tracks)
+
+	_, err = rtmpConnTrackSelection(url.Values{"tracks": []string{"3"}}, 3)
+	require.EqualError(t, err, "invalid track ID: '3'")
+
+	_, err = rtmpConnTrackSelection(url.Values{"tracks": []string{"video"}}, 3)
+	require.EqualError(t, err, "invalid track ID: 'video'")
+}
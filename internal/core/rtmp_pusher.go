@@ -0,0 +1,369 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/ringbuffer"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/notedit/rtmp/av"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/proxydialer"
+	"github.com/aler9/rtsp-simple-server/internal/rtmp"
+)
+
+const (
+	rtmpPusherRetryPause    = 2 * time.Second
+	rtmpPusherRetryMaxPause = 30 * time.Second
+)
+
+type rtmpPusherTrackIDPayloadPair struct {
+	trackID int
+	buf     []byte
+}
+
+type rtmpPusherParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// rtmpPusherStatus is the status of a push target.
+type rtmpPusherStatus struct {
+	URL       string     `json:"url"`
+	Connected bool       `json:"connected"`
+	Since     *time.Time `json:"since"`
+	BytesSent uint64     `json:"bytesSent"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// rtmpPusher is a reader that forwards a stream to an external RTMP server.
+// When the connection fails, it is established again after a pause,
+// independently from the path and from the other push targets.
+type rtmpPusher struct {
+	idx          int
+	ur           string
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	dialContext  proxydialer.DialContextFunc
+	title        string
+	description  string
+	parent       rtmpPusherParent
+
+	ctx            context.Context
+	ctxCancel      func()
+	ringBuffer     *ringbuffer.RingBuffer
+	retryBackoff   *sourceRetryBackoff
+	videoTrack     *gortsplib.Track
+	videoTrackID   int
+	audioTrack     *gortsplib.Track
+	audioTrackID   int
+	audioClockRate int
+	connected      int32 // frames are discarded when the pusher is not connected
+	done           chan struct{}
+
+	mutex  sync.Mutex
+	status rtmpPusherStatus
+}
+
+func newRTMPPusher(
+	parentCtx context.Context,
+	idx int,
+	ur string,
+	title string,
+	description string,
+	readTimeout time.Duration,
+	writeTimeout time.Duration,
+	readBufferCount int,
+	dialContext proxydialer.DialContextFunc,
+	tracks gortsplib.Tracks,
+	parent rtmpPusherParent) (*rtmpPusher, error) {
+	p := &rtmpPusher{
+		idx:          idx,
+		ur:           ur,
+		readTimeout:  readTimeout,
+		writeTimeout: writeTimeout,
+		title:        title,
+		description:  description,
+		parent:       parent,
+		retryBackoff: newSourceRetryBackoff(rtmpPusherRetryPause, rtmpPusherRetryMaxPause),
+		videoTrackID: -1,
+		audioTrackID: -1,
+		done:         make(chan struct{}),
+		status:       rtmpPusherStatus{URL: ur},
+	}
+
+	for i, t := range tracks {
+		if t.IsH264() {
+			if p.videoTrack != nil {
+				return nil, fmt.Errorf("can't send track %d with RTMP: too many tracks", i+1)
+			}
+			p.videoTrack = t
+			p.videoTrackID = i
+
+		} else if t.IsAAC() {
+			if p.audioTrack != nil {
+				return nil, fmt.Errorf("can't send track %d with RTMP: too many tracks", i+1)
+			}
+			p.audioTrack = t
+			p.audioTrackID = i
+			p.audioClockRate, _ = t.ClockRate()
+		}
+	}
+
+	if p.videoTrack == nil && p.audioTrack == nil {
+		return nil, fmt.Errorf("the stream doesn't contain an H264 track or an AAC track")
+	}
+
+	p.dialContext = rtmpDialContext(ur, "", dialContext)
+
+	p.ctx, p.ctxCancel = context.WithCancel(parentCtx)
+	p.ringBuffer = ringbuffer.New(uint64(readBufferCount))
+
+	go p.run()
+
+	return p, nil
+}
+
+func (p *rtmpPusher) log(level logger.Level, format string, args ...interface{}) {
+	p.parent.Log(level, "[push target %d] "+format, append([]interface{}{p.idx + 1}, args...)...)
+}
+
+func (p *rtmpPusher) close() {
+	p.ctxCancel()
+	<-p.done
+}
+
+func (p *rtmpPusher) run() {
+	defer close(p.done)
+
+	for {
+		err := p.runInner()
+		if p.ctx.Err() != nil {
+			return
+		}
+
+		p.log(logger.Warn, "ERR: %s", err)
+		p.setStatus(false, err)
+
+		select {
+		case <-time.After(p.retryBackoff.next()):
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *rtmpPusher) runInner() error {
+	p.log(logger.Debug, "connecting")
+
+	ctx2, cancel2 := context.WithTimeout(p.ctx, p.readTimeout)
+	conn, err := rtmp.DialContext(ctx2, p.ur, p.dialContext)
+	cancel2()
+	if err != nil {
+		return err
+	}
+
+	writerDone := make(chan error)
+	go func() {
+		writerDone <- p.runWriter(conn)
+	}()
+
+	select {
+	case err := <-writerDone:
+		atomic.StoreInt32(&p.connected, 0)
+		conn.NetConn().Close()
+		return err
+
+	case <-p.ctx.Done():
+		atomic.StoreInt32(&p.connected, 0)
+		conn.NetConn().Close()
+		p.ringBuffer.Close()
+		<-writerDone
+		return nil
+	}
+}
+
+func (p *rtmpPusher) runWriter(conn *rtmp.Conn) error {
+	conn.NetConn().SetReadDeadline(time.Now().Add(p.readTimeout))
+	conn.NetConn().SetWriteDeadline(time.Now().Add(p.writeTimeout))
+	err := conn.ClientHandshakePublish()
+	if err != nil {
+		return err
+	}
+
+	err = conn.WriteMetadata(p.videoTrack, p.audioTrack, p.title, p.description)
+	if err != nil {
+		return err
+	}
+
+	var h264Decoder *rtph264.Decoder
+	if p.videoTrack != nil {
+		h264Decoder = rtph264.NewDecoder()
+	}
+
+	var aacDecoder *rtpaac.Decoder
+	if p.audioTrack != nil {
+		aacDecoder = rtpaac.NewDecoder(p.audioClockRate)
+	}
+
+	p.log(logger.Info, "connected")
+	p.setStatus(true, nil)
+	p.retryBackoff.reset()
+	atomic.StoreInt32(&p.connected, 1)
+
+	var videoBuf [][]byte
+	videoIsKeyFrame := false
+	videoDTSEst := h264.NewDTSEstimator()
+
+	write := func(pkt av.Packet) error {
+		conn.NetConn().SetWriteDeadline(time.Now().Add(p.writeTimeout))
+		err := conn.WritePacket(pkt)
+		if err != nil {
+			return err
+		}
+
+		p.mutex.Lock()
+		p.status.BytesSent += uint64(len(pkt.Data))
+		p.mutex.Unlock()
+		return nil
+	}
+
+	for {
+		data, ok := p.ringBuffer.Pull()
+		if !ok {
+			return fmt.Errorf("terminated")
+		}
+		pair := data.(rtmpPusherTrackIDPayloadPair)
+
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(pair.buf)
+		if err != nil {
+			p.log(logger.Warn, "unable to decode RTP packet: %v", err)
+			continue
+		}
+
+		if pair.trackID == p.videoTrackID {
+			nalus, pts, err := h264Decoder.DecodeRTP(&pkt)
+			if err != nil {
+				if err != rtph264.ErrMorePacketsNeeded && err != rtph264.ErrNonStartingPacketAndNoPrevious {
+					p.log(logger.Warn, "unable to decode video track: %v", err)
+				}
+				continue
+			}
+
+			for _, nalu := range nalus {
+				// remove SPS, PPS and AUD, not needed by RTMP
+				typ := h264.NALUType(nalu[0] & 0x1F)
+				switch typ {
+				case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+					continue
+				}
+
+				if typ == h264.NALUTypeIDR {
+					videoIsKeyFrame = true
+				}
+
+				videoBuf = append(videoBuf, nalu)
+			}
+
+			// RTP marker means that all the NALUs with the same PTS have been received.
+			// send them together.
+			if !pkt.Marker || videoBuf == nil {
+				continue
+			}
+
+			data, err := h264.EncodeAVCC(videoBuf)
+			if err != nil {
+				return err
+			}
+
+			dts := videoDTSEst.Feed(pts + rtmpConnPTSOffset)
+			err = write(av.Packet{
+				Type:       av.H264,
+				Data:       data,
+				Time:       dts,
+				CTime:      pts + rtmpConnPTSOffset - dts,
+				IsKeyFrame: videoIsKeyFrame,
+			})
+			if err != nil {
+				return err
+			}
+
+			videoBuf = nil
+			videoIsKeyFrame = false
+
+		} else if pair.trackID == p.audioTrackID {
+			aus, pts, err := aacDecoder.DecodeRTP(&pkt)
+			if err != nil {
+				if err != rtpaac.ErrMorePacketsNeeded {
+					p.log(logger.Warn, "unable to decode audio track: %v", err)
+				}
+				continue
+			}
+
+			for i, au := range aus {
+				err := write(av.Packet{
+					Type: av.AAC,
+					Data: au,
+					Time: pts + rtmpConnPTSOffset + time.Duration(i)*1000*time.Second/time.Duration(p.audioClockRate),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (p *rtmpPusher) setStatus(connected bool, err error) {
+	now := time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if connected != p.status.Connected || p.status.Since == nil {
+		p.status.Since = &now
+	}
+	p.status.Connected = connected
+	p.status.Error = ""
+	if err != nil {
+		p.status.Error = err.Error()
+	}
+}
+
+// apiStatus returns the status of the push target.
+func (p *rtmpPusher) apiStatus() rtmpPusherStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.status
+}
+
+// Close implements reader.
+func (p *rtmpPusher) Close() {
+	p.ctxCancel()
+}
+
+// OnReaderAccepted implements reader.
+func (p *rtmpPusher) OnReaderAccepted() {
+}
+
+// OnReaderFrame implements reader.
+func (p *rtmpPusher) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType == gortsplib.StreamTypeRTP && atomic.LoadInt32(&p.connected) == 1 {
+		p.ringBuffer.Push(rtmpPusherTrackIDPayloadPair{trackID, payload})
+	}
+}
+
+// OnReaderAPIDescribe implements reader.
+func (p *rtmpPusher) OnReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"rtmpPusher"}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/notedit/rtmp/av"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/rtmp"
+)
+
+func TestRTMPPusher(t *testing.T) {
+	p, ok := newInstance("api: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  source:\n" +
+		"    pushTargets:\n" +
+		"      - rtmp://localhost:1935/dest\n" +
+		"      - rtmp://localhost:1999/dest\n" +
+		"  dest:\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1935/source", nil)
+	require.NoError(t, err)
+	defer conn.NetConn().Close()
+
+	err = conn.ClientHandshakePublish()
+	require.NoError(t, err)
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}, []byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	err = conn.WriteMetadata(videoTrack, nil, "", "")
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	data, err := h264.EncodeAVCC([][]byte{{0x05, 0x01}})
+	require.NoError(t, err)
+	err = conn.WritePacket(av.Packet{
+		Type: av.H264,
+		Data: data,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	c, err := gortsplib.DialRead("rtsp://localhost:8554/dest")
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, 1, len(c.Tracks()))
+
+	var out struct {
+		Items map[string]struct {
+			PushTargets []struct {
+				URL       string `json:"url"`
+				Connected bool   `json:"connected"`
+				Error     string `json:"error"`
+			} `json:"pushTargets"`
+		} `json:"items"`
+	}
+	err = httpRequest(http.MethodGet, "http://localhost:9997/v1/paths/list", nil, &out)
+	require.NoError(t, err)
+
+	targets := out.Items["source"].PushTargets
+	require.Equal(t, 2, len(targets))
+	require.Equal(t, "rtmp://localhost:1935/dest", targets[0].URL)
+	require.Equal(t, true, targets[0].Connected)
+	require.Equal(t, false, targets[1].Connected)
+	require.NotEqual(t, "", targets[1].Error)
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"net"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/aler9/gortsplib"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
@@ -19,6 +21,16 @@ type rtmpServerParent interface {
 	Log(logger.Level, string, ...interface{})
 }
 
+type rtmpServerListener struct {
+	l      net.Listener
+	noAuth bool
+}
+
+type rtmpServerNewConn struct {
+	nconn  net.Conn
+	noAuth bool
+}
+
 type rtmpServer struct {
 	readTimeout         time.Duration
 	writeTimeout        time.Duration
@@ -26,15 +38,18 @@ type rtmpServer struct {
 	rtspAddress         string
 	runOnConnect        string
 	runOnConnectRestart bool
+	streamKeyURL        string
+	stats               *stats
 	metrics             *metrics
 	pathManager         *pathManager
 	parent              rtmpServerParent
 
-	ctx       context.Context
-	ctxCancel func()
-	wg        sync.WaitGroup
-	l         net.Listener
-	conns     map[*rtmpConn]struct{}
+	ctx        context.Context
+	ctxCancel  func()
+	wg         sync.WaitGroup
+	ls         []rtmpServerListener
+	certLoader *certLoader
+	conns      map[*rtmpConn]struct{}
 
 	// in
 	connClose        chan *rtmpConn
@@ -45,20 +60,25 @@ type rtmpServer struct {
 func newRTMPServer(
 	parentCtx context.Context,
 	address string,
+	encryption conf.Encryption,
+	rtmpsAddress string,
+	serverCert string,
+	serverKey string,
+	clientCA string,
+	clientCertRequired bool,
+	acmeManager *acmeManager,
+	additionalAddresses []conf.RTMPListener,
 	readTimeout time.Duration,
 	writeTimeout time.Duration,
 	readBufferCount int,
 	rtspAddress string,
 	runOnConnect string,
 	runOnConnectRestart bool,
+	streamKeyURL string,
+	stats *stats,
 	metrics *metrics,
 	pathManager *pathManager,
 	parent rtmpServerParent) (*rtmpServer, error) {
-	l, err := net.Listen("tcp", address)
-	if err != nil {
-		return nil, err
-	}
-
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	s := &rtmpServer{
@@ -68,19 +88,26 @@ func newRTMPServer(
 		rtspAddress:         rtspAddress,
 		runOnConnect:        runOnConnect,
 		runOnConnectRestart: runOnConnectRestart,
+		streamKeyURL:        streamKeyURL,
+		stats:               stats,
 		metrics:             metrics,
 		pathManager:         pathManager,
 		parent:              parent,
 		ctx:                 ctx,
 		ctxCancel:           ctxCancel,
-		l:                   l,
 		conns:               make(map[*rtmpConn]struct{}),
 		connClose:           make(chan *rtmpConn),
 		apiRTMPConnsList:    make(chan apiRTMPConnsListReq),
 		apiRTMPConnsKick:    make(chan apiRTMPConnsKickReq),
 	}
 
-	s.Log(logger.Info, "listener opened on %s", address)
+	err := s.listen(encryption, address, rtmpsAddress, serverCert, serverKey,
+		clientCA, clientCertRequired, acmeManager, additionalAddresses)
+	if err != nil {
+		s.closeListeners()
+		ctxCancel()
+		return nil, err
+	}
 
 	if s.metrics != nil {
 		s.metrics.OnRTMPServerSet(s)
@@ -92,6 +119,92 @@ func newRTMPServer(
 	return s, nil
 }
 
+func (s *rtmpServer) listen(
+	encryption conf.Encryption,
+	address string,
+	rtmpsAddress string,
+	serverCert string,
+	serverKey string,
+	clientCA string,
+	clientCertRequired bool,
+	acmeManager *acmeManager,
+	additionalAddresses []conf.RTMPListener) error {
+	if encryption == conf.EncryptionNo || encryption == conf.EncryptionOptional {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		s.ls = append(s.ls, rtmpServerListener{l: l})
+
+		s.Log(logger.Info, "listener opened on %s", address)
+	}
+
+	if encryption == conf.EncryptionStrict || encryption == conf.EncryptionOptional {
+		var tlsConfig *tls.Config
+		if acmeManager != nil {
+			tlsConfig = &tls.Config{GetCertificate: acmeManager.getCertificate}
+		} else {
+			var err error
+			s.certLoader, err = newCertLoader(serverCert, serverKey, s)
+			if err != nil {
+				return err
+			}
+			tlsConfig = &tls.Config{GetCertificate: s.certLoader.getCertificate}
+		}
+
+		// the common name of verified client certificates is read by rtmpConn
+		// once the handshake is complete.
+		if clientCA != "" {
+			pool, err := loadClientCAs(clientCA)
+			if err != nil {
+				return err
+			}
+
+			tlsConfig.ClientCAs = pool
+			if clientCertRequired {
+				tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			} else {
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+		}
+
+		l, err := net.Listen("tcp", rtmpsAddress)
+		if err != nil {
+			return err
+		}
+		s.ls = append(s.ls, rtmpServerListener{l: tls.NewListener(l, tlsConfig)})
+
+		s.Log(logger.Info, "listener opened on %s (RTMPS)", rtmpsAddress)
+	}
+
+	// additional listeners are opened regardless of the encryption,
+	// since they are meant to be reachable by trusted networks only.
+	for _, al := range additionalAddresses {
+		l, err := net.Listen("tcp", al.Address)
+		if err != nil {
+			return err
+		}
+		s.ls = append(s.ls, rtmpServerListener{l: l, noAuth: al.NoAuth})
+
+		if al.NoAuth {
+			s.Log(logger.Info, "listener opened on %s (without authentication)", al.Address)
+		} else {
+			s.Log(logger.Info, "listener opened on %s", al.Address)
+		}
+	}
+
+	return nil
+}
+
+func (s *rtmpServer) closeListeners() {
+	for _, sl := range s.ls {
+		sl.l.Close()
+	}
+	if s.certLoader != nil {
+		s.certLoader.close()
+	}
+}
+
 func (s *rtmpServer) Log(level logger.Level, format string, args ...interface{}) {
 	s.parent.Log(level, "[RTMP] "+format, append([]interface{}{}, args...)...)
 }
@@ -105,31 +218,34 @@ func (s *rtmpServer) close() {
 func (s *rtmpServer) run() {
 	defer s.wg.Done()
 
-	s.wg.Add(1)
-	connNew := make(chan net.Conn)
+	connNew := make(chan rtmpServerNewConn)
 	acceptErr := make(chan error)
-	go func() {
-		defer s.wg.Done()
-		err := func() error {
-			for {
-				conn, err := s.l.Accept()
-				if err != nil {
-					return err
-				}
 
-				select {
-				case connNew <- conn:
-				case <-s.ctx.Done():
-					conn.Close()
+	for _, sl := range s.ls {
+		s.wg.Add(1)
+		go func(sl rtmpServerListener) {
+			defer s.wg.Done()
+			err := func() error {
+				for {
+					conn, err := sl.l.Accept()
+					if err != nil {
+						return err
+					}
+
+					select {
+					case connNew <- rtmpServerNewConn{conn, sl.noAuth}:
+					case <-s.ctx.Done():
+						conn.Close()
+					}
 				}
-			}
-		}()
+			}()
 
-		select {
-		case acceptErr <- err:
-		case <-s.ctx.Done():
-		}
-	}()
+			select {
+			case acceptErr <- err:
+			case <-s.ctx.Done():
+			}
+		}(sl)
+	}
 
 outer:
 	for {
@@ -138,7 +254,7 @@ outer:
 			s.Log(logger.Warn, "ERR: %s", err)
 			break outer
 
-		case nconn := <-connNew:
+		case nc := <-connNew:
 			id, _ := s.newConnID()
 
 			c := newRTMPConn(
@@ -150,8 +266,11 @@ outer:
 				s.readBufferCount,
 				s.runOnConnect,
 				s.runOnConnectRestart,
+				s.streamKeyURL,
+				nc.noAuth,
 				&s.wg,
-				nconn,
+				s.stats,
+				nc.nconn,
 				s.pathManager,
 				s)
 			s.conns[c] = struct{}{}
@@ -209,7 +328,7 @@ outer:
 
 	s.ctxCancel()
 
-	s.l.Close()
+	s.closeListeners()
 
 	if s.metrics != nil {
 		s.metrics.OnRTMPServerSet(s)
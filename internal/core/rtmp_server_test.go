@@ -1,10 +1,29 @@
 package core
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/notedit/rtmp/av"
+	nh264 "github.com/notedit/rtmp/codec/h264"
+	"github.com/notedit/rtmp/format/flv/flvio"
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/av1"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
+	"github.com/aler9/rtsp-simple-server/internal/rtmp"
 )
 
 func TestRTMPServerPublish(t *testing.T) {
@@ -214,3 +233,557 @@ func TestRTMPServerAuthFail(t *testing.T) {
 		require.NotEqual(t, 0, cnt2.wait())
 	})
 }
+
+func TestRTMPServerPublishLateTrack(t *testing.T) {
+	p, ok := newInstance("hlsDisable: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1935/mystream", nil)
+	require.NoError(t, err)
+	defer conn.NetConn().Close()
+
+	err = conn.ClientHandshakePublish()
+	require.NoError(t, err)
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}, []byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	// metadata contains only the video track
+	err = conn.WriteMetadata(videoTrack, nil, "", "")
+	require.NoError(t, err)
+
+	writeIDR := func() {
+		data, err := h264.EncodeAVCC([][]byte{{0x05, 0x01}})
+		require.NoError(t, err)
+		err = conn.WritePacket(av.Packet{
+			Type: av.H264,
+			Data: data,
+		})
+		require.NoError(t, err)
+	}
+
+	writeIDR()
+	time.Sleep(500 * time.Millisecond)
+
+	c1, err := gortsplib.DialRead("rtsp://localhost:8554/mystream")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(c1.Tracks()))
+	c1.Close()
+
+	// audio starts later
+	err = conn.WritePacket(av.Packet{
+		Type: av.AACDecoderConfig,
+		Data: []byte{0x12, 0x10},
+	})
+	require.NoError(t, err)
+
+	writeIDR()
+	time.Sleep(500 * time.Millisecond)
+
+	c2, err := gortsplib.DialRead("rtsp://localhost:8554/mystream")
+	require.NoError(t, err)
+	defer c2.Close()
+	require.Equal(t, 2, len(c2.Tracks()))
+	require.Equal(t, true, c2.Tracks()[1].IsAAC())
+}
+
+func TestRTMPServerPublishLateTrackCodec(t *testing.T) {
+	p, ok := newInstance("hlsDisable: yes\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    publishCodecs: [h264]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1935/mystream", nil)
+	require.NoError(t, err)
+	defer conn.NetConn().Close()
+
+	err = conn.ClientHandshakePublish()
+	require.NoError(t, err)
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}, []byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	err = conn.WriteMetadata(videoTrack, nil, "", "")
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	c, err := gortsplib.DialRead("rtsp://localhost:8554/mystream")
+	require.NoError(t, err)
+	c.Close()
+
+	// a late track with a codec that is not allowed closes the publisher
+	err = conn.WritePacket(av.Packet{
+		Type: av.AACDecoderConfig,
+		Data: []byte{0x12, 0x10},
+	})
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	_, err = gortsplib.DialRead("rtsp://localhost:8554/mystream")
+	require.Error(t, err)
+}
+
+func TestRTMPServerEncryption(t *testing.T) {
+	serverCertFpath, err := writeTempFile(serverCert)
+	require.NoError(t, err)
+	defer os.Remove(serverCertFpath)
+
+	serverKeyFpath, err := writeTempFile(serverKey)
+	require.NoError(t, err)
+	defer os.Remove(serverKeyFpath)
+
+	p, ok := newInstance("hlsDisable: yes\n" +
+		"rtmpEncryption: strict\n" +
+		"rtmpServerCert: " + serverCertFpath + "\n" +
+		"rtmpServerKey: " + serverKeyFpath + "\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	_, err = net.Dial("tcp", "localhost:1935")
+	require.Error(t, err)
+
+	conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1936/mystream",
+		func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return (&tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}).DialContext(ctx, network, address)
+		})
+	require.NoError(t, err)
+	defer conn.NetConn().Close()
+
+	err = conn.ClientHandshakePublish()
+	require.NoError(t, err)
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}, []byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	err = conn.WriteMetadata(videoTrack, nil, "", "")
+	require.NoError(t, err)
+
+	data, err := h264.EncodeAVCC([][]byte{{0x05, 0x01}})
+	require.NoError(t, err)
+	err = conn.WritePacket(av.Packet{
+		Type: av.H264,
+		Data: data,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	c, err := gortsplib.DialRead("rtsp://localhost:8554/mystream")
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, 1, len(c.Tracks()))
+}
+
+func TestRTMPServerClientCert(t *testing.T) {
+	caPEM, clientCert := newTestClientCA(t)
+
+	serverCertFpath, err := writeTempFile(serverCert)
+	require.NoError(t, err)
+	defer os.Remove(serverCertFpath)
+
+	serverKeyFpath, err := writeTempFile(serverKey)
+	require.NoError(t, err)
+	defer os.Remove(serverKeyFpath)
+
+	clientCAFpath, err := writeTempFile(caPEM)
+	require.NoError(t, err)
+	defer os.Remove(clientCAFpath)
+
+	p, ok := newInstance("hlsDisable: yes\n" +
+		"rtmpEncryption: strict\n" +
+		"rtmpServerCert: " + serverCertFpath + "\n" +
+		"rtmpServerKey: " + serverKeyFpath + "\n" +
+		"rtmpClientCA: " + clientCAFpath + "\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    publishClientCNs: [cam1]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	for _, ca := range []struct {
+		name    string
+		certs   []tls.Certificate
+		allowed bool
+	}{
+		{"allowed", []tls.Certificate{clientCert("cam1")}, true},
+		{"wrong cn", []tls.Certificate{clientCert("cam2")}, false},
+		{"no cert", nil, false},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1936/mystream",
+				func(ctx context.Context, network string, address string) (net.Conn, error) {
+					return (&tls.Dialer{Config: &tls.Config{
+						InsecureSkipVerify: true,
+						Certificates:       ca.certs,
+					}}).DialContext(ctx, network, address)
+				})
+			require.NoError(t, err)
+			defer conn.NetConn().Close()
+
+			err = conn.ClientHandshakePublish()
+			require.NoError(t, err)
+
+			videoTrack, err := gortsplib.NewTrackH264(96, []byte{
+				0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+				0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+				0x00, 0x03, 0x00, 0x3d, 0x08,
+			}, []byte{0x68, 0xee, 0x3c, 0x80})
+			require.NoError(t, err)
+
+			err = conn.WriteMetadata(videoTrack, nil, "", "")
+			require.NoError(t, err)
+
+			time.Sleep(500 * time.Millisecond)
+
+			c, err := gortsplib.DialRead("rtsp://localhost:8554/mystream")
+			if ca.allowed {
+				require.NoError(t, err)
+				c.Close()
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestRTMPServerPublishEnhanced(t *testing.T) {
+	for _, ca := range []string{
+		"h265",
+		"av1",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			p, ok := newInstance("hlsDisable: yes\n")
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1935/mystream", nil)
+			require.NoError(t, err)
+			defer conn.NetConn().Close()
+
+			err = conn.ClientHandshakePublish()
+			require.NoError(t, err)
+
+			var codecID string
+			var config av.Packet
+			var frame av.Packet
+			var rtpPayload []byte
+
+			if ca == "h265" {
+				codecID = "hvc1"
+
+				config = av.Packet{
+					Type: rtmp.H265DecoderConfig,
+					Data: append(append([]byte{0x01}, make([]byte, 21)...),
+						0x03,
+						0x20, 0x00, 0x01, 0x00, 0x02, 0x40, 0x01,
+						0x21, 0x00, 0x01, 0x00, 0x02, 0x42, 0x01,
+						0x22, 0x00, 0x01, 0x00, 0x02, 0x44, 0x01,
+					),
+				}
+
+				data, err := h264.EncodeAVCC([][]byte{{0x26, 0x01, 0xaa}})
+				require.NoError(t, err)
+				frame = av.Packet{
+					Type:       rtmp.H265,
+					Data:       data,
+					IsKeyFrame: true,
+				}
+
+				rtpPayload = []byte{0x26, 0x01, 0xaa}
+			} else {
+				codecID = "av01"
+
+				config = av.Packet{
+					Type: rtmp.AV1DecoderConfig,
+					Data: []byte{0x81, 0x00, 0x0c, 0x00},
+				}
+
+				frame = av.Packet{
+					Type: rtmp.AV1,
+					Data: []byte{
+						0x12, 0x00, // temporal delimiter
+						0x32, 0x02, 0xaa, 0xbb, // frame
+					},
+					IsKeyFrame: true,
+				}
+
+				rtpPayload = []byte{0x00, 0x03, 0x30, 0xaa, 0xbb}
+			}
+
+			err = conn.WritePacket(av.Packet{
+				Type: av.Metadata,
+				Data: flvio.FillAMF0ValMalloc(flvio.AMFMap{
+					{K: "videocodecid", V: codecID},
+					{K: "audiocodecid", V: float64(0)},
+				}),
+			})
+			require.NoError(t, err)
+
+			err = conn.WritePacket(config)
+			require.NoError(t, err)
+
+			time.Sleep(500 * time.Millisecond)
+
+			c, err := gortsplib.DialRead("rtsp://localhost:8554/mystream")
+			require.NoError(t, err)
+			defer c.Close()
+			require.Equal(t, 1, len(c.Tracks()))
+
+			if ca == "h265" {
+				require.Equal(t, true, h265.IsTrack(c.Tracks()[0]))
+			} else {
+				require.Equal(t, true, av1.IsTrack(c.Tracks()[0]))
+			}
+
+			frameRecv := make(chan []byte, 1)
+			go func() {
+				c.ReadFrames(func(trackID int, streamType gortsplib.StreamType, payload []byte) {
+					if streamType == gortsplib.StreamTypeRTP {
+						select {
+						case frameRecv <- payload:
+						default:
+						}
+					}
+				})
+			}()
+
+			err = conn.WritePacket(frame)
+			require.NoError(t, err)
+
+			var pkt rtp.Packet
+			err = pkt.Unmarshal(<-frameRecv)
+			require.NoError(t, err)
+			require.Equal(t, rtpPayload, pkt.Payload)
+		})
+	}
+}
+
+func TestRTMPServerStreamKey(t *testing.T) {
+	type keyReq struct {
+		Key  string `json:"key"`
+		IP   string `json:"ip"`
+		Path string `json:"path"`
+	}
+	reqs := make(chan keyReq, 10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in keyReq
+		err := json.NewDecoder(r.Body).Decode(&in)
+		require.NoError(t, err)
+		reqs <- in
+
+		if in.Key != "mykey" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+
+	p, ok := newInstance("hlsDisable: yes\n" +
+		"rtmpStreamKeyURL: " + ts.URL + "/validate\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	for _, ca := range []string{
+		"accepted",
+		"rejected",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			pathName := "live/mykey"
+			if ca == "rejected" {
+				pathName = "live/otherkey"
+			}
+
+			conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1935/"+pathName, nil)
+			require.NoError(t, err)
+			defer conn.NetConn().Close()
+
+			err = conn.ClientHandshakePublish()
+			require.NoError(t, err)
+
+			videoTrack, err := gortsplib.NewTrackH264(96, []byte{
+				0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+				0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+				0x00, 0x03, 0x00, 0x3d, 0x08,
+			}, []byte{0x68, 0xee, 0x3c, 0x80})
+			require.NoError(t, err)
+
+			err = conn.WriteMetadata(videoTrack, nil, "", "")
+			require.NoError(t, err)
+
+			in := <-reqs
+			require.Equal(t, keyReq{
+				Key:  pathName[len("live/"):],
+				IP:   "127.0.0.1",
+				Path: pathName,
+			}, in)
+
+			time.Sleep(500 * time.Millisecond)
+
+			c, err := gortsplib.DialRead("rtsp://localhost:8554/" + pathName)
+			if ca == "accepted" {
+				require.NoError(t, err)
+				defer c.Close()
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestRTMPServerPublishMetadata(t *testing.T) {
+	p, ok := newInstance("api: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    sdpPassthrough: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1935/mystream", nil)
+	require.NoError(t, err)
+	defer conn.NetConn().Close()
+
+	err = conn.ClientHandshakePublish()
+	require.NoError(t, err)
+
+	err = conn.WritePacket(av.Packet{
+		Type: av.Metadata,
+		Data: flvio.FillAMF0ValMalloc(flvio.AMFMap{
+			{K: "videocodecid", V: float64(7)},
+			{K: "width", V: float64(1280)},
+			{K: "encoder", V: "obs-output module"},
+			{K: "stereo", V: true},
+			{K: "custom", V: flvio.AMFMap{{K: "nested", V: "value"}}},
+		}),
+	})
+	require.NoError(t, err)
+
+	codec := nh264.Codec{
+		SPS: map[int][]byte{
+			0: {
+				0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+				0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+				0x00, 0x03, 0x00, 0x3d, 0x08,
+			},
+		},
+		PPS: map[int][]byte{
+			0: {0x68, 0xee, 0x3c, 0x80},
+		},
+	}
+	b := make([]byte, 128)
+	var n int
+	codec.ToConfig(b, &n)
+
+	err = conn.WritePacket(av.Packet{
+		Type: av.H264DecoderConfig,
+		Data: b[:n],
+	})
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	var out struct {
+		Items map[string]struct {
+			Metadata map[string]string `json:"metadata"`
+		} `json:"items"`
+	}
+	err = httpRequest(http.MethodGet, "http://localhost:9997/v1/paths/list", nil, &out)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"videocodecid": "7",
+		"width":        "1280",
+		"encoder":      "obs-output module",
+		"stereo":       "true",
+	}, out.Items["mystream"].Metadata)
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(nconn), bufio.NewWriter(nconn))
+
+	err = base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://localhost:8554/mystream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	}.Write(bconn.Writer)
+	require.NoError(t, err)
+
+	var res base.Response
+	err = res.Read(bconn.Reader)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Contains(t, string(res.Body), "a=x-metadata:encoder=obs-output module\r\n"+
+		"a=x-metadata:stereo=true\r\n"+
+		"a=x-metadata:videocodecid=7\r\n"+
+		"a=x-metadata:width=1280\r\n")
+}
+
+func TestRTMPServerAdditionalAddresses(t *testing.T) {
+	p, ok := newInstance("hlsDisable: yes\n" +
+		"rtmpAdditionalAddresses: [\":19350/noauth\", \":19351\"]\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    publishUser: testuser\n" +
+		"    publishPass: testpass\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	for _, ca := range []struct {
+		name    string
+		port    string
+		allowed bool
+	}{
+		{"main", "1935", false},
+		{"auth", "19351", false},
+		{"noauth", "19350", true},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			conn, err := rtmp.DialContext(context.Background(),
+				"rtmp://localhost:"+ca.port+"/"+ca.name, nil)
+			require.NoError(t, err)
+			defer conn.NetConn().Close()
+
+			err = conn.ClientHandshakePublish()
+			require.NoError(t, err)
+
+			videoTrack, err := gortsplib.NewTrackH264(96, []byte{
+				0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+				0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+				0x00, 0x03, 0x00, 0x3d, 0x08,
+			}, []byte{0x68, 0xee, 0x3c, 0x80})
+			require.NoError(t, err)
+
+			err = conn.WriteMetadata(videoTrack, nil, "", "")
+			require.NoError(t, err)
+
+			time.Sleep(500 * time.Millisecond)
+
+			c, err := gortsplib.DialRead("rtsp://localhost:8554/" + ca.name)
+			if ca.allowed {
+				require.NoError(t, err)
+				c.Close()
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
@@ -2,57 +2,69 @@ package core
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"net"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/aler9/gortsplib"
-	"github.com/aler9/gortsplib/pkg/rtpaac"
-	"github.com/aler9/gortsplib/pkg/rtph264"
 	"github.com/notedit/rtmp/av"
 
-	"github.com/aler9/rtsp-simple-server/internal/h264"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/proxydialer"
 	"github.com/aler9/rtsp-simple-server/internal/rtcpsenderset"
 	"github.com/aler9/rtsp-simple-server/internal/rtmp"
 )
 
-const (
-	rtmpSourceRetryPause = 5 * time.Second
-)
-
 type rtmpSourceParent interface {
 	Log(logger.Level, string, ...interface{})
 	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
 	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	OnSourceTracksUpdate(req pathSourceTracksUpdateReq) pathSourceTracksUpdateRes
+	OnSourceStaticSwitch(req pathSourceStaticSwitchReq)
 }
 
 type rtmpSource struct {
-	ur           string
+	urs          []string
+	fingerprint  string
 	readTimeout  time.Duration
 	writeTimeout time.Duration
+	retryBackoff *sourceRetryBackoff
+	dialContext  proxydialer.DialContextFunc
 	wg           *sync.WaitGroup
 	stats        *stats
 	parent       rtmpSourceParent
 
 	ctx       context.Context
 	ctxCancel func()
+	ur        string
+	urIdx     int
+	failover  *sourceFailover
 }
 
 func newRTMPSource(
 	parentCtx context.Context,
-	ur string,
+	urs []string,
+	failbackInterval time.Duration,
+	fingerprint string,
 	readTimeout time.Duration,
 	writeTimeout time.Duration,
+	retryPause time.Duration,
+	retryMaxPause time.Duration,
+	dialContext proxydialer.DialContextFunc,
 	wg *sync.WaitGroup,
 	stats *stats,
 	parent rtmpSourceParent) *rtmpSource {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	s := &rtmpSource{
-		ur:           ur,
+		urs:          urs,
+		fingerprint:  fingerprint,
 		readTimeout:  readTimeout,
 		writeTimeout: writeTimeout,
+		retryBackoff: newSourceRetryBackoff(retryPause, retryMaxPause),
+		dialContext:  dialContext,
 		wg:           wg,
 		stats:        stats,
 		parent:       parent,
@@ -60,6 +72,11 @@ func newRTMPSource(
 		ctxCancel:    ctxCancel,
 	}
 
+	// URLs after the first one are backups, instead of being used in rotation
+	if failbackInterval != 0 {
+		s.failover = newSourceFailover(urs, failbackInterval)
+	}
+
 	s.log(logger.Info, "started")
 
 	s.wg.Add(1)
@@ -78,18 +95,40 @@ func (s *rtmpSource) log(level logger.Level, format string, args ...interface{})
 	s.parent.Log(level, "[rtmp source] "+format, args...)
 }
 
+// nextURL picks the URL used by the next connection attempt,
+// rotating between the available ones.
+func (s *rtmpSource) nextURL() {
+	if s.failover != nil {
+		s.ur = s.failover.url()
+		return
+	}
+
+	if len(s.urs) > 1 {
+		s.log(logger.Debug, "using URL %d of %d", s.urIdx+1, len(s.urs))
+	}
+
+	s.ur = s.urs[s.urIdx]
+	s.urIdx = (s.urIdx + 1) % len(s.urs)
+}
+
 func (s *rtmpSource) run() {
 	defer s.wg.Done()
 
 	for {
+		s.nextURL()
+
 		ok := func() bool {
 			ok := s.runInner()
 			if !ok {
 				return false
 			}
 
+			if s.failover != nil && !s.failover.next() {
+				return true
+			}
+
 			select {
-			case <-time.After(rtmpSourceRetryPause):
+			case <-time.After(s.retryBackoff.next()):
 				return true
 			case <-s.ctx.Done():
 				return false
@@ -103,9 +142,87 @@ func (s *rtmpSource) run() {
 	s.ctxCancel()
 }
 
+// rtmpDialContext returns the function that establishes connections to a
+// RTMP URL. With RTMPS, connections are wrapped with TLS, and the certificate
+// of the server is verified with the fingerprint, if provided, or with the
+// certificate authorities of the system.
+func rtmpDialContext(ur string, fingerprint string, dialContext proxydialer.DialContextFunc) proxydialer.DialContextFunc {
+	u, err := url.Parse(ur)
+	if err != nil || u.Scheme != "rtmps" {
+		return dialContext
+	}
+
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	if fingerprint != "" {
+		tlsConfig = rtspSourceTLSConfig(fingerprint)
+	}
+
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		nconn, err := dialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			nconn.SetDeadline(deadline)
+		}
+
+		tconn := tls.Client(nconn, tlsConfig)
+		err = tconn.Handshake()
+		if err != nil {
+			nconn.Close()
+			return nil, err
+		}
+
+		nconn.SetDeadline(time.Time{})
+
+		return tconn, nil
+	}
+}
+
+// rtmpSourceProbe checks whether a stream is available, by reading its metadata.
+func rtmpSourceProbe(
+	ctx context.Context,
+	ur string,
+	fingerprint string,
+	readTimeout time.Duration,
+	writeTimeout time.Duration,
+	dialContext proxydialer.DialContextFunc,
+) error {
+	ctx2, cancel2 := context.WithTimeout(ctx, readTimeout)
+	defer cancel2()
+
+	conn, err := rtmp.DialContext(ctx2, ur, rtmpDialContext(ur, fingerprint, dialContext))
+	if err != nil {
+		return err
+	}
+	defer conn.NetConn().Close()
+
+	conn.NetConn().SetReadDeadline(time.Now().Add(readTimeout))
+	conn.NetConn().SetWriteDeadline(time.Now().Add(writeTimeout))
+	err = conn.ClientHandshake()
+	if err != nil {
+		return err
+	}
+
+	_, _, err = conn.ReadMetadata()
+	return err
+}
+
+func (s *rtmpSource) probe(ctx context.Context, ur string) error {
+	return rtmpSourceProbe(ctx, ur, s.fingerprint, s.readTimeout, s.writeTimeout, s.dialContext)
+}
+
 func (s *rtmpSource) runInner() bool {
 	innerCtx, innerCtxCancel := context.WithCancel(s.ctx)
 
+	var failbackC <-chan struct{}
+	if s.failover != nil && s.failover.usingBackup() {
+		failbackC = s.failover.probePrimary(innerCtx, s.probe, func(err error) {
+			s.log(logger.Debug, "primary URL is not available: %s", err)
+		})
+	}
+
 	runErr := make(chan error)
 	go func() {
 		runErr <- func() error {
@@ -114,7 +231,7 @@ func (s *rtmpSource) runInner() bool {
 			ctx2, cancel2 := context.WithTimeout(innerCtx, s.readTimeout)
 			defer cancel2()
 
-			conn, err := rtmp.DialContext(ctx2, s.ur)
+			conn, err := rtmp.DialContext(ctx2, s.ur, rtmpDialContext(s.ur, s.fingerprint, s.dialContext))
 			if err != nil {
 				return err
 			}
@@ -137,44 +254,41 @@ func (s *rtmpSource) runInner() bool {
 						return err
 					}
 
-					var tracks gortsplib.Tracks
-					videoTrackID := -1
-					audioTrackID := -1
-
-					var h264Encoder *rtph264.Encoder
-					if videoTrack != nil {
-						h264Encoder = rtph264.NewEncoder(96, nil, nil, nil)
-						videoTrackID = len(tracks)
-						tracks = append(tracks, videoTrack)
-					}
-
-					var aacEncoder *rtpaac.Encoder
-					if audioTrack != nil {
-						clockRate, _ := audioTrack.ClockRate()
-						aacEncoder = rtpaac.NewEncoder(96, clockRate, nil, nil, nil)
-						audioTrackID = len(tracks)
-						tracks = append(tracks, audioTrack)
-					}
+					rtracks := newRTMPTracks(videoTrack, audioTrack)
+					tracks := rtracks.tracks
 
 					s.log(logger.Info, "ready")
 
 					res := s.parent.OnSourceStaticSetReady(pathSourceStaticSetReadyReq{
-						Tracks: tracks,
+						Tracks:   tracks,
+						Metadata: conn.Metadata(),
 					})
 					if res.Err != nil {
 						return err
 					}
 
+					s.retryBackoff.reset()
+
 					defer func() {
 						s.parent.OnSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{Source: s})
 					}()
 
-					rtcpSenders := rtcpsenderset.New(tracks, res.Stream.onFrame)
-					defer rtcpSenders.Close()
+					if s.failover != nil {
+						if reason := s.failover.onReady(); reason != "" {
+							s.log(logger.Warn, "%s, using %s", reason, s.failover.label())
+							s.parent.OnSourceStaticSwitch(pathSourceStaticSwitchReq{URL: s.ur, Reason: reason})
+						}
+					}
+
+					stream := res.Stream
+					rtcpSenders := rtcpsenderset.New(tracks, stream.onFrame)
+					defer func() {
+						rtcpSenders.Close()
+					}()
 
 					onFrame := func(trackID int, payload []byte) {
 						rtcpSenders.OnFrame(trackID, gortsplib.StreamTypeRTP, payload)
-						res.Stream.onFrame(trackID, gortsplib.StreamTypeRTP, payload)
+						stream.onFrame(trackID, gortsplib.StreamTypeRTP, payload)
 					}
 
 					for {
@@ -184,54 +298,40 @@ func (s *rtmpSource) runInner() bool {
 							return err
 						}
 
-						switch pkt.Type {
-						case av.H264:
-							if videoTrack == nil {
-								return fmt.Errorf("ERR: received an H264 frame, but track is not set up")
-							}
-
-							nalus, err := h264.DecodeAVCC(pkt.Data)
+						if rtmp.IsVideoDecoderConfig(pkt.Type) || pkt.Type == av.AACDecoderConfig {
+							// a track that was not available when the stream started
+							added, err := rtracks.addLateTrack(pkt)
 							if err != nil {
 								return err
 							}
-
-							var outNALUs [][]byte
-							for _, nalu := range nalus {
-								// remove SPS, PPS and AUD, not needed by RTSP / RTMP
-								typ := h264.NALUType(nalu[0] & 0x1F)
-								switch typ {
-								case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
-									continue
-								}
-
-								outNALUs = append(outNALUs, nalu)
+							if !added {
+								continue
 							}
 
-							pkts, err := h264Encoder.Encode(outNALUs, pkt.Time+pkt.CTime)
-							if err != nil {
-								return fmt.Errorf("ERR while encoding H264: %v", err)
-							}
+							tracks = rtracks.tracks
+							s.log(logger.Info, "a late track has been added, the stream now has %d tracks", len(tracks))
 
-							for _, pkt := range pkts {
-								onFrame(videoTrackID, pkt)
+							ures := s.parent.OnSourceTracksUpdate(pathSourceTracksUpdateReq{
+								Source: s,
+								Tracks: tracks,
+							})
+							if ures.Err != nil {
+								return ures.Err
 							}
 
-						case av.AAC:
-							if audioTrack == nil {
-								return fmt.Errorf("ERR: received an AAC frame, but track is not set up")
-							}
-
-							pkts, err := aacEncoder.Encode([][]byte{pkt.Data}, pkt.Time+pkt.CTime)
-							if err != nil {
-								return fmt.Errorf("ERR while encoding AAC: %v", err)
-							}
+							rtcpSenders.Close()
+							stream = ures.Stream
+							rtcpSenders = rtcpsenderset.New(tracks, stream.onFrame)
+							continue
+						}
 
-							for _, pkt := range pkts {
-								onFrame(audioTrackID, pkt)
-							}
+						trackID, frames, err := rtracks.encode(pkt)
+						if err != nil {
+							return err
+						}
 
-						default:
-							return fmt.Errorf("ERR: unexpected packet: %v", pkt.Type)
+						for _, frame := range frames {
+							onFrame(trackID, frame)
 						}
 					}
 				}()
@@ -256,6 +356,13 @@ func (s *rtmpSource) runInner() bool {
 		s.log(logger.Info, "ERR: %s", err)
 		return true
 
+	case <-failbackC:
+		s.log(logger.Info, "primary URL is available again")
+		innerCtxCancel()
+		<-runErr
+		s.failover.failback()
+		return true
+
 	case <-s.ctx.Done():
 		innerCtxCancel()
 		<-runErr
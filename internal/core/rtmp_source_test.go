@@ -1,10 +1,17 @@
 package core
 
 import (
+	"context"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/aler9/gortsplib"
+	"github.com/notedit/rtmp/av"
 	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/rtmp"
 )
 
 func TestRTMPSource(t *testing.T) {
@@ -57,3 +64,58 @@ func TestRTMPSource(t *testing.T) {
 		})
 	}
 }
+
+func TestRTMPSourceTLS(t *testing.T) {
+	serverCertFpath, err := writeTempFile(serverCert)
+	require.NoError(t, err)
+	defer os.Remove(serverCertFpath)
+
+	serverKeyFpath, err := writeTempFile(serverKey)
+	require.NoError(t, err)
+	defer os.Remove(serverKeyFpath)
+
+	p, ok := newInstance("hlsDisable: yes\n" +
+		"rtmpEncryption: optional\n" +
+		"rtmpServerCert: " + serverCertFpath + "\n" +
+		"rtmpServerKey: " + serverKeyFpath + "\n" +
+		"paths:\n" +
+		"  proxied:\n" +
+		"    source: rtmps://localhost:1936/mystream\n" +
+		"    sourceFingerprint: 33949E05FFFB5FF3E8AA16F8213A6251B4D9363804BA53233C4DA9A46D6F2739\n" +
+		"  mystream:\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := rtmp.DialContext(context.Background(), "rtmp://localhost:1935/mystream", nil)
+	require.NoError(t, err)
+	defer conn.NetConn().Close()
+
+	err = conn.ClientHandshakePublish()
+	require.NoError(t, err)
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}, []byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	err = conn.WriteMetadata(videoTrack, nil, "", "")
+	require.NoError(t, err)
+
+	data, err := h264.EncodeAVCC([][]byte{{0x05, 0x01}})
+	require.NoError(t, err)
+	err = conn.WritePacket(av.Packet{
+		Type: av.H264,
+		Data: data,
+	})
+	require.NoError(t, err)
+
+	// the source retries until the stream is available
+	time.Sleep(2500 * time.Millisecond)
+
+	c, err := gortsplib.DialRead("rtsp://localhost:8554/proxied")
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, 1, len(c.Tracks()))
+}
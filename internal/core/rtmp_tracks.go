@@ -0,0 +1,110 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/notedit/rtmp/av"
+
+	"github.com/aler9/rtsp-simple-server/internal/rtmp"
+)
+
+// rtmpTracks contains the tracks of an incoming RTMP stream, and converts
+// the packets of the stream into RTP packets.
+// It is shared by RTMP publishers and RTMP sources.
+type rtmpTracks struct {
+	tracks       gortsplib.Tracks
+	videoTrack   *gortsplib.Track
+	videoTrackID int
+	videoEncoder *rtmpVideoEncoder
+	audioTrack   *gortsplib.Track
+	audioTrackID int
+	aacEncoder   *rtpaac.Encoder
+}
+
+func newRTMPTracks(videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) *rtmpTracks {
+	t := &rtmpTracks{
+		videoTrackID: -1,
+		audioTrackID: -1,
+	}
+
+	if videoTrack != nil {
+		t.setVideoTrack(videoTrack)
+	}
+
+	if audioTrack != nil {
+		t.setAudioTrack(audioTrack)
+	}
+
+	return t
+}
+
+func (t *rtmpTracks) setVideoTrack(track *gortsplib.Track) {
+	t.videoTrack = track
+	t.videoEncoder = newRTMPVideoEncoder(track)
+	t.videoTrackID = len(t.tracks)
+	t.tracks = append(t.tracks, track)
+}
+
+func (t *rtmpTracks) setAudioTrack(track *gortsplib.Track) {
+	clockRate, _ := track.ClockRate()
+	t.audioTrack = track
+	t.aacEncoder = rtpaac.NewEncoder(96, clockRate, nil, nil, nil)
+	t.audioTrackID = len(t.tracks)
+	t.tracks = append(t.tracks, track)
+}
+
+// addLateTrack adds a track that was not available when the stream started,
+// reading it from a decoder configuration. It returns false when the
+// track is already set up.
+func (t *rtmpTracks) addLateTrack(pkt av.Packet) (bool, error) {
+	if (rtmp.IsVideoDecoderConfig(pkt.Type) && t.videoTrack != nil) ||
+		(pkt.Type == av.AACDecoderConfig && t.audioTrack != nil) {
+		return false, nil
+	}
+
+	track, err := rtmp.TrackFromDecoderConfig(pkt)
+	if err != nil {
+		return false, err
+	}
+
+	if rtmp.IsVideoDecoderConfig(pkt.Type) {
+		t.setVideoTrack(track)
+	} else {
+		t.setAudioTrack(track)
+	}
+
+	return true, nil
+}
+
+// encode returns the ID of the track of a packet and its RTP packets.
+func (t *rtmpTracks) encode(pkt av.Packet) (int, [][]byte, error) {
+	switch pkt.Type {
+	case av.H264, rtmp.H265, rtmp.AV1:
+		if t.videoTrack == nil {
+			return 0, nil, fmt.Errorf("ERR: received a video frame, but track is not set up")
+		}
+
+		frames, err := t.videoEncoder.encode(pkt)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return t.videoTrackID, frames, nil
+
+	case av.AAC:
+		if t.audioTrack == nil {
+			return 0, nil, fmt.Errorf("ERR: received an AAC frame, but track is not set up")
+		}
+
+		frames, err := t.aacEncoder.Encode([][]byte{pkt.Data}, pkt.Time+pkt.CTime)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ERR while encoding AAC: %v", err)
+		}
+
+		return t.audioTrackID, frames, nil
+	}
+
+	return 0, nil, fmt.Errorf("ERR: unexpected packet: %v", pkt.Type)
+}
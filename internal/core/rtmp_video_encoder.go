@@ -0,0 +1,135 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/notedit/rtmp/av"
+
+	"github.com/aler9/rtsp-simple-server/internal/av1"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
+	"github.com/aler9/rtsp-simple-server/internal/rtmp"
+)
+
+// rtmpVideoEncoder converts the video packets of a RTMP stream
+// (H264, H265 or AV1) into RTP packets.
+type rtmpVideoEncoder struct {
+	h264Encoder *rtph264.Encoder
+	h265Encoder *h265.Encoder
+	av1Encoder  *av1.Encoder
+}
+
+func newRTMPVideoEncoder(track *gortsplib.Track) *rtmpVideoEncoder {
+	e := &rtmpVideoEncoder{}
+
+	switch {
+	case h265.IsTrack(track):
+		e.h265Encoder = h265.NewEncoder(96, nil, nil, nil)
+
+	case av1.IsTrack(track):
+		e.av1Encoder = av1.NewEncoder(96, nil, nil, nil)
+
+	default:
+		e.h264Encoder = rtph264.NewEncoder(96, nil, nil, nil)
+	}
+
+	return e
+}
+
+// encode returns the RTP packets of a video packet.
+func (e *rtmpVideoEncoder) encode(pkt av.Packet) ([][]byte, error) {
+	switch pkt.Type {
+	case av.H264:
+		if e.h264Encoder == nil {
+			return nil, fmt.Errorf("ERR: received an H264 frame, but the video track uses another codec")
+		}
+
+		nalus, err := h264.DecodeAVCC(pkt.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		var outNALUs [][]byte
+
+		for _, nalu := range nalus {
+			// remove SPS, PPS and AUD, not needed by RTSP
+			typ := h264.NALUType(nalu[0] & 0x1F)
+			switch typ {
+			case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+				continue
+			}
+
+			outNALUs = append(outNALUs, nalu)
+		}
+
+		if len(outNALUs) == 0 {
+			return nil, nil
+		}
+
+		frames, err := e.h264Encoder.Encode(outNALUs, pkt.Time+pkt.CTime)
+		if err != nil {
+			return nil, fmt.Errorf("ERR while encoding H264: %v", err)
+		}
+
+		return frames, nil
+
+	case rtmp.H265:
+		if e.h265Encoder == nil {
+			return nil, fmt.Errorf("ERR: received an H265 frame, but the video track uses another codec")
+		}
+
+		// NALUs are in the same length-prefixed format of H264
+		nalus, err := h264.DecodeAVCC(pkt.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		var outNALUs [][]byte
+
+		for _, nalu := range nalus {
+			if len(nalu) < 2 {
+				continue
+			}
+
+			// remove VPS, SPS, PPS and AUD, not needed by RTSP
+			switch h265.NALUTypeOf(nalu) {
+			case h265.NALUTypeVPS, h265.NALUTypeSPS, h265.NALUTypePPS, h265.NALUTypeAccessUnitDelimiter:
+				continue
+			}
+
+			outNALUs = append(outNALUs, nalu)
+		}
+
+		if len(outNALUs) == 0 {
+			return nil, nil
+		}
+
+		frames, err := e.h265Encoder.Encode(outNALUs, pkt.Time+pkt.CTime)
+		if err != nil {
+			return nil, fmt.Errorf("ERR while encoding H265: %v", err)
+		}
+
+		return frames, nil
+
+	case rtmp.AV1:
+		if e.av1Encoder == nil {
+			return nil, fmt.Errorf("ERR: received an AV1 frame, but the video track uses another codec")
+		}
+
+		obus, err := av1.DecodeOBUs(pkt.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		frames, err := e.av1Encoder.Encode(obus, pkt.Time+pkt.CTime)
+		if err != nil {
+			return nil, fmt.Errorf("ERR while encoding AV1: %v", err)
+		}
+
+		return frames, nil
+	}
+
+	return nil, fmt.Errorf("ERR: unexpected packet: %v", pkt.Type)
+}
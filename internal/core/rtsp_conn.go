@@ -2,8 +2,15 @@ package core
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aler9/gortsplib"
@@ -11,13 +18,19 @@ import (
 	"github.com/aler9/gortsplib/pkg/base"
 	"github.com/aler9/gortsplib/pkg/headers"
 	"github.com/aler9/gortsplib/pkg/liberrors"
+	"github.com/aler9/gortsplib/pkg/sdp"
+	psdp "github.com/pion/sdp/v3"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/credential"
 	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/srtp"
 )
 
 const (
 	rtspConnPauseAfterAuthError = 2 * time.Second
+	rtspConnRedirectHookTimeout = 5 * time.Second
 )
 
 func isTeardownErr(err error) bool {
@@ -40,15 +53,25 @@ type rtspConn struct {
 	readTimeout         time.Duration
 	runOnConnect        string
 	runOnConnectRestart bool
+	stats               *stats
 	pathManager         *pathManager
 	conn                *gortsplib.ServerConn
 	parent              rtspConnParent
 
-	onConnectCmd  *externalcmd.Cmd
-	authUser      string
-	authPass      string
-	authValidator *auth.Validator
-	authFailures  int
+	ipStats        *ipStatsEntry
+	onConnectCmd   *externalcmd.Cmd
+	authUser       string
+	authPass       string
+	authValidator  *auth.Validator
+	authFailures   int
+	clientCN       string
+	vodToStart     *rtspVOD
+	sessionTimeout time.Duration
+
+	// keys sent to the client in the session description of each path,
+	// and whether the current SETUP request uses SRTP.
+	srtpAttributes map[string][]*srtp.CryptoAttribute
+	srtpSetup      bool
 }
 
 func newRTSPConn(
@@ -57,6 +80,7 @@ func newRTSPConn(
 	readTimeout time.Duration,
 	runOnConnect string,
 	runOnConnectRestart bool,
+	stats *stats,
 	pathManager *pathManager,
 	conn *gortsplib.ServerConn,
 	parent rtspConnParent) *rtspConn {
@@ -66,6 +90,7 @@ func newRTSPConn(
 		readTimeout:         readTimeout,
 		runOnConnect:        runOnConnect,
 		runOnConnectRestart: runOnConnectRestart,
+		stats:               stats,
 		pathManager:         pathManager,
 		conn:                conn,
 		parent:              parent,
@@ -73,6 +98,8 @@ func newRTSPConn(
 
 	c.log(logger.Info, "opened")
 
+	c.ipStats = stats.IPs.onConn(c.ip())
+
 	if c.runOnConnect != "" {
 		_, port, _ := net.SplitHostPort(c.rtspAddress)
 		c.onConnectCmd = externalcmd.New(c.runOnConnect, c.runOnConnectRestart, externalcmd.Environment{
@@ -97,6 +124,14 @@ func (c *rtspConn) ip() net.IP {
 	return c.conn.NetConn().RemoteAddr().(*net.TCPAddr).IP
 }
 
+// applyPathTimeouts applies the timeouts of a path to the connection.
+func (c *rtspConn) applyPathTimeouts(pathConf *conf.PathConf) {
+	if tc, ok := c.conn.NetConn().(*rtspTimeoutConn); ok {
+		tc.setTimeouts(pathConf.ReadTimeout, pathConf.WriteTimeout)
+	}
+	c.sessionTimeout = pathConf.SessionTimeout
+}
+
 func (c *rtspConn) validateCredentials(
 	pathUser string,
 	pathPass string,
@@ -107,7 +142,13 @@ func (c *rtspConn) validateCredentials(
 	if c.authValidator == nil || c.authUser != pathUser || c.authPass != pathPass {
 		c.authUser = pathUser
 		c.authPass = pathPass
-		c.authValidator = auth.NewValidator(pathUser, pathPass, c.authMethods)
+
+		methods := c.authMethods
+		// bcrypt and argon2 hashes can't be used with the Digest method
+		if credential.IsSlowHashed(pathUser) || credential.IsSlowHashed(pathPass) {
+			methods = []headers.AuthMethod{headers.AuthBasic}
+		}
+		c.authValidator = auth.NewValidator(pathUser, pathPass, methods)
 	}
 
 	// VLC strips the control attribute
@@ -123,7 +164,7 @@ func (c *rtspConn) validateCredentials(
 		}
 	}()
 
-	err := c.authValidator.ValidateRequest(req, altURL)
+	err := c.validateRequest(pathUser, pathPass, req, altURL)
 	if err != nil {
 		c.authFailures++
 
@@ -146,6 +187,11 @@ func (c *rtspConn) validateCredentials(
 			c.log(logger.Debug, "WARN: unauthorized: %s", err)
 		}
 
+		// requests without credentials are part of the normal handshake
+		if _, ok := req.Header["Authorization"]; ok {
+			c.stats.IPs.onAuthFailure(c.ip())
+		}
+
 		return pathErrAuthNotCritical{
 			Response: &base.Response{
 				StatusCode: base.StatusUnauthorized,
@@ -162,6 +208,52 @@ func (c *rtspConn) validateCredentials(
 	return nil
 }
 
+// rtspConnRequestUser returns the user contained in the Authorization header of a request.
+func rtspConnRequestUser(req *base.Request) string {
+	var auth headers.Authorization
+	err := auth.Read(req.Header["Authorization"])
+	if err != nil {
+		return ""
+	}
+
+	if auth.Method == headers.AuthBasic {
+		return auth.BasicUser
+	}
+
+	if auth.DigestValues.Username != nil {
+		return *auth.DigestValues.Username
+	}
+	return ""
+}
+
+func (c *rtspConn) validateRequest(
+	pathUser string,
+	pathPass string,
+	req *base.Request,
+	altURL *base.URL,
+) error {
+	if !credential.IsSlowHashed(pathUser) && !credential.IsSlowHashed(pathPass) {
+		return c.authValidator.ValidateRequest(req, altURL)
+	}
+
+	var auth headers.Authorization
+	err := auth.Read(req.Header["Authorization"])
+	if err != nil {
+		return err
+	}
+
+	if auth.Method != headers.AuthBasic {
+		return fmt.Errorf("unsupported authentication method")
+	}
+
+	if !credential.Check(pathUser, auth.BasicUser) ||
+		!credential.Check(pathPass, auth.BasicPass) {
+		return fmt.Errorf("wrong response")
+	}
+
+	return nil
+}
+
 // OnClose is called by rtspServer.
 func (c *rtspConn) OnClose(err error) {
 	if err != io.EOF && !isTeardownErr(err) && !isTerminatedErr(err) {
@@ -173,16 +265,55 @@ func (c *rtspConn) OnClose(err error) {
 	if c.onConnectCmd != nil {
 		c.onConnectCmd.Close()
 	}
+
+	c.stats.IPs.release(c.ipStats)
 }
 
 // OnRequest is called by rtspServer.
 func (c *rtspConn) OnRequest(req *base.Request) {
 	c.log(logger.Debug, "[c->s] %v", req)
+
+	c.srtpSetup = false
+	if req.Method == base.Setup {
+		if v, ok := rtspTransportSetProfile(req.Header["Transport"], "SAVP", "AVP"); ok {
+			req.Header["Transport"] = v
+			c.srtpSetup = true
+		}
+	}
 }
 
 // OnResponse is called by rtspServer.
 func (c *rtspConn) OnResponse(res *base.Response) {
+	if c.srtpSetup {
+		if v, ok := rtspTransportSetProfile(res.Header["Transport"], "AVP", "SAVP"); ok {
+			res.Header["Transport"] = v
+		}
+		c.srtpSetup = false
+	}
+
+	// advertise the session timeout, in order to make clients
+	// send keepalives accordingly.
+	if c.sessionTimeout != 0 && res.StatusCode == base.StatusOK {
+		if v, ok := res.Header["Session"]; ok {
+			var h headers.Session
+			if h.Read(v) == nil {
+				timeout := uint(c.sessionTimeout / time.Second)
+				h.Timeout = &timeout
+				res.Header["Session"] = h.Write()
+			}
+		}
+	}
+
 	c.log(logger.Debug, "[s->c] %v", res)
+
+	// files are played after the response to PLAY, in order not to lose
+	// the initial frames.
+	if c.vodToStart != nil {
+		if res.StatusCode == base.StatusOK {
+			c.vodToStart.start()
+		}
+		c.vodToStart = nil
+	}
 }
 
 // OnDescribe is called by rtspServer.
@@ -190,7 +321,10 @@ func (c *rtspConn) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.
 	res := c.pathManager.OnDescribe(pathDescribeReq{
 		PathName: ctx.Path,
 		URL:      ctx.Req.URL,
+		// ctx.Query is always empty in DESCRIBE requests
+		Query:    ctx.Req.URL.RawQuery,
 		IP:       c.ip(),
+		ClientCN: c.clientCN,
 		ValidateCredentials: func(pathUser string, pathPass string) error {
 			return c.validateCredentials(pathUser, pathPass, ctx.Path, ctx.Req)
 		},
@@ -212,6 +346,14 @@ func (c *rtspConn) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.
 				StatusCode: base.StatusNotFound,
 			}, nil, res.Err
 
+		case pathErrNotReady:
+			return &base.Response{
+				StatusCode: base.StatusServiceUnavailable,
+				Header: base.Header{
+					"Retry-After": base.HeaderValue{terr.RetryAfterSeconds()},
+				},
+			}, nil, nil
+
 		default:
 			return &base.Response{
 				StatusCode: base.StatusBadRequest,
@@ -219,16 +361,205 @@ func (c *rtspConn) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.
 		}
 	}
 
+	if res.RedirectHook != "" {
+		ur, err := rtspConnRedirectHook(res.RedirectHook, ctx.Path, c.ip())
+		switch {
+		case err == nil:
+			res.Redirect = ur
+
+		case res.Redirect == "":
+			return &base.Response{
+				StatusCode: base.StatusNotFound,
+			}, nil, fmt.Errorf("redirect hook failed: %s", err)
+
+		default:
+			c.log(logger.Warn, "redirect hook failed: %s", err)
+		}
+	}
+
+	// redirects are temporary, in order to prevent clients from caching them
+	// and allow to change the target at any time.
 	if res.Redirect != "" {
 		return &base.Response{
-			StatusCode: base.StatusMovedPermanently,
+			StatusCode: base.StatusFound,
 			Header: base.Header{
 				"Location": base.HeaderValue{res.Redirect},
 			},
 		}, nil, nil
 	}
 
+	pathConf := res.Path.Conf()
+
+	if res.VODFile != "" {
+		tracks, duration, err := rtspVODDescribe(res.VODFile)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return &base.Response{
+					StatusCode: base.StatusNotFound,
+				}, nil, err
+			}
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, nil, err
+		}
+
+		return &base.Response{
+			StatusCode: base.StatusOK,
+			Header: base.Header{
+				"Media-Properties": rtspVODMediaProperties(tracks[0].IsH264()),
+				"Supported":        base.HeaderValue{"play.scale, play.speed"},
+			},
+			Body: rtspConnSDP(tracks, nil, pathConf.Title, pathConf.Description, duration),
+		}, nil, nil
+	}
+
+	// the session description is generated here in order to insert the keys,
+	// that are generated for each reader.
+	if pathConf.SRTP {
+		tracks := res.Stream.sdpTracks(pathConf.SDPPassthrough)
+		var attributes []psdp.Attribute
+		if pathConf.SDPPassthrough {
+			attributes = res.Stream.sessionSDPAttributes()
+		}
+
+		cryptoAttrs, err := rtspSRTPNewCryptoAttributes(len(tracks))
+		if err != nil {
+			return &base.Response{
+				StatusCode: base.StatusInternalServerError,
+			}, nil, err
+		}
+
+		if c.srtpAttributes == nil {
+			c.srtpAttributes = make(map[string][]*srtp.CryptoAttribute)
+		}
+		c.srtpAttributes[res.Path.Name()] = cryptoAttrs
+
+		return &base.Response{
+			StatusCode: base.StatusOK,
+			Body: rtspConnSDP(rtspSRTPTracks(tracks, cryptoAttrs), attributes,
+				pathConf.Title, pathConf.Description, 0),
+		}, nil, nil
+	}
+
+	if pathConf.SDPPassthrough || pathConf.RTPHeaderExtensionsParsed != nil {
+		var attributes []psdp.Attribute
+		if pathConf.SDPPassthrough {
+			attributes = res.Stream.sessionSDPAttributes()
+		}
+
+		return &base.Response{
+			StatusCode: base.StatusOK,
+			Body: rtspConnSDP(res.Stream.sdpTracks(pathConf.SDPPassthrough), attributes,
+				pathConf.Title, pathConf.Description, 0),
+		}, nil, nil
+	}
+
+	if pathConf.Title != "" || pathConf.Description != "" {
+		// the session description is generated here instead of by the library,
+		// in order to insert metadata.
+		return &base.Response{
+			StatusCode: base.StatusOK,
+			Body:       rtspConnSDP(res.Stream.tracks(), nil, pathConf.Title, pathConf.Description, 0),
+		}, nil, nil
+	}
+
 	return &base.Response{
 		StatusCode: base.StatusOK,
 	}, res.Stream.rtspStream, nil
 }
+
+// rtspConnRedirectHook asks an external service the URL which a reader must be
+// redirected to.
+func rtspConnRedirectHook(hook string, pathName string, ip net.IP) (string, error) {
+	u, err := url.Parse(hook)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("path", pathName)
+	q.Set("ip", ip.String())
+	u.RawQuery = q.Encode()
+
+	hc := &http.Client{Timeout: rtspConnRedirectHookTimeout}
+
+	res, err := hc.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	byts, err := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+
+	ur := strings.TrimSpace(string(byts))
+
+	_, err = base.ParseURL(ur)
+	if err != nil {
+		return "", fmt.Errorf("'%s' is not a valid RTSP URL", ur)
+	}
+
+	return ur, nil
+}
+
+// rtspConnSDP encodes tracks into SDP, like gortsplib.Tracks.Write(),
+// with the given session attributes, session name and session information.
+// When the duration is not zero, the range of the stream is added, allowing
+// clients to seek.
+func rtspConnSDP(
+	tracks gortsplib.Tracks,
+	attributes []psdp.Attribute,
+	title string,
+	description string,
+	duration time.Duration,
+) []byte {
+	if title == "" {
+		title = "Stream"
+	}
+
+	sout := &sdp.SessionDescription{
+		SessionName: psdp.SessionName(title),
+		Origin: psdp.Origin{
+			Username:       "-",
+			NetworkType:    "IN",
+			AddressType:    "IP4",
+			UnicastAddress: "127.0.0.1",
+		},
+		// required by Darwin Streaming Server
+		ConnectionInformation: &psdp.ConnectionInformation{
+			NetworkType: "IN",
+			AddressType: "IP4",
+			Address:     &psdp.Address{Address: "0.0.0.0"},
+		},
+		TimeDescriptions: []psdp.TimeDescription{
+			{Timing: psdp.Timing{StartTime: 0, StopTime: 0}},
+		},
+	}
+
+	if description != "" {
+		v := psdp.Information(description)
+		sout.SessionInformation = &v
+	}
+
+	sout.Attributes = append(sout.Attributes, attributes...)
+
+	if duration != 0 {
+		sout.Attributes = append(sout.Attributes, psdp.Attribute{
+			Key:   "range",
+			Value: "npt=0-" + strconv.FormatFloat(duration.Seconds(), 'f', 3, 64),
+		})
+	}
+
+	for _, track := range tracks {
+		sout.MediaDescriptions = append(sout.MediaDescriptions, track.Media)
+	}
+
+	byts, _ := sout.Marshal()
+	return byts
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"fmt"
+	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
 	"github.com/aler9/gortsplib/pkg/headers"
+	"golang.org/x/net/ipv4"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
@@ -28,19 +32,49 @@ type rtspServer struct {
 	isTLS               bool
 	rtspAddress         string
 	protocols           map[conf.Protocol]struct{}
+	forceTCPIPs         []interface{}
+	forceUDPIPs         []interface{}
 	runOnConnect        string
 	runOnConnectRestart bool
+	stats               *stats
 	metrics             *metrics
 	pathManager         *pathManager
 	parent              rtspServerParent
 
-	ctx       context.Context
-	ctxCancel func()
-	wg        sync.WaitGroup
-	srv       *gortsplib.Server
-	mutex     sync.RWMutex
-	conns     map[*gortsplib.ServerConn]*rtspConn
-	sessions  map[*gortsplib.ServerSession]*rtspSession
+	ctx        context.Context
+	ctxCancel  func()
+	wg         sync.WaitGroup
+	srv        *gortsplib.Server
+	certLoader *certLoader
+	mutex      sync.RWMutex
+	conns      map[*gortsplib.ServerConn]*rtspConn
+	netConns   map[net.Conn]*rtspConn
+	sessions   map[*gortsplib.ServerSession]*rtspSession
+}
+
+// rtspListenPacket opens a UDP listener. When the listener is used to send
+// multicast packets, their TTL is set.
+func rtspListenPacket(network string, address string, multicastTTL int) (net.PacketConn, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsMulticast() {
+		err = ipv4.NewPacketConn(pc).SetMulticastTTL(multicastTTL)
+		if err != nil {
+			pc.Close()
+			return nil, err
+		}
+	}
+
+	return pc, nil
 }
 
 func newRTSPServer(
@@ -58,13 +92,22 @@ func newRTSPServer(
 	multicastIPRange string,
 	multicastRTPPort int,
 	multicastRTCPPort int,
+	multicastTTL int,
+	useWebSocket bool,
+	webSocketAddress string,
 	isTLS bool,
 	serverCert string,
 	serverKey string,
+	clientCA string,
+	clientCertRequired bool,
+	acmeManager *acmeManager,
 	rtspAddress string,
 	protocols map[conf.Protocol]struct{},
+	forceTCPIPs []interface{},
+	forceUDPIPs []interface{},
 	runOnConnect string,
 	runOnConnectRestart bool,
+	stats *stats,
 	metrics *metrics,
 	pathManager *pathManager,
 	parent rtspServerParent) (*rtspServer, error) {
@@ -76,12 +119,16 @@ func newRTSPServer(
 		isTLS:       isTLS,
 		rtspAddress: rtspAddress,
 		protocols:   protocols,
+		forceTCPIPs: forceTCPIPs,
+		forceUDPIPs: forceUDPIPs,
+		stats:       stats,
 		metrics:     metrics,
 		pathManager: pathManager,
 		parent:      parent,
 		ctx:         ctx,
 		ctxCancel:   ctxCancel,
 		conns:       make(map[*gortsplib.ServerConn]*rtspConn),
+		netConns:    make(map[net.Conn]*rtspConn),
 		sessions:    make(map[*gortsplib.ServerSession]*rtspSession),
 	}
 
@@ -102,19 +149,63 @@ func newRTSPServer(
 		s.srv.MulticastIPRange = multicastIPRange
 		s.srv.MulticastRTPPort = multicastRTPPort
 		s.srv.MulticastRTCPPort = multicastRTCPPort
+
+		if multicastTTL != 0 {
+			s.srv.ListenPacket = func(network string, address string) (net.PacketConn, error) {
+				return rtspListenPacket(network, address, multicastTTL)
+			}
+		}
 	}
 
-	if isTLS {
-		cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	// connections are wrapped in order to allow paths to override timeouts.
+	s.srv.Listen = func(network string, address string) (net.Listener, error) {
+		var ln net.Listener
+		var err error
+		if useWebSocket {
+			ln, err = newRTSPWebSocketListener(address, webSocketAddress, readTimeout)
+		} else {
+			ln, err = net.Listen(network, address)
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		s.srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		return &rtspTimeoutListener{
+			Listener:     ln,
+			readTimeout:  readTimeout,
+			writeTimeout: writeTimeout,
+		}, nil
+	}
+
+	if isTLS {
+		if acmeManager != nil {
+			s.srv.TLSConfig = &tls.Config{GetCertificate: acmeManager.getCertificate}
+		} else {
+			var err error
+			s.certLoader, err = newCertLoader(serverCert, serverKey, s)
+			if err != nil {
+				return nil, err
+			}
+
+			s.srv.TLSConfig = &tls.Config{GetCertificate: s.certLoader.getCertificate}
+		}
+
+		if clientCA != "" {
+			err := s.setupClientAuth(clientCA, clientCertRequired)
+			if err != nil {
+				if s.certLoader != nil {
+					s.certLoader.close()
+				}
+				return nil, err
+			}
+		}
 	}
 
 	err := s.srv.Start(address)
 	if err != nil {
+		if s.certLoader != nil {
+			s.certLoader.close()
+		}
 		return nil, err
 	}
 
@@ -128,6 +219,10 @@ func newRTSPServer(
 
 	s.Log(logger.Info, "TCP listener opened on %s", address)
 
+	if useWebSocket {
+		s.Log(logger.Info, "WebSocket listener opened on %s", webSocketAddress)
+	}
+
 	if s.metrics != nil {
 		if !isTLS {
 			s.metrics.OnRTSPServerSet(s)
@@ -142,6 +237,42 @@ func newRTSPServer(
 	return s, nil
 }
 
+// setupClientAuth enables the verification of client certificates. The common
+// name of a verified certificate is stored into the related rtspConn.
+func (s *rtspServer) setupClientAuth(clientCA string, clientCertRequired bool) error {
+	pool, err := loadClientCAs(clientCA)
+	if err != nil {
+		return err
+	}
+
+	base := s.srv.TLSConfig
+	base.ClientCAs = pool
+	if clientCertRequired {
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	s.srv.TLSConfig = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mutex.RLock()
+			c := s.netConns[hello.Conn]
+			s.mutex.RUnlock()
+
+			conf := base.Clone()
+			conf.VerifyPeerCertificate = func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+				if c != nil && len(verifiedChains) != 0 && len(verifiedChains[0]) != 0 {
+					c.clientCN = verifiedChains[0][0].Subject.CommonName
+				}
+				return nil
+			}
+			return conf, nil
+		},
+	}
+
+	return nil
+}
+
 func (s *rtspServer) Log(level logger.Level, format string, args ...interface{}) {
 	label := func() string {
 		if s.isTLS {
@@ -188,6 +319,10 @@ outer:
 
 	s.srv.Close()
 
+	if s.certLoader != nil {
+		s.certLoader.close()
+	}
+
 	if s.metrics != nil {
 		if !s.isTLS {
 			s.metrics.OnRTSPServerSet(nil)
@@ -197,6 +332,26 @@ outer:
 	}
 }
 
+// readProtocols returns the protocols that can be used by readers coming
+// from an IP. When the IP is in both forceTCPIPs and forceUDPIPs, the most
+// specific network is used.
+func (s *rtspServer) readProtocols(ip net.IP) map[conf.Protocol]struct{} {
+	tcpLen := ipMatchLength(ip, s.forceTCPIPs)
+	udpLen := ipMatchLength(ip, s.forceUDPIPs)
+
+	if tcpLen < 0 && udpLen < 0 {
+		return s.protocols
+	}
+
+	ret := make(map[conf.Protocol]struct{})
+	for proto := range s.protocols {
+		if (proto == conf.ProtocolTCP) == (tcpLen >= udpLen) {
+			ret[proto] = struct{}{}
+		}
+	}
+	return ret
+}
+
 func (s *rtspServer) newSessionID() (string, error) {
 	for {
 		b := make([]byte, 4)
@@ -233,12 +388,16 @@ func (s *rtspServer) OnConnOpen(ctx *gortsplib.ServerHandlerOnConnOpenCtx) {
 		s.readTimeout,
 		s.runOnConnect,
 		s.runOnConnectRestart,
+		s.stats,
 		s.pathManager,
 		ctx.Conn,
 		s)
 
 	s.mutex.Lock()
 	s.conns[ctx.Conn] = c
+	if s.isTLS {
+		s.netConns[ctx.Conn.NetConn()] = c
+	}
 	s.mutex.Unlock()
 }
 
@@ -247,6 +406,9 @@ func (s *rtspServer) OnConnClose(ctx *gortsplib.ServerHandlerOnConnCloseCtx) {
 	s.mutex.Lock()
 	c := s.conns[ctx.Conn]
 	delete(s.conns, ctx.Conn)
+	if s.isTLS {
+		delete(s.netConns, ctx.Conn.NetConn())
+	}
 	s.mutex.Unlock()
 
 	c.OnClose(ctx.Error)
@@ -279,9 +441,11 @@ func (s *rtspServer) OnSessionOpen(ctx *gortsplib.ServerHandlerOnSessionOpenCtx)
 	se := newRTSPSession(
 		s.rtspAddress,
 		s.protocols,
+		s.readProtocols(ctx.Conn.NetConn().RemoteAddr().(*net.TCPAddr).IP),
 		id,
 		ctx.Session,
 		ctx.Conn,
+		s.stats,
 		s.pathManager,
 		s)
 
@@ -330,9 +494,10 @@ func (s *rtspServer) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Resp
 // OnPlay implements gortsplib.ServerHandlerOnPlay.
 func (s *rtspServer) OnPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
 	s.mutex.RLock()
+	c := s.conns[ctx.Conn]
 	se := s.sessions[ctx.Session]
 	s.mutex.RUnlock()
-	return se.OnPlay(ctx)
+	return se.OnPlay(c, ctx)
 }
 
 // OnRecord implements gortsplib.ServerHandlerOnRecord.
@@ -351,6 +516,41 @@ func (s *rtspServer) OnPause(ctx *gortsplib.ServerHandlerOnPauseCtx) (*base.Resp
 	return se.OnPause(ctx)
 }
 
+// OnSetParameter implements gortsplib.ServerHandlerOnSetParameter.
+func (s *rtspServer) OnSetParameter(ctx *gortsplib.ServerHandlerOnSetParameterCtx) (*base.Response, error) {
+	// the request is not bound to a session; use the session that is
+	// publishing to the path with the same connection.
+	s.mutex.RLock()
+	var se *rtspSession
+	for _, cse := range s.sessions {
+		if cse.author == ctx.Conn && cse.safeState() == gortsplib.ServerSessionStatePublish &&
+			cse.path.Name() == strings.TrimSuffix(ctx.Path, "/") {
+			se = cse
+			break
+		}
+	}
+	s.mutex.RUnlock()
+
+	if se == nil {
+		// requests with an empty body are used as keepalives
+		if len(ctx.Req.Body) == 0 {
+			return &base.Response{
+				StatusCode: base.StatusOK,
+			}, nil
+		}
+
+		// answer with an error without closing the connection, that may
+		// be used by other sessions.
+		s.Log(logger.Warn, "[conn %v] no session is publishing to path '%s' with this connection",
+			ctx.Conn.NetConn().RemoteAddr(), ctx.Path)
+		return &base.Response{
+			StatusCode: base.StatusSessionNotFound,
+		}, nil
+	}
+
+	return se.OnSetParameter(ctx)
+}
+
 // OnFrame implements gortsplib.ServerHandlerOnFrame.
 func (s *rtspServer) OnFrame(ctx *gortsplib.ServerHandlerOnFrameCtx) {
 	s.mutex.RLock()
@@ -389,6 +589,7 @@ func (s *rtspServer) OnAPIRTSPSessionsList(req apiRTSPSessionsListReq) apiRTSPSe
 				}
 				return "idle"
 			}(),
+			Tracks: s.apiTracks(),
 		}
 	}
 
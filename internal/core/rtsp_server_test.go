@@ -3,17 +3,29 @@ package core
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
 	"github.com/aler9/gortsplib/pkg/headers"
+	psdp "github.com/pion/sdp/v3"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/websocket"
+
+	"github.com/aler9/rtsp-simple-server/internal/mpegts"
 )
 
 func mustParseURL(s string) *base.URL {
@@ -536,6 +548,164 @@ func TestRTSPServerPublisherOverride(t *testing.T) {
 	}
 }
 
+func TestRTSPServerPublisherPolicy(t *testing.T) {
+	for _, ca := range []string{
+		"reject",
+		"standby",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			p, ok := newInstance("rtmpDisable: yes\n" +
+				"hlsDisable: yes\n" +
+				"protocols: [tcp]\n" +
+				"paths:\n" +
+				"  all:\n" +
+				"    publisherPolicy: " + ca + "\n")
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			track, err := gortsplib.NewTrackH264(68, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+			require.NoError(t, err)
+
+			s1, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+				gortsplib.Tracks{track})
+			require.NoError(t, err)
+			defer s1.Close()
+
+			if ca == "reject" {
+				_, err = gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+					gortsplib.Tracks{track})
+				require.Error(t, err)
+				return
+			}
+
+			type dialRes struct {
+				conn *gortsplib.ClientConn
+				err  error
+			}
+			done := make(chan dialRes)
+			go func() {
+				conn, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+					gortsplib.Tracks{track})
+				done <- dialRes{conn, err}
+			}()
+
+			select {
+			case <-done:
+				t.Errorf("standby publisher has been accepted while the path is in use")
+			case <-time.After(500 * time.Millisecond):
+			}
+
+			s1.Close()
+
+			res := <-done
+			require.NoError(t, res.err)
+			defer res.conn.Close()
+
+			err = s1.WriteFrame(0, gortsplib.StreamTypeRTP,
+				[]byte{0x01, 0x02, 0x03, 0x04})
+			require.Error(t, err)
+
+			err = res.conn.WriteFrame(0, gortsplib.StreamTypeRTP,
+				[]byte{0x05, 0x06, 0x07, 0x08})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRTSPServerPublishCodecs(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    publishCodecs: [h264]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	t.Run("allowed", func(t *testing.T) {
+		track, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+		require.NoError(t, err)
+
+		source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+			gortsplib.Tracks{track})
+		require.NoError(t, err)
+		source.Close()
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		track1, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+		require.NoError(t, err)
+
+		track2, err := gortsplib.NewTrackAAC(97, []byte{0x12, 0x10})
+		require.NoError(t, err)
+
+		_, err = gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+			gortsplib.Tracks{track1, track2})
+		require.EqualError(t, err, "invalid status code: 415 (Unsupported Media Type)")
+	})
+}
+
+func TestRTSPServerRTPValidation(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"api: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    rtpValidation: drop\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	dest, err := gortsplib.DialRead("rtsp://localhost:8554/teststream")
+	require.NoError(t, err)
+	defer dest.Close()
+
+	valid := []byte{
+		0x80, 0xe0, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0x11, 0x22, 0x33, 0x44,
+		0x65, 0x01, 0x02,
+	}
+
+	readDone := make(chan struct{})
+	frameRecv := make(chan []byte)
+	go func() {
+		defer close(readDone)
+		dest.ReadFrames(func(trackID int, streamType base.StreamType, payload []byte) {
+			if streamType == gortsplib.StreamTypeRTP {
+				frameRecv <- payload
+			}
+		})
+	}()
+
+	err = source.WriteFrame(0, gortsplib.StreamTypeRTP, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	err = source.WriteFrame(0, gortsplib.StreamTypeRTP, valid)
+	require.NoError(t, err)
+
+	require.Equal(t, valid, <-frameRecv)
+
+	var out struct {
+		Items map[string]struct {
+			MalformedRTPPackets int64 `json:"malformedRTPPackets"`
+		} `json:"items"`
+	}
+	err = httpRequest(http.MethodGet, "http://localhost:9997/v1/paths/list", nil, &out)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), out.Items["teststream"].MalformedRTPPackets)
+
+	dest.Close()
+	<-readDone
+}
+
 func TestRTSPServerNonCompliantFrameSize(t *testing.T) {
 	t.Run("publish", func(t *testing.T) {
 		p, ok := newInstance("rtmpDisable: yes\n" +
@@ -688,6 +858,142 @@ func TestRTSPServerRedirect(t *testing.T) {
 	require.Equal(t, 0, cnt2.wait())
 }
 
+func TestRTSPServerRedirectPathName(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  ~^group1/.+$:\n" +
+		"    source: redirect\n" +
+		"    sourceRedirect: rtsp://edge1:8554/$RTSP_PATH\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	err = base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://localhost:8554/group1/cam1"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	}.Write(bconn.Writer)
+	require.NoError(t, err)
+
+	var res base.Response
+	err = res.Read(bconn.Reader)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusFound, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"rtsp://edge1:8554/group1/cam1"}, res.Header["Location"])
+}
+
+func TestRTSPServerRedirectPool(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  mypath:\n" +
+		"    source: redirect\n" +
+		"    sourceRedirect: rtsp://edge1:8554/$RTSP_PATH\n" +
+		"    sourceRedirectPool:\n" +
+		"      - rtsp://edge2:8554/$RTSP_PATH\n" +
+		"      - rtsp://edge3:8554/$RTSP_PATH\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	var locations []string
+
+	for i := 0; i < 6; i++ {
+		err = base.Request{
+			Method: base.Describe,
+			URL:    mustParseURL("rtsp://localhost:8554/mypath"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{strconv.FormatInt(int64(i+1), 10)},
+			},
+		}.Write(bconn.Writer)
+		require.NoError(t, err)
+
+		var res base.Response
+		err = res.Read(bconn.Reader)
+		require.NoError(t, err)
+		require.Equal(t, base.StatusFound, res.StatusCode)
+		locations = append(locations, res.Header["Location"][0])
+	}
+
+	// URLs are used in rotation
+	require.ElementsMatch(t, []string{
+		"rtsp://edge1:8554/mypath",
+		"rtsp://edge2:8554/mypath",
+		"rtsp://edge3:8554/mypath",
+	}, locations[:3])
+	require.Equal(t, locations[:3], locations[3:])
+}
+
+func TestRTSPServerRedirectHook(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("path") != "mypath" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("rtsp://edge9:8554/mypath?ip=" + r.URL.Query().Get("ip") + "\n"))
+	}))
+	defer hook.Close()
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  mypath:\n" +
+		"    source: redirect\n" +
+		"    sourceRedirectHook: " + hook.URL + "/select\n" +
+		"  fallback:\n" +
+		"    source: redirect\n" +
+		"    sourceRedirect: rtsp://edge1:8554/fallback\n" +
+		"    sourceRedirectHook: " + hook.URL + "/select\n" +
+		"  nofallback:\n" +
+		"    source: redirect\n" +
+		"    sourceRedirectHook: " + hook.URL + "/select\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	describe := func(pathName string) *base.Response {
+		err = base.Request{
+			Method: base.Describe,
+			URL:    mustParseURL("rtsp://localhost:8554/" + pathName),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"1"},
+			},
+		}.Write(bconn.Writer)
+		require.NoError(t, err)
+
+		var res base.Response
+		err = res.Read(bconn.Reader)
+		require.NoError(t, err)
+		return &res
+	}
+
+	res := describe("mypath")
+	require.Equal(t, base.StatusFound, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"rtsp://edge9:8554/mypath?ip=127.0.0.1"}, res.Header["Location"])
+
+	res = describe("fallback")
+	require.Equal(t, base.StatusFound, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"rtsp://edge1:8554/fallback"}, res.Header["Location"])
+
+	res = describe("nofallback")
+	require.Equal(t, base.StatusNotFound, res.StatusCode)
+}
+
 func TestRTSPServerFallback(t *testing.T) {
 	for _, ca := range []string{
 		"absolute",
@@ -914,3 +1220,1278 @@ wait
 		}
 	})
 }
+
+func TestRTSPServerPublisherPause(t *testing.T) {
+	p, ok := newInstance("api: yes\n" +
+		"rtmpDisable: yes\n" +
+		"hlsDisable: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	track, err := gortsplib.NewTrackH264(96, []byte("123456"), []byte("123456"))
+	require.NoError(t, err)
+
+	request := func(req base.Request) *base.Response {
+		err := req.Write(bconn.Writer)
+		require.NoError(t, err)
+
+		var res base.Response
+		err = res.Read(bconn.Reader)
+		require.NoError(t, err)
+		return &res
+	}
+
+	res := request(base.Request{
+		Method: base.Announce,
+		URL:    mustParseURL("rtsp://localhost:8554/mypath"),
+		Header: base.Header{
+			"CSeq":         base.HeaderValue{"1"},
+			"Content-Type": base.HeaderValue{"application/sdp"},
+		},
+		Body: gortsplib.Tracks{track}.Write(),
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Read(res.Header["Session"])
+	require.NoError(t, err)
+
+	res = request(base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/mypath"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": base.HeaderValue{sx.Session},
+			"Transport": headers.Transport{
+				Protocol: base.StreamProtocolTCP,
+				Delivery: func() *base.StreamDelivery {
+					v := base.StreamDeliveryUnicast
+					return &v
+				}(),
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModeRecord
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Write(),
+		},
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res = request(base.Request{
+		Method: base.Record,
+		URL:    mustParseURL("rtsp://localhost:8554/mypath"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"3"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	isPaused := func() bool {
+		var out struct {
+			Items map[string]struct {
+				Paused bool `json:"paused"`
+			} `json:"items"`
+		}
+		err := httpRequest(http.MethodGet, "http://localhost:9997/v1/paths/list", nil, &out)
+		require.NoError(t, err)
+		return out.Items["mypath"].Paused
+	}
+
+	setParameter := func(cseq string, body string) *base.Response {
+		return request(base.Request{
+			Method: base.SetParameter,
+			URL:    mustParseURL("rtsp://localhost:8554/mypath"),
+			Header: base.Header{
+				"CSeq":         base.HeaderValue{cseq},
+				"Session":      base.HeaderValue{sx.Session},
+				"Content-Type": base.HeaderValue{"text/parameters"},
+			},
+			Body: []byte(body),
+		})
+	}
+
+	res = setParameter("4", "paused: yes\r\n")
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, true, isPaused())
+
+	res = setParameter("5", "paused: no\r\n")
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, false, isPaused())
+
+	res = setParameter("6", "volume: 10\r\n")
+	require.Equal(t, base.StatusParameterNotUnderstood, res.StatusCode)
+
+	// the API can pause the path too
+	err = httpRequest(http.MethodPost, "http://localhost:9997/v1/paths/pause/mypath", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, true, isPaused())
+
+	err = httpRequest(http.MethodPost, "http://localhost:9997/v1/paths/resume/mypath", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, false, isPaused())
+
+	err = httpRequest(http.MethodPost, "http://localhost:9997/v1/paths/pause/otherpath", nil, nil)
+	require.EqualError(t, err, "bad status code: 404")
+}
+
+func TestRTSPServerSetParameterNoSession(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	request := func(req base.Request) *base.Response {
+		err := req.Write(bconn.Writer)
+		require.NoError(t, err)
+
+		var res base.Response
+		err = res.Read(bconn.Reader)
+		require.NoError(t, err)
+		return &res
+	}
+
+	res := request(base.Request{
+		Method: base.SetParameter,
+		URL:    mustParseURL("rtsp://localhost:8554/mypath"),
+		Header: base.Header{
+			"CSeq":         base.HeaderValue{"1"},
+			"Content-Type": base.HeaderValue{"text/parameters"},
+		},
+		Body: []byte("paused: yes\r\n"),
+	})
+	require.Equal(t, base.StatusSessionNotFound, res.StatusCode)
+
+	// the connection is still open
+	res = request(base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/mypath"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"2"},
+		},
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+}
+
+func TestRTSPServerKeyframeRequest(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    keyframeRequest: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, []byte("123456"), []byte("123456"))
+	require.NoError(t, err)
+
+	c := gortsplib.Client{
+		Protocol: func() *gortsplib.ClientProtocol {
+			v := gortsplib.ClientProtocolTCP
+			return &v
+		}(),
+	}
+
+	source, err := c.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	pliReceived := make(chan []byte, 1)
+	go source.ReadFrames(func(trackID int, streamType gortsplib.StreamType, payload []byte) {
+		// payload-specific feedback message with format 1 (PLI)
+		if streamType == gortsplib.StreamTypeRTCP && len(payload) >= 12 &&
+			payload[1] == 206 && (payload[0]&0x1F) == 1 {
+			select {
+			case pliReceived <- payload:
+			default:
+			}
+		}
+	})
+
+	err = source.WriteFrame(0, gortsplib.StreamTypeRTP,
+		[]byte{0x80, 0x60, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x12, 0x34, 0x56, 0x78, 0x05})
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	reader, err := gortsplib.DialRead("rtsp://localhost:8554/teststream")
+	require.NoError(t, err)
+	defer reader.Close()
+
+	select {
+	case pli := <-pliReceived:
+		// media SSRC
+		require.Equal(t, []byte{0x12, 0x34, 0x56, 0x78}, pli[8:12])
+
+	case <-time.After(2 * time.Second):
+		t.Errorf("keyframe request not received")
+	}
+}
+
+func TestRTSPServerMetadata(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    title: My camera\n" +
+		"    description: Front door\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, []byte("123456"), []byte("123456"))
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	err = base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	}.Write(bconn.Writer)
+	require.NoError(t, err)
+
+	var res base.Response
+	err = res.Read(bconn.Reader)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Contains(t, string(res.Body), "s=My camera\r\n")
+	require.Contains(t, string(res.Body), "i=Front door\r\n")
+
+	tracks, err := gortsplib.ReadTracks(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(tracks))
+
+	reader, err := gortsplib.DialRead("rtsp://localhost:8554/teststream")
+	require.NoError(t, err)
+	reader.Close()
+}
+
+func TestRTSPServerPublishLimits(t *testing.T) {
+	for _, ca := range []string{
+		"maxPublishDuration",
+		"idleCloseAfter",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			reasonFile := filepath.Join(os.TempDir(), "onlimit_reason")
+			defer os.Remove(reasonFile)
+
+			p, ok := newInstance("rtmpDisable: yes\n" +
+				"hlsDisable: yes\n" +
+				"paths:\n" +
+				"  all:\n" +
+				"    " + ca + ": 1s\n" +
+				"    runOnLimit: sh -c 'echo -n $RTSP_REASON > " + reasonFile + "'\n")
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			track, err := gortsplib.NewTrackH264(96, []byte("123456"), []byte("123456"))
+			require.NoError(t, err)
+
+			c := gortsplib.Client{
+				Protocol: func() *gortsplib.ClientProtocol {
+					v := gortsplib.ClientProtocolTCP
+					return &v
+				}(),
+			}
+
+			source, err := c.DialPublish("rtsp://localhost:8554/teststream",
+				gortsplib.Tracks{track})
+			require.NoError(t, err)
+			defer source.Close()
+
+			if ca == "maxPublishDuration" {
+				// a reader doesn't prevent the publisher from being closed
+				reader, err := gortsplib.DialRead("rtsp://localhost:8554/teststream")
+				require.NoError(t, err)
+				defer reader.Close()
+			}
+
+			readDone := make(chan struct{})
+			go func() {
+				defer close(readDone)
+				source.ReadFrames(func(trackID int, streamType gortsplib.StreamType, payload []byte) {})
+			}()
+
+			select {
+			case <-readDone:
+			case <-time.After(3 * time.Second):
+				t.Errorf("publisher not closed")
+			}
+
+			time.Sleep(500 * time.Millisecond)
+
+			byts, err := ioutil.ReadFile(reasonFile)
+			require.NoError(t, err)
+			require.Equal(t, ca, string(byts))
+		})
+	}
+}
+
+func TestRTSPServerOnDemandRetryAfter(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  ondemand:\n" +
+		"    runOnDemand: sleep 10\n" +
+		"    onDemandRetryAfter: 2s\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	describe := func() *base.Response {
+		conn, err := net.Dial("tcp", "127.0.0.1:8554")
+		require.NoError(t, err)
+		defer conn.Close()
+		bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+		err = base.Request{
+			Method: base.Describe,
+			URL:    mustParseURL("rtsp://localhost:8554/ondemand"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"1"},
+			},
+		}.Write(bconn.Writer)
+		require.NoError(t, err)
+
+		var res base.Response
+		err = res.Read(bconn.Reader)
+		require.NoError(t, err)
+		return &res
+	}
+
+	res := describe()
+	require.Equal(t, base.StatusServiceUnavailable, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"2"}, res.Header["Retry-After"])
+
+	track, err := gortsplib.NewTrackH264(96, []byte("123456"), []byte("123456"))
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/ondemand",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	res = describe()
+	require.Equal(t, base.StatusOK, res.StatusCode)
+}
+
+func TestRTSPServerOnDemandForwardQuery(t *testing.T) {
+	queryFile := filepath.Join(os.TempDir(), "ondemand_query")
+	defer os.Remove(queryFile)
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  ondemand:\n" +
+		"    runOnDemand: sh -c 'echo -n $RTSP_QUERY > " + queryFile + "; sleep 10'\n" +
+		"    onDemandRetryAfter: 2s\n" +
+		"    onDemandForwardQuery: yes\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	err = base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://localhost:8554/ondemand?profile=low&user=myuser&pass=mypass"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	}.Write(bconn.Writer)
+	require.NoError(t, err)
+
+	var res base.Response
+	err = res.Read(bconn.Reader)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusServiceUnavailable, res.StatusCode)
+
+	time.Sleep(500 * time.Millisecond)
+
+	byts, err := os.ReadFile(queryFile)
+	require.NoError(t, err)
+	require.Equal(t, "profile=low", string(byts))
+}
+
+func TestRTSPServerMulticastTTL(t *testing.T) {
+	pc, err := rtspListenPacket("udp", "224.0.0.0:8012", 16)
+	require.NoError(t, err)
+	defer pc.Close()
+
+	ttl, err := ipv4.NewPacketConn(pc).MulticastTTL()
+	require.NoError(t, err)
+	require.Equal(t, 16, ttl)
+
+	// unicast listeners are left untouched
+	pc2, err := rtspListenPacket("udp", "127.0.0.1:8014", 16)
+	require.NoError(t, err)
+	defer pc2.Close()
+
+	ttl, err = ipv4.NewPacketConn(pc2).MulticastTTL()
+	require.NoError(t, err)
+	require.Equal(t, 1, ttl)
+}
+
+func TestRTSPServerClientCert(t *testing.T) {
+	caPEM, clientCert := newTestClientCA(t)
+
+	serverCertFpath, err := writeTempFile(serverCert)
+	require.NoError(t, err)
+	defer os.Remove(serverCertFpath)
+
+	serverKeyFpath, err := writeTempFile(serverKey)
+	require.NoError(t, err)
+	defer os.Remove(serverKeyFpath)
+
+	clientCAFpath, err := writeTempFile(caPEM)
+	require.NoError(t, err)
+	defer os.Remove(clientCAFpath)
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"encryption: optional\n" +
+		"serverCert: " + serverCertFpath + "\n" +
+		"serverKey: " + serverKeyFpath + "\n" +
+		"clientCA: " + clientCAFpath + "\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    readClientCNs: [cam1]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	describe := func(certs []tls.Certificate) base.StatusCode {
+		conn, err := tls.Dial("tcp", "localhost:8555", &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+		bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+		err = base.Request{
+			Method: base.Describe,
+			URL:    mustParseURL("rtsps://localhost:8555/teststream"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"1"},
+			},
+		}.Write(bconn.Writer)
+		require.NoError(t, err)
+
+		var res base.Response
+		err = res.Read(bconn.Reader)
+		require.NoError(t, err)
+		return res.StatusCode
+	}
+
+	// the path is not being published, but authorization succeeds
+	require.Equal(t, base.StatusNotFound, describe([]tls.Certificate{clientCert("cam1")}))
+
+	require.Equal(t, base.StatusUnauthorized, describe([]tls.Certificate{clientCert("cam2")}))
+
+	require.Equal(t, base.StatusUnauthorized, describe(nil))
+}
+
+func TestRTSPServerVOD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-vod")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	func() {
+		track, err := gortsplib.NewTrackH264(96,
+			[]byte{0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0, 0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x03, 0x00, 0x3d, 0x08},
+			[]byte{0x68, 0xee, 0x3c, 0x80})
+		require.NoError(t, err)
+
+		f, err := os.Create(filepath.Join(dir, "rec1.ts"))
+		require.NoError(t, err)
+		defer f.Close()
+
+		w, err := mpegts.NewWriter(f, track, nil)
+		require.NoError(t, err)
+
+		// 10 seconds, an IDR every second
+		for i := 0; i < 100; i++ {
+			typ := byte(0x01)
+			if (i % 10) == 0 {
+				typ = 0x05
+			}
+			err := w.WriteH264(time.Duration(i)*100*time.Millisecond, [][]byte{
+				{0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0, 0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x03, 0x00, 0x3d, 0x08},
+				{0x68, 0xee, 0x3c, 0x80},
+				append([]byte{typ}, bytes.Repeat([]byte{0x01}, 500)...),
+			})
+			require.NoError(t, err)
+		}
+	}()
+
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  ~^rec.+$:\n" +
+		"    source: vod\n" +
+		"    sourceFile: " + filepath.Join(dir, "$RTSP_PATH.ts") + "\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	conn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn.Close()
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	// frames are skipped
+	frame := base.InterleavedFrame{Payload: make([]byte, 2048)}
+	readResponse := func() *base.Response {
+		for {
+			var res base.Response
+			what, err := base.ReadInterleavedFrameOrResponse(&frame, &res, bconn.Reader)
+			require.NoError(t, err)
+			if _, ok := what.(*base.Response); ok {
+				return &res
+			}
+		}
+	}
+
+	request := func(req base.Request) *base.Response {
+		err := req.Write(bconn.Writer)
+		require.NoError(t, err)
+		return readResponse()
+	}
+
+	res := request(base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://localhost:8554/rec1"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"2"},
+		},
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Contains(t, string(res.Body), "a=range:npt=0-9.900\r\n")
+	require.Equal(t, base.HeaderValue{"Random-Access, Immutable, Scales=\"[-32:-0.25, 0.25:32]\""}, res.Header["Media-Properties"])
+
+	res = request(base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/rec1/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"3"},
+			"Transport": headers.Transport{
+				Protocol: base.StreamProtocolTCP,
+				Delivery: func() *base.StreamDelivery {
+					v := base.StreamDeliveryUnicast
+					return &v
+				}(),
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Write(),
+		},
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Read(res.Header["Session"])
+	require.NoError(t, err)
+
+	play := func(cseq string, rangeStart time.Duration) (*base.Response, uint16) {
+		res := request(base.Request{
+			Method: base.Play,
+			URL:    mustParseURL("rtsp://localhost:8554/rec1"),
+			Header: base.Header{
+				"CSeq":    base.HeaderValue{cseq},
+				"Session": base.HeaderValue{sx.Session},
+				"Range": headers.Range{
+					Value: &headers.RangeNPT{Start: headers.RangeNPTTime(rangeStart)},
+				}.Write(),
+			},
+		})
+		if res.StatusCode != base.StatusOK {
+			return res, 0
+		}
+
+		var ri headers.RTPInfo
+		err := ri.Read(res.Header["RTP-Info"])
+		require.NoError(t, err)
+
+		// the first frame is the keyframe that precedes the position
+		err = frame.Read(bconn.Reader)
+		require.NoError(t, err)
+		require.Equal(t, 0, frame.Channel)
+		require.Equal(t, *ri[0].SequenceNumber, uint16(frame.Payload[2])<<8|uint16(frame.Payload[3]))
+		require.Equal(t, *ri[0].Timestamp, uint32(frame.Payload[4])<<24|uint32(frame.Payload[5])<<16|
+			uint32(frame.Payload[6])<<8|uint32(frame.Payload[7]))
+
+		return res, *ri[0].SequenceNumber
+	}
+
+	res, seq1 := play("4", 4500*time.Millisecond)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"npt=4-9.9"}, res.Header["Range"])
+
+	// seek while playing
+	res, seq2 := play("5", 8*time.Second)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"npt=8-9.9"}, res.Header["Range"])
+	require.Greater(t, int(seq2-seq1), 0)
+
+	res, _ = play("6", 20*time.Second)
+	require.Equal(t, base.StatusInvalidRange, res.StatusCode)
+
+	// returns the difference between the timestamps of two consecutive frames,
+	// and whether they are both keyframes
+	playRate := func(cseq string, rangeStart time.Duration, name string, value string) (*base.Response, uint32, bool) {
+		res := request(base.Request{
+			Method: base.Play,
+			URL:    mustParseURL("rtsp://localhost:8554/rec1"),
+			Header: base.Header{
+				"CSeq":    base.HeaderValue{cseq},
+				"Session": base.HeaderValue{sx.Session},
+				"Range": headers.Range{
+					Value: &headers.RangeNPT{Start: headers.RangeNPTTime(rangeStart)},
+				}.Write(),
+				name: base.HeaderValue{value},
+			},
+		})
+		if res.StatusCode != base.StatusOK {
+			return res, 0, false
+		}
+
+		var prevTs uint32
+		keyframes := true
+		for i := 0; ; i++ {
+			err = frame.Read(bconn.Reader)
+			require.NoError(t, err)
+			ts := uint32(frame.Payload[4])<<24 | uint32(frame.Payload[5])<<16 |
+				uint32(frame.Payload[6])<<8 | uint32(frame.Payload[7])
+			keyframes = keyframes && (frame.Payload[12]&0x1F) == 0x05
+			if i != 0 && ts != prevTs {
+				return res, ts - prevTs, keyframes
+			}
+			prevTs = ts
+		}
+	}
+
+	res, diff, keyframes := playRate("7", 0, "Scale", "2")
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"2"}, res.Header["Scale"])
+	require.Equal(t, uint32(4500), diff)
+	require.Equal(t, false, keyframes)
+
+	res, diff, _ = playRate("8", 0, "Speed", "2")
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"2"}, res.Header["Speed"])
+	require.Equal(t, uint32(9000), diff)
+
+	// fast-forward with keyframes only
+	res, diff, keyframes = playRate("9", 0, "Scale", "10")
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"10"}, res.Header["Scale"])
+	require.Equal(t, uint32(9000), diff)
+	require.Equal(t, true, keyframes)
+
+	res, _, _ = playRate("10", 0, "Scale", "100")
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"32"}, res.Header["Scale"])
+
+	// rewind with keyframes only
+	res, diff, keyframes = playRate("11", 5*time.Second, "Scale", "-2")
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"-2"}, res.Header["Scale"])
+	require.Equal(t, base.HeaderValue{"npt=5-0"}, res.Header["Range"])
+	require.Equal(t, uint32(45000), diff)
+	require.Equal(t, true, keyframes)
+
+	res, _, _ = playRate("12", 0, "Speed", "-1")
+	require.Equal(t, base.StatusBadRequest, res.StatusCode)
+
+	// missing file
+	conn2, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer conn2.Close()
+	bconn2 := bufio.NewReadWriter(bufio.NewReader(conn2), bufio.NewWriter(conn2))
+
+	err = base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://localhost:8554/rec2"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	}.Write(bconn2.Writer)
+	require.NoError(t, err)
+
+	err = res.Read(bconn2.Reader)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusNotFound, res.StatusCode)
+}
+
+func TestRTSPServerWebSocket(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"rtspWebSocket: yes\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    readIPs: [127.0.0.1/32]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://127.0.0.1:8554/mypath",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	conf, err := websocket.NewConfig("ws://127.0.0.1:8556/", "http://127.0.0.1/")
+	require.NoError(t, err)
+	conf.Protocol = []string{"rtsp"}
+
+	conn, err := websocket.DialConfig(conf)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.PayloadType = websocket.BinaryFrame
+	bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	request := func(req base.Request) *base.Response {
+		err := req.Write(bconn.Writer)
+		require.NoError(t, err)
+
+		var res base.Response
+		err = res.Read(bconn.Reader)
+		require.NoError(t, err)
+		return &res
+	}
+
+	res := request(base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://127.0.0.1:8554/mypath"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res = request(base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://127.0.0.1:8554/mypath/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"2"},
+			"Transport": headers.Transport{
+				Protocol: base.StreamProtocolTCP,
+				Delivery: func() *base.StreamDelivery {
+					v := base.StreamDeliveryUnicast
+					return &v
+				}(),
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Write(),
+		},
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Read(res.Header["Session"])
+	require.NoError(t, err)
+
+	res = request(base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://127.0.0.1:8554/mypath"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"3"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	pkt := []byte{0x80, 0x60, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05}
+	err = source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+	require.NoError(t, err)
+
+	frame := base.InterleavedFrame{Payload: make([]byte, 2048)}
+	err = frame.Read(bconn.Reader)
+	require.NoError(t, err)
+	require.Equal(t, 0, frame.Channel)
+	require.Equal(t, pkt, frame.Payload)
+}
+
+func TestRTSPServerSDPPassthrough(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"paths:\n" +
+		"  passthrough:\n" +
+		"    sdpPassthrough: yes\n" +
+		"  all:\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	for _, pathName := range []string{"passthrough", "default"} {
+		t.Run(pathName, func(t *testing.T) {
+			conn, err := net.Dial("tcp", "127.0.0.1:8554")
+			require.NoError(t, err)
+			defer conn.Close()
+			bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+			request := func(req base.Request) *base.Response {
+				err := req.Write(bconn.Writer)
+				require.NoError(t, err)
+
+				var res base.Response
+				err = res.Read(bconn.Reader)
+				require.NoError(t, err)
+				return &res
+			}
+
+			res := request(base.Request{
+				Method: base.Announce,
+				URL:    mustParseURL("rtsp://localhost:8554/" + pathName),
+				Header: base.Header{
+					"CSeq":         base.HeaderValue{"1"},
+					"Content-Type": base.HeaderValue{"application/sdp"},
+				},
+				Body: []byte("v=0\r\n" +
+					"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+					"s=Stream\r\n" +
+					"c=IN IP4 0.0.0.0\r\n" +
+					"t=0 0\r\n" +
+					"a=x-onvif-session:test\r\n" +
+					"a=range:npt=0-\r\n" +
+					"m=video 0 RTP/AVP 96\r\n" +
+					"a=rtpmap:96 H264/90000\r\n" +
+					"a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z2QAKKzZQHgCJ+VA,aO48gA==\r\n" +
+					"a=control:trackID=0\r\n" +
+					"a=x-klv:descriptor\r\n" +
+					"a=sendonly\r\n"),
+			})
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			var sx headers.Session
+			err = sx.Read(res.Header["Session"])
+			require.NoError(t, err)
+
+			res = request(base.Request{
+				Method: base.Setup,
+				URL:    mustParseURL("rtsp://localhost:8554/" + pathName + "/trackID=0"),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"2"},
+					"Session": base.HeaderValue{sx.Session},
+					"Transport": headers.Transport{
+						Protocol: base.StreamProtocolTCP,
+						Delivery: func() *base.StreamDelivery {
+							v := base.StreamDeliveryUnicast
+							return &v
+						}(),
+						Mode: func() *headers.TransportMode {
+							v := headers.TransportModeRecord
+							return &v
+						}(),
+						InterleavedIDs: &[2]int{0, 1},
+					}.Write(),
+				},
+			})
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			res = request(base.Request{
+				Method: base.Record,
+				URL:    mustParseURL("rtsp://localhost:8554/" + pathName),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"3"},
+					"Session": base.HeaderValue{sx.Session},
+				},
+			})
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			conn2, err := net.Dial("tcp", "127.0.0.1:8554")
+			require.NoError(t, err)
+			defer conn2.Close()
+			bconn2 := bufio.NewReadWriter(bufio.NewReader(conn2), bufio.NewWriter(conn2))
+
+			err = base.Request{
+				Method: base.Describe,
+				URL:    mustParseURL("rtsp://localhost:8554/" + pathName),
+				Header: base.Header{
+					"CSeq": base.HeaderValue{"1"},
+				},
+			}.Write(bconn2.Writer)
+			require.NoError(t, err)
+
+			var dres base.Response
+			err = dres.Read(bconn2.Reader)
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, dres.StatusCode)
+
+			body := string(dres.Body)
+			require.Equal(t, 1, strings.Count(body, "a=control:trackID=0\r\n"))
+			require.NotContains(t, body, "a=sendonly")
+			require.NotContains(t, body, "a=range")
+
+			if pathName == "passthrough" {
+				require.Contains(t, body, "a=x-onvif-session:test\r\n")
+				require.Contains(t, body, "a=x-klv:descriptor\r\n")
+			} else {
+				require.NotContains(t, body, "a=x-onvif-session")
+				require.NotContains(t, body, "a=x-klv")
+			}
+		})
+	}
+}
+
+func TestRTSPServerRTPHeaderExtensions(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    rtpHeaderExtensions: [1]\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+	track.Media.Attributes = append(track.Media.Attributes,
+		psdp.Attribute{Key: "extmap", Value: "1 urn:ietf:params:rtp-hdrext:toffset"},
+		psdp.Attribute{Key: "extmap", Value: "2 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time"})
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	dest, err := gortsplib.DialRead("rtsp://localhost:8554/teststream")
+	require.NoError(t, err)
+	defer dest.Close()
+
+	var extmaps []string
+	for _, attr := range dest.Tracks()[0].Media.Attributes {
+		if attr.Key == "extmap" {
+			extmaps = append(extmaps, attr.Value)
+		}
+	}
+	require.Equal(t, []string{"1 urn:ietf:params:rtp-hdrext:toffset"}, extmaps)
+
+	recv := make(chan []byte)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		dest.ReadFrames(func(trackID int, streamType gortsplib.StreamType, payload []byte) {
+			if streamType == gortsplib.StreamTypeRTP {
+				select {
+				case recv <- append([]byte(nil), payload...):
+				default:
+				}
+			}
+		})
+	}()
+
+	pkt := []byte{
+		0x90, 0x60, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0x11, 0x22, 0x33, 0x44,
+		0xBE, 0xDE, 0x00, 0x02,
+		0x12, 0xAA, 0xBB, 0xCC,
+		0x21, 0x01, 0x02, 0x00,
+		0x05, 0x01, 0x02,
+	}
+
+	var buf []byte
+	for buf == nil {
+		err = source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+		require.NoError(t, err)
+
+		select {
+		case buf = <-recv:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	require.Equal(t, []byte{
+		0x90, 0x60, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0x11, 0x22, 0x33, 0x44,
+		0xBE, 0xDE, 0x00, 0x01,
+		0x12, 0xAA, 0xBB, 0xCC,
+		0x05, 0x01, 0x02,
+	}, buf)
+
+	dest.Close()
+	<-readDone
+}
+
+func TestRTSPServerForceProtocol(t *testing.T) {
+	for _, ca := range []string{
+		"tcp",
+		"udp",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			conf := "rtmpDisable: yes\n" +
+				"hlsDisable: yes\n" +
+				"protocols: [udp, tcp]\n" +
+				"forceTCPIPs: [0.0.0.0/0]\n"
+			if ca == "udp" {
+				// the most specific network takes precedence
+				conf += "forceUDPIPs: [127.0.0.0/8]\n"
+			}
+
+			p, ok := newInstance(conf)
+			require.Equal(t, true, ok)
+			defer p.close()
+
+			track, err := gortsplib.NewTrackH264(96, []byte("123456"), []byte("123456"))
+			require.NoError(t, err)
+
+			// publishers are not affected
+			for _, proto := range []gortsplib.ClientProtocol{
+				gortsplib.ClientProtocolUDP,
+				gortsplib.ClientProtocolTCP,
+			} {
+				c := gortsplib.Client{Protocol: &proto}
+				source, err := c.DialPublish("rtsp://localhost:8554/teststream",
+					gortsplib.Tracks{track})
+				require.NoError(t, err)
+				source.Close()
+			}
+
+			source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+				gortsplib.Tracks{track})
+			require.NoError(t, err)
+			defer source.Close()
+
+			for _, proto := range []gortsplib.ClientProtocol{
+				gortsplib.ClientProtocolUDP,
+				gortsplib.ClientProtocolTCP,
+			} {
+				c := gortsplib.Client{Protocol: &proto}
+				reader, err := c.DialRead("rtsp://localhost:8554/teststream")
+
+				allowed := (proto == gortsplib.ClientProtocolTCP) == (ca == "tcp")
+				if allowed {
+					require.NoError(t, err)
+					reader.Close()
+				} else {
+					require.Error(t, err)
+				}
+			}
+
+			// clients that support both protocols switch to the allowed one
+			reader, err := gortsplib.DialRead("rtsp://localhost:8554/teststream")
+			require.NoError(t, err)
+			reader.Close()
+		})
+	}
+}
+
+func TestRTSPServerPathTimeouts(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"readTimeout: 10s\n" +
+		"paths:\n" +
+		"  slow:\n" +
+		"    readTimeout: 1s\n" +
+		"    sessionTimeout: 20s\n" +
+		"  all:\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	for _, pathName := range []string{"slow", "default"} {
+		t.Run(pathName, func(t *testing.T) {
+			conn, err := net.Dial("tcp", "127.0.0.1:8554")
+			require.NoError(t, err)
+			defer conn.Close()
+			bconn := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+			request := func(req base.Request) *base.Response {
+				err := req.Write(bconn.Writer)
+				require.NoError(t, err)
+
+				var res base.Response
+				err = res.Read(bconn.Reader)
+				require.NoError(t, err)
+				return &res
+			}
+
+			res := request(base.Request{
+				Method: base.Announce,
+				URL:    mustParseURL("rtsp://localhost:8554/" + pathName),
+				Header: base.Header{
+					"CSeq":         base.HeaderValue{"1"},
+					"Content-Type": base.HeaderValue{"application/sdp"},
+				},
+				Body: []byte("v=0\r\n" +
+					"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+					"s=Stream\r\n" +
+					"c=IN IP4 0.0.0.0\r\n" +
+					"t=0 0\r\n" +
+					"m=video 0 RTP/AVP 96\r\n" +
+					"a=rtpmap:96 H264/90000\r\n" +
+					"a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z2QAKKzZQHgCJ+VA,aO48gA==\r\n" +
+					"a=control:trackID=0\r\n"),
+			})
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			var sx headers.Session
+			err = sx.Read(res.Header["Session"])
+			require.NoError(t, err)
+
+			if pathName == "slow" {
+				require.NotNil(t, sx.Timeout)
+				require.Equal(t, uint(20), *sx.Timeout)
+			} else {
+				require.Nil(t, sx.Timeout)
+			}
+
+			res = request(base.Request{
+				Method: base.Setup,
+				URL:    mustParseURL("rtsp://localhost:8554/" + pathName + "/trackID=0"),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"2"},
+					"Session": base.HeaderValue{sx.Session},
+					"Transport": headers.Transport{
+						Protocol: base.StreamProtocolTCP,
+						Delivery: func() *base.StreamDelivery {
+							v := base.StreamDeliveryUnicast
+							return &v
+						}(),
+						Mode: func() *headers.TransportMode {
+							v := headers.TransportModeRecord
+							return &v
+						}(),
+						InterleavedIDs: &[2]int{0, 1},
+					}.Write(),
+				},
+			})
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			res = request(base.Request{
+				Method: base.Record,
+				URL:    mustParseURL("rtsp://localhost:8554/" + pathName),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"3"},
+					"Session": base.HeaderValue{sx.Session},
+				},
+			})
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			// the publisher doesn't send anything, therefore it is disconnected
+			// only when the timeout of the path is shorter than the global one.
+			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			_, err = bconn.Reader.ReadByte()
+			require.Error(t, err)
+
+			ne, ok := err.(net.Error)
+			timedOut := ok && ne.Timeout()
+			require.Equal(t, pathName == "default", timedOut)
+		})
+	}
+}
+
+func TestRTSPServerPublisherGracePeriod(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  all:\n" +
+		"    publisherGracePeriod: 2s\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	track, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	source, err := gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	dest, err := gortsplib.DialRead("rtsp://localhost:8554/teststream")
+	require.NoError(t, err)
+	defer dest.Close()
+
+	recv := make(chan []byte)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		dest.ReadFrames(func(trackID int, streamType gortsplib.StreamType, payload []byte) {
+			if streamType == gortsplib.StreamTypeRTP {
+				select {
+				case recv <- append([]byte(nil), payload...):
+				default:
+				}
+			}
+		})
+	}()
+
+	writeAndRead := func(source *gortsplib.ClientConn, pkt []byte) []byte {
+		for {
+			err := source.WriteFrame(0, gortsplib.StreamTypeRTP, pkt)
+			require.NoError(t, err)
+
+			select {
+			case buf := <-recv:
+				return buf
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	buf := writeAndRead(source, []byte{
+		0x80, 0x60, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0x11, 0x22, 0x33, 0x44,
+		0x05, 0x01, 0x02,
+	})
+	require.Equal(t, uint16(1), binary.BigEndian.Uint16(buf[2:4]))
+
+	source.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	// the publisher comes back with another SSRC and sequence number
+	source, err = gortsplib.DialPublish("rtsp://localhost:8554/teststream",
+		gortsplib.Tracks{track})
+	require.NoError(t, err)
+	defer source.Close()
+
+	buf = writeAndRead(source, []byte{
+		0x80, 0x60, 0x10, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		0x55, 0x66, 0x77, 0x88,
+		0x05, 0x01, 0x02,
+	})
+	require.Equal(t, uint16(2), binary.BigEndian.Uint16(buf[2:4]))
+	require.Equal(t, uint32(0x11223344), binary.BigEndian.Uint32(buf[8:12]))
+	require.Equal(t, []byte{0x05, 0x01, 0x02}, buf[12:])
+
+	// the reader is disconnected when the grace period expires
+	source.Close()
+
+	select {
+	case <-readDone:
+	case <-time.After(5 * time.Second):
+		t.Errorf("reader has not been disconnected")
+	}
+}
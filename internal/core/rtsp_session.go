@@ -4,15 +4,24 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/aler9/gortsplib/pkg/headers"
+	"github.com/aler9/gortsplib/pkg/sdp"
+	psdp "github.com/pion/sdp/v3"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpstats"
+	"github.com/aler9/rtsp-simple-server/internal/srtp"
 )
 
 const (
@@ -29,39 +38,54 @@ type rtspSessionParent interface {
 }
 
 type rtspSession struct {
-	rtspAddress string
-	protocols   map[conf.Protocol]struct{}
-	id          string
-	ss          *gortsplib.ServerSession
-	author      *gortsplib.ServerConn
-	pathManager rtspSessionPathManager
-	parent      rtspSessionParent
+	rtspAddress   string
+	protocols     map[conf.Protocol]struct{}
+	readProtocols map[conf.Protocol]struct{}
+	id            string
+	ss            *gortsplib.ServerSession
+	author        *gortsplib.ServerConn
+	stats         *stats
+	ipStats       *ipStatsEntry
+	pathManager   rtspSessionPathManager
+	parent        rtspSessionParent
 
 	path            *path
+	bandwidth       *bandwidthCounter
 	state           gortsplib.ServerSessionState
 	stateMutex      sync.Mutex
-	setuppedTracks  map[int]*gortsplib.Track // read
-	onReadCmd       *externalcmd.Cmd         // read
-	announcedTracks gortsplib.Tracks         // publish
-	stream          *stream                  // publish
+	setuppedTracks  map[int]*gortsplib.Track  // read
+	onReadCmd       *externalcmd.Cmd          // read
+	vod             *rtspVOD                  // read
+	readStats       map[int]*rtcpstats.Sender // read
+	srtpStream      *gortsplib.ServerStream   // read
+	srtpContexts    map[int]*srtp.Context     // read
+	announcedTracks gortsplib.Tracks          // publish
+	announcedAttrs  []psdp.Attribute          // publish
+	stream          *stream                   // publish
+	publishStats    []*rtcpstats.Receiver     // publish
 }
 
 func newRTSPSession(
 	rtspAddress string,
 	protocols map[conf.Protocol]struct{},
+	readProtocols map[conf.Protocol]struct{},
 	id string,
 	ss *gortsplib.ServerSession,
 	sc *gortsplib.ServerConn,
+	stats *stats,
 	pathManager rtspSessionPathManager,
 	parent rtspSessionParent) *rtspSession {
 	s := &rtspSession{
-		rtspAddress: rtspAddress,
-		protocols:   protocols,
-		id:          id,
-		ss:          ss,
-		author:      sc,
-		pathManager: pathManager,
-		parent:      parent,
+		rtspAddress:   rtspAddress,
+		protocols:     protocols,
+		readProtocols: readProtocols,
+		id:            id,
+		ss:            ss,
+		author:        sc,
+		stats:         stats,
+		ipStats:       stats.IPs.acquire(sc.NetConn().RemoteAddr().(*net.TCPAddr).IP),
+		pathManager:   pathManager,
+		parent:        parent,
 	}
 
 	s.log(logger.Info, "opened by %v", s.author.NetConn().RemoteAddr())
@@ -88,6 +112,41 @@ func (s *rtspSession) safeState() gortsplib.ServerSessionState {
 	return s.state
 }
 
+// apiTracks returns the RTCP statistics of the tracks that are being
+// read or published.
+func (s *rtspSession) apiTracks() []apiRTSPSessionsListTrack {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	ret := []apiRTSPSessionsListTrack{}
+
+	switch s.state {
+	case gortsplib.ServerSessionStatePreRead, gortsplib.ServerSessionStateRead:
+		ids := make([]int, 0, len(s.readStats))
+		for id := range s.readStats {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+
+		for _, id := range ids {
+			ret = append(ret, apiRTSPSessionsListTrack{
+				ID:    id,
+				Stats: s.readStats[id].Stats(),
+			})
+		}
+
+	case gortsplib.ServerSessionStatePublish:
+		for id, st := range s.publishStats {
+			ret = append(ret, apiRTSPSessionsListTrack{
+				ID:    id,
+				Stats: st.Stats(),
+			})
+		}
+	}
+
+	return ret
+}
+
 // RemoteAddr returns the remote address of the author of the session.
 func (s *rtspSession) RemoteAddr() net.Addr {
 	return s.author.NetConn().RemoteAddr()
@@ -112,6 +171,10 @@ func (s *rtspSession) OnClose() {
 		}
 	}
 
+	if s.vod != nil {
+		s.vod.close()
+	}
+
 	switch s.ss.State() {
 	case gortsplib.ServerSessionStatePreRead, gortsplib.ServerSessionStateRead:
 		s.path.OnReaderRemove(pathReaderRemoveReq{Author: s})
@@ -122,17 +185,44 @@ func (s *rtspSession) OnClose() {
 		s.path = nil
 	}
 
+	if s.srtpStream != nil {
+		s.srtpStream.Close()
+	}
+
+	s.stats.IPs.release(s.ipStats)
+
+	if s.bandwidth != nil {
+		s.stats.Bandwidth.release(s.bandwidth)
+	}
+
 	s.log(logger.Info, "closed")
 }
 
+// setBandwidth sets the counters that are incremented by the session.
+// Counters are acquired again on every SETUP, since each one can point
+// to a different path.
+func (s *rtspSession) setBandwidth(pathName string, user string) {
+	if s.bandwidth != nil {
+		s.stats.Bandwidth.release(s.bandwidth)
+	}
+	s.bandwidth = s.stats.Bandwidth.acquire(pathName, user)
+}
+
 // OnAnnounce is called by rtspServer.
 func (s *rtspSession) OnAnnounce(c *rtspConn, ctx *gortsplib.ServerHandlerOnAnnounceCtx) (*base.Response, error) {
+	user := ""
 	res := s.pathManager.OnPublisherAnnounce(pathPublisherAnnounceReq{
 		Author:   s,
 		PathName: ctx.Path,
+		Tracks:   ctx.Tracks,
 		IP:       ctx.Conn.NetConn().RemoteAddr().(*net.TCPAddr).IP,
+		ClientCN: c.clientCN,
 		ValidateCredentials: func(pathUser string, pathPass string) error {
-			return c.validateCredentials(pathUser, pathPass, ctx.Path, ctx.Req)
+			err := c.validateCredentials(pathUser, pathPass, ctx.Path, ctx.Req)
+			if err == nil {
+				user = rtspConnRequestUser(ctx.Req)
+			}
+			return err
 		},
 	})
 
@@ -147,6 +237,11 @@ func (s *rtspSession) OnAnnounce(c *rtspConn, ctx *gortsplib.ServerHandlerOnAnno
 
 			return terr.Response, errors.New(terr.Message)
 
+		case pathErrCodecNotAllowed:
+			return &base.Response{
+				StatusCode: base.StatusUnsupportedMediaType,
+			}, res.Err
+
 		default:
 			return &base.Response{
 				StatusCode: base.StatusBadRequest,
@@ -155,7 +250,15 @@ func (s *rtspSession) OnAnnounce(c *rtspConn, ctx *gortsplib.ServerHandlerOnAnno
 	}
 
 	s.path = res.Path
+	s.setBandwidth(res.Path.Name(), user)
 	s.announcedTracks = ctx.Tracks
+	c.applyPathTimeouts(res.Path.Conf())
+
+	// session attributes are not provided by the library.
+	var desc sdp.SessionDescription
+	if desc.Unmarshal(ctx.Req.Body) == nil {
+		s.announcedAttrs = desc.Attributes
+	}
 
 	s.stateMutex.Lock()
 	s.state = gortsplib.ServerSessionStatePrePublish
@@ -168,21 +271,27 @@ func (s *rtspSession) OnAnnounce(c *rtspConn, ctx *gortsplib.ServerHandlerOnAnno
 
 // OnSetup is called by rtspServer.
 func (s *rtspSession) OnSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	protocols := s.protocols
+	switch s.ss.State() {
+	case gortsplib.ServerSessionStateInitial, gortsplib.ServerSessionStatePreRead:
+		protocols = s.readProtocols
+	}
+
 	if ctx.Transport.Protocol == base.StreamProtocolUDP {
-		if _, ok := s.protocols[conf.ProtocolUDP]; !ok {
+		if _, ok := protocols[conf.ProtocolUDP]; !ok {
 			return &base.Response{
 				StatusCode: base.StatusUnsupportedTransport,
 			}, nil, nil
 		}
 
 		if ctx.Transport.Delivery != nil && *ctx.Transport.Delivery == base.StreamDeliveryMulticast {
-			if _, ok := s.protocols[conf.ProtocolMulticast]; !ok {
+			if _, ok := protocols[conf.ProtocolMulticast]; !ok {
 				return &base.Response{
 					StatusCode: base.StatusUnsupportedTransport,
 				}, nil, nil
 			}
 		}
-	} else if _, ok := s.protocols[conf.ProtocolTCP]; !ok {
+	} else if _, ok := protocols[conf.ProtocolTCP]; !ok {
 		return &base.Response{
 			StatusCode: base.StatusUnsupportedTransport,
 		}, nil, nil
@@ -190,12 +299,19 @@ func (s *rtspSession) OnSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 
 	switch s.ss.State() {
 	case gortsplib.ServerSessionStateInitial, gortsplib.ServerSessionStatePreRead: // play
+		user := ""
 		res := s.pathManager.OnReaderSetupPlay(pathReaderSetupPlayReq{
 			Author:   s,
 			PathName: ctx.Path,
+			Query:    ctx.Query,
 			IP:       ctx.Conn.NetConn().RemoteAddr().(*net.TCPAddr).IP,
+			ClientCN: c.clientCN,
 			ValidateCredentials: func(pathUser string, pathPass string) error {
-				return c.validateCredentials(pathUser, pathPass, ctx.Path, ctx.Req)
+				err := c.validateCredentials(pathUser, pathPass, ctx.Path, ctx.Req)
+				if err == nil {
+					user = rtspConnRequestUser(ctx.Req)
+				}
+				return err
 			},
 		})
 
@@ -215,6 +331,14 @@ func (s *rtspSession) OnSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 					StatusCode: base.StatusNotFound,
 				}, nil, res.Err
 
+			case pathErrNotReady:
+				return &base.Response{
+					StatusCode: base.StatusServiceUnavailable,
+					Header: base.Header{
+						"Retry-After": base.HeaderValue{terr.RetryAfterSeconds()},
+					},
+				}, nil, nil
+
 			default:
 				return &base.Response{
 					StatusCode: base.StatusBadRequest,
@@ -222,9 +346,83 @@ func (s *rtspSession) OnSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 			}
 		}
 
+		if c.srtpSetup != res.Path.Conf().SRTP {
+			return &base.Response{
+				StatusCode: base.StatusUnsupportedTransport,
+			}, nil, nil
+		}
+
 		s.path = res.Path
+		s.setBandwidth(res.Path.Name(), user)
+		c.applyPathTimeouts(res.Path.Conf())
+
+		var rtspStream *gortsplib.ServerStream
+		var senderReports []*rtcpstats.SenderReports
+
+		if c.srtpSetup {
+			// frames are encrypted with the keys of the session, and are
+			// written into a dedicated stream.
+			if ctx.Transport.Delivery != nil && *ctx.Transport.Delivery == base.StreamDeliveryMulticast {
+				return &base.Response{
+					StatusCode: base.StatusUnsupportedTransport,
+				}, nil, nil
+			}
+
+			cryptoAttrs := c.srtpAttributes[res.Path.Name()]
+			if ctx.TrackID >= len(cryptoAttrs) || ctx.TrackID >= len(res.Stream.tracks()) {
+				return &base.Response{
+					StatusCode: base.StatusBadRequest,
+				}, nil, fmt.Errorf("the key of track %d is unknown, since the stream has not been described"+
+					" on this connection", ctx.TrackID)
+			}
+
+			srtpCtx, err := srtp.NewContext(cryptoAttrs[ctx.TrackID].MasterKey, cryptoAttrs[ctx.TrackID].MasterSalt)
+			if err != nil {
+				return &base.Response{
+					StatusCode: base.StatusInternalServerError,
+				}, nil, err
+			}
+
+			if s.srtpStream == nil {
+				s.srtpStream = gortsplib.NewServerStream(res.Stream.tracks())
+				s.srtpContexts = make(map[int]*srtp.Context)
+			}
+			s.srtpContexts[ctx.TrackID] = srtpCtx
+
+			rtspStream = s.srtpStream
+			senderReports = res.Stream.senderReports
+		} else if res.VODFile != "" {
+			// the file is played independently to each session,
+			// therefore multicast can't be used.
+			if ctx.Transport.Delivery != nil && *ctx.Transport.Delivery == base.StreamDeliveryMulticast {
+				return &base.Response{
+					StatusCode: base.StatusUnsupportedTransport,
+				}, nil, nil
+			}
+
+			if s.vod == nil {
+				vod, err := newRTSPVOD(res.VODFile, s)
+				if err != nil {
+					if os.IsNotExist(err) {
+						return &base.Response{
+							StatusCode: base.StatusNotFound,
+						}, nil, err
+					}
+					return &base.Response{
+						StatusCode: base.StatusBadRequest,
+					}, nil, err
+				}
+				s.vod = vod
+			}
+
+			rtspStream = s.vod.stream
+			senderReports = s.vod.senderReports
+		} else {
+			rtspStream = res.Stream.rtspStream
+			senderReports = res.Stream.senderReports
+		}
 
-		if ctx.TrackID >= len(res.Stream.tracks()) {
+		if ctx.TrackID >= len(rtspStream.Tracks()) {
 			return &base.Response{
 				StatusCode: base.StatusBadRequest,
 			}, nil, fmt.Errorf("track %d does not exist", ctx.TrackID)
@@ -233,17 +431,30 @@ func (s *rtspSession) OnSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 		if s.setuppedTracks == nil {
 			s.setuppedTracks = make(map[int]*gortsplib.Track)
 		}
-		s.setuppedTracks[ctx.TrackID] = res.Stream.tracks()[ctx.TrackID]
+		track := rtspStream.Tracks()[ctx.TrackID]
+		s.setuppedTracks[ctx.TrackID] = track
+
+		clockRate, _ := track.ClockRate()
 
 		s.stateMutex.Lock()
+		if s.readStats == nil {
+			s.readStats = make(map[int]*rtcpstats.Sender)
+		}
+		s.readStats[ctx.TrackID] = rtcpstats.NewSender(clockRate, senderReports[ctx.TrackID])
 		s.state = gortsplib.ServerSessionStatePreRead
 		s.stateMutex.Unlock()
 
 		return &base.Response{
 			StatusCode: base.StatusOK,
-		}, res.Stream.rtspStream, nil
+		}, rtspStream, nil
 
 	default: // record
+		if c.srtpSetup {
+			return &base.Response{
+				StatusCode: base.StatusUnsupportedTransport,
+			}, nil, nil
+		}
+
 		return &base.Response{
 			StatusCode: base.StatusOK,
 		}, nil, nil
@@ -251,11 +462,22 @@ func (s *rtspSession) OnSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 }
 
 // OnPlay is called by rtspServer.
-func (s *rtspSession) OnPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+func (s *rtspSession) OnPlay(c *rtspConn, ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
 	h := make(base.Header)
 
+	if s.vod != nil {
+		res, err := s.onPlayVOD(c, ctx, h)
+		if res.StatusCode != base.StatusOK {
+			return res, err
+		}
+	}
+
 	if s.ss.State() == gortsplib.ServerSessionStatePreRead {
-		s.path.OnReaderPlay(pathReaderPlayReq{Author: s})
+		if s.vod != nil {
+			s.OnReaderAccepted()
+		} else {
+			s.path.OnReaderPlay(pathReaderPlayReq{Author: s})
+		}
 
 		if s.path.Conf().RunOnRead != "" {
 			_, port, _ := net.SplitHostPort(s.rtspAddress)
@@ -276,11 +498,103 @@ func (s *rtspSession) OnPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Respo
 	}, nil
 }
 
+// onPlayVOD seeks into the file, if requested by the Range header, and
+// fills the Range and RTP-Info headers.
+func (s *rtspSession) onPlayVOD(c *rtspConn, ctx *gortsplib.ServerHandlerOnPlayCtx, h base.Header) (*base.Response, error) {
+	var pos *time.Duration
+
+	if v, ok := ctx.Req.Header["Range"]; ok {
+		var ra headers.Range
+		err := ra.Read(v)
+		if err != nil {
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, err
+		}
+
+		npt, ok := ra.Value.(*headers.RangeNPT)
+		if !ok {
+			return &base.Response{
+				StatusCode: base.StatusNotImplemented,
+			}, fmt.Errorf("only NPT ranges are supported")
+		}
+
+		start := time.Duration(npt.Start)
+		if start < 0 || start > s.vod.duration() {
+			return &base.Response{
+				StatusCode: base.StatusInvalidRange,
+			}, nil
+		}
+
+		pos = &start
+	}
+
+	// trick play, that allows greater and negative scales, requires a video track
+	maxScale := float64(rtspVODMaxRate)
+	if s.vod.trickPlaySupported() {
+		maxScale = rtspVODMaxTrickScale
+	}
+
+	// rates are reset to the normal ones when headers are not provided
+	rates := []float64{1, 1}
+	for i, ca := range []struct {
+		name    string
+		maxRate float64
+		reverse bool
+	}{
+		{"Scale", maxScale, s.vod.trickPlaySupported()},
+		{"Speed", rtspVODMaxRate, false},
+	} {
+		v, ok := ctx.Req.Header[ca.name]
+		if !ok {
+			continue
+		}
+
+		rate, err := rtspVODParseRate(v, ca.maxRate, ca.reverse)
+		if err != nil {
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, fmt.Errorf("invalid %s: %s", ca.name, err)
+		}
+
+		rates[i] = rate
+		h[ca.name] = base.HeaderValue{rtspVODFormatRate(rate)}
+	}
+
+	start, ri, err := s.vod.play(pos, rates[0], rates[1], ctx.Req.URL, s.path.Name())
+	if err != nil {
+		return &base.Response{
+			StatusCode: base.StatusInternalServerError,
+		}, err
+	}
+
+	// frames are sent after the response
+	c.vodToStart = s.vod
+
+	// in case of reverse playback, the range ends at the beginning of the file
+	end := headers.RangeNPTTime(s.vod.duration())
+	if rates[0] < 0 {
+		end = 0
+	}
+	h["Range"] = headers.Range{
+		Value: &headers.RangeNPT{
+			Start: headers.RangeNPTTime(start),
+			End:   &end,
+		},
+	}.Write()
+	h["RTP-Info"] = ri.Write()
+
+	return &base.Response{
+		StatusCode: base.StatusOK,
+	}, nil
+}
+
 // OnRecord is called by rtspServer.
 func (s *rtspSession) OnRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.Response, error) {
 	res := s.path.OnPublisherRecord(pathPublisherRecordReq{
-		Author: s,
-		Tracks: s.announcedTracks,
+		Author:        s,
+		Tracks:        s.announcedTracks,
+		SDPAttributes: s.announcedAttrs,
 	})
 	if res.Err != nil {
 		return &base.Response{
@@ -291,6 +605,11 @@ func (s *rtspSession) OnRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.R
 	s.stream = res.Stream
 
 	s.stateMutex.Lock()
+	s.publishStats = make([]*rtcpstats.Receiver, len(s.announcedTracks))
+	for i, t := range s.announcedTracks {
+		clockRate, _ := t.ClockRate()
+		s.publishStats[i] = rtcpstats.NewReceiver(clockRate)
+	}
 	s.state = gortsplib.ServerSessionStatePublish
 	s.stateMutex.Unlock()
 
@@ -307,7 +626,11 @@ func (s *rtspSession) OnPause(ctx *gortsplib.ServerHandlerOnPauseCtx) (*base.Res
 			s.onReadCmd.Close()
 		}
 
-		s.path.OnReaderPause(pathReaderPauseReq{Author: s})
+		if s.vod != nil {
+			s.vod.pause()
+		} else {
+			s.path.OnReaderPause(pathReaderPauseReq{Author: s})
+		}
 
 		s.stateMutex.Lock()
 		s.state = gortsplib.ServerSessionStatePreRead
@@ -326,6 +649,57 @@ func (s *rtspSession) OnPause(ctx *gortsplib.ServerHandlerOnPauseCtx) (*base.Res
 	}, nil
 }
 
+// OnSetParameter is called by rtspServer.
+// The parameter "paused" allows publishers to pause and resume the stream
+// without tearing down the session. Errors are logged instead of being
+// returned, since returning them would close the connection.
+func (s *rtspSession) OnSetParameter(ctx *gortsplib.ServerHandlerOnSetParameterCtx) (*base.Response, error) {
+	for _, line := range strings.Split(string(ctx.Req.Body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "paused" {
+			s.log(logger.Warn, "unsupported parameter: '%s'", line)
+			return &base.Response{
+				StatusCode: base.StatusParameterNotUnderstood,
+			}, nil
+		}
+
+		var paused bool
+		switch strings.TrimSpace(parts[1]) {
+		case "yes", "true":
+			paused = true
+
+		case "no", "false":
+			paused = false
+
+		default:
+			s.log(logger.Warn, "invalid value of parameter 'paused': '%s'", strings.TrimSpace(parts[1]))
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, nil
+		}
+
+		res := s.path.OnSourceSuspend(pathSourceSuspendReq{
+			Author:    s,
+			Suspended: paused,
+		})
+		if res.Err != nil {
+			s.log(logger.Warn, "unable to set parameter 'paused': %s", res.Err)
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, nil
+		}
+	}
+
+	return &base.Response{
+		StatusCode: base.StatusOK,
+	}, nil
+}
+
 // OnReaderAccepted implements reader.
 func (s *rtspSession) OnReaderAccepted() {
 	tracksLen := len(s.ss.SetuppedTracks())
@@ -344,9 +718,40 @@ func (s *rtspSession) OnReaderAccepted() {
 
 // OnReaderFrame implements reader.
 func (s *rtspSession) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if s.srtpStream != nil {
+		srtpCtx, ok := s.srtpContexts[trackID]
+		if !ok {
+			return
+		}
+
+		var err error
+		payload, err = rtspSRTPEncrypt(srtpCtx, streamType, payload)
+		if err != nil {
+			return
+		}
+
+		atomic.AddInt64(s.ipStats.BytesSent, int64(len(payload)))
+		atomic.AddInt64(s.bandwidth.BytesSent, int64(len(payload)))
+		s.srtpStream.WriteFrame(trackID, streamType, payload)
+		return
+	}
+
+	atomic.AddInt64(s.ipStats.BytesSent, int64(len(payload)))
+	atomic.AddInt64(s.bandwidth.BytesSent, int64(len(payload)))
 	s.ss.WriteFrame(trackID, streamType, payload)
 }
 
+// hasOwnStream implements streamOwnStreamReader.
+func (s *rtspSession) hasOwnStream() bool {
+	return s.srtpStream != nil
+}
+
+// onVODFrame implements rtspVODParent.
+func (s *rtspSession) onVODFrame(trackID int, payload []byte) {
+	atomic.AddInt64(s.ipStats.BytesSent, int64(len(payload)))
+	atomic.AddInt64(s.bandwidth.BytesSent, int64(len(payload)))
+}
+
 // OnReaderAPIDescribe implements reader.
 func (s *rtspSession) OnReaderAPIDescribe() interface{} {
 	return struct {
@@ -363,6 +768,11 @@ func (s *rtspSession) OnSourceAPIDescribe() interface{} {
 	}{"rtspsession", s.id}
 }
 
+// OnSourceKeyframeRequest implements sourceKeyframeRequester.
+func (s *rtspSession) OnSourceKeyframeRequest(trackID int, ssrc uint32) {
+	s.ss.WriteFrame(trackID, gortsplib.StreamTypeRTCP, sourcePLI(ssrc))
+}
+
 // OnPublisherAccepted implements publisher.
 func (s *rtspSession) OnPublisherAccepted(tracksLen int) {
 	s.log(logger.Info, "is publishing to path '%s', %d %s with %s",
@@ -379,9 +789,25 @@ func (s *rtspSession) OnPublisherAccepted(tracksLen int) {
 
 // OnFrame is called by rtspServer.
 func (s *rtspSession) OnFrame(ctx *gortsplib.ServerHandlerOnFrameCtx) {
-	if s.ss.State() != gortsplib.ServerSessionStatePublish {
+	switch s.ss.State() {
+	case gortsplib.ServerSessionStateRead:
+		// readers send receiver reports
+		if st, ok := s.readStats[ctx.TrackID]; ok {
+			st.ProcessFrame(time.Now(), ctx.StreamType, ctx.Payload)
+		}
+		return
+
+	case gortsplib.ServerSessionStatePublish:
+
+	default:
 		return
 	}
 
+	if ctx.TrackID < len(s.publishStats) {
+		s.publishStats[ctx.TrackID].ProcessFrame(time.Now(), ctx.StreamType, ctx.Payload)
+	}
+
+	atomic.AddInt64(s.ipStats.BytesReceived, int64(len(ctx.Payload)))
+	atomic.AddInt64(s.bandwidth.BytesReceived, int64(len(ctx.Payload)))
 	s.stream.onFrame(ctx.TrackID, ctx.StreamType, ctx.Payload)
 }
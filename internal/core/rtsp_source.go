@@ -6,72 +6,234 @@ import (
 	"crypto/tls"
 	"encoding/hex"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/aler9/gortsplib/pkg/headers"
+	"github.com/aler9/gortsplib/pkg/liberrors"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/proxydialer"
+	"github.com/aler9/rtsp-simple-server/internal/srtp"
 )
 
 const (
-	rtspSourceRetryPause = 5 * time.Second
+	// when the protocol is chosen automatically, the packet loss is checked
+	// periodically and the source switches to TCP if it exceeds this ratio.
+	rtspSourceLossCheckPeriod = 10 * time.Second
+	rtspSourceMaxLossRatio    = 0.05
 )
 
+func rtspSourceProtocolLabel(proto gortsplib.ClientProtocol) string {
+	switch proto {
+	case gortsplib.ClientProtocolUDP:
+		return "UDP"
+
+	case gortsplib.ClientProtocolMulticast:
+		return "UDP-multicast"
+	}
+	return "TCP"
+}
+
+// rtspSourceLossCounter counts lost RTP packets by looking at sequence numbers.
+type rtspSourceLossCounter struct {
+	mutex    sync.Mutex
+	started  map[int]bool
+	lastSeq  map[int]uint16
+	received uint64
+	lost     uint64
+}
+
+func newRTSPSourceLossCounter() *rtspSourceLossCounter {
+	return &rtspSourceLossCounter{
+		started: make(map[int]bool),
+		lastSeq: make(map[int]uint16),
+	}
+}
+
+func (c *rtspSourceLossCounter) onRTP(trackID int, payload []byte) {
+	if len(payload) < 12 || (payload[0]>>6) != 2 {
+		return
+	}
+	seq := uint16(payload[2])<<8 | uint16(payload[3])
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.received++
+
+	if c.started[trackID] {
+		diff := seq - c.lastSeq[trackID]
+		// ignore duplicated and reordered packets
+		if diff == 0 || diff >= 0x8000 {
+			return
+		}
+		c.lost += uint64(diff - 1)
+	}
+
+	c.started[trackID] = true
+	c.lastSeq[trackID] = seq
+}
+
+// ratio returns the loss ratio since the last call and resets the counters.
+func (c *rtspSourceLossCounter) ratio() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	total := c.received + c.lost
+	var ret float64
+	if total > 0 {
+		ret = float64(c.lost) / float64(total)
+	}
+
+	c.received = 0
+	c.lost = 0
+	return ret
+}
+
+// rtspSourceListenPairs opens a RTP and a RTCP listener for each track,
+// on consecutive ports of the given range.
+func rtspSourceListenPairs(ip net.IP, r *conf.PortRange, count int) ([]net.PacketConn, error) {
+	host := ""
+	if ip != nil {
+		host = ip.String()
+	}
+
+	var ret []net.PacketConn
+	closeAll := func() {
+		for _, pc := range ret {
+			pc.Close()
+		}
+	}
+
+	for port := r.Min + (r.Min % 2); port+1 <= r.Max && len(ret) < count*2; port += 2 {
+		rtpListener, err := net.ListenPacket("udp", net.JoinHostPort(host, strconv.FormatInt(int64(port), 10)))
+		if err != nil {
+			continue
+		}
+
+		rtcpListener, err := net.ListenPacket("udp", net.JoinHostPort(host, strconv.FormatInt(int64(port+1), 10)))
+		if err != nil {
+			rtpListener.Close()
+			continue
+		}
+
+		ret = append(ret, rtpListener, rtcpListener)
+	}
+
+	if len(ret) < count*2 {
+		closeAll()
+		return nil, fmt.Errorf("UDP port range %d-%d is exhausted", r.Min, r.Max)
+	}
+
+	return ret, nil
+}
+
+type rtspSourceKeyframeReq struct {
+	trackID int
+	ssrc    uint32
+}
+
 type rtspSourceParent interface {
 	Log(logger.Level, string, ...interface{})
 	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
 	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	OnSourceStaticSwitch(req pathSourceStaticSwitchReq)
 }
 
 type rtspSource struct {
-	ur              string
+	urs             []string
 	proto           *gortsplib.ClientProtocol
 	anyPortEnable   bool
 	fingerprint     string
 	readTimeout     time.Duration
 	writeTimeout    time.Duration
+	retryBackoff    *sourceRetryBackoff
 	readBufferCount int
 	readBufferSize  int
+	localIP         net.IP
+	portRange       *conf.PortRange
+	dialContext     proxydialer.DialContextFunc
 	wg              *sync.WaitGroup
 	stats           *stats
 	parent          rtspSourceParent
 
 	ctx       context.Context
 	ctxCancel func()
+	ur        string
+	urIdx     int
+	failover  *sourceFailover
+
+	// protocol in use when proto is nil (automatic).
+	// it is kept between reconnections.
+	autoProto        gortsplib.ClientProtocol
+	multicastChecked bool
+
+	// UDP listeners opened in advance, when a port range is set,
+	// and ports in use by the current connection.
+	listenersMutex   sync.Mutex
+	pendingListeners []net.PacketConn
+	udpPorts         []int
+
+	// contexts used to decrypt tracks, when the server uses SRTP.
+	srtpContexts []*srtp.Context
+
+	// in
+	keyframeReq chan rtspSourceKeyframeReq
 }
 
 func newRTSPSource(
 	parentCtx context.Context,
-	ur string,
+	urs []string,
+	failbackInterval time.Duration,
 	proto *gortsplib.ClientProtocol,
 	anyPortEnable bool,
 	fingerprint string,
 	readTimeout time.Duration,
 	writeTimeout time.Duration,
+	retryPause time.Duration,
+	retryMaxPause time.Duration,
 	readBufferCount int,
 	readBufferSize int,
+	localIP net.IP,
+	portRange *conf.PortRange,
+	dialContext proxydialer.DialContextFunc,
 	wg *sync.WaitGroup,
 	stats *stats,
 	parent rtspSourceParent) *rtspSource {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	s := &rtspSource{
-		ur:              ur,
+		urs:             urs,
 		proto:           proto,
 		anyPortEnable:   anyPortEnable,
 		fingerprint:     fingerprint,
 		readTimeout:     readTimeout,
 		writeTimeout:    writeTimeout,
+		retryBackoff:    newSourceRetryBackoff(retryPause, retryMaxPause),
 		readBufferCount: readBufferCount,
 		readBufferSize:  readBufferSize,
+		localIP:         localIP,
+		portRange:       portRange,
+		dialContext:     dialContext,
 		wg:              wg,
 		stats:           stats,
 		parent:          parent,
 		ctx:             ctx,
 		ctxCancel:       ctxCancel,
+		autoProto:       gortsplib.ClientProtocolUDP,
+		keyframeReq:     make(chan rtspSourceKeyframeReq, 8),
+	}
+
+	// URLs after the first one are backups, instead of being used in rotation
+	if failbackInterval != 0 {
+		s.failover = newSourceFailover(urs, failbackInterval)
 	}
 
 	s.log(logger.Info, "started")
@@ -91,18 +253,51 @@ func (s *rtspSource) log(level logger.Level, format string, args ...interface{})
 	s.parent.Log(level, "[rtsp source] "+format, args...)
 }
 
+// nextURL picks the URL used by the next connection attempt,
+// rotating between the available ones.
+func (s *rtspSource) nextURL() {
+	if s.failover != nil {
+		s.ur = s.failover.url()
+		return
+	}
+
+	if len(s.urs) > 1 {
+		s.log(logger.Debug, "using URL %d of %d", s.urIdx+1, len(s.urs))
+	}
+
+	s.ur = s.urs[s.urIdx]
+	s.urIdx = (s.urIdx + 1) % len(s.urs)
+}
+
 func (s *rtspSource) run() {
 	defer s.wg.Done()
 
+	sameURL := false
+
 	for {
+		// when switching protocol, the same URL is used
+		if !sameURL {
+			s.nextURL()
+		}
+
 		ok := func() bool {
-			ok := s.runInner()
+			ok, fallback := s.runInner()
 			if !ok {
 				return false
 			}
 
+			sameURL = fallback
+
+			if fallback {
+				return true
+			}
+
+			if s.failover != nil && !s.failover.next() {
+				return true
+			}
+
 			select {
-			case <-time.After(rtspSourceRetryPause):
+			case <-time.After(s.retryBackoff.next()):
 				return true
 			case <-s.ctx.Done():
 				return false
@@ -116,55 +311,296 @@ func (s *rtspSource) run() {
 	s.ctxCancel()
 }
 
-func (s *rtspSource) runInner() bool {
-	s.log(logger.Debug, "connecting")
+// listenPacket opens UDP listeners on the local IP, if set.
+// When a port range is set, listeners opened in advance are used instead.
+func (s *rtspSource) listenPacket(network string, address string) (net.PacketConn, error) {
+	host, port, err := net.SplitHostPort(address)
 
-	client := &gortsplib.Client{
-		Protocol: s.proto,
-		TLSConfig: &tls.Config{
-			InsecureSkipVerify: true,
-			VerifyConnection: func(cs tls.ConnectionState) error {
-				h := sha256.New()
-				h.Write(cs.PeerCertificates[0].Raw)
-				hstr := hex.EncodeToString(h.Sum(nil))
-				fingerprintLower := strings.ToLower(s.fingerprint)
-
-				if hstr != fingerprintLower {
-					return fmt.Errorf("server fingerprint do not match: expected %s, got %s",
-						fingerprintLower, hstr)
-				}
+	// multicast listeners are bound to the group address
+	if err != nil || host != "" {
+		return net.ListenPacket(network, address)
+	}
+
+	s.listenersMutex.Lock()
+	defer s.listenersMutex.Unlock()
+
+	var pc net.PacketConn
+	if len(s.pendingListeners) > 0 {
+		pc = s.pendingListeners[0]
+		s.pendingListeners = s.pendingListeners[1:]
+	} else {
+		if s.localIP != nil {
+			address = net.JoinHostPort(s.localIP.String(), port)
+		}
+
+		pc, err = net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.udpPorts = append(s.udpPorts, pc.LocalAddr().(*net.UDPAddr).Port)
+	return pc, nil
+}
+
+// resetListeners closes the listeners that have not been used.
+func (s *rtspSource) resetListeners() {
+	s.listenersMutex.Lock()
+	defer s.listenersMutex.Unlock()
+
+	for _, pc := range s.pendingListeners {
+		pc.Close()
+	}
+	s.pendingListeners = nil
+	s.udpPorts = nil
+}
+
+// fallback switches to the next protocol when the protocol is chosen automatically.
+// It returns false if there are no other protocols to try.
+func (s *rtspSource) fallback(reason string) bool {
+	if s.proto != nil || s.autoProto == gortsplib.ClientProtocolTCP {
+		return false
+	}
+
+	prev := s.autoProto
+	if prev == gortsplib.ClientProtocolMulticast {
+		s.autoProto = gortsplib.ClientProtocolUDP
+	} else {
+		s.autoProto = gortsplib.ClientProtocolTCP
+	}
+
+	s.log(logger.Warn, "%s with %s, switching to %s", reason,
+		rtspSourceProtocolLabel(prev), rtspSourceProtocolLabel(s.autoProto))
+	return true
+}
+
+// tracksAdvertiseMulticast checks whether all tracks have a multicast destination.
+func tracksAdvertiseMulticast(tracks gortsplib.Tracks) bool {
+	for _, track := range tracks {
+		ci := track.Media.ConnectionInformation
+		if ci == nil || ci.Address == nil {
+			return false
+		}
+
+		ip := net.ParseIP(strings.Split(ci.Address.Address, "/")[0])
+		if ip == nil || !ip.IsMulticast() {
+			return false
+		}
+	}
+	return len(tracks) > 0
+}
+
+func (s *rtspSource) dialRead(ctx context.Context, client *gortsplib.Client) (*gortsplib.ClientConn, bool, error) {
+	u, err := base.ParseURL(s.ur)
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := client.Dial(u.Scheme, u.Host)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctxHandlerDone := make(chan struct{})
+	defer func() { <-ctxHandlerDone }()
+
+	ctxHandlerTerminate := make(chan struct{})
+	defer close(ctxHandlerTerminate)
+
+	go func() {
+		defer close(ctxHandlerDone)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-ctxHandlerTerminate:
+		}
+	}()
+
+	_, err = conn.Options(u)
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+
+	tracks, baseURL, _, err := conn.Describe(u)
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+
+	s.srtpContexts, err = rtspSRTPSourceContexts(tracks)
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+
+	if s.proto == nil && u.Scheme != "rtsps" {
+		if !s.multicastChecked {
+			s.multicastChecked = true
+			if s.autoProto == gortsplib.ClientProtocolUDP && tracksAdvertiseMulticast(tracks) {
+				s.autoProto = gortsplib.ClientProtocolMulticast
+			}
+		}
+
+		proto := s.autoProto
+		client.Protocol = &proto
+	}
+
+	if s.portRange != nil &&
+		((s.proto == nil && u.Scheme != "rtsps" && *client.Protocol == gortsplib.ClientProtocolUDP) ||
+			(s.proto != nil && *s.proto == gortsplib.ClientProtocolUDP)) {
+		listeners, err := rtspSourceListenPairs(s.localIP, s.portRange, len(tracks))
+		if err != nil {
+			conn.Close()
+			return nil, false, err
+		}
+
+		s.listenersMutex.Lock()
+		s.pendingListeners = listeners
+		s.listenersMutex.Unlock()
+	}
+
+	// from now on, errors are caused by the protocol
+	for _, track := range tracks {
+		_, err := conn.Setup(headers.TransportModePlay, baseURL, track, 0, 0)
+		if err != nil {
+			conn.Close()
+			return nil, true, err
+		}
+	}
+
+	_, err = conn.Play(nil)
+	if err != nil {
+		conn.Close()
+		return nil, true, err
+	}
+
+	return conn, false, nil
+}
+
+func rtspSourceTLSConfig(fingerprint string) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			h := sha256.New()
+			h.Write(cs.PeerCertificates[0].Raw)
+			hstr := hex.EncodeToString(h.Sum(nil))
+			fingerprintLower := strings.ToLower(fingerprint)
+
+			if hstr != fingerprintLower {
+				return fmt.Errorf("server fingerprint do not match: expected %s, got %s",
+					fingerprintLower, hstr)
+			}
 
-				return nil
-			},
+			return nil
 		},
+	}
+}
+
+// rtspSourceProbe checks whether a stream is available, by describing it.
+func rtspSourceProbe(
+	ctx context.Context,
+	ur string,
+	fingerprint string,
+	readTimeout time.Duration,
+	writeTimeout time.Duration,
+	dialContext proxydialer.DialContextFunc,
+) error {
+	u, err := base.ParseURL(ur)
+	if err != nil {
+		return err
+	}
+
+	client := &gortsplib.Client{
+		TLSConfig:    rtspSourceTLSConfig(fingerprint),
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		DialContext:  dialContext,
+	}
+
+	conn, err := client.Dial(u.Scheme, u.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctxHandlerDone := make(chan struct{})
+	defer func() { <-ctxHandlerDone }()
+
+	ctxHandlerTerminate := make(chan struct{})
+	defer close(ctxHandlerTerminate)
+
+	go func() {
+		defer close(ctxHandlerDone)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-ctxHandlerTerminate:
+		}
+	}()
+
+	_, err = conn.Options(u)
+	if err != nil {
+		return err
+	}
+
+	_, _, _, err = conn.Describe(u)
+	return err
+}
+
+func (s *rtspSource) probe(ctx context.Context, ur string) error {
+	return rtspSourceProbe(ctx, ur, s.fingerprint, s.readTimeout, s.writeTimeout, s.dialContext)
+}
+
+// runInner returns whether the source must be restarted, and whether
+// it must be restarted immediately with another protocol.
+func (s *rtspSource) runInner() (bool, bool) {
+	s.log(logger.Debug, "connecting")
+
+	defer s.resetListeners()
+
+	client := &gortsplib.Client{
+		Protocol:        s.proto,
+		TLSConfig:       rtspSourceTLSConfig(s.fingerprint),
 		ReadTimeout:     s.readTimeout,
 		WriteTimeout:    s.writeTimeout,
 		ReadBufferCount: s.readBufferCount,
 		ReadBufferSize:  s.readBufferSize,
 		AnyPortEnable:   s.anyPortEnable,
+		DialContext:     s.dialContext,
+		ListenPacket:    s.listenPacket,
 		OnRequest: func(req *base.Request) {
+			if req.Method == base.Setup && s.srtpContexts != nil {
+				if v, ok := rtspTransportSetProfile(req.Header["Transport"], "AVP", "SAVP"); ok {
+					req.Header["Transport"] = v
+				}
+			}
 			s.log(logger.Debug, "c->s %v", req)
 		},
 		OnResponse: func(res *base.Response) {
 			s.log(logger.Debug, "s->c %v", res)
+			if v, ok := rtspTransportSetProfile(res.Header["Transport"], "SAVP", "AVP"); ok {
+				res.Header["Transport"] = v
+			}
 		},
 	}
 
 	innerCtx, innerCtxCancel := context.WithCancel(context.Background())
 
 	var conn *gortsplib.ClientConn
+	var protoErr bool
 	var err error
 	dialDone := make(chan struct{})
 	go func() {
 		defer close(dialDone)
-		conn, err = client.DialReadContext(innerCtx, s.ur)
+		conn, protoErr, err = s.dialRead(innerCtx, client)
 	}()
 
 	select {
 	case <-s.ctx.Done():
 		innerCtxCancel()
 		<-dialDone
-		return false
+		return false, false
 
 	case <-dialDone:
 		innerCtxCancel()
@@ -172,46 +608,149 @@ func (s *rtspSource) runInner() bool {
 
 	if err != nil {
 		s.log(logger.Info, "ERR: %s", err)
-		return true
+		if protoErr {
+			return true, s.fallback("unable to setup the stream")
+		}
+		return true, false
 	}
 
-	s.log(logger.Info, "ready")
+	if client.Protocol != nil {
+		s.log(logger.Info, "ready, using %s", rtspSourceProtocolLabel(*client.Protocol))
+	} else {
+		s.log(logger.Info, "ready")
+	}
+
+	if ports := s.apiUDPPorts(); ports != nil {
+		s.log(logger.Info, "receiving on UDP ports %v", ports)
+	}
 
 	res := s.parent.OnSourceStaticSetReady(pathSourceStaticSetReadyReq{
 		Tracks: conn.Tracks(),
 	})
 	if res.Err != nil {
 		s.log(logger.Info, "ERR: %s", err)
-		return true
+		return true, false
 	}
 
+	s.retryBackoff.reset()
+
 	defer func() {
 		s.parent.OnSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{Source: s})
 	}()
 
+	var failbackC <-chan struct{}
+	if s.failover != nil {
+		if reason := s.failover.onReady(); reason != "" {
+			s.log(logger.Warn, "%s, using %s", reason, s.failover.label())
+			s.parent.OnSourceStaticSwitch(pathSourceStaticSwitchReq{URL: s.ur, Reason: reason})
+		}
+
+		if s.failover.usingBackup() {
+			probeCtx, probeCtxCancel := context.WithCancel(s.ctx)
+			defer probeCtxCancel()
+			failbackC = s.failover.probePrimary(probeCtx, s.probe, func(err error) {
+				s.log(logger.Debug, "primary URL is not available: %s", err)
+			})
+		}
+	}
+
+	checkLoss := s.proto == nil && s.autoProto != gortsplib.ClientProtocolTCP
+	lossCounter := newRTSPSourceLossCounter()
+	srtpContexts := s.srtpContexts
+
+	if srtpContexts != nil {
+		s.log(logger.Info, "tracks are encrypted with SRTP")
+	}
+
 	readErr := make(chan error)
 	go func() {
 		readErr <- conn.ReadFrames(func(trackID int, streamType gortsplib.StreamType, payload []byte) {
+			if srtpContexts != nil {
+				var err error
+				payload, err = rtspSRTPDecrypt(srtpContexts[trackID], streamType, payload)
+				if err != nil {
+					// packets that can't be authenticated are discarded.
+					return
+				}
+			}
+
+			if checkLoss && streamType == gortsplib.StreamTypeRTP {
+				lossCounter.onRTP(trackID, payload)
+			}
 			res.Stream.onFrame(trackID, streamType, payload)
 		})
 	}()
 
+	var lossCheckC <-chan time.Time
+	if checkLoss {
+		lossCheckTicker := time.NewTicker(rtspSourceLossCheckPeriod)
+		defer lossCheckTicker.Stop()
+		lossCheckC = lossCheckTicker.C
+	}
+
+	for {
+		select {
+		case <-lossCheckC:
+			ratio := lossCounter.ratio()
+			if ratio > rtspSourceMaxLossRatio {
+				conn.Close()
+				<-readErr
+				return true, s.fallback(fmt.Sprintf("packet loss is %.1f%%", ratio*100))
+			}
+
+		case req := <-s.keyframeReq:
+			err := conn.WriteFrame(req.trackID, gortsplib.StreamTypeRTCP, sourcePLI(req.ssrc))
+			if err != nil {
+				s.log(logger.Debug, "unable to request a keyframe: %s", err)
+			}
+
+		case <-failbackC:
+			s.log(logger.Info, "primary URL is available again")
+			conn.Close()
+			<-readErr
+			s.failover.failback()
+			return true, false
+
+		case <-s.ctx.Done():
+			conn.Close()
+			<-readErr
+			return false, false
+
+		case err := <-readErr:
+			s.log(logger.Info, "ERR: %s", err)
+			conn.Close()
+			if _, ok := err.(liberrors.ErrClientNoUDPPacketsRecently); ok {
+				return true, s.fallback("no packets received")
+			}
+			return true, false
+		}
+	}
+}
+
+// OnSourceKeyframeRequest implements sourceKeyframeRequester.
+func (s *rtspSource) OnSourceKeyframeRequest(trackID int, ssrc uint32) {
+	// requests are discarded when the source is busy, since
+	// the path must not be blocked.
 	select {
-	case <-s.ctx.Done():
-		conn.Close()
-		<-readErr
-		return false
+	case s.keyframeReq <- rtspSourceKeyframeReq{trackID, ssrc}:
+	default:
+	}
+}
 
-	case err := <-readErr:
-		s.log(logger.Info, "ERR: %s", err)
-		conn.Close()
-		return true
+func (s *rtspSource) apiUDPPorts() []int {
+	s.listenersMutex.Lock()
+	defer s.listenersMutex.Unlock()
+
+	if len(s.udpPorts) == 0 {
+		return nil
 	}
+	return append([]int(nil), s.udpPorts...)
 }
 
 // OnSourceAPIDescribe implements source.
-func (*rtspSource) OnSourceAPIDescribe() interface{} {
+func (s *rtspSource) OnSourceAPIDescribe() interface{} {
 	return struct {
-		Type string `json:"type"`
-	}{"rtspSource"}
+		Type     string `json:"type"`
+		UDPPorts []int  `json:"udpPorts,omitempty"`
+	}{"rtspSource", s.apiUDPPorts()}
 }
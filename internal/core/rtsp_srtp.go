@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/base"
+	psdp "github.com/pion/sdp/v3"
+
+	"github.com/aler9/rtsp-simple-server/internal/srtp"
+)
+
+// rtspTransportSetProfile replaces the profile of a Transport header (i.e. AVP
+// in RTP/AVP/TCP). The SRTP profile (SAVP) is not supported by the library,
+// therefore it is replaced with the plain one when requests and responses
+// are processed by the library, and restored when they are sent.
+// It returns false if the header doesn't use the given profile.
+func rtspTransportSetProfile(v base.HeaderValue, from string, to string) (base.HeaderValue, bool) {
+	if len(v) != 1 {
+		return v, false
+	}
+
+	spec := v[0]
+	params := ""
+	if i := strings.IndexByte(spec, ';'); i >= 0 {
+		spec, params = spec[:i], spec[i:]
+	}
+
+	parts := strings.Split(spec, "/")
+	if len(parts) < 2 || parts[0] != "RTP" || parts[1] != from {
+		return v, false
+	}
+
+	parts[1] = to
+	return base.HeaderValue{strings.Join(parts, "/") + params}, true
+}
+
+func rtspTrackIsSRTP(track *gortsplib.Track) bool {
+	for _, proto := range track.Media.MediaName.Protos {
+		if proto == "SAVP" {
+			return true
+		}
+	}
+	return false
+}
+
+// rtspSRTPSourceContexts returns the contexts needed to decrypt the tracks of
+// a source, by using the keys that are advertised in the session description.
+// It returns nil if tracks are not encrypted.
+func rtspSRTPSourceContexts(tracks gortsplib.Tracks) ([]*srtp.Context, error) {
+	secure := 0
+	for _, track := range tracks {
+		if rtspTrackIsSRTP(track) {
+			secure++
+		}
+	}
+
+	if secure == 0 {
+		return nil, nil
+	}
+
+	if secure != len(tracks) {
+		return nil, fmt.Errorf("tracks that use SRTP and tracks that don't use it can't be mixed")
+	}
+
+	ret := make([]*srtp.Context, len(tracks))
+
+	for i, track := range tracks {
+		for _, attr := range track.Media.Attributes {
+			if attr.Key != "crypto" {
+				continue
+			}
+
+			var ca srtp.CryptoAttribute
+			if ca.Unmarshal(attr.Value) != nil {
+				continue
+			}
+
+			ctx, err := srtp.NewContext(ca.MasterKey, ca.MasterSalt)
+			if err != nil {
+				return nil, err
+			}
+
+			ret[i] = ctx
+			break
+		}
+
+		if ret[i] == nil {
+			return nil, fmt.Errorf("track %d uses SRTP, but no key with the %s suite has been provided",
+				i, srtp.CryptoSuite)
+		}
+	}
+
+	return ret, nil
+}
+
+// rtspSRTPTracks returns a copy of tracks that uses the SRTP profile and
+// contains the given keys.
+func rtspSRTPTracks(tracks gortsplib.Tracks, attrs []*srtp.CryptoAttribute) gortsplib.Tracks {
+	ret := make(gortsplib.Tracks, len(tracks))
+
+	for i, track := range tracks {
+		md := *track.Media
+		md.MediaName.Protos = []string{"RTP", "SAVP"}
+		md.Attributes = append(append([]psdp.Attribute(nil), md.Attributes...), psdp.Attribute{
+			Key:   "crypto",
+			Value: attrs[i].Marshal(),
+		})
+		ret[i] = &gortsplib.Track{Media: &md}
+	}
+
+	return ret
+}
+
+func rtspSRTPNewCryptoAttributes(n int) ([]*srtp.CryptoAttribute, error) {
+	ret := make([]*srtp.CryptoAttribute, n)
+	for i := range ret {
+		var err error
+		ret[i], err = srtp.NewCryptoAttribute()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func rtspSRTPEncrypt(ctx *srtp.Context, streamType gortsplib.StreamType, payload []byte) ([]byte, error) {
+	if streamType == gortsplib.StreamTypeRTP {
+		return ctx.EncryptRTP(payload)
+	}
+	return ctx.EncryptRTCP(payload)
+}
+
+func rtspSRTPDecrypt(ctx *srtp.Context, streamType gortsplib.StreamType, payload []byte) ([]byte, error) {
+	if streamType == gortsplib.StreamTypeRTP {
+		return ctx.DecryptRTP(payload)
+	}
+	return ctx.DecryptRTCP(payload)
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// rtspTimeoutConn is a net.Conn whose deadlines, that are set by the RTSP server
+// with the global timeouts, can be moved in order to use the timeouts of a path.
+type rtspTimeoutConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	readOffset   int64 // atomic
+	writeOffset  int64 // atomic
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *rtspTimeoutConn) SetReadDeadline(t time.Time) error {
+	if !t.IsZero() {
+		t = t.Add(time.Duration(atomic.LoadInt64(&c.readOffset)))
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *rtspTimeoutConn) SetWriteDeadline(t time.Time) error {
+	if !t.IsZero() {
+		t = t.Add(time.Duration(atomic.LoadInt64(&c.writeOffset)))
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// setTimeouts sets the timeouts of the connection. Zero means the global timeout.
+func (c *rtspTimeoutConn) setTimeouts(readTimeout time.Duration, writeTimeout time.Duration) {
+	var readOffset time.Duration
+	if readTimeout != 0 {
+		readOffset = readTimeout - c.readTimeout
+	}
+
+	var writeOffset time.Duration
+	if writeTimeout != 0 {
+		writeOffset = writeTimeout - c.writeTimeout
+	}
+
+	atomic.StoreInt64(&c.readOffset, int64(readOffset))
+	atomic.StoreInt64(&c.writeOffset, int64(writeOffset))
+}
+
+// rtspTimeoutListener is a net.Listener that returns rtspTimeoutConns.
+type rtspTimeoutListener struct {
+	net.Listener
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+// Accept implements net.Listener.
+func (l *rtspTimeoutListener) Accept() (net.Conn, error) {
+	nconn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rtspTimeoutConn{
+		Conn:         nconn,
+		readTimeout:  l.readTimeout,
+		writeTimeout: l.writeTimeout,
+	}, nil
+}
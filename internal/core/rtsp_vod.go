@@ -0,0 +1,404 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/aler9/gortsplib/pkg/headers"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/mpegts"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpsenderset"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpstats"
+)
+
+// range of the playback rates, that are set by readers with the Scale and Speed headers.
+const (
+	rtspVODMinRate = 0.25
+	rtspVODMaxRate = 4
+
+	// maximum absolute value of the scale of trick play.
+	rtspVODMaxTrickScale = 32
+)
+
+func rtspVODFormatRate(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// rtspVODMediaProperties returns the Media-Properties header (RFC7826) of files.
+// Trick play is available only when the file contains a video track.
+func rtspVODMediaProperties(trickPlay bool) base.HeaderValue {
+	scales := rtspVODFormatRate(rtspVODMinRate) + ":" + rtspVODFormatRate(rtspVODMaxRate)
+	if trickPlay {
+		scales = rtspVODFormatRate(-rtspVODMaxTrickScale) + ":" + rtspVODFormatRate(-rtspVODMinRate) + ", " +
+			rtspVODFormatRate(rtspVODMinRate) + ":" + rtspVODFormatRate(rtspVODMaxTrickScale)
+	}
+
+	return base.HeaderValue{"Random-Access, Immutable, Scales=\"[" + scales + "]\""}
+}
+
+// rtspVODParseRate parses the value of a Scale or Speed header, and returns
+// the closest supported rate.
+func rtspVODParseRate(v base.HeaderValue, maxRate float64, reverse bool) (float64, error) {
+	if len(v) != 1 {
+		return 0, fmt.Errorf("value not provided")
+	}
+
+	rate, err := strconv.ParseFloat(v[0], 64)
+	if err != nil || rate == 0 {
+		return 0, fmt.Errorf("invalid value (%v)", v[0])
+	}
+
+	if rate < 0 && !reverse {
+		return 0, fmt.Errorf("reverse playback is not supported")
+	}
+
+	abs := math.Abs(rate)
+	if abs < rtspVODMinRate {
+		abs = rtspVODMinRate
+	} else if abs > maxRate {
+		abs = maxRate
+	}
+	return math.Copysign(abs, rate), nil
+}
+
+// rtspVODIsTrickPlay checks whether a scale requires trick play, in which
+// only keyframes are sent, since sending all frames would require too much
+// bandwidth or would be impossible, as in case of reverse playback.
+func rtspVODIsTrickPlay(scale float64) bool {
+	return scale < 0 || scale > rtspVODMaxRate
+}
+
+type rtspVODParent interface {
+	log(logger.Level, string, ...interface{})
+	onVODFrame(trackID int, payload []byte)
+}
+
+type rtspVODTrack struct {
+	trackID   int
+	clockRate int
+	initialTs uint32
+	nextSeq   uint16
+	encode    func([][]byte, time.Duration) ([][]byte, error)
+}
+
+// rtspVOD plays a file to a single RTSP session, that can pause it and seek into it.
+type rtspVOD struct {
+	parent rtspVODParent
+
+	reader        *mpegts.Reader
+	stream        *gortsplib.ServerStream
+	rtcpSenders   *rtcpsenderset.RTCPSenderSet
+	senderReports []*rtcpstats.SenderReports
+	videoTrack    *rtspVODTrack
+	audioTrack    *rtspVODTrack
+	created       time.Time
+
+	// playback state, accessed by play() only when the playback routine is stopped
+	position  time.Duration
+	startPTS  time.Duration
+	startTime time.Time
+	startBase time.Duration
+	lastBase  time.Duration
+	scale     float64
+	speed     float64
+	pending   *mpegts.Frame
+
+	// in case of reverse playback, whether the reader has to be moved
+	// to the keyframe that precedes the current position.
+	seekBackward bool
+
+	mutex     sync.Mutex
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+func newRTSPVOD(fpath string, parent rtspVODParent) (*rtspVOD, error) {
+	reader, err := mpegts.OpenReader(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &rtspVOD{
+		parent:  parent,
+		reader:  reader,
+		created: time.Now(),
+	}
+
+	videoTrack, audioTrack := reader.Tracks()
+	var tracks gortsplib.Tracks
+
+	if videoTrack != nil {
+		t := newRTSPVODTrack(len(tracks), 90000)
+		enc := rtph264.NewEncoder(96, &t.nextSeq, nil, &t.initialTs)
+		t.encode = enc.Encode
+		v.videoTrack = t
+		tracks = append(tracks, videoTrack)
+	}
+
+	if audioTrack != nil {
+		clockRate, _ := audioTrack.ClockRate()
+		t := newRTSPVODTrack(len(tracks), clockRate)
+		enc := rtpaac.NewEncoder(97, clockRate, &t.nextSeq, nil, &t.initialTs)
+		t.encode = enc.Encode
+		v.audioTrack = t
+		tracks = append(tracks, audioTrack)
+	}
+
+	v.stream = gortsplib.NewServerStream(tracks)
+
+	v.senderReports = make([]*rtcpstats.SenderReports, len(tracks))
+	for i := range v.senderReports {
+		v.senderReports[i] = rtcpstats.NewSenderReports()
+	}
+
+	v.rtcpSenders = rtcpsenderset.New(tracks, func(trackID int, streamType gortsplib.StreamType, payload []byte) {
+		v.senderReports[trackID].ProcessFrame(time.Now(), streamType, payload)
+		v.stream.WriteFrame(trackID, streamType, payload)
+	})
+
+	return v, nil
+}
+
+func newRTSPVODTrack(trackID int, clockRate int) *rtspVODTrack {
+	return &rtspVODTrack{
+		trackID:   trackID,
+		clockRate: clockRate,
+		initialTs: rand.Uint32(),
+		nextSeq:   uint16(rand.Uint32()),
+	}
+}
+
+// rtspVODDescribe returns the tracks and the duration of a file.
+func rtspVODDescribe(fpath string) (gortsplib.Tracks, time.Duration, error) {
+	reader, err := mpegts.OpenReader(fpath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reader.Close()
+
+	videoTrack, audioTrack := reader.Tracks()
+	var tracks gortsplib.Tracks
+	if videoTrack != nil {
+		tracks = append(tracks, videoTrack)
+	}
+	if audioTrack != nil {
+		tracks = append(tracks, audioTrack)
+	}
+
+	// set control attributes
+	stream := gortsplib.NewServerStream(tracks)
+	defer stream.Close()
+
+	return stream.Tracks(), reader.Duration(), nil
+}
+
+func (v *rtspVOD) close() {
+	v.pause()
+	v.rtcpSenders.Close()
+	v.stream.Close()
+	v.reader.Close()
+}
+
+func (v *rtspVOD) duration() time.Duration {
+	return v.reader.Duration()
+}
+
+func (v *rtspVOD) trickPlaySupported() bool {
+	return v.videoTrack != nil
+}
+
+// play prepares the playback from the given position, or from the current
+// position if it is nil. It returns the actual starting position, that is
+// the position of the previous keyframe, and the RTP-Info header.
+// The scale changes the rate of both the timestamps and the delivery of frames,
+// while the speed changes only the rate of the delivery. In case of trick play,
+// only keyframes are sent, and they are sent backwards when the scale is negative.
+// Frames are sent after start() is called.
+func (v *rtspVOD) play(pos *time.Duration, scale float64, speed float64,
+	reqURL *base.URL, pathName string) (time.Duration, headers.RTPInfo, error) {
+	v.pause()
+
+	v.scale = scale
+	v.speed = speed
+
+	if pos != nil {
+		start, err := v.reader.Seek(*pos)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		v.position = start
+		v.pending = nil
+	}
+
+	// after a seek, the reader is already on the keyframe to send.
+	v.seekBackward = (pos == nil)
+
+	v.startPTS = v.position
+
+	// RTP timestamps follow the wall clock, in order to remain
+	// monotonic across pauses and seeks. When the speed is greater than one,
+	// they can be ahead of the wall clock.
+	v.startTime = time.Now()
+	v.startBase = v.startTime.Sub(v.created)
+	if v.startBase < v.lastBase {
+		v.startBase = v.lastBase
+	}
+
+	var ri headers.RTPInfo
+
+	for _, t := range []*rtspVODTrack{v.videoTrack, v.audioTrack} {
+		if t == nil {
+			continue
+		}
+
+		u := &base.URL{
+			Scheme: reqURL.Scheme,
+			User:   reqURL.User,
+			Host:   reqURL.Host,
+			Path:   "/" + pathName + "/trackID=" + strconv.FormatInt(int64(t.trackID), 10),
+		}
+
+		seq := t.nextSeq
+		ts := t.initialTs + uint32(v.startBase.Seconds()*float64(t.clockRate))
+
+		ri = append(ri, &headers.RTPInfoEntry{
+			URL:            u.String(),
+			SequenceNumber: &seq,
+			Timestamp:      &ts,
+		})
+	}
+
+	return v.startPTS, ri, nil
+}
+
+// start starts sending frames. It must be called after the response to PLAY
+// has been sent, since frames written before are discarded.
+func (v *rtspVOD) start() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.done != nil {
+		return
+	}
+
+	v.terminate = make(chan struct{})
+	v.done = make(chan struct{})
+
+	go v.run(v.terminate, v.done)
+}
+
+// pause stops sending frames. The position is kept.
+func (v *rtspVOD) pause() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.done == nil {
+		return
+	}
+
+	close(v.terminate)
+	<-v.done
+	v.done = nil
+}
+
+func (v *rtspVOD) run(terminate chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	trickPlay := rtspVODIsTrickPlay(v.scale)
+	scale := math.Abs(v.scale)
+
+	for {
+		fr := v.pending
+		if fr == nil {
+			var err error
+			fr, err = v.readFrame()
+			if err != nil {
+				if err != io.EOF {
+					v.parent.log(logger.Warn, "unable to read file: %s", err)
+				}
+				return
+			}
+		}
+		v.pending = fr
+		v.position = fr.PTS
+
+		if trickPlay && (!fr.Video || !fr.SyncPoint) {
+			v.pending = nil
+			continue
+		}
+
+		rel := fr.PTS - v.startPTS
+		if v.scale < 0 {
+			rel = -rel
+		}
+		if rel < 0 {
+			rel = 0
+		}
+
+		t := time.NewTimer(time.Until(v.startTime.Add(time.Duration(float64(rel) / (scale * v.speed)))))
+		select {
+		case <-t.C:
+		case <-terminate:
+			t.Stop()
+			return
+		}
+
+		v.pending = nil
+
+		track := v.audioTrack
+		if fr.Video {
+			track = v.videoTrack
+		}
+
+		ts := v.startBase + time.Duration(float64(rel)/scale)
+		v.lastBase = ts
+
+		pkts, err := track.encode(fr.Data, ts)
+		if err != nil {
+			v.parent.log(logger.Warn, "unable to encode frame: %s", err)
+			continue
+		}
+
+		for _, pkt := range pkts {
+			track.nextSeq = binary.BigEndian.Uint16(pkt[2:4]) + 1
+			v.rtcpSenders.OnFrame(track.trackID, gortsplib.StreamTypeRTP, pkt)
+			v.stream.WriteFrame(track.trackID, gortsplib.StreamTypeRTP, pkt)
+			v.parent.onVODFrame(track.trackID, pkt)
+		}
+	}
+}
+
+// readFrame reads the next frame. In case of reverse playback, it reads
+// the keyframe that precedes the current position.
+func (v *rtspVOD) readFrame() (*mpegts.Frame, error) {
+	if v.scale > 0 {
+		return v.reader.Read()
+	}
+
+	if v.seekBackward {
+		start, err := v.reader.Seek(v.position - 1)
+		if err != nil {
+			return nil, err
+		}
+
+		// the beginning of the file has been reached
+		if start >= v.position {
+			return nil, io.EOF
+		}
+	}
+	v.seekBackward = true
+
+	return v.reader.Read()
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// rtspWebSocketConn is a RTSP connection tunneled over WebSocket.
+type rtspWebSocketConn struct {
+	*websocket.Conn
+	remoteAddr *net.TCPAddr
+	closeOnce  sync.Once
+	done       chan struct{}
+}
+
+// RemoteAddr implements net.Conn. The address of the client is returned in place
+// of the WebSocket origin, since the RTSP server needs its IP.
+func (c *rtspWebSocketConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// Close implements net.Conn.
+func (c *rtspWebSocketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return c.Conn.Close()
+}
+
+// rtspWebSocketListener is a net.Listener that accepts both plain RTSP connections
+// and RTSP connections tunneled over WebSocket, in which the RTSP protocol
+// (including interleaved frames) is transported into binary messages.
+type rtspWebSocketListener struct {
+	tcpLn      net.Listener
+	wsLn       net.Listener
+	httpServer *http.Server
+
+	ctx       context.Context
+	ctxCancel func()
+	wg        sync.WaitGroup
+	conns     chan net.Conn
+	acceptErr chan error
+}
+
+func newRTSPWebSocketListener(
+	tcpAddress string,
+	wsAddress string,
+	readTimeout time.Duration,
+) (*rtspWebSocketListener, error) {
+	tcpLn, err := net.Listen("tcp", tcpAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	wsLn, err := net.Listen("tcp", wsAddress)
+	if err != nil {
+		tcpLn.Close()
+		return nil, err
+	}
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	l := &rtspWebSocketListener{
+		tcpLn:     tcpLn,
+		wsLn:      wsLn,
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+		conns:     make(chan net.Conn),
+		acceptErr: make(chan error),
+	}
+
+	l.httpServer = &http.Server{
+		Handler: websocket.Server{
+			Handshake: l.handshake,
+			Handler:   l.handleConn,
+		},
+		ReadHeaderTimeout: readTimeout,
+	}
+
+	l.wg.Add(2)
+	go l.runTCP()
+	go l.runWebSocket()
+
+	return l, nil
+}
+
+// Accept implements net.Listener.
+func (l *rtspWebSocketListener) Accept() (net.Conn, error) {
+	select {
+	case nconn := <-l.conns:
+		return nconn, nil
+
+	case err := <-l.acceptErr:
+		return nil, err
+
+	case <-l.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// Close implements net.Listener.
+func (l *rtspWebSocketListener) Close() error {
+	l.ctxCancel()
+	l.httpServer.Close()
+	l.tcpLn.Close()
+	l.wg.Wait()
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *rtspWebSocketListener) Addr() net.Addr {
+	return l.tcpLn.Addr()
+}
+
+func (l *rtspWebSocketListener) runTCP() {
+	defer l.wg.Done()
+
+	for {
+		nconn, err := l.tcpLn.Accept()
+		if err != nil {
+			select {
+			case l.acceptErr <- err:
+			case <-l.ctx.Done():
+			}
+			return
+		}
+
+		select {
+		case l.conns <- nconn:
+		case <-l.ctx.Done():
+			nconn.Close()
+			return
+		}
+	}
+}
+
+func (l *rtspWebSocketListener) runWebSocket() {
+	defer l.wg.Done()
+
+	err := l.httpServer.Serve(l.wsLn)
+	if err != http.ErrServerClosed {
+		select {
+		case l.acceptErr <- err:
+		case <-l.ctx.Done():
+		}
+	}
+}
+
+// handshake accepts connections from any origin. When the client offers
+// subprotocols, one of them must be picked, otherwise browsers refuse the connection.
+func (l *rtspWebSocketListener) handshake(config *websocket.Config, req *http.Request) error {
+	for _, proto := range config.Protocol {
+		if proto == "rtsp" {
+			config.Protocol = []string{proto}
+			return nil
+		}
+	}
+
+	if len(config.Protocol) > 1 {
+		config.Protocol = config.Protocol[:1]
+	}
+	return nil
+}
+
+func (l *rtspWebSocketListener) handleConn(ws *websocket.Conn) {
+	remoteAddr, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr)
+	if err != nil {
+		return
+	}
+
+	ws.PayloadType = websocket.BinaryFrame
+
+	// remove deadlines set by the HTTP server
+	ws.SetDeadline(time.Time{})
+
+	c := &rtspWebSocketConn{
+		Conn:       ws,
+		remoteAddr: remoteAddr,
+		done:       make(chan struct{}),
+	}
+
+	select {
+	case l.conns <- c:
+	case <-l.ctx.Done():
+		return
+	}
+
+	// the connection is closed by the WebSocket server when this function returns.
+	select {
+	case <-c.done:
+	case <-l.ctx.Done():
+	}
+}
@@ -1,5 +1,13 @@
 package core
 
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
 // source is an entity that can provide a stream, statically or dynamically.
 type source interface {
 	OnSourceAPIDescribe() interface{}
@@ -10,3 +18,66 @@ type sourceStatic interface {
 	source
 	Close()
 }
+
+// sourceKeyframeRequester is implemented by sources that can be asked to send
+// a keyframe as soon as possible.
+type sourceKeyframeRequester interface {
+	OnSourceKeyframeRequest(trackID int, ssrc uint32)
+}
+
+// sourcePLI returns a RTCP Picture Loss Indication, that asks the sender of
+// a track to send a keyframe.
+func sourcePLI(ssrc uint32) []byte {
+	byts, _ := (&rtcp.PictureLossIndication{MediaSSRC: ssrc}).Marshal()
+	return byts
+}
+
+// sourceRetryBackoff computes the pauses between connection attempts of
+// static sources. The pause is doubled after every failed attempt, up to a
+// maximum, and randomized in order to avoid synchronized reconnections of
+// multiple sources. It is reset when a source becomes ready.
+type sourceRetryBackoff struct {
+	minPause time.Duration
+	maxPause time.Duration
+
+	mutex sync.Mutex
+	cur   time.Duration
+	rand  *rand.Rand
+}
+
+func newSourceRetryBackoff(minPause time.Duration, maxPause time.Duration) *sourceRetryBackoff {
+	return &sourceRetryBackoff{
+		minPause: minPause,
+		maxPause: maxPause,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the pause before the next connection attempt.
+func (b *sourceRetryBackoff) next() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.cur == 0 {
+		b.cur = b.minPause
+	} else {
+		b.cur *= 2
+		if b.cur > b.maxPause {
+			b.cur = b.maxPause
+		}
+	}
+
+	// use half of the pause plus a random value between zero and the other half
+	half := b.cur / 2
+	if half <= 0 {
+		return b.cur
+	}
+	return b.cur - half + time.Duration(b.rand.Int63n(int64(half)+1))
+}
+
+// reset restores the minimum pause.
+func (b *sourceRetryBackoff) reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.cur = 0
+}
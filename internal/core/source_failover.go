@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// sourceFailover picks the URLs used by a static source that has backup URLs.
+// URLs are tried in order: the source switches to the next URL as soon as
+// a connection attempt fails, and waits only when all URLs have been tried.
+// When a backup URL is in use, the primary URL is probed periodically,
+// in order to switch back to it as soon as it is available again.
+type sourceFailover struct {
+	urs              []string
+	failbackInterval time.Duration
+
+	idx         int
+	readyIdx    int
+	ready       bool
+	failingBack bool
+}
+
+func newSourceFailover(urs []string, failbackInterval time.Duration) *sourceFailover {
+	return &sourceFailover{
+		urs:              urs,
+		failbackInterval: failbackInterval,
+	}
+}
+
+// url returns the URL of the current connection attempt.
+func (f *sourceFailover) url() string {
+	return f.urs[f.idx]
+}
+
+// usingBackup returns whether the current connection attempt uses a backup URL.
+func (f *sourceFailover) usingBackup() bool {
+	return f.idx != 0
+}
+
+// label returns a description of the URL of the current connection attempt.
+func (f *sourceFailover) label() string {
+	if f.idx == 0 {
+		return "the primary URL"
+	}
+	return fmt.Sprintf("backup URL %d of %d", f.idx, len(f.urs)-1)
+}
+
+// onReady is called when the source is ready. It returns the reason of the
+// switch ("failover" or "failback"), or an empty string if the URL in use is
+// the same of the previous ready connection.
+func (f *sourceFailover) onReady() string {
+	f.ready = true
+
+	if f.idx == f.readyIdx {
+		return ""
+	}
+	f.readyIdx = f.idx
+
+	if f.idx == 0 {
+		return "failback"
+	}
+	return "failover"
+}
+
+// failback is called when the primary URL is available again.
+func (f *sourceFailover) failback() {
+	f.failingBack = true
+}
+
+// next moves to the URL of the next connection attempt, and returns whether
+// the source has to wait before performing it.
+func (f *sourceFailover) next() bool {
+	switch {
+	case f.failingBack:
+		f.failingBack = false
+		f.ready = false
+		f.idx = 0
+		return false
+
+	// after a disconnection, the primary URL is tried first
+	case f.ready:
+		f.ready = false
+		f.idx = 0
+		return true
+	}
+
+	f.idx = (f.idx + 1) % len(f.urs)
+	return f.idx == 0
+}
+
+// probePrimary probes the primary URL periodically, until the context is
+// canceled. The returned channel is closed when the probe succeeds.
+func (f *sourceFailover) probePrimary(
+	ctx context.Context,
+	probe func(ctx context.Context, ur string) error,
+	log func(err error),
+) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		t := time.NewTicker(f.failbackInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				err := probe(ctx, f.urs[0])
+				if err == nil {
+					close(done)
+					return
+				}
+				log(err)
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return done
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+// sourceHealthStatus is the result of the last probe of a source URL.
+type sourceHealthStatus struct {
+	URL       string     `json:"url"`
+	Available bool       `json:"available"`
+	LastCheck *time.Time `json:"lastCheck"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type sourceHealthCheckParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// sourceHealthCheck probes the URLs of a static source periodically,
+// regardless of whether the source is in use, in order to know in advance
+// whether they are available.
+type sourceHealthCheck struct {
+	urs      []string
+	interval time.Duration
+	probe    func(ctx context.Context, ur string) error
+	parent   sourceHealthCheckParent
+
+	ctx       context.Context
+	ctxCancel func()
+	done      chan struct{}
+
+	mutex    sync.Mutex
+	statuses []sourceHealthStatus
+}
+
+func newSourceHealthCheck(
+	parentCtx context.Context,
+	urs []string,
+	interval time.Duration,
+	probe func(ctx context.Context, ur string) error,
+	parent sourceHealthCheckParent) *sourceHealthCheck {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	h := &sourceHealthCheck{
+		urs:       urs,
+		interval:  interval,
+		probe:     probe,
+		parent:    parent,
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+		done:      make(chan struct{}),
+		statuses:  make([]sourceHealthStatus, len(urs)),
+	}
+
+	for i, ur := range urs {
+		h.statuses[i] = sourceHealthStatus{URL: ur}
+	}
+
+	go h.run()
+
+	return h
+}
+
+func (h *sourceHealthCheck) close() {
+	h.ctxCancel()
+	<-h.done
+}
+
+func (h *sourceHealthCheck) run() {
+	defer close(h.done)
+
+	t := time.NewTicker(h.interval)
+	defer t.Stop()
+
+	for {
+		for i, ur := range h.urs {
+			err := h.probe(h.ctx, ur)
+			if h.ctx.Err() != nil {
+				return
+			}
+			h.update(i, err)
+		}
+
+		select {
+		case <-t.C:
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *sourceHealthCheck) update(i int, err error) {
+	now := time.Now()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	st := &h.statuses[i]
+	wasChecked := st.LastCheck != nil
+	wasAvailable := st.Available
+
+	st.LastCheck = &now
+	st.Available = (err == nil)
+	st.Error = ""
+	if err != nil {
+		st.Error = err.Error()
+	}
+
+	switch {
+	case err != nil && (wasAvailable || !wasChecked):
+		h.parent.Log(logger.Warn, "health check: source URL %d of %d is not available: %s",
+			i+1, len(h.urs), err)
+
+	case err == nil && !wasAvailable && wasChecked:
+		h.parent.Log(logger.Info, "health check: source URL %d of %d is available again",
+			i+1, len(h.urs))
+	}
+}
+
+// status returns the result of the last probe of each URL.
+func (h *sourceHealthCheck) status() []sourceHealthStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]sourceHealthStatus(nil), h.statuses...)
+}
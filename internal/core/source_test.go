@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+func TestSourceRetryBackoff(t *testing.T) {
+	b := newSourceRetryBackoff(1*time.Second, 5*time.Second)
+
+	for _, ca := range []time.Duration{
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	} {
+		pause := b.next()
+		require.GreaterOrEqual(t, int64(pause), int64(ca/2))
+		require.LessOrEqual(t, int64(pause), int64(ca))
+	}
+
+	b.reset()
+
+	pause := b.next()
+	require.GreaterOrEqual(t, int64(pause), int64(500*time.Millisecond))
+	require.LessOrEqual(t, int64(pause), int64(1*time.Second))
+}
+
+type testSourceHealthCheckParent struct{}
+
+func (testSourceHealthCheckParent) Log(logger.Level, string, ...interface{}) {}
+
+func TestSourceHealthCheck(t *testing.T) {
+	var secondAlive int32
+
+	h := newSourceHealthCheck(context.Background(), []string{"rtsp://first", "rtsp://second"},
+		100*time.Millisecond,
+		func(ctx context.Context, ur string) error {
+			if ur == "rtsp://second" && atomic.LoadInt32(&secondAlive) == 0 {
+				return fmt.Errorf("unreachable")
+			}
+			return nil
+		},
+		testSourceHealthCheckParent{})
+	defer h.close()
+
+	waitCheck := func(cond func(st []sourceHealthStatus) bool) []sourceHealthStatus {
+		for i := 0; i < 50; i++ {
+			st := h.status()
+			if cond(st) {
+				return st
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal("condition not met")
+		return nil
+	}
+
+	st := waitCheck(func(st []sourceHealthStatus) bool {
+		return st[1].LastCheck != nil
+	})
+	require.Equal(t, "rtsp://first", st[0].URL)
+	require.Equal(t, true, st[0].Available)
+	require.Equal(t, "", st[0].Error)
+	require.Equal(t, false, st[1].Available)
+	require.Equal(t, "unreachable", st[1].Error)
+
+	atomic.StoreInt32(&secondAlive, 1)
+
+	waitCheck(func(st []sourceHealthStatus) bool {
+		return st[1].Available
+	})
+}
@@ -10,12 +10,19 @@ type stats struct {
 	// https://github.com/golang/go/issues/9959
 	CountPublishers *int64
 	CountReaders    *int64
+
+	IPs        *ipStats
+	Bandwidth  *bandwidthCounters
+	HLSViewers *hlsViewers
 }
 
 func newStats() *stats {
 	return &stats{
 		CountPublishers: ptrInt64(),
 		CountReaders:    ptrInt64(),
+		IPs:             newIPStats(),
+		Bandwidth:       newBandwidthCounters(),
+		HLSViewers:      newHLSViewers(),
 	}
 }
 
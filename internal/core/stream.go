@@ -1,19 +1,92 @@
 package core
 
 import (
+	"encoding/binary"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aler9/gortsplib"
+	psdp "github.com/pion/sdp/v3"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpstats"
+	"github.com/aler9/rtsp-simple-server/internal/rtpextfilter"
+	"github.com/aler9/rtsp-simple-server/internal/rtpsanitizer"
+	"github.com/aler9/rtsp-simple-server/internal/rtpsplicer"
+)
+
+const (
+	streamMalformedLogPeriod = 10 * time.Second
 )
 
+// SDP attributes that are generated by the server, and therefore
+// can't be passed through from the source to readers.
+var streamGeneratedSDPAttributes = map[string]struct{}{
+	"control":  {},
+	"range":    {},
+	"rtpmap":   {},
+	"fmtp":     {},
+	"sendonly": {},
+	"recvonly": {},
+	"sendrecv": {},
+	"inactive": {},
+
+	// keys used by the source to encrypt tracks must not be disclosed.
+	"crypto":   {},
+	"key-mgmt": {},
+}
+
+// streamPassthroughSDPAttributes returns the attributes that are not
+// generated by the server.
+func streamPassthroughSDPAttributes(attrs []psdp.Attribute) []psdp.Attribute {
+	var ret []psdp.Attribute
+	for _, attr := range attrs {
+		if _, ok := streamGeneratedSDPAttributes[attr.Key]; !ok {
+			ret = append(ret, attr)
+		}
+	}
+	return ret
+}
+
+// streamMetadataEntry is an entry of the metadata of the source.
+type streamMetadataEntry struct {
+	Key   string
+	Value string
+}
+
+// streamMetadataEntries returns the entries of a metadata map that can be
+// inserted into text-based protocols, sorted by key.
+func streamMetadataEntries(metadata map[string]string) []streamMetadataEntry {
+	var ret []streamMetadataEntry
+	for k, v := range metadata {
+		if k == "" || strings.ContainsAny(k, "\"=\r\n") || strings.ContainsAny(v, "\r\n") {
+			continue
+		}
+		ret = append(ret, streamMetadataEntry{k, v})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+
+	return ret
+}
+
 type streamNonRTSPReadersMap struct {
+	gopCache *streamGOPCache
+
 	mutex sync.RWMutex
 	ma    map[reader]struct{}
 }
 
-func newStreamNonRTSPReadersMap() *streamNonRTSPReadersMap {
+func newStreamNonRTSPReadersMap(gopCache *streamGOPCache) *streamNonRTSPReadersMap {
 	return &streamNonRTSPReadersMap{
-		ma: make(map[reader]struct{}),
+		gopCache: gopCache,
+		ma:       make(map[reader]struct{}),
 	}
 }
 
@@ -26,6 +99,13 @@ func (m *streamNonRTSPReadersMap) close() {
 func (m *streamNonRTSPReadersMap) add(r reader) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+
+	// the cache is sent while holding the lock, in order not to
+	// skip or duplicate frames that are being forwarded.
+	if m.gopCache != nil {
+		m.gopCache.forward(r)
+	}
+
 	m.ma[r] = struct{}{}
 }
 
@@ -39,21 +119,126 @@ func (m *streamNonRTSPReadersMap) forwardFrame(trackID int, streamType gortsplib
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
+	if m.gopCache != nil {
+		m.gopCache.add(trackID, streamType, payload)
+	}
+
 	for c := range m.ma {
 		c.OnReaderFrame(trackID, streamType, payload)
 	}
 }
 
+type streamParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
 type stream struct {
+	rtpValidation conf.RTPValidation
+	bandwidth     *bandwidthCounter // incoming bytes of static sources
+	parent        streamParent
+
 	nonRTSPReaders *streamNonRTSPReadersMap
 	rtspStream     *gortsplib.ServerStream
+	sanitizer      *rtpsanitizer.Sanitizer
+	extFilter      *rtpextfilter.Filter
+	malformedRTP   *int64
+	malformedRTCP  *int64
+	lastLog        *int64
+	latency        *latencyProbe
+	rtspReaders    *int64
+	suspended      *int64
+	ssrcs          []uint32
+	senderReports  []*rtcpstats.SenderReports
+	splicers       []*rtpsplicer.Splicer
+
+	// SDP attributes of the source, of the session and of each track.
+	sdpAttributes      []psdp.Attribute
+	sdpMediaAttributes [][]psdp.Attribute
+
+	// extmap attributes of each track whose header extensions are forwarded.
+	extmapAttributes [][]psdp.Attribute
+
+	// metadata sent by the source, like the one of RTMP publishers.
+	metadata map[string]string
 }
 
-func newStream(tracks gortsplib.Tracks) *stream {
+func newStream(
+	tracks gortsplib.Tracks,
+	sdpAttributes []psdp.Attribute,
+	metadata map[string]string,
+	rtpValidation conf.RTPValidation,
+	rtpHeaderExtensions []int,
+	latencyProbe bool,
+	splice bool,
+	gopCacheSize int,
+	bandwidth *bandwidthCounter,
+	parent streamParent,
+) *stream {
+	var gopCache *streamGOPCache
+	if gopCacheSize > 0 {
+		gopCache = newStreamGOPCache(tracks, gopCacheSize)
+	}
+
 	s := &stream{
-		nonRTSPReaders: newStreamNonRTSPReadersMap(),
+		rtpValidation:  rtpValidation,
+		bandwidth:      bandwidth,
+		parent:         parent,
+		nonRTSPReaders: newStreamNonRTSPReadersMap(gopCache),
 		rtspStream:     gortsplib.NewServerStream(tracks),
+		malformedRTP:   new(int64),
+		malformedRTCP:  new(int64),
+		lastLog:        new(int64),
+		rtspReaders:    new(int64),
+		suspended:      new(int64),
+		ssrcs:          make([]uint32, len(tracks)),
+		senderReports:  make([]*rtcpstats.SenderReports, len(tracks)),
+		metadata:       metadata,
 	}
+
+	for i := range s.senderReports {
+		s.senderReports[i] = rtcpstats.NewSenderReports()
+	}
+
+	if rtpHeaderExtensions != nil {
+		s.extFilter = rtpextfilter.New(rtpHeaderExtensions)
+	}
+
+	s.sdpAttributes = streamPassthroughSDPAttributes(sdpAttributes)
+	s.sdpMediaAttributes = make([][]psdp.Attribute, len(tracks))
+	s.extmapAttributes = make([][]psdp.Attribute, len(tracks))
+	for i, track := range tracks {
+		for _, attr := range streamPassthroughSDPAttributes(track.Media.Attributes) {
+			if s.extFilter != nil {
+				if !s.extFilter.AllowsSDPAttribute(attr.Key, attr.Value) {
+					continue
+				}
+				if attr.Key == "extmap" {
+					s.extmapAttributes[i] = append(s.extmapAttributes[i], attr)
+				}
+			}
+			s.sdpMediaAttributes[i] = append(s.sdpMediaAttributes[i], attr)
+		}
+	}
+
+	if latencyProbe {
+		s.latency = newLatencyProbe()
+	}
+
+	if rtpValidation != conf.RTPValidationNo {
+		s.sanitizer = rtpsanitizer.New(tracks)
+	}
+
+	if splice {
+		s.splicers = make([]*rtpsplicer.Splicer, len(tracks))
+		for i, track := range tracks {
+			clockRate, err := track.ClockRate()
+			if err != nil {
+				clockRate = 90000
+			}
+			s.splicers[i] = rtpsplicer.New(clockRate)
+		}
+	}
+
 	return s
 }
 
@@ -66,21 +251,191 @@ func (s *stream) tracks() gortsplib.Tracks {
 	return s.rtspStream.Tracks()
 }
 
-func (s *stream) readerAdd(r reader) {
+// sdpTracks returns the tracks of the stream, with the SDP attributes of
+// the source that are not generated by the server when passthrough is true,
+// or with the extmap attributes of forwarded header extensions otherwise.
+func (s *stream) sdpTracks(passthrough bool) gortsplib.Tracks {
+	tracks := s.tracks()
+	ret := make(gortsplib.Tracks, len(tracks))
+
+	for i, track := range tracks {
+		attrs := s.extmapAttributes[i]
+		if passthrough {
+			attrs = s.sdpMediaAttributes[i]
+		}
+
+		md := *track.Media
+		md.Attributes = append(append([]psdp.Attribute(nil), md.Attributes...), attrs...)
+		ret[i] = &gortsplib.Track{Media: &md}
+	}
+
+	return ret
+}
+
+// sessionSDPAttributes returns the SDP attributes of the source that are not
+// generated by the server, followed by the metadata of the source, that is
+// inserted as x-metadata attributes.
+func (s *stream) sessionSDPAttributes() []psdp.Attribute {
+	ret := append([]psdp.Attribute(nil), s.sdpAttributes...)
+	for _, e := range streamMetadataEntries(s.metadata) {
+		ret = append(ret, psdp.Attribute{Key: "x-metadata", Value: e.Key + "=" + e.Value})
+	}
+	return ret
+}
+
+// streamTrackFormat returns the attributes of a track that must not change
+// when the stream is spliced.
+func streamTrackFormat(track *gortsplib.Track) string {
+	ret := track.Media.MediaName.Media + " " + strings.Join(track.Media.MediaName.Formats, " ")
+	for _, attr := range track.Media.Attributes {
+		if attr.Key == "rtpmap" || attr.Key == "fmtp" {
+			ret += "\n" + attr.Key + ":" + attr.Value
+		}
+	}
+	return ret
+}
+
+// canSplice returns whether the packets of a source with the given tracks
+// can be appended to the stream.
+func (s *stream) canSplice(tracks gortsplib.Tracks) bool {
+	if s.splicers == nil {
+		return false
+	}
+
+	cur := s.tracks()
+	if len(tracks) != len(cur) {
+		return false
+	}
+
+	for i, track := range tracks {
+		if streamTrackFormat(track) != streamTrackFormat(cur[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// splice notifies the stream that the next frames are produced by another source.
+func (s *stream) splice() {
+	for _, sp := range s.splicers {
+		sp.Splice()
+	}
+}
+
+// ssrc returns the SSRC of the last RTP packet received on a track.
+func (s *stream) ssrc(trackID int) uint32 {
+	return atomic.LoadUint32(&s.ssrcs[trackID])
+}
+
+// streamOwnStreamReader is a RTSP session that writes frames into its own
+// RTSP stream, instead of reading from the RTSP stream of the path.
+type streamOwnStreamReader interface {
+	hasOwnStream() bool
+}
+
+func streamReadsRTSPStream(r reader) bool {
 	if _, ok := r.(pathRTSPSession); !ok {
+		return false
+	}
+
+	or, ok := r.(streamOwnStreamReader)
+	return !ok || !or.hasOwnStream()
+}
+
+func (s *stream) readerAdd(r reader) {
+	if !streamReadsRTSPStream(r) {
 		s.nonRTSPReaders.add(r)
+	} else {
+		atomic.AddInt64(s.rtspReaders, 1)
 	}
 }
 
 func (s *stream) readerRemove(r reader) {
-	if _, ok := r.(pathRTSPSession); !ok {
+	if !streamReadsRTSPStream(r) {
 		s.nonRTSPReaders.remove(r)
+	} else {
+		atomic.AddInt64(s.rtspReaders, -1)
+	}
+}
+
+func (s *stream) onMalformedFrame(trackID int, streamType gortsplib.StreamType, err error) {
+	if streamType == gortsplib.StreamTypeRTP {
+		atomic.AddInt64(s.malformedRTP, 1)
+	} else {
+		atomic.AddInt64(s.malformedRTCP, 1)
+	}
+
+	// do not flood the log when a publisher sends only malformed packets
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(s.lastLog)
+	if now-last >= int64(streamMalformedLogPeriod) &&
+		atomic.CompareAndSwapInt64(s.lastLog, last, now) {
+		s.parent.Log(logger.Warn, "malformed packet on track %d: %s (RTP: %d, RTCP: %d in total)",
+			trackID, err, atomic.LoadInt64(s.malformedRTP), atomic.LoadInt64(s.malformedRTCP))
+	}
+}
+
+// setSuspended sets whether frames are discarded instead of being
+// forwarded to readers.
+func (s *stream) setSuspended(v bool) {
+	if v {
+		atomic.StoreInt64(s.suspended, 1)
+	} else {
+		atomic.StoreInt64(s.suspended, 0)
 	}
 }
 
 func (s *stream) onFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
-	// forward to RTSP readers
-	s.rtspStream.WriteFrame(trackID, streamType, payload)
+	if s.bandwidth != nil {
+		atomic.AddInt64(s.bandwidth.BytesReceived, int64(len(payload)))
+	}
+
+	if atomic.LoadInt64(s.suspended) == 1 {
+		return
+	}
+
+	if s.splicers != nil && trackID < len(s.splicers) {
+		payload = s.splicers[trackID].Process(streamType, payload)
+		if payload == nil {
+			return
+		}
+	}
+
+	if streamType == gortsplib.StreamTypeRTP && len(payload) >= 12 && trackID < len(s.ssrcs) {
+		atomic.StoreUint32(&s.ssrcs[trackID], binary.BigEndian.Uint32(payload[8:12]))
+	}
+
+	if s.sanitizer != nil {
+		err := s.sanitizer.Check(trackID, streamType, payload)
+		if err != nil {
+			s.onMalformedFrame(trackID, streamType, err)
+
+			if s.rtpValidation == conf.RTPValidationDrop {
+				return
+			}
+		}
+	}
+
+	if s.extFilter != nil && streamType == gortsplib.StreamTypeRTP {
+		payload = s.extFilter.Process(payload)
+	}
+
+	// sender reports are needed to compute the round-trip time of readers
+	if streamType == gortsplib.StreamTypeRTCP && trackID < len(s.senderReports) {
+		s.senderReports[trackID].ProcessFrame(time.Now(), streamType, payload)
+	}
+
+	// forward to RTSP readers.
+	// frames are handed to them synchronously, therefore the latency
+	// is the time spent in writing.
+	if s.latency != nil && atomic.LoadInt64(s.rtspReaders) > 0 {
+		start := time.Now()
+		s.rtspStream.WriteFrame(trackID, streamType, payload)
+		s.latency.add("rtsp", time.Since(start))
+	} else {
+		s.rtspStream.WriteFrame(trackID, streamType, payload)
+	}
 
 	// forward to non-RTSP readers
 	s.nonRTSPReaders.forwardFrame(trackID, streamType, payload)
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtp"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+type streamGOPCacheEntry struct {
+	trackID int
+	payload []byte
+}
+
+// streamGOPCache contains the RTP packets of all tracks received since the
+// beginning of the last group of pictures (GOP) of the first H264 track.
+// They are sent to readers that want to start from the last keyframe.
+type streamGOPCache struct {
+	h264TrackID int
+	maxSize     int
+
+	mutex     sync.Mutex
+	entries   []streamGOPCacheEntry
+	startTS   uint32
+	startSeen bool
+}
+
+func newStreamGOPCache(tracks gortsplib.Tracks, maxSize int) *streamGOPCache {
+	for i, t := range tracks {
+		if t.IsH264() {
+			return &streamGOPCache{
+				h264TrackID: i,
+				maxSize:     maxSize,
+			}
+		}
+	}
+
+	// without a H264 track, there's no keyframe to start from.
+	return nil
+}
+
+// streamGOPCacheIsStart returns whether a H264 RTP payload contains
+// the beginning of a GOP, that is a SPS or an IDR.
+func streamGOPCacheIsStart(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+
+	isStart := func(typ h264.NALUType) bool {
+		return typ == h264.NALUTypeSPS || typ == h264.NALUTypeIDR
+	}
+
+	switch typ := h264.NALUType(payload[0] & 0x1F); typ {
+	case 24: // STAP-A
+		buf := payload[1:]
+		for len(buf) >= 3 {
+			size := int(buf[0])<<8 | int(buf[1])
+			if size == 0 || len(buf[2:]) < size {
+				return false
+			}
+
+			if isStart(h264.NALUType(buf[2] & 0x1F)) {
+				return true
+			}
+			buf = buf[2+size:]
+		}
+		return false
+
+	case 28: // FU-A
+		if len(payload) < 2 {
+			return false
+		}
+		start := (payload[1] >> 7) == 1
+		return start && isStart(h264.NALUType(payload[1]&0x1F))
+
+	default:
+		return isStart(typ)
+	}
+}
+
+func (c *streamGOPCache) add(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	if streamType != gortsplib.StreamTypeRTP {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if trackID == c.h264TrackID {
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(payload)
+		if err != nil {
+			return
+		}
+
+		// SPS, PPS and IDR usually share the same timestamp and are
+		// sent in sequence; the GOP begins with the first of them.
+		if streamGOPCacheIsStart(pkt.Payload) &&
+			(!c.startSeen || pkt.Timestamp != c.startTS) {
+			c.entries = c.entries[:0]
+			c.startTS = pkt.Timestamp
+			c.startSeen = true
+		}
+	}
+
+	if !c.startSeen {
+		return
+	}
+
+	// when the GOP is longer than the read buffer, it can't be sent
+	// entirely; wait for the next one.
+	if len(c.entries) >= c.maxSize {
+		c.entries = c.entries[:0]
+		c.startSeen = false
+		return
+	}
+
+	// payloads may be reused by the publisher, copy them.
+	buf := make([]byte, len(payload))
+	copy(buf, payload)
+
+	c.entries = append(c.entries, streamGOPCacheEntry{trackID, buf})
+}
+
+func (c *streamGOPCache) forward(r reader) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, e := range c.entries {
+		r.OnReaderFrame(e.trackID, gortsplib.StreamTypeRTP, e.payload)
+	}
+}
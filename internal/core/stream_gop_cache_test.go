@@ -0,0 +1,83 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+type testGOPCacheReader struct {
+	frames [][]byte
+}
+
+func (r *testGOPCacheReader) Close()            {}
+func (r *testGOPCacheReader) OnReaderAccepted() {}
+func (r *testGOPCacheReader) OnReaderFrame(trackID int, streamType gortsplib.StreamType, payload []byte) {
+	r.frames = append(r.frames, payload)
+}
+func (r *testGOPCacheReader) OnReaderAPIDescribe() interface{} { return nil }
+
+func TestStreamGOPCache(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96,
+		[]byte{0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0, 0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x03, 0x00, 0x3d, 0x08},
+		[]byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{0x12, 0x10})
+	require.NoError(t, err)
+
+	c := newStreamGOPCache(gortsplib.Tracks{videoTrack, audioTrack}, 5)
+
+	pkt := func(seq uint16, ts uint32, payload []byte) []byte {
+		byts, err := (&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    96,
+				SequenceNumber: seq,
+				Timestamp:      ts,
+			},
+			Payload: payload,
+		}).Marshal()
+		require.NoError(t, err)
+		return byts
+	}
+
+	sps := pkt(2, 100, []byte{0x67, 0x01})
+	idr := pkt(3, 100, []byte{0x65, 0x01})
+	nonIDR := pkt(4, 200, []byte{0x41, 0x01})
+	fuaIDR := pkt(5, 300, []byte{0x7c, 0x85, 0x01})
+	audio := []byte{0x01, 0x02}
+
+	// packets received before the first keyframe are discarded
+	c.add(1, gortsplib.StreamTypeRTP, audio)
+	c.add(0, gortsplib.StreamTypeRTP, pkt(1, 50, []byte{0x41, 0x01}))
+
+	// SPS and IDR with the same timestamp belong to the same GOP
+	c.add(0, gortsplib.StreamTypeRTP, sps)
+	c.add(0, gortsplib.StreamTypeRTP, idr)
+	c.add(1, gortsplib.StreamTypeRTP, audio)
+	c.add(0, gortsplib.StreamTypeRTCP, []byte{0x01})
+	c.add(0, gortsplib.StreamTypeRTP, nonIDR)
+
+	r := &testGOPCacheReader{}
+	c.forward(r)
+	require.Equal(t, [][]byte{sps, idr, audio, nonIDR}, r.frames)
+
+	// a new keyframe replaces the cache
+	c.add(0, gortsplib.StreamTypeRTP, fuaIDR)
+
+	r = &testGOPCacheReader{}
+	c.forward(r)
+	require.Equal(t, [][]byte{fuaIDR}, r.frames)
+
+	// a GOP longer than the maximum size is discarded
+	for i := 0; i < 5; i++ {
+		c.add(1, gortsplib.StreamTypeRTP, audio)
+	}
+
+	r = &testGOPCacheReader{}
+	c.forward(r)
+	require.Equal(t, [][]byte(nil), r.frames)
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+	"github.com/pion/rtp"
+	psdp "github.com/pion/sdp/v3"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtcpsenderset"
+	"github.com/aler9/rtsp-simple-server/internal/testpattern"
+)
+
+const (
+	testPatternToneFrequency  = 1000
+	testPatternAudioClockRate = 8000
+	testPatternAudioPeriod    = 20 * time.Millisecond
+)
+
+type testPatternSourceParent interface {
+	Log(logger.Level, string, ...interface{})
+	OnSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	OnSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+}
+
+type testPatternSource struct {
+	width   int
+	height  int
+	fps     int
+	bitrate int
+	wg      *sync.WaitGroup
+	parent  testPatternSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newTestPatternSource(
+	parentCtx context.Context,
+	width int,
+	height int,
+	fps int,
+	bitrate int,
+	wg *sync.WaitGroup,
+	parent testPatternSourceParent) *testPatternSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &testPatternSource{
+		width:     width,
+		height:    height,
+		fps:       fps,
+		bitrate:   bitrate,
+		wg:        wg,
+		parent:    parent,
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+	}
+
+	s.log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Close closes a Source.
+func (s *testPatternSource) Close() {
+	s.log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *testPatternSource) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[test pattern source] "+format, args...)
+}
+
+func newTestPatternAudioTrack() *gortsplib.Track {
+	return &gortsplib.Track{
+		Media: &psdp.MediaDescription{
+			MediaName: psdp.MediaName{
+				Media:   "audio",
+				Protos:  []string{"RTP", "AVP"},
+				Formats: []string{"0"},
+			},
+			Attributes: []psdp.Attribute{
+				{
+					Key:   "rtpmap",
+					Value: "0 PCMU/8000",
+				},
+			},
+		},
+	}
+}
+
+func (s *testPatternSource) run() {
+	defer s.wg.Done()
+	defer s.ctxCancel()
+
+	gen := testpattern.New(s.width, s.height, s.fps, s.bitrate)
+	tone := testpattern.NewToneGenerator(testPatternToneFrequency, testPatternAudioClockRate)
+
+	videoTrack, err := gortsplib.NewTrackH264(96, gen.SPS(), gen.PPS())
+	if err != nil {
+		s.log(logger.Info, "ERR: %s", err)
+		return
+	}
+
+	tracks := gortsplib.Tracks{videoTrack, newTestPatternAudioTrack()}
+
+	res := s.parent.OnSourceStaticSetReady(pathSourceStaticSetReadyReq{
+		Tracks: tracks,
+	})
+	if res.Err != nil {
+		s.log(logger.Info, "ERR: %s", res.Err)
+		return
+	}
+
+	s.log(logger.Info, "ready")
+
+	defer func() {
+		s.parent.OnSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{Source: s})
+	}()
+
+	rtcpSenders := rtcpsenderset.New(tracks, res.Stream.onFrame)
+	defer rtcpSenders.Close()
+
+	onFrame := func(trackID int, payload []byte) {
+		rtcpSenders.OnFrame(trackID, gortsplib.StreamTypeRTP, payload)
+		res.Stream.onFrame(trackID, gortsplib.StreamTypeRTP, payload)
+	}
+
+	h264Encoder := rtph264.NewEncoder(96, nil, nil, nil)
+
+	audioPkt := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    0,
+			SequenceNumber: uint16(rand.Uint32()),
+			Timestamp:      rand.Uint32(),
+			SSRC:           rand.Uint32(),
+		},
+	}
+	audioSamples := int(testPatternAudioClockRate * testPatternAudioPeriod / time.Second)
+
+	videoPeriod := time.Second / time.Duration(s.fps)
+	videoTicker := time.NewTicker(videoPeriod)
+	defer videoTicker.Stop()
+
+	audioTicker := time.NewTicker(testPatternAudioPeriod)
+	defer audioTicker.Stop()
+
+	videoCount := 0
+
+	for {
+		select {
+		case <-videoTicker.C:
+			nalus, _ := gen.Next()
+
+			pkts, err := h264Encoder.Encode(nalus, time.Duration(videoCount)*videoPeriod)
+			if err != nil {
+				s.log(logger.Info, "ERR while encoding H264: %v", err)
+				return
+			}
+			videoCount++
+
+			for _, pkt := range pkts {
+				onFrame(0, pkt)
+			}
+
+		case <-audioTicker.C:
+			audioPkt.Payload = tone.Next(audioSamples)
+			byts, err := audioPkt.Marshal()
+			if err != nil {
+				s.log(logger.Info, "ERR while encoding audio: %v", err)
+				return
+			}
+
+			onFrame(1, byts)
+
+			audioPkt.SequenceNumber++
+			audioPkt.Timestamp += uint32(audioSamples)
+
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// OnSourceAPIDescribe implements source.
+func (*testPatternSource) OnSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"testPatternSource"}
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestPatternSource(t *testing.T) {
+	p, ok := newInstance("rtmpDisable: yes\n" +
+		"hlsDisable: yes\n" +
+		"protocols: [tcp]\n" +
+		"paths:\n" +
+		"  test:\n" +
+		"    source: testpattern\n" +
+		"    testPatternResolution: 64x48\n" +
+		"    testPatternFPS: 10\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	var conn *gortsplib.ClientConn
+	require.Eventually(t, func() bool {
+		var err error
+		conn, err = gortsplib.DialRead("rtsp://localhost:8554/test")
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+	defer conn.Close()
+
+	tracks := conn.Tracks()
+	require.Equal(t, 2, len(tracks))
+	require.Equal(t, true, tracks[0].IsH264())
+	require.Equal(t, "audio", tracks[1].Media.MediaName.Media)
+
+	readDone := make(chan struct{})
+	videoRecv := make(chan struct{})
+	audioRecv := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		videoDone := false
+		audioDone := false
+		conn.ReadFrames(func(trackID int, streamType base.StreamType, payload []byte) {
+			if streamType != gortsplib.StreamTypeRTP {
+				return
+			}
+
+			if trackID == 0 && !videoDone {
+				videoDone = true
+				close(videoRecv)
+			} else if trackID == 1 && !audioDone {
+				require.Equal(t, 12+160, len(payload))
+				audioDone = true
+				close(audioRecv)
+			}
+		})
+	}()
+
+	<-videoRecv
+	<-audioRecv
+
+	conn.Close()
+	<-readDone
+}
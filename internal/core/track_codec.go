@@ -0,0 +1,55 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/aler9/gortsplib"
+)
+
+// codec names used in the configuration, indexed by RTP encoding name.
+var trackCodecNames = map[string]string{
+	"h264":          "h264",
+	"h265":          "h265",
+	"vp8":           "vp8",
+	"vp9":           "vp9",
+	"av1":           "av1",
+	"mp4v-es":       "mpeg4video",
+	"mpv":           "mpegvideo",
+	"jpeg":          "jpeg",
+	"mpeg4-generic": "aac",
+	"mp4a-latm":     "aac",
+	"opus":          "opus",
+	"mpa":           "mpegaudio",
+	"pcma":          "pcma",
+	"pcmu":          "pcmu",
+	"g722":          "g722",
+	"l16":           "lpcm",
+}
+
+// codec names of static payload types, that can be used without rtpmap.
+var trackCodecStaticNames = map[string]string{
+	"0":  "pcmu",
+	"8":  "pcma",
+	"9":  "g722",
+	"14": "mpegaudio",
+	"26": "jpeg",
+	"32": "mpegvideo",
+}
+
+// trackCodec returns the name of the codec of a track,
+// or an empty string if the codec is unknown.
+func trackCodec(t *gortsplib.Track) string {
+	if v, ok := t.Media.Attribute("rtpmap"); ok {
+		vals := strings.Split(v, " ")
+		if len(vals) == 2 {
+			enc := strings.ToLower(strings.Split(vals[1], "/")[0])
+			return trackCodecNames[enc]
+		}
+		return ""
+	}
+
+	if len(t.Media.MediaName.Formats) == 0 {
+		return ""
+	}
+	return trackCodecStaticNames[t.Media.MediaName.Formats[0]]
+}
@@ -0,0 +1,234 @@
+// Package credential contains functions to hash and check credentials.
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	prefixSHA256 = "sha256:"
+	prefixBcrypt = "bcrypt:"
+	prefixArgon2 = "argon2:"
+
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	// maximum memory that hashes can require, in KiB.
+	argon2MaxMemory = 256 * 1024
+
+	maxCacheSize = 1024
+
+	// every argon2 check allocates argon2Memory KiB.
+	maxConcurrentSlowChecks = 4
+)
+
+// slow hashes are checked once for every couple of credentials,
+// since some protocols (HLS) send credentials with every request.
+// Failures are cached too, in order to prevent clients from consuming
+// resources by repeating requests with wrong credentials, but they are
+// evicted first, in order to prevent them from evicting valid credentials.
+var (
+	cacheMutex sync.Mutex
+	cache      = make(map[[sha256.Size]byte]bool)
+	slowChecks = make(chan struct{}, maxConcurrentSlowChecks)
+)
+
+func cacheGet(key [sha256.Size]byte) (bool, bool) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	res, ok := cache[key]
+	return res, ok
+}
+
+func cacheSet(key [sha256.Size]byte, res bool) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if len(cache) >= maxCacheSize {
+		// a failure can't replace a success
+		if !cacheEvict(false) && (!res || !cacheEvict(true)) {
+			return
+		}
+	}
+	cache[key] = res
+}
+
+// cacheEvict removes an entry with the given result.
+// It must be called with the mutex locked.
+func cacheEvict(res bool) bool {
+	for key, v := range cache {
+		if v == res {
+			delete(cache, key)
+			return true
+		}
+	}
+	return false
+}
+
+func sha256Base64(in string) string {
+	h := sha256.Sum256([]byte(in))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2(encoded string) (*argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("invalid argon2 hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version")
+	}
+
+	var p argon2Params
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads)
+	if err != nil || p.time < 1 || p.threads < 1 ||
+		p.memory < 8*uint32(p.threads) || p.memory > argon2MaxMemory {
+		return nil, fmt.Errorf("invalid argon2 parameters")
+	}
+
+	p.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2 salt")
+	}
+
+	p.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(p.key) == 0 {
+		return nil, fmt.Errorf("invalid argon2 key")
+	}
+
+	return &p, nil
+}
+
+// IsHashed checks whether a credential is hashed.
+func IsHashed(v string) bool {
+	return strings.HasPrefix(v, prefixSHA256) ||
+		strings.HasPrefix(v, prefixBcrypt) ||
+		strings.HasPrefix(v, prefixArgon2)
+}
+
+// IsSlowHashed checks whether a credential is hashed with an algorithm
+// that can't be used with the Digest authentication method.
+func IsSlowHashed(v string) bool {
+	return strings.HasPrefix(v, prefixBcrypt) ||
+		strings.HasPrefix(v, prefixArgon2)
+}
+
+// Validate checks whether a hashed credential is well formed.
+func Validate(v string) error {
+	switch {
+	case strings.HasPrefix(v, prefixBcrypt):
+		_, err := bcrypt.Cost([]byte(strings.TrimPrefix(v, prefixBcrypt)))
+		if err != nil {
+			return fmt.Errorf("invalid bcrypt hash")
+		}
+
+	case strings.HasPrefix(v, prefixArgon2):
+		_, err := parseArgon2(strings.TrimPrefix(v, prefixArgon2))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkSlow(expected string, provided string) bool {
+	switch {
+	case strings.HasPrefix(expected, prefixBcrypt):
+		return bcrypt.CompareHashAndPassword(
+			[]byte(strings.TrimPrefix(expected, prefixBcrypt)), []byte(provided)) == nil
+
+	default:
+		p, err := parseArgon2(strings.TrimPrefix(expected, prefixArgon2))
+		if err != nil {
+			return false
+		}
+
+		key := argon2.IDKey([]byte(provided), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		return subtle.ConstantTimeCompare(key, p.key) == 1
+	}
+}
+
+// Check checks whether a provided credential matches the expected one,
+// that can be in plain text or hashed.
+func Check(expected string, provided string) bool {
+	switch {
+	case strings.HasPrefix(expected, prefixSHA256):
+		return strings.TrimPrefix(expected, prefixSHA256) == sha256Base64(provided)
+
+	case IsSlowHashed(expected):
+		key := sha256.Sum256([]byte(expected + "\x00" + provided))
+
+		if res, ok := cacheGet(key); ok {
+			return res
+		}
+
+		slowChecks <- struct{}{}
+		defer func() { <-slowChecks }()
+
+		// the same credentials may have been checked while waiting
+		if res, ok := cacheGet(key); ok {
+			return res
+		}
+
+		res := checkSlow(expected, provided)
+		cacheSet(key, res)
+		return res
+	}
+
+	return expected == provided
+}
+
+// Hash hashes a credential with the given algorithm
+// ("bcrypt", "argon2" or "sha256").
+func Hash(algorithm string, v string) (string, error) {
+	switch algorithm {
+	case "bcrypt":
+		h, err := bcrypt.GenerateFromPassword([]byte(v), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return prefixBcrypt + string(h), nil
+
+	case "argon2":
+		salt := make([]byte, argon2SaltLen)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", err
+		}
+
+		key := argon2.IDKey([]byte(v), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+		return prefixArgon2 + fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+
+	case "sha256":
+		return prefixSHA256 + sha256Base64(v), nil
+	}
+
+	return "", fmt.Errorf("unsupported algorithm: %s", algorithm)
+}
@@ -0,0 +1,110 @@
+package credential
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	for _, alg := range []string{"bcrypt", "argon2", "sha256"} {
+		t.Run(alg, func(t *testing.T) {
+			h, err := Hash(alg, "testpass")
+			require.NoError(t, err)
+			require.Equal(t, true, IsHashed(h))
+			require.NoError(t, Validate(h))
+
+			require.Equal(t, true, Check(h, "testpass"))
+			require.Equal(t, true, Check(h, "testpass")) // cached
+			require.Equal(t, false, Check(h, "wrongpass"))
+			require.Equal(t, false, Check(h, "wrongpass")) // cached
+		})
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		require.Equal(t, false, IsHashed("testpass"))
+		require.Equal(t, true, Check("testpass", "testpass"))
+		require.Equal(t, false, Check("testpass", "wrongpass"))
+	})
+}
+
+func TestValidateErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		v    string
+		err  string
+	}{
+		{
+			"bcrypt",
+			"bcrypt:invalid",
+			"invalid bcrypt hash",
+		},
+		{
+			"argon2 format",
+			"argon2:$argon2i$v=19$m=65536,t=1,p=4$c2FsdA$a2V5",
+			"invalid argon2 hash",
+		},
+		{
+			"argon2 version",
+			"argon2:$argon2id$v=16$m=65536,t=1,p=4$c2FsdA$a2V5",
+			"unsupported argon2 version",
+		},
+		{
+			"argon2 time",
+			"argon2:$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5",
+			"invalid argon2 parameters",
+		},
+		{
+			"argon2 threads",
+			"argon2:$argon2id$v=19$m=65536,t=1,p=0$c2FsdA$a2V5",
+			"invalid argon2 parameters",
+		},
+		{
+			"argon2 memory",
+			"argon2:$argon2id$v=19$m=4294967295,t=1,p=4$c2FsdA$a2V5",
+			"invalid argon2 parameters",
+		},
+		{
+			"argon2 key",
+			"argon2:$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$",
+			"invalid argon2 key",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.EqualError(t, Validate(ca.v), ca.err)
+		})
+	}
+}
+
+func TestCheckConcurrent(t *testing.T) {
+	h, err := Hash("argon2", "testpass")
+	require.NoError(t, err)
+
+	done := make(chan bool)
+	for i := 0; i < 2*maxConcurrentSlowChecks; i++ {
+		go func(i int) {
+			done <- Check(h, fmt.Sprintf("wrongpass%d", i))
+		}(i)
+	}
+
+	for i := 0; i < 2*maxConcurrentSlowChecks; i++ {
+		require.Equal(t, false, <-done)
+	}
+	require.Equal(t, 0, len(slowChecks))
+}
+
+func TestCacheEviction(t *testing.T) {
+	valid := sha256.Sum256([]byte("valid"))
+	cacheSet(valid, true)
+
+	for i := 0; i < 2*maxCacheSize; i++ {
+		cacheSet(sha256.Sum256([]byte(fmt.Sprintf("wrong%d", i))), false)
+	}
+
+	res, ok := cacheGet(valid)
+	require.Equal(t, true, ok)
+	require.Equal(t, true, res)
+	require.Equal(t, maxCacheSize, len(cache))
+}
@@ -0,0 +1,179 @@
+// Package ctl contains a command-line client for the HTTP API.
+package ctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+)
+
+type client struct {
+	address string
+	out     io.Writer
+	hc      *http.Client
+}
+
+func (c *client) do(method string, path string, in io.Reader) error {
+	req, err := http.NewRequest(method, "http://"+c.address+path, in)
+	if err != nil {
+		return err
+	}
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	byts, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	if len(byts) == 0 {
+		return nil
+	}
+
+	// pretty-print JSON responses
+	var buf bytes.Buffer
+	err = json.Indent(&buf, byts, "", "  ")
+	if err != nil {
+		_, err = c.out.Write(byts)
+		return err
+	}
+	buf.WriteByte('\n')
+
+	_, err = c.out.Write(buf.Bytes())
+	return err
+}
+
+// Run runs the client with the given arguments and returns an exit code.
+func Run(args []string) int {
+	return run(args, os.Stdout, os.Stderr)
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	k := kingpin.New("rtsp-simple-server ctl",
+		"Control a running rtsp-simple-server through its API.")
+	k.Writer(stderr)
+
+	argAddress := k.Flag("address", "address of the API listener.").Default("127.0.0.1:9997").String()
+
+	cmdConfig := k.Command("config", "manage the configuration.")
+	cmdConfigGet := cmdConfig.Command("get", "print the current configuration.")
+	cmdConfigSet := cmdConfig.Command("set", "change the configuration; reads a JSON object from stdin.")
+	cmdConfigPatch := cmdConfig.Command("patch", "change the global configuration with validation; reads a JSON object from stdin.")
+
+	cmdPaths := k.Command("paths", "manage paths.")
+	cmdPathsList := cmdPaths.Command("list", "list paths.")
+	cmdPathsAdd := cmdPaths.Command("add", "add a path configuration; reads a JSON object from stdin.")
+	argPathsAddName := cmdPathsAdd.Arg("name", "path name.").Required().String()
+	cmdPathsEdit := cmdPaths.Command("edit", "edit a path configuration; reads a JSON object from stdin.")
+	argPathsEditName := cmdPathsEdit.Arg("name", "path name.").Required().String()
+	cmdPathsRemove := cmdPaths.Command("remove", "remove a path configuration.")
+	argPathsRemoveName := cmdPathsRemove.Arg("name", "path name.").Required().String()
+
+	cmdRTSPSessions := k.Command("rtspsessions", "manage RTSP sessions.")
+	cmdRTSPSessionsList := cmdRTSPSessions.Command("list", "list RTSP sessions.")
+	cmdRTSPSessionsKick := cmdRTSPSessions.Command("kick", "kick out a RTSP session.")
+	argRTSPSessionsKickID := cmdRTSPSessionsKick.Arg("id", "session ID.").Required().String()
+
+	cmdRTSPSSessions := k.Command("rtspssessions", "manage RTSPS sessions.")
+	cmdRTSPSSessionsList := cmdRTSPSSessions.Command("list", "list RTSPS sessions.")
+	cmdRTSPSSessionsKick := cmdRTSPSSessions.Command("kick", "kick out a RTSPS session.")
+	argRTSPSSessionsKickID := cmdRTSPSSessionsKick.Arg("id", "session ID.").Required().String()
+
+	cmdRTMPConns := k.Command("rtmpconns", "manage RTMP connections.")
+	cmdRTMPConnsList := cmdRTMPConns.Command("list", "list RTMP connections.")
+	cmdRTMPConnsKick := cmdRTMPConns.Command("kick", "kick out a RTMP connection.")
+	argRTMPConnsKickID := cmdRTMPConnsKick.Arg("id", "connection ID.").Required().String()
+
+	cmdIPs := k.Command("ips", "inspect statistics of remote IPs.")
+	cmdIPsList := cmdIPs.Command("list", "list statistics aggregated by remote IP.")
+
+	cmd, err := k.Parse(args)
+	if err != nil {
+		fmt.Fprintf(stderr, "ERR: %s\n", err)
+		return 1
+	}
+
+	c := &client{
+		address: *argAddress,
+		out:     stdout,
+		hc:      &http.Client{Timeout: requestTimeout},
+	}
+
+	stdin := func() io.Reader {
+		return os.Stdin
+	}
+
+	switch cmd {
+	case cmdConfigGet.FullCommand():
+		err = c.do(http.MethodGet, "/v1/config/get", nil)
+
+	case cmdConfigSet.FullCommand():
+		err = c.do(http.MethodPost, "/v1/config/set", stdin())
+
+	case cmdConfigPatch.FullCommand():
+		err = c.do(http.MethodPatch, "/v1/config/global", stdin())
+
+	case cmdPathsList.FullCommand():
+		err = c.do(http.MethodGet, "/v1/paths/list", nil)
+
+	case cmdPathsAdd.FullCommand():
+		err = c.do(http.MethodPost, "/v1/config/paths/add/"+*argPathsAddName, stdin())
+
+	case cmdPathsEdit.FullCommand():
+		err = c.do(http.MethodPost, "/v1/config/paths/edit/"+*argPathsEditName, stdin())
+
+	case cmdPathsRemove.FullCommand():
+		err = c.do(http.MethodPost, "/v1/config/paths/remove/"+*argPathsRemoveName, nil)
+
+	case cmdRTSPSessionsList.FullCommand():
+		err = c.do(http.MethodGet, "/v1/rtspsessions/list", nil)
+
+	case cmdRTSPSessionsKick.FullCommand():
+		err = c.do(http.MethodPost, "/v1/rtspsessions/kick/"+*argRTSPSessionsKickID, nil)
+
+	case cmdRTSPSSessionsList.FullCommand():
+		err = c.do(http.MethodGet, "/v1/rtspssessions/list", nil)
+
+	case cmdRTSPSSessionsKick.FullCommand():
+		err = c.do(http.MethodPost, "/v1/rtspssessions/kick/"+*argRTSPSSessionsKickID, nil)
+
+	case cmdRTMPConnsList.FullCommand():
+		err = c.do(http.MethodGet, "/v1/rtmpconns/list", nil)
+
+	case cmdRTMPConnsKick.FullCommand():
+		err = c.do(http.MethodPost, "/v1/rtmpconns/kick/"+*argRTMPConnsKickID, nil)
+
+	case cmdIPsList.FullCommand():
+		err = c.do(http.MethodGet, "/v1/ips/list", nil)
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "ERR: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
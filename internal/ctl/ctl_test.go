@@ -0,0 +1,104 @@
+package ctl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCtl(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// the handler runs in the goroutines of the server
+	var mutex sync.Mutex
+	var lastMethod string
+	var lastPath string
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			lastMethod = r.Method
+			lastPath = r.URL.Path
+			mutex.Unlock()
+
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`{"items":{}}`))
+			}
+		}),
+	}
+	go s.Serve(ln)
+	defer s.Close()
+
+	for _, ca := range []struct {
+		name   string
+		args   []string
+		method string
+		path   string
+	}{
+		{
+			"paths list",
+			[]string{"paths", "list"},
+			http.MethodGet,
+			"/v1/paths/list",
+		},
+		{
+			"rtsp sessions kick",
+			[]string{"rtspsessions", "kick", "123456"},
+			http.MethodPost,
+			"/v1/rtspsessions/kick/123456",
+		},
+		{
+			"rtmp conns list",
+			[]string{"rtmpconns", "list"},
+			http.MethodGet,
+			"/v1/rtmpconns/list",
+		},
+		{
+			"ips list",
+			[]string{"ips", "list"},
+			http.MethodGet,
+			"/v1/ips/list",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			code := run(append([]string{"--address", ln.Addr().String()}, ca.args...),
+				&stdout, ioutil.Discard)
+			require.Equal(t, 0, code)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			require.Equal(t, ca.method, lastMethod)
+			require.Equal(t, ca.path, lastPath)
+
+			if ca.method == http.MethodGet {
+				require.Equal(t, "{\n  \"items\": {}\n}\n", stdout.String())
+			}
+		})
+	}
+}
+
+func TestCtlError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}),
+	}
+	go s.Serve(ln)
+	defer s.Close()
+
+	var stderr bytes.Buffer
+	code := run([]string{"--address", ln.Addr().String(), "rtmpconns", "kick", "1"},
+		ioutil.Discard, &stderr)
+	require.Equal(t, 1, code)
+	require.Equal(t, "ERR: bad status code: 404\n", stderr.String())
+}
@@ -0,0 +1,450 @@
+// Package dash contains a MPEG-DASH muxer, that produces a dynamic MPD
+// manifest and fragmented MP4 segments.
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+
+	"github.com/aler9/rtsp-simple-server/internal/fmp4"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
+)
+
+// file names of initialization sections.
+const (
+	videoInitName = "video_init.mp4"
+	audioInitName = "audio_init.mp4"
+)
+
+// Muxer is a MPEG-DASH muxer.
+type Muxer struct {
+	segmentCount    int
+	segmentDuration time.Duration
+	videoTrack      *gortsplib.Track
+	audioTrack      *gortsplib.Track
+
+	videoCodec            string
+	videoWidth            int
+	videoHeight           int
+	aacConfig             rtpaac.MPEG4AudioConfig
+	videoInit             []byte
+	audioInit             []byte
+	availabilityStartTime time.Time
+	sequenceNumber        uint32
+	lastVideoDTS          time.Duration
+	currentSegment        *segment
+	segments              []*segment
+	mutex                 sync.RWMutex
+}
+
+// NewMuxer allocates a Muxer.
+// The manifest lists at most segmentCount segments, each one with a
+// minimum duration of segmentDuration.
+// videoTrack can be a H264 or a H265 track.
+func NewMuxer(
+	segmentCount int,
+	segmentDuration time.Duration,
+	videoTrack *gortsplib.Track,
+	audioTrack *gortsplib.Track) (*Muxer, error) {
+	if videoTrack == nil && audioTrack == nil {
+		return nil, fmt.Errorf("at least one track is needed")
+	}
+
+	m := &Muxer{
+		segmentCount:    segmentCount,
+		segmentDuration: segmentDuration,
+		videoTrack:      videoTrack,
+		audioTrack:      audioTrack,
+		currentSegment:  &segment{},
+	}
+
+	if videoTrack != nil {
+		var err error
+		m.videoInit, err = fmp4.GenerateInit(videoTrack, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if h265.IsTrack(videoTrack) {
+			_, sps, _, err := h265.ExtractParams(videoTrack)
+			if err != nil {
+				return nil, err
+			}
+
+			spsp, err := h265.DecodeSPS(sps)
+			if err != nil {
+				return nil, err
+			}
+
+			m.videoCodec = spsp.Codec()
+			m.videoWidth = spsp.Width
+			m.videoHeight = spsp.Height
+		} else {
+			sps, _, err := videoTrack.ExtractDataH264()
+			if err != nil {
+				return nil, err
+			}
+
+			if len(sps) < 4 {
+				return nil, fmt.Errorf("invalid SPS")
+			}
+			m.videoCodec = fmt.Sprintf("avc1.%02x%02x%02x", sps[1], sps[2], sps[3])
+
+			// the resolution is optional
+			spsp, err := h264.DecodeSPS(sps)
+			if err == nil {
+				m.videoWidth = spsp.Width
+				m.videoHeight = spsp.Height
+			}
+		}
+	}
+
+	if audioTrack != nil {
+		byts, err := audioTrack.ExtractDataAAC()
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.aacConfig.Decode(byts)
+		if err != nil {
+			return nil, err
+		}
+
+		m.audioInit, err = fmp4.GenerateInit(nil, audioTrack)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// WriteH264 writes H264 NALUs, grouped by PTS, into the muxer.
+func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
+	idrPresent := false
+	var filtered [][]byte
+
+	for _, nalu := range nalus {
+		// remove parameter sets and AUD, since they're stored in the initialization section
+		switch h264.NALUType(nalu[0] & 0x1F) {
+		case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+			continue
+
+		case h264.NALUTypeIDR:
+			idrPresent = true
+		}
+		filtered = append(filtered, nalu)
+	}
+
+	return m.writeVideo(pts, idrPresent, filtered)
+}
+
+// WriteH265 writes H265 NALUs, grouped by PTS, into the muxer.
+func (m *Muxer) WriteH265(pts time.Duration, nalus [][]byte) error {
+	idrPresent := false
+	var filtered [][]byte
+
+	for _, nalu := range nalus {
+		typ := h265.NALUTypeOf(nalu)
+
+		// remove parameter sets and AUD, since they're stored in the initialization section
+		switch typ {
+		case h265.NALUTypeVPS, h265.NALUTypeSPS, h265.NALUTypePPS, h265.NALUTypeAccessUnitDelimiter:
+			continue
+		}
+
+		if typ.IsRandomAccess() {
+			idrPresent = true
+		}
+		filtered = append(filtered, nalu)
+	}
+
+	return m.writeVideo(pts, idrPresent, filtered)
+}
+
+func (m *Muxer) writeVideo(pts time.Duration, idrPresent bool, nalus [][]byte) error {
+	if len(nalus) == 0 {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// skip groups silently until we find one with a IDR
+	if m.currentSegment.isEmpty() && !idrPresent {
+		return nil
+	}
+
+	data, err := h264.EncodeAVCC(nalus)
+	if err != nil {
+		return err
+	}
+
+	// samples are written in decoding order, with strictly increasing DTS
+	dts := pts
+	if m.lastVideoDTS != 0 && dts <= m.lastVideoDTS {
+		dts = m.lastVideoDTS + time.Millisecond
+	}
+	m.lastVideoDTS = dts
+
+	if idrPresent && !m.currentSegment.isEmpty() &&
+		(dts-m.currentSegment.firstTime()) >= m.segmentDuration {
+		m.finishSegment(dts)
+	}
+
+	m.setAvailabilityStartTime(dts)
+
+	samples := m.currentSegment.videoSamples
+	if len(samples) > 0 {
+		prev := samples[len(samples)-1]
+		prev.Duration = dts - prev.DTS
+	}
+
+	m.currentSegment.videoSamples = append(samples, &fmp4.Sample{
+		DTS:       dts,
+		PTSOffset: pts - dts,
+		IsSync:    idrPresent,
+		Data:      data,
+	})
+
+	return nil
+}
+
+// WriteAAC writes AAC AUs, grouped by PTS, into the muxer.
+func (m *Muxer) WriteAAC(pts time.Duration, aus [][]byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// when there's a video track, segments start with a IDR
+	if m.videoTrack != nil && len(m.currentSegment.videoSamples) == 0 {
+		return nil
+	}
+
+	auDuration := 1024 * time.Second / time.Duration(m.aacConfig.SampleRate)
+
+	for i, au := range aus {
+		auPTS := pts + time.Duration(i)*auDuration
+
+		// when there's no video track, segments are split on audio
+		if m.videoTrack == nil && !m.currentSegment.isEmpty() &&
+			(auPTS-m.currentSegment.firstTime()) >= m.segmentDuration {
+			m.finishSegment(auPTS)
+		}
+
+		m.setAvailabilityStartTime(auPTS)
+
+		m.currentSegment.audioSamples = append(m.currentSegment.audioSamples, &fmp4.Sample{
+			DTS:      auPTS,
+			Duration: auDuration,
+			IsSync:   true,
+			Data:     au,
+		})
+	}
+
+	return nil
+}
+
+// setAvailabilityStartTime computes the time at which the stream has started,
+// when the first sample is received, in order to allow players to find
+// the live edge of the stream.
+func (m *Muxer) setAvailabilityStartTime(ts time.Duration) {
+	if m.availabilityStartTime.IsZero() {
+		m.availabilityStartTime = time.Now().Add(-ts)
+	}
+}
+
+func (m *Muxer) finishSegment(endTime time.Duration) {
+	m.currentSegment.finish(endTime, &m.sequenceNumber, m.aacConfig.SampleRate)
+	m.segments = append(m.segments, m.currentSegment)
+	m.currentSegment = &segment{}
+
+	if len(m.segments) > m.segmentCount {
+		m.segments = m.segments[len(m.segments)-m.segmentCount:]
+	}
+}
+
+// MPD returns a reader to read the manifest, or nil if no segment is ready yet.
+func (m *Muxer) MPD() io.Reader {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if len(m.segments) == 0 {
+		return nil
+	}
+
+	maxDuration := time.Duration(0)
+	totalDuration := time.Duration(0)
+	for _, s := range m.segments {
+		d := s.videoDuration
+		if m.videoTrack == nil {
+			d = s.audioDuration
+		}
+		if d > maxDuration {
+			maxDuration = d
+		}
+		totalDuration += d
+	}
+
+	cnt := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011"` +
+		` type="dynamic"` +
+		` availabilityStartTime="` + formatTime(m.availabilityStartTime) + `"` +
+		` publishTime="` + formatTime(time.Now()) + `"` +
+		` minimumUpdatePeriod="` + formatDuration(m.segmentDuration) + `"` +
+		` minBufferTime="` + formatDuration(m.segmentDuration) + `"` +
+		` suggestedPresentationDelay="` + formatDuration(2*maxDuration) + `"` +
+		` maxSegmentDuration="` + formatDuration(maxDuration) + `"` +
+		` timeShiftBufferDepth="` + formatDuration(totalDuration) + `">` + "\n" +
+		`  <Period id="0" start="PT0S">` + "\n"
+
+	if m.videoTrack != nil {
+		cnt += `    <AdaptationSet id="0" contentType="video" mimeType="video/mp4" segmentAlignment="true" startWithSAP="1">` + "\n" +
+			m.segmentTemplate(fmp4.VideoTimeScale, "video", func(s *segment) (time.Duration, time.Duration) {
+				return s.videoStart, s.videoDuration
+			}) +
+			`      <Representation id="video" codecs="` + m.videoCodec + `"` +
+			` bandwidth="` + strconv.FormatInt(int64(m.bandwidth(true)), 10) + `"`
+		if m.videoWidth != 0 {
+			cnt += ` width="` + strconv.FormatInt(int64(m.videoWidth), 10) + `"` +
+				` height="` + strconv.FormatInt(int64(m.videoHeight), 10) + `"`
+		}
+		cnt += `/>` + "\n" +
+			`    </AdaptationSet>` + "\n"
+	}
+
+	if m.audioTrack != nil {
+		cnt += `    <AdaptationSet id="1" contentType="audio" mimeType="audio/mp4" segmentAlignment="true" startWithSAP="1">` + "\n" +
+			m.segmentTemplate(uint32(m.aacConfig.SampleRate), "audio", func(s *segment) (time.Duration, time.Duration) {
+				return s.audioStart, s.audioDuration
+			}) +
+			`      <Representation id="audio" codecs="mp4a.40.` + strconv.FormatInt(int64(m.aacConfig.Type), 10) + `"` +
+			` bandwidth="` + strconv.FormatInt(int64(m.bandwidth(false)), 10) + `"` +
+			` audioSamplingRate="` + strconv.FormatInt(int64(m.aacConfig.SampleRate), 10) + `">` + "\n" +
+			`        <AudioChannelConfiguration schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011"` +
+			` value="` + strconv.FormatInt(int64(m.aacConfig.ChannelCount), 10) + `"/>` + "\n" +
+			`      </Representation>` + "\n" +
+			`    </AdaptationSet>` + "\n"
+	}
+
+	cnt += `  </Period>` + "\n" +
+		`</MPD>` + "\n"
+
+	return bytes.NewReader([]byte(cnt))
+}
+
+// segmentTemplate returns the SegmentTemplate element of a track.
+// Segments are addressed by time, since a segment may not contain samples
+// of every track.
+func (m *Muxer) segmentTemplate(
+	timeScale uint32,
+	prefix string,
+	times func(s *segment) (time.Duration, time.Duration),
+) string {
+	ret := `      <SegmentTemplate timescale="` + strconv.FormatInt(int64(timeScale), 10) + `"` +
+		` initialization="` + prefix + `_init.mp4"` +
+		` media="` + prefix + `_$Time$.mp4">` + "\n" +
+		`        <SegmentTimeline>` + "\n"
+
+	for _, s := range m.segments {
+		start, duration := times(s)
+		if duration == 0 {
+			continue
+		}
+
+		t := durationToTimeScale(start, timeScale)
+		d := durationToTimeScale(start+duration, timeScale) - t
+
+		ret += `          <S t="` + strconv.FormatInt(t, 10) + `" d="` + strconv.FormatInt(d, 10) + `"/>` + "\n"
+	}
+
+	ret += `        </SegmentTimeline>` + "\n" +
+		`      </SegmentTemplate>` + "\n"
+
+	return ret
+}
+
+// bandwidth returns the peak bitrate of the video or audio track, in bits per second.
+func (m *Muxer) bandwidth(video bool) int {
+	ret := 0
+	for _, s := range m.segments {
+		byts, d := s.audio, s.audioDuration
+		if video {
+			byts, d = s.video, s.videoDuration
+		}
+		if d <= 0 {
+			continue
+		}
+
+		v := int(float64(len(byts)*8) / d.Seconds())
+		if v > ret {
+			ret = v
+		}
+	}
+	return ret
+}
+
+// File returns a reader to read a segment or an initialization section,
+// or nil if the file doesn't exist.
+func (m *Muxer) File(fname string) io.Reader {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	switch {
+	case fname == videoInitName && m.videoInit != nil:
+		return bytes.NewReader(m.videoInit)
+
+	case fname == audioInitName && m.audioInit != nil:
+		return bytes.NewReader(m.audioInit)
+
+	case strings.HasPrefix(fname, "video_") && strings.HasSuffix(fname, ".mp4"):
+		t, err := strconv.ParseInt(fname[len("video_"):len(fname)-len(".mp4")], 10, 64)
+		if err != nil {
+			return nil
+		}
+
+		for _, s := range m.segments {
+			if s.video != nil && durationToTimeScale(s.videoStart, fmp4.VideoTimeScale) == t {
+				return bytes.NewReader(s.video)
+			}
+		}
+
+	case strings.HasPrefix(fname, "audio_") && strings.HasSuffix(fname, ".mp4"):
+		t, err := strconv.ParseInt(fname[len("audio_"):len(fname)-len(".mp4")], 10, 64)
+		if err != nil {
+			return nil
+		}
+
+		for _, s := range m.segments {
+			if s.audio != nil && durationToTimeScale(s.audioStart, uint32(m.aacConfig.SampleRate)) == t {
+				return bytes.NewReader(s.audio)
+			}
+		}
+	}
+
+	return nil
+}
+
+// durationToTimeScale converts a duration into a time scale,
+// with the same rounding used in fragments.
+func durationToTimeScale(d time.Duration, timeScale uint32) int64 {
+	return int64(d/time.Second)*int64(timeScale) +
+		int64(d%time.Second)*int64(timeScale)/int64(time.Second)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// formatDuration formats a duration in the ISO 8601 format used by MPDs.
+func formatDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', 3, 64) + "S"
+}
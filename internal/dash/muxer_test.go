@@ -0,0 +1,108 @@
+package dash
+
+import (
+	"io/ioutil"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+var testSPS = []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x40}
+
+func TestMuxer(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, testSPS, []byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{17, 144})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, videoTrack, audioTrack)
+	require.NoError(t, err)
+
+	// no segments yet
+	require.Nil(t, m.MPD())
+
+	// group without IDR, that is skipped
+	err = m.WriteH264(0, [][]byte{{0x01}})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{
+			testSPS, // removed
+			{0x05},  // IDR
+		})
+		require.NoError(t, err)
+
+		err = m.WriteAAC(time.Duration(i)*time.Second, [][]byte{
+			{0x01, 0x02, 0x03, 0x04},
+			{0x05, 0x06, 0x07, 0x08},
+		})
+		require.NoError(t, err)
+
+		err = m.WriteH264(time.Duration(i)*time.Second+500*time.Millisecond, [][]byte{{0x01}})
+		require.NoError(t, err)
+	}
+
+	byts, err := ioutil.ReadAll(m.MPD())
+	require.NoError(t, err)
+
+	require.Regexp(t, regexp.MustCompile(`^<\?xml version="1.0" encoding="UTF-8"\?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="dynamic"`+
+		` availabilityStartTime="[0-9TZ:.-]+" publishTime="[0-9TZ:.-]+" minimumUpdatePeriod="PT1.000S"`+
+		` minBufferTime="PT1.000S" suggestedPresentationDelay="PT2.000S" maxSegmentDuration="PT1.000S"`+
+		` timeShiftBufferDepth="PT2.000S">
+  <Period id="0" start="PT0S">
+    <AdaptationSet id="0" contentType="video" mimeType="video/mp4" segmentAlignment="true" startWithSAP="1">
+      <SegmentTemplate timescale="90000" initialization="video_init.mp4" media="video_\$Time\$.mp4">
+        <SegmentTimeline>
+          <S t="0" d="90000"/>
+          <S t="90000" d="90000"/>
+        </SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="video" codecs="avc1.640028" bandwidth="[0-9]+" width="1920" height="1080"/>
+    </AdaptationSet>
+    <AdaptationSet id="1" contentType="audio" mimeType="audio/mp4" segmentAlignment="true" startWithSAP="1">
+      <SegmentTemplate timescale="48000" initialization="audio_init.mp4" media="audio_\$Time\$.mp4">
+        <SegmentTimeline>
+          <S t="0" d="2047"/>
+          <S t="48000" d="2047"/>
+        </SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="audio" codecs="mp4a.40.2" bandwidth="[0-9]+" audioSamplingRate="48000">
+        <AudioChannelConfiguration schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011" value="2"/>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+$`), string(byts))
+
+	require.NotNil(t, m.File("video_init.mp4"))
+	require.NotNil(t, m.File("audio_init.mp4"))
+	require.NotNil(t, m.File("video_90000.mp4"))
+	require.NotNil(t, m.File("audio_48000.mp4"))
+	require.Nil(t, m.File("video_1.mp4"))
+	require.Nil(t, m.File("video_abc.mp4"))
+}
+
+func TestMuxerAudioOnly(t *testing.T) {
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{17, 144})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, nil, audioTrack)
+	require.NoError(t, err)
+
+	auDuration := 1024 * time.Second / 48000
+	for i := 0; i < 100; i++ {
+		err = m.WriteAAC(time.Duration(i)*auDuration, [][]byte{{0x01, 0x02, 0x03, 0x04}})
+		require.NoError(t, err)
+	}
+
+	byts, err := ioutil.ReadAll(m.MPD())
+	require.NoError(t, err)
+	require.NotRegexp(t, `contentType="video"`, string(byts))
+	require.Regexp(t, `<S t="0" d="4[0-9]{4}"/>`, string(byts))
+	require.Nil(t, m.File("video_init.mp4"))
+}
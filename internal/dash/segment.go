@@ -0,0 +1,77 @@
+package dash
+
+import (
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/fmp4"
+)
+
+// segment is a group of samples that is served as two separate files,
+// one for each track, since DASH players expect tracks in different
+// adaptation sets.
+type segment struct {
+	videoSamples []*fmp4.Sample
+	audioSamples []*fmp4.Sample
+
+	// filled by finish()
+	videoStart    time.Duration
+	videoDuration time.Duration
+	video         []byte
+	audioStart    time.Duration
+	audioDuration time.Duration
+	audio         []byte
+}
+
+func (s *segment) isEmpty() bool {
+	return len(s.videoSamples) == 0 && len(s.audioSamples) == 0
+}
+
+// firstTime returns the timestamp of the first sample of the segment.
+func (s *segment) firstTime() time.Duration {
+	if len(s.videoSamples) > 0 {
+		return s.videoSamples[0].DTS
+	}
+	return s.audioSamples[0].DTS
+}
+
+// finish generates the fragments of the segment.
+// endTime is the timestamp of the access unit that follows the segment.
+func (s *segment) finish(endTime time.Duration, sequenceNumber *uint32, audioSampleRate int) {
+	if len(s.videoSamples) > 0 {
+		last := s.videoSamples[len(s.videoSamples)-1]
+		if endTime > last.DTS {
+			last.Duration = endTime - last.DTS
+		} else if len(s.videoSamples) > 1 {
+			last.Duration = s.videoSamples[len(s.videoSamples)-2].Duration
+		} else {
+			last.Duration = time.Millisecond
+		}
+
+		s.videoStart = s.videoSamples[0].DTS
+		s.videoDuration = last.DTS + last.Duration - s.videoStart
+
+		*sequenceNumber++
+		s.video = fmp4.GenerateFragment(*sequenceNumber, []*fmp4.FragmentTrack{{
+			ID:        fmp4.VideoTrackID,
+			TimeScale: fmp4.VideoTimeScale,
+			Samples:   s.videoSamples,
+		}})
+	}
+
+	if len(s.audioSamples) > 0 {
+		last := s.audioSamples[len(s.audioSamples)-1]
+		s.audioStart = s.audioSamples[0].DTS
+		s.audioDuration = last.DTS + last.Duration - s.audioStart
+
+		*sequenceNumber++
+		s.audio = fmp4.GenerateFragment(*sequenceNumber, []*fmp4.FragmentTrack{{
+			ID:        fmp4.AudioTrackID,
+			TimeScale: uint32(audioSampleRate),
+			Samples:   s.audioSamples,
+		}})
+	}
+
+	// samples are not needed anymore
+	s.videoSamples = nil
+	s.audioSamples = nil
+}
@@ -10,8 +10,20 @@ const (
 
 // Environment is a Cmd environment.
 type Environment struct {
-	Path string
-	Port string
+	Path   string
+	Port   string
+	Reason string
+
+	// used by on-demand commands
+	Query string
+
+	// used by commands that are notified about source switches
+	SourceURL string
+
+	// used by commands that generate HLS renditions
+	RenditionPath    string
+	RenditionHeight  string
+	RenditionBitrate string
 }
 
 // Cmd is an external command.
@@ -42,6 +54,18 @@ func New(cmdstr string, restart bool, env Environment) *Cmd {
 	return e
 }
 
+// RunOnce starts a command in the background, that is not restarted
+// and is left running until it exits.
+func RunOnce(cmdstr string, env Environment) {
+	e := &Cmd{
+		cmdstr:    cmdstr,
+		env:       env,
+		terminate: make(chan struct{}),
+	}
+
+	go e.runInner()
+}
+
 // Close closes an Cmd.
 func (e *Cmd) Close() {
 	close(e.terminate)
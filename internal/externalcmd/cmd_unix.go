@@ -14,6 +14,12 @@ func (e *Cmd) runInner() bool {
 	cmd.Env = append(os.Environ(),
 		"RTSP_PATH="+e.env.Path,
 		"RTSP_PORT="+e.env.Port,
+		"RTSP_REASON="+e.env.Reason,
+		"RTSP_QUERY="+e.env.Query,
+		"RTSP_SOURCE_URL="+e.env.SourceURL,
+		"RTSP_RENDITION_PATH="+e.env.RenditionPath,
+		"RTSP_RENDITION_HEIGHT="+e.env.RenditionHeight,
+		"RTSP_RENDITION_BITRATE="+e.env.RenditionBitrate,
 	)
 
 	cmd.Stdout = os.Stdout
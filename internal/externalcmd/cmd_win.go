@@ -16,6 +16,12 @@ func (e *Cmd) runInner() bool {
 	// with Linux commands
 	tmp := strings.ReplaceAll(e.cmdstr, "$RTSP_PATH", e.env.Path)
 	tmp = strings.ReplaceAll(tmp, "$RTSP_PORT", e.env.Port)
+	tmp = strings.ReplaceAll(tmp, "$RTSP_REASON", e.env.Reason)
+	tmp = strings.ReplaceAll(tmp, "$RTSP_QUERY", e.env.Query)
+	tmp = strings.ReplaceAll(tmp, "$RTSP_SOURCE_URL", e.env.SourceURL)
+	tmp = strings.ReplaceAll(tmp, "$RTSP_RENDITION_PATH", e.env.RenditionPath)
+	tmp = strings.ReplaceAll(tmp, "$RTSP_RENDITION_HEIGHT", e.env.RenditionHeight)
+	tmp = strings.ReplaceAll(tmp, "$RTSP_RENDITION_BITRATE", e.env.RenditionBitrate)
 	parts, err := shellquote.Split(tmp)
 	if err != nil {
 		return true
@@ -26,6 +32,12 @@ func (e *Cmd) runInner() bool {
 	cmd.Env = append(os.Environ(),
 		"RTSP_PATH="+e.env.Path,
 		"RTSP_PORT="+e.env.Port,
+		"RTSP_REASON="+e.env.Reason,
+		"RTSP_QUERY="+e.env.Query,
+		"RTSP_SOURCE_URL="+e.env.SourceURL,
+		"RTSP_RENDITION_PATH="+e.env.RenditionPath,
+		"RTSP_RENDITION_HEIGHT="+e.env.RenditionHeight,
+		"RTSP_RENDITION_BITRATE="+e.env.RenditionBitrate,
 	)
 
 	cmd.Stdout = os.Stdout
@@ -0,0 +1,65 @@
+// Package fmp4 contains a fragmented MP4 (ISO/IEC 14496-12) writer,
+// that produces initialization sections and fragments compatible with CMAF.
+package fmp4
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// box writes a box with the given type and content.
+func box(typ string, content ...[]byte) []byte {
+	size := 8
+	for _, c := range content {
+		size += len(c)
+	}
+
+	ret := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(ret, uint32(size))
+	copy(ret[4:], typ)
+
+	for _, c := range content {
+		ret = append(ret, c...)
+	}
+
+	return ret
+}
+
+// fullBox writes a box with a version and flags.
+func fullBox(typ string, version uint8, flags uint32, content ...[]byte) []byte {
+	vf := make([]byte, 4)
+	binary.BigEndian.PutUint32(vf, uint32(version)<<24|(flags&0xFFFFFF))
+	return box(typ, append([][]byte{vf}, content...)...)
+}
+
+func uint16b(v uint16) []byte {
+	ret := make([]byte, 2)
+	binary.BigEndian.PutUint16(ret, v)
+	return ret
+}
+
+func uint32b(v uint32) []byte {
+	ret := make([]byte, 4)
+	binary.BigEndian.PutUint32(ret, v)
+	return ret
+}
+
+func uint64b(v uint64) []byte {
+	ret := make([]byte, 8)
+	binary.BigEndian.PutUint64(ret, v)
+	return ret
+}
+
+// durationToTimeScale converts a duration into the given time scale,
+// without overflowing on long durations.
+func durationToTimeScale(d time.Duration, timeScale uint32) int64 {
+	return int64(d/time.Second)*int64(timeScale) +
+		int64(d%time.Second)*int64(timeScale)/int64(time.Second)
+}
+
+// unity matrix used by mvhd and tkhd.
+var matrix = []byte{
+	0x00, 0x01, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0x00, 0x01, 0x00, 0x00, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0x00, 0x00, 0x00,
+}
@@ -0,0 +1,26 @@
+package fmp4
+
+import (
+	"time"
+)
+
+// ID3SchemeIDURI is the scheme of event messages that contain ID3 tags.
+const ID3SchemeIDURI = "https://aomedia.org/emsg/ID3"
+
+// time scale of event messages.
+const emsgTimeScale = 90000
+
+// GenerateEmsg generates an event message box (emsg), in version 1, that
+// is presented at the given time of the media timeline.
+// It must be written before the moof box of the fragment it refers to.
+func GenerateEmsg(schemeIDURI string, value string, presentationTime time.Duration, id uint32, data []byte) []byte {
+	return fullBox("emsg", 1, 0,
+		uint32b(emsgTimeScale),
+		uint64b(uint64(durationToTimeScale(presentationTime, emsgTimeScale))),
+		uint32b(0xFFFFFFFF), // unknown duration
+		uint32b(id),
+		append([]byte(schemeIDURI), 0x00),
+		append([]byte(value), 0x00),
+		data,
+	)
+}
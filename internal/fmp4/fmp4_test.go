@@ -0,0 +1,156 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/h265"
+)
+
+var testSPS = []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x40}
+
+// boxTypes returns the types of the top-level boxes in buf.
+func boxTypes(t *testing.T, buf []byte) []string {
+	var ret []string
+	for len(buf) > 0 {
+		require.GreaterOrEqual(t, len(buf), 8)
+		size := int(binary.BigEndian.Uint32(buf))
+		require.GreaterOrEqual(t, len(buf), size)
+		ret = append(ret, string(buf[4:8]))
+		buf = buf[size:]
+	}
+	return ret
+}
+
+func TestGenerateInit(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, testSPS, []byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{17, 144})
+	require.NoError(t, err)
+
+	for _, ca := range []struct {
+		name       string
+		videoTrack *gortsplib.Track
+		audioTrack *gortsplib.Track
+		moovBoxes  []string
+	}{
+		{"video", videoTrack, nil, []string{"mvhd", "trak", "mvex"}},
+		{"audio", nil, audioTrack, []string{"mvhd", "trak", "mvex"}},
+		{"video+audio", videoTrack, audioTrack, []string{"mvhd", "trak", "trak", "mvex"}},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			byts, err := GenerateInit(ca.videoTrack, ca.audioTrack)
+			require.NoError(t, err)
+			require.Equal(t, []string{"ftyp", "moov"}, boxTypes(t, byts))
+
+			ftypSize := binary.BigEndian.Uint32(byts)
+			require.Equal(t, ca.moovBoxes, boxTypes(t, byts[ftypSize+8:]))
+		})
+	}
+
+	_, err = GenerateInit(nil, nil)
+	require.Error(t, err)
+}
+
+func TestGenerateInitH265(t *testing.T) {
+	videoTrack := h265.NewTrack(96,
+		[]byte{
+			0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60,
+			0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03,
+			0x00, 0x00, 0x03, 0x00, 0x78, 0x99, 0x98, 0x09,
+		},
+		[]byte{
+			0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+			0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+			0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+			0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+			0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+			0xe0, 0x80,
+		},
+		[]byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40})
+
+	byts, err := GenerateInit(videoTrack, nil)
+	require.NoError(t, err)
+	require.Contains(t, string(byts), "hvc1")
+	require.Contains(t, string(byts), "hvcC")
+	require.NotContains(t, string(byts), "avc1")
+}
+
+func TestGenerateFragment(t *testing.T) {
+	byts := GenerateFragment(3, []*FragmentTrack{
+		{
+			ID:        VideoTrackID,
+			TimeScale: VideoTimeScale,
+			Samples: []*Sample{
+				{
+					DTS:       2 * time.Second,
+					PTSOffset: 40 * time.Millisecond,
+					Duration:  40 * time.Millisecond,
+					IsSync:    true,
+					Data:      []byte{0x00, 0x00, 0x00, 0x01, 0x05},
+				},
+				{
+					DTS:      2*time.Second + 40*time.Millisecond,
+					Duration: 40 * time.Millisecond,
+					Data:     []byte{0x00, 0x00, 0x00, 0x01, 0x01},
+				},
+			},
+		},
+		{
+			ID:        AudioTrackID,
+			TimeScale: 44100,
+			Samples: []*Sample{
+				{
+					DTS:      2 * time.Second,
+					Duration: 1024 * time.Second / 44100,
+					IsSync:   true,
+					Data:     []byte{0x01, 0x02, 0x03},
+				},
+			},
+		},
+	})
+	require.Equal(t, []string{"moof", "mdat"}, boxTypes(t, byts))
+
+	moofSize := binary.BigEndian.Uint32(byts)
+	require.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x05,
+		0x00, 0x00, 0x00, 0x01, 0x01,
+		0x01, 0x02, 0x03,
+	}, byts[moofSize+8:])
+
+	// sequence number
+	require.Equal(t, uint32(3), binary.BigEndian.Uint32(byts[8+12:]))
+
+	// data offset of the audio track points to the audio sample
+	moof := byts[:moofSize]
+	trafs := boxTypes(t, moof[8+16:])
+	require.Equal(t, []string{"traf", "traf"}, trafs)
+
+	videoTrafSize := binary.BigEndian.Uint32(moof[8+16:])
+	audioTraf := moof[8+16+videoTrafSize:]
+	tfhdSize := binary.BigEndian.Uint32(audioTraf[8:])
+	tfdt := audioTraf[8+tfhdSize:]
+	require.Equal(t, uint64(2*44100), binary.BigEndian.Uint64(tfdt[12:]))
+	trun := tfdt[binary.BigEndian.Uint32(tfdt):]
+	dataOffset := binary.BigEndian.Uint32(trun[16:])
+	require.Equal(t, []byte{0x01, 0x02, 0x03}, byts[dataOffset:dataOffset+3])
+
+	require.Nil(t, GenerateFragment(1, []*FragmentTrack{{ID: VideoTrackID, TimeScale: VideoTimeScale}}))
+}
+
+func TestGenerateEmsg(t *testing.T) {
+	byts := GenerateEmsg(ID3SchemeIDURI, "", 2*time.Second, 5, []byte{0x01, 0x02})
+	require.Equal(t, []string{"emsg"}, boxTypes(t, byts))
+
+	// version 1
+	require.Equal(t, byte(1), byts[8])
+	require.Equal(t, uint32(90000), binary.BigEndian.Uint32(byts[12:]))
+	require.Equal(t, uint64(2*90000), binary.BigEndian.Uint64(byts[16:]))
+	require.Equal(t, uint32(5), binary.BigEndian.Uint32(byts[28:]))
+	require.Equal(t, append([]byte(ID3SchemeIDURI+"\x00\x00"), 0x01, 0x02), byts[32:])
+}
@@ -0,0 +1,135 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// sample flags.
+const (
+	sampleFlagsSync    = 0x02000000 // sample depends on no other samples
+	sampleFlagsNonSync = 0x01010000 // sample depends on others, is a non-sync sample
+)
+
+// Sample is a sample of a fragment.
+type Sample struct {
+	// decoding timestamp
+	DTS time.Duration
+
+	// difference between presentation and decoding timestamp
+	PTSOffset time.Duration
+
+	// duration of the sample
+	Duration time.Duration
+
+	// whether the sample can be decoded without previous samples
+	IsSync bool
+
+	// sample content. H264 samples are in AVCC format.
+	Data []byte
+}
+
+// FragmentTrack is a track of a fragment.
+type FragmentTrack struct {
+	ID        int
+	TimeScale uint32
+	Samples   []*Sample
+}
+
+func generateTRAF(track *FragmentTrack) ([]byte, int) {
+	baseDTS := durationToTimeScale(track.Samples[0].DTS, track.TimeScale)
+
+	trun := make([]byte, 0, len(track.Samples)*16)
+	for _, s := range track.Samples {
+		dur := durationToTimeScale(s.DTS+s.Duration, track.TimeScale) -
+			durationToTimeScale(s.DTS, track.TimeScale)
+
+		flags := uint32(sampleFlagsNonSync)
+		if s.IsSync {
+			flags = sampleFlagsSync
+		}
+
+		trun = append(trun, uint32b(uint32(dur))...)
+		trun = append(trun, uint32b(uint32(len(s.Data)))...)
+		trun = append(trun, uint32b(flags)...)
+		trun = append(trun, uint32b(uint32(int32(durationToTimeScale(s.PTSOffset, track.TimeScale))))...)
+	}
+
+	traf := box("traf",
+		fullBox("tfhd", 0, 0x020000, // default-base-is-moof
+			uint32b(uint32(track.ID)),
+		),
+		fullBox("tfdt", 1, 0,
+			uint64b(uint64(baseDTS)),
+		),
+		fullBox("trun", 1, 0xF01, // data offset, duration, size, flags, composition time offset
+			uint32b(uint32(len(track.Samples))),
+			uint32b(0), // data offset, filled later
+			trun,
+		),
+	)
+
+	// position of the data offset, relative to the start of traf
+	dataOffsetPos := len(traf) - len(trun) - 4
+
+	return traf, dataOffsetPos
+}
+
+// GenerateFragment generates a fragment (moof and mdat) that contains
+// the samples of the given tracks. Tracks without samples are skipped.
+func GenerateFragment(sequenceNumber uint32, tracks []*FragmentTrack) []byte {
+	var trafs [][]byte
+	var dataOffsetPositions []int
+	var dataSizes []int
+	var tracksWithSamples []*FragmentTrack
+
+	for _, track := range tracks {
+		if len(track.Samples) == 0 {
+			continue
+		}
+
+		traf, pos := generateTRAF(track)
+		trafs = append(trafs, traf)
+		dataOffsetPositions = append(dataOffsetPositions, pos)
+		tracksWithSamples = append(tracksWithSamples, track)
+
+		size := 0
+		for _, s := range track.Samples {
+			size += len(s.Data)
+		}
+		dataSizes = append(dataSizes, size)
+	}
+
+	if len(trafs) == 0 {
+		return nil
+	}
+
+	moof := box("moof",
+		append([][]byte{fullBox("mfhd", 0, 0, uint32b(sequenceNumber))}, trafs...)...)
+
+	// fill data offsets, that are relative to the start of moof
+	trafPos := 8 + 16 // moof header + mfhd
+	dataOffset := len(moof) + 8
+	for i, traf := range trafs {
+		binary.BigEndian.PutUint32(moof[trafPos+dataOffsetPositions[i]:], uint32(dataOffset))
+		trafPos += len(traf)
+		dataOffset += dataSizes[i]
+	}
+
+	mdatSize := 8
+	for _, size := range dataSizes {
+		mdatSize += size
+	}
+
+	ret := make([]byte, 0, len(moof)+mdatSize)
+	ret = append(ret, moof...)
+	ret = append(ret, uint32b(uint32(mdatSize))...)
+	ret = append(ret, []byte("mdat")...)
+	for _, track := range tracksWithSamples {
+		for _, s := range track.Samples {
+			ret = append(ret, s.Data...)
+		}
+	}
+
+	return ret
+}
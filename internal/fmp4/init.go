@@ -0,0 +1,357 @@
+package fmp4
+
+import (
+	"fmt"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
+)
+
+// track IDs used in initialization sections and fragments.
+const (
+	VideoTrackID = 1
+	AudioTrackID = 2
+)
+
+// VideoTimeScale is the time scale of the video track.
+const VideoTimeScale = 90000
+
+func generateTKHD(trackID int, isVideo bool, width int, height int) []byte {
+	volume := uint16(0)
+	if !isVideo {
+		volume = 0x0100
+	}
+
+	return fullBox("tkhd", 0, 3, // track enabled and in movie
+		uint32b(0), // creation time
+		uint32b(0), // modification time
+		uint32b(uint32(trackID)),
+		uint32b(0), // reserved
+		uint32b(0), // duration
+		make([]byte, 8),
+		uint16b(0), // layer
+		uint16b(0), // alternate group
+		uint16b(volume),
+		uint16b(0), // reserved
+		matrix,
+		uint32b(uint32(width)<<16),
+		uint32b(uint32(height)<<16),
+	)
+}
+
+func generateMDHD(timeScale uint32) []byte {
+	return fullBox("mdhd", 0, 0,
+		uint32b(0), // creation time
+		uint32b(0), // modification time
+		uint32b(timeScale),
+		uint32b(0),      // duration
+		uint16b(0x55C4), // language = "und"
+		uint16b(0),      // pre-defined
+	)
+}
+
+func generateHDLR(handlerType string, name string) []byte {
+	return fullBox("hdlr", 0, 0,
+		uint32b(0), // pre-defined
+		[]byte(handlerType),
+		make([]byte, 12), // reserved
+		append([]byte(name), 0),
+	)
+}
+
+func generateSTBL(stsd []byte) []byte {
+	return box("stbl",
+		stsd,
+		fullBox("stts", 0, 0, uint32b(0)),
+		fullBox("stsc", 0, 0, uint32b(0)),
+		fullBox("stsz", 0, 0, uint32b(0), uint32b(0)),
+		fullBox("stco", 0, 0, uint32b(0)),
+	)
+}
+
+func generateDINF() []byte {
+	return box("dinf",
+		fullBox("dref", 0, 0,
+			uint32b(1),
+			fullBox("url ", 0, 1), // data is in the same file
+		),
+	)
+}
+
+// generateVisualSampleEntry generates a sample entry of a video track,
+// that contains the given decoder configuration box.
+func generateVisualSampleEntry(typ string, width int, height int, config []byte) []byte {
+	compressorName := make([]byte, 32)
+
+	return box(typ,
+		make([]byte, 6), // reserved
+		uint16b(1),      // data reference index
+		make([]byte, 16),
+		uint16b(uint16(width)),
+		uint16b(uint16(height)),
+		uint32b(0x00480000), // horizontal resolution = 72 dpi
+		uint32b(0x00480000), // vertical resolution = 72 dpi
+		uint32b(0),          // reserved
+		uint16b(1),          // frame count
+		compressorName,
+		uint16b(0x18),   // depth
+		uint16b(0xFFFF), // pre-defined = -1
+		config,
+	)
+}
+
+func generateAVC1(videoTrack *gortsplib.Track) ([]byte, int, int, error) {
+	sps, pps, err := videoTrack.ExtractDataH264()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	spsp, err := h264.DecodeSPS(sps)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	avcC := box("avcC",
+		[]byte{
+			1, // configuration version
+			sps[1],
+			sps[2],
+			sps[3],
+			0xFF, // NALU length size = 4
+			0xE1, // number of SPS = 1
+		},
+		uint16b(uint16(len(sps))),
+		sps,
+		[]byte{1}, // number of PPS
+		uint16b(uint16(len(pps))),
+		pps,
+	)
+
+	return generateVisualSampleEntry("avc1", spsp.Width, spsp.Height, avcC), spsp.Width, spsp.Height, nil
+}
+
+// generateHVC1 generates a H265 sample entry, whose decoder configuration
+// record (ISO/IEC 14496-15) contains the VPS, SPS and PPS.
+func generateHVC1(videoTrack *gortsplib.Track) ([]byte, int, int, error) {
+	vps, sps, pps, err := h265.ExtractParams(videoTrack)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	spsp, err := h265.DecodeSPS(sps)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	nestingFlag := byte(0)
+	if spsp.TemporalIDNestingFlag {
+		nestingFlag = 1
+	}
+
+	content := [][]byte{
+		{
+			1, // configuration version
+			spsp.ProfileSpace<<6 | spsp.TierFlag<<5 | spsp.ProfileIdc,
+		},
+		uint32b(spsp.ProfileCompatibilityFlags),
+		spsp.ConstraintIndicatorFlags[:],
+		{
+			spsp.LevelIdc,
+			0xF0, 0x00, // min spatial segmentation = 0
+			0xFC,                        // parallelism type = unknown
+			0xFC | spsp.ChromaFormatIdc, // chroma format
+			0xF8 | spsp.BitDepthLumaMinus8,
+			0xF8 | spsp.BitDepthChromaMinus8,
+			0x00, 0x00, // average frame rate = unknown
+			// constant frame rate = 0, number of temporal layers,
+			// temporal ID nesting, NALU length size = 4
+			(spsp.MaxSubLayersMinus1+1)<<3 | nestingFlag<<2 | 0x03,
+			3, // number of arrays
+		},
+	}
+
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		content = append(content,
+			[]byte{0x80 | byte(h265.NALUTypeOf(nalu))}, // array completeness + NALU type
+			uint16b(1), // number of NALUs
+			uint16b(uint16(len(nalu))),
+			nalu,
+		)
+	}
+
+	hvcC := box("hvcC", content...)
+
+	return generateVisualSampleEntry("hvc1", spsp.Width, spsp.Height, hvcC), spsp.Width, spsp.Height, nil
+}
+
+func generateVideoTrak(videoTrack *gortsplib.Track) ([]byte, error) {
+	var sampleEntry []byte
+	var width int
+	var height int
+	var err error
+
+	if h265.IsTrack(videoTrack) {
+		sampleEntry, width, height, err = generateHVC1(videoTrack)
+	} else {
+		sampleEntry, width, height, err = generateAVC1(videoTrack)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return box("trak",
+		generateTKHD(VideoTrackID, true, width, height),
+		box("mdia",
+			generateMDHD(VideoTimeScale),
+			generateHDLR("vide", "VideoHandler"),
+			box("minf",
+				fullBox("vmhd", 0, 1, make([]byte, 8)),
+				generateDINF(),
+				generateSTBL(fullBox("stsd", 0, 0, uint32b(1), sampleEntry)),
+			),
+		),
+	), nil
+}
+
+// generateESDS generates an ES descriptor (ISO/IEC 14496-1) that contains
+// the AAC configuration.
+func generateESDS(config []byte) []byte {
+	descriptor := func(tag byte, content ...[]byte) []byte {
+		size := 0
+		for _, c := range content {
+			size += len(c)
+		}
+
+		ret := []byte{tag, byte(size)}
+		for _, c := range content {
+			ret = append(ret, c...)
+		}
+		return ret
+	}
+
+	return fullBox("esds", 0, 0,
+		descriptor(0x03, // ES_DescrTag
+			uint16b(AudioTrackID), // ES ID
+			[]byte{0},             // flags
+			descriptor(0x04, // DecoderConfigDescrTag
+				[]byte{
+					0x40,             // object type indication = MPEG-4 audio
+					0x15,             // stream type = audio
+					0x00, 0x00, 0x00, // buffer size
+				},
+				uint32b(0),               // max bitrate
+				uint32b(0),               // average bitrate
+				descriptor(0x05, config), // DecSpecificInfoTag
+			),
+			descriptor(0x06, []byte{0x02}), // SLConfigDescrTag
+		),
+	)
+}
+
+func generateAudioTrak(audioTrack *gortsplib.Track) ([]byte, error) {
+	config, err := audioTrack.ExtractDataAAC()
+	if err != nil {
+		return nil, err
+	}
+
+	var aacConfig rtpaac.MPEG4AudioConfig
+	err = aacConfig.Decode(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config) > 127 {
+		return nil, fmt.Errorf("AAC configuration is too big")
+	}
+
+	mp4a := box("mp4a",
+		make([]byte, 6), // reserved
+		uint16b(1),      // data reference index
+		make([]byte, 8), // reserved
+		uint16b(uint16(aacConfig.ChannelCount)),
+		uint16b(16), // sample size
+		uint16b(0),  // pre-defined
+		uint16b(0),  // reserved
+		uint32b(uint32(aacConfig.SampleRate)<<16),
+		generateESDS(config),
+	)
+
+	return box("trak",
+		generateTKHD(AudioTrackID, false, 0, 0),
+		box("mdia",
+			generateMDHD(uint32(aacConfig.SampleRate)),
+			generateHDLR("soun", "SoundHandler"),
+			box("minf",
+				fullBox("smhd", 0, 0, make([]byte, 4)),
+				generateDINF(),
+				generateSTBL(fullBox("stsd", 0, 0, uint32b(1), mp4a)),
+			),
+		),
+	), nil
+}
+
+func generateTREX(trackID int) []byte {
+	return fullBox("trex", 0, 0,
+		uint32b(uint32(trackID)),
+		uint32b(1), // default sample description index
+		uint32b(0), // default sample duration
+		uint32b(0), // default sample size
+		uint32b(0), // default sample flags
+	)
+}
+
+// GenerateInit generates an initialization section, that describes
+// a H264 or H265 track, an AAC track, or both.
+func GenerateInit(videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) ([]byte, error) {
+	if videoTrack == nil && audioTrack == nil {
+		return nil, fmt.Errorf("at least one track is needed")
+	}
+
+	mvhd := fullBox("mvhd", 0, 0,
+		uint32b(0),          // creation time
+		uint32b(0),          // modification time
+		uint32b(1000),       // time scale
+		uint32b(0),          // duration
+		uint32b(0x00010000), // rate
+		uint16b(0x0100),     // volume
+		make([]byte, 10),    // reserved
+		matrix,
+		make([]byte, 24),        // pre-defined
+		uint32b(AudioTrackID+1), // next track ID
+	)
+
+	moov := [][]byte{mvhd}
+	var mvex [][]byte
+
+	if videoTrack != nil {
+		trak, err := generateVideoTrak(videoTrack)
+		if err != nil {
+			return nil, err
+		}
+		moov = append(moov, trak)
+		mvex = append(mvex, generateTREX(VideoTrackID))
+	}
+
+	if audioTrack != nil {
+		trak, err := generateAudioTrak(audioTrack)
+		if err != nil {
+			return nil, err
+		}
+		moov = append(moov, trak)
+		mvex = append(mvex, generateTREX(AudioTrackID))
+	}
+
+	moov = append(moov, box("mvex", mvex...))
+
+	ftyp := box("ftyp",
+		[]byte("iso5"), // major brand
+		uint32b(512),   // minor version
+		[]byte("iso6"), // compatible brands
+		[]byte("mp41"),
+	)
+
+	return append(ftyp, box("moov", moov...)...), nil
+}
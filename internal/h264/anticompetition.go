@@ -45,46 +45,23 @@ func AntiCompetitionRemove(nalu []byte) []byte {
 	// 0x00 0x00 0x03 0x02 -> 0x00 0x00 0x02
 	// 0x00 0x00 0x03 0x03 -> 0x00 0x00 0x03
 
-	var ret []byte
-	step := 0
-	start := 0
-
-	for i, b := range nalu {
-		switch step {
-		case 0:
-			if b == 0 {
-				step++
-			}
-
-		case 1:
-			if b == 0 {
-				step++
-			} else {
-				step = 0
-			}
+	ret := make([]byte, 0, len(nalu))
+	zeros := 0
 
-		case 2:
-			if b == 3 {
-				step++
-			} else {
-				step = 0
-			}
+	for _, b := range nalu {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
 
-		case 3:
-			switch b {
-			case 3, 2, 1, 0:
-				ret = append(ret, nalu[start:i-3]...)
-				ret = append(ret, []byte{0x00, 0x00, b}...)
-				step = 0
-				start = i + 1
+		ret = append(ret, b)
 
-			default:
-				step = 0
-			}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
 		}
 	}
 
-	ret = append(ret, nalu[start:]...)
-
 	return ret
 }
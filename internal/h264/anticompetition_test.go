@@ -45,3 +45,14 @@ func TestAntiCompetitionRemove(t *testing.T) {
 		})
 	}
 }
+
+func TestAntiCompetitionRemoveConsecutive(t *testing.T) {
+	unproc := AntiCompetitionRemove([]byte{
+		0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x78,
+		0x00, 0x00, 0x00, 0x03, 0x01,
+	})
+	require.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x78,
+		0x00, 0x00, 0x00, 0x01,
+	}, unproc)
+}
@@ -0,0 +1,315 @@
+package h264
+
+import (
+	"fmt"
+)
+
+type bitReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *bitReader) readBit() (uint32, error) {
+	if r.pos >= len(r.buf)*8 {
+		return 0, fmt.Errorf("not enough bits")
+	}
+	v := uint32(r.buf[r.pos/8]>>(7-(r.pos%8))) & 0x01
+	r.pos++
+	return v, nil
+}
+
+func (r *bitReader) readBits(n int) (uint32, error) {
+	var v uint32
+	for i := 0; i < n; i++ {
+		b, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = (v << 1) | b
+	}
+	return v, nil
+}
+
+func (r *bitReader) readFlag() (bool, error) {
+	b, err := r.readBit()
+	return b == 1, err
+}
+
+// readUE reads an unsigned Exp-Golomb code.
+func (r *bitReader) readUE() (uint32, error) {
+	leadingZeros := 0
+	for {
+		b, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		leadingZeros++
+		if leadingZeros > 31 {
+			return 0, fmt.Errorf("invalid Exp-Golomb code")
+		}
+	}
+
+	v, err := r.readBits(leadingZeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1 << leadingZeros) - 1 + v, nil
+}
+
+// readSE reads a signed Exp-Golomb code.
+func (r *bitReader) readSE() (int32, error) {
+	v, err := r.readUE()
+	if err != nil {
+		return 0, err
+	}
+	if (v & 0x01) != 0 {
+		return int32((v + 1) / 2), nil
+	}
+	return -int32(v / 2), nil
+}
+
+func skipScalingList(r *bitReader, size int) error {
+	lastScale := int32(8)
+	nextScale := int32(8)
+	for j := 0; j < size; j++ {
+		if nextScale != 0 {
+			delta, err := r.readSE()
+			if err != nil {
+				return err
+			}
+			nextScale = (lastScale + delta + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+	return nil
+}
+
+// SPS contains the parameters of a sequence parameter set
+// that are needed to describe a stream.
+type SPS struct {
+	ProfileIdc uint8
+	LevelIdc   uint8
+	Width      int
+	Height     int
+}
+
+// DecodeSPS decodes a sequence parameter set.
+func DecodeSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 4 {
+		return nil, fmt.Errorf("SPS is too short")
+	}
+
+	if NALUType(nalu[0]&0x1F) != NALUTypeSPS {
+		return nil, fmt.Errorf("not a SPS")
+	}
+
+	s := &SPS{
+		ProfileIdc: nalu[1],
+		LevelIdc:   nalu[3],
+	}
+
+	r := &bitReader{buf: AntiCompetitionRemove(nalu[4:])}
+
+	// seq_parameter_set_id
+	_, err := r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	chromaFormatIdc := uint32(1)
+	separateColourPlane := false
+
+	switch s.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIdc, err = r.readUE()
+		if err != nil {
+			return nil, err
+		}
+
+		if chromaFormatIdc == 3 {
+			separateColourPlane, err = r.readFlag()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// bit_depth_luma_minus8, bit_depth_chroma_minus8
+		for i := 0; i < 2; i++ {
+			_, err = r.readUE()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// qpprime_y_zero_transform_bypass_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return nil, err
+		}
+
+		scalingMatrixPresent, err := r.readFlag()
+		if err != nil {
+			return nil, err
+		}
+
+		if scalingMatrixPresent {
+			count := 8
+			if chromaFormatIdc == 3 {
+				count = 12
+			}
+
+			for i := 0; i < count; i++ {
+				present, err := r.readFlag()
+				if err != nil {
+					return nil, err
+				}
+
+				if present {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+
+					err = skipScalingList(r, size)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	// log2_max_frame_num_minus4
+	_, err = r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	picOrderCntType, err := r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	switch picOrderCntType {
+	case 0:
+		// log2_max_pic_order_cnt_lsb_minus4
+		_, err = r.readUE()
+		if err != nil {
+			return nil, err
+		}
+
+	case 1:
+		// delta_pic_order_always_zero_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return nil, err
+		}
+
+		// offset_for_non_ref_pic, offset_for_top_to_bottom_field
+		for i := 0; i < 2; i++ {
+			_, err = r.readSE()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		numRefFramesInPicOrderCntCycle, err := r.readUE()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := uint32(0); i < numRefFramesInPicOrderCntCycle; i++ {
+			_, err = r.readSE()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// max_num_ref_frames
+	_, err = r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	// gaps_in_frame_num_value_allowed_flag
+	_, err = r.readFlag()
+	if err != nil {
+		return nil, err
+	}
+
+	picWidthInMbsMinus1, err := r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	picHeightInMapUnitsMinus1, err := r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	frameMbsOnly, err := r.readFlag()
+	if err != nil {
+		return nil, err
+	}
+
+	if !frameMbsOnly {
+		// mb_adaptive_frame_field_flag
+		_, err = r.readFlag()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// direct_8x8_inference_flag
+	_, err = r.readFlag()
+	if err != nil {
+		return nil, err
+	}
+
+	frameCropping, err := r.readFlag()
+	if err != nil {
+		return nil, err
+	}
+
+	var crop [4]uint32 // left, right, top, bottom
+	if frameCropping {
+		for i := range crop {
+			crop[i], err = r.readUE()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	frameHeightFactor := 2
+	if frameMbsOnly {
+		frameHeightFactor = 1
+	}
+
+	cropUnitX := 1
+	cropUnitY := frameHeightFactor
+	if chromaFormatIdc != 0 && !separateColourPlane {
+		subWidthC := 2
+		subHeightC := 2
+		switch chromaFormatIdc {
+		case 2:
+			subHeightC = 1
+		case 3:
+			subWidthC = 1
+			subHeightC = 1
+		}
+		cropUnitX = subWidthC
+		cropUnitY = subHeightC * frameHeightFactor
+	}
+
+	s.Width = int(picWidthInMbsMinus1+1)*16 - int(crop[0]+crop[1])*cropUnitX
+	s.Height = frameHeightFactor*int(picHeightInMapUnitsMinus1+1)*16 - int(crop[2]+crop[3])*cropUnitY
+
+	return s, nil
+}
@@ -0,0 +1,50 @@
+package h264
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSPS(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		byts []byte
+		sps  SPS
+	}{
+		{
+			"baseline",
+			[]byte{0x67, 0x42, 0xc0, 0x0a, 0xda, 0x10, 0x99},
+			SPS{
+				ProfileIdc: 66,
+				LevelIdc:   10,
+				Width:      64,
+				Height:     64,
+			},
+		},
+		{
+			"high with cropping",
+			[]byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x40},
+			SPS{
+				ProfileIdc: 100,
+				LevelIdc:   40,
+				Width:      1920,
+				Height:     1080,
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			sps, err := DecodeSPS(ca.byts)
+			require.NoError(t, err)
+			require.Equal(t, ca.sps, *sps)
+		})
+	}
+}
+
+func TestDecodeSPSErrors(t *testing.T) {
+	_, err := DecodeSPS([]byte{0x68, 0x42, 0xc0, 0x0a})
+	require.EqualError(t, err, "not a SPS")
+
+	_, err = DecodeSPS([]byte{0x67, 0x42, 0xc0, 0x0a, 0xda})
+	require.EqualError(t, err, "not enough bits")
+}
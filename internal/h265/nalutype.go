@@ -0,0 +1,79 @@
+// Package h265 contains utilities to work with the H265 codec.
+package h265
+
+import (
+	"fmt"
+)
+
+// NALUType is the type of a NALU.
+type NALUType uint8
+
+// standard NALU types.
+const (
+	NALUTypeTrailN              NALUType = 0
+	NALUTypeTrailR              NALUType = 1
+	NALUTypeBLAWLP              NALUType = 16
+	NALUTypeBLAWRADL            NALUType = 17
+	NALUTypeBLANLP              NALUType = 18
+	NALUTypeIDRWRADL            NALUType = 19
+	NALUTypeIDRNLP              NALUType = 20
+	NALUTypeCRA                 NALUType = 21
+	NALUTypeVPS                 NALUType = 32
+	NALUTypeSPS                 NALUType = 33
+	NALUTypePPS                 NALUType = 34
+	NALUTypeAccessUnitDelimiter NALUType = 35
+	NALUTypePrefixSEI           NALUType = 39
+	NALUTypeSuffixSEI           NALUType = 40
+	NALUTypeAggregationUnit     NALUType = 48
+	NALUTypeFragmentationUnit   NALUType = 49
+)
+
+// NALUTypeOf returns the type of a NALU.
+func NALUTypeOf(nalu []byte) NALUType {
+	return NALUType((nalu[0] >> 1) & 0x3F)
+}
+
+// IsRandomAccess tells whether a NALU type is an intra random access point,
+// from which decoding can start.
+func (nt NALUType) IsRandomAccess() bool {
+	return nt >= NALUTypeBLAWLP && nt <= 23
+}
+
+// String implements fmt.Stringer.
+func (nt NALUType) String() string {
+	switch nt {
+	case NALUTypeTrailN:
+		return "TrailN"
+	case NALUTypeTrailR:
+		return "TrailR"
+	case NALUTypeBLAWLP:
+		return "BLAWLP"
+	case NALUTypeBLAWRADL:
+		return "BLAWRADL"
+	case NALUTypeBLANLP:
+		return "BLANLP"
+	case NALUTypeIDRWRADL:
+		return "IDRWRADL"
+	case NALUTypeIDRNLP:
+		return "IDRNLP"
+	case NALUTypeCRA:
+		return "CRA"
+	case NALUTypeVPS:
+		return "VPS"
+	case NALUTypeSPS:
+		return "SPS"
+	case NALUTypePPS:
+		return "PPS"
+	case NALUTypeAccessUnitDelimiter:
+		return "AccessUnitDelimiter"
+	case NALUTypePrefixSEI:
+		return "PrefixSEI"
+	case NALUTypeSuffixSEI:
+		return "SuffixSEI"
+	case NALUTypeAggregationUnit:
+		return "AggregationUnit"
+	case NALUTypeFragmentationUnit:
+		return "FragmentationUnit"
+	}
+	return fmt.Sprintf("unknown (%d)", nt)
+}
@@ -0,0 +1,144 @@
+package h265
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const rtpClockRate = 90000
+
+// ErrMorePacketsNeeded is returned when more packets are needed.
+var ErrMorePacketsNeeded = errors.New("need more packets")
+
+// ErrNonStartingPacketAndNoPrevious is returned when we decoded a non-starting
+// packet of a fragmented NALU and we didn't received anything before.
+// It's normal to receive this when we are decoding a stream that has been already
+// running for some time.
+var ErrNonStartingPacketAndNoPrevious = errors.New("decoded a non-starting fragmented packet without any previous starting packet")
+
+// Decoder is a RTP/H265 decoder, that implements RFC7798.
+// Decoding order numbers (DONL) are not supported.
+type Decoder struct {
+	initialTs    uint32
+	initialTsSet bool
+
+	startingPacketReceived bool
+	isDecodingFragmented   bool
+	fragmentedBuf          []byte
+}
+
+// NewDecoder allocates a Decoder.
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+func (d *Decoder) decodeTimestamp(ts uint32) time.Duration {
+	return time.Duration(ts-d.initialTs) * time.Second / rtpClockRate
+}
+
+// DecodeRTP decodes NALUs from a rtp.Packet.
+// It returns the decoded NALUs and their PTS.
+func (d *Decoder) DecodeRTP(pkt *rtp.Packet) ([][]byte, time.Duration, error) {
+	if len(pkt.Payload) < 2 {
+		d.isDecodingFragmented = false
+		return nil, 0, fmt.Errorf("payload is too short")
+	}
+
+	typ := NALUTypeOf(pkt.Payload)
+
+	if !d.isDecodingFragmented {
+		if !d.initialTsSet {
+			d.initialTsSet = true
+			d.initialTs = pkt.Timestamp
+		}
+
+		switch typ {
+		case NALUTypeAggregationUnit:
+			var nalus [][]byte
+			payload := pkt.Payload[2:]
+
+			for len(payload) > 0 {
+				if len(payload) < 2 {
+					return nil, 0, fmt.Errorf("invalid aggregation unit (invalid size)")
+				}
+
+				size := binary.BigEndian.Uint16(payload)
+				payload = payload[2:]
+
+				// avoid final padding
+				if size == 0 {
+					break
+				}
+
+				if int(size) > len(payload) {
+					return nil, 0, fmt.Errorf("invalid aggregation unit (invalid size)")
+				}
+
+				nalus = append(nalus, payload[:size])
+				payload = payload[size:]
+			}
+
+			if len(nalus) == 0 {
+				return nil, 0, fmt.Errorf("aggregation unit doesn't contain any NALU")
+			}
+
+			d.startingPacketReceived = true
+			return nalus, d.decodeTimestamp(pkt.Timestamp), nil
+
+		case NALUTypeFragmentationUnit:
+			if len(pkt.Payload) < 3 {
+				return nil, 0, fmt.Errorf("invalid fragmentation unit (invalid size)")
+			}
+
+			start := pkt.Payload[2] >> 7
+			if start != 1 {
+				if !d.startingPacketReceived {
+					return nil, 0, ErrNonStartingPacketAndNoPrevious
+				}
+				return nil, 0, fmt.Errorf("invalid fragmentation unit (non-starting)")
+			}
+
+			// rebuild the NALU header from the payload header and the FU header
+			head := (pkt.Payload[0] & 0x81) | ((pkt.Payload[2] & 0x3F) << 1)
+			d.fragmentedBuf = append([]byte{head, pkt.Payload[1]}, pkt.Payload[3:]...)
+
+			d.isDecodingFragmented = true
+			d.startingPacketReceived = true
+			return nil, 0, ErrMorePacketsNeeded
+
+		case 50: // PACI
+			return nil, 0, fmt.Errorf("packet type not supported (%v)", typ)
+		}
+
+		d.startingPacketReceived = true
+		return [][]byte{pkt.Payload}, d.decodeTimestamp(pkt.Timestamp), nil
+	}
+
+	// we are decoding a fragmented NALU
+
+	if typ != NALUTypeFragmentationUnit || len(pkt.Payload) < 3 {
+		d.isDecodingFragmented = false
+		return nil, 0, fmt.Errorf("expected fragmentation unit, got another type")
+	}
+
+	start := pkt.Payload[2] >> 7
+	end := (pkt.Payload[2] >> 6) & 0x01
+
+	if start == 1 {
+		d.isDecodingFragmented = false
+		return nil, 0, fmt.Errorf("invalid fragmentation unit (decoded two starting packets in a row)")
+	}
+
+	d.fragmentedBuf = append(d.fragmentedBuf, pkt.Payload[3:]...)
+
+	if end != 1 {
+		return nil, 0, ErrMorePacketsNeeded
+	}
+
+	d.isDecodingFragmented = false
+	return [][]byte{d.fragmentedBuf}, d.decodeTimestamp(pkt.Timestamp), nil
+}
@@ -0,0 +1,60 @@
+package h265
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecoder(t *testing.T) {
+	d := NewDecoder()
+
+	// single NALU
+	nalus, pts, err := d.DecodeRTP(&rtp.Packet{
+		Header:  rtp.Header{Timestamp: 1000},
+		Payload: []byte{0x02, 0x01, 0xaa, 0xbb},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0x02, 0x01, 0xaa, 0xbb}}, nalus)
+	require.Equal(t, time.Duration(0), pts)
+
+	// aggregation unit
+	nalus, pts, err = d.DecodeRTP(&rtp.Packet{
+		Header: rtp.Header{Timestamp: 1000 + 90000},
+		Payload: []byte{
+			0x60, 0x01,
+			0x00, 0x03, 0x40, 0x01, 0x0c,
+			0x00, 0x03, 0x42, 0x01, 0x01,
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0x40, 0x01, 0x0c}, {0x42, 0x01, 0x01}}, nalus)
+	require.Equal(t, 1*time.Second, pts)
+
+	// fragmentation unit
+	_, _, err = d.DecodeRTP(&rtp.Packet{
+		Header:  rtp.Header{Timestamp: 1000 + 2*90000},
+		Payload: []byte{0x62, 0x01, 0x80 | 19, 0x01, 0x02},
+	})
+	require.Equal(t, ErrMorePacketsNeeded, err)
+
+	nalus, pts, err = d.DecodeRTP(&rtp.Packet{
+		Header:  rtp.Header{Timestamp: 1000 + 2*90000},
+		Payload: []byte{0x62, 0x01, 0x40 | 19, 0x03, 0x04},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0x26, 0x01, 0x01, 0x02, 0x03, 0x04}}, nalus)
+	require.Equal(t, NALUTypeIDRWRADL, NALUTypeOf(nalus[0]))
+	require.Equal(t, 2*time.Second, pts)
+}
+
+func TestDecoderNonStarting(t *testing.T) {
+	d := NewDecoder()
+
+	_, _, err := d.DecodeRTP(&rtp.Packet{
+		Payload: []byte{0x62, 0x01, 0x40 | 19, 0x03, 0x04},
+	})
+	require.Equal(t, ErrNonStartingPacketAndNoPrevious, err)
+}
@@ -0,0 +1,153 @@
+package h265
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	rtpVersion        = 0x02
+	rtpPayloadMaxSize = 1460 // 1500 (mtu) - 20 (ip header) - 8 (udp header) - 12 (rtp header)
+)
+
+// Encoder is a RTP/H265 encoder, that implements RFC7798.
+// NALUs are sent in single NALU packets or, when they don't fit into a
+// single packet, in fragmentation units.
+type Encoder struct {
+	payloadType    uint8
+	sequenceNumber uint16
+	ssrc           uint32
+	initialTs      uint32
+}
+
+// NewEncoder allocates an Encoder.
+func NewEncoder(payloadType uint8,
+	sequenceNumber *uint16,
+	ssrc *uint32,
+	initialTs *uint32) *Encoder {
+	return &Encoder{
+		payloadType: payloadType,
+		sequenceNumber: func() uint16 {
+			if sequenceNumber != nil {
+				return *sequenceNumber
+			}
+			return uint16(rand.Uint32())
+		}(),
+		ssrc: func() uint32 {
+			if ssrc != nil {
+				return *ssrc
+			}
+			return rand.Uint32()
+		}(),
+		initialTs: func() uint32 {
+			if initialTs != nil {
+				return *initialTs
+			}
+			return rand.Uint32()
+		}(),
+	}
+}
+
+func (e *Encoder) encodeTimestamp(ts time.Duration) uint32 {
+	return e.initialTs + uint32(ts.Seconds()*rtpClockRate)
+}
+
+// Encode encodes NALUs into RTP/H265 packets.
+// It returns the encoded packets.
+func (e *Encoder) Encode(nalus [][]byte, pts time.Duration) ([][]byte, error) {
+	var rets [][]byte
+
+	for i, nalu := range nalus {
+		// marker is used to indicate when all NALUs with same PTS have been sent
+		marker := (i == len(nalus)-1)
+
+		var pkts [][]byte
+		var err error
+		if len(nalu) <= rtpPayloadMaxSize {
+			pkts, err = e.writeSingle(nalu, pts, marker)
+		} else {
+			pkts, err = e.writeFragmented(nalu, pts, marker)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rets = append(rets, pkts...)
+	}
+
+	return rets, nil
+}
+
+func (e *Encoder) writePacket(payload []byte, pts time.Duration, marker bool) ([]byte, error) {
+	frame, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:        rtpVersion,
+			PayloadType:    e.payloadType,
+			SequenceNumber: e.sequenceNumber,
+			Timestamp:      e.encodeTimestamp(pts),
+			SSRC:           e.ssrc,
+			Marker:         marker,
+		},
+		Payload: payload,
+	}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	e.sequenceNumber++
+
+	return frame, nil
+}
+
+func (e *Encoder) writeSingle(nalu []byte, pts time.Duration, marker bool) ([][]byte, error) {
+	frame, err := e.writePacket(nalu, pts, marker)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{frame}, nil
+}
+
+func (e *Encoder) writeFragmented(nalu []byte, pts time.Duration, marker bool) ([][]byte, error) {
+	typ := (nalu[0] >> 1) & 0x3F
+
+	// payload header: the NALU header with the type replaced
+	head0 := (nalu[0] & 0x81) | (uint8(NALUTypeFragmentationUnit) << 1)
+	head1 := nalu[1]
+	nalu = nalu[2:]
+
+	le := rtpPayloadMaxSize - 3
+	var ret [][]byte
+
+	for i := 0; len(nalu) > 0; i++ {
+		start := uint8(0)
+		if i == 0 {
+			start = 1
+		}
+
+		n := le
+		end := uint8(0)
+		if len(nalu) <= le {
+			n = len(nalu)
+			end = 1
+		}
+
+		data := make([]byte, 3+n)
+		data[0] = head0
+		data[1] = head1
+		data[2] = (start << 7) | (end << 6) | typ
+		copy(data[3:], nalu[:n])
+		nalu = nalu[n:]
+
+		frame, err := e.writePacket(data, pts, end == 1 && marker)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, frame)
+	}
+
+	return ret, nil
+}
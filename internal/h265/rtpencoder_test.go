@@ -0,0 +1,48 @@
+package h265
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoder(t *testing.T) {
+	sequenceNumber := uint16(0x44ed)
+	ssrc := uint32(0x9dbb7812)
+	initialTs := uint32(0x88776655)
+	e := NewEncoder(96, &sequenceNumber, &ssrc, &initialTs)
+
+	nalus := [][]byte{
+		{0x40, 0x01, 0x0c},
+		append([]byte{0x26, 0x01}, bytes.Repeat([]byte{0x01, 0x02, 0x03, 0x04}, 1000)...),
+	}
+
+	frames, err := e.Encode(nalus, 2*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(frames))
+
+	d := NewDecoder()
+	var decoded [][]byte
+
+	for i, frame := range frames {
+		var pkt rtp.Packet
+		err := pkt.Unmarshal(frame)
+		require.NoError(t, err)
+		require.Equal(t, sequenceNumber+uint16(i), pkt.SequenceNumber)
+		require.Equal(t, initialTs+2*90000, pkt.Timestamp)
+		require.Equal(t, i == len(frames)-1, pkt.Marker)
+		require.LessOrEqual(t, len(pkt.Payload), rtpPayloadMaxSize)
+
+		dnalus, _, err := d.DecodeRTP(&pkt)
+		if err == ErrMorePacketsNeeded {
+			continue
+		}
+		require.NoError(t, err)
+		decoded = append(decoded, dnalus...)
+	}
+
+	require.Equal(t, nalus, decoded)
+}
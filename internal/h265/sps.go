@@ -0,0 +1,316 @@
+package h265
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+type bitReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *bitReader) readBit() (uint32, error) {
+	if r.pos >= len(r.buf)*8 {
+		return 0, fmt.Errorf("not enough bits")
+	}
+	v := uint32(r.buf[r.pos/8]>>(7-(r.pos%8))) & 0x01
+	r.pos++
+	return v, nil
+}
+
+func (r *bitReader) readBits(n int) (uint32, error) {
+	var v uint32
+	for i := 0; i < n; i++ {
+		b, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = (v << 1) | b
+	}
+	return v, nil
+}
+
+func (r *bitReader) readFlag() (bool, error) {
+	b, err := r.readBit()
+	return b == 1, err
+}
+
+func (r *bitReader) skipBits(n int) error {
+	if r.pos+n > len(r.buf)*8 {
+		return fmt.Errorf("not enough bits")
+	}
+	r.pos += n
+	return nil
+}
+
+// readUE reads an unsigned Exp-Golomb code.
+func (r *bitReader) readUE() (uint32, error) {
+	leadingZeros := 0
+	for {
+		b, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		leadingZeros++
+		if leadingZeros > 31 {
+			return 0, fmt.Errorf("invalid Exp-Golomb code")
+		}
+	}
+
+	v, err := r.readBits(leadingZeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1 << leadingZeros) - 1 + v, nil
+}
+
+// SPS contains the parameters of a sequence parameter set
+// that are needed to describe a stream.
+type SPS struct {
+	MaxSubLayersMinus1        uint8
+	TemporalIDNestingFlag     bool
+	ProfileSpace              uint8
+	TierFlag                  uint8
+	ProfileIdc                uint8
+	ProfileCompatibilityFlags uint32
+	ConstraintIndicatorFlags  [6]byte
+	LevelIdc                  uint8
+	ChromaFormatIdc           uint8
+	Width                     int
+	Height                    int
+	BitDepthLumaMinus8        uint8
+	BitDepthChromaMinus8      uint8
+}
+
+// DecodeSPS decodes a sequence parameter set.
+func DecodeSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 3 {
+		return nil, fmt.Errorf("SPS is too short")
+	}
+
+	if NALUTypeOf(nalu) != NALUTypeSPS {
+		return nil, fmt.Errorf("not a SPS")
+	}
+
+	r := &bitReader{buf: h264.AntiCompetitionRemove(nalu[2:])}
+	s := &SPS{}
+
+	// sps_video_parameter_set_id
+	err := r.skipBits(4)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := r.readBits(3)
+	if err != nil {
+		return nil, err
+	}
+	s.MaxSubLayersMinus1 = uint8(v)
+
+	s.TemporalIDNestingFlag, err = r.readFlag()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.readProfileTierLevel(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// sps_seq_parameter_set_id
+	_, err = r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err = r.readUE()
+	if err != nil {
+		return nil, err
+	}
+	s.ChromaFormatIdc = uint8(v)
+
+	separateColourPlane := false
+	if s.ChromaFormatIdc == 3 {
+		separateColourPlane, err = r.readFlag()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	width, err := r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	height, err := r.readUE()
+	if err != nil {
+		return nil, err
+	}
+
+	conformanceWindow, err := r.readFlag()
+	if err != nil {
+		return nil, err
+	}
+
+	var crop [4]uint32 // left, right, top, bottom
+	if conformanceWindow {
+		for i := range crop {
+			crop[i], err = r.readUE()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	subWidthC := 1
+	subHeightC := 1
+	if !separateColourPlane {
+		switch s.ChromaFormatIdc {
+		case 1:
+			subWidthC = 2
+			subHeightC = 2
+		case 2:
+			subWidthC = 2
+		}
+	}
+
+	s.Width = int(width) - int(crop[0]+crop[1])*subWidthC
+	s.Height = int(height) - int(crop[2]+crop[3])*subHeightC
+
+	v, err = r.readUE()
+	if err != nil {
+		return nil, err
+	}
+	s.BitDepthLumaMinus8 = uint8(v)
+
+	v, err = r.readUE()
+	if err != nil {
+		return nil, err
+	}
+	s.BitDepthChromaMinus8 = uint8(v)
+
+	return s, nil
+}
+
+func (s *SPS) readProfileTierLevel(r *bitReader) error {
+	v, err := r.readBits(2)
+	if err != nil {
+		return err
+	}
+	s.ProfileSpace = uint8(v)
+
+	v, err = r.readBits(1)
+	if err != nil {
+		return err
+	}
+	s.TierFlag = uint8(v)
+
+	v, err = r.readBits(5)
+	if err != nil {
+		return err
+	}
+	s.ProfileIdc = uint8(v)
+
+	s.ProfileCompatibilityFlags, err = r.readBits(32)
+	if err != nil {
+		return err
+	}
+
+	for i := range s.ConstraintIndicatorFlags {
+		v, err = r.readBits(8)
+		if err != nil {
+			return err
+		}
+		s.ConstraintIndicatorFlags[i] = uint8(v)
+	}
+
+	v, err = r.readBits(8)
+	if err != nil {
+		return err
+	}
+	s.LevelIdc = uint8(v)
+
+	subLayerProfilePresent := make([]bool, s.MaxSubLayersMinus1)
+	subLayerLevelPresent := make([]bool, s.MaxSubLayersMinus1)
+
+	for i := 0; i < int(s.MaxSubLayersMinus1); i++ {
+		subLayerProfilePresent[i], err = r.readFlag()
+		if err != nil {
+			return err
+		}
+
+		subLayerLevelPresent[i], err = r.readFlag()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.MaxSubLayersMinus1 > 0 {
+		// reserved_zero_2bits
+		err = r.skipBits(2 * (8 - int(s.MaxSubLayersMinus1)))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < int(s.MaxSubLayersMinus1); i++ {
+		if subLayerProfilePresent[i] {
+			err = r.skipBits(88)
+			if err != nil {
+				return err
+			}
+		}
+
+		if subLayerLevelPresent[i] {
+			err = r.skipBits(8)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Codec returns the codec string of the stream, in the format
+// described by ISO/IEC 14496-15, Annex E.
+func (s *SPS) Codec() string {
+	ret := "hvc1."
+
+	if s.ProfileSpace > 0 {
+		ret += string(rune('A' + s.ProfileSpace - 1))
+	}
+	ret += strconv.FormatUint(uint64(s.ProfileIdc), 10)
+
+	// compatibility flags are written in reverse bit order
+	var compat uint32
+	for i := 0; i < 32; i++ {
+		compat |= ((s.ProfileCompatibilityFlags >> i) & 0x01) << (31 - i)
+	}
+	ret += "." + strings.ToUpper(strconv.FormatUint(uint64(compat), 16))
+
+	if s.TierFlag == 1 {
+		ret += ".H"
+	} else {
+		ret += ".L"
+	}
+	ret += strconv.FormatUint(uint64(s.LevelIdc), 10)
+
+	// trailing zero bytes are omitted
+	n := len(s.ConstraintIndicatorFlags)
+	for n > 0 && s.ConstraintIndicatorFlags[n-1] == 0 {
+		n--
+	}
+	for _, b := range s.ConstraintIndicatorFlags[:n] {
+		ret += "." + strings.ToUpper(strconv.FormatUint(uint64(b), 16))
+	}
+
+	return ret
+}
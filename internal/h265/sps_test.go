@@ -0,0 +1,40 @@
+package h265
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testSPS = []byte{
+	0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+	0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+	0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+	0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+	0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+	0xe0, 0x80,
+}
+
+func TestDecodeSPS(t *testing.T) {
+	sps, err := DecodeSPS(testSPS)
+	require.NoError(t, err)
+	require.Equal(t, SPS{
+		TemporalIDNestingFlag:     true,
+		ProfileIdc:                1,
+		ProfileCompatibilityFlags: 0x60000000,
+		ConstraintIndicatorFlags:  [6]byte{0x90},
+		LevelIdc:                  120,
+		ChromaFormatIdc:           1,
+		Width:                     1920,
+		Height:                    1080,
+	}, *sps)
+	require.Equal(t, "hvc1.1.6.L120.90", sps.Codec())
+}
+
+func TestDecodeSPSErrors(t *testing.T) {
+	_, err := DecodeSPS([]byte{0x44, 0x01, 0xc1, 0x72})
+	require.EqualError(t, err, "not a SPS")
+
+	_, err = DecodeSPS([]byte{0x42, 0x01, 0x01, 0x01, 0x60})
+	require.EqualError(t, err, "not enough bits")
+}
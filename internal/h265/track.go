@@ -0,0 +1,112 @@
+package h265
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aler9/gortsplib"
+	psdp "github.com/pion/sdp/v3"
+)
+
+// IsTrack checks whether a track is a H265 track.
+func IsTrack(t *gortsplib.Track) bool {
+	if t.Media.MediaName.Media != "video" {
+		return false
+	}
+
+	v, ok := t.Media.Attribute("rtpmap")
+	if !ok {
+		return false
+	}
+
+	vals := strings.Split(v, " ")
+	if len(vals) != 2 {
+		return false
+	}
+
+	return strings.ToUpper(vals[1]) == "H265/90000"
+}
+
+// ExtractParams extracts the VPS, SPS and PPS from the SDP of a H265 track.
+func ExtractParams(t *gortsplib.Track) ([]byte, []byte, []byte, error) {
+	v, ok := t.Media.Attribute("fmtp")
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("fmtp attribute is missing")
+	}
+
+	tmp := strings.SplitN(v, " ", 2)
+	if len(tmp) != 2 {
+		return nil, nil, nil, fmt.Errorf("invalid fmtp attribute (%v)", v)
+	}
+
+	var vps []byte
+	var sps []byte
+	var pps []byte
+
+	for _, kv := range strings.Split(tmp[1], ";") {
+		kv = strings.Trim(kv, " ")
+
+		if len(kv) == 0 {
+			continue
+		}
+
+		tmp := strings.SplitN(kv, "=", 2)
+		if len(tmp) != 2 {
+			return nil, nil, nil, fmt.Errorf("invalid fmtp attribute (%v)", v)
+		}
+
+		var dest *[]byte
+		switch tmp[0] {
+		case "sprop-vps":
+			dest = &vps
+		case "sprop-sps":
+			dest = &sps
+		case "sprop-pps":
+			dest = &pps
+		default:
+			continue
+		}
+
+		// only the first parameter set of each kind is used
+		byts, err := base64.StdEncoding.DecodeString(strings.SplitN(tmp[1], ",", 2)[0])
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid %s (%v)", tmp[0], v)
+		}
+		*dest = byts
+	}
+
+	if vps == nil || sps == nil || pps == nil {
+		return nil, nil, nil, fmt.Errorf("sprop-vps, sprop-sps or sprop-pps is missing (%v)", v)
+	}
+
+	return vps, sps, pps, nil
+}
+
+// NewTrack initializes a H265 track from a VPS, SPS and PPS.
+func NewTrack(payloadType uint8, vps []byte, sps []byte, pps []byte) *gortsplib.Track {
+	typ := strconv.FormatInt(int64(payloadType), 10)
+
+	return &gortsplib.Track{
+		Media: &psdp.MediaDescription{
+			MediaName: psdp.MediaName{
+				Media:   "video",
+				Protos:  []string{"RTP", "AVP"},
+				Formats: []string{typ},
+			},
+			Attributes: []psdp.Attribute{
+				{
+					Key:   "rtpmap",
+					Value: typ + " H265/90000",
+				},
+				{
+					Key: "fmtp",
+					Value: typ + " sprop-vps=" + base64.StdEncoding.EncodeToString(vps) + "; " +
+						"sprop-sps=" + base64.StdEncoding.EncodeToString(sps) + "; " +
+						"sprop-pps=" + base64.StdEncoding.EncodeToString(pps),
+				},
+			},
+		},
+	}
+}
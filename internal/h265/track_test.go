@@ -0,0 +1,22 @@
+package h265
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrack(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff}
+	pps := []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+
+	track := NewTrack(96, vps, testSPS, pps)
+	require.True(t, IsTrack(track))
+	require.False(t, track.IsH264())
+
+	vps2, sps2, pps2, err := ExtractParams(track)
+	require.NoError(t, err)
+	require.Equal(t, vps, vps2)
+	require.Equal(t, testSPS, sps2)
+	require.Equal(t, pps, pps2)
+}
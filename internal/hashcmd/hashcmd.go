@@ -0,0 +1,60 @@
+// Package hashcmd contains a command that hashes credentials.
+package hashcmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/aler9/rtsp-simple-server/internal/credential"
+)
+
+// Run runs the command with the given arguments and returns an exit code.
+func Run(args []string) int {
+	return run(args, os.Stdin, os.Stdout, os.Stderr)
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	k := kingpin.New("rtsp-simple-server hash",
+		"Hash a credential, in order to store it in the configuration file.\n"+
+			"If the credential is not provided, it is read from the standard input.")
+	k.Writer(stderr)
+
+	argAlgorithm := k.Flag("algorithm", "hash algorithm (bcrypt, argon2 or sha256).").
+		Default("bcrypt").Enum("bcrypt", "argon2", "sha256")
+	argValue := k.Arg("credential", "credential to hash.").String()
+
+	_, err := k.Parse(args)
+	if err != nil {
+		fmt.Fprintf(stderr, "ERR: %s\n", err)
+		return 1
+	}
+
+	v := *argValue
+	if v == "" {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintf(stderr, "ERR: %s\n", err)
+			return 1
+		}
+		v = strings.TrimRight(line, "\r\n")
+	}
+
+	if v == "" {
+		fmt.Fprintf(stderr, "ERR: credential is empty\n")
+		return 1
+	}
+
+	h, err := credential.Hash(*argAlgorithm, v)
+	if err != nil {
+		fmt.Fprintf(stderr, "ERR: %s\n", err)
+		return 1
+	}
+
+	fmt.Fprintln(stdout, h)
+	return 0
+}
@@ -0,0 +1,47 @@
+package hashcmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/credential"
+)
+
+func TestHashCmd(t *testing.T) {
+	for _, ca := range []struct {
+		name  string
+		args  []string
+		stdin string
+	}{
+		{
+			"argument",
+			[]string{"testpass"},
+			"",
+		},
+		{
+			"stdin",
+			[]string{"--algorithm", "argon2"},
+			"testpass\n",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			code := run(ca.args, strings.NewReader(ca.stdin), &stdout, ioutil.Discard)
+			require.Equal(t, 0, code)
+
+			h := strings.TrimSuffix(stdout.String(), "\n")
+			require.Equal(t, true, credential.Check(h, "testpass"))
+		})
+	}
+}
+
+func TestHashCmdError(t *testing.T) {
+	var stderr bytes.Buffer
+	code := run([]string{"--algorithm", "md5", "testpass"}, strings.NewReader(""), ioutil.Discard, &stderr)
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr.String(), "ERR:")
+}
@@ -0,0 +1,441 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/asticode/go-astits"
+
+	"github.com/aler9/rtsp-simple-server/internal/aac"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+const (
+	clientMaxPlaylistSize = 1 * 1024 * 1024
+	clientMaxSegmentSize  = 50 * 1024 * 1024
+)
+
+// ErrClientTerminated is returned by Run when the context is canceled.
+var ErrClientTerminated = errors.New("terminated")
+
+// Client is a HLS client, that reads a stream from a remote server
+// and demuxes its MPEG-TS segments into H264 and AAC frames.
+type Client struct {
+	ur          *url.URL
+	httpClient  *http.Client
+	onTracks    func(*gortsplib.Track, *gortsplib.Track) error
+	onVideoData func(time.Duration, [][]byte)
+	onAudioData func(time.Duration, [][]byte)
+
+	lastSeq      int
+	videoPID     uint16
+	audioPID     uint16
+	sps          []byte
+	pps          []byte
+	aacConfig    []byte
+	tracksSet    bool
+	startPTS     int64
+	startPTSSet  bool
+	startTime    time.Time
+	startTimePTS time.Duration
+}
+
+// NewClient allocates a Client.
+// The URL can point to a media playlist or to a master playlist, in which
+// case the first variant is read.
+// onTracks is called once, when the tracks of the stream are known; then
+// frames are passed to onVideoData and onAudioData, paced in real time.
+func NewClient(
+	ur string,
+	httpClient *http.Client,
+	onTracks func(*gortsplib.Track, *gortsplib.Track) error,
+	onVideoData func(time.Duration, [][]byte),
+	onAudioData func(time.Duration, [][]byte),
+) (*Client, error) {
+	u, err := url.Parse(ur)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		ur:          u,
+		httpClient:  httpClient,
+		onTracks:    onTracks,
+		onVideoData: onVideoData,
+		onAudioData: onAudioData,
+		lastSeq:     -1,
+	}, nil
+}
+
+// Run reads the stream until an error occurs or the context is canceled.
+func (c *Client) Run(ctx context.Context) error {
+	playlistURL := c.ur
+	variantFollowed := false
+
+	for {
+		byts, err := c.download(ctx, playlistURL, clientMaxPlaylistSize)
+		if err != nil {
+			return err
+		}
+
+		pl, err := parseClientPlaylist(byts)
+		if err != nil {
+			return err
+		}
+
+		if pl.variants != nil {
+			if variantFollowed {
+				return fmt.Errorf("the variant playlist is a master playlist")
+			}
+			variantFollowed = true
+
+			playlistURL, err = playlistURL.Parse(pl.variants[0])
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		segments := pl.segments
+
+		if c.lastSeq < 0 {
+			// start from the last segment of live streams, in order to minimize latency
+			if !pl.endList && len(segments) > 1 {
+				segments = segments[len(segments)-1:]
+			}
+		} else {
+			if len(segments) != 0 && segments[len(segments)-1].seq < c.lastSeq {
+				return fmt.Errorf("the media sequence of the playlist went backwards")
+			}
+
+			for len(segments) != 0 && segments[0].seq <= c.lastSeq {
+				segments = segments[1:]
+			}
+		}
+
+		for _, seg := range segments {
+			segURL, err := playlistURL.Parse(seg.uri)
+			if err != nil {
+				return err
+			}
+
+			byts, err := c.download(ctx, segURL, clientMaxSegmentSize)
+			if err != nil {
+				return err
+			}
+
+			err = c.processSegment(ctx, byts)
+			if err != nil {
+				return err
+			}
+
+			c.lastSeq = seg.seq
+		}
+
+		if pl.endList {
+			return fmt.Errorf("the stream has ended")
+		}
+
+		// when there are no new segments, wait before reloading the playlist
+		if len(segments) == 0 {
+			select {
+			case <-time.After(pl.targetDuration / 2):
+			case <-ctx.Done():
+				return ErrClientTerminated
+			}
+		}
+	}
+}
+
+func (c *Client) download(ctx context.Context, ur *url.URL, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, ur.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrClientTerminated
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code while downloading %s: %d", ur.Path, res.StatusCode)
+	}
+
+	byts, err := ioutil.ReadAll(&limitedReader{r: res.Body, n: maxSize})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrClientTerminated
+		}
+		return nil, err
+	}
+
+	return byts, nil
+}
+
+func (c *Client) processSegment(ctx context.Context, byts []byte) error {
+	if !c.tracksSet {
+		err := c.findTracks(ctx, byts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.demux(ctx, byts, func(pid uint16, pes *astits.PESData) error {
+		switch pid {
+		case c.videoPID:
+			return c.processVideo(ctx, pes)
+
+		case c.audioPID:
+			return c.processAudio(ctx, pes)
+		}
+		return nil
+	})
+}
+
+// findTracks reads the first segment in order to find the tracks
+// and their parameters.
+func (c *Client) findTracks(ctx context.Context, byts []byte) error {
+	err := c.demux(ctx, byts, func(pid uint16, pes *astits.PESData) error {
+		switch pid {
+		case c.videoPID:
+			if c.sps != nil && c.pps != nil {
+				return nil
+			}
+
+			nalus, err := h264.DecodeAnnexB(pes.Data)
+			if err != nil {
+				return err
+			}
+
+			for _, nalu := range nalus {
+				if len(nalu) == 0 {
+					continue
+				}
+
+				switch h264.NALUType(nalu[0] & 0x1F) {
+				case h264.NALUTypeSPS:
+					c.sps = append([]byte(nil), nalu...)
+
+				case h264.NALUTypePPS:
+					c.pps = append([]byte(nil), nalu...)
+				}
+			}
+
+		case c.audioPID:
+			if c.aacConfig != nil {
+				return nil
+			}
+
+			pkts, err := aac.DecodeADTS(pes.Data)
+			if err != nil {
+				return err
+			}
+
+			if len(pkts) != 0 {
+				c.aacConfig, err = aac.EncodeMPEG4AudioConfig(pkts[0].SampleRate, pkts[0].ChannelCount)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// tracks whose parameters are not in the first segment are discarded
+	var videoTrack *gortsplib.Track
+	if c.sps != nil && c.pps != nil {
+		videoTrack, err = gortsplib.NewTrackH264(96, c.sps, c.pps)
+		if err != nil {
+			return err
+		}
+	} else {
+		c.videoPID = 0
+	}
+
+	var audioTrack *gortsplib.Track
+	if c.aacConfig != nil {
+		audioTrack, err = gortsplib.NewTrackAAC(97, c.aacConfig)
+		if err != nil {
+			return err
+		}
+	} else {
+		c.audioPID = 0
+	}
+
+	if videoTrack == nil && audioTrack == nil {
+		return fmt.Errorf("the stream doesn't contain any H264 or AAC track")
+	}
+
+	c.tracksSet = true
+	return c.onTracks(videoTrack, audioTrack)
+}
+
+// demux calls onPES for every PES of the H264 and AAC tracks of a segment.
+func (c *Client) demux(ctx context.Context, byts []byte, onPES func(uint16, *astits.PESData) error) error {
+	dem := astits.NewDemuxer(ctx, bytes.NewReader(byts))
+
+	for {
+		data, err := dem.NextData()
+		if err != nil {
+			if err == astits.ErrNoMorePackets {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ErrClientTerminated
+			}
+			return err
+		}
+
+		if data.PMT != nil {
+			if !c.tracksSet && c.videoPID == 0 && c.audioPID == 0 {
+				for _, es := range data.PMT.ElementaryStreams {
+					switch es.StreamType {
+					case astits.StreamTypeH264Video:
+						if c.videoPID == 0 {
+							c.videoPID = es.ElementaryPID
+						}
+
+					case astits.StreamTypeAACAudio:
+						if c.audioPID == 0 {
+							c.audioPID = es.ElementaryPID
+						}
+					}
+				}
+			}
+			continue
+		}
+
+		if data.PES == nil || data.PES.Header.OptionalHeader == nil ||
+			data.PES.Header.OptionalHeader.PTS == nil ||
+			(data.PID != c.videoPID && data.PID != c.audioPID) {
+			continue
+		}
+
+		err = onPES(data.PID, data.PES)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) processVideo(ctx context.Context, pes *astits.PESData) error {
+	nalus, err := h264.DecodeAnnexB(pes.Data)
+	if err != nil {
+		return err
+	}
+
+	var outNALUs [][]byte
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		// remove SPS, PPS and AUD, not needed by RTSP / RTMP
+		switch h264.NALUType(nalu[0] & 0x1F) {
+		case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+			continue
+		}
+
+		outNALUs = append(outNALUs, nalu)
+	}
+
+	if len(outNALUs) == 0 {
+		return nil
+	}
+
+	pts, err := c.waitPTS(ctx, pes.Header.OptionalHeader.PTS.Base)
+	if err != nil {
+		return err
+	}
+
+	c.onVideoData(pts, outNALUs)
+	return nil
+}
+
+func (c *Client) processAudio(ctx context.Context, pes *astits.PESData) error {
+	pkts, err := aac.DecodeADTS(pes.Data)
+	if err != nil {
+		return err
+	}
+
+	if len(pkts) == 0 {
+		return nil
+	}
+
+	pts, err := c.waitPTS(ctx, pes.Header.OptionalHeader.PTS.Base)
+	if err != nil {
+		return err
+	}
+
+	aus := make([][]byte, len(pkts))
+	for i, pkt := range pkts {
+		aus[i] = pkt.Frame
+	}
+
+	c.onAudioData(pts, aus)
+	return nil
+}
+
+// waitPTS converts a MPEG-TS timestamp into a relative one and waits
+// until it's time to emit the related frame.
+func (c *Client) waitPTS(ctx context.Context, v int64) (time.Duration, error) {
+	if !c.startPTSSet {
+		c.startPTSSet = true
+		c.startPTS = v
+	}
+
+	// timestamps are 33 bits long and wrap around
+	diff := (v - c.startPTS) & 0x1FFFFFFFF
+	if diff >= 0x100000000 {
+		diff -= 0x200000000
+	}
+
+	pts := ptsOffset + time.Duration(diff)*time.Second/90000
+
+	if c.startTime.IsZero() {
+		c.startTime = time.Now()
+		c.startTimePTS = pts
+	}
+
+	wait := time.Until(c.startTime.Add(pts - c.startTimePTS))
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return 0, ErrClientTerminated
+		}
+	}
+
+	return pts, nil
+}
+
+// limitedReader is a reader that returns an error when more than n bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, fmt.Errorf("response is too big")
+	}
+	return n, err
+}
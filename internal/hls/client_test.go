@@ -0,0 +1,100 @@
+package hls
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	sps := []byte{0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0, 0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00, 0x00, 0x03, 0x00, 0x3d, 0x08}
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+
+	videoTrack, err := gortsplib.NewTrackH264(96, sps, pps)
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{0x12, 0x10})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "$TIME.ts", nil, "", "", videoTrack, audioTrack)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := 0; i < 4; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{sps, pps, {0x65, byte(i)}})
+		require.NoError(t, err)
+
+		err = m.WriteAAC(time.Duration(i)*time.Second, [][]byte{{0x01, byte(i)}})
+		require.NoError(t, err)
+	}
+
+	err = m.End()
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rd io.Reader
+		if strings.HasSuffix(r.URL.Path, ".m3u8") {
+			rd = m.Playlist("", "")
+		} else {
+			rd = m.File(strings.TrimPrefix(r.URL.Path, "/"))
+		}
+
+		if rd == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		io.Copy(w, rd)
+	}))
+	defer srv.Close()
+
+	tracksRecv := make(chan gortsplib.Tracks, 1)
+	videoRecv := make(chan [][]byte, 10)
+	audioRecv := make(chan [][]byte, 10)
+
+	c, err := NewClient(
+		srv.URL+"/stream.m3u8",
+		http.DefaultClient,
+		func(videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) error {
+			tracksRecv <- gortsplib.Tracks{videoTrack, audioTrack}
+			return nil
+		},
+		func(pts time.Duration, nalus [][]byte) {
+			videoRecv <- nalus
+		},
+		func(pts time.Duration, aus [][]byte) {
+			audioRecv <- aus
+		})
+	require.NoError(t, err)
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	tracks := <-tracksRecv
+	recvSPS, recvPPS, err := tracks[0].ExtractDataH264()
+	require.NoError(t, err)
+	require.Equal(t, sps, recvSPS)
+	require.Equal(t, pps, recvPPS)
+
+	config, err := tracks[1].ExtractDataAAC()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x12, 0x10}, config)
+
+	// the stream has ended, therefore it is read from the first segment.
+	// Parameters are removed from frames.
+	require.Equal(t, [][]byte{{0x65, 0x00}}, <-videoRecv)
+	require.Equal(t, [][]byte{{0x01, 0x00}}, <-audioRecv)
+
+	ctxCancel()
+	require.Equal(t, ErrClientTerminated, <-done)
+}
@@ -0,0 +1,114 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clientSegment is a segment listed in a media playlist.
+type clientSegment struct {
+	seq      int
+	duration time.Duration
+	uri      string
+}
+
+// clientPlaylist is a playlist read by the client.
+type clientPlaylist struct {
+	// URIs of the variants, in case of a master playlist.
+	variants []string
+
+	targetDuration time.Duration
+	segments       []clientSegment
+	endList        bool
+}
+
+// parseClientPlaylist parses a master or media playlist in M3U8 format.
+func parseClientPlaylist(byts []byte) (*clientPlaylist, error) {
+	sc := bufio.NewScanner(bytes.NewReader(byts))
+
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) != "#EXTM3U" {
+		return nil, fmt.Errorf("invalid playlist: missing #EXTM3U")
+	}
+
+	p := &clientPlaylist{}
+	mediaSequence := 0
+	var curDuration time.Duration
+	isVariant := false
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+
+		switch {
+		case line == "":
+
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			isVariant = true
+
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			v, err := strconv.ParseUint(line[len("#EXT-X-TARGETDURATION:"):], 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target duration: %s", line)
+			}
+			p.targetDuration = time.Duration(v) * time.Second
+
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			v, err := strconv.ParseUint(line[len("#EXT-X-MEDIA-SEQUENCE:"):], 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("invalid media sequence: %s", line)
+			}
+			mediaSequence = int(v)
+
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v := line[len("#EXTINF:"):]
+			if i := strings.Index(v, ","); i >= 0 {
+				v = v[:i]
+			}
+			d, err := strconv.ParseFloat(v, 64)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid segment duration: %s", line)
+			}
+			curDuration = time.Duration(d * float64(time.Second))
+
+		case line == "#EXT-X-ENDLIST":
+			p.endList = true
+
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			return nil, fmt.Errorf("fMP4 segments are not supported")
+
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			if !strings.Contains(line, "METHOD=NONE") {
+				return nil, fmt.Errorf("encrypted segments are not supported")
+			}
+
+		case strings.HasPrefix(line, "#"):
+
+		default:
+			if isVariant {
+				p.variants = append(p.variants, line)
+				isVariant = false
+				continue
+			}
+
+			p.segments = append(p.segments, clientSegment{
+				seq:      mediaSequence + len(p.segments),
+				duration: curDuration,
+				uri:      line,
+			})
+			curDuration = 0
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if p.variants == nil && p.targetDuration == 0 {
+		return nil, fmt.Errorf("invalid playlist: missing #EXT-X-TARGETDURATION")
+	}
+
+	return p, nil
+}
@@ -0,0 +1,101 @@
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientPlaylist(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		byts string
+		pl   *clientPlaylist
+	}{
+		{
+			"master",
+			"#EXTM3U\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"avc1.64000c\"\n" +
+				"low/stream.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000,CODECS=\"avc1.64001f\"\n" +
+				"high/stream.m3u8\n",
+			&clientPlaylist{
+				variants: []string{"low/stream.m3u8", "high/stream.m3u8"},
+			},
+		},
+		{
+			"media",
+			"#EXTM3U\n" +
+				"#EXT-X-VERSION:3\n" +
+				"#EXT-X-TARGETDURATION:2\n" +
+				"#EXT-X-MEDIA-SEQUENCE:27\n" +
+				"#EXTINF:2,\n" +
+				"seg27.ts\n" +
+				"#EXTINF:1.5,title\n" +
+				"seg28.ts\n",
+			&clientPlaylist{
+				targetDuration: 2 * time.Second,
+				segments: []clientSegment{
+					{27, 2 * time.Second, "seg27.ts"},
+					{28, 1500 * time.Millisecond, "seg28.ts"},
+				},
+			},
+		},
+		{
+			"ended",
+			"#EXTM3U\r\n" +
+				"#EXT-X-TARGETDURATION:1\r\n" +
+				"#EXTINF:1,\r\n" +
+				"http://host/seg0.ts\r\n" +
+				"#EXT-X-ENDLIST\r\n",
+			&clientPlaylist{
+				targetDuration: 1 * time.Second,
+				segments: []clientSegment{
+					{0, 1 * time.Second, "http://host/seg0.ts"},
+				},
+				endList: true,
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			pl, err := parseClientPlaylist([]byte(ca.byts))
+			require.NoError(t, err)
+			require.Equal(t, ca.pl, pl)
+		})
+	}
+}
+
+func TestParseClientPlaylistErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		byts string
+		err  string
+	}{
+		{
+			"missing header",
+			"#EXT-X-TARGETDURATION:2\n",
+			"invalid playlist: missing #EXTM3U",
+		},
+		{
+			"missing target duration",
+			"#EXTM3U\n#EXTINF:2,\nseg.ts\n",
+			"invalid playlist: missing #EXT-X-TARGETDURATION",
+		},
+		{
+			"fmp4",
+			"#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MAP:URI=\"init.mp4\"\n",
+			"fMP4 segments are not supported",
+		},
+		{
+			"encrypted",
+			"#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n",
+			"encrypted segments are not supported",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := parseClientPlaylist([]byte(ca.byts))
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package hls
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func writeFileAtomic(fpath string, r io.Reader) error {
+	// write into a temporary file and rename it, in order not to
+	// expose incomplete files.
+	tmpPath := fpath + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, fpath)
+}
+
+func (m *Muxer) diskDir() string {
+	return filepath.Dir(m.diskPlaylistPath)
+}
+
+// initDisk creates the directory in which files are persisted,
+// and removes the segments of previous sessions.
+func (m *Muxer) initDisk() error {
+	dir := m.diskDir()
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".ts" || ext == ".mp4") {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+
+	// the key is persisted when the playlist points to a file in the same directory
+	if m.hlsEncryptionKey != nil {
+		u, err := url.Parse(m.hlsEncryptionKeyURI)
+		if err == nil && !u.IsAbs() && !strings.Contains(m.hlsEncryptionKeyURI, "/") {
+			err := ioutil.WriteFile(filepath.Join(dir, m.hlsEncryptionKeyURI), m.hlsEncryptionKey, 0o600)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Muxer) persistInit(name string) error {
+	if m.diskPlaylistPath == "" {
+		return nil
+	}
+
+	return writeFileAtomic(filepath.Join(m.diskDir(), name), strings.NewReader(string(m.inits[name])))
+}
+
+// persistSegment writes a complete segment to disk.
+func (m *Muxer) persistSegment(f *segment) error {
+	if m.diskPlaylistPath == "" {
+		return nil
+	}
+
+	r := f.newReader()
+	if m.hlsEncryptionKey != nil {
+		r = m.encryptedReader(f)
+	}
+
+	err := writeFileAtomic(filepath.Join(m.diskDir(), f.name), r)
+	if err != nil {
+		return err
+	}
+
+	f.persisted = true
+	return nil
+}
+
+// persistPlaylist writes a playlist that lists the segments that have been
+// written to disk.
+func (m *Muxer) persistPlaylist() error {
+	if m.diskPlaylistPath == "" {
+		return nil
+	}
+
+	var segments []*segment
+	for _, f := range m.segments {
+		if f.persisted {
+			segments = append(segments, f)
+		}
+	}
+
+	if segments == nil {
+		return nil
+	}
+
+	return writeFileAtomic(m.diskPlaylistPath, strings.NewReader(m.standardPlaylist("", "", segments)))
+}
+
+func (m *Muxer) unpersist(name string) {
+	if m.diskPlaylistPath == "" {
+		return
+	}
+
+	os.Remove(filepath.Join(m.diskDir(), name))
+}
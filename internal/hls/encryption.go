@@ -0,0 +1,70 @@
+package hls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+)
+
+// aes128Reader encrypts a segment with AES-128 in CBC mode and PKCS7 padding,
+// as required by the AES-128 method of EXT-X-KEY. Data is encrypted
+// while it is read, in order to support segments that are still in progress.
+type aes128Reader struct {
+	r       io.Reader
+	mode    cipher.BlockMode
+	buf     []byte
+	pending []byte
+	out     []byte
+	eof     bool
+}
+
+// newAES128Reader allocates an aes128Reader. Since the playlist doesn't
+// contain the IV attribute, the IV is the media sequence number of the segment.
+func newAES128Reader(r io.Reader, key []byte, msn int) (*aes128Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(msn))
+
+	return &aes128Reader{
+		r:    r,
+		mode: cipher.NewCBCEncrypter(block, iv),
+		buf:  make([]byte, 4096),
+	}, nil
+}
+
+func (r *aes128Reader) Read(b []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		n, err := r.r.Read(r.buf)
+		r.pending = append(r.pending, r.buf[:n]...)
+
+		if err == io.EOF {
+			padding := aes.BlockSize - len(r.pending)%aes.BlockSize
+			for i := 0; i < padding; i++ {
+				r.pending = append(r.pending, byte(padding))
+			}
+			r.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+
+		le := len(r.pending) - len(r.pending)%aes.BlockSize
+		if le > 0 {
+			r.out = make([]byte, le)
+			r.mode.CryptBlocks(r.out, r.pending[:le])
+			r.pending = r.pending[le:]
+		}
+	}
+
+	n := copy(b, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
@@ -0,0 +1,40 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAES128Reader(t *testing.T) {
+	key := []byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+	}
+
+	for _, le := range []int{0, 15, 16, 5000} {
+		plain := bytes.Repeat([]byte{0x47}, le)
+
+		r, err := newAES128Reader(bytes.NewReader(plain), key, 3)
+		require.NoError(t, err)
+
+		enc, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, 0, len(enc)%aes.BlockSize)
+		require.Greater(t, len(enc), le)
+
+		block, err := aes.NewCipher(key)
+		require.NoError(t, err)
+		iv := make([]byte, aes.BlockSize)
+		iv[15] = 3
+		dec := make([]byte, len(enc))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(dec, enc)
+
+		padding := int(dec[len(dec)-1])
+		require.Equal(t, plain, dec[:len(dec)-padding])
+	}
+}
@@ -0,0 +1,149 @@
+package hls
+
+import (
+	"io"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/fmp4"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
+)
+
+// fmp4Encoder writes fragmented MP4 segments.
+// Access units are buffered and written as a fragment when flush() is called,
+// that is, once per part in low-latency mode, once per segment otherwise.
+type fmp4Encoder struct {
+	w               io.Writer
+	sequenceNumber  *uint32
+	videoIsH265     bool
+	audioSampleRate int
+	lastVideoDTS    *time.Duration
+	videoSamples    []*fmp4.Sample
+	audioSamples    []*fmp4.Sample
+	emsgs           [][]byte
+}
+
+// newFMP4Encoder allocates a fmp4Encoder.
+// sequenceNumber and lastVideoDTS are shared between the segments of a stream.
+func newFMP4Encoder(
+	w io.Writer,
+	sequenceNumber *uint32,
+	videoIsH265 bool,
+	audioSampleRate int,
+	lastVideoDTS *time.Duration) *fmp4Encoder {
+	return &fmp4Encoder{
+		w:               w,
+		sequenceNumber:  sequenceNumber,
+		videoIsH265:     videoIsH265,
+		audioSampleRate: audioSampleRate,
+		lastVideoDTS:    lastVideoDTS,
+	}
+}
+
+func (e *fmp4Encoder) flush(endTime time.Duration) {
+	// event messages precede the fragment they refer to
+	for _, emsg := range e.emsgs {
+		e.w.Write(emsg)
+	}
+	e.emsgs = nil
+
+	if len(e.videoSamples) == 0 && len(e.audioSamples) == 0 {
+		return
+	}
+
+	if len(e.videoSamples) > 0 {
+		last := e.videoSamples[len(e.videoSamples)-1]
+		if endTime > last.DTS {
+			last.Duration = endTime - last.DTS
+		} else if len(e.videoSamples) > 1 {
+			last.Duration = e.videoSamples[len(e.videoSamples)-2].Duration
+		} else {
+			last.Duration = time.Millisecond
+		}
+	}
+
+	*e.sequenceNumber++
+
+	e.w.Write(fmp4.GenerateFragment(*e.sequenceNumber, []*fmp4.FragmentTrack{
+		{
+			ID:        fmp4.VideoTrackID,
+			TimeScale: fmp4.VideoTimeScale,
+			Samples:   e.videoSamples,
+		},
+		{
+			ID:        fmp4.AudioTrackID,
+			TimeScale: uint32(e.audioSampleRate),
+			Samples:   e.audioSamples,
+		},
+	}))
+
+	e.videoSamples = nil
+	e.audioSamples = nil
+}
+
+// writeVideo buffers a H264 or H265 sample.
+// The estimated DTS is not used, since sample durations are computed from
+// the difference between consecutive DTS, that must be strictly increasing.
+func (e *fmp4Encoder) writeVideo(pcr time.Duration, dts time.Duration, pts time.Duration, isIDR bool, nalus [][]byte) error {
+	// remove parameter sets and AUD, since they're stored in the initialization section
+	filtered := make([][]byte, 0, len(nalus))
+	for _, nalu := range nalus {
+		if e.videoIsH265 {
+			switch h265.NALUTypeOf(nalu) {
+			case h265.NALUTypeVPS, h265.NALUTypeSPS, h265.NALUTypePPS, h265.NALUTypeAccessUnitDelimiter:
+				continue
+			}
+		} else {
+			switch h264.NALUType(nalu[0] & 0x1F) {
+			case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+				continue
+			}
+		}
+		filtered = append(filtered, nalu)
+	}
+
+	if len(filtered) == 0 {
+		return nil
+	}
+
+	data, err := h264.EncodeAVCC(filtered)
+	if err != nil {
+		return err
+	}
+
+	dts = pts
+	if *e.lastVideoDTS != 0 && dts <= *e.lastVideoDTS {
+		dts = *e.lastVideoDTS + time.Millisecond
+	}
+	*e.lastVideoDTS = dts
+
+	if len(e.videoSamples) > 0 {
+		prev := e.videoSamples[len(e.videoSamples)-1]
+		prev.Duration = dts - prev.DTS
+	}
+
+	e.videoSamples = append(e.videoSamples, &fmp4.Sample{
+		DTS:       dts,
+		PTSOffset: pts - dts,
+		IsSync:    isIDR,
+		Data:      data,
+	})
+
+	return nil
+}
+
+func (e *fmp4Encoder) writeAAC(pcr time.Duration, sampleRate int, channelCount int, pts time.Duration, au []byte) error {
+	e.audioSamples = append(e.audioSamples, &fmp4.Sample{
+		DTS:      pts,
+		Duration: 1024 * time.Second / time.Duration(sampleRate),
+		IsSync:   true,
+		Data:     au,
+	})
+
+	return nil
+}
+
+func (e *fmp4Encoder) writeID3(pcr time.Duration, pts time.Duration, id uint32, tag []byte) error {
+	e.emsgs = append(e.emsgs, fmp4.GenerateEmsg(fmp4.ID3SchemeIDURI, "", pts, id, tag))
+	return nil
+}
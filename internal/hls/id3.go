@@ -0,0 +1,36 @@
+package hls
+
+// id3SyncSafe encodes a size as a 28-bit synchsafe integer.
+func id3SyncSafe(v int) []byte {
+	return []byte{
+		byte(v>>21) & 0x7F,
+		byte(v>>14) & 0x7F,
+		byte(v>>7) & 0x7F,
+		byte(v) & 0x7F,
+	}
+}
+
+// ID3TextTag generates an ID3v2.4 tag that contains a single user-defined
+// text frame (TXXX), with the given description and value.
+func ID3TextTag(description string, value string) []byte {
+	content := make([]byte, 0, 2+len(description)+len(value))
+	content = append(content, 0x03) // UTF-8
+	content = append(content, description...)
+	content = append(content, 0x00)
+	content = append(content, value...)
+
+	frame := make([]byte, 0, 10+len(content))
+	frame = append(frame, "TXXX"...)
+	frame = append(frame, id3SyncSafe(len(content))...)
+	frame = append(frame, 0x00, 0x00) // flags
+	frame = append(frame, content...)
+
+	tag := make([]byte, 0, 10+len(frame))
+	tag = append(tag, "ID3"...)
+	tag = append(tag, 0x04, 0x00) // version 2.4.0
+	tag = append(tag, 0x00)       // flags
+	tag = append(tag, id3SyncSafe(len(frame))...)
+	tag = append(tag, frame...)
+
+	return tag
+}
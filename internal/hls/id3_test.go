@@ -0,0 +1,15 @@
+package hls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestID3TextTag(t *testing.T) {
+	require.Equal(t, []byte{
+		'I', 'D', '3', 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x14,
+		'T', 'X', 'X', 'X', 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00,
+		0x03, 's', 'c', 'o', 'r', 'e', 0x00, '2', '-', '1',
+	}, ID3TextTag("score", "2-1"))
+}
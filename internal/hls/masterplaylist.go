@@ -0,0 +1,86 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Variant is a rendition listed in a master playlist.
+type Variant struct {
+	// URL of the playlist of the rendition, relative to the master playlist.
+	URL string
+
+	// peak bitrate, in bits per second.
+	Bandwidth int
+
+	// codecs in RFC6381 format.
+	Codecs []string
+
+	// group ID of the alternate audio renditions that can be played
+	// together with the rendition.
+	Audio string
+}
+
+// AudioRendition is an alternate audio rendition listed in a master playlist.
+type AudioRendition struct {
+	// group ID, that is referenced by variants.
+	GroupID string
+
+	// human-readable name.
+	Name string
+
+	// whether the rendition is played when the user doesn't choose one.
+	Default bool
+
+	// URL of the playlist of the rendition, relative to the master playlist.
+	// When empty, the rendition is contained in the variants.
+	URL string
+}
+
+// SessionData is a metadata entry of a master playlist.
+type SessionData struct {
+	// identifier, in reverse DNS notation.
+	ID string
+
+	// value. It can't contain double quotes, that are removed.
+	Value string
+}
+
+// MasterPlaylist returns a reader to read a HLS master playlist in M3U8 format.
+func MasterPlaylist(variants []Variant, audioRenditions []AudioRendition, sessionData []SessionData) io.Reader {
+	cnt := "#EXTM3U\n"
+
+	for _, d := range sessionData {
+		cnt += "#EXT-X-SESSION-DATA:DATA-ID=\"" + d.ID + "\"," +
+			"VALUE=\"" + strings.ReplaceAll(d.Value, "\"", "") + "\"\n"
+	}
+
+	for _, a := range audioRenditions {
+		cnt += "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"" + a.GroupID + "\",NAME=\"" + a.Name + "\""
+		if a.Default {
+			cnt += ",DEFAULT=YES,AUTOSELECT=YES"
+		} else {
+			cnt += ",DEFAULT=NO,AUTOSELECT=YES"
+		}
+		if a.URL != "" {
+			cnt += ",URI=\"" + a.URL + "\""
+		}
+		cnt += "\n"
+	}
+
+	for _, v := range variants {
+		cnt += "#EXT-X-STREAM-INF:BANDWIDTH=" + strconv.FormatInt(int64(v.Bandwidth), 10)
+		if len(v.Codecs) > 0 {
+			cnt += ",CODECS=\"" + strings.Join(v.Codecs, ",") + "\""
+		}
+		if v.Audio != "" {
+			cnt += ",AUDIO=\"" + v.Audio + "\""
+		}
+		cnt += "\n"
+		cnt += v.URL + "\n"
+	}
+
+	return bytes.NewReader([]byte(cnt))
+}
@@ -0,0 +1,79 @@
+package hls
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterPlaylist(t *testing.T) {
+	byts, err := ioutil.ReadAll(MasterPlaylist([]Variant{
+		{
+			URL:       "../cam1_1080/stream.m3u8",
+			Bandwidth: 4000000,
+			Codecs:    []string{"avc1.640028", "mp4a.40.2"},
+		},
+		{
+			URL:       "../cam1_720/stream.m3u8",
+			Bandwidth: 2000000,
+		},
+	}, nil, nil))
+	require.NoError(t, err)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=4000000,CODECS=\"avc1.640028,mp4a.40.2\"\n"+
+		"../cam1_1080/stream.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000\n"+
+		"../cam1_720/stream.m3u8\n", string(byts))
+}
+
+func TestMasterPlaylistSessionData(t *testing.T) {
+	byts, err := ioutil.ReadAll(MasterPlaylist([]Variant{
+		{
+			URL:       "../cam1_1080/stream.m3u8",
+			Bandwidth: 4000000,
+		},
+	}, nil, []SessionData{
+		{
+			ID:    "com.apple.hls.title",
+			Value: "My \"camera\"",
+		},
+	}))
+	require.NoError(t, err)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-SESSION-DATA:DATA-ID=\"com.apple.hls.title\",VALUE=\"My camera\"\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=4000000\n"+
+		"../cam1_1080/stream.m3u8\n", string(byts))
+}
+
+func TestMasterPlaylistAudioRenditions(t *testing.T) {
+	byts, err := ioutil.ReadAll(MasterPlaylist([]Variant{
+		{
+			URL:       "../cam1/stream.m3u8",
+			Bandwidth: 4000000,
+			Codecs:    []string{"avc1.640028", "mp4a.40.2"},
+			Audio:     "audio",
+		},
+	}, []AudioRendition{
+		{
+			GroupID: "audio",
+			Name:    "audio1",
+			Default: true,
+		},
+		{
+			GroupID: "audio",
+			Name:    "audio2",
+			URL:     "../cam1/audio2.m3u8",
+		},
+	}, nil))
+	require.NoError(t, err)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio1\",DEFAULT=YES,AUTOSELECT=YES\n"+
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio2\",DEFAULT=NO,AUTOSELECT=YES,"+
+		"URI=\"../cam1/audio2.m3u8\"\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=4000000,CODECS=\"avc1.640028,mp4a.40.2\",AUDIO=\"audio\"\n"+
+		"../cam1/stream.m3u8\n", string(byts))
+}
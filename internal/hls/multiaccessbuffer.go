@@ -61,6 +61,19 @@ func (m *multiAccessBuffer) Write(p []byte) (int, error) {
 	return n, nil
 }
 
+func (m *multiAccessBuffer) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.writePos
+}
+
+// Slice returns a portion of the buffer that has already been written.
+func (m *multiAccessBuffer) Slice(start int, end int) []byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.buf.Bytes()[start:end]
+}
+
 func (m *multiAccessBuffer) NewReader() io.Reader {
 	return &multiAccessBufferReader{
 		m: m,
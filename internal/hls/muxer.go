@@ -2,8 +2,12 @@ package hls
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"math"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,7 +16,9 @@ import (
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/rtpaac"
 
+	"github.com/aler9/rtsp-simple-server/internal/fmp4"
 	"github.com/aler9/rtsp-simple-server/internal/h264"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
 )
 
 const (
@@ -22,30 +28,94 @@ const (
 	ptsOffset = 2 * time.Second
 
 	segmentMinAUCount = 100
+
+	// number of segments, starting from the last one, whose parts are listed
+	// in low-latency playlists.
+	partSegmentCount = 3
+)
+
+// SegmentFormat is the format of segments.
+type SegmentFormat int
+
+// segment formats.
+const (
+	SegmentFormatMPEGTS SegmentFormat = iota
+	SegmentFormatFMP4
 )
 
+// ErrPlaylistRequestInvalid is returned by WaitPlaylist when the requested
+// segment is too far in the future.
+var ErrPlaylistRequestInvalid = errors.New("requested segment is too far in the future")
+
 // Muxer is a HLS muxer.
 type Muxer struct {
-	hlsSegmentCount    int
-	hlsSegmentDuration time.Duration
-	videoTrack         *gortsplib.Track
-	audioTrack         *gortsplib.Track
-
-	aacConfig     rtpaac.MPEG4AudioConfig
-	startPCR      time.Time
-	videoDTSEst   *h264.DTSEstimator
-	audioAUCount  int
-	tsCurrent     *tsFile
-	tsQueue       []*tsFile
-	tsByName      map[string]*tsFile
-	tsDeleteCount int
-	mutex         sync.RWMutex
+	hlsSegmentCount     int
+	hlsSegmentDuration  time.Duration
+	hlsDVRWindow        time.Duration
+	hlsPartDuration     time.Duration
+	hlsSegmentFormat    SegmentFormat
+	hlsSegmentName      string
+	hlsEncryptionKey    []byte
+	hlsEncryptionKeyURI string
+	diskPlaylistPath    string
+	videoTrack          *gortsplib.Track
+	audioTrack          *gortsplib.Track
+
+	videoIsH265        bool
+	aacConfig          rtpaac.MPEG4AudioConfig
+	startPCR           time.Time
+	videoDTSEst        *h264.DTSEstimator
+	audioAUCount       int
+	currentSegment     *segment
+	segments           []*segment
+	segmentsByName     map[string]*segment
+	segmentDeleteCount int
+	discDelCount       int
+	lastTime           int64
+	segmentSeq         int
+	lastPTS            time.Duration
+	id3Count           uint32
+	ended              bool
+	refPTS             time.Duration
+	refTime            time.Time
+	mutex              sync.RWMutex
+
+	// fMP4 only
+	initName           string
+	initCount          int
+	inits              map[string][]byte
+	fmp4SequenceNumber uint32
+	fmp4LastVideoDTS   time.Duration
+
+	// closed and replaced when the playlist changes
+	changed chan struct{}
 }
 
 // NewMuxer allocates a Muxer.
+// hlsSegmentName is the template of segment names, where $TIME is replaced
+// with the Unix time and $SEQ with the sequence number of the segment.
+// If hlsDVRWindow is not zero, segments are kept as long as they are within
+// this duration from the end of the stream, allowing players to seek back.
+// If hlsPartDuration is not zero, the muxer works in low-latency mode
+// and segments are split into parts of this duration.
+// In fMP4 format, the .ts suffix of segment names is replaced with .mp4.
+// If hlsEncryptionKey is not nil, segments are encrypted with AES-128 and
+// the playlist points to hlsEncryptionKeyURI, that is relative to the
+// playlist unless it is absolute. Encryption is supported with MPEG-TS
+// segments only, in standard mode.
+// If diskPlaylistPath is not empty, complete segments are also written to
+// disk, together with a playlist that lists them, in the same directory.
+// videoTrack can be a H264 or a H265 track.
 func NewMuxer(
 	hlsSegmentCount int,
 	hlsSegmentDuration time.Duration,
+	hlsDVRWindow time.Duration,
+	hlsPartDuration time.Duration,
+	hlsSegmentFormat SegmentFormat,
+	hlsSegmentName string,
+	hlsEncryptionKey []byte,
+	hlsEncryptionKeyURI string,
+	diskPlaylistPath string,
 	videoTrack *gortsplib.Track,
 	audioTrack *gortsplib.Track) (*Muxer, error) {
 	var aacConfig rtpaac.MPEG4AudioConfig
@@ -62,26 +132,257 @@ func NewMuxer(
 	}
 
 	m := &Muxer{
-		hlsSegmentCount:    hlsSegmentCount,
-		hlsSegmentDuration: hlsSegmentDuration,
-		videoTrack:         videoTrack,
-		audioTrack:         audioTrack,
-		aacConfig:          aacConfig,
-		startPCR:           time.Now(),
-		videoDTSEst:        h264.NewDTSEstimator(),
-		tsCurrent:          newTSFile(videoTrack != nil, audioTrack != nil),
-		tsByName:           make(map[string]*tsFile),
+		hlsSegmentCount:     hlsSegmentCount,
+		hlsSegmentDuration:  hlsSegmentDuration,
+		hlsDVRWindow:        hlsDVRWindow,
+		hlsPartDuration:     hlsPartDuration,
+		hlsSegmentFormat:    hlsSegmentFormat,
+		hlsSegmentName:      hlsSegmentName,
+		hlsEncryptionKey:    hlsEncryptionKey,
+		hlsEncryptionKeyURI: hlsEncryptionKeyURI,
+		diskPlaylistPath:    diskPlaylistPath,
+		videoTrack:          videoTrack,
+		audioTrack:          audioTrack,
+		videoIsH265:         videoTrack != nil && h265.IsTrack(videoTrack),
+		aacConfig:           aacConfig,
+		startPCR:            time.Now(),
+		videoDTSEst:         h264.NewDTSEstimator(),
+		segmentsByName:      make(map[string]*segment),
+		inits:               make(map[string][]byte),
+		changed:             make(chan struct{}),
+	}
+
+	if diskPlaylistPath != "" {
+		err := m.initDisk()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := m.updateInit()
+	if err != nil {
+		return nil, err
 	}
 
-	m.tsByName[m.tsCurrent.name] = m.tsCurrent
-	m.tsQueue = append(m.tsQueue, m.tsCurrent)
+	m.currentSegment = m.createSegment()
+
+	m.segmentsByName[m.currentSegment.name] = m.currentSegment
+	m.segments = append(m.segments, m.currentSegment)
 
 	return m, nil
 }
 
 // Close closes a Muxer.
 func (m *Muxer) Close() {
-	m.tsCurrent.close()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.currentSegment.close()
+
+	if m.currentSegment.firstPacketWritten {
+		m.persistSegment(m.currentSegment)
+		m.persistPlaylist()
+	}
+}
+
+// notifyChange wakes up the routines that are waiting for a playlist change.
+func (m *Muxer) notifyChange() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Restart must be called when the stream is restarted with the given tracks.
+// The next segment begins with a discontinuity, and timestamps can start
+// again from zero.
+func (m *Muxer) Restart(videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) error {
+	var aacConfig rtpaac.MPEG4AudioConfig
+	if audioTrack != nil {
+		byts, err := audioTrack.ExtractDataAAC()
+		if err != nil {
+			return err
+		}
+
+		err = aacConfig.Decode(byts)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.videoTrack = videoTrack
+	m.audioTrack = audioTrack
+	m.videoIsH265 = videoTrack != nil && h265.IsTrack(videoTrack)
+	m.aacConfig = aacConfig
+	m.videoDTSEst = h264.NewDTSEstimator()
+	m.audioAUCount = 0
+	m.fmp4LastVideoDTS = 0
+	m.lastPTS = 0
+	m.refTime = time.Time{}
+
+	err := m.updateInit()
+	if err != nil {
+		return err
+	}
+
+	// the current segment has already been finished by End()
+	ended := m.ended
+	m.ended = false
+
+	// an empty segment can be reused
+	if !m.currentSegment.firstPacketWritten {
+		m.currentSegment.close()
+		delete(m.segmentsByName, m.currentSegment.name)
+		m.currentSegment = m.createSegment()
+		m.segmentsByName[m.currentSegment.name] = m.currentSegment
+		m.segments[len(m.segments)-1] = m.currentSegment
+		m.removeUnusedInits()
+	} else {
+		if !ended {
+			m.currentSegment.close()
+			err := m.persistSegment(m.currentSegment)
+			if err != nil {
+				return err
+			}
+		}
+
+		m.currentSegment = m.createSegment()
+		m.pushSegment()
+		m.notifyChange()
+
+		err = m.persistPlaylist()
+		if err != nil {
+			return err
+		}
+	}
+
+	m.currentSegment.discontinuity = true
+
+	return nil
+}
+
+// End finishes the current segment and appends the EXT-X-ENDLIST tag to
+// the playlist, in order to tell players that the stream is over.
+// Data written afterwards is discarded, until Restart() is called.
+func (m *Muxer) End() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.ended {
+		return nil
+	}
+
+	m.ended = true
+
+	if m.currentSegment.firstPacketWritten {
+		m.currentSegment.close()
+		err := m.persistSegment(m.currentSegment)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.notifyChange()
+
+	return m.persistPlaylist()
+}
+
+// updateInit generates the initialization section of the current tracks,
+// in fMP4 format.
+func (m *Muxer) updateInit() error {
+	if m.hlsSegmentFormat != SegmentFormatFMP4 {
+		return nil
+	}
+
+	initData, err := fmp4.GenerateInit(m.videoTrack, m.audioTrack)
+	if err != nil {
+		return err
+	}
+
+	m.initName = "init_" + strconv.FormatInt(int64(m.initCount), 10) + ".mp4"
+	m.initCount++
+	m.inits[m.initName] = initData
+
+	return m.persistInit(m.initName)
+}
+
+// removeUnusedInits removes initialization sections that are not used
+// by any segment.
+func (m *Muxer) removeUnusedInits() {
+	for name := range m.inits {
+		used := false
+		for _, s := range m.segments {
+			if s.initName == name {
+				used = true
+				break
+			}
+		}
+		if !used {
+			delete(m.inits, name)
+			m.unpersist(name)
+		}
+	}
+}
+
+// createSegment allocates a segment. The Unix time used in names is incremented
+// in case it has already been used, in order to keep names unique.
+func (m *Muxer) createSegment() *segment {
+	t := time.Now().Unix()
+	if t <= m.lastTime {
+		t = m.lastTime + 1
+	}
+	m.lastTime = t
+
+	name := strings.ReplaceAll(m.hlsSegmentName, "$TIME", strconv.FormatInt(t, 10))
+	name = strings.ReplaceAll(name, "$SEQ", strconv.FormatInt(int64(m.segmentSeq), 10))
+	m.segmentSeq++
+
+	if m.hlsSegmentFormat == SegmentFormatFMP4 {
+		return newSegment(strings.TrimSuffix(name, ".ts")+".mp4", m.initName, m.videoTrack != nil,
+			func(w io.Writer) segmentEncoder {
+				return newFMP4Encoder(w, &m.fmp4SequenceNumber, m.videoIsH265, m.aacConfig.SampleRate, &m.fmp4LastVideoDTS)
+			})
+	}
+
+	return newSegment(name, "", m.videoTrack != nil,
+		func(w io.Writer) segmentEncoder {
+			return newTSEncoder(w, m.videoTrack != nil, m.videoIsH265, m.audioTrack != nil, m.id3Count != 0)
+		})
+}
+
+// pushSegment adds the current segment to the queue and removes the oldest ones.
+func (m *Muxer) pushSegment() {
+	m.segmentsByName[m.currentSegment.name] = m.currentSegment
+	m.segments = append(m.segments, m.currentSegment)
+	for m.segmentExpired() {
+		if m.segments[0].discontinuity {
+			m.discDelCount++
+		}
+		delete(m.segmentsByName, m.segments[0].name)
+		m.unpersist(m.segments[0].name)
+		m.segments = m.segments[1:]
+		m.segmentDeleteCount++
+		m.removeUnusedInits()
+	}
+}
+
+// segmentExpired checks whether the oldest segment can be removed.
+func (m *Muxer) segmentExpired() bool {
+	if len(m.segments) <= m.hlsSegmentCount {
+		return false
+	}
+
+	if m.hlsDVRWindow == 0 {
+		return true
+	}
+
+	// the oldest segment is removed when the other ones cover the window
+	var d time.Duration
+	for _, f := range m.segments[1:] {
+		d += f.duration()
+	}
+	return d >= m.hlsDVRWindow
 }
 
 // WriteH264 writes H264 NALUs, grouped by PTS, into the muxer.
@@ -96,34 +397,69 @@ func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
 		return false
 	}()
 
+	return m.writeVideo(pts, idrPresent, nalus)
+}
+
+// WriteH265 writes H265 NALUs, grouped by PTS, into the muxer.
+func (m *Muxer) WriteH265(pts time.Duration, nalus [][]byte) error {
+	idrPresent := func() bool {
+		for _, nalu := range nalus {
+			if h265.NALUTypeOf(nalu).IsRandomAccess() {
+				return true
+			}
+		}
+		return false
+	}()
+
+	return m.writeVideo(pts, idrPresent, nalus)
+}
+
+func (m *Muxer) writeVideo(pts time.Duration, idrPresent bool, nalus [][]byte) error {
 	// skip group silently until we find one with a IDR
-	if !m.tsCurrent.firstPacketWritten && !idrPresent {
+	if !m.currentSegment.firstPacketWritten && !idrPresent {
 		return nil
 	}
 
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if m.ended {
+		return nil
+	}
+
 	if idrPresent &&
-		m.tsCurrent.firstPacketWritten &&
-		m.tsCurrent.duration() >= m.hlsSegmentDuration {
-		if m.tsCurrent != nil {
-			m.tsCurrent.close()
+		m.currentSegment.firstPacketWritten &&
+		m.currentSegment.duration() >= m.hlsSegmentDuration {
+		if m.currentSegment != nil {
+			m.currentSegment.finish(pts + ptsOffset)
+			m.currentSegment.close()
+			err := m.persistSegment(m.currentSegment)
+			if err != nil {
+				return err
+			}
 		}
 
-		m.tsCurrent = newTSFile(m.videoTrack != nil, m.audioTrack != nil)
+		m.currentSegment = m.createSegment()
+		m.pushSegment()
+		m.notifyChange()
 
-		m.tsByName[m.tsCurrent.name] = m.tsCurrent
-		m.tsQueue = append(m.tsQueue, m.tsCurrent)
-		if len(m.tsQueue) > m.hlsSegmentCount {
-			delete(m.tsByName, m.tsQueue[0].name)
-			m.tsQueue = m.tsQueue[1:]
-			m.tsDeleteCount++
+		err := m.persistPlaylist()
+		if err != nil {
+			return err
 		}
 	}
 
-	m.tsCurrent.setPCR(time.Since(m.startPCR))
-	err := m.tsCurrent.writeH264(
+	// parts are split with the same timestamps used to compute the segment duration
+	if m.hlsPartDuration != 0 && m.currentSegment.updatePart(pts+ptsOffset, idrPresent, m.hlsPartDuration) {
+		m.notifyChange()
+	}
+
+	if !m.currentSegment.firstPacketWritten {
+		m.currentSegment.startTime = m.absoluteTime(pts)
+	}
+
+	m.currentSegment.setPCR(time.Since(m.startPCR))
+	err := m.currentSegment.writeVideo(
 		m.videoDTSEst.Feed(pts+ptsOffset),
 		pts+ptsOffset,
 		idrPresent,
@@ -132,6 +468,8 @@ func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
 		return err
 	}
 
+	m.lastPTS = pts + ptsOffset
+
 	return nil
 }
 
@@ -140,27 +478,36 @@ func (m *Muxer) WriteAAC(pts time.Duration, aus [][]byte) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if m.ended {
+		return nil
+	}
+
 	if m.videoTrack == nil {
 		if m.audioAUCount >= segmentMinAUCount &&
-			m.tsCurrent.firstPacketWritten &&
-			m.tsCurrent.duration() >= m.hlsSegmentDuration {
-
-			if m.tsCurrent != nil {
-				m.tsCurrent.close()
+			m.currentSegment.firstPacketWritten &&
+			m.currentSegment.duration() >= m.hlsSegmentDuration {
+
+			if m.currentSegment != nil {
+				m.currentSegment.finish(pts + ptsOffset)
+				m.currentSegment.close()
+				err := m.persistSegment(m.currentSegment)
+				if err != nil {
+					return err
+				}
 			}
 
 			m.audioAUCount = 0
-			m.tsCurrent = newTSFile(m.videoTrack != nil, m.audioTrack != nil)
-			m.tsByName[m.tsCurrent.name] = m.tsCurrent
-			m.tsQueue = append(m.tsQueue, m.tsCurrent)
-			if len(m.tsQueue) > m.hlsSegmentCount {
-				delete(m.tsByName, m.tsQueue[0].name)
-				m.tsQueue = m.tsQueue[1:]
-				m.tsDeleteCount++
+			m.currentSegment = m.createSegment()
+			m.pushSegment()
+			m.notifyChange()
+
+			err := m.persistPlaylist()
+			if err != nil {
+				return err
 			}
 		}
 	} else {
-		if !m.tsCurrent.firstPacketWritten {
+		if !m.currentSegment.firstPacketWritten {
 			return nil
 		}
 	}
@@ -168,9 +515,19 @@ func (m *Muxer) WriteAAC(pts time.Duration, aus [][]byte) error {
 	for i, au := range aus {
 		auPTS := pts + time.Duration(i)*1000*time.Second/time.Duration(m.aacConfig.SampleRate)
 
+		// when there's a video track, parts are split on video access units
+		if m.videoTrack == nil && m.hlsPartDuration != 0 &&
+			m.currentSegment.updatePart(auPTS+ptsOffset, true, m.hlsPartDuration) {
+			m.notifyChange()
+		}
+
+		if !m.currentSegment.firstPacketWritten {
+			m.currentSegment.startTime = m.absoluteTime(auPTS)
+		}
+
 		m.audioAUCount++
-		m.tsCurrent.setPCR(time.Since(m.startPCR))
-		err := m.tsCurrent.writeAAC(
+		m.currentSegment.setPCR(time.Since(m.startPCR))
+		err := m.currentSegment.writeAAC(
 			m.aacConfig.SampleRate,
 			m.aacConfig.ChannelCount,
 			auPTS+ptsOffset,
@@ -178,60 +535,403 @@ func (m *Muxer) WriteAAC(pts time.Duration, aus [][]byte) error {
 		if err != nil {
 			return err
 		}
+
+		if m.videoTrack == nil {
+			m.lastPTS = auPTS + ptsOffset
+		}
 	}
 
 	return nil
 }
 
+// SetReferenceTime associates a timestamp, expressed with the same clock of
+// the timestamps passed to the write methods, with an absolute time, that
+// is used to fill the EXT-X-PROGRAM-DATE-TIME tags of next segments.
+// If it's never called, the wall clock at the first access unit is used.
+func (m *Muxer) SetReferenceTime(pts time.Duration, t time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.refPTS = pts
+	m.refTime = t
+}
+
+// absoluteTime converts a timestamp into an absolute time.
+func (m *Muxer) absoluteTime(pts time.Duration) time.Time {
+	if m.refTime.IsZero() {
+		m.refPTS = pts
+		m.refTime = time.Now()
+	}
+	return m.refTime.Add(pts - m.refPTS)
+}
+
+// WriteID3 writes an ID3 tag into the muxer, with the timestamp of the
+// last access unit. In MPEG-TS segments, tags are written into a metadata
+// stream; in fMP4 segments, they are written into event messages.
+func (m *Muxer) WriteID3(tag []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.lastPTS == 0 {
+		return fmt.Errorf("the stream has not started yet")
+	}
+
+	if m.ended {
+		return fmt.Errorf("the stream has ended")
+	}
+
+	m.id3Count++
+	return m.currentSegment.writeID3(m.lastPTS, m.id3Count, tag)
+}
+
 // Playlist returns a reader to read the HLS playlist in M3U8 format.
-func (m *Muxer) Playlist() io.Reader {
+// segmentPrefix is prepended to the URLs of segments, and segmentQuery,
+// if not empty, is appended to them as query string.
+func (m *Muxer) Playlist(segmentPrefix string, segmentQuery string) io.Reader {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	if len(m.tsQueue) == 0 {
+	if len(m.segments) == 0 {
 		return nil
 	}
 
+	if m.hlsPartDuration != 0 {
+		return m.lowLatencyPlaylist(segmentPrefix, segmentQuery)
+	}
+
+	return bytes.NewReader([]byte(m.standardPlaylist(segmentPrefix, segmentQuery, m.segments)))
+}
+
+// standardPlaylist generates a playlist without parts, that lists the given segments.
+func (m *Muxer) standardPlaylist(segmentPrefix string, segmentQuery string, segments []*segment) string {
 	cnt := "#EXTM3U\n"
-	cnt += "#EXT-X-VERSION:3\n"
-	cnt += "#EXT-X-ALLOW-CACHE:NO\n"
+	if m.hlsSegmentFormat == SegmentFormatFMP4 {
+		cnt += "#EXT-X-VERSION:7\n"
+	} else {
+		cnt += "#EXT-X-VERSION:3\n"
+		cnt += "#EXT-X-ALLOW-CACHE:NO\n"
+	}
+	cnt += "#EXT-X-TARGETDURATION:" + strconv.FormatUint(uint64(m.targetDuration()), 10) + "\n"
+
+	cnt += "#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(m.segmentDeleteCount), 10) + "\n"
+
+	if m.discDelCount > 0 {
+		cnt += "#EXT-X-DISCONTINUITY-SEQUENCE:" + strconv.FormatInt(int64(m.discDelCount), 10) + "\n"
+	}
+
+	if m.hlsEncryptionKey != nil {
+		cnt += m.keyTag(segmentPrefix, segmentQuery)
+	}
+
+	initName := ""
+	for _, f := range segments {
+		// the segment that follows the last one is empty
+		if m.ended && !f.firstPacketWritten {
+			continue
+		}
+
+		if f.discontinuity {
+			cnt += "#EXT-X-DISCONTINUITY\n"
+		}
+		cnt += mapTag(&initName, f, segmentPrefix, segmentQuery)
+		cnt += programDateTimeTag(f)
+		cnt += "#EXTINF:" + strconv.FormatFloat(f.duration().Seconds(), 'f', -1, 64) + ",\n"
+		cnt += fileURI(segmentPrefix, segmentQuery, f.name) + "\n"
+	}
+
+	if m.ended {
+		cnt += "#EXT-X-ENDLIST\n"
+	}
+
+	return cnt
+}
 
-	targetDuration := func() uint {
-		ret := uint(math.Ceil(m.hlsSegmentDuration.Seconds()))
+func (m *Muxer) targetDuration() uint {
+	ret := uint(math.Ceil(m.hlsSegmentDuration.Seconds()))
 
-		// EXTINF, when rounded to the nearest integer, must be <= EXT-X-TARGETDURATION
-		for _, f := range m.tsQueue {
-			v2 := uint(math.Round(f.duration().Seconds()))
-			if v2 > ret {
-				ret = v2
+	// EXTINF, when rounded to the nearest integer, must be <= EXT-X-TARGETDURATION
+	for _, f := range m.segments {
+		v2 := uint(math.Round(f.duration().Seconds()))
+		if v2 > ret {
+			ret = v2
+		}
+	}
+
+	return ret
+}
+
+// partTargetDuration returns the maximum duration of parts.
+// Parts are split on access units, therefore they can be slightly longer
+// than the configured duration.
+func (m *Muxer) partTargetDuration() time.Duration {
+	ret := m.hlsPartDuration
+	for _, f := range m.segments {
+		for _, p := range f.parts {
+			if p.duration > ret {
+				ret = p.duration
 			}
 		}
+	}
+	return ret
+}
 
-		return ret
-	}()
-	cnt += "#EXT-X-TARGETDURATION:" + strconv.FormatUint(uint64(targetDuration), 10) + "\n"
+// lowLatencyPlaylist generates a playlist with parts (LL-HLS).
+// The segment being written is listed through its parts only.
+func (m *Muxer) lowLatencyPlaylist(segmentPrefix string, segmentQuery string) io.Reader {
+	complete := m.segments[:len(m.segments)-1]
+	if len(complete) == 0 && len(m.currentSegment.parts) == 0 {
+		return nil
+	}
 
-	cnt += "#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(m.tsDeleteCount), 10) + "\n"
+	partTarget := m.partTargetDuration()
 
-	for _, f := range m.tsQueue {
-		cnt += "#EXTINF:" + strconv.FormatFloat(f.duration().Seconds(), 'f', -1, 64) + ",\n"
-		cnt += f.name + ".ts\n"
+	cnt := "#EXTM3U\n"
+	cnt += "#EXT-X-VERSION:9\n"
+	cnt += "#EXT-X-TARGETDURATION:" + strconv.FormatUint(uint64(m.targetDuration()), 10) + "\n"
+	cnt += "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=" +
+		strconv.FormatFloat((3*partTarget).Seconds(), 'f', 5, 64) + "\n"
+	cnt += "#EXT-X-PART-INF:PART-TARGET=" + strconv.FormatFloat(partTarget.Seconds(), 'f', 5, 64) + "\n"
+	cnt += "#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(m.segmentDeleteCount), 10) + "\n"
+
+	if m.discDelCount > 0 {
+		cnt += "#EXT-X-DISCONTINUITY-SEQUENCE:" + strconv.FormatInt(int64(m.discDelCount), 10) + "\n"
+	}
+
+	initName := ""
+	for i, f := range m.segments {
+		if m.ended && !f.firstPacketWritten {
+			continue
+		}
+
+		if f.discontinuity {
+			cnt += "#EXT-X-DISCONTINUITY\n"
+		}
+		cnt += mapTag(&initName, f, segmentPrefix, segmentQuery)
+
+		// the tag refers to the first part or segment that follows it
+		if f != m.currentSegment || m.ended || len(f.parts) != 0 {
+			cnt += programDateTimeTag(f)
+		}
+
+		if i >= (len(m.segments) - partSegmentCount) {
+			for _, p := range f.parts {
+				cnt += "#EXT-X-PART:DURATION=" + strconv.FormatFloat(p.duration.Seconds(), 'f', 5, 64) +
+					",URI=\"" + fileURI(segmentPrefix, segmentQuery, p.name) + "\""
+				if p.independent {
+					cnt += ",INDEPENDENT=YES"
+				}
+				cnt += "\n"
+			}
+		}
+
+		if f != m.currentSegment || m.ended {
+			cnt += "#EXTINF:" + strconv.FormatFloat(f.duration().Seconds(), 'f', -1, 64) + ",\n"
+			cnt += fileURI(segmentPrefix, segmentQuery, f.name) + "\n"
+		}
+	}
+
+	if m.ended {
+		cnt += "#EXT-X-ENDLIST\n"
+	} else if m.currentSegment.currentPart != nil {
+		cnt += "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"" +
+			fileURI(segmentPrefix, segmentQuery, m.currentSegment.currentPart.name) + "\"\n"
 	}
 
 	return bytes.NewReader([]byte(cnt))
 }
 
-// TSFile returns a reader to read a given MPEG-TS file.
-func (m *Muxer) TSFile(fname string) io.Reader {
-	base := strings.TrimSuffix(fname, ".ts")
+// fileURI returns the URI of a file of the muxer.
+func fileURI(segmentPrefix string, segmentQuery string, name string) string {
+	if segmentQuery != "" {
+		return segmentPrefix + name + "?" + segmentQuery
+	}
+	return segmentPrefix + name
+}
+
+// keyTag returns the EXT-X-KEY tag.
+func (m *Muxer) keyTag(segmentPrefix string, segmentQuery string) string {
+	uri := m.hlsEncryptionKeyURI
+	if u, err := url.Parse(uri); err == nil && !u.IsAbs() && !strings.HasPrefix(uri, "/") {
+		uri = fileURI(segmentPrefix, segmentQuery, uri)
+	}
+	return "#EXT-X-KEY:METHOD=AES-128,URI=\"" + uri + "\"\n"
+}
 
+// programDateTimeTag returns the EXT-X-PROGRAM-DATE-TIME tag of a segment,
+// that contains the absolute time of its first access unit.
+func programDateTimeTag(f *segment) string {
+	if f.startTime.IsZero() {
+		return ""
+	}
+	return "#EXT-X-PROGRAM-DATE-TIME:" + f.startTime.UTC().Format("2006-01-02T15:04:05.000Z07:00") + "\n"
+}
+
+// mapTag returns a EXT-X-MAP tag when the initialization section of a
+// segment differs from the one of the previous segment.
+func mapTag(prevInitName *string, f *segment, segmentPrefix string, segmentQuery string) string {
+	if f.initName == "" || f.initName == *prevInitName {
+		return ""
+	}
+	*prevInitName = f.initName
+	return "#EXT-X-MAP:URI=\"" + fileURI(segmentPrefix, segmentQuery, f.initName) + "\"\n"
+}
+
+// playlistContains checks whether the playlist contains the segment with
+// the given media sequence number, or one of its parts if part is not negative.
+func (m *Muxer) playlistContains(msn int, part int) (bool, error) {
+	// the playlist won't change anymore
+	if m.ended {
+		return true, nil
+	}
+
+	currentMSN := m.segmentDeleteCount + len(m.segments) - 1
+
+	if msn > currentMSN+1 {
+		return false, ErrPlaylistRequestInvalid
+	}
+
+	if msn < currentMSN {
+		return true, nil
+	}
+
+	if msn == currentMSN && part >= 0 {
+		return part < len(m.currentSegment.parts), nil
+	}
+
+	return false, nil
+}
+
+// WaitPlaylist waits until the playlist contains the segment with the
+// given media sequence number, or one of its parts if part is not negative
+// (blocking playlist reload).
+func (m *Muxer) WaitPlaylist(ctx context.Context, msn int, part int) error {
+	for {
+		m.mutex.RLock()
+		ok, err := m.playlistContains(msn, part)
+		changed := m.changed
+		m.mutex.RUnlock()
+
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TargetDuration returns the target duration of segments.
+func (m *Muxer) TargetDuration() time.Duration {
 	m.mutex.RLock()
-	f, ok := m.tsByName[base]
-	m.mutex.RUnlock()
+	defer m.mutex.RUnlock()
 
-	if !ok {
-		return nil
+	return time.Duration(m.targetDuration()) * time.Second
+}
+
+// Bandwidth returns the peak bitrate of the stream, in bits per second,
+// computed on segments. The segment being written is taken into account
+// only when there are no other segments.
+func (m *Muxer) Bandwidth() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	segments := m.segments
+	if len(segments) > 1 {
+		segments = segments[:len(segments)-1]
+	}
+
+	ret := 0
+	for _, f := range segments {
+		d := f.duration()
+		if d <= 0 {
+			continue
+		}
+
+		v := int(float64(f.size()*8) / d.Seconds())
+		if v > ret {
+			ret = v
+		}
+	}
+
+	return ret
+}
+
+// Codecs returns the codecs of the stream, in RFC6381 format.
+func (m *Muxer) Codecs() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var ret []string
+
+	if m.videoTrack != nil {
+		if m.videoIsH265 {
+			_, sps, _, err := h265.ExtractParams(m.videoTrack)
+			if err == nil {
+				spsp, err := h265.DecodeSPS(sps)
+				if err == nil {
+					ret = append(ret, spsp.Codec())
+				}
+			}
+		} else {
+			sps, _, err := m.videoTrack.ExtractDataH264()
+			if err == nil && len(sps) >= 4 {
+				ret = append(ret, fmt.Sprintf("avc1.%02x%02x%02x", sps[1], sps[2], sps[3]))
+			}
+		}
+	}
+
+	if m.audioTrack != nil {
+		ret = append(ret, "mp4a.40."+strconv.FormatInt(int64(m.aacConfig.Type), 10))
 	}
 
-	return f.newReader()
+	return ret
+}
+
+// File returns a reader to read a given segment.
+// In low-latency mode, parts can be read too.
+// In fMP4 format, initialization sections can be read too.
+func (m *Muxer) File(fname string) io.Reader {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if initData, ok := m.inits[fname]; ok {
+		return bytes.NewReader(initData)
+	}
+
+	f, ok := m.segmentsByName[fname]
+	if ok {
+		if m.hlsEncryptionKey != nil {
+			return m.encryptedReader(f)
+		}
+		return f.newReader()
+	}
+
+	if m.hlsPartDuration != 0 {
+		for _, f := range m.segments {
+			if p := f.findPart(fname); p != nil {
+				return p.newReader()
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Muxer) encryptedReader(f *segment) io.Reader {
+	for i, f2 := range m.segments {
+		if f2 == f {
+			r, err := newAES128Reader(f.newReader(), m.hlsEncryptionKey, m.segmentDeleteCount+i)
+			if err != nil {
+				return nil
+			}
+			return r
+		}
+	}
+	return nil
 }
@@ -1,14 +1,26 @@
 package hls
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/aler9/gortsplib"
+	"github.com/asticode/go-astits"
 	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/h265"
 )
 
+// pdtTag matches a EXT-X-PROGRAM-DATE-TIME tag.
+const pdtTag = `#EXT-X-PROGRAM-DATE-TIME:[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}\.[0-9]{3}Z\n`
+
 func TestMuxer(t *testing.T) {
 	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
 	require.NoError(t, err)
@@ -16,7 +28,7 @@ func TestMuxer(t *testing.T) {
 	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{17, 144})
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 5*time.Second, videoTrack, audioTrack)
+	m, err := NewMuxer(3, 5*time.Second, 0, 0, SegmentFormatMPEGTS, "$TIME.ts", nil, "", "", videoTrack, audioTrack)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -47,8 +59,594 @@ func TestMuxer(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	byts, err := ioutil.ReadAll(m.Playlist())
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+
+	require.Regexp(t, `^#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:5\n#EXT-X-MEDIA-SEQUENCE:0\n`+pdtTag+`#EXTINF:2,\n[0-9]+\.ts\n$`, string(byts))
+}
+
+func TestMuxerRestart(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(2, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "$TIME.ts", nil, "", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.WriteH264(0, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	err = m.WriteH264(1*time.Second, [][]byte{{0x01}})
+	require.NoError(t, err)
+
+	err = m.Restart(videoTrack, nil)
+	require.NoError(t, err)
+
+	// timestamps start again from zero
+	err = m.WriteH264(0, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	err = m.WriteH264(1*time.Second, [][]byte{{0x01}})
+	require.NoError(t, err)
+
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+
+	require.Regexp(t, `^#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:1\n`+
+		`#EXT-X-MEDIA-SEQUENCE:0\n`+
+		pdtTag+`#EXTINF:1,\n[0-9]+\.ts\n`+
+		`#EXT-X-DISCONTINUITY\n`+pdtTag+`#EXTINF:1,\n[0-9]+\.ts\n$`, string(byts))
+
+	// remove the segment with the discontinuity
+	for i := 0; i < 2; i++ {
+		err = m.Restart(videoTrack, nil)
+		require.NoError(t, err)
+
+		err = m.WriteH264(0, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	byts, err = ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `#EXT-X-MEDIA-SEQUENCE:2\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n`, string(byts))
+}
+
+func TestMuxerSegmentName(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `\n`+pdtTag+`#EXTINF:1,\nseg_0\.ts\n`+pdtTag+`#EXTINF:0,\nseg_1\.ts\n$`, string(byts))
+
+	require.NotNil(t, m.File("seg_1.ts"))
+	require.Nil(t, m.File("seg_2.ts"))
+}
+
+func TestMuxerSegmentQuery(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	byts, err := ioutil.ReadAll(m.Playlist("mystream/", "user=myuser&pass=mypass"))
+	require.NoError(t, err)
+	require.Regexp(t, `\n`+pdtTag+`#EXTINF:1,\nmystream/seg_0\.ts\?user=myuser&pass=mypass\n`+
+		pdtTag+`#EXTINF:0,\nmystream/seg_1\.ts\?user=myuser&pass=mypass\n$`, string(byts))
+}
+
+func TestMuxerInProgressSegment(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.WriteH264(0, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	// the segment being written is listed and can be read before it's complete
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `\nseg_0\.ts\n$`, string(byts))
+
+	r := m.File("seg_0.ts")
+	require.NotNil(t, r)
+
+	buf := make([]byte, 4096)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.NotEqual(t, 0, n)
+
+	// data written later is received by the same reader
+	err = m.WriteH264(1*time.Second, [][]byte{{0x01}})
+	require.NoError(t, err)
+
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.NotEqual(t, 0, n)
+
+	// the reader is terminated when the segment is complete
+	err = m.WriteH264(2*time.Second, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	_, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+}
+
+func TestMuxerBandwidthCodecs(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x67, 0x64, 0x00, 0x28}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{17, 144})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "$SEQ.ts", nil, "", "", videoTrack, audioTrack)
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.Equal(t, []string{"avc1.640028", "mp4a.40.2"}, m.Codecs())
+	require.Equal(t, 0, m.Bandwidth())
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	require.NotEqual(t, 0, m.Bandwidth())
+}
+
+func TestMuxerLowLatency(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 200*time.Millisecond, SegmentFormatMPEGTS, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.WriteH264(0, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	for i := 1; i <= 11; i++ {
+		err = m.WriteH264(time.Duration(i)*100*time.Millisecond, [][]byte{{0x01}})
+		require.NoError(t, err)
+	}
+
+	err = m.WriteH264(1200*time.Millisecond, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n#EXT-X-VERSION:9\n#EXT-X-TARGETDURATION:1\n`+
+		`#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.60000\n`+
+		`#EXT-X-PART-INF:PART-TARGET=0.20000\n#EXT-X-MEDIA-SEQUENCE:0\n`+
+		pdtTag+`#EXT-X-PART:DURATION=0.20000,URI="seg_0_part0.ts",INDEPENDENT=YES\n`+
+		`#EXT-X-PART:DURATION=0.20000,URI="seg_0_part1.ts"\n`+
+		`(#EXT-X-PART:DURATION=0.20000,URI="seg_0_part[2-5].ts"\n){4}`+
+		`#EXTINF:1.1,\nseg_0.ts\n`+
+		`#EXT-X-PRELOAD-HINT:TYPE=PART,URI="seg_1_part0.ts"\n$`, string(byts))
+
+	byts, err = ioutil.ReadAll(m.File("seg_0_part0.ts"))
+	require.NoError(t, err)
+	require.Equal(t, byte(0x47), byts[0])
+
+	// blocking playlist reload
+	err = m.WaitPlaylist(context.Background(), 3, -1)
+	require.Equal(t, ErrPlaylistRequestInvalid, err)
+
+	err = m.WaitPlaylist(context.Background(), 0, -1)
+	require.NoError(t, err)
+
+	waitDone := make(chan error)
+	go func() {
+		waitDone <- m.WaitPlaylist(context.Background(), 1, 0)
+	}()
+
+	// parts can be requested before they're complete
+	r := m.File("seg_1_part0.ts")
+	require.NotNil(t, r)
+	readDone := make(chan []byte)
+	go func() {
+		byts, _ := ioutil.ReadAll(r)
+		readDone <- byts
+	}()
+
+	select {
+	case <-waitDone:
+		t.Errorf("should not happen")
+	case <-readDone:
+		t.Errorf("should not happen")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	err = m.WriteH264(1400*time.Millisecond, [][]byte{{0x01}})
+	require.NoError(t, err)
+
+	require.NoError(t, <-waitDone)
+	require.NotEqual(t, 0, len(<-readDone))
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer ctxCancel()
+	err = m.WaitPlaylist(ctx, 1, 5)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestMuxerFMP4(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96,
+		[]byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x40},
+		[]byte{0x68, 0xee, 0x3c, 0x80})
 	require.NoError(t, err)
 
-	require.Regexp(t, `^#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:5\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:2,\n[0-9]+\.ts\n$`, string(byts))
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{17, 144})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatFMP4, "seg_$SEQ.ts", nil, "", "", videoTrack, audioTrack)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{
+			{0x07, 0x01},
+			{0x08, 0x01},
+			{0x05, 0x01},
+		})
+		require.NoError(t, err)
+
+		err = m.WriteAAC(time.Duration(i)*time.Second, [][]byte{{0x01, 0x02}})
+		require.NoError(t, err)
+	}
+
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n`+
+		`#EXT-X-MAP:URI="init_0.mp4"\n`+
+		pdtTag+`#EXTINF:1,\nseg_0.mp4\n`+pdtTag+`#EXTINF:0,\nseg_1.mp4\n$`, string(byts))
+
+	byts, err = ioutil.ReadAll(m.File("init_0.mp4"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("ftyp"), byts[4:8])
+
+	byts, err = ioutil.ReadAll(m.File("seg_0.mp4"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("moof"), byts[4:8])
+
+	// a restart changes the initialization section
+	err = m.Restart(videoTrack, nil)
+	require.NoError(t, err)
+
+	err = m.WriteH264(0, [][]byte{{0x05, 0x01}})
+	require.NoError(t, err)
+
+	byts, err = ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI="init_1.mp4"\n`+pdtTag+`#EXTINF:0,\nseg_2.mp4\n$`, string(byts))
+	require.NotNil(t, m.File("init_0.mp4"))
+}
+
+func TestMuxerEncryption(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	key := bytes.Repeat([]byte{0x01}, 16)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", key, "encryption.key", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	byts, err := ioutil.ReadAll(m.Playlist("mypath/", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-KEY:METHOD=AES-128,URI="mypath/encryption.key"\n`+pdtTag+`#EXTINF:1,\nmypath/seg_0\.ts\n`, string(byts))
+
+	enc, err := ioutil.ReadAll(m.File("seg_0.ts"))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(enc)%aes.BlockSize)
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	dec := make([]byte, len(enc))
+	cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(dec, enc)
+	require.Equal(t, byte(0x47), dec[0])
+
+	m2, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", key, "https://keys.example.com/key", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m2.Close()
+
+	err = m2.WriteH264(0, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	byts, err = ioutil.ReadAll(m2.Playlist("mypath/", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `\n#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example.com/key"\n`, string(byts))
+}
+
+func TestMuxerDVRWindow(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(2, 1*time.Second, 5*time.Second, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := 0; i < 16; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	// segments are kept until the following ones cover the window
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `#EXT-X-MEDIA-SEQUENCE:2\n`+
+		pdtTag+`#EXTINF:1,\nseg_2\.ts\n`+pdtTag+`#EXTINF:1,\nseg_3\.ts\n`+pdtTag+`#EXTINF:1,\nseg_4\.ts\n`+
+		pdtTag+`#EXTINF:1,\nseg_5\.ts\n`+pdtTag+`#EXTINF:1,\nseg_6\.ts\n`+pdtTag+`#EXTINF:1,\nseg_7\.ts\n$`, string(byts))
+
+	require.Nil(t, m.File("seg_1.ts"))
+	require.NotNil(t, m.File("seg_2.ts"))
+}
+
+func TestMuxerDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-hls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// segments of previous sessions are removed
+	err = os.MkdirAll(filepath.Join(dir, "mypath"), 0o755)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "mypath", "old.ts"), []byte{0x01}, 0o644)
+	require.NoError(t, err)
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", nil, "",
+		filepath.Join(dir, "mypath", "stream.m3u8"), videoTrack, nil)
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "mypath", "old.ts"))
+	require.True(t, os.IsNotExist(err))
+
+	for i := 0; i < 8; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	// only complete segments are written
+	byts, err := ioutil.ReadFile(filepath.Join(dir, "mypath", "stream.m3u8"))
+	require.NoError(t, err)
+	require.Regexp(t, `#EXT-X-MEDIA-SEQUENCE:1\n`+
+		pdtTag+`#EXTINF:1,\nseg_1\.ts\n`+pdtTag+`#EXTINF:1,\nseg_2\.ts\n$`, string(byts))
+
+	_, err = os.Stat(filepath.Join(dir, "mypath", "seg_0.ts"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "mypath", "seg_2.ts"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "mypath", "seg_3.ts"))
+	require.True(t, os.IsNotExist(err))
+
+	// the last segment is written when closing
+	m.Close()
+
+	byts, err = ioutil.ReadFile(filepath.Join(dir, "mypath", "stream.m3u8"))
+	require.NoError(t, err)
+	require.Regexp(t, `\n`+pdtTag+`#EXTINF:1,\nseg_3\.ts\n$`, string(byts))
+}
+
+func TestMuxerH265(t *testing.T) {
+	videoTrack := h265.NewTrack(96,
+		[]byte{
+			0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60,
+			0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03,
+			0x00, 0x00, 0x03, 0x00, 0x78, 0x99, 0x98, 0x09,
+		},
+		[]byte{
+			0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+			0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+			0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+			0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+			0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+			0xe0, 0x80,
+		},
+		[]byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40})
+
+	for _, ca := range []string{"mpegts", "fmp4"} {
+		t.Run(ca, func(t *testing.T) {
+			format := SegmentFormatMPEGTS
+			if ca == "fmp4" {
+				format = SegmentFormatFMP4
+			}
+
+			m, err := NewMuxer(3, 1*time.Second, 0, 0, format, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+			require.NoError(t, err)
+			defer m.Close()
+
+			require.Equal(t, []string{"hvc1.1.6.L120.90"}, m.Codecs())
+
+			// group without IDR
+			err = m.WriteH265(0, [][]byte{{0x02, 0x01, 0xaa}})
+			require.NoError(t, err)
+
+			for i := 1; i < 4; i++ {
+				err = m.WriteH265(time.Duration(i)*time.Second, [][]byte{{0x26, 0x01, 0xaa}})
+				require.NoError(t, err)
+			}
+
+			byts, err := ioutil.ReadAll(m.Playlist("", ""))
+			require.NoError(t, err)
+
+			if ca == "mpegts" {
+				require.Regexp(t, pdtTag+`#EXTINF:1,\nseg_0.ts\n`, string(byts))
+
+				dem := astits.NewDemuxer(context.Background(), m.File("seg_0.ts"))
+				foundPMT := false
+				foundPES := false
+				for {
+					d, err := dem.NextData()
+					if err != nil {
+						break
+					}
+					if d.PMT != nil {
+						require.Equal(t, astits.StreamTypeH265Video, d.PMT.ElementaryStreams[0].StreamType)
+						foundPMT = true
+					}
+					if d.PES != nil && d.PID == 256 {
+						require.Equal(t, []byte{0x00, 0x00, 0x00, 0x01, 0x26, 0x01, 0xaa}, d.PES.Data)
+						foundPES = true
+					}
+				}
+				require.Equal(t, true, foundPMT)
+				require.Equal(t, true, foundPES)
+			} else {
+				require.Regexp(t, pdtTag+`#EXTINF:1,\nseg_0.mp4\n`, string(byts))
+
+				byts, err := ioutil.ReadAll(m.File("init_0.mp4"))
+				require.NoError(t, err)
+				require.Equal(t, true, bytes.Contains(byts, []byte("hvcC")))
+			}
+		})
+	}
+}
+
+func TestMuxerID3(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96,
+		[]byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x40},
+		[]byte{0x68, 0xee, 0x3c, 0x80})
+	require.NoError(t, err)
+
+	tag := ID3TextTag("score", "2-1")
+
+	for _, ca := range []string{"mpegts", "fmp4"} {
+		t.Run(ca, func(t *testing.T) {
+			format := SegmentFormatMPEGTS
+			if ca == "fmp4" {
+				format = SegmentFormatFMP4
+			}
+
+			m, err := NewMuxer(3, 1*time.Second, 0, 0, format, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+			require.NoError(t, err)
+			defer m.Close()
+
+			// tags can't be written before the stream starts
+			err = m.WriteID3(tag)
+			require.Error(t, err)
+
+			err = m.WriteH264(0, [][]byte{{0x05, 0x01}})
+			require.NoError(t, err)
+
+			err = m.WriteID3(tag)
+			require.NoError(t, err)
+
+			for i := 1; i < 3; i++ {
+				err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05, 0x01}})
+				require.NoError(t, err)
+			}
+
+			if ca == "mpegts" {
+				dem := astits.NewDemuxer(context.Background(), m.File("seg_0.ts"))
+				found := false
+				for {
+					d, err := dem.NextData()
+					if err != nil {
+						break
+					}
+					if d.PES != nil && d.PID == 258 {
+						require.Equal(t, tag, d.PES.Data)
+						require.Equal(t, int64(ptsOffset.Seconds()*90000), d.PES.Header.OptionalHeader.PTS.Base)
+						found = true
+					}
+				}
+				require.Equal(t, true, found)
+			} else {
+				byts, err := ioutil.ReadAll(m.File("seg_0.mp4"))
+				require.NoError(t, err)
+				require.Equal(t, []byte("emsg"), byts[4:8])
+				require.Equal(t, true, bytes.Contains(byts, tag))
+			}
+		})
+	}
+}
+
+func TestMuxerEnd(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	err = m.End()
+	require.NoError(t, err)
+
+	// data is discarded
+	err = m.WriteH264(3*time.Second, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, pdtTag+`#EXTINF:1,\nseg_0\.ts\n`+pdtTag+`#EXTINF:0,\nseg_1\.ts\n#EXT-X-ENDLIST\n$`, string(byts))
+
+	// the last segment is complete
+	byts, err = ioutil.ReadAll(m.File("seg_1.ts"))
+	require.NoError(t, err)
+	require.NotEqual(t, 0, len(byts))
+
+	// blocking requests are served immediately
+	err = m.WaitPlaylist(context.Background(), 5, -1)
+	require.NoError(t, err)
+
+	err = m.Restart(videoTrack, nil)
+	require.NoError(t, err)
+
+	err = m.WriteH264(0, [][]byte{{0x05}})
+	require.NoError(t, err)
+
+	byts, err = ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, pdtTag+`#EXTINF:0,\nseg_1\.ts\n#EXT-X-DISCONTINUITY\n`+pdtTag+`#EXTINF:0,\nseg_2\.ts\n$`, string(byts))
+}
+
+func TestMuxerProgramDateTime(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, SegmentFormatMPEGTS, "seg_$SEQ.ts", nil, "", "", videoTrack, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	m.SetReferenceTime(500*time.Millisecond, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(i)*time.Second, [][]byte{{0x05}})
+		require.NoError(t, err)
+	}
+
+	byts, err := ioutil.ReadAll(m.Playlist("", ""))
+	require.NoError(t, err)
+	require.Regexp(t, `\n#EXT-X-PROGRAM-DATE-TIME:2019-12-31T23:59:59.500Z\n#EXTINF:1,\nseg_0\.ts\n`+
+		`#EXT-X-PROGRAM-DATE-TIME:2020-01-01T00:00:01.500Z\n#EXTINF:0,\nseg_1\.ts\n$`, string(byts))
 }
@@ -0,0 +1,63 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// part is a partial segment, used in low-latency mode.
+// It is a portion of a segment that begins with an access unit.
+type part struct {
+	segment     *segment
+	name        string
+	start       int
+	end         int
+	startTime   time.Duration
+	lastTime    time.Duration
+	duration    time.Duration
+	independent bool
+	done        chan struct{}
+}
+
+func newPart(segment *segment, id int, start int, startTime time.Duration, independent bool) *part {
+	ext := filepath.Ext(segment.name)
+
+	return &part{
+		segment:     segment,
+		name:        strings.TrimSuffix(segment.name, ext) + "_part" + strconv.FormatInt(int64(id), 10) + ext,
+		start:       start,
+		startTime:   startTime,
+		lastTime:    startTime,
+		independent: independent,
+		done:        make(chan struct{}),
+	}
+}
+
+func (p *part) finish(endTime time.Duration) {
+	p.end = p.segment.buf.Len()
+	p.duration = endTime - p.startTime
+	close(p.done)
+}
+
+// newReader returns a reader that waits for the part to be finished.
+// This allows clients to request parts in advance (preload hints).
+func (p *part) newReader() io.Reader {
+	return &partReader{p: p}
+}
+
+type partReader struct {
+	p *part
+	r io.Reader
+}
+
+func (r *partReader) Read(b []byte) (int, error) {
+	if r.r == nil {
+		<-r.p.done
+		r.r = bytes.NewReader(r.p.segment.buf.Slice(r.p.start, r.p.end))
+	}
+	return r.r.Read(b)
+}
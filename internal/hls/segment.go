@@ -0,0 +1,164 @@
+package hls
+
+import (
+	"io"
+	"time"
+)
+
+// segmentEncoder writes access units into a segment, in a given format.
+type segmentEncoder interface {
+	writeVideo(pcr time.Duration, dts time.Duration, pts time.Duration, isIDR bool, nalus [][]byte) error
+	writeAAC(pcr time.Duration, sampleRate int, channelCount int, pts time.Duration, au []byte) error
+	writeID3(pcr time.Duration, pts time.Duration, id uint32, tag []byte) error
+
+	// flush writes buffered access units, if any, into the segment.
+	// endTime is the timestamp of the access unit that follows them.
+	flush(endTime time.Duration)
+}
+
+type segment struct {
+	name               string
+	initName           string
+	buf                *multiAccessBuffer
+	enc                segmentEncoder
+	hasVideoTrack      bool
+	pcr                time.Duration
+	firstPacketWritten bool
+	discontinuity      bool
+	persisted          bool
+	startTime          time.Time
+	minPTS             time.Duration
+	maxPTS             time.Duration
+	lastTime           time.Duration
+	parts              []*part
+	currentPart        *part
+}
+
+func newSegment(
+	name string,
+	initName string,
+	hasVideoTrack bool,
+	newEncoder func(w io.Writer) segmentEncoder) *segment {
+	s := &segment{
+		name:          name,
+		initName:      initName,
+		buf:           newMultiAccessBuffer(),
+		hasVideoTrack: hasVideoTrack,
+	}
+
+	s.enc = newEncoder(s.buf)
+
+	return s
+}
+
+// finish writes buffered access units and finishes the current part, if any.
+// endTime is the timestamp of the access unit that follows the segment.
+func (s *segment) finish(endTime time.Duration) {
+	if s.currentPart != nil {
+		s.finishPart(endTime)
+	} else {
+		s.enc.flush(endTime)
+	}
+}
+
+func (s *segment) close() error {
+	s.finish(s.lastTime)
+	return s.buf.Close()
+}
+
+// updatePart must be called before writing an access unit with the given
+// timestamp, in low-latency mode. The current part is finished when it
+// exceeds partDuration, and a new part is started. It returns whether a part
+// has been finished.
+func (s *segment) updatePart(ts time.Duration, independent bool, partDuration time.Duration) bool {
+	if s.currentPart == nil {
+		// the first part includes data written at the beginning of the segment
+		start := 0
+		if len(s.parts) > 0 {
+			start = s.buf.Len()
+		}
+		s.currentPart = newPart(s, len(s.parts), start, ts, independent)
+		return false
+	}
+
+	if (ts - s.currentPart.startTime) < partDuration {
+		s.currentPart.lastTime = ts
+		return false
+	}
+
+	s.finishPart(ts)
+	s.currentPart = newPart(s, len(s.parts), s.buf.Len(), ts, independent)
+	return true
+}
+
+func (s *segment) finishPart(endTime time.Duration) {
+	s.enc.flush(endTime)
+	s.currentPart.finish(endTime)
+	s.parts = append(s.parts, s.currentPart)
+	s.currentPart = nil
+}
+
+func (s *segment) findPart(name string) *part {
+	for _, p := range s.parts {
+		if p.name == name {
+			return p
+		}
+	}
+	if s.currentPart != nil && s.currentPart.name == name {
+		return s.currentPart
+	}
+	return nil
+}
+
+func (s *segment) duration() time.Duration {
+	return s.maxPTS - s.minPTS
+}
+
+func (s *segment) size() int {
+	return s.buf.Len()
+}
+
+func (s *segment) setPCR(pcr time.Duration) {
+	s.pcr = pcr
+}
+
+func (s *segment) newReader() io.Reader {
+	return s.buf.NewReader()
+}
+
+func (s *segment) updatePTS(pts time.Duration) {
+	if !s.firstPacketWritten {
+		s.firstPacketWritten = true
+		s.minPTS = pts
+		s.maxPTS = pts
+	} else {
+		if pts < s.minPTS {
+			s.minPTS = pts
+		}
+		if pts > s.maxPTS {
+			s.maxPTS = pts
+		}
+	}
+}
+
+func (s *segment) writeVideo(dts time.Duration, pts time.Duration, isIDR bool, nalus [][]byte) error {
+	if s.hasVideoTrack {
+		s.updatePTS(pts)
+		s.lastTime = pts
+	}
+
+	return s.enc.writeVideo(s.pcr, dts, pts, isIDR, nalus)
+}
+
+func (s *segment) writeAAC(sampleRate int, channelCount int, pts time.Duration, au []byte) error {
+	if !s.hasVideoTrack {
+		s.updatePTS(pts)
+		s.lastTime = pts
+	}
+
+	return s.enc.writeAAC(s.pcr, sampleRate, channelCount, pts, au)
+}
+
+func (s *segment) writeID3(pts time.Duration, id uint32, tag []byte) error {
+	return s.enc.writeID3(s.pcr, pts, id, tag)
+}
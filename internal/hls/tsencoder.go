@@ -0,0 +1,186 @@
+package hls
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/asticode/go-astits"
+
+	"github.com/aler9/rtsp-simple-server/internal/aac"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+// tsEncoder writes MPEG-TS segments.
+type tsEncoder struct {
+	mux             *astits.Muxer
+	pcrTrackIsVideo bool
+	hasID3Track     bool
+}
+
+// newTSEncoder allocates a tsEncoder.
+// videoIsH265 tells whether the video track is a H265 track instead of a H264 one.
+// hasID3Track tells whether the metadata stream, that is otherwise added
+// when the first ID3 tag is written, is present since the beginning.
+func newTSEncoder(
+	w io.Writer,
+	hasVideoTrack bool,
+	videoIsH265 bool,
+	hasAudioTrack bool,
+	hasID3Track bool) *tsEncoder {
+	e := &tsEncoder{
+		mux: astits.NewMuxer(context.Background(), w),
+	}
+
+	if hasVideoTrack {
+		streamType := astits.StreamTypeH264Video
+		if videoIsH265 {
+			streamType = astits.StreamTypeH265Video
+		}
+
+		e.mux.AddElementaryStream(astits.PMTElementaryStream{
+			ElementaryPID: 256,
+			StreamType:    streamType,
+		})
+	}
+
+	if hasAudioTrack {
+		e.mux.AddElementaryStream(astits.PMTElementaryStream{
+			ElementaryPID: 257,
+			StreamType:    astits.StreamTypeAACAudio,
+		})
+	}
+
+	if hasID3Track {
+		e.addID3Track()
+	}
+
+	if hasVideoTrack {
+		e.pcrTrackIsVideo = true
+		e.mux.SetPCRPID(256)
+	} else {
+		e.pcrTrackIsVideo = false
+		e.mux.SetPCRPID(257)
+	}
+
+	// write PMT at the beginning of every segment
+	// so no packets are lost
+	e.mux.WriteTables()
+
+	return e
+}
+
+func (e *tsEncoder) addID3Track() {
+	e.mux.AddElementaryStream(astits.PMTElementaryStream{
+		ElementaryPID: 258,
+		StreamType:    astits.StreamTypeMetadata,
+	})
+	e.hasID3Track = true
+}
+
+func (e *tsEncoder) flush(time.Duration) {
+}
+
+func (e *tsEncoder) writeVideo(pcr time.Duration, dts time.Duration, pts time.Duration, isIDR bool, nalus [][]byte) error {
+	enc, err := h264.EncodeAnnexB(nalus)
+	if err != nil {
+		return err
+	}
+
+	af := &astits.PacketAdaptationField{
+		RandomAccessIndicator: isIDR,
+	}
+
+	if e.pcrTrackIsVideo {
+		af.HasPCR = true
+		af.PCR = &astits.ClockReference{Base: int64(pcr.Seconds() * 90000)}
+	}
+
+	_, err = e.mux.WriteData(&astits.MuxerData{
+		PID:             256,
+		AdaptationField: af,
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorBothPresent,
+					DTS:             &astits.ClockReference{Base: int64(dts.Seconds() * 90000)},
+					PTS:             &astits.ClockReference{Base: int64(pts.Seconds() * 90000)},
+				},
+				StreamID: 224, // = video
+			},
+			Data: enc,
+		},
+	})
+	return err
+}
+
+func (e *tsEncoder) writeAAC(pcr time.Duration, sampleRate int, channelCount int, pts time.Duration, au []byte) error {
+	adtsPkt, err := aac.EncodeADTS([]*aac.ADTSPacket{
+		{
+			SampleRate:   sampleRate,
+			ChannelCount: channelCount,
+			Frame:        au,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	af := &astits.PacketAdaptationField{
+		RandomAccessIndicator: true,
+	}
+
+	if !e.pcrTrackIsVideo {
+		af.HasPCR = true
+		af.PCR = &astits.ClockReference{Base: int64(pcr.Seconds() * 90000)}
+	}
+
+	_, err = e.mux.WriteData(&astits.MuxerData{
+		PID:             257,
+		AdaptationField: af,
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+					PTS:             &astits.ClockReference{Base: int64(pts.Seconds() * 90000)},
+				},
+				PacketLength: uint16(len(adtsPkt) + 8),
+				StreamID:     192, // = audio
+			},
+			Data: adtsPkt,
+		},
+	})
+	return err
+}
+
+func (e *tsEncoder) writeID3(pcr time.Duration, pts time.Duration, id uint32, tag []byte) error {
+	if !e.hasID3Track {
+		e.addID3Track()
+
+		// write the updated PMT
+		_, err := e.mux.WriteTables()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := e.mux.WriteData(&astits.MuxerData{
+		PID: 258,
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:             2,
+					DataAlignmentIndicator: true,
+					PTSDTSIndicator:        astits.PTSDTSIndicatorOnlyPTS,
+					PTS:                    &astits.ClockReference{Base: int64(pts.Seconds() * 90000)},
+				},
+				PacketLength: uint16(len(tag) + 8),
+				StreamID:     189, // = private stream 1
+			},
+			Data: tag,
+		},
+	})
+	return err
+}
@@ -163,15 +163,22 @@ func writeContent(buf *bytes.Buffer, format string, args []interface{}) {
 	buf.WriteByte('\n')
 }
 
+// SetLevel changes the minimum level of log entries.
+func (lh *Logger) SetLevel(level Level) {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+	lh.level = level
+}
+
 // Log writes a log entry.
 func (lh *Logger) Log(level Level, format string, args ...interface{}) {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+
 	if level < lh.level {
 		return
 	}
 
-	lh.mutex.Lock()
-	defer lh.mutex.Unlock()
-
 	if _, ok := lh.destinations[DestinationStdout]; ok {
 		lh.stdoutBuffer.Reset()
 		writeTime(&lh.stdoutBuffer, true)
@@ -0,0 +1,492 @@
+package mpegts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/asticode/go-astits"
+
+	"github.com/aler9/rtsp-simple-server/internal/aac"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+const (
+	// amount of data that is read to find the parameters of tracks.
+	readerProbeSize = 8 * 1024 * 1024
+
+	// amount of data, from the end of the file, that is read to find the duration.
+	readerDurationProbeSize = 2 * 1024 * 1024
+
+	// the binary search of seeking stops when the distance between the
+	// bounds is below this number of packets.
+	readerSeekPrecision = 256
+)
+
+// Frame is a group of H264 NALUs or AAC AUs read from a file.
+type Frame struct {
+	// whether the frame belongs to the video track.
+	Video bool
+
+	// presentation timestamp, relative to the beginning of the file.
+	PTS time.Duration
+
+	// whether playback can start from the frame.
+	SyncPoint bool
+
+	// NALUs or AUs.
+	Data [][]byte
+}
+
+// Reader reads H264 and AAC access units from a MPEG-TS file,
+// and allows to seek into it.
+type Reader struct {
+	f          *os.File
+	size       int64
+	videoPID   uint16
+	audioPID   uint16
+	videoTrack *gortsplib.Track
+	audioTrack *gortsplib.Track
+	startPTS   int64
+	duration   time.Duration
+
+	dem     *astits.Demuxer
+	synced  bool
+	syncPTS time.Duration
+}
+
+// OpenReader opens a MPEG-TS file.
+func OpenReader(fpath string) (*Reader, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r := &Reader{
+		f:    f,
+		size: fi.Size(),
+	}
+
+	err = r.findTracks()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	err = r.findDuration()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	_, err = r.Seek(0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Close closes the file.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// Tracks returns the video and audio track. One of them can be nil.
+func (r *Reader) Tracks() (*gortsplib.Track, *gortsplib.Track) {
+	return r.videoTrack, r.audioTrack
+}
+
+// Duration returns the duration of the file.
+func (r *Reader) Duration() time.Duration {
+	return r.duration
+}
+
+func (r *Reader) demuxer(offset int64, maxSize int64) *astits.Demuxer {
+	return astits.NewDemuxer(context.Background(),
+		io.NewSectionReader(r.f, offset, maxSize))
+}
+
+// nextPES returns the next PES of the video or audio track.
+func (r *Reader) nextPES(dem *astits.Demuxer) (*astits.DemuxerData, error) {
+	for {
+		data, err := dem.NextData()
+		if err != nil {
+			if err == astits.ErrNoMorePackets {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		if data.PES == nil || data.PES.Header.OptionalHeader == nil ||
+			data.PES.Header.OptionalHeader.PTS == nil ||
+			(data.PID != r.videoPID && data.PID != r.audioPID) {
+			continue
+		}
+
+		return data, nil
+	}
+}
+
+func (r *Reader) findTracks() error {
+	dem := r.demuxer(0, readerProbeSize)
+
+	pmtFound := false
+	var sps []byte
+	var pps []byte
+	var aacConfig []byte
+	startPTSSet := false
+
+	for {
+		data, err := dem.NextData()
+		if err != nil {
+			if err == astits.ErrNoMorePackets {
+				break
+			}
+			return err
+		}
+
+		if data.PMT != nil {
+			if !pmtFound {
+				pmtFound = true
+				for _, es := range data.PMT.ElementaryStreams {
+					switch es.StreamType {
+					case astits.StreamTypeH264Video:
+						if r.videoPID == 0 {
+							r.videoPID = es.ElementaryPID
+						}
+
+					case astits.StreamTypeAACAudio:
+						if r.audioPID == 0 {
+							r.audioPID = es.ElementaryPID
+						}
+					}
+				}
+			}
+			continue
+		}
+
+		if data.PES == nil || data.PES.Header.OptionalHeader == nil ||
+			data.PES.Header.OptionalHeader.PTS == nil ||
+			(data.PID != r.videoPID && data.PID != r.audioPID) {
+			continue
+		}
+
+		if !startPTSSet {
+			startPTSSet = true
+			r.startPTS = data.PES.Header.OptionalHeader.PTS.Base
+		}
+
+		switch data.PID {
+		case r.videoPID:
+			if sps != nil && pps != nil {
+				break
+			}
+
+			nalus, err := h264.DecodeAnnexB(data.PES.Data)
+			if err != nil {
+				return err
+			}
+
+			for _, nalu := range nalus {
+				if len(nalu) == 0 {
+					continue
+				}
+
+				switch h264.NALUType(nalu[0] & 0x1F) {
+				case h264.NALUTypeSPS:
+					sps = append([]byte(nil), nalu...)
+
+				case h264.NALUTypePPS:
+					pps = append([]byte(nil), nalu...)
+				}
+			}
+
+		case r.audioPID:
+			if aacConfig != nil {
+				break
+			}
+
+			pkts, err := aac.DecodeADTS(data.PES.Data)
+			if err != nil {
+				return err
+			}
+
+			if len(pkts) != 0 {
+				aacConfig, err = aac.EncodeMPEG4AudioConfig(pkts[0].SampleRate, pkts[0].ChannelCount)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if (r.videoPID == 0 || (sps != nil && pps != nil)) &&
+			(r.audioPID == 0 || aacConfig != nil) {
+			break
+		}
+	}
+
+	// tracks whose parameters are not found are discarded
+	if sps != nil && pps != nil {
+		var err error
+		r.videoTrack, err = gortsplib.NewTrackH264(96, sps, pps)
+		if err != nil {
+			return err
+		}
+	} else {
+		r.videoPID = 0
+	}
+
+	if aacConfig != nil {
+		var err error
+		r.audioTrack, err = gortsplib.NewTrackAAC(97, aacConfig)
+		if err != nil {
+			return err
+		}
+	} else {
+		r.audioPID = 0
+	}
+
+	if r.videoTrack == nil && r.audioTrack == nil {
+		return fmt.Errorf("the file doesn't contain any H264 or AAC track")
+	}
+
+	return nil
+}
+
+func (r *Reader) findDuration() error {
+	offset := r.size - readerDurationProbeSize
+	if offset < 0 {
+		offset = 0
+	}
+	offset -= offset % packetSize
+
+	dem := r.demuxer(offset, r.size-offset)
+
+	for {
+		data, err := r.nextPES(dem)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		pts := r.relativePTS(data.PES.Header.OptionalHeader.PTS.Base)
+		if pts > r.duration {
+			r.duration = pts
+		}
+	}
+}
+
+// relativePTS converts a MPEG-TS timestamp into one relative to the
+// beginning of the file.
+func (r *Reader) relativePTS(v int64) time.Duration {
+	// timestamps are 33 bits long and wrap around
+	diff := (v - r.startPTS) & 0x1FFFFFFFF
+	if diff >= 0x100000000 {
+		diff -= 0x200000000
+	}
+	return time.Duration(diff) * time.Second / 90000
+}
+
+// isSyncPoint checks whether playback can start from a PES.
+func (r *Reader) isSyncPoint(data *astits.DemuxerData) bool {
+	if r.videoPID == 0 {
+		return true
+	}
+
+	if data.PID != r.videoPID {
+		return false
+	}
+
+	nalus, err := h264.DecodeAnnexB(data.PES.Data)
+	if err != nil {
+		return false
+	}
+
+	for _, nalu := range nalus {
+		if len(nalu) != 0 && h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// syncPointAfter returns the timestamp of the first sync point after a packet.
+func (r *Reader) syncPointAfter(packet int64) (time.Duration, bool, error) {
+	dem := r.demuxer(packet*packetSize, r.size-packet*packetSize)
+
+	for {
+		data, err := r.nextPES(dem)
+		if err != nil {
+			if err == io.EOF {
+				return 0, false, nil
+			}
+			return 0, false, err
+		}
+
+		if r.isSyncPoint(data) {
+			return r.relativePTS(data.PES.Header.OptionalHeader.PTS.Base), true, nil
+		}
+	}
+}
+
+// Seek moves the reading position to the last sync point before the given
+// position, or to the first sync point of the file. It returns the position
+// of the sync point.
+func (r *Reader) Seek(pos time.Duration) (time.Duration, error) {
+	// find with a binary search a packet that is followed by
+	// a sync point whose timestamp is not greater than pos.
+	lo := int64(0)
+	hi := r.size / packetSize
+
+	for (hi - lo) > readerSeekPrecision {
+		mid := (lo + hi) / 2
+
+		pts, ok, err := r.syncPointAfter(mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if ok && pts <= pos {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	// find the last sync point whose timestamp is not greater than pos
+	dem := r.demuxer(lo*packetSize, r.size-lo*packetSize)
+	found := false
+	var syncPTS time.Duration
+
+	for {
+		data, err := r.nextPES(dem)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, err
+		}
+
+		if !r.isSyncPoint(data) {
+			continue
+		}
+
+		pts := r.relativePTS(data.PES.Header.OptionalHeader.PTS.Base)
+		if found && pts > pos {
+			break
+		}
+
+		syncPTS = pts
+		found = true
+
+		if pts > pos {
+			break
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("no sync point found")
+	}
+
+	r.dem = r.demuxer(lo*packetSize, r.size-lo*packetSize)
+	r.synced = false
+	r.syncPTS = syncPTS
+
+	return syncPTS, nil
+}
+
+// Read reads the next frame. It returns io.EOF when the end of the file
+// is reached.
+func (r *Reader) Read() (*Frame, error) {
+	for {
+		data, err := r.nextPES(r.dem)
+		if err != nil {
+			return nil, err
+		}
+
+		pts := r.relativePTS(data.PES.Header.OptionalHeader.PTS.Base)
+
+		// skip frames until the sync point
+		if !r.synced {
+			if pts < r.syncPTS || !r.isSyncPoint(data) {
+				continue
+			}
+			r.synced = true
+		}
+
+		// skip audio frames that precede the sync point
+		if pts < r.syncPTS {
+			continue
+		}
+
+		if data.PID == r.videoPID {
+			nalus, err := h264.DecodeAnnexB(data.PES.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			var outNALUs [][]byte
+			idr := false
+			for _, nalu := range nalus {
+				if len(nalu) == 0 {
+					continue
+				}
+
+				// remove SPS, PPS and AUD, not needed by RTSP
+				switch h264.NALUType(nalu[0] & 0x1F) {
+				case h264.NALUTypeSPS, h264.NALUTypePPS, h264.NALUTypeAccessUnitDelimiter:
+					continue
+
+				case h264.NALUTypeIDR:
+					idr = true
+				}
+
+				outNALUs = append(outNALUs, nalu)
+			}
+
+			if len(outNALUs) == 0 {
+				continue
+			}
+
+			return &Frame{
+				Video:     true,
+				PTS:       pts,
+				SyncPoint: idr,
+				Data:      outNALUs,
+			}, nil
+		}
+
+		pkts, err := aac.DecodeADTS(data.PES.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(pkts) == 0 {
+			continue
+		}
+
+		aus := make([][]byte, len(pkts))
+		for i, pkt := range pkts {
+			aus[i] = pkt.Frame
+		}
+
+		return &Frame{
+			PTS:       pts,
+			SyncPoint: r.videoPID == 0,
+			Data:      aus,
+		}, nil
+	}
+}
@@ -0,0 +1,127 @@
+package mpegts
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x40}
+	testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+)
+
+func writeTestFile(t *testing.T) string {
+	videoTrack, err := gortsplib.NewTrackH264(96, testSPS, testPPS)
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{17, 144})
+	require.NoError(t, err)
+
+	f, err := ioutil.TempFile(os.TempDir(), "mpegts-")
+	require.NoError(t, err)
+	defer f.Close()
+
+	w, err := NewWriter(f, videoTrack, audioTrack)
+	require.NoError(t, err)
+
+	// 20 seconds, 10 frames per second, an IDR every second
+	for i := 0; i < 200; i++ {
+		pts := time.Duration(i) * 100 * time.Millisecond
+
+		if (i % 10) == 0 {
+			err = w.WriteH264(pts, [][]byte{
+				testSPS,
+				testPPS,
+				append([]byte{0x05}, bytes.Repeat([]byte{0x01}, 20000)...),
+			})
+		} else {
+			err = w.WriteH264(pts, [][]byte{
+				append([]byte{0x01}, bytes.Repeat([]byte{0x01}, 2000)...),
+			})
+		}
+		require.NoError(t, err)
+
+		err = w.WriteAAC(pts, [][]byte{{0x01, 0x02, 0x03, 0x04}})
+		require.NoError(t, err)
+	}
+
+	return f.Name()
+}
+
+func TestReader(t *testing.T) {
+	fpath := writeTestFile(t)
+	defer os.Remove(fpath)
+
+	r, err := OpenReader(fpath)
+	require.NoError(t, err)
+	defer r.Close()
+
+	videoTrack, audioTrack := r.Tracks()
+	require.NotNil(t, videoTrack)
+	require.NotNil(t, audioTrack)
+
+	sps, pps, err := videoTrack.ExtractDataH264()
+	require.NoError(t, err)
+	require.Equal(t, testSPS, sps)
+	require.Equal(t, testPPS, pps)
+
+	require.Equal(t, 19900*time.Millisecond, r.Duration().Round(time.Millisecond))
+
+	// reading starts from the first IDR, parameters are removed
+	fr, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, true, fr.Video)
+	require.Equal(t, time.Duration(0), fr.PTS.Round(time.Millisecond))
+	require.Equal(t, true, fr.SyncPoint)
+	require.Equal(t, 1, len(fr.Data))
+	require.Equal(t, byte(0x05), fr.Data[0][0])
+
+	fr, err = r.Read()
+	require.NoError(t, err)
+	require.Equal(t, false, fr.Video)
+	require.Equal(t, false, fr.SyncPoint)
+	require.Equal(t, [][]byte{{0x01, 0x02, 0x03, 0x04}}, fr.Data)
+
+	fr, err = r.Read()
+	require.NoError(t, err)
+	require.Equal(t, true, fr.Video)
+	require.Equal(t, false, fr.SyncPoint)
+
+	for _, ca := range []struct {
+		pos  time.Duration
+		sync time.Duration
+	}{
+		{4500 * time.Millisecond, 4 * time.Second},
+		{12 * time.Second, 12 * time.Second},
+		{0, 0},
+		{100 * time.Second, 19 * time.Second},
+	} {
+		sync, err := r.Seek(ca.pos)
+		require.NoError(t, err)
+		require.Equal(t, ca.sync, sync.Round(time.Millisecond))
+
+		fr, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, true, fr.Video)
+		require.Equal(t, ca.sync, fr.PTS.Round(time.Millisecond))
+		require.Equal(t, byte(0x05), fr.Data[0][0])
+	}
+
+	count := 0
+	for {
+		_, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		count++
+	}
+	require.Equal(t, 19, count) // 9 video frames + 10 audio frames
+}
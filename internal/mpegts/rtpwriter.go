@@ -0,0 +1,101 @@
+package mpegts
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	packetSize = 188
+
+	// 7 MPEG-TS packets fit into a standard Ethernet MTU.
+	rtpPacketsPerPayload = 7
+
+	// payload type of MPEG-TS, defined in RFC3551.
+	rtpPayloadType = 33
+
+	rtpClockRate = 90000
+)
+
+// RTPWriter is a io.Writer that packs MPEG-TS packets into RTP packets,
+// as described in RFC2250, and writes every RTP packet into a separate
+// call to the underlying writer.
+type RTPWriter struct {
+	w io.Writer
+
+	ssrc           uint32
+	sequenceNumber uint16
+	start          time.Time
+	buf            []byte
+}
+
+// NewRTPWriter allocates a RTPWriter.
+func NewRTPWriter(w io.Writer) *RTPWriter {
+	var tmp [6]byte
+	rand.Read(tmp[:])
+
+	return &RTPWriter{
+		w:              w,
+		ssrc:           binary.BigEndian.Uint32(tmp[:4]),
+		sequenceNumber: binary.BigEndian.Uint16(tmp[4:]),
+		start:          time.Now(),
+	}
+}
+
+// SSRC returns the SSRC of the RTP packets.
+func (w *RTPWriter) SSRC() uint32 {
+	return w.ssrc
+}
+
+// Write implements io.Writer. Data is buffered until there are enough
+// MPEG-TS packets to fill a RTP packet.
+func (w *RTPWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for len(w.buf) >= packetSize*rtpPacketsPerPayload {
+		err := w.writePacket(packetSize * rtpPacketsPerPayload)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes all the complete MPEG-TS packets that are buffered,
+// in order not to delay them until the next write.
+func (w *RTPWriter) Flush() error {
+	n := (len(w.buf) / packetSize) * packetSize
+	if n == 0 {
+		return nil
+	}
+	return w.writePacket(n)
+}
+
+func (w *RTPWriter) writePacket(n int) error {
+	pkt := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    rtpPayloadType,
+			SequenceNumber: w.sequenceNumber,
+			Timestamp:      uint32(time.Since(w.start).Seconds() * rtpClockRate),
+			SSRC:           w.ssrc,
+		},
+		Payload: w.buf[:n],
+	}
+	w.sequenceNumber++
+
+	byts, err := pkt.Marshal()
+	if err != nil {
+		return err
+	}
+
+	w.buf = w.buf[n:]
+
+	_, err = w.w.Write(byts)
+	return err
+}
@@ -0,0 +1,54 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+type testPacketWriter struct {
+	packets [][]byte
+}
+
+func (w *testPacketWriter) Write(p []byte) (int, error) {
+	w.packets = append(w.packets, p)
+	return len(p), nil
+}
+
+func TestRTPWriter(t *testing.T) {
+	pw := &testPacketWriter{}
+	w := NewRTPWriter(pw)
+
+	tsPackets := bytes.Repeat([]byte{0x47}, 10*188)
+
+	// writes in chunks with arbitrary sizes
+	_, err := w.Write(tsPackets[:100])
+	require.NoError(t, err)
+	_, err = w.Write(tsPackets[100:])
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pw.packets))
+
+	err = w.Flush()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(pw.packets))
+
+	// nothing to flush
+	err = w.Flush()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(pw.packets))
+
+	var pkt1 rtp.Packet
+	err = pkt1.Unmarshal(pw.packets[0])
+	require.NoError(t, err)
+	require.Equal(t, uint8(33), pkt1.PayloadType)
+	require.Equal(t, w.SSRC(), pkt1.SSRC)
+	require.Equal(t, 7*188, len(pkt1.Payload))
+
+	var pkt2 rtp.Packet
+	err = pkt2.Unmarshal(pw.packets[1])
+	require.NoError(t, err)
+	require.Equal(t, pkt1.SequenceNumber+1, pkt2.SequenceNumber)
+	require.Equal(t, 3*188, len(pkt2.Payload))
+}
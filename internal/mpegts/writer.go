@@ -0,0 +1,196 @@
+// Package mpegts contains a MPEG-TS writer, a MPEG-TS file reader and a MPEG-TS over RTP packetizer.
+package mpegts
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/asticode/go-astits"
+
+	"github.com/aler9/rtsp-simple-server/internal/aac"
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+const (
+	// an offset is needed to
+	// - avoid negative PTS values
+	// - avoid PTS < DTS during startup
+	ptsOffset = 2 * time.Second
+
+	videoPID = 256
+	audioPID = 257
+)
+
+// Writer writes H264 and AAC access units into a continuous MPEG-TS stream.
+type Writer struct {
+	videoTrack *gortsplib.Track
+	audioTrack *gortsplib.Track
+
+	aacConfig        rtpaac.MPEG4AudioConfig
+	mux              *astits.Muxer
+	startPCR         time.Time
+	videoDTSEst      *h264.DTSEstimator
+	firstIDRReceived bool
+}
+
+// NewWriter allocates a Writer.
+func NewWriter(
+	w io.Writer,
+	videoTrack *gortsplib.Track,
+	audioTrack *gortsplib.Track) (*Writer, error) {
+	var aacConfig rtpaac.MPEG4AudioConfig
+	if audioTrack != nil {
+		byts, err := audioTrack.ExtractDataAAC()
+		if err != nil {
+			return nil, err
+		}
+
+		err = aacConfig.Decode(byts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// tables are sent periodically by the muxer,
+	// in order to allow receivers to join at any time.
+	mux := astits.NewMuxer(context.Background(), w)
+
+	if videoTrack != nil {
+		mux.AddElementaryStream(astits.PMTElementaryStream{
+			ElementaryPID: videoPID,
+			StreamType:    astits.StreamTypeH264Video,
+		})
+	}
+
+	if audioTrack != nil {
+		mux.AddElementaryStream(astits.PMTElementaryStream{
+			ElementaryPID: audioPID,
+			StreamType:    astits.StreamTypeAACAudio,
+		})
+	}
+
+	if videoTrack != nil {
+		mux.SetPCRPID(videoPID)
+	} else {
+		mux.SetPCRPID(audioPID)
+	}
+
+	return &Writer{
+		videoTrack:  videoTrack,
+		audioTrack:  audioTrack,
+		aacConfig:   aacConfig,
+		mux:         mux,
+		startPCR:    time.Now(),
+		videoDTSEst: h264.NewDTSEstimator(),
+	}, nil
+}
+
+func (w *Writer) pcr() *astits.ClockReference {
+	return &astits.ClockReference{Base: int64(time.Since(w.startPCR).Seconds() * 90000)}
+}
+
+// WriteH264 writes H264 NALUs, grouped by PTS.
+func (w *Writer) WriteH264(pts time.Duration, nalus [][]byte) error {
+	idrPresent := false
+	for _, nalu := range nalus {
+		if h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeIDR {
+			idrPresent = true
+			break
+		}
+	}
+
+	// skip groups silently until we find one with a IDR
+	if !w.firstIDRReceived {
+		if !idrPresent {
+			return nil
+		}
+		w.firstIDRReceived = true
+	}
+
+	enc, err := h264.EncodeAnnexB(nalus)
+	if err != nil {
+		return err
+	}
+
+	pts += ptsOffset
+	dts := w.videoDTSEst.Feed(pts)
+
+	_, err = w.mux.WriteData(&astits.MuxerData{
+		PID: videoPID,
+		AdaptationField: &astits.PacketAdaptationField{
+			RandomAccessIndicator: idrPresent,
+			HasPCR:                true,
+			PCR:                   w.pcr(),
+		},
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorBothPresent,
+					DTS:             &astits.ClockReference{Base: int64(dts.Seconds() * 90000)},
+					PTS:             &astits.ClockReference{Base: int64(pts.Seconds() * 90000)},
+				},
+				StreamID: 224, // = video
+			},
+			Data: enc,
+		},
+	})
+	return err
+}
+
+// WriteAAC writes AAC AUs, grouped by PTS.
+func (w *Writer) WriteAAC(pts time.Duration, aus [][]byte) error {
+	// wait for the first IDR, in order to start with both tracks
+	if w.videoTrack != nil && !w.firstIDRReceived {
+		return nil
+	}
+
+	for i, au := range aus {
+		auPTS := pts + ptsOffset + time.Duration(i)*1000*time.Second/time.Duration(w.aacConfig.SampleRate)
+
+		adtsPkt, err := aac.EncodeADTS([]*aac.ADTSPacket{
+			{
+				SampleRate:   w.aacConfig.SampleRate,
+				ChannelCount: w.aacConfig.ChannelCount,
+				Frame:        au,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		af := &astits.PacketAdaptationField{
+			RandomAccessIndicator: true,
+		}
+
+		if w.videoTrack == nil {
+			af.HasPCR = true
+			af.PCR = w.pcr()
+		}
+
+		_, err = w.mux.WriteData(&astits.MuxerData{
+			PID:             audioPID,
+			AdaptationField: af,
+			PES: &astits.PESData{
+				Header: &astits.PESHeader{
+					OptionalHeader: &astits.PESOptionalHeader{
+						MarkerBits:      2,
+						PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+						PTS:             &astits.ClockReference{Base: int64(auPTS.Seconds() * 90000)},
+					},
+					PacketLength: uint16(len(adtsPkt) + 8),
+					StreamID:     192, // = audio
+				},
+				Data: adtsPkt,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
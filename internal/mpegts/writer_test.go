@@ -0,0 +1,64 @@
+package mpegts
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/asticode/go-astits"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, []byte{17, 144})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, videoTrack, audioTrack)
+	require.NoError(t, err)
+
+	// discarded, since the first IDR has not been received yet
+	err = w.WriteAAC(0, [][]byte{{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	// group without IDR, discarded
+	err = w.WriteH264(0, [][]byte{{0x01}})
+	require.NoError(t, err)
+
+	err = w.WriteH264(1*time.Second, [][]byte{{0x05, 0x01}})
+	require.NoError(t, err)
+
+	err = w.WriteAAC(1*time.Second, [][]byte{{0x01, 0x02, 0x03, 0x04}})
+	require.NoError(t, err)
+
+	require.Equal(t, 0, buf.Len()%188)
+
+	// tables are written before the IDR
+	pid := func(pkt []byte) uint16 {
+		return uint16(pkt[1]&0x1F)<<8 | uint16(pkt[2])
+	}
+	require.Equal(t, uint16(0), pid(buf.Bytes()[0:]))
+	require.Equal(t, uint16(0x1000), pid(buf.Bytes()[188:]))
+
+	dem := astits.NewDemuxer(context.Background(), &buf)
+
+	var pids []uint16
+	for {
+		data, err := dem.NextData()
+		if err == astits.ErrNoMorePackets {
+			break
+		}
+		require.NoError(t, err)
+
+		if data.PES != nil {
+			pids = append(pids, data.PID)
+		}
+	}
+
+	require.Equal(t, []uint16{videoPID, audioPID}, pids)
+}
@@ -0,0 +1,402 @@
+// Package oidc contains an OpenID Connect relying party.
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	requestTimeout    = 10 * time.Second
+	minKeysRefreshGap = 1 * time.Minute
+)
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+// Claims are the claims of a verified token.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Email     string   `json:"email"`
+}
+
+// Provider is an OpenID Connect provider.
+// The discovery document and the signing keys are fetched when needed,
+// in order not to prevent the server from starting when the provider is unreachable.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	hc           *http.Client
+
+	mutex     sync.Mutex
+	discovery *discoveryDocument
+	keys      map[string]crypto.PublicKey
+	keysTime  time.Time
+}
+
+// New allocates a Provider.
+func New(issuer string, clientID string, clientSecret string) *Provider {
+	return &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		hc:           &http.Client{Timeout: requestTimeout},
+	}
+}
+
+func (p *Provider) getJSON(u string, dest interface{}) error {
+	res, err := p.hc.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(dest)
+}
+
+// must be called with mutex locked.
+func (p *Provider) loadDiscovery() (*discoveryDocument, error) {
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var doc discoveryDocument
+	err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load discovery document: %s", err)
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("issuer mismatch: expected '%s', got '%s'", p.issuer, doc.Issuer)
+	}
+
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	byts, err := decodeSegment(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(byts), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+}
+
+// must be called with mutex locked.
+func (p *Provider) refreshKeys() error {
+	// prevent tokens with unknown key IDs from flooding the provider
+	if !p.keysTime.IsZero() && time.Since(p.keysTime) < minKeysRefreshGap {
+		return nil
+	}
+
+	doc, err := p.loadDiscovery()
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = p.getJSON(doc.JWKSURI, &set)
+	if err != nil {
+		return fmt.Errorf("unable to load keys: %s", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	p.keys = keys
+	p.keysTime = time.Now()
+	return nil
+}
+
+func (p *Provider) key(kid string) (crypto.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+
+	err := p.refreshKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("key '%s' not found", kid)
+}
+
+func verifySignature(alg string, pub crypto.PublicKey, signed []byte, sig []byte) error {
+	h := sha256.Sum256(signed)
+
+	switch alg {
+	case "RS256":
+		rpub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type doesn't match algorithm")
+		}
+		return rsa.VerifyPKCS1v15(rpub, crypto.SHA256, h[:], sig)
+
+	case "ES256":
+		epub, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type doesn't match algorithm")
+		}
+		if len(sig) != 64 {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(epub, h[:], r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported algorithm: %s", alg)
+}
+
+// Verify verifies a token signed by the provider and returns its claims.
+func (p *Provider) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	byts, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err = json.Unmarshal(byts, &header)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	pub, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifySignature(header.Alg, pub, []byte(parts[0]+"."+parts[1]), sig)
+	if err != nil {
+		return nil, err
+	}
+
+	byts, err = decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload")
+	}
+
+	var claims Claims
+	err = json.Unmarshal(byts, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload")
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("wrong issuer")
+	}
+
+	found := false
+	for _, a := range claims.Audience {
+		if a == p.clientID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("wrong audience")
+	}
+
+	now := time.Now().Unix()
+	if claims.Expiry == 0 || now >= claims.Expiry {
+		return nil, fmt.Errorf("token is expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+
+	return &claims, nil
+}
+
+// AuthCodeURL returns the URL of the login page of the provider.
+func (p *Provider) AuthCodeURL(redirectURL string, state string) (string, error) {
+	p.mutex.Lock()
+	doc, err := p.loadDiscovery()
+	p.mutex.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	v := u.Query()
+	v.Set("response_type", "code")
+	v.Set("client_id", p.clientID)
+	v.Set("redirect_uri", redirectURL)
+	v.Set("scope", "openid email")
+	v.Set("state", state)
+	u.RawQuery = v.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange exchanges an authorization code with an ID token, and verifies it.
+func (p *Provider) Exchange(redirectURL string, code string) (string, *Claims, error) {
+	p.mutex.Lock()
+	doc, err := p.loadDiscovery()
+	p.mutex.Unlock()
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := p.hc.PostForm(doc.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&tok)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if tok.IDToken == "" {
+		return "", nil, fmt.Errorf("ID token not provided")
+	}
+
+	claims, err := p.Verify(tok.IDToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return tok.IDToken, claims, nil
+}
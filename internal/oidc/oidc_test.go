@@ -0,0 +1,242 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testProvider struct {
+	srv    *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	code   string
+}
+
+func encodeSegment(byts []byte) string {
+	return base64.RawURLEncoding.EncodeToString(byts)
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tp := &testProvider{
+		rsaKey: rsaKey,
+		ecKey:  ecKey,
+		code:   "testcode",
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 tp.srv.URL,
+			"authorization_endpoint": tp.srv.URL + "/auth",
+			"token_endpoint":         tp.srv.URL + "/token",
+			"jwks_uri":               tp.srv.URL + "/keys",
+		})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "rsakey",
+					"n":   encodeSegment(rsaKey.N.Bytes()),
+					"e":   encodeSegment(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{
+					"kty": "EC",
+					"kid": "eckey",
+					"crv": "P-256",
+					"x":   encodeSegment(ecKey.X.FillBytes(make([]byte, 32))),
+					"y":   encodeSegment(ecKey.Y.FillBytes(make([]byte, 32))),
+				},
+			},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != tp.code || r.Form.Get("client_secret") != "testsecret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": tp.sign(t, "RS256", map[string]interface{}{
+				"iss": tp.srv.URL,
+				"sub": "testuser",
+				"aud": "testclient",
+				"exp": time.Now().Add(1 * time.Hour).Unix(),
+			}),
+		})
+	})
+
+	tp.srv = httptest.NewServer(mux)
+
+	return tp
+}
+
+func (tp *testProvider) sign(t *testing.T, alg string, claims map[string]interface{}) string {
+	kid := "rsakey"
+	if alg == "ES256" {
+		kid = "eckey"
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encodeSegment(header) + "." + encodeSegment(payload)
+	h := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	if alg == "ES256" {
+		r, s, err := ecdsa.Sign(rand.Reader, tp.ecKey, h[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	} else {
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, tp.rsaKey, crypto.SHA256, h[:])
+		require.NoError(t, err)
+	}
+
+	return signed + "." + encodeSegment(sig)
+}
+
+func TestVerify(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.srv.Close()
+
+	p := New(tp.srv.URL, "testclient", "testsecret")
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			claims, err := p.Verify(tp.sign(t, alg, map[string]interface{}{
+				"iss":   tp.srv.URL,
+				"sub":   "testuser",
+				"aud":   []string{"otherclient", "testclient"},
+				"exp":   time.Now().Add(1 * time.Hour).Unix(),
+				"email": "test@example.com",
+			}))
+			require.NoError(t, err)
+			require.Equal(t, "testuser", claims.Subject)
+			require.Equal(t, "test@example.com", claims.Email)
+		})
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.srv.Close()
+
+	p := New(tp.srv.URL, "testclient", "testsecret")
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": tp.srv.URL,
+			"sub": "testuser",
+			"aud": "testclient",
+			"exp": time.Now().Add(1 * time.Hour).Unix(),
+		}
+	}
+
+	for _, ca := range []struct {
+		name   string
+		token  func() string
+		errStr string
+	}{
+		{
+			"malformed",
+			func() string {
+				return "abc"
+			},
+			"malformed token",
+		},
+		{
+			"expired",
+			func() string {
+				c := valid()
+				c["exp"] = time.Now().Add(-1 * time.Hour).Unix()
+				return tp.sign(t, "RS256", c)
+			},
+			"token is expired",
+		},
+		{
+			"wrong issuer",
+			func() string {
+				c := valid()
+				c["iss"] = "http://other"
+				return tp.sign(t, "RS256", c)
+			},
+			"wrong issuer",
+		},
+		{
+			"wrong audience",
+			func() string {
+				c := valid()
+				c["aud"] = "otherclient"
+				return tp.sign(t, "RS256", c)
+			},
+			"wrong audience",
+		},
+		{
+			"tampered",
+			func() string {
+				parts := strings.Split(tp.sign(t, "RS256", valid()), ".")
+				c := valid()
+				c["sub"] = "admin"
+				payload, _ := json.Marshal(c)
+				return parts[0] + "." + encodeSegment(payload) + "." + parts[2]
+			},
+			"crypto/rsa: verification error",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := p.Verify(ca.token())
+			require.EqualError(t, err, ca.errStr)
+		})
+	}
+}
+
+func TestAuthCodeFlow(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.srv.Close()
+
+	p := New(tp.srv.URL, "testclient", "testsecret")
+
+	u, err := p.AuthCodeURL("http://localhost:8888/oidc/callback", "teststate")
+	require.NoError(t, err)
+
+	pu, err := url.Parse(u)
+	require.NoError(t, err)
+	require.Equal(t, "/auth", pu.Path)
+	require.Equal(t, "testclient", pu.Query().Get("client_id"))
+	require.Equal(t, "teststate", pu.Query().Get("state"))
+	require.Equal(t, "http://localhost:8888/oidc/callback", pu.Query().Get("redirect_uri"))
+
+	_, claims, err := p.Exchange("http://localhost:8888/oidc/callback", tp.code)
+	require.NoError(t, err)
+	require.Equal(t, "testuser", claims.Subject)
+
+	_, _, err = p.Exchange("http://localhost:8888/oidc/callback", "wrongcode")
+	require.EqualError(t, err, "bad status code: 400")
+}
@@ -0,0 +1,145 @@
+package onvif
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+var errNotProbe = fmt.Errorf("message is not a probe")
+
+// ProfileToken is the token of the only media profile exposed by a device.
+const ProfileToken = "profile0"
+
+// Action returns the name of the operation invoked by a SOAP request.
+func Action(byts []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(byts))
+	inBody := false
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return "", fmt.Errorf("body not found")
+			}
+			return "", err
+		}
+
+		if se, ok := tok.(xml.StartElement); ok {
+			if inBody {
+				return se.Name.Local, nil
+			}
+			if se.Name.Local == "Body" {
+				inBody = true
+			}
+		}
+	}
+}
+
+// Device is an emulated ONVIF device, that exposes a single stream.
+type Device struct {
+	Name            string
+	UUID            string
+	FirmwareVersion string
+	XAddr           string
+	StreamURI       string
+}
+
+// Response returns the response to an operation, or nil if the operation is
+// not supported.
+func (d Device) Response(action string, now time.Time) []byte {
+	switch action {
+	case "GetSystemDateAndTime":
+		now = now.UTC()
+		return envelope("",
+			`<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime>`+
+				`<tt:DateTimeType>NTP</tt:DateTimeType>`+
+				`<tt:DaylightSavings>false</tt:DaylightSavings>`+
+				`<tt:TimeZone><tt:TZ>UTC</tt:TZ></tt:TimeZone>`+
+				`<tt:UTCDateTime>`+
+				fmt.Sprintf(`<tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>`,
+					now.Hour(), now.Minute(), now.Second())+
+				fmt.Sprintf(`<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date>`,
+					now.Year(), now.Month(), now.Day())+
+				`</tt:UTCDateTime>`+
+				`</tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`)
+
+	case "GetDeviceInformation":
+		return envelope("",
+			`<tds:GetDeviceInformationResponse>`+
+				`<tds:Manufacturer>rtsp-simple-server</tds:Manufacturer>`+
+				`<tds:Model>`+escape(d.Name)+`</tds:Model>`+
+				`<tds:FirmwareVersion>`+escape(d.FirmwareVersion)+`</tds:FirmwareVersion>`+
+				`<tds:SerialNumber>`+escape(d.UUID)+`</tds:SerialNumber>`+
+				`<tds:HardwareId>rtsp-simple-server</tds:HardwareId>`+
+				`</tds:GetDeviceInformationResponse>`)
+
+	case "GetCapabilities":
+		return envelope("",
+			`<tds:GetCapabilitiesResponse><tds:Capabilities>`+
+				`<tt:Device><tt:XAddr>`+escape(d.XAddr)+`</tt:XAddr></tt:Device>`+
+				`<tt:Media><tt:XAddr>`+escape(d.XAddr)+`</tt:XAddr>`+
+				`<tt:StreamingCapabilities>`+
+				`<tt:RTPMulticast>false</tt:RTPMulticast>`+
+				`<tt:RTP_TCP>true</tt:RTP_TCP>`+
+				`<tt:RTP_RTSP_TCP>true</tt:RTP_RTSP_TCP>`+
+				`</tt:StreamingCapabilities>`+
+				`</tt:Media>`+
+				`</tds:Capabilities></tds:GetCapabilitiesResponse>`)
+
+	case "GetServices":
+		service := func(ns string) string {
+			return `<tds:Service>` +
+				`<tds:Namespace>` + ns + `</tds:Namespace>` +
+				`<tds:XAddr>` + escape(d.XAddr) + `</tds:XAddr>` +
+				`<tds:Version><tt:Major>2</tt:Major><tt:Minor>0</tt:Minor></tds:Version>` +
+				`</tds:Service>`
+		}
+		return envelope("",
+			`<tds:GetServicesResponse>`+
+				service(nsDevice)+
+				service(nsMedia)+
+				`</tds:GetServicesResponse>`)
+
+	case "GetProfiles":
+		return envelope("",
+			`<trt:GetProfilesResponse>`+
+				`<trt:Profiles token="`+ProfileToken+`" fixed="true">`+
+				`<tt:Name>`+escape(d.Name)+`</tt:Name>`+
+				`<tt:VideoSourceConfiguration token="source0">`+
+				`<tt:Name>source0</tt:Name>`+
+				`<tt:UseCount>1</tt:UseCount>`+
+				`<tt:SourceToken>source0</tt:SourceToken>`+
+				`<tt:Bounds x="0" y="0" width="1920" height="1080"/>`+
+				`</tt:VideoSourceConfiguration>`+
+				`<tt:VideoEncoderConfiguration token="encoder0">`+
+				`<tt:Name>encoder0</tt:Name>`+
+				`<tt:UseCount>1</tt:UseCount>`+
+				`<tt:Encoding>H264</tt:Encoding>`+
+				`</tt:VideoEncoderConfiguration>`+
+				`</trt:Profiles>`+
+				`</trt:GetProfilesResponse>`)
+
+	case "GetStreamUri":
+		return envelope("",
+			`<trt:GetStreamUriResponse><trt:MediaUri>`+
+				`<tt:Uri>`+escape(d.StreamURI)+`</tt:Uri>`+
+				`<tt:InvalidAfterConnect>false</tt:InvalidAfterConnect>`+
+				`<tt:InvalidAfterReboot>false</tt:InvalidAfterReboot>`+
+				`<tt:Timeout>PT0S</tt:Timeout>`+
+				`</trt:MediaUri></trt:GetStreamUriResponse>`)
+	}
+
+	return nil
+}
+
+// Fault returns a SOAP fault.
+func Fault(reason string) []byte {
+	return envelope("",
+		`<s:Fault>`+
+			`<s:Code><s:Value>s:Sender</s:Value></s:Code>`+
+			`<s:Reason><s:Text xml:lang="en">`+escape(reason)+`</s:Text></s:Reason>`+
+			`</s:Fault>`)
+}
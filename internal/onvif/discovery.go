@@ -0,0 +1,99 @@
+package onvif
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+)
+
+// Probe is a WS-Discovery Probe message.
+type Probe struct {
+	MessageID string
+	Types     []string
+}
+
+// Unmarshal decodes a Probe.
+func (p *Probe) Unmarshal(byts []byte) error {
+	var env struct {
+		XMLName xml.Name `xml:"Envelope"`
+		Header  struct {
+			MessageID string `xml:"MessageID"`
+			Action    string `xml:"Action"`
+		} `xml:"Header"`
+		Body struct {
+			Probe *struct {
+				Types string `xml:"Types"`
+			} `xml:"Probe"`
+		} `xml:"Body"`
+	}
+
+	err := xml.Unmarshal(byts, &env)
+	if err != nil {
+		return err
+	}
+
+	if env.Body.Probe == nil {
+		return errNotProbe
+	}
+
+	p.MessageID = strings.TrimSpace(env.Header.MessageID)
+	p.Types = nil
+
+	for _, typ := range strings.Fields(env.Body.Probe.Types) {
+		// remove namespace prefix
+		if i := strings.IndexByte(typ, ':'); i >= 0 {
+			typ = typ[i+1:]
+		}
+		p.Types = append(p.Types, typ)
+	}
+
+	return nil
+}
+
+// MatchesDevice checks whether the probe is looking for a video device.
+func (p *Probe) MatchesDevice() bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+
+	for _, typ := range p.Types {
+		if typ == "NetworkVideoTransmitter" || typ == "Device" {
+			return true
+		}
+	}
+	return false
+}
+
+// ProbeMatch is a WS-Discovery ProbeMatches message that contains a single device.
+type ProbeMatch struct {
+	MessageID  string
+	RelatesTo  string
+	DeviceUUID string
+	Name       string
+	XAddr      string
+}
+
+// Marshal encodes a ProbeMatch.
+func (m ProbeMatch) Marshal() []byte {
+	scopes := []string{
+		"onvif://www.onvif.org/type/video_encoder",
+		"onvif://www.onvif.org/Profile/Streaming",
+		"onvif://www.onvif.org/name/" + url.PathEscape(m.Name),
+		"onvif://www.onvif.org/hardware/rtsp-simple-server",
+	}
+
+	return envelope(
+		`<a:MessageID>uuid:`+escape(m.MessageID)+`</a:MessageID>`+
+			`<a:RelatesTo>`+escape(m.RelatesTo)+`</a:RelatesTo>`+
+			`<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>`+
+			`<a:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</a:Action>`,
+		`<d:ProbeMatches>`+
+			`<d:ProbeMatch>`+
+			`<a:EndpointReference><a:Address>urn:uuid:`+escape(m.DeviceUUID)+`</a:Address></a:EndpointReference>`+
+			`<d:Types>dn:NetworkVideoTransmitter</d:Types>`+
+			`<d:Scopes>`+escape(strings.Join(scopes, " "))+`</d:Scopes>`+
+			`<d:XAddrs>`+escape(m.XAddr)+`</d:XAddrs>`+
+			`<d:MetadataVersion>1</d:MetadataVersion>`+
+			`</d:ProbeMatch>`+
+			`</d:ProbeMatches>`)
+}
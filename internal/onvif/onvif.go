@@ -0,0 +1,49 @@
+// Package onvif contains utilities to emulate ONVIF devices, that are
+// WS-Discovery messages and a minimal set of SOAP messages of the device
+// and media services.
+package onvif
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+)
+
+// DiscoveryAddress is the multicast address of WS-Discovery.
+const DiscoveryAddress = "239.255.255.250:3702"
+
+const (
+	nsSOAP      = "http://www.w3.org/2003/05/soap-envelope"
+	nsAddress   = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+	nsDiscovery = "http://schemas.xmlsoap.org/ws/2005/04/discovery"
+	nsNetwork   = "http://www.onvif.org/ver10/network/wsdl"
+	nsDevice    = "http://www.onvif.org/ver10/device/wsdl"
+	nsMedia     = "http://www.onvif.org/ver10/media/wsdl"
+	nsSchema    = "http://www.onvif.org/ver10/schema"
+)
+
+// DeviceUUID returns an UUID that identifies a device, that is always the same
+// for a given name.
+func DeviceUUID(name string) string {
+	h := sha1.Sum([]byte(name))
+	h[6] = (h[6] & 0x0f) | 0x50 // version 5
+	h[8] = (h[8] & 0x3f) | 0x80 // RFC4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func envelope(header string, body string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="` + nsSOAP + `" xmlns:a="` + nsAddress + `" xmlns:d="` + nsDiscovery + `"` +
+		` xmlns:dn="` + nsNetwork + `" xmlns:tds="` + nsDevice + `" xmlns:trt="` + nsMedia + `"` +
+		` xmlns:tt="` + nsSchema + `">` +
+		`<s:Header>` + header + `</s:Header>` +
+		`<s:Body>` + body + `</s:Body>` +
+		`</s:Envelope>`)
+}
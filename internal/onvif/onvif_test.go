@@ -0,0 +1,124 @@
+package onvif
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceUUID(t *testing.T) {
+	require.Equal(t, DeviceUUID("mypath"), DeviceUUID("mypath"))
+	require.NotEqual(t, DeviceUUID("mypath"), DeviceUUID("otherpath"))
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, DeviceUUID("mypath"))
+}
+
+func TestProbeUnmarshal(t *testing.T) {
+	var p Probe
+	err := p.Unmarshal([]byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"` +
+		` xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+		`<e:Header>` +
+		`<w:MessageID>uuid:84ede3de-7dec-11d0-c360-f01234567890</w:MessageID>` +
+		`<w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>` +
+		`<w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action>` +
+		`</e:Header>` +
+		`<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body>` +
+		`</e:Envelope>`))
+	require.NoError(t, err)
+	require.Equal(t, Probe{
+		MessageID: "uuid:84ede3de-7dec-11d0-c360-f01234567890",
+		Types:     []string{"NetworkVideoTransmitter"},
+	}, p)
+	require.Equal(t, true, p.MatchesDevice())
+
+	require.Equal(t, false, (&Probe{Types: []string{"Printer"}}).MatchesDevice())
+	require.Equal(t, true, (&Probe{}).MatchesDevice())
+
+	err = p.Unmarshal([]byte(`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope">` +
+		`<e:Body><Hello/></e:Body></e:Envelope>`))
+	require.Equal(t, errNotProbe, err)
+}
+
+func TestProbeMatchMarshal(t *testing.T) {
+	byts := ProbeMatch{
+		MessageID:  "1234",
+		RelatesTo:  "uuid:5678",
+		DeviceUUID: "abcd",
+		Name:       "my path",
+		XAddr:      "http://192.168.1.2:8000/onvif/my%20path/device_service",
+	}.Marshal()
+
+	var env struct {
+		Header struct {
+			RelatesTo string `xml:"RelatesTo"`
+		} `xml:"Header"`
+		Body struct {
+			ProbeMatches struct {
+				ProbeMatch struct {
+					Address string `xml:"EndpointReference>Address"`
+					Scopes  string `xml:"Scopes"`
+					XAddrs  string `xml:"XAddrs"`
+				} `xml:"ProbeMatch"`
+			} `xml:"ProbeMatches"`
+		} `xml:"Body"`
+	}
+	err := xml.Unmarshal(byts, &env)
+	require.NoError(t, err)
+
+	require.Equal(t, "uuid:5678", env.Header.RelatesTo)
+	require.Equal(t, "urn:uuid:abcd", env.Body.ProbeMatches.ProbeMatch.Address)
+	require.Contains(t, env.Body.ProbeMatches.ProbeMatch.Scopes, "onvif://www.onvif.org/name/my%20path")
+	require.Equal(t, "http://192.168.1.2:8000/onvif/my%20path/device_service",
+		env.Body.ProbeMatches.ProbeMatch.XAddrs)
+}
+
+func TestAction(t *testing.T) {
+	action, err := Action([]byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		`<s:Header/>` +
+		`<s:Body><GetStreamUri xmlns="http://www.onvif.org/ver10/media/wsdl">` +
+		`<ProfileToken>profile0</ProfileToken></GetStreamUri></s:Body>` +
+		`</s:Envelope>`))
+	require.NoError(t, err)
+	require.Equal(t, "GetStreamUri", action)
+
+	_, err = Action([]byte(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"></s:Envelope>`))
+	require.Error(t, err)
+}
+
+func TestDeviceResponse(t *testing.T) {
+	d := Device{
+		Name:            "mypath",
+		UUID:            "abcd",
+		FirmwareVersion: "v1.0.0",
+		XAddr:           "http://192.168.1.2:8000/onvif/mypath/device_service",
+		StreamURI:       "rtsp://192.168.1.2:8554/mypath",
+	}
+
+	var env struct {
+		Body struct {
+			URI string `xml:"GetStreamUriResponse>MediaUri>Uri"`
+		} `xml:"Body"`
+	}
+	err := xml.Unmarshal(d.Response("GetStreamUri", time.Now()), &env)
+	require.NoError(t, err)
+	require.Equal(t, "rtsp://192.168.1.2:8554/mypath", env.Body.URI)
+
+	for _, action := range []string{
+		"GetSystemDateAndTime",
+		"GetDeviceInformation",
+		"GetCapabilities",
+		"GetServices",
+		"GetProfiles",
+	} {
+		byts := d.Response(action, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		require.NotNil(t, byts)
+		require.NoError(t, xml.Unmarshal(byts, new(struct{})))
+	}
+
+	require.Nil(t, d.Response("SetSystemFactoryDefault", time.Now()))
+}
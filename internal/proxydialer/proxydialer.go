@@ -0,0 +1,184 @@
+// Package proxydialer contains a dialer that connects through HTTP or SOCKS5 proxies
+// and from a given local address.
+package proxydialer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// DialContextFunc is a function that connects to an address.
+// It has the same signature of net.Dialer.DialContext.
+type DialContextFunc func(ctx context.Context, network string, address string) (net.Conn, error)
+
+// ResolveLocalIP returns the IP of a local address, that can be either an IP
+// or the name of a network interface. In the latter case, the first IPv4
+// address of the interface is returned, or the first IPv6 if there are none.
+func ResolveLocalIP(v string) (net.IP, error) {
+	if ip := net.ParseIP(v); ip != nil {
+		return ip, nil
+	}
+
+	intf, err := net.InterfaceByName(v)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is neither an IP nor a network interface", v)
+	}
+
+	addrs, err := intf.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+
+		if ret == nil {
+			ret = ipnet.IP
+		}
+	}
+
+	if ret == nil {
+		return nil, fmt.Errorf("network interface '%s' has no addresses", v)
+	}
+	return ret, nil
+}
+
+// New allocates a DialContextFunc that connects through the given proxy.
+// Supported URLs are http://[user:pass@]host:port and socks5://[user:pass@]host:port.
+// If the URL is empty, connections are direct.
+// If localIP is not nil, connections are established from it.
+func New(proxyURL string, localIP net.IP) (DialContextFunc, error) {
+	var direct net.Dialer
+	if localIP != nil {
+		direct.LocalAddr = &net.TCPAddr{IP: localIP}
+	}
+
+	if proxyURL == "" {
+		return direct.DialContext, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %s", err)
+	}
+
+	if u.Host == "" || u.Port() == "" {
+		return nil, fmt.Errorf("proxy URL must contain a host and a port")
+	}
+
+	switch u.Scheme {
+	case "http":
+		return func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return dialHTTP(ctx, &direct, u, network, address)
+		}, nil
+
+	case "socks5":
+		var auth *proxy.Auth
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			auth = &proxy.Auth{
+				User:     u.User.Username(),
+				Password: pass,
+			}
+		}
+
+		d, err := proxy.SOCKS5("tcp", u.Host, auth, &direct)
+		if err != nil {
+			return nil, err
+		}
+
+		return d.(proxy.ContextDialer).DialContext, nil
+	}
+
+	return nil, fmt.Errorf("unsupported proxy scheme '%s'", u.Scheme)
+}
+
+// bufConn is a net.Conn that reads from a buffer first.
+type bufConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+func dialHTTP(ctx context.Context, direct *net.Dialer,
+	u *url.URL, network string, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("HTTP proxies support TCP only")
+	}
+
+	nconn, err := direct.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// interrupt the handshake when the context is canceled
+	handshakeDone := make(chan struct{})
+	defer close(handshakeDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			nconn.Close()
+		case <-handshakeDone:
+		}
+	}()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+pass)))
+	}
+
+	err = req.Write(nconn)
+	if err != nil {
+		nconn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(nconn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		nconn.Close()
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		nconn.Close()
+		return nil, fmt.Errorf("proxy replied with code %d", res.StatusCode)
+	}
+
+	if ctx.Err() != nil {
+		nconn.Close()
+		return nil, ctx.Err()
+	}
+
+	if br.Buffered() > 0 {
+		return &bufConn{Conn: nconn, br: br}, nil
+	}
+	return nconn, nil
+}
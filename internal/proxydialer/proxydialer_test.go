@@ -0,0 +1,118 @@
+package proxydialer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		url  string
+		err  string
+	}{
+		{
+			"no port",
+			"http://myproxy",
+			"proxy URL must contain a host and a port",
+		},
+		{
+			"unsupported scheme",
+			"ftp://myproxy:21",
+			"unsupported proxy scheme 'ftp'",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := New(ca.url, nil)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer target.Close()
+
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	proxyLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer proxyLn.Close()
+
+	authHeader := make(chan string, 1)
+
+	go func() {
+		conn, err := proxyLn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		authHeader <- req.Header.Get("Proxy-Authorization")
+
+		tconn, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			return
+		}
+		defer tconn.Close()
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		go io.Copy(tconn, conn)
+		io.Copy(conn, tconn)
+	}()
+
+	dial, err := New("http://myuser:mypass@"+proxyLn.Addr().String(), net.ParseIP("127.0.0.1"))
+	require.NoError(t, err)
+
+	conn, err := dial(context.Background(), "tcp", target.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Equal(t, "Basic bXl1c2VyOm15cGFzcw==", <-authHeader)
+
+	_, err = conn.Write([]byte("testing"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 7)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("testing"), buf)
+}
+
+func TestResolveLocalIP(t *testing.T) {
+	ip, err := ResolveLocalIP("127.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", ip.String())
+
+	intfs, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, intf := range intfs {
+		if (intf.Flags & net.FlagLoopback) != 0 {
+			ip, err = ResolveLocalIP(intf.Name)
+			require.NoError(t, err)
+			require.Equal(t, true, ip.IsLoopback())
+		}
+	}
+
+	_, err = ResolveLocalIP("nonexisting")
+	require.EqualError(t, err, "'nonexisting' is neither an IP nor a network interface")
+}
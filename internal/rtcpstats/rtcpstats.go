@@ -0,0 +1,288 @@
+// Package rtcpstats contains utilities to collect statistics of RTP tracks
+// from RTP packets and RTCP reports.
+package rtcpstats
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtcp"
+)
+
+const (
+	// number of sender reports that are remembered in order to compute
+	// the round-trip time. Receiver reports refer to the last one.
+	senderReportsHistory = 8
+)
+
+// Stats are the statistics of a track.
+type Stats struct {
+	// RTP packets and bytes received. Available for received tracks only.
+	PacketsReceived uint64 `json:"packetsReceived"`
+	BytesReceived   uint64 `json:"bytesReceived"`
+
+	// number of RTP packets that have been lost.
+	PacketsLost uint64 `json:"packetsLost"`
+
+	// fraction of RTP packets that have been lost, from 0 to 1.
+	// For received tracks, it is computed since the beginning;
+	// for sent tracks, it is taken from the last receiver report.
+	FractionLost float64 `json:"fractionLost"`
+
+	// interarrival jitter, in seconds.
+	Jitter float64 `json:"jitter"`
+
+	// round-trip time, in seconds. Available for sent tracks only,
+	// after a receiver report has been received.
+	RTT *float64 `json:"rtt"`
+
+	// RTP packets and bytes declared by the last sender report.
+	SenderPackets uint32 `json:"senderPackets"`
+	SenderBytes   uint32 `json:"senderBytes"`
+}
+
+// Receiver collects the statistics of a received track.
+type Receiver struct {
+	clockRate float64
+
+	mutex         sync.Mutex
+	stats         Stats
+	started       bool
+	firstSeq      uint16
+	lastSeq       uint16
+	seqCycles     uint64
+	lastRTPTime   uint32
+	lastArrival   time.Time
+	jitterInTicks float64
+}
+
+// NewReceiver allocates a Receiver.
+func NewReceiver(clockRate int) *Receiver {
+	return &Receiver{
+		clockRate: float64(clockRate),
+	}
+}
+
+// ProcessFrame processes a RTP or RTCP packet received on the track.
+func (r *Receiver) ProcessFrame(ts time.Time, streamType gortsplib.StreamType, payload []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if streamType == gortsplib.StreamTypeRTCP {
+		pkts, err := rtcp.Unmarshal(payload)
+		if err != nil {
+			return
+		}
+
+		for _, pkt := range pkts {
+			if sr, ok := pkt.(*rtcp.SenderReport); ok {
+				r.stats.SenderPackets = sr.PacketCount
+				r.stats.SenderBytes = sr.OctetCount
+			}
+		}
+		return
+	}
+
+	if len(payload) < 12 {
+		return
+	}
+
+	r.stats.PacketsReceived++
+	r.stats.BytesReceived += uint64(len(payload))
+
+	seq := binary.BigEndian.Uint16(payload[2:4])
+	rtpTime := binary.BigEndian.Uint32(payload[4:8])
+
+	if !r.started {
+		r.started = true
+		r.firstSeq = seq
+		r.lastSeq = seq
+		r.lastRTPTime = rtpTime
+		r.lastArrival = ts
+		return
+	}
+
+	diff := int16(seq - r.lastSeq)
+
+	// ignore duplicate and reordered packets
+	if diff <= 0 {
+		return
+	}
+
+	if seq < r.lastSeq {
+		r.seqCycles++
+	}
+	r.lastSeq = seq
+
+	// https://tools.ietf.org/html/rfc3550#appendix-A.8
+	d := ts.Sub(r.lastArrival).Seconds()*r.clockRate - (float64(rtpTime) - float64(r.lastRTPTime))
+	if d < 0 {
+		d = -d
+	}
+	r.jitterInTicks += (d - r.jitterInTicks) / 16
+	r.lastRTPTime = rtpTime
+	r.lastArrival = ts
+}
+
+// Stats returns the statistics of the track.
+func (r *Receiver) Stats() Stats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := r.stats
+
+	if r.started {
+		expected := r.seqCycles<<16 + uint64(r.lastSeq) - uint64(r.firstSeq) + 1
+		if expected > stats.PacketsReceived {
+			stats.PacketsLost = expected - stats.PacketsReceived
+			stats.FractionLost = float64(stats.PacketsLost) / float64(expected)
+		}
+	}
+
+	if r.clockRate != 0 {
+		stats.Jitter = r.jitterInTicks / r.clockRate
+	}
+
+	return stats
+}
+
+type senderReport struct {
+	ntpMiddle uint32
+	sent      time.Time
+}
+
+// SenderReports remembers the sender reports that have been sent on a track,
+// that are needed to compute the round-trip time.
+type SenderReports struct {
+	mutex   sync.Mutex
+	reports []senderReport
+	packets uint32
+	bytes   uint32
+}
+
+// NewSenderReports allocates a SenderReports.
+func NewSenderReports() *SenderReports {
+	return &SenderReports{}
+}
+
+// ProcessFrame processes a RTP or RTCP packet sent on the track.
+func (s *SenderReports) ProcessFrame(ts time.Time, streamType gortsplib.StreamType, payload []byte) {
+	if streamType != gortsplib.StreamTypeRTCP {
+		return
+	}
+
+	pkts, err := rtcp.Unmarshal(payload)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, pkt := range pkts {
+		if sr, ok := pkt.(*rtcp.SenderReport); ok {
+			s.reports = append(s.reports, senderReport{
+				ntpMiddle: uint32(sr.NTPTime >> 16),
+				sent:      ts,
+			})
+			if len(s.reports) > senderReportsHistory {
+				s.reports = s.reports[1:]
+			}
+
+			s.packets = sr.PacketCount
+			s.bytes = sr.OctetCount
+		}
+	}
+}
+
+func (s *SenderReports) find(ntpMiddle uint32) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, r := range s.reports {
+		if r.ntpMiddle == ntpMiddle {
+			return r.sent, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (s *SenderReports) last() (uint32, uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.packets, s.bytes
+}
+
+// Sender collects the statistics of a sent track, by using the
+// receiver reports sent back by the receiver.
+type Sender struct {
+	clockRate float64
+	reports   *SenderReports
+
+	mutex sync.Mutex
+	stats Stats
+}
+
+// NewSender allocates a Sender.
+func NewSender(clockRate int, reports *SenderReports) *Sender {
+	return &Sender{
+		clockRate: float64(clockRate),
+		reports:   reports,
+	}
+}
+
+// ProcessFrame processes a RTP or RTCP packet received from the receiver.
+func (s *Sender) ProcessFrame(ts time.Time, streamType gortsplib.StreamType, payload []byte) {
+	if streamType != gortsplib.StreamTypeRTCP {
+		return
+	}
+
+	pkts, err := rtcp.Unmarshal(payload)
+	if err != nil {
+		return
+	}
+
+	for _, pkt := range pkts {
+		rr, ok := pkt.(*rtcp.ReceiverReport)
+		if !ok || len(rr.Reports) == 0 {
+			continue
+		}
+		report := rr.Reports[0]
+
+		var rtt *float64
+		if report.LastSenderReport != 0 {
+			if sent, ok := s.reports.find(report.LastSenderReport); ok {
+				// the delay is expressed in units of 1/65536 seconds
+				v := ts.Sub(sent).Seconds() - float64(report.Delay)/65536
+				if v < 0 {
+					v = 0
+				}
+				rtt = &v
+			}
+		}
+
+		s.mutex.Lock()
+		s.stats.PacketsLost = uint64(report.TotalLost)
+		s.stats.FractionLost = float64(report.FractionLost) / 256
+		if s.clockRate != 0 {
+			s.stats.Jitter = float64(report.Jitter) / s.clockRate
+		}
+		if rtt != nil {
+			s.stats.RTT = rtt
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// Stats returns the statistics of the track.
+func (s *Sender) Stats() Stats {
+	s.mutex.Lock()
+	stats := s.stats
+	s.mutex.Unlock()
+
+	stats.SenderPackets, stats.SenderBytes = s.reports.last()
+
+	return stats
+}
@@ -0,0 +1,101 @@
+package rtcpstats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func rtpPacket(seq uint16, ts uint32) []byte {
+	byts, _ := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: seq,
+			Timestamp:      ts,
+			SSRC:           0x11223344,
+		},
+		Payload: []byte{0x01, 0x02, 0x03, 0x04},
+	}).Marshal()
+	return byts
+}
+
+func TestReceiver(t *testing.T) {
+	r := NewReceiver(90000)
+	now := time.Date(2008, 5, 20, 22, 15, 20, 0, time.UTC)
+
+	// a packet every 100ms; the packet with seq 65535 is lost.
+	// the last packet arrives 10ms late.
+	r.ProcessFrame(now, gortsplib.StreamTypeRTP, rtpPacket(65533, 0))
+	r.ProcessFrame(now.Add(100*time.Millisecond), gortsplib.StreamTypeRTP, rtpPacket(65534, 9000))
+	r.ProcessFrame(now.Add(300*time.Millisecond), gortsplib.StreamTypeRTP, rtpPacket(0, 27000))
+	r.ProcessFrame(now.Add(410*time.Millisecond), gortsplib.StreamTypeRTP, rtpPacket(1, 36000))
+
+	sr, _ := (&rtcp.SenderReport{
+		SSRC:        0x11223344,
+		PacketCount: 4,
+		OctetCount:  16,
+	}).Marshal()
+	r.ProcessFrame(now.Add(500*time.Millisecond), gortsplib.StreamTypeRTCP, sr)
+
+	stats := r.Stats()
+	require.Equal(t, uint64(4), stats.PacketsReceived)
+	require.Equal(t, uint64(64), stats.BytesReceived)
+	require.Equal(t, uint64(1), stats.PacketsLost)
+	require.Equal(t, 0.2, stats.FractionLost)
+	require.Equal(t, uint32(4), stats.SenderPackets)
+	require.Equal(t, uint32(16), stats.SenderBytes)
+	require.InDelta(t, 0.01/16, stats.Jitter, 0.00001)
+	require.Nil(t, stats.RTT)
+
+	r = NewReceiver(90000)
+	r.ProcessFrame(now, gortsplib.StreamTypeRTP, rtpPacket(10, 0))
+	r.ProcessFrame(now, gortsplib.StreamTypeRTP, rtpPacket(13, 0))
+
+	stats = r.Stats()
+	require.Equal(t, uint64(2), stats.PacketsLost)
+	require.Equal(t, 0.5, stats.FractionLost)
+}
+
+func TestSender(t *testing.T) {
+	reports := NewSenderReports()
+	s := NewSender(90000, reports)
+	now := time.Date(2008, 5, 20, 22, 15, 20, 0, time.UTC)
+
+	sr, _ := (&rtcp.SenderReport{
+		SSRC:        0x11223344,
+		NTPTime:     0xcbddcc34_99999999,
+		PacketCount: 100,
+		OctetCount:  20000,
+	}).Marshal()
+	reports.ProcessFrame(now, gortsplib.StreamTypeRTCP, sr)
+
+	stats := s.Stats()
+	require.Nil(t, stats.RTT)
+	require.Equal(t, uint32(100), stats.SenderPackets)
+	require.Equal(t, uint32(20000), stats.SenderBytes)
+
+	rr, _ := (&rtcp.ReceiverReport{
+		SSRC: 0x55667788,
+		Reports: []rtcp.ReceptionReport{{
+			SSRC:             0x11223344,
+			FractionLost:     64,
+			TotalLost:        12,
+			LastSenderReport: 0xcc349999,
+			Delay:            65536 / 2,
+			Jitter:           900,
+		}},
+	}).Marshal()
+	s.ProcessFrame(now.Add(700*time.Millisecond), gortsplib.StreamTypeRTCP, rr)
+
+	stats = s.Stats()
+	require.Equal(t, uint64(12), stats.PacketsLost)
+	require.Equal(t, 0.25, stats.FractionLost)
+	require.InDelta(t, 0.01, stats.Jitter, 0.00001)
+	require.NotNil(t, stats.RTT)
+	require.InDelta(t, 0.2, *stats.RTT, 0.00001)
+}
@@ -9,8 +9,10 @@ import (
 	"github.com/notedit/rtmp/format/rtmp"
 )
 
-// DialContext connects to a server in reading mode.
-func DialContext(ctx context.Context, address string) (*Conn, error) {
+// DialContext connects to a server.
+// The connection is established with dialContext; if nil, a net.Dialer is used.
+func DialContext(ctx context.Context, address string,
+	dialContext func(context.Context, string, string) (net.Conn, error)) (*Conn, error) {
 	// https://github.com/aler9/rtmp/blob/3be4a55359274dcd88762e72aa0a702e2d8ba2fd/format/rtmp/client.go#L74
 
 	u, err := url.Parse(address)
@@ -19,8 +21,11 @@ func DialContext(ctx context.Context, address string) (*Conn, error) {
 	}
 	host := rtmp.UrlGetHost(u)
 
-	var d net.Dialer
-	nconn, err := d.DialContext(ctx, "tcp", host)
+	if dialContext == nil {
+		dialContext = (&net.Dialer{}).DialContext
+	}
+
+	nconn, err := dialContext(ctx, "tcp", host)
 	if err != nil {
 		return nil, err
 	}
@@ -42,3 +47,9 @@ func DialContext(ctx context.Context, address string) (*Conn, error) {
 func (c *Conn) ClientHandshake() error {
 	return c.rconn.Prepare(rtmp.StageGotPublishOrPlayCommand, rtmp.PrepareReading)
 }
+
+// ClientHandshakePublish performs the handshake of a client-side connection
+// that is used to publish.
+func (c *Conn) ClientHandshakePublish() error {
+	return c.rconn.Prepare(rtmp.StageGotPublishOrPlayCommand, rtmp.PrepareWriting)
+}
@@ -1,17 +1,53 @@
 package rtmp
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"net"
 	"net/url"
 
 	"github.com/notedit/rtmp/av"
+	"github.com/notedit/rtmp/format/flv"
+	"github.com/notedit/rtmp/format/flv/flvio"
 	"github.com/notedit/rtmp/format/rtmp"
 )
 
+// packet types that are not provided by the av package.
+// They are produced by the Enhanced RTMP extensions and by the legacy
+// H265 codec ID.
+const (
+	H265DecoderConfig = 100 + iota
+	H265
+	AV1DecoderConfig
+	AV1
+)
+
+const (
+	// enhanced video tags have this bit set in the frame type
+	videoExHeader = 0x08
+
+	videoPacketTypeSequenceStart = 0
+	videoPacketTypeCodedFrames   = 1
+	videoPacketTypeCodedFramesX  = 3
+
+	fourCCHEVC = "hvc1"
+	fourCCAV1  = "av01"
+)
+
+// returned by the tag reader when a tag doesn't produce a packet.
+var errNoPacket = errors.New("no packet")
+
 // Conn is a RTMP connection.
 type Conn struct {
 	rconn *rtmp.Conn
 	nconn net.Conn
+
+	// packet read in advance, that is returned by the next ReadPacket()
+	pending *av.Packet
+
+	// metadata sent by the publisher, filled by ReadMetadata()
+	metadata map[string]string
 }
 
 // NetConn returns the underlying net.Conn.
@@ -31,12 +67,186 @@ func (c *Conn) URL() *url.URL {
 
 // ReadPacket reads a packet.
 func (c *Conn) ReadPacket() (av.Packet, error) {
-	return c.rconn.ReadPacket()
+	if c.pending != nil {
+		pkt := *c.pending
+		c.pending = nil
+		return pkt, nil
+	}
+
+	err := c.rconn.Prepare(rtmp.StageCommandDone, rtmp.PrepareReading)
+	if err != nil {
+		return av.Packet{}, err
+	}
+
+	for {
+		tag, err := c.rconn.ReadTag()
+		if err != nil {
+			return av.Packet{}, err
+		}
+
+		if tag.Type == flvio.TAG_VIDEO &&
+			((tag.FrameType&videoExHeader) != 0 || tag.VideoFormat == flvio.VIDEO_H265) {
+			pkt, err := packetFromVideoTag(tag)
+			if err == errNoPacket {
+				continue
+			}
+			return pkt, err
+		}
+
+		// other tags are decoded by the flv package, one at a time,
+		// in order to intercept the ones that don't produce a packet.
+		read := false
+		pkt, err := flv.ReadPacket(func() (flvio.Tag, error) {
+			if read {
+				return flvio.Tag{}, errNoPacket
+			}
+			read = true
+			return tag, nil
+		})
+		if err == errNoPacket {
+			continue
+		}
+		return pkt, err
+	}
+}
+
+// packetFromVideoTag decodes a video tag that uses the Enhanced RTMP
+// extensions or the legacy H265 codec ID.
+func packetFromVideoTag(tag flvio.Tag) (av.Packet, error) {
+	if (tag.FrameType & videoExHeader) == 0 {
+		switch tag.AVCPacketType {
+		case flvio.AVC_SEQHDR:
+			return av.Packet{
+				Type: H265DecoderConfig,
+				Data: tag.Data,
+			}, nil
+
+		case flvio.AVC_NALU:
+			return av.Packet{
+				Type:       H265,
+				Data:       tag.Data,
+				Time:       flvio.TsToTime(int64(tag.Time)),
+				CTime:      flvio.TsToTime(int64(tag.CTime)),
+				IsKeyFrame: tag.FrameType == flvio.FRAME_KEY,
+			}, nil
+		}
+
+		return av.Packet{}, errNoPacket
+	}
+
+	// in enhanced tags, the codec ID is replaced by the packet type
+	packetType := tag.VideoFormat
+	frameType := tag.FrameType &^ videoExHeader
+
+	switch packetType {
+	case videoPacketTypeSequenceStart, videoPacketTypeCodedFrames, videoPacketTypeCodedFramesX:
+	default:
+		return av.Packet{}, errNoPacket
+	}
+
+	if len(tag.Data) < 4 {
+		return av.Packet{}, fmt.Errorf("invalid video tag")
+	}
+
+	fourCC := string(tag.Data[:4])
+	data := tag.Data[4:]
+
+	var configType int
+	var dataType int
+	switch fourCC {
+	case fourCCHEVC:
+		configType = H265DecoderConfig
+		dataType = H265
+
+	case fourCCAV1:
+		configType = AV1DecoderConfig
+		dataType = AV1
+
+	default:
+		return av.Packet{}, fmt.Errorf("unsupported video codec %s", fourCC)
+	}
+
+	if packetType == videoPacketTypeSequenceStart {
+		return av.Packet{
+			Type: configType,
+			Data: data,
+		}, nil
+	}
+
+	var cts int32
+	// AV1 frames never have a composition time
+	if packetType == videoPacketTypeCodedFrames && fourCC != fourCCAV1 {
+		if len(data) < 3 {
+			return av.Packet{}, fmt.Errorf("invalid video tag")
+		}
+		cts = int32(uint32(data[0])<<16|uint32(data[1])<<8|uint32(data[2])) << 8 >> 8
+		data = data[3:]
+	}
+
+	return av.Packet{
+		Type:       dataType,
+		Data:       data,
+		Time:       flvio.TsToTime(int64(tag.Time)),
+		CTime:      flvio.TsToTime(int64(cts)),
+		IsKeyFrame: frameType == flvio.FRAME_KEY,
+	}, nil
+}
+
+// videoTagFromPacket encodes a packet of a type that is not provided by the av
+// package into an enhanced video tag.
+func videoTagFromPacket(pkt av.Packet) flvio.Tag {
+	var fourCC string
+	switch pkt.Type {
+	case H265DecoderConfig, H265:
+		fourCC = fourCCHEVC
+	default:
+		fourCC = fourCCAV1
+	}
+
+	frameType := uint8(flvio.FRAME_INTER)
+	if pkt.IsKeyFrame || pkt.Type == H265DecoderConfig || pkt.Type == AV1DecoderConfig {
+		frameType = flvio.FRAME_KEY
+	}
+
+	var packetType uint8
+	data := []byte(fourCC)
+
+	switch pkt.Type {
+	case H265DecoderConfig, AV1DecoderConfig:
+		packetType = videoPacketTypeSequenceStart
+
+	case H265:
+		packetType = videoPacketTypeCodedFrames
+		var cts [4]byte
+		binary.BigEndian.PutUint32(cts[:], uint32(flvio.TimeToTs(pkt.CTime)))
+		data = append(data, cts[1:]...)
+
+	default:
+		packetType = videoPacketTypeCodedFramesX
+	}
+
+	return flvio.Tag{
+		Type:        flvio.TAG_VIDEO,
+		FrameType:   videoExHeader | frameType,
+		VideoFormat: packetType,
+		Data:        append(data, pkt.Data...),
+		Time:        uint32(flvio.TimeToTs(pkt.Time)),
+	}
 }
 
 // WritePacket writes a packet.
 func (c *Conn) WritePacket(pkt av.Packet) error {
-	err := c.rconn.WritePacket(pkt)
+	var err error
+	switch pkt.Type {
+	case H265DecoderConfig, H265, AV1DecoderConfig, AV1:
+		err = c.rconn.Prepare(rtmp.StageDataStart, rtmp.PrepareWriting)
+		if err == nil {
+			err = c.rconn.WriteTag(videoTagFromPacket(pkt))
+		}
+
+	default:
+		err = c.rconn.WritePacket(pkt)
+	}
 	if err != nil {
 		return err
 	}
@@ -1,20 +1,163 @@
 package rtmp
 
 import (
+	"encoding/binary"
 	"fmt"
+	"strconv"
 
 	"github.com/aler9/gortsplib"
 	"github.com/notedit/rtmp/av"
 	nh264 "github.com/notedit/rtmp/codec/h264"
 	"github.com/notedit/rtmp/format/flv/flvio"
+
+	"github.com/aler9/rtsp-simple-server/internal/av1"
+	"github.com/aler9/rtsp-simple-server/internal/h265"
 )
 
 const (
 	codecH264 = 7
 	codecAAC  = 10
+	codecH265 = 12
+
+	// FourCCs of the Enhanced RTMP extensions, written as numbers
+	codecHEVCFourCC = 0x68766331 // hvc1
+	codecAV1FourCC  = 0x61763031 // av01
 )
 
+// h265ParamsFromDecoderConfig extracts the VPS, SPS and PPS from a
+// HEVCDecoderConfigurationRecord.
+func h265ParamsFromDecoderConfig(byts []byte) ([]byte, []byte, []byte, error) {
+	if len(byts) < 23 {
+		return nil, nil, nil, fmt.Errorf("invalid H265 decoder configuration")
+	}
+
+	var vps []byte
+	var sps []byte
+	var pps []byte
+
+	arrayCount := int(byts[22])
+	pos := 23
+
+	for i := 0; i < arrayCount; i++ {
+		if len(byts) < pos+3 {
+			return nil, nil, nil, fmt.Errorf("invalid H265 decoder configuration")
+		}
+
+		typ := h265.NALUType(byts[pos] & 0x3F)
+		naluCount := int(binary.BigEndian.Uint16(byts[pos+1:]))
+		pos += 3
+
+		for j := 0; j < naluCount; j++ {
+			if len(byts) < pos+2 {
+				return nil, nil, nil, fmt.Errorf("invalid H265 decoder configuration")
+			}
+
+			l := int(binary.BigEndian.Uint16(byts[pos:]))
+			pos += 2
+
+			if len(byts) < pos+l {
+				return nil, nil, nil, fmt.Errorf("invalid H265 decoder configuration")
+			}
+
+			nalu := byts[pos : pos+l]
+			pos += l
+
+			// only the first parameter set of each kind is used
+			switch {
+			case typ == h265.NALUTypeVPS && vps == nil:
+				vps = nalu
+			case typ == h265.NALUTypeSPS && sps == nil:
+				sps = nalu
+			case typ == h265.NALUTypePPS && pps == nil:
+				pps = nalu
+			}
+		}
+	}
+
+	if vps == nil || sps == nil || pps == nil {
+		return nil, nil, nil, fmt.Errorf("H265 decoder configuration is missing VPS, SPS or PPS")
+	}
+
+	return vps, sps, pps, nil
+}
+
+// IsVideoDecoderConfig checks whether a packet type is the one of a video
+// decoder configuration.
+func IsVideoDecoderConfig(typ int) bool {
+	return typ == av.H264DecoderConfig || typ == H265DecoderConfig || typ == AV1DecoderConfig
+}
+
+// TrackFromDecoderConfig returns the track described by a decoder configuration
+// packet (av.H264DecoderConfig, H265DecoderConfig, AV1DecoderConfig or
+// av.AACDecoderConfig).
+func TrackFromDecoderConfig(pkt av.Packet) (*gortsplib.Track, error) {
+	switch pkt.Type {
+	case av.H264DecoderConfig:
+		codec, err := nh264.FromDecoderConfig(pkt.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(codec.SPS) == 0 || len(codec.PPS) == 0 {
+			return nil, fmt.Errorf("H264 decoder configuration is missing SPS or PPS")
+		}
+
+		return gortsplib.NewTrackH264(96, codec.SPS[0], codec.PPS[0])
+
+	case H265DecoderConfig:
+		vps, sps, pps, err := h265ParamsFromDecoderConfig(pkt.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		return h265.NewTrack(96, vps, sps, pps), nil
+
+	case AV1DecoderConfig:
+		// AV1CodecConfigurationRecord starts with marker and version
+		if len(pkt.Data) < 4 || pkt.Data[0] != 0x81 {
+			return nil, fmt.Errorf("invalid AV1 decoder configuration")
+		}
+
+		return av1.NewTrack(96), nil
+
+	case av.AACDecoderConfig:
+		return gortsplib.NewTrackAAC(96, pkt.Data)
+	}
+
+	return nil, fmt.Errorf("packet is not a decoder configuration")
+}
+
+// metadataValues returns the scalar values of a metadata map as strings.
+// Nested objects and arrays are discarded.
+func metadataValues(md flvio.AMFMap) map[string]string {
+	ret := make(map[string]string)
+
+	for _, kv := range md {
+		switch v := kv.V.(type) {
+		case string:
+			ret[kv.K] = v
+
+		case float64:
+			ret[kv.K] = strconv.FormatFloat(v, 'f', -1, 64)
+
+		case bool:
+			ret[kv.K] = strconv.FormatBool(v)
+		}
+	}
+
+	return ret
+}
+
+// Metadata returns the metadata sent by the publisher in onMetaData,
+// like the resolution, the name of the encoder or custom fields.
+// It is available after ReadMetadata().
+func (c *Conn) Metadata() map[string]string {
+	return c.metadata
+}
+
 // ReadMetadata extracts track informations from a connection that is publishing.
+// If a track declared in metadata is not configured before the first media
+// packet, it is ignored; it can be added later with TrackFromDecoderConfig().
 func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
 	var videoTrack *gortsplib.Track
 	var audioTrack *gortsplib.Track
@@ -49,6 +192,8 @@ func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
 		return nil, nil, err
 	}
 
+	c.metadata = metadataValues(md)
+
 	hasVideo, err := func() (bool, error) {
 		v, ok := md.GetV("videocodecid")
 		if !ok {
@@ -61,12 +206,13 @@ func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
 			case 0:
 				return false, nil
 
-			case codecH264:
+			case codecH264, codecH265, codecHEVCFourCC, codecAV1FourCC:
 				return true, nil
 			}
 
 		case string:
-			if vt == "avc1" {
+			switch vt {
+			case "avc1", "hvc1", "av01":
 				return true, nil
 			}
 		}
@@ -117,7 +263,7 @@ func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
 		}
 
 		switch pkt.Type {
-		case av.H264DecoderConfig:
+		case av.H264DecoderConfig, H265DecoderConfig, AV1DecoderConfig:
 			if !hasVideo {
 				return nil, nil, fmt.Errorf("unexpected video packet")
 			}
@@ -125,12 +271,7 @@ func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
 				return nil, nil, fmt.Errorf("video track setupped twice")
 			}
 
-			codec, err := nh264.FromDecoderConfig(pkt.Data)
-			if err != nil {
-				return nil, nil, err
-			}
-
-			videoTrack, err = gortsplib.NewTrackH264(96, codec.SPS[0], codec.PPS[0])
+			videoTrack, err = TrackFromDecoderConfig(pkt)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -143,10 +284,18 @@ func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
 				return nil, nil, fmt.Errorf("audio track setupped twice")
 			}
 
-			audioTrack, err = gortsplib.NewTrackAAC(96, pkt.Data)
+			audioTrack, err = TrackFromDecoderConfig(pkt)
 			if err != nil {
 				return nil, nil, err
 			}
+
+		case av.H264, H265, AV1, av.AAC:
+			// a track is late, return the available ones and
+			// keep the packet for the next ReadPacket()
+			if videoTrack != nil || audioTrack != nil {
+				c.pending = &pkt
+				return videoTrack, audioTrack, nil
+			}
 		}
 
 		if (!hasVideo || videoTrack != nil) &&
@@ -157,37 +306,52 @@ func (c *Conn) ReadMetadata() (*gortsplib.Track, *gortsplib.Track, error) {
 }
 
 // WriteMetadata writes track informations to a connection that is reading.
-func (c *Conn) WriteMetadata(videoTrack *gortsplib.Track, audioTrack *gortsplib.Track) error {
+// title and description are optional and are shown by players.
+func (c *Conn) WriteMetadata(
+	videoTrack *gortsplib.Track,
+	audioTrack *gortsplib.Track,
+	title string,
+	description string) error {
+	md := flvio.AMFMap{
+		{
+			K: "videodatarate",
+			V: float64(0),
+		},
+		{
+			K: "videocodecid",
+			V: func() float64 {
+				if videoTrack != nil {
+					return codecH264
+				}
+				return 0
+			}(),
+		},
+		{
+			K: "audiodatarate",
+			V: float64(0),
+		},
+		{
+			K: "audiocodecid",
+			V: func() float64 {
+				if audioTrack != nil {
+					return codecAAC
+				}
+				return 0
+			}(),
+		},
+	}
+
+	if title != "" {
+		md = append(md, flvio.AMFKv{K: "title", V: title})
+	}
+
+	if description != "" {
+		md = append(md, flvio.AMFKv{K: "description", V: description})
+	}
+
 	err := c.WritePacket(av.Packet{
 		Type: av.Metadata,
-		Data: flvio.FillAMF0ValMalloc(flvio.AMFMap{
-			{
-				K: "videodatarate",
-				V: float64(0),
-			},
-			{
-				K: "videocodecid",
-				V: func() float64 {
-					if videoTrack != nil {
-						return codecH264
-					}
-					return 0
-				}(),
-			},
-			{
-				K: "audiodatarate",
-				V: float64(0),
-			},
-			{
-				K: "audiocodecid",
-				V: func() float64 {
-					if audioTrack != nil {
-						return codecAAC
-					}
-					return 0
-				}(),
-			},
-		}),
+		Data: flvio.FillAMF0ValMalloc(md),
 	})
 	if err != nil {
 		return err
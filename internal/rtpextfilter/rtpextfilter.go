@@ -0,0 +1,180 @@
+// Package rtpextfilter contains a RTP header extension filter.
+package rtpextfilter
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+const (
+	rtpHeaderSize = 12
+
+	profileOneByte = 0xBEDE
+	profileTwoByte = 0x1000
+)
+
+// Filter removes from RTP packets the header extension elements (RFC 8285)
+// whose IDs are not allowed.
+type Filter struct {
+	allowed map[uint8]struct{}
+}
+
+// New allocates a Filter that keeps only the elements with the given IDs.
+func New(ids []int) *Filter {
+	f := &Filter{
+		allowed: make(map[uint8]struct{}),
+	}
+
+	for _, id := range ids {
+		f.allowed[uint8(id)] = struct{}{}
+	}
+
+	return f
+}
+
+// AllowsSDPAttribute checks whether a SDP attribute can be passed to readers.
+// extmap attributes are allowed only when their ID is allowed.
+func (f *Filter) AllowsSDPAttribute(key string, value string) bool {
+	if key != "extmap" {
+		return true
+	}
+
+	i := strings.IndexAny(value, "/ ")
+	if i >= 0 {
+		value = value[:i]
+	}
+
+	id, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return false
+	}
+
+	_, ok := f.allowed[uint8(id)]
+	return ok
+}
+
+// Process returns the packet without the elements that are not allowed.
+// Header extensions that don't follow RFC 8285 are removed entirely.
+// Malformed packets are returned untouched.
+func (f *Filter) Process(payload []byte) []byte {
+	l := len(payload)
+	if l < rtpHeaderSize || (payload[0]&0x10) == 0 {
+		return payload
+	}
+
+	n := rtpHeaderSize + 4*int(payload[0]&0x0F)
+	if l < n+4 {
+		return payload
+	}
+
+	profile := binary.BigEndian.Uint16(payload[n:])
+	extLen := 4 * int(binary.BigEndian.Uint16(payload[n+2:]))
+	if l < n+4+extLen {
+		return payload
+	}
+	ext := payload[n+4 : n+4+extLen]
+
+	var elems []byte
+	var ok bool
+
+	switch {
+	case profile == profileOneByte:
+		elems, ok = f.filterOneByte(ext)
+
+	case (profile & 0xFFF0) == profileTwoByte:
+		elems, ok = f.filterTwoByte(ext)
+
+	default:
+		ok = true
+	}
+
+	if !ok {
+		return payload
+	}
+
+	if len(elems) == len(ext) {
+		return payload
+	}
+
+	// pad elements to a multiple of 4 bytes
+	for len(elems)%4 != 0 {
+		elems = append(elems, 0)
+	}
+
+	ret := make([]byte, 0, l)
+	ret = append(ret, payload[:n]...)
+
+	if len(elems) == 0 {
+		ret[0] &^= 0x10
+	} else {
+		ret = append(ret, payload[n], payload[n+1])
+		ret = append(ret, byte(len(elems)/4>>8), byte(len(elems)/4))
+		ret = append(ret, elems...)
+	}
+
+	return append(ret, payload[n+4+extLen:]...)
+}
+
+func (f *Filter) filterOneByte(ext []byte) ([]byte, bool) {
+	var ret []byte
+	pos := 0
+
+	for pos < len(ext) {
+		id := ext[pos] >> 4
+
+		// padding
+		if id == 0 {
+			pos++
+			continue
+		}
+
+		// reserved ID, stop parsing
+		if id == 15 {
+			break
+		}
+
+		size := 1 + int(ext[pos]&0x0F) + 1
+		if pos+size > len(ext) {
+			return nil, false
+		}
+
+		if _, ok := f.allowed[id]; ok {
+			ret = append(ret, ext[pos:pos+size]...)
+		}
+		pos += size
+	}
+
+	return ret, true
+}
+
+func (f *Filter) filterTwoByte(ext []byte) ([]byte, bool) {
+	var ret []byte
+	pos := 0
+
+	for pos < len(ext) {
+		id := ext[pos]
+
+		// padding
+		if id == 0 {
+			pos++
+			continue
+		}
+
+		if pos+2 > len(ext) {
+			return nil, false
+		}
+
+		size := 2 + int(ext[pos+1])
+		if pos+size > len(ext) {
+			return nil, false
+		}
+
+		if _, ok := f.allowed[id]; ok {
+			ret = append(ret, ext[pos:pos+size]...)
+		}
+		pos += size
+	}
+
+	return ret, true
+}
@@ -0,0 +1,81 @@
+package rtpextfilter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rtpPacket(ext ...byte) []byte {
+	hdr := []byte{
+		0x80, 0x60, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0x11, 0x22, 0x33, 0x44,
+	}
+	if ext != nil {
+		hdr[0] |= 0x10
+		hdr = append(hdr, ext...)
+	}
+	return append(hdr, 0x01, 0x02, 0x03)
+}
+
+var casesProcess = []struct {
+	name string
+	in   []byte
+	out  []byte
+}{
+	{
+		"no extension",
+		rtpPacket(),
+		rtpPacket(),
+	},
+	{
+		"one-byte all allowed",
+		rtpPacket(0xBE, 0xDE, 0x00, 0x01, 0x10, 0xAA, 0x30, 0xBB),
+		rtpPacket(0xBE, 0xDE, 0x00, 0x01, 0x10, 0xAA, 0x30, 0xBB),
+	},
+	{
+		"one-byte some allowed",
+		rtpPacket(0xBE, 0xDE, 0x00, 0x02, 0x21, 0xAA, 0xBB, 0x10, 0xCC, 0x00, 0x00, 0x00),
+		rtpPacket(0xBE, 0xDE, 0x00, 0x01, 0x10, 0xCC, 0x00, 0x00),
+	},
+	{
+		"one-byte none allowed",
+		rtpPacket(0xBE, 0xDE, 0x00, 0x01, 0x20, 0xAA, 0x00, 0x00),
+		rtpPacket(),
+	},
+	{
+		"two-byte some allowed",
+		rtpPacket(0x10, 0x00, 0x00, 0x02, 0x01, 0x01, 0xAA, 0x05, 0x02, 0xBB, 0xCC, 0x00),
+		rtpPacket(0x10, 0x00, 0x00, 0x01, 0x01, 0x01, 0xAA, 0x00),
+	},
+	{
+		"other profile",
+		rtpPacket(0x12, 0x34, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04),
+		rtpPacket(),
+	},
+	{
+		"malformed",
+		rtpPacket(0xBE, 0xDE, 0x00, 0x01, 0x1F, 0xAA, 0x00, 0x00),
+		rtpPacket(0xBE, 0xDE, 0x00, 0x01, 0x1F, 0xAA, 0x00, 0x00),
+	},
+}
+
+func TestProcess(t *testing.T) {
+	f := New([]int{1, 3})
+
+	for _, ca := range casesProcess {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.out, f.Process(ca.in))
+		})
+	}
+}
+
+func TestAllowsSDPAttribute(t *testing.T) {
+	f := New([]int{1, 3})
+
+	require.Equal(t, true, f.AllowsSDPAttribute("extmap", "1 urn:ietf:params:rtp-hdrext:toffset"))
+	require.Equal(t, true, f.AllowsSDPAttribute("extmap", "3/sendonly http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time"))
+	require.Equal(t, false, f.AllowsSDPAttribute("extmap", "2 urn:ietf:params:rtp-hdrext:sdes:mid"))
+	require.Equal(t, true, f.AllowsSDPAttribute("framerate", "25"))
+}
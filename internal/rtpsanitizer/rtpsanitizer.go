@@ -0,0 +1,192 @@
+// Package rtpsanitizer contains a RTP/RTCP packet validator.
+package rtpsanitizer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/aler9/gortsplib"
+)
+
+const (
+	rtpHeaderSize  = 12
+	rtcpHeaderSize = 4
+)
+
+type track struct {
+	payloadTypes map[uint8]struct{}
+	isH264       bool
+}
+
+// Sanitizer validates RTP and RTCP packets of a stream.
+type Sanitizer struct {
+	tracks []track
+}
+
+// New allocates a Sanitizer.
+func New(tracks gortsplib.Tracks) *Sanitizer {
+	s := &Sanitizer{
+		tracks: make([]track, len(tracks)),
+	}
+
+	for i, t := range tracks {
+		pts := make(map[uint8]struct{})
+		for _, f := range t.Media.MediaName.Formats {
+			v, err := strconv.ParseUint(f, 10, 8)
+			if err == nil && v <= 127 {
+				pts[uint8(v)] = struct{}{}
+			}
+		}
+
+		s.tracks[i] = track{
+			payloadTypes: pts,
+			isH264:       t.IsH264(),
+		}
+	}
+
+	return s
+}
+
+// Check checks whether a packet is well formed.
+func (s *Sanitizer) Check(trackID int, streamType gortsplib.StreamType, payload []byte) error {
+	if trackID < 0 || trackID >= len(s.tracks) {
+		return fmt.Errorf("invalid track ID (%d)", trackID)
+	}
+
+	if streamType == gortsplib.StreamTypeRTP {
+		return s.checkRTP(&s.tracks[trackID], payload)
+	}
+	return checkRTCP(payload)
+}
+
+func (s *Sanitizer) checkRTP(t *track, payload []byte) error {
+	l := len(payload)
+	if l < rtpHeaderSize {
+		return fmt.Errorf("RTP packet is too short (%d bytes)", l)
+	}
+
+	if v := payload[0] >> 6; v != 2 {
+		return fmt.Errorf("invalid RTP version (%d)", v)
+	}
+
+	n := rtpHeaderSize + 4*int(payload[0]&0x0F)
+	if l < n {
+		return fmt.Errorf("RTP packet is too short for its CSRC count")
+	}
+
+	if (payload[0] & 0x10) != 0 {
+		if l < n+4 {
+			return fmt.Errorf("RTP packet is too short for its header extension")
+		}
+		n += 4 + 4*int(binary.BigEndian.Uint16(payload[n+2:]))
+		if l < n {
+			return fmt.Errorf("invalid RTP header extension length")
+		}
+	}
+
+	end := l
+	if (payload[0] & 0x20) != 0 {
+		padLen := int(payload[l-1])
+		if padLen == 0 || n+padLen > l {
+			return fmt.Errorf("invalid RTP padding")
+		}
+		end -= padLen
+	}
+
+	pt := payload[1] & 0x7F
+	if len(t.payloadTypes) != 0 {
+		if _, ok := t.payloadTypes[pt]; !ok {
+			return fmt.Errorf("unexpected RTP payload type (%d)", pt)
+		}
+	}
+
+	if t.isH264 {
+		return checkH264(payload[n:end])
+	}
+
+	return nil
+}
+
+func checkH264(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("H264 payload is empty")
+	}
+
+	if (payload[0] & 0x80) != 0 {
+		return fmt.Errorf("H264 NALU has the forbidden bit set")
+	}
+
+	typ := payload[0] & 0x1F
+
+	switch {
+	case typ >= 1 && typ <= 23:
+		return nil
+
+	case typ == 24: // STAP-A
+		pos := 1
+		count := 0
+		for pos < len(payload) {
+			if pos+2 > len(payload) {
+				return fmt.Errorf("H264 STAP-A is truncated")
+			}
+
+			size := int(binary.BigEndian.Uint16(payload[pos:]))
+			pos += 2
+
+			if size == 0 || pos+size > len(payload) {
+				return fmt.Errorf("invalid H264 STAP-A NALU size (%d)", size)
+			}
+
+			pos += size
+			count++
+		}
+
+		if count == 0 {
+			return fmt.Errorf("H264 STAP-A is empty")
+		}
+		return nil
+
+	case typ == 28: // FU-A
+		if len(payload) < 3 {
+			return fmt.Errorf("H264 FU-A is too short")
+		}
+
+		start := (payload[1] >> 7) != 0
+		end := ((payload[1] >> 6) & 0x01) != 0
+		if start && end {
+			return fmt.Errorf("H264 FU-A has both start and end bits set")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported H264 NALU type (%d)", typ)
+}
+
+func checkRTCP(payload []byte) error {
+	l := len(payload)
+	if l < rtcpHeaderSize {
+		return fmt.Errorf("RTCP packet is too short (%d bytes)", l)
+	}
+
+	// a RTCP packet can contain multiple compound packets
+	pos := 0
+	for pos < l {
+		if pos+rtcpHeaderSize > l {
+			return fmt.Errorf("RTCP packet is truncated")
+		}
+
+		if v := payload[pos] >> 6; v != 2 {
+			return fmt.Errorf("invalid RTCP version (%d)", v)
+		}
+
+		size := (int(binary.BigEndian.Uint16(payload[pos+2:])) + 1) * 4
+		if pos+size > l {
+			return fmt.Errorf("invalid RTCP packet length")
+		}
+
+		pos += size
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package rtpsanitizer
+
+import (
+	"testing"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func rtpPacket(pt byte, payload ...byte) []byte {
+	return append([]byte{
+		0x80, 0x80 | pt, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0x11, 0x22, 0x33, 0x44,
+	}, payload...)
+}
+
+var casesCheck = []struct {
+	name       string
+	streamType gortsplib.StreamType
+	pkt        []byte
+	err        string
+}{
+	{
+		"rtp valid nalu",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(96, 0x65, 0x01, 0x02),
+		"",
+	},
+	{
+		"rtp valid stap-a",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(96, 0x18, 0x00, 0x02, 0x67, 0x01, 0x00, 0x02, 0x68, 0x01),
+		"",
+	},
+	{
+		"rtp valid fu-a",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(96, 0x7c, 0x85, 0x01, 0x02),
+		"",
+	},
+	{
+		"rtp valid padding",
+		gortsplib.StreamTypeRTP,
+		append([]byte{
+			0xa0, 0xe0, 0x00, 0x01,
+			0x00, 0x00, 0x00, 0x01,
+			0x11, 0x22, 0x33, 0x44,
+			0x65, 0x01,
+		}, 0x00, 0x02),
+		"",
+	},
+	{
+		"rtp too short",
+		gortsplib.StreamTypeRTP,
+		[]byte{0x01, 0x02, 0x03, 0x04},
+		"RTP packet is too short (4 bytes)",
+	},
+	{
+		"rtp invalid version",
+		gortsplib.StreamTypeRTP,
+		append([]byte{0x40}, rtpPacket(96, 0x65)[1:]...),
+		"invalid RTP version (1)",
+	},
+	{
+		"rtp invalid csrc count",
+		gortsplib.StreamTypeRTP,
+		append([]byte{0x8f}, rtpPacket(96, 0x65)[1:]...),
+		"RTP packet is too short for its CSRC count",
+	},
+	{
+		"rtp invalid extension",
+		gortsplib.StreamTypeRTP,
+		append([]byte{0x90}, rtpPacket(96, 0x00, 0x00, 0x00, 0x10)[1:]...),
+		"invalid RTP header extension length",
+	},
+	{
+		"rtp invalid padding",
+		gortsplib.StreamTypeRTP,
+		append([]byte{0xa0}, rtpPacket(96, 0x65, 0x20)[1:]...),
+		"invalid RTP padding",
+	},
+	{
+		"rtp unexpected payload type",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(97, 0x65, 0x01),
+		"unexpected RTP payload type (97)",
+	},
+	{
+		"h264 empty",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(96),
+		"H264 payload is empty",
+	},
+	{
+		"h264 forbidden bit",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(96, 0xe5, 0x01),
+		"H264 NALU has the forbidden bit set",
+	},
+	{
+		"h264 invalid stap-a",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(96, 0x18, 0x00, 0x05, 0x67, 0x01),
+		"invalid H264 STAP-A NALU size (5)",
+	},
+	{
+		"h264 invalid fu-a",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(96, 0x7c, 0xc5, 0x01),
+		"H264 FU-A has both start and end bits set",
+	},
+	{
+		"h264 unsupported type",
+		gortsplib.StreamTypeRTP,
+		rtpPacket(96, 0x1e, 0x01),
+		"unsupported H264 NALU type (30)",
+	},
+	{
+		"rtcp valid",
+		gortsplib.StreamTypeRTCP,
+		[]byte{
+			0x81, 0xc9, 0x00, 0x01, 0x11, 0x22, 0x33, 0x44,
+			0x81, 0xca, 0x00, 0x00,
+		},
+		"",
+	},
+	{
+		"rtcp too short",
+		gortsplib.StreamTypeRTCP,
+		[]byte{0x81},
+		"RTCP packet is too short (1 bytes)",
+	},
+	{
+		"rtcp invalid version",
+		gortsplib.StreamTypeRTCP,
+		[]byte{0x01, 0xc9, 0x00, 0x00},
+		"invalid RTCP version (0)",
+	},
+	{
+		"rtcp invalid length",
+		gortsplib.StreamTypeRTCP,
+		[]byte{0x81, 0xc9, 0x00, 0x05, 0x11, 0x22, 0x33, 0x44},
+		"invalid RTCP packet length",
+	},
+}
+
+func TestCheck(t *testing.T) {
+	track, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04})
+	require.NoError(t, err)
+
+	s := New(gortsplib.Tracks{track})
+
+	for _, ca := range casesCheck {
+		t.Run(ca.name, func(t *testing.T) {
+			err := s.Check(0, ca.streamType, ca.pkt)
+			if ca.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, ca.err)
+			}
+		})
+	}
+
+	err = s.Check(1, gortsplib.StreamTypeRTP, rtpPacket(96, 0x65))
+	require.EqualError(t, err, "invalid track ID (1)")
+}
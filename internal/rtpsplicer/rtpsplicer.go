@@ -0,0 +1,130 @@
+// Package rtpsplicer contains a RTP/RTCP packet rewriter that joins the
+// packets of consecutive sources into a single stream.
+package rtpsplicer
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+)
+
+const (
+	rtpHeaderSize = 12
+	rtcpSRSize    = 20
+	rtcpTypeSR    = 200
+)
+
+// Splicer rewrites the SSRC, the sequence numbers and the timestamps of the
+// packets of a track, in order to make the packets of a source continue the
+// ones of the previous source, as if they were produced by the same one.
+type Splicer struct {
+	clockRate int
+
+	mutex       sync.Mutex
+	initialized bool
+	pending     bool
+	ssrc        uint32
+	seqOffset   uint16
+	tsOffset    uint32
+	lastSeq     uint16
+	lastTS      uint32
+	lastTime    time.Time
+}
+
+// New allocates a Splicer.
+func New(clockRate int) *Splicer {
+	return &Splicer{
+		clockRate: clockRate,
+	}
+}
+
+// Splice notifies the splicer that the next packets are produced by
+// another source.
+func (s *Splicer) Splice() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.initialized {
+		s.pending = true
+	}
+}
+
+// Process rewrites a packet. When the packet has to be changed, a copy
+// is returned, otherwise the packet itself. When the packet has to be
+// discarded, nil is returned.
+func (s *Splicer) Process(streamType gortsplib.StreamType, payload []byte) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if streamType == gortsplib.StreamTypeRTP {
+		return s.processRTP(payload)
+	}
+	return s.processRTCP(payload)
+}
+
+func (s *Splicer) processRTP(payload []byte) []byte {
+	if len(payload) < rtpHeaderSize {
+		return payload
+	}
+
+	seq := binary.BigEndian.Uint16(payload[2:4])
+	ts := binary.BigEndian.Uint32(payload[4:8])
+	ssrc := binary.BigEndian.Uint32(payload[8:12])
+
+	now := time.Now()
+
+	switch {
+	case !s.initialized:
+		s.initialized = true
+		s.ssrc = ssrc
+
+	case s.pending:
+		s.pending = false
+
+		// the timestamp advances by the time elapsed since the last packet
+		elapsed := uint32(now.Sub(s.lastTime).Seconds() * float64(s.clockRate))
+		s.seqOffset = s.lastSeq + 1 - seq
+		s.tsOffset = s.lastTS + elapsed - ts
+	}
+
+	s.lastSeq = seq + s.seqOffset
+	s.lastTS = ts + s.tsOffset
+	s.lastTime = now
+
+	if ssrc == s.ssrc && s.seqOffset == 0 && s.tsOffset == 0 {
+		return payload
+	}
+
+	ret := append([]byte(nil), payload...)
+	binary.BigEndian.PutUint16(ret[2:4], s.lastSeq)
+	binary.BigEndian.PutUint32(ret[4:8], s.lastTS)
+	binary.BigEndian.PutUint32(ret[8:12], s.ssrc)
+	return ret
+}
+
+// processRTCP rewrites the sender report at the beginning of a compound
+// packet, that is the one used by readers to synchronize tracks.
+func (s *Splicer) processRTCP(payload []byte) []byte {
+	if !s.initialized ||
+		len(payload) < rtcpSRSize ||
+		payload[1] != rtcpTypeSR {
+		return payload
+	}
+
+	// the timestamp offset of the new source is not known yet
+	if s.pending {
+		return nil
+	}
+
+	ssrc := binary.BigEndian.Uint32(payload[4:8])
+	if ssrc == s.ssrc && s.tsOffset == 0 {
+		return payload
+	}
+
+	ret := append([]byte(nil), payload...)
+	binary.BigEndian.PutUint32(ret[4:8], s.ssrc)
+	binary.BigEndian.PutUint32(ret[16:20], binary.BigEndian.Uint32(payload[16:20])+s.tsOffset)
+	return ret
+}
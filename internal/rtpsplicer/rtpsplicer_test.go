@@ -0,0 +1,83 @@
+package rtpsplicer
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func rtpPacket(seq uint16, ts uint32, ssrc uint32) []byte {
+	byts := []byte{
+		0x80, 0x60, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x01, 0x02,
+	}
+	binary.BigEndian.PutUint16(byts[2:4], seq)
+	binary.BigEndian.PutUint32(byts[4:8], ts)
+	binary.BigEndian.PutUint32(byts[8:12], ssrc)
+	return byts
+}
+
+func senderReport(ssrc uint32, ts uint32) []byte {
+	byts := []byte{
+		0x80, 0xc8, 0x00, 0x06,
+		0x00, 0x00, 0x00, 0x00,
+		0xe4, 0x46, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x64,
+	}
+	binary.BigEndian.PutUint32(byts[4:8], ssrc)
+	binary.BigEndian.PutUint32(byts[16:20], ts)
+	return byts
+}
+
+func TestSplicer(t *testing.T) {
+	s := New(90000)
+
+	// packets of the first source are not changed
+	pkt := rtpPacket(100, 1000, 0x11223344)
+	require.Equal(t, rtpPacket(100, 1000, 0x11223344), s.Process(gortsplib.StreamTypeRTP, pkt))
+	pkt = rtpPacket(101, 4000, 0x11223344)
+	require.Equal(t, rtpPacket(101, 4000, 0x11223344), s.Process(gortsplib.StreamTypeRTP, pkt))
+	require.Equal(t, senderReport(0x11223344, 4000),
+		s.Process(gortsplib.StreamTypeRTCP, senderReport(0x11223344, 4000)))
+
+	s.Splice()
+
+	// sender reports are discarded until the offset of the new source is known
+	require.Nil(t, s.Process(gortsplib.StreamTypeRTCP, senderReport(0x55667788, 50000)))
+
+	time.Sleep(100 * time.Millisecond)
+
+	out := s.Process(gortsplib.StreamTypeRTP, rtpPacket(3000, 50000, 0x55667788))
+	require.Equal(t, uint16(102), binary.BigEndian.Uint16(out[2:4]))
+	ts := binary.BigEndian.Uint32(out[4:8])
+	require.GreaterOrEqual(t, ts, uint32(4000+9000))
+	require.Less(t, ts, uint32(4000+2*9000))
+	require.Equal(t, uint32(0x11223344), binary.BigEndian.Uint32(out[8:12]))
+	require.Equal(t, []byte{0x01, 0x02}, out[12:])
+
+	out = s.Process(gortsplib.StreamTypeRTP, rtpPacket(3001, 53000, 0x55667788))
+	require.Equal(t, uint16(103), binary.BigEndian.Uint16(out[2:4]))
+	require.Equal(t, ts+3000, binary.BigEndian.Uint32(out[4:8]))
+
+	out = s.Process(gortsplib.StreamTypeRTCP, senderReport(0x55667788, 53000))
+	require.Equal(t, uint32(0x11223344), binary.BigEndian.Uint32(out[4:8]))
+	require.Equal(t, ts+3000, binary.BigEndian.Uint32(out[16:20]))
+}
+
+func TestSplicerSeqWrap(t *testing.T) {
+	s := New(48000)
+
+	s.Process(gortsplib.StreamTypeRTP, rtpPacket(65535, 0xFFFFFF00, 0x01))
+	s.Splice()
+
+	out := s.Process(gortsplib.StreamTypeRTP, rtpPacket(10, 0x10, 0x02))
+	require.Equal(t, uint16(0), binary.BigEndian.Uint16(out[2:4]))
+	require.Equal(t, uint32(0x01), binary.BigEndian.Uint32(out[8:12]))
+}
@@ -0,0 +1,51 @@
+// Package sap contains an encoder of Session Announcement Protocol (RFC2974) packets.
+package sap
+
+import (
+	"fmt"
+	"net"
+)
+
+// DefaultAddress is the address to which IPv4 announcements with global scope are sent.
+const DefaultAddress = "224.2.127.254:9875"
+
+// Packet is a SAP packet that contains a SDP session description.
+type Packet struct {
+	// whether the packet deletes the announcement instead of creating it
+	Deletion bool
+
+	// identifies the announcement, together with Origin
+	MessageIDHash uint16
+
+	// IPv4 address of the originating source
+	Origin net.IP
+
+	// session description
+	SDP []byte
+}
+
+// Marshal encodes a Packet.
+func (p Packet) Marshal() ([]byte, error) {
+	origin := p.Origin.To4()
+	if origin == nil {
+		return nil, fmt.Errorf("only IPv4 origins are supported")
+	}
+
+	// version 1, IPv4, no encryption, no compression
+	flags := byte(1 << 5)
+	if p.Deletion {
+		flags |= 1 << 2
+	}
+
+	payloadType := "application/sdp"
+
+	ret := make([]byte, 0, 8+len(payloadType)+1+len(p.SDP))
+	ret = append(ret, flags)
+	ret = append(ret, 0) // authentication length
+	ret = append(ret, byte(p.MessageIDHash>>8), byte(p.MessageIDHash))
+	ret = append(ret, origin...)
+	ret = append(ret, []byte(payloadType)...)
+	ret = append(ret, 0)
+	ret = append(ret, p.SDP...)
+	return ret, nil
+}
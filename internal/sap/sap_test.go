@@ -0,0 +1,49 @@
+package sap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshal(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		pkt  Packet
+		byts []byte
+	}{
+		{
+			"announcement",
+			Packet{
+				MessageIDHash: 0x1234,
+				Origin:        net.ParseIP("192.168.1.2"),
+				SDP:           []byte("v=0\r\n"),
+			},
+			append([]byte{0x20, 0x00, 0x12, 0x34, 192, 168, 1, 2},
+				[]byte("application/sdp\x00v=0\r\n")...),
+		},
+		{
+			"deletion",
+			Packet{
+				Deletion:      true,
+				MessageIDHash: 0x1234,
+				Origin:        net.ParseIP("192.168.1.2"),
+				SDP:           []byte("v=0\r\n"),
+			},
+			append([]byte{0x24, 0x00, 0x12, 0x34, 192, 168, 1, 2},
+				[]byte("application/sdp\x00v=0\r\n")...),
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			byts, err := ca.pkt.Marshal()
+			require.NoError(t, err)
+			require.Equal(t, ca.byts, byts)
+		})
+	}
+}
+
+func TestMarshalIPv6(t *testing.T) {
+	_, err := Packet{Origin: net.ParseIP("::1")}.Marshal()
+	require.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package srtp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CryptoSuite is the only supported crypto suite.
+const CryptoSuite = "AES_CM_128_HMAC_SHA1_80"
+
+// CryptoAttribute is a SDP Security Descriptions attribute (RFC4568),
+// that contains the master key used to encrypt a media stream.
+type CryptoAttribute struct {
+	Tag        int
+	Suite      string
+	MasterKey  []byte
+	MasterSalt []byte
+}
+
+// NewCryptoAttribute allocates a CryptoAttribute with a random master key and salt.
+func NewCryptoAttribute() (*CryptoAttribute, error) {
+	buf := make([]byte, KeyLen+SaltLen)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CryptoAttribute{
+		Tag:        1,
+		Suite:      CryptoSuite,
+		MasterKey:  buf[:KeyLen],
+		MasterSalt: buf[KeyLen:],
+	}, nil
+}
+
+// Unmarshal decodes the value of a crypto attribute, in the format
+// "tag suite inline:key||lifetime|mki".
+func (a *CryptoAttribute) Unmarshal(v string) error {
+	parts := strings.Fields(v)
+	if len(parts) < 3 {
+		return fmt.Errorf("invalid crypto attribute (%v)", v)
+	}
+
+	tag, err := strconv.ParseUint(parts[0], 10, 31)
+	if err != nil {
+		return fmt.Errorf("invalid tag (%v)", parts[0])
+	}
+	a.Tag = int(tag)
+	a.Suite = parts[1]
+
+	if a.Suite != CryptoSuite {
+		return fmt.Errorf("unsupported crypto suite (%v)", a.Suite)
+	}
+
+	// only the first key is used; lifetime and MKI are ignored.
+	params := strings.SplitN(parts[2], ";", 2)[0]
+	if !strings.HasPrefix(params, "inline:") {
+		return fmt.Errorf("unsupported key method (%v)", params)
+	}
+
+	keySalt := strings.SplitN(params[len("inline:"):], "|", 2)[0]
+
+	byts, err := base64.StdEncoding.DecodeString(keySalt)
+	if err != nil {
+		return fmt.Errorf("invalid key: %s", err)
+	}
+
+	if len(byts) != KeyLen+SaltLen {
+		return fmt.Errorf("invalid key length (%d)", len(byts))
+	}
+
+	a.MasterKey = byts[:KeyLen]
+	a.MasterSalt = byts[KeyLen:]
+	return nil
+}
+
+// Marshal encodes the value of a crypto attribute.
+func (a CryptoAttribute) Marshal() string {
+	return strconv.FormatInt(int64(a.Tag), 10) + " " + a.Suite + " inline:" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(nil), a.MasterKey...), a.MasterSalt...))
+}
@@ -0,0 +1,330 @@
+// Package srtp implements the Secure Real-time Transport Protocol (RFC3711)
+// with the AES_CM_128_HMAC_SHA1_80 crypto suite.
+package srtp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+const (
+	// KeyLen is the length of master keys.
+	KeyLen = 16
+
+	// SaltLen is the length of master salts.
+	SaltLen = 14
+
+	authKeyLen    = 20
+	authTagLen    = 10
+	srtcpIndexLen = 4
+)
+
+// key derivation labels.
+const (
+	labelRTPEncryption  = 0x00
+	labelRTPAuth        = 0x01
+	labelRTPSalt        = 0x02
+	labelRTCPEncryption = 0x03
+	labelRTCPAuth       = 0x04
+	labelRTCPSalt       = 0x05
+)
+
+// deriveKey derives a session key from the master key, with a key
+// derivation rate of zero.
+func deriveKey(master cipher.Block, masterSalt []byte, label byte, le int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, masterSalt)
+	iv[7] ^= label
+
+	ret := make([]byte, le)
+	cipher.NewCTR(master, iv).XORKeyStream(ret, ret)
+	return ret
+}
+
+type sessionKeys struct {
+	block   cipher.Block
+	salt    []byte
+	authKey []byte
+}
+
+func newSessionKeys(master cipher.Block, masterSalt []byte,
+	encLabel byte, authLabel byte, saltLabel byte) (*sessionKeys, error) {
+	block, err := aes.NewCipher(deriveKey(master, masterSalt, encLabel, KeyLen))
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionKeys{
+		block:   block,
+		salt:    deriveKey(master, masterSalt, saltLabel, SaltLen),
+		authKey: deriveKey(master, masterSalt, authLabel, authKeyLen),
+	}, nil
+}
+
+// xorKeyStream encrypts or decrypts a payload with AES in counter mode.
+func (k *sessionKeys) xorKeyStream(dst []byte, src []byte, ssrc uint32, index uint64) {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, k.salt)
+
+	var tmp [8]byte
+	binary.BigEndian.PutUint32(tmp[:4], ssrc)
+	for i := 0; i < 4; i++ {
+		iv[4+i] ^= tmp[i]
+	}
+
+	binary.BigEndian.PutUint64(tmp[:], index<<16)
+	for i := 0; i < 8; i++ {
+		iv[8+i] ^= tmp[i]
+	}
+
+	cipher.NewCTR(k.block, iv).XORKeyStream(dst, src)
+}
+
+func (k *sessionKeys) authTag(parts ...[]byte) []byte {
+	h := hmac.New(sha1.New, k.authKey)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)[:authTagLen]
+}
+
+// rtpState is the state of a RTP stream, that is used to compute
+// the packet index from the sequence number.
+type rtpState struct {
+	initialized bool
+	roc         uint32
+	lastSeq     uint16
+}
+
+// estimateROC estimates the rollover counter of a packet (RFC3711, section 3.3.1).
+func (st *rtpState) estimateROC(seq uint16) uint32 {
+	if !st.initialized {
+		return 0
+	}
+
+	if st.lastSeq < 0x8000 {
+		if int(seq)-int(st.lastSeq) > 0x8000 {
+			return st.roc - 1
+		}
+		return st.roc
+	}
+
+	if int(st.lastSeq)-0x8000 > int(seq) {
+		return st.roc + 1
+	}
+	return st.roc
+}
+
+func (st *rtpState) update(seq uint16, roc uint32) {
+	if !st.initialized {
+		st.initialized = true
+		st.roc = roc
+		st.lastSeq = seq
+		return
+	}
+
+	if roc == st.roc+1 || (roc == st.roc && seq > st.lastSeq) {
+		st.roc = roc
+		st.lastSeq = seq
+	}
+}
+
+// rtpHeaderLen returns the length of the header of a RTP packet,
+// including CSRCs and the header extension.
+func rtpHeaderLen(pkt []byte) (int, error) {
+	if len(pkt) < 12 {
+		return 0, fmt.Errorf("packet is too short")
+	}
+
+	if (pkt[0] >> 6) != 2 {
+		return 0, fmt.Errorf("unsupported RTP version")
+	}
+
+	n := 12 + int(pkt[0]&0x0F)*4
+
+	if (pkt[0] & 0x10) != 0 {
+		if len(pkt) < n+4 {
+			return 0, fmt.Errorf("packet is too short")
+		}
+		n += 4 + int(binary.BigEndian.Uint16(pkt[n+2:]))*4
+	}
+
+	if len(pkt) < n {
+		return 0, fmt.Errorf("packet is too short")
+	}
+
+	return n, nil
+}
+
+// Context is a SRTP cryptographic context, that allows to encrypt and
+// decrypt RTP and RTCP packets. It can be used by multiple goroutines.
+type Context struct {
+	rtp  *sessionKeys
+	rtcp *sessionKeys
+
+	mutex      sync.Mutex
+	rtpStates  map[uint32]*rtpState
+	srtcpIndex uint32
+}
+
+// NewContext allocates a Context.
+func NewContext(masterKey []byte, masterSalt []byte) (*Context, error) {
+	if len(masterKey) != KeyLen {
+		return nil, fmt.Errorf("invalid master key length (%d)", len(masterKey))
+	}
+
+	if len(masterSalt) != SaltLen {
+		return nil, fmt.Errorf("invalid master salt length (%d)", len(masterSalt))
+	}
+
+	master, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rtp, err := newSessionKeys(master, masterSalt, labelRTPEncryption, labelRTPAuth, labelRTPSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	rtcp, err := newSessionKeys(master, masterSalt, labelRTCPEncryption, labelRTCPAuth, labelRTCPSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Context{
+		rtp:       rtp,
+		rtcp:      rtcp,
+		rtpStates: make(map[uint32]*rtpState),
+	}, nil
+}
+
+func (c *Context) rtpState(ssrc uint32) *rtpState {
+	st, ok := c.rtpStates[ssrc]
+	if !ok {
+		st = &rtpState{}
+		c.rtpStates[ssrc] = st
+	}
+	return st
+}
+
+// EncryptRTP encrypts a RTP packet. The returned packet is a new buffer.
+func (c *Context) EncryptRTP(pkt []byte) ([]byte, error) {
+	n, err := rtpHeaderLen(pkt)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := binary.BigEndian.Uint16(pkt[2:])
+	ssrc := binary.BigEndian.Uint32(pkt[8:])
+
+	c.mutex.Lock()
+	st := c.rtpState(ssrc)
+	roc := st.estimateROC(seq)
+	st.update(seq, roc)
+	c.mutex.Unlock()
+
+	ret := make([]byte, len(pkt)+authTagLen)
+	copy(ret, pkt[:n])
+	c.rtp.xorKeyStream(ret[n:len(pkt)], pkt[n:], ssrc, uint64(roc)<<16|uint64(seq))
+
+	var rocBuf [4]byte
+	binary.BigEndian.PutUint32(rocBuf[:], roc)
+	copy(ret[len(pkt):], c.rtp.authTag(ret[:len(pkt)], rocBuf[:]))
+
+	return ret, nil
+}
+
+// DecryptRTP authenticates and decrypts a SRTP packet.
+// The returned packet is a new buffer.
+func (c *Context) DecryptRTP(pkt []byte) ([]byte, error) {
+	if len(pkt) < authTagLen {
+		return nil, fmt.Errorf("packet is too short")
+	}
+	body := pkt[:len(pkt)-authTagLen]
+
+	n, err := rtpHeaderLen(body)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := binary.BigEndian.Uint16(body[2:])
+	ssrc := binary.BigEndian.Uint32(body[8:])
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	st := c.rtpState(ssrc)
+	roc := st.estimateROC(seq)
+
+	var rocBuf [4]byte
+	binary.BigEndian.PutUint32(rocBuf[:], roc)
+	if subtle.ConstantTimeCompare(c.rtp.authTag(body, rocBuf[:]), pkt[len(body):]) != 1 {
+		return nil, fmt.Errorf("authentication failed")
+	}
+
+	st.update(seq, roc)
+
+	ret := make([]byte, len(body))
+	copy(ret, body[:n])
+	c.rtp.xorKeyStream(ret[n:], body[n:], ssrc, uint64(roc)<<16|uint64(seq))
+
+	return ret, nil
+}
+
+// EncryptRTCP encrypts a RTCP packet. The returned packet is a new buffer.
+func (c *Context) EncryptRTCP(pkt []byte) ([]byte, error) {
+	if len(pkt) < 8 {
+		return nil, fmt.Errorf("packet is too short")
+	}
+
+	ssrc := binary.BigEndian.Uint32(pkt[4:])
+
+	c.mutex.Lock()
+	index := c.srtcpIndex
+	c.srtcpIndex = (c.srtcpIndex + 1) & 0x7FFFFFFF
+	c.mutex.Unlock()
+
+	ret := make([]byte, len(pkt)+srtcpIndexLen+authTagLen)
+	copy(ret, pkt[:8])
+	c.rtcp.xorKeyStream(ret[8:len(pkt)], pkt[8:], ssrc, uint64(index))
+
+	// the E flag is set, since packets are encrypted
+	binary.BigEndian.PutUint32(ret[len(pkt):], 0x80000000|index)
+
+	copy(ret[len(pkt)+srtcpIndexLen:], c.rtcp.authTag(ret[:len(pkt)+srtcpIndexLen]))
+
+	return ret, nil
+}
+
+// DecryptRTCP authenticates and decrypts a SRTCP packet.
+// The returned packet is a new buffer.
+func (c *Context) DecryptRTCP(pkt []byte) ([]byte, error) {
+	if len(pkt) < 8+srtcpIndexLen+authTagLen {
+		return nil, fmt.Errorf("packet is too short")
+	}
+
+	authenticated := pkt[:len(pkt)-authTagLen]
+	if subtle.ConstantTimeCompare(c.rtcp.authTag(authenticated), pkt[len(authenticated):]) != 1 {
+		return nil, fmt.Errorf("authentication failed")
+	}
+
+	body := authenticated[:len(authenticated)-srtcpIndexLen]
+	v := binary.BigEndian.Uint32(authenticated[len(body):])
+
+	ret := make([]byte, len(body))
+	copy(ret, body)
+
+	if (v & 0x80000000) != 0 {
+		ssrc := binary.BigEndian.Uint32(body[4:])
+		c.rtcp.xorKeyStream(ret[8:], body[8:], ssrc, uint64(v&0x7FFFFFFF))
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,136 @@
+package srtp
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecodeHex(s string) []byte {
+	byts, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return byts
+}
+
+// RFC3711, appendix B.2
+func TestKeyStream(t *testing.T) {
+	block, err := aes.NewCipher(mustDecodeHex("2B7E151628AED2A6ABF7158809CF4F3C"))
+	require.NoError(t, err)
+
+	k := &sessionKeys{
+		block: block,
+		salt:  mustDecodeHex("F0F1F2F3F4F5F6F7F8F9FAFBFCFD"),
+	}
+
+	out := make([]byte, 48)
+	k.xorKeyStream(out, out, 0, 0)
+	require.Equal(t, mustDecodeHex("E03EAD0935C95E80E166B16DD92B4EB4"+
+		"D23513162B02D0F72A43A2FE4A5F97AB"+
+		"41E95B3BB0A2E8DD477901E4FCA894C0"), out)
+}
+
+// RFC3711, appendix B.3
+func TestKeyDerivation(t *testing.T) {
+	master, err := aes.NewCipher(mustDecodeHex("E1F97A0D3E018BE0D64FA32C06DE4139"))
+	require.NoError(t, err)
+	masterSalt := mustDecodeHex("0EC675AD498AFEEBB6960B3AABE6")
+
+	require.Equal(t, mustDecodeHex("C61E7A93744F39EE10734AFE3FF7A087"),
+		deriveKey(master, masterSalt, labelRTPEncryption, KeyLen))
+	require.Equal(t, mustDecodeHex("30CBBC08863D8C85D49DB34A9AE1"),
+		deriveKey(master, masterSalt, labelRTPSalt, SaltLen))
+	require.Equal(t, mustDecodeHex("CEBE321F6FF7716B6FD4AB49AF256A156D38BAA4"),
+		deriveKey(master, masterSalt, labelRTPAuth, authKeyLen))
+}
+
+func TestRTP(t *testing.T) {
+	ca, err := NewCryptoAttribute()
+	require.NoError(t, err)
+
+	enc, err := NewContext(ca.MasterKey, ca.MasterSalt)
+	require.NoError(t, err)
+
+	dec, err := NewContext(ca.MasterKey, ca.MasterSalt)
+	require.NoError(t, err)
+
+	// sequence numbers wrap around
+	for _, seq := range []uint16{0xFFFE, 0xFFFF, 0x0000, 0x0001} {
+		pkt := []byte{
+			0x80, 0x60, byte(seq >> 8), byte(seq),
+			0x00, 0x00, 0x00, 0x01,
+			0x11, 0x22, 0x33, 0x44,
+			0x01, 0x02, 0x03, 0x04, 0x05,
+		}
+
+		encrypted, err := enc.EncryptRTP(pkt)
+		require.NoError(t, err)
+		require.Equal(t, len(pkt)+authTagLen, len(encrypted))
+		require.Equal(t, pkt[:12], encrypted[:12])
+		require.NotEqual(t, pkt[12:], encrypted[12:len(pkt)])
+
+		decrypted, err := dec.DecryptRTP(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, pkt, decrypted)
+	}
+
+	require.Equal(t, uint32(1), enc.rtpStates[0x11223344].roc)
+	require.Equal(t, uint32(1), dec.rtpStates[0x11223344].roc)
+
+	encrypted, err := enc.EncryptRTP([]byte{
+		0x80, 0x60, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x01,
+		0x11, 0x22, 0x33, 0x44,
+		0x01, 0x02, 0x03, 0x04,
+	})
+	require.NoError(t, err)
+	encrypted[13] ^= 0xFF
+	_, err = dec.DecryptRTP(encrypted)
+	require.EqualError(t, err, "authentication failed")
+}
+
+func TestRTCP(t *testing.T) {
+	ca, err := NewCryptoAttribute()
+	require.NoError(t, err)
+
+	enc, err := NewContext(ca.MasterKey, ca.MasterSalt)
+	require.NoError(t, err)
+
+	dec, err := NewContext(ca.MasterKey, ca.MasterSalt)
+	require.NoError(t, err)
+
+	pkt := []byte{
+		0x81, 0xc8, 0x00, 0x06, 0x11, 0x22, 0x33, 0x44,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x02,
+	}
+
+	for i := 0; i < 2; i++ {
+		encrypted, err := enc.EncryptRTCP(pkt)
+		require.NoError(t, err)
+		require.Equal(t, len(pkt)+srtcpIndexLen+authTagLen, len(encrypted))
+		require.Equal(t, []byte{0x80, 0x00, 0x00, byte(i)}, encrypted[len(pkt):len(pkt)+srtcpIndexLen])
+
+		decrypted, err := dec.DecryptRTCP(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, pkt, decrypted)
+	}
+}
+
+func TestCryptoAttribute(t *testing.T) {
+	var ca CryptoAttribute
+	err := ca.Unmarshal("1 AES_CM_128_HMAC_SHA1_80 inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz|2^20|1:32")
+	require.NoError(t, err)
+	require.Equal(t, 1, ca.Tag)
+	require.Equal(t, []byte("YS___semctl () {"), ca.MasterKey)
+	require.Equal(t, KeyLen, len(ca.MasterKey))
+	require.Equal(t, SaltLen, len(ca.MasterSalt))
+	require.Equal(t, "1 AES_CM_128_HMAC_SHA1_80 inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz", ca.Marshal())
+
+	err = ca.Unmarshal("1 AES_CM_128_HMAC_SHA1_32 inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz")
+	require.EqualError(t, err, "unsupported crypto suite (AES_CM_128_HMAC_SHA1_32)")
+}
@@ -0,0 +1,64 @@
+package testpattern
+
+type bitWriter struct {
+	buf   []byte
+	cur   byte
+	nbits uint
+}
+
+func (w *bitWriter) writeBit(v uint) {
+	w.cur = (w.cur << 1) | byte(v&0x01)
+	w.nbits++
+	if w.nbits == 8 {
+		w.buf = append(w.buf, w.cur)
+		w.cur = 0
+		w.nbits = 0
+	}
+}
+
+func (w *bitWriter) writeBits(v uint, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(v >> (i - 1))
+	}
+}
+
+// writeUE writes an unsigned Exp-Golomb code.
+func (w *bitWriter) writeUE(v uint) {
+	v++
+	n := uint(0)
+	for t := v; t > 1; t >>= 1 {
+		n++
+	}
+	w.writeBits(0, n)
+	w.writeBits(v, n+1)
+}
+
+// writeSE writes a signed Exp-Golomb code.
+func (w *bitWriter) writeSE(v int) {
+	if v <= 0 {
+		w.writeUE(uint(-2 * v))
+	} else {
+		w.writeUE(uint(2*v - 1))
+	}
+}
+
+func (w *bitWriter) isAligned() bool {
+	return w.nbits == 0
+}
+
+// align writes zero bits until the byte boundary.
+func (w *bitWriter) align() {
+	for !w.isAligned() {
+		w.writeBit(0)
+	}
+}
+
+// writeTrailingBits writes the RBSP stop bit and aligns.
+func (w *bitWriter) writeTrailingBits() {
+	w.writeBit(1)
+	w.align()
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
@@ -0,0 +1,319 @@
+// Package testpattern contains a generator of synthetic H264 video and G711 audio.
+package testpattern
+
+import (
+	"math"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+const (
+	mbSize = 16
+
+	// 8 bits are used to encode frame_num
+	log2MaxFrameNum = 8
+
+	boxColorY = 235
+)
+
+// SMPTE-like color bars at 75%, in the Y, Cb, Cr space
+var barColors = [][3]byte{
+	{180, 128, 128}, // white
+	{162, 44, 142},  // yellow
+	{131, 156, 44},  // cyan
+	{112, 72, 58},   // green
+	{84, 184, 198},  // magenta
+	{65, 100, 212},  // red
+	{35, 212, 114},  // blue
+}
+
+var backgroundColor = [3]byte{16, 128, 128}
+
+// Generator generates H264 frames that contain color bars and a moving box.
+//
+// Frames are made of I_PCM macroblocks, that store raw samples and therefore
+// don't require an encoder. Frames between IDR frames contain only the
+// macroblocks that changed. When a bitrate is set, frames are padded with
+// filler data in order to reach it.
+type Generator struct {
+	width   int
+	height  int
+	fps     int
+	bitrate int
+
+	mbWidth  int
+	mbHeight int
+	boxRow   int
+	count    int
+	idrPicID uint
+	budget   int
+}
+
+// New allocates a Generator. Width and height must be multiples of 16.
+func New(width int, height int, fps int, bitrate int) *Generator {
+	mbHeight := height / mbSize
+
+	return &Generator{
+		width:    width,
+		height:   height,
+		fps:      fps,
+		bitrate:  bitrate,
+		mbWidth:  width / mbSize,
+		mbHeight: mbHeight,
+		boxRow:   mbHeight * 7 / 8,
+	}
+}
+
+func (g *Generator) levelIDC() uint {
+	switch mbs := g.mbWidth * g.mbHeight; {
+	case mbs <= 1620:
+		return 30
+	case mbs <= 8192:
+		return 40
+	}
+	return 51
+}
+
+// SPS returns the sequence parameter set.
+func (g *Generator) SPS() []byte {
+	w := &bitWriter{}
+	w.writeBits(0x67, 8)         // nal_ref_idc 3, nal_unit_type 7
+	w.writeBits(66, 8)           // profile_idc (baseline)
+	w.writeBits(0xC0, 8)         // constraint_set0_flag, constraint_set1_flag
+	w.writeBits(g.levelIDC(), 8) // level_idc
+	w.writeUE(0)                 // seq_parameter_set_id
+	w.writeUE(log2MaxFrameNum - 4)
+	w.writeUE(2)  // pic_order_cnt_type
+	w.writeUE(1)  // max_num_ref_frames
+	w.writeBit(0) // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(uint(g.mbWidth - 1))
+	w.writeUE(uint(g.mbHeight - 1))
+	w.writeBit(1) // frame_mbs_only_flag
+	w.writeBit(1) // direct_8x8_inference_flag
+	w.writeBit(0) // frame_cropping_flag
+	w.writeBit(0) // vui_parameters_present_flag
+	w.writeTrailingBits()
+	return h264.AntiCompetitionAdd(w.bytes())
+}
+
+// PPS returns the picture parameter set.
+func (g *Generator) PPS() []byte {
+	w := &bitWriter{}
+	w.writeBits(0x68, 8) // nal_ref_idc 3, nal_unit_type 8
+	w.writeUE(0)         // pic_parameter_set_id
+	w.writeUE(0)         // seq_parameter_set_id
+	w.writeBit(0)        // entropy_coding_mode_flag (CAVLC)
+	w.writeBit(0)        // bottom_field_pic_order_in_frame_present_flag
+	w.writeUE(0)         // num_slice_groups_minus1
+	w.writeUE(0)         // num_ref_idx_l0_default_active_minus1
+	w.writeUE(0)         // num_ref_idx_l1_default_active_minus1
+	w.writeBit(0)        // weighted_pred_flag
+	w.writeBits(0, 2)    // weighted_bipred_idc
+	w.writeSE(0)         // pic_init_qp_minus26
+	w.writeSE(0)         // pic_init_qs_minus26
+	w.writeSE(0)         // chroma_qp_index_offset
+	w.writeBit(1)        // deblocking_filter_control_present_flag
+	w.writeBit(0)        // constrained_intra_pred_flag
+	w.writeBit(0)        // redundant_pic_cnt_present_flag
+	w.writeTrailingBits()
+	return h264.AntiCompetitionAdd(w.bytes())
+}
+
+func (g *Generator) boxColumn(count int) int {
+	return count % g.mbWidth
+}
+
+func (g *Generator) pixel(x int, y int, count int) [3]byte {
+	if y < g.height*3/4 {
+		return barColors[x*len(barColors)/g.width]
+	}
+
+	if y/mbSize == g.boxRow && x/mbSize == g.boxColumn(count) {
+		return [3]byte{boxColorY, 128, 128}
+	}
+
+	return backgroundColor
+}
+
+func (g *Generator) writePCMMacroblock(w *bitWriter, mbX int, mbY int, count int) {
+	for !w.isAligned() {
+		w.writeBit(0) // pcm_alignment_zero_bit
+	}
+
+	for y := 0; y < mbSize; y++ {
+		for x := 0; x < mbSize; x++ {
+			w.writeBits(uint(g.pixel(mbX*mbSize+x, mbY*mbSize+y, count)[0]), 8)
+		}
+	}
+
+	for c := 1; c <= 2; c++ {
+		for y := 0; y < mbSize/2; y++ {
+			for x := 0; x < mbSize/2; x++ {
+				w.writeBits(uint(g.pixel(mbX*mbSize+x*2, mbY*mbSize+y*2, count)[c]), 8)
+			}
+		}
+	}
+}
+
+func (g *Generator) idrFrame() []byte {
+	w := &bitWriter{}
+	w.writeBits(0x65, 8) // nal_ref_idc 3, nal_unit_type 5
+	w.writeUE(0)         // first_mb_in_slice
+	w.writeUE(7)         // slice_type (I)
+	w.writeUE(0)         // pic_parameter_set_id
+	w.writeBits(0, log2MaxFrameNum)
+	w.writeUE(g.idrPicID)
+	w.writeBit(0) // no_output_of_prior_pics_flag
+	w.writeBit(0) // long_term_reference_flag
+	w.writeSE(0)  // slice_qp_delta
+	w.writeUE(1)  // disable_deblocking_filter_idc
+
+	for mbY := 0; mbY < g.mbHeight; mbY++ {
+		for mbX := 0; mbX < g.mbWidth; mbX++ {
+			w.writeUE(25) // mb_type (I_PCM)
+			g.writePCMMacroblock(w, mbX, mbY, g.count)
+		}
+	}
+
+	w.writeTrailingBits()
+	return h264.AntiCompetitionAdd(w.bytes())
+}
+
+func (g *Generator) nonIDRFrame(frameNum int) []byte {
+	w := &bitWriter{}
+	w.writeBits(0x41, 8) // nal_ref_idc 2, nal_unit_type 1
+	w.writeUE(0)         // first_mb_in_slice
+	w.writeUE(5)         // slice_type (P)
+	w.writeUE(0)         // pic_parameter_set_id
+	w.writeBits(uint(frameNum%(1<<log2MaxFrameNum)), log2MaxFrameNum)
+	w.writeBit(0) // num_ref_idx_active_override_flag
+	w.writeBit(0) // ref_pic_list_modification_flag_l0
+	w.writeBit(0) // adaptive_ref_pic_marking_mode_flag
+	w.writeSE(0)  // slice_qp_delta
+	w.writeUE(1)  // disable_deblocking_filter_idc
+
+	// encode the previous and current box positions and skip everything else
+	prev := g.boxRow*g.mbWidth + g.boxColumn(g.count-1)
+	cur := g.boxRow*g.mbWidth + g.boxColumn(g.count)
+	var changed []int
+	switch {
+	case cur == prev:
+		changed = []int{cur}
+	case cur < prev:
+		changed = []int{cur, prev}
+	default:
+		changed = []int{prev, cur}
+	}
+
+	pos := 0
+	for _, mb := range changed {
+		w.writeUE(uint(mb - pos)) // mb_skip_run
+		w.writeUE(5 + 25)         // mb_type (I_PCM in a P slice)
+		g.writePCMMacroblock(w, mb%g.mbWidth, mb/g.mbWidth, g.count)
+		pos = mb + 1
+	}
+
+	if rem := g.mbWidth*g.mbHeight - pos; rem > 0 {
+		w.writeUE(uint(rem))
+	}
+
+	w.writeTrailingBits()
+	return h264.AntiCompetitionAdd(w.bytes())
+}
+
+func fillerData(size int) []byte {
+	ret := make([]byte, size)
+	ret[0] = 0x0C // nal_unit_type 12
+	for i := 1; i < size-1; i++ {
+		ret[i] = 0xFF
+	}
+	ret[size-1] = 0x80
+	return ret
+}
+
+// Next returns the NALUs of the next frame, and whether the frame is an IDR.
+func (g *Generator) Next() ([][]byte, bool) {
+	gopPos := g.count % g.fps
+
+	var nalus [][]byte
+	idr := gopPos == 0
+	if idr {
+		nalus = [][]byte{g.idrFrame()}
+		g.idrPicID = (g.idrPicID + 1) % 2
+	} else {
+		nalus = [][]byte{g.nonIDRFrame(gopPos)}
+	}
+
+	if g.bitrate > 0 {
+		g.budget += g.bitrate / 8 / g.fps
+		for _, n := range nalus {
+			g.budget -= len(n)
+		}
+
+		// a filler NALU is at least 2 bytes long
+		if g.budget >= 2 {
+			nalus = append(nalus, fillerData(g.budget))
+			g.budget = 0
+		}
+	}
+
+	g.count++
+	return nalus, idr
+}
+
+// ToneGenerator generates a sine tone encoded with G711 µ-law (PCMU).
+type ToneGenerator struct {
+	frequency  float64
+	sampleRate int
+	phase      float64
+}
+
+// NewToneGenerator allocates a ToneGenerator.
+func NewToneGenerator(frequency float64, sampleRate int) *ToneGenerator {
+	return &ToneGenerator{
+		frequency:  frequency,
+		sampleRate: sampleRate,
+	}
+}
+
+// Next returns the next samples.
+func (t *ToneGenerator) Next(count int) []byte {
+	ret := make([]byte, count)
+	step := 2 * math.Pi * t.frequency / float64(t.sampleRate)
+
+	for i := range ret {
+		ret[i] = encodeMulaw(int16(math.Sin(t.phase) * 8000))
+		t.phase += step
+		if t.phase >= 2*math.Pi {
+			t.phase -= 2 * math.Pi
+		}
+	}
+
+	return ret
+}
+
+func encodeMulaw(sample int16) byte {
+	const (
+		bias = 0x84
+		clip = 32635
+	)
+
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+
+	exponent := 7
+	for mask := 0x4000; (s&mask) == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+
+	mantissa := (s >> (exponent + 3)) & 0x0F
+	return ^byte(sign | (exponent << 4) | mantissa)
+}
@@ -0,0 +1,154 @@
+package testpattern
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/h264"
+)
+
+type bitReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *bitReader) readBits(n int) uint {
+	v := uint(0)
+	for i := 0; i < n; i++ {
+		v = (v << 1) | uint((r.buf[r.pos/8]>>(7-uint(r.pos%8)))&0x01)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) readUE() uint {
+	n := 0
+	for r.readBits(1) == 0 {
+		n++
+	}
+	return (1 << uint(n)) - 1 + r.readBits(n)
+}
+
+func (r *bitReader) readSE() int {
+	v := r.readUE()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func TestSPS(t *testing.T) {
+	g := New(640, 480, 25, 0)
+
+	r := &bitReader{buf: h264.AntiCompetitionRemove(g.SPS())}
+	require.Equal(t, uint(0x67), r.readBits(8))
+	require.Equal(t, uint(66), r.readBits(8))
+	r.readBits(8)
+	require.Equal(t, uint(30), r.readBits(8))
+	require.Equal(t, uint(0), r.readUE())
+	require.Equal(t, uint(4), r.readUE())
+	require.Equal(t, uint(2), r.readUE())
+	require.Equal(t, uint(1), r.readUE())
+	require.Equal(t, uint(0), r.readBits(1))
+	require.Equal(t, uint(39), r.readUE())
+	require.Equal(t, uint(29), r.readUE())
+}
+
+func TestFrames(t *testing.T) {
+	g := New(64, 64, 5, 0)
+	mbCount := 4 * 4
+
+	for i := 0; i < 10; i++ {
+		nalus, idr := g.Next()
+		require.Equal(t, 1, len(nalus))
+		require.Equal(t, i%5 == 0, idr)
+
+		r := &bitReader{buf: h264.AntiCompetitionRemove(nalus[0])}
+
+		if idr {
+			require.Equal(t, uint(0x65), r.readBits(8))
+			require.Equal(t, uint(0), r.readUE())
+			require.Equal(t, uint(7), r.readUE())
+			require.Equal(t, uint(0), r.readUE())
+			require.Equal(t, uint(0), r.readBits(log2MaxFrameNum))
+			require.Equal(t, uint((i/5)%2), r.readUE())
+			r.readBits(2)
+			require.Equal(t, 0, r.readSE())
+			require.Equal(t, uint(1), r.readUE())
+
+			for mb := 0; mb < mbCount; mb++ {
+				require.Equal(t, uint(25), r.readUE())
+				for r.pos%8 != 0 {
+					require.Equal(t, uint(0), r.readBits(1))
+				}
+				r.pos += 384 * 8
+			}
+		} else {
+			require.Equal(t, uint(0x41), r.readBits(8))
+			require.Equal(t, uint(0), r.readUE())
+			require.Equal(t, uint(5), r.readUE())
+			require.Equal(t, uint(0), r.readUE())
+			require.Equal(t, uint(i%5), r.readBits(log2MaxFrameNum))
+			r.readBits(3)
+			require.Equal(t, 0, r.readSE())
+			require.Equal(t, uint(1), r.readUE())
+
+			mb := 0
+			coded := 0
+			for {
+				mb += int(r.readUE())
+				if mb == mbCount {
+					break
+				}
+
+				require.Equal(t, uint(30), r.readUE())
+				for r.pos%8 != 0 {
+					require.Equal(t, uint(0), r.readBits(1))
+				}
+				r.pos += 384 * 8
+				mb++
+				coded++
+
+				if mb == mbCount {
+					break
+				}
+			}
+			require.Equal(t, 2, coded)
+		}
+
+		// RBSP trailing bits
+		require.Equal(t, uint(1), r.readBits(1))
+		require.Equal(t, len(r.buf)*8, (r.pos+7)/8*8)
+	}
+}
+
+func TestBitrate(t *testing.T) {
+	g := New(64, 64, 10, 400000)
+
+	size := 0
+	for i := 0; i < 20; i++ {
+		nalus, _ := g.Next()
+		for _, n := range nalus {
+			size += len(n)
+		}
+	}
+
+	// 2 seconds at 400 kbit/s
+	require.Equal(t, 100000, size)
+}
+
+func TestMulaw(t *testing.T) {
+	require.Equal(t, byte(0xFF), encodeMulaw(0))
+	require.Equal(t, byte(0x80), encodeMulaw(32767))
+	require.Equal(t, byte(0x00), encodeMulaw(-32768))
+}
+
+func TestTone(t *testing.T) {
+	tg := NewToneGenerator(1000, 8000)
+	samples := tg.Next(16)
+	require.Equal(t, 16, len(samples))
+
+	// a 1kHz tone sampled at 8kHz has a period of 8 samples
+	require.Equal(t, samples[:8], samples[8:])
+}
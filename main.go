@@ -4,9 +4,21 @@ import (
 	"os"
 
 	"github.com/aler9/rtsp-simple-server/internal/core"
+	"github.com/aler9/rtsp-simple-server/internal/ctl"
+	"github.com/aler9/rtsp-simple-server/internal/hashcmd"
 )
 
 func main() {
+	// "ctl" is a client of the API of a running server
+	if len(os.Args) >= 2 && os.Args[1] == "ctl" {
+		os.Exit(ctl.Run(os.Args[2:]))
+	}
+
+	// "hash" hashes credentials to be stored in the configuration
+	if len(os.Args) >= 2 && os.Args[1] == "hash" {
+		os.Exit(hashcmd.Run(os.Args[2:]))
+	}
+
 	s, ok := core.New(os.Args[1:])
 	if !ok {
 		os.Exit(1)